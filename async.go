@@ -0,0 +1,275 @@
+package vel
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// OperationsPath is the path of the status endpoint of the async operations, see RegisterOperationsEndpoint
+	OperationsPath = "/operations/"
+
+	OperationNotFoundCode = "OPERATION_NOT_FOUND"
+
+	defaultOperationTTL = time.Hour
+)
+
+type OperationStatus string
+
+const (
+	OperationPending   OperationStatus = "pending"
+	OperationRunning   OperationStatus = "running"
+	OperationSucceeded OperationStatus = "succeeded"
+	OperationFailed    OperationStatus = "failed"
+)
+
+func (OperationStatus) EnumValues() []string {
+	return []string{string(OperationPending), string(OperationRunning), string(OperationSucceeded), string(OperationFailed)}
+}
+
+// Done reports whether the operation succeeded or failed
+func (s OperationStatus) Done() bool {
+	return s == OperationSucceeded || s == OperationFailed
+}
+
+// Operation is the state of an async operation, the body of its 202 Accepted response and of the status endpoint
+type Operation struct {
+	ID string `json:"id"`
+	// Name is the operation id of the route started the operation
+	Name   string          `json:"name"`
+	Status OperationStatus `json:"status"`
+	// Result is the output of the succeeded operation
+	Result json.RawMessage `json:"result,omitempty"`
+	// Error is the error of the failed operation encoded as the router encodes the errors, see ErrorEncoder
+	Error     json.RawMessage `json:"error,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+// JobStore keeps the state of the async operations, e.g. in a database to share it between instances
+type JobStore interface {
+	// Save creates or updates the operation
+	Save(ctx context.Context, op Operation) error
+	// Get returns the operation, nil if it's unknown
+	Get(ctx context.Context, id string) (*Operation, error)
+}
+
+// asyncJobs tracks the operations running in the background, Shutdown waits for them
+type asyncJobs struct {
+	store JobStore
+	// path is the path of the status endpoint
+	path string
+	wg   sync.WaitGroup
+}
+
+// wait waits for the running operations until the context is done
+func (j *asyncJobs) wait(ctx context.Context) error {
+	if j == nil {
+		return nil
+	}
+	finished := make(chan struct{})
+	go func() {
+		j.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return errors.New("async operations didn't finish before the shutdown timeout")
+	}
+}
+
+// RegisterOperationsEndpoint serves GET /operations/{id} answering the state of the async operations kept by the store,
+// see RegisterAsync. Retry-After tells the clients when to poll again an operation that isn't done.
+// The endpoint is not a part of the router meta, the clients generate its calls for the async routes.
+func (r *Router) RegisterOperationsEndpoint(store JobStore, middlewares ...Middleware) {
+	r.shared.jobs = &asyncJobs{store: store, path: r.prefix + OperationsPath}
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		op, err := store.Get(req.Context(), req.PathValue("id"))
+		if err != nil {
+			writeError(w, req, http.StatusInternalServerError, &Error{Err: err})
+			return
+		}
+		if op == nil {
+			writeError(w, req, http.StatusNotFound, &Error{Code: OperationNotFoundCode, Message: "no operation " + req.PathValue("id")})
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		if !op.Status.Done() {
+			w.Header().Set("Retry-After", "1")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(op); err != nil {
+			writeError(w, req, http.StatusInternalServerError, &Error{Err: err})
+		}
+	})
+	for i := range middlewares {
+		handler = middlewares[i](handler)
+	}
+	// the meta isn't a part of the router meta, it's seen by the route-aware middlewares only
+	path := r.prefix + OperationsPath + "{id}"
+	meta := &HandlerMeta{Input: struct{}{}, Output: Operation{}, OperationID: "operations", Method: http.MethodGet, Path: path}
+	r.handle(http.MethodGet+" "+path, withRoute(handler, meta, r.shared), nil, callSite())
+}
+
+// RegisterAsync registers a POST route of a long-running operation: the request is decoded and validated as usual,
+// then the route answers 202 Accepted with the pending Operation and its status endpoint in the Location header,
+// while the handler runs in the background, the operation keeps its output or error once it's done.
+// The handler context keeps the values of the request but it's never canceled, and the response is written already.
+// The router must register the status endpoint first, see RegisterOperationsEndpoint,
+// the generated clients poll it until the operation is done.
+func RegisterAsync[I, O any](r *Router, operationID string, handler Handler[I, O], middlewares ...Middleware) *HandlerMeta {
+	jobs := r.shared.jobs
+	if jobs == nil {
+		panic(fmt.Sprintf("vel: async route %s registered without the operations endpoint, see RegisterOperationsEndpoint", operationID))
+	}
+	var i I
+	var o O
+
+	start := NewHandler(func(ctx context.Context, in I) (Operation, *Error) {
+		req := RequestFromContext(ctx)
+		now := time.Now()
+		op := Operation{ID: newOperationID(), Name: operationID, Status: OperationPending, CreatedAt: now, UpdatedAt: now}
+		if err := jobs.store.Save(ctx, op); err != nil {
+			return op, &Error{Err: err}
+		}
+		WriterFromContext(ctx).Header().Set("Location", jobs.path+op.ID)
+
+		jobs.wg.Add(1)
+		// the response is written once the handler returns, the operation mustn't write it
+		ctx = writerKey.Set(context.WithoutCancel(ctx), nil)
+		go func() {
+			defer jobs.wg.Done()
+			runOperation(ctx, req, jobs.store, op, handler, in)
+		}()
+		return op, nil
+	})
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start(&acceptedWriter{ResponseWriter: w}, req)
+	})
+	return RegisterHandler(r, h, HandlerMeta{
+		Input:       i,
+		Output:      o,
+		OperationID: operationID,
+		Method:      http.MethodPost,
+		operations:  jobs.path,
+	}, middlewares...)
+}
+
+// runOperation runs the handler and saves the outcome of the operation, a panic fails the operation
+func runOperation[I, O any](ctx context.Context, req *http.Request, store JobStore, op Operation, handler Handler[I, O], in I) {
+	save := func() {
+		op.UpdatedAt = time.Now()
+		if err := store.Save(ctx, op); err != nil {
+			slog.Default().ErrorContext(ctx, "failed to save operation", "err", err, "operation", op.Name, "id", op.ID)
+		}
+	}
+	op.Status = OperationRunning
+	save()
+
+	var (
+		out   O
+		opErr *Error
+	)
+	func() {
+		defer func() {
+			if p := recover(); p != nil {
+				opErr = &Error{Err: fmt.Errorf("panic: %v", p)}
+			}
+		}()
+		out, opErr = handler(ctx, in)
+	}()
+	if opErr == nil {
+		result, err := json.Marshal(out)
+		if err != nil {
+			opErr = &Error{Err: err}
+		} else {
+			op.Status, op.Result = OperationSucceeded, result
+		}
+	}
+	if opErr != nil {
+		slog.Default().ErrorContext(ctx, "async operation failed", "err", opErr, "operation", op.Name, "id", op.ID)
+		// the error is encoded by the encoder of the router as a response would be
+		buf := &bufferedResponse{header: make(http.Header)}
+		writeError(buf, req, errorStatus(req, opErr), opErr)
+		op.Status, op.Error = OperationFailed, buf.body.Bytes()
+	}
+	save()
+}
+
+// acceptedWriter answers 202 Accepted instead of 200 OK
+type acceptedWriter struct {
+	http.ResponseWriter
+}
+
+func (w *acceptedWriter) WriteHeader(status int) {
+	if status == http.StatusOK {
+		status = http.StatusAccepted
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *acceptedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func newOperationID() string {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// MemoryJobStore keeps the operations in memory, the done ones for the TTL, it suits a single instance
+type MemoryJobStore struct {
+	ttl time.Duration
+
+	mu    sync.Mutex
+	ops   map[string]Operation
+	swept time.Time
+}
+
+// NewMemoryJobStore makes a store keeping the done operations for the TTL, an hour if zero
+func NewMemoryJobStore(ttl time.Duration) *MemoryJobStore {
+	return &MemoryJobStore{ttl: cmp.Or(ttl, defaultOperationTTL), ops: make(map[string]Operation), swept: time.Now()}
+}
+
+func (s *MemoryJobStore) Save(_ context.Context, op Operation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(time.Now())
+	s.ops[op.ID] = op
+	return nil
+}
+
+func (s *MemoryJobStore) Get(_ context.Context, id string) (*Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	op, ok := s.ops[id]
+	if !ok || (op.Status.Done() && time.Since(op.UpdatedAt) > s.ttl) {
+		return nil, nil
+	}
+	return &op, nil
+}
+
+// sweep drops the expired operations once per TTL, the running ones are kept
+func (s *MemoryJobStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < s.ttl {
+		return
+	}
+	s.swept = now
+	for id, op := range s.ops {
+		if op.Status.Done() && now.Sub(op.UpdatedAt) > s.ttl {
+			delete(s.ops, id)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package vel
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	UnauthenticatedCode = "UNAUTHENTICATED"
+	ForbiddenCode       = "FORBIDDEN"
+)
+
+// Principal is the authenticated caller, an authentication middleware puts it in the context by WithPrincipal
+type Principal struct {
+	ID string
+	// Permissions are granted to the principal, PermissionPolicy checks them
+	Permissions []string
+	// Attributes keep the rest of the identity for the custom policies, e.g. the tenant or the token claims
+	Attributes map[string]any
+}
+
+// WithPrincipal returns the context of the request authenticated as the principal
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return principalKey.Set(ctx, p)
+}
+
+// PrincipalFromContext returns the authenticated principal, nil if the request isn't authenticated
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := principalKey.From(ctx)
+	return p
+}
+
+// Policy decides whether the principal may call the route, e.g. by a policy engine like OPA or Casbin
+type Policy interface {
+	// Allow reports whether the principal has the permissions declared by the route, the meta identifies the route,
+	// an error fails the request by 500
+	Allow(ctx context.Context, principal *Principal, meta *HandlerMeta) (bool, error)
+}
+
+type PolicyFunc func(ctx context.Context, principal *Principal, meta *HandlerMeta) (bool, error)
+
+func (f PolicyFunc) Allow(ctx context.Context, principal *Principal, meta *HandlerMeta) (bool, error) {
+	return f(ctx, principal, meta)
+}
+
+// PermissionPolicy allows the principals granted every permission declared by the route
+var PermissionPolicy Policy = PolicyFunc(func(_ context.Context, principal *Principal, meta *HandlerMeta) (bool, error) {
+	for _, permission := range meta.Spec.Permissions {
+		if !slices.Contains(principal.Permissions, permission) {
+			return false, nil
+		}
+	}
+	return true, nil
+})
+
+// Authorize is a middleware checking the permissions declared by the spec of the route, see Spec.Permissions,
+// the routes without permissions are served as is. It goes after the authentication middleware,
+// a request without a principal is answered by 401 UNAUTHENTICATED and a principal the policy denies by 403 FORBIDDEN.
+// The permissions are published in the OpenAPI spec as x-required-permissions.
+func Authorize(policy Policy) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			meta := MetaFromContext(ctx)
+			if meta == nil || len(meta.Spec.Permissions) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			principal := PrincipalFromContext(ctx)
+			if principal == nil {
+				writeError(w, r, http.StatusUnauthorized, &Error{Code: UnauthenticatedCode, Message: "the request isn't authenticated"})
+				return
+			}
+			allowed, err := policy.Allow(ctx, principal, meta)
+			if err != nil {
+				writeError(w, r, http.StatusInternalServerError, &Error{Err: err})
+				return
+			}
+			if !allowed {
+				writeError(w, r, http.StatusForbidden, &Error{
+					Code:    ForbiddenCode,
+					Message: "the operation requires the permissions " + strings.Join(meta.Spec.Permissions, ", "),
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package vel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+)
+
+const (
+	// BatchPath is the path of the batch endpoint, see RegisterBatchEndpoint
+	BatchPath = "/batch"
+
+	BatchTooLargeCode      = "BATCH_TOO_LARGE"
+	UnknownOperationCode   = "UNKNOWN_OPERATION"
+	AmbiguousOperationCode = "AMBIGUOUS_OPERATION"
+	// UnbatchableOperationCode rejects the items of the streaming routes and the routes with raw bodies,
+	// their responses don't fit a batch result
+	UnbatchableOperationCode = "UNBATCHABLE_OPERATION"
+)
+
+// BatchItem is a call of the batch, the body is the request body or the query of a GET operation as a JSON object
+type BatchItem struct {
+	OperationID string          `json:"operationId"`
+	Body        json.RawMessage `json:"body,omitempty"`
+}
+
+// BatchResult is the response of a batch item, the body is the response body or the error
+type BatchResult struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+type BatchOpts struct {
+	// Concurrency limits the items served at once, 4 if zero
+	Concurrency int
+	// MaxItems limits the items of a batch, 100 if zero
+	MaxItems int
+}
+
+func (o BatchOpts) withDefaults() BatchOpts {
+	if o.Concurrency <= 0 {
+		o.Concurrency = 4
+	}
+	if o.MaxItems <= 0 {
+		o.MaxItems = 100
+	}
+	return o
+}
+
+// RegisterBatchEndpoint serves POST /batch taking an array of items and answering the array of their results in the same order.
+// Every item is served by the router as a request to the route of its operation id with the headers of the batch request,
+// so the middlewares apply to the items, e.g. the authorization. The items are served concurrently up to the limit.
+// The batch answers 200 unless the batch itself is malformed or too large, the items carry their own statuses,
+// a panicking item gets 500. The streaming routes and the routes with raw bodies can't be batched, their items get 400.
+// The endpoint is not a part of the router meta, the clients generate it if the batch option is set.
+func (r *Router) RegisterBatchEndpoint(opts BatchOpts, middlewares ...Middleware) {
+	opts = opts.withDefaults()
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var items []BatchItem
+		if err := decodeBody(req, &items); err != nil {
+			writeError(w, req, http.StatusBadRequest, &Error{Code: "FAILED_DECODING_REQUEST_BODY", Err: err})
+			return
+		}
+		if len(items) > opts.MaxItems {
+			writeError(w, req, http.StatusBadRequest, &Error{Code: BatchTooLargeCode, Message: "the batch has too many items"})
+			return
+		}
+
+		results := make([]BatchResult, len(items))
+		limit := make(chan struct{}, opts.Concurrency)
+		var wg sync.WaitGroup
+		for i, item := range items {
+			wg.Add(1)
+			limit <- struct{}{}
+			go func() {
+				defer func() {
+					<-limit
+					wg.Done()
+				}()
+				// a panic escaping the handlers would crash the server, the goroutine isn't recovered by net/http
+				defer func() {
+					if p := recover(); p != nil {
+						slog.Default().ErrorContext(req.Context(), "batch item panicked", "panic", p, "operation", item.OperationID)
+						rec := &bufferedResponse{header: make(http.Header)}
+						writeError(rec, req, http.StatusInternalServerError, &Error{Err: fmt.Errorf("panic: %v", p)})
+						results[i] = rec.batchResult()
+					}
+				}()
+				results[i] = r.serveBatchItem(req, item)
+			}()
+		}
+		wg.Wait()
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(results); err != nil {
+			writeError(w, req, http.StatusInternalServerError, &Error{Err: err})
+		}
+	})
+	for i := range middlewares {
+		handler = middlewares[i](handler)
+	}
+	// the meta isn't a part of the router meta, it's seen by the route-aware middlewares only
+	meta := &HandlerMeta{Input: []BatchItem{}, Output: []BatchResult{}, OperationID: "batch", Method: http.MethodPost, Path: BatchPath}
+	r.handle(http.MethodPost+" "+BatchPath, withRoute(handler, meta, r.shared), nil, callSite())
+}
+
+// serveBatchItem serves the item by the router as a request to the route of the operation
+func (r *Router) serveBatchItem(req *http.Request, item BatchItem) BatchResult {
+	rec := &bufferedResponse{header: make(http.Header)}
+	var routes []*HandlerMeta
+	for _, meta := range r.shared.allRoutes() {
+		if meta.OperationID == item.OperationID {
+			routes = append(routes, meta)
+		}
+	}
+	switch {
+	case len(routes) == 0:
+		writeError(rec, req, http.StatusNotFound, &Error{Code: UnknownOperationCode, Message: "no operation " + item.OperationID})
+		return rec.batchResult()
+	case len(routes) > 1:
+		writeError(rec, req, http.StatusBadRequest, &Error{Code: AmbiguousOperationCode, Message: "several routes serve " + item.OperationID})
+		return rec.batchResult()
+	case !batchable(routes[0]):
+		writeError(rec, req, http.StatusBadRequest, &Error{Code: UnbatchableOperationCode, Message: "the operation " + item.OperationID + " can't be batched"})
+		return rec.batchResult()
+	}
+
+	meta := routes[0]
+	target, body := meta.Path, []byte(item.Body)
+	if meta.Method == http.MethodGet {
+		query, err := batchQuery(meta, item.Body)
+		if err != nil {
+			writeError(rec, req, http.StatusBadRequest, &Error{Code: "FAILED_DECODING_REQUEST_BODY", Err: err})
+			return rec.batchResult()
+		}
+		target, body = target+"?"+query, nil
+	}
+	itemReq, err := http.NewRequestWithContext(req.Context(), meta.Method, target, bytes.NewReader(body))
+	if err != nil {
+		writeError(rec, req, http.StatusBadRequest, &Error{Code: "FAILED_DECODING_REQUEST_BODY", Err: err})
+		return rec.batchResult()
+	}
+	itemReq.Header = req.Header.Clone()
+	itemReq.Header.Del("Content-Length")
+	itemReq.Host, itemReq.RemoteAddr = req.Host, req.RemoteAddr
+	r.mux.ServeHTTP(rec, itemReq)
+	return rec.batchResult()
+}
+
+// batchable reports whether the route answers a single JSON response, i.e. it doesn't stream and its bodies aren't raw
+func batchable(meta *HandlerMeta) bool {
+	input, output := reflect.TypeOf(meta.Input), reflect.TypeOf(meta.Output)
+	_, items := ItemType(input)
+	return meta.Spec.Stream == "" && !items && !IsRawBody(input) && !IsRawBody(output)
+}
+
+// batchQuery encodes the JSON body of a GET item as the query of the route input
+func batchQuery(meta *HandlerMeta, body json.RawMessage) (string, error) {
+	if meta.Input == nil || len(body) == 0 {
+		return "", nil
+	}
+	v := reflect.New(reflect.TypeOf(meta.Input))
+	if err := json.Unmarshal(body, v.Interface()); err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	if err := newQueryEncoder().Encode(v.Elem().Interface(), query); err != nil {
+		return "", err
+	}
+	return query.Encode(), nil
+}
+
+// batchResult is the recorded response as a batch result, a body that isn't JSON is a JSON string
+func (r *bufferedResponse) batchResult() BatchResult {
+	result := BatchResult{Status: r.status}
+	if result.Status == 0 {
+		result.Status = http.StatusOK
+	}
+	body := bytes.TrimSpace(r.body.Bytes())
+	switch {
+	case len(body) == 0:
+	case json.Valid(body):
+		result.Body = body
+	default:
+		result.Body, _ = json.Marshal(string(body))
+	}
+	return result
+}
@@ -0,0 +1,36 @@
+package vel
+
+import (
+	"encoding/json"
+	"math/big"
+	"strconv"
+)
+
+// BigInt is a big.Int encoded as a JSON string, a JSON number past 2^53 is rounded by the TS clients:
+//
+//	type Account struct {
+//		Balance vel.BigInt `json:"balance"`
+//	}
+//
+// It's decoded from a string or a number, the spec and the clients describe it as a string of the decimal format.
+type BigInt struct {
+	big.Int
+}
+
+func (i BigInt) MarshalJSON() ([]byte, error) {
+	return strconv.AppendQuote(nil, i.String()), nil
+}
+
+func (i *BigInt) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		data = []byte(s)
+	}
+	return i.Int.UnmarshalJSON(data)
+}
@@ -0,0 +1,195 @@
+package vel
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// CacheStatusHeader is HIT in the responses served from the ResponseCache and MISS in the ones stored to it
+	CacheStatusHeader = "X-Cache"
+
+	defaultCacheTTL     = time.Minute
+	defaultCacheEntries = 1000
+)
+
+// CachedResponse is a response kept by a CacheStore
+type CachedResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// Stored and Expires bound the period the response is served from the cache
+	Stored  time.Time
+	Expires time.Time
+}
+
+// CacheStore keeps the cached responses, e.g. in memory or in Redis to share them between instances.
+// A key starts with the operation id of the route followed by a newline.
+type CacheStore interface {
+	// Get returns the response of the key, nil if it's missing or expired
+	Get(ctx context.Context, key string) (*CachedResponse, error)
+	Set(ctx context.Context, key string, resp CachedResponse) error
+	// DeletePrefix drops the responses whose keys start with the prefix
+	DeletePrefix(ctx context.Context, prefix string) error
+}
+
+type CacheOpts struct {
+	// TTL is how long a response is served from the cache, the MaxAge of the route cache policy if zero, a minute if neither
+	TTL time.Duration
+	// EmitCacheControl sets the Cache-Control of the responses without one to the rest of their TTL, e.g. private, max-age=42,
+	// so the clients keep them as long as the server does, they don't see the invalidations then
+	EmitCacheControl bool
+}
+
+// ResponseCache caches the successful GET responses of the routes using its middleware,
+// see Invalidate to drop the responses changed by a mutation
+type ResponseCache struct {
+	store CacheStore
+}
+
+// NewResponseCache makes a cache keeping the responses in the store, see NewMemoryCacheStore
+func NewResponseCache(store CacheStore) *ResponseCache {
+	return &ResponseCache{store: store}
+}
+
+// Middleware is a per-route middleware serving the responses from the cache, they're keyed by the operation id
+// and the request as the route cache policy declares, see CachePolicy.Key, i.e. the query with the params sorted.
+// Only 200 responses are stored, unless they set a cookie or their Cache-Control is no-store.
+//
+// The cache is shared by every caller, so the requests carrying credentials, an Authorization header or a cookie,
+// are served as is, unless the route cache policy lists the header in VaryHeaders, then it's a part of the key
+// and every caller gets only its own responses.
+//
+// The response is buffered, so it doesn't suit the streams, they're served as is.
+func (c *ResponseCache) Middleware(opts CacheOpts) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// a handler without a route meta has no cache policy, it isn't cacheable
+			rt := routeFromContext(r.Context())
+			if rt == nil || rt.meta == nil || rt.meta.Spec.Stream != "" || !rt.meta.Spec.Cache.shared(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := r.Context()
+			ttl := cmp.Or(opts.TTL, rt.meta.Spec.Cache.MaxAge, defaultCacheTTL)
+			key := rt.meta.OperationID + "\n" + rt.meta.Spec.Cache.Key(r)
+
+			cached, err := c.store.Get(ctx, key)
+			if err != nil {
+				slog.Default().ErrorContext(ctx, "failed to get cached response", "err", err, "operation", rt.meta.OperationID)
+			}
+			now := time.Now()
+			if cached != nil {
+				w.Header().Set(CacheStatusHeader, "HIT")
+				w.Header().Set("Age", strconv.Itoa(int(now.Sub(cached.Stored)/time.Second)))
+				if opts.EmitCacheControl && cached.Header.Get("Cache-Control") == "" {
+					w.Header().Set("Cache-Control", CachePolicy{MaxAge: cached.Expires.Sub(now)}.HeaderValue())
+				}
+				writeStored(w, r, cached.Status, cached.Header, cached.Body)
+				return
+			}
+
+			// the headers set by the outer middlewares belong to the request, e.g. a request id, they aren't cached
+			outer := w.Header().Clone()
+			buf := &bufferedResponse{header: w.Header()}
+			next.ServeHTTP(buf, r)
+			status := cmp.Or(buf.status, http.StatusOK)
+			if status == http.StatusOK && cacheable(w.Header()) {
+				resp := CachedResponse{Status: status, Header: changedHeaders(outer, w.Header()), Body: buf.body.Bytes(), Stored: now, Expires: now.Add(ttl)}
+				if err := c.store.Set(ctx, key, resp); err != nil {
+					slog.Default().ErrorContext(ctx, "failed to cache response", "err", err, "operation", rt.meta.OperationID)
+				}
+				w.Header().Set(CacheStatusHeader, "MISS")
+				if opts.EmitCacheControl && w.Header().Get("Cache-Control") == "" {
+					w.Header().Set("Cache-Control", CachePolicy{MaxAge: ttl}.HeaderValue())
+				}
+			}
+			w.WriteHeader(status)
+			if _, err := w.Write(buf.body.Bytes()); err != nil {
+				slog.Default().ErrorContext(ctx, "failed to write response", "err", err)
+			}
+		})
+	}
+}
+
+// Invalidate drops every cached response of the operations, e.g. a handler updating a user invalidates getUser and listUsers.
+// The operations of every router sharing the cache are dropped.
+func (c *ResponseCache) Invalidate(ctx context.Context, operationIDs ...string) error {
+	for _, operationID := range operationIDs {
+		if err := c.store.DeletePrefix(ctx, operationID+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cacheable(header http.Header) bool {
+	return header.Get("Set-Cookie") == "" && !strings.Contains(header.Get("Cache-Control"), "no-store")
+}
+
+// MemoryCacheStore keeps the responses in memory, it suits a single instance
+type MemoryCacheStore struct {
+	maxEntries int
+
+	mu      sync.RWMutex
+	entries map[string]CachedResponse
+}
+
+// NewMemoryCacheStore makes a store keeping up to maxEntries responses, 1000 if zero
+func NewMemoryCacheStore(maxEntries int) *MemoryCacheStore {
+	return &MemoryCacheStore{maxEntries: cmp.Or(maxEntries, defaultCacheEntries), entries: make(map[string]CachedResponse)}
+}
+
+func (s *MemoryCacheStore) Get(_ context.Context, key string) (*CachedResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	resp, ok := s.entries[key]
+	if !ok || time.Now().After(resp.Expires) {
+		return nil, nil
+	}
+	return &resp, nil
+}
+
+func (s *MemoryCacheStore) Set(_ context.Context, key string, resp CachedResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[key]; !ok && len(s.entries) >= s.maxEntries {
+		s.evict()
+	}
+	s.entries[key] = resp
+	return nil
+}
+
+func (s *MemoryCacheStore) DeletePrefix(_ context.Context, prefix string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.entries, key)
+		}
+	}
+	return nil
+}
+
+// evict drops the expired responses, or an arbitrary one if none expired, the store only has to be bounded
+func (s *MemoryCacheStore) evict() {
+	now := time.Now()
+	for key, resp := range s.entries {
+		if now.After(resp.Expires) {
+			delete(s.entries, key)
+		}
+	}
+	if len(s.entries) < s.maxEntries {
+		return
+	}
+	for key := range s.entries {
+		delete(s.entries, key)
+		break
+	}
+}
@@ -0,0 +1,256 @@
+// Command vel generates clients and specs of a vel router declared in vel.yaml:
+//
+//	vel gen                          generates every client and the spec
+//	vel gen client -lang ts -out ./sdk
+//	vel gen openapi -out ./openapi.yaml
+//	vel gen postman -out ./api.postman_collection.json
+//	vel gen contract -out ./internal/api/contract_test.go
+//	vel routes                       prints the routes
+//	vel gen -watch                   regenerates on every change of the Go sources
+//	vel new service billing -module github.com/me/billing
+//	vel import ./openapi.yaml -out ./internal/api
+//	vel diff ./old.yaml ./openapi.yaml   fails on the changes breaking the clients
+//
+// The router is constructed by the function set in the config, e.g. router: ./internal/api.NewRouter,
+// vel builds a program calling it and runs the program in the current module.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/dennypenta/vel/gen"
+)
+
+const driverTemplate = `package main
+
+import (
+	"log"
+	"os"
+
+	target %q
+	"github.com/dennypenta/vel/gen"
+)
+
+func main() {
+	log.SetFlags(0)
+	if err := gen.Main(target.%s(), os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
+`
+
+func main() {
+	log.SetFlags(0)
+	flags := flag.NewFlagSet("vel", flag.ExitOnError)
+	configPath := flags.String("config", gen.DefaultConfigPath, "path to the config file")
+	flags.Parse(os.Args[1:])
+
+	if rest := flags.Args(); len(rest) > 0 && rest[0] == "new" {
+		if err := runNew(rest[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if rest := flags.Args(); len(rest) > 0 && rest[0] == "import" {
+		if err := runImport(rest[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if rest := flags.Args(); len(rest) > 0 && rest[0] == "diff" {
+		if err := runDiff(rest[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	args, watch := watchFlag(os.Args[1:])
+	if watch {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		if err := runWatch(ctx, *configPath, args); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := run(*configPath, args); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+		log.Fatal(err)
+	}
+}
+
+// watchFlag removes the -watch flag from the arguments, it's handled by the command instead of the driver
+func watchFlag(args []string) ([]string, bool) {
+	rest := make([]string, 0, len(args))
+	watch := false
+	for _, arg := range args {
+		if arg == "-watch" || arg == "--watch" {
+			watch = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return rest, watch
+}
+
+// runWatch runs the driver on every change of the Go sources, so the changed handlers are compiled in
+func runWatch(ctx context.Context, configPath string, args []string) error {
+	config, err := gen.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(args, "gen") {
+		// the driver is run for every change, only the changed files are formatted and written
+		args = append(args, "-skip-unchanged")
+	}
+	generate := func() {
+		if err := run(configPath, args); err != nil {
+			log.Println(err)
+			return
+		}
+		log.Println("generated")
+	}
+	generate()
+	return gen.WatchSources(ctx, ".", config.OutputDirs(), generate)
+}
+
+// runNew scaffolds a new project, it doesn't need a config
+func runNew(args []string) error {
+	if len(args) == 0 || args[0] != "service" {
+		return errors.New("usage: vel new service <name> [-module path] [-dir dir]")
+	}
+	flags := flag.NewFlagSet("new service", flag.ContinueOnError)
+	module := flags.String("module", "", "module path of the service, the name by default")
+	dir := flags.String("dir", "", "directory of the project, the name by default")
+	// the name may precede the flags
+	name := ""
+	if len(args) > 1 && !strings.HasPrefix(args[1], "-") {
+		name, args = args[1], args[2:]
+	} else {
+		args = args[1:]
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if name == "" && flags.NArg() > 0 {
+		name = flags.Arg(0)
+	}
+
+	written, err := gen.Scaffold(gen.ScaffoldConfig{Name: name, Module: *module, Dir: *dir})
+	if err != nil {
+		return err
+	}
+	for _, path := range written {
+		fmt.Println("created", path)
+	}
+	fmt.Println("run make tidy to fetch the dependencies, then make test")
+	return nil
+}
+
+// runImport writes the handler skeletons of an OpenAPI document, it doesn't need a config
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	out := flags.String("out", "api", "directory of the package, it names the package")
+	// the spec may precede the flags
+	spec := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		spec, args = args[0], args[1:]
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if spec == "" && flags.NArg() > 0 {
+		spec = flags.Arg(0)
+	}
+	if spec == "" {
+		return errors.New("usage: vel import <openapi.yaml> [-out dir]")
+	}
+
+	if err := gen.FromOpenAPI(spec, *out); err != nil {
+		return err
+	}
+	fmt.Println("created", filepath.Join(*out, "types.go"), filepath.Join(*out, "handlers.go"), filepath.Join(*out, "router.go"))
+	return nil
+}
+
+// runDiff prints the breaking changes between two versions of a spec, it fails if there are any
+func runDiff(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: vel diff <old.yaml> <new.yaml>")
+	}
+	changes, err := gen.DiffOpenAPIFiles(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	if len(changes) > 0 {
+		return fmt.Errorf("%d breaking change(s)", len(changes))
+	}
+	return nil
+}
+
+// run builds the driver program calling the router constructor and runs it with the arguments
+func run(configPath string, args []string) error {
+	config, err := gen.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	pkg, constructor, err := splitRouter(config.Router)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(pkg, ".") {
+		if pkg, err = importPath(pkg); err != nil {
+			return err
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "vel")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	driver := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(driver, fmt.Appendf(nil, driverTemplate, pkg, constructor), 0644); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("go", append([]string{"run", driver}, args...)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// splitRouter splits the router reference to the package and the constructor name,
+// e.g. github.com/me/app/api.NewRouter
+func splitRouter(ref string) (string, string, error) {
+	i := strings.LastIndex(ref, ".")
+	if i <= 0 || i == len(ref)-1 || strings.Contains(ref[i:], "/") {
+		return "", "", fmt.Errorf("router %q must be a package followed by a function name, e.g. ./api.NewRouter", ref)
+	}
+	return ref[:i], ref[i+1:], nil
+}
+
+// importPath resolves a directory of the current module to its import path
+func importPath(dir string) (string, error) {
+	out, err := exec.Command("go", "list", "-f", "{{.ImportPath}}", dir).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", dir, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const diffSpec = `openapi: 3.0.0
+paths:
+  /createUser:
+    post:
+      operationId: createUser
+      responses:
+        "200":
+          description: Success
+`
+
+func TestRunDiff(t *testing.T) {
+	dir := t.TempDir()
+	spec, empty := filepath.Join(dir, "openapi.yaml"), filepath.Join(dir, "empty.yaml")
+	if err := os.WriteFile(spec, []byte(diffSpec), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(empty, []byte("openapi: 3.0.0\npaths: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		args []string
+		// wantErr is a part of the error failing the command, empty if it succeeds
+		wantErr string
+	}{
+		{"no changes", []string{spec, spec}, ""},
+		{"compatible addition", []string{empty, spec}, ""},
+		{"breaking change", []string{spec, empty}, "1 breaking change(s)"},
+		{"missing spec", []string{spec, filepath.Join(dir, "missing.yaml")}, "missing.yaml"},
+		{"one spec", []string{spec}, "usage: vel diff <old.yaml> <new.yaml>"},
+		{"three specs", []string{spec, spec, spec}, "usage: vel diff <old.yaml> <new.yaml>"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := runDiff(tc.args)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("expected an error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestWatchFlag(t *testing.T) {
+	for _, tc := range []struct {
+		args      []string
+		wantArgs  []string
+		wantWatch bool
+	}{
+		{[]string{"gen"}, []string{"gen"}, false},
+		{[]string{"gen", "-watch"}, []string{"gen"}, true},
+		{[]string{"--watch", "gen", "client", "-lang", "ts"}, []string{"gen", "client", "-lang", "ts"}, true},
+		{nil, []string{}, false},
+	} {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			args, watch := watchFlag(tc.args)
+			if !slices.Equal(args, tc.wantArgs) || watch != tc.wantWatch {
+				t.Errorf("expected %q %v, got %q %v", tc.wantArgs, tc.wantWatch, args, watch)
+			}
+		})
+	}
+}
+
+func TestSplitRouter(t *testing.T) {
+	for _, tc := range []struct {
+		ref              string
+		pkg, constructor string
+		wantErr          bool
+	}{
+		{"./api.NewRouter", "./api", "NewRouter", false},
+		{"github.com/me/app/api.NewRouter", "github.com/me/app/api", "NewRouter", false},
+		{"NewRouter", "", "", true},
+		{"./api.", "", "", true},
+		{"github.com/me/app.v2/api", "", "", true},
+	} {
+		t.Run(tc.ref, func(t *testing.T) {
+			pkg, constructor, err := splitRouter(tc.ref)
+			if (err != nil) != tc.wantErr || pkg != tc.pkg || constructor != tc.constructor {
+				t.Errorf("expected %q %q error %v, got %q %q %v", tc.pkg, tc.constructor, tc.wantErr, pkg, constructor, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package vel
+
+import (
+	"cmp"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	OverloadedCode = "OVERLOADED"
+
+	defaultQueueWait  = time.Second
+	defaultRetryAfter = time.Second
+)
+
+type ConcurrencyOpts struct {
+	// MaxInFlight bounds the requests served at once, it must be positive
+	MaxInFlight int
+	// MaxQueue bounds the requests waiting for a slot once MaxInFlight are served, zero rejects them right away
+	MaxQueue int
+	// MaxWait bounds the wait of a queued request, a second if zero
+	MaxWait time.Duration
+	// RetryAfter is sent to the rejected requests, a second if zero
+	RetryAfter time.Duration
+	// PerRoute limits every route using the middleware separately, otherwise they share the limit,
+	// e.g. router.Use(ConcurrencyLimit(...)) limits the whole router
+	PerRoute bool
+}
+
+// ConcurrencyLimit is a middleware shedding the load under bursts: it serves up to MaxInFlight requests at once,
+// the next ones wait in a bounded queue, a request that doesn't fit the queue or waits longer than MaxWait
+// is answered by 503 OVERLOADED with Retry-After.
+func ConcurrencyLimit(opts ConcurrencyOpts) Middleware {
+	if opts.MaxInFlight <= 0 {
+		panic("vel: ConcurrencyLimit requires a positive MaxInFlight")
+	}
+	opts.MaxWait = cmp.Or(opts.MaxWait, defaultQueueWait)
+	opts.RetryAfter = cmp.Or(opts.RetryAfter, defaultRetryAfter)
+	shared := newLimiter(opts)
+	return func(next http.Handler) http.Handler {
+		// the middleware wraps every route once, so a per-route limiter is made here
+		l := shared
+		if opts.PerRoute {
+			l = newLimiter(opts)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !l.acquire(r) {
+				w.Header().Set("Retry-After", strconv.Itoa(max(1, int(opts.RetryAfter/time.Second))))
+				writeError(w, r, http.StatusServiceUnavailable, &Error{Code: OverloadedCode, Message: "the server is overloaded, retry later"})
+				return
+			}
+			defer l.release()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limiter holds a slot per served request
+type limiter struct {
+	slots   chan struct{}
+	queued  atomic.Int64
+	maxWait time.Duration
+	queue   int64
+}
+
+func newLimiter(opts ConcurrencyOpts) *limiter {
+	return &limiter{slots: make(chan struct{}, opts.MaxInFlight), maxWait: opts.MaxWait, queue: int64(opts.MaxQueue)}
+}
+
+// acquire takes a slot, it waits in the queue if there is room, it reports false if the request is shed
+// or gone while waiting
+func (l *limiter) acquire(r *http.Request) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if l.queued.Add(1) > l.queue {
+		l.queued.Add(-1)
+		return false
+	}
+	defer l.queued.Add(-1)
+
+	timer := time.NewTimer(l.maxWait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (l *limiter) release() {
+	<-l.slots
+}
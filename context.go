@@ -5,34 +5,79 @@ import (
 	"net/http"
 )
 
-type (
-	requestKeyType int
-	writerKeyType  int
-)
+// ContextValue is a typed key of a context value, every variable of it is a distinct key:
+//
+//	var tenant vel.ContextValue[string]
+//	ctx = tenant.Set(ctx, "acme")
+//	name, ok := tenant.From(ctx)
+type ContextValue[T any] struct {
+	// a key of a zero size might share its address with another one
+	_ byte
+}
+
+// Set returns the context carrying the value
+func (k *ContextValue[T]) Set(ctx context.Context, v T) context.Context {
+	return context.WithValue(ctx, k, v)
+}
 
-const (
-	requestKey requestKeyType = 1
-	writerKey  writerKeyType  = 1
+// From returns the value of the context, false if it's not set
+func (k *ContextValue[T]) From(ctx context.Context) (T, bool) {
+	v, ok := ctx.Value(k).(T)
+	return v, ok
+}
+
+var (
+	requestKey   ContextValue[*http.Request]
+	writerKey    ContextValue[http.ResponseWriter]
+	routeKey     ContextValue[*route]
+	metricsKey   ContextValue[*metricsRecord]
+	streamKey    ContextValue[*streamState]
+	signatureKey ContextValue[string]
+	principalKey ContextValue[*Principal]
 )
 
 func RequestWithContext(ctx context.Context, r *http.Request) context.Context {
-	return context.WithValue(ctx, requestKey, r)
+	return requestKey.Set(ctx, r)
 }
 
 func RequestFromContext(ctx context.Context) *http.Request {
-	if r, ok := ctx.Value(requestKey).(*http.Request); ok {
-		return r
-	}
-	return nil
+	r, _ := requestKey.From(ctx)
+	return r
 }
 
 func WriterWithContext(ctx context.Context, w http.ResponseWriter) context.Context {
-	return context.WithValue(ctx, writerKey, w)
+	return writerKey.Set(ctx, w)
 }
 
 func WriterFromContext(ctx context.Context) http.ResponseWriter {
-	if w, ok := ctx.Value(writerKey).(http.ResponseWriter); ok {
-		return w
+	w, _ := writerKey.From(ctx)
+	return w
+}
+
+// MetaFromContext returns the meta of the route serving the request,
+// nil if the handler is not registered on a Router.
+func MetaFromContext(ctx context.Context) *HandlerMeta {
+	if route := routeFromContext(ctx); route != nil {
+		return route.meta
 	}
 	return nil
 }
+
+// route binds a registered handler to its meta and the router it's registered on
+type route struct {
+	meta     *HandlerMeta
+	shared   *routerShared
+	fallback fallbackCache
+}
+
+func routeFromContext(ctx context.Context) *route {
+	r, _ := routeKey.From(ctx)
+	return r
+}
+
+func withRoute(h http.Handler, meta *HandlerMeta, shared *routerShared) http.Handler {
+	rt := &route{meta: meta, shared: shared}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(routeKey.Set(r.Context(), rt)))
+	})
+}
@@ -0,0 +1,116 @@
+package vel
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// RequestTimeoutHeader carries the time left to serve the request, e.g. 1500ms or 2s, see Deadline
+	RequestTimeoutHeader = "X-Request-Timeout"
+	// GRPCTimeoutHeader carries the timeout in the gRPC format, e.g. 100m for 100 milliseconds
+	GRPCTimeoutHeader = "Grpc-Timeout"
+
+	DeadlineExceededCode = "DEADLINE_EXCEEDED"
+	InvalidTimeoutCode   = "INVALID_TIMEOUT"
+)
+
+type DeadlineOpts struct {
+	// Header carries the timeout, RequestTimeoutHeader if empty. Its value is a Go duration or milliseconds,
+	// GRPCTimeoutHeader is read in the gRPC format.
+	Header string
+	// Default is the timeout of the requests without the header, zero doesn't limit them
+	Default time.Duration
+	// Max bounds the timeout, the requests without the header included, zero doesn't bound it
+	Max time.Duration
+}
+
+// Deadline is a middleware propagating the deadlines across the services: the handler context gets the deadline
+// of the timeout the caller sends in the header, so the calls the handler makes stop in time, see SetRequestTimeout.
+// A request whose timeout is expired already is answered by 504 DEADLINE_EXCEEDED, a malformed one by 400 INVALID_TIMEOUT.
+// The header is documented in the OpenAPI spec of the routes using the middleware.
+func Deadline(opts DeadlineOpts) Middleware {
+	opts.Header = http.CanonicalHeaderKey(cmp.Or(opts.Header, RequestTimeoutHeader))
+	return func(next http.Handler) http.Handler {
+		return &deadlineHandler{next: next, opts: opts}
+	}
+}
+
+type deadlineHandler struct {
+	next http.Handler
+	opts DeadlineOpts
+}
+
+// requestHeaders documents the header in the meta of the route, see RegisterHandler
+func (h *deadlineHandler) requestHeaders() []KeyValueSpec {
+	return []KeyValueSpec{{
+		Key:          h.opts.Header,
+		ValueType:    String,
+		Description:  "time left to serve the request, the request is rejected once it's expired",
+		ValueExample: "1500ms",
+	}}
+}
+
+func (h *deadlineHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	timeout := h.opts.Default
+	if value := r.Header.Get(h.opts.Header); value != "" {
+		var err error
+		if timeout, err = parseTimeout(h.opts.Header, value); err != nil {
+			writeError(w, r, http.StatusBadRequest, &Error{Code: InvalidTimeoutCode, Message: err.Error()})
+			return
+		}
+		if timeout <= 0 {
+			writeError(w, r, http.StatusGatewayTimeout, &Error{Code: DeadlineExceededCode, Message: "the request deadline is exceeded"})
+			return
+		}
+	}
+	if h.opts.Max > 0 && (timeout == 0 || timeout > h.opts.Max) {
+		timeout = h.opts.Max
+	}
+	if timeout == 0 {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	h.next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// parseTimeout reads the timeout of the header, the gRPC one is an integer of up to 8 digits followed by the unit
+func parseTimeout(header, value string) (time.Duration, error) {
+	if header == GRPCTimeoutHeader {
+		units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second, 'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond}
+		if len(value) < 2 || len(value) > 9 {
+			return 0, fmt.Errorf("malformed %s %q", header, value)
+		}
+		unit, ok := units[value[len(value)-1]]
+		amount, err := strconv.ParseUint(value[:len(value)-1], 10, 64)
+		if !ok || err != nil {
+			return 0, fmt.Errorf("malformed %s %q", header, value)
+		}
+		return time.Duration(amount) * unit, nil
+	}
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("malformed %s %q, expected a duration, e.g. 1500ms", header, value)
+	}
+	return timeout, nil
+}
+
+// SetRequestTimeout sends the time left until the deadline of the request context in RequestTimeoutHeader,
+// e.g. in a client calling another service from a handler, a request without a deadline is left as is
+func SetRequestTimeout(r *http.Request) {
+	deadline, ok := r.Context().Deadline()
+	if !ok || r.Header.Get(RequestTimeoutHeader) != "" {
+		return
+	}
+	left := max(time.Until(deadline), 0)
+	r.Header.Set(RequestTimeoutHeader, strconv.FormatInt(left.Milliseconds(), 10)+"ms")
+}
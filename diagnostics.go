@@ -0,0 +1,121 @@
+package vel
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+type DiagnosticKind string
+
+const (
+	DiagnosticRouteWithoutSpec DiagnosticKind = "ROUTE_WITHOUT_SPEC"
+	DiagnosticLateMiddleware   DiagnosticKind = "LATE_MIDDLEWARE"
+	DiagnosticDuplicateOptions DiagnosticKind = "DUPLICATE_OPTIONS"
+	DiagnosticGlobalOptsUnset  DiagnosticKind = "GLOBAL_OPTS_UNSET"
+	DiagnosticDuplicateRoute   DiagnosticKind = "DUPLICATE_ROUTE"
+)
+
+// Diagnostic describes a setup mistake detected in the router configuration
+type Diagnostic struct {
+	Kind    DiagnosticKind `json:"kind"`
+	Message string         `json:"message"`
+}
+
+// diagnostics collects the mistakes found while registering routes
+// and remembers which of them have been logged already
+type diagnostics struct {
+	mu     sync.Mutex
+	found  []Diagnostic
+	logged map[Diagnostic]bool
+}
+
+func (d *diagnostics) add(kind DiagnosticKind, message string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.found = append(d.found, Diagnostic{Kind: kind, Message: message})
+}
+
+// Diagnostics reports misconfigurations of the router and all its subrouters:
+// routes without specs, middlewares registered after routes, subrouters sharing OPTIONS of a path,
+// routes registered twice and the global options cleared by the service, e.g. a nil MapCodeToStatus.
+func (r *Router) Diagnostics() []Diagnostic {
+	r.shared.diagnostics.mu.Lock()
+	result := append([]Diagnostic{}, r.shared.diagnostics.found...)
+	r.shared.diagnostics.mu.Unlock()
+
+	for _, meta := range r.shared.allRoutes() {
+		if reflect.ValueOf(meta.Spec).IsZero() {
+			result = append(result, Diagnostic{
+				Kind:    DiagnosticRouteWithoutSpec,
+				Message: meta.Method + " " + meta.Path + " has no spec, it's documented by its types only",
+			})
+		}
+	}
+	// the defaults are a valid setup, only an option cleared by the service is reported
+	if GlobalOpts.MapCodeToStatus == nil {
+		result = append(result, Diagnostic{
+			Kind:    DiagnosticGlobalOptsUnset,
+			Message: "GlobalOpts.MapCodeToStatus is nil, every handler error panics",
+		})
+	}
+
+	return result
+}
+
+// LogDiagnostics logs every diagnostic as a warning,
+// a diagnostic is logged only once no matter how many times the function is called.
+func (r *Router) LogDiagnostics(logger *slog.Logger) {
+	found := r.Diagnostics()
+
+	d := &r.shared.diagnostics
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.logged == nil {
+		d.logged = make(map[Diagnostic]bool)
+	}
+	for _, diag := range found {
+		if d.logged[diag] {
+			continue
+		}
+		d.logged[diag] = true
+		logger.Warn("vel: "+diag.Message, "kind", diag.Kind)
+	}
+}
+
+type DebugRoute struct {
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	OperationID string `json:"operationId"`
+}
+
+type DebugInfo struct {
+	Routes      []DebugRoute `json:"routes"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// RegisterDebugEndpoint serves the registered routes and the diagnostics on GET /debug/vel,
+// the endpoint is not a part of the router meta, therefore it's not generated in clients.
+func (r *Router) RegisterDebugEndpoint(middlewares ...Middleware) {
+	var handler http.Handler = NewHandler(func(ctx context.Context, _ struct{}) (DebugInfo, *Error) {
+		routes := r.shared.allRoutes()
+		info := DebugInfo{
+			Routes:      make([]DebugRoute, 0, len(routes)),
+			Diagnostics: r.Diagnostics(),
+		}
+		for _, meta := range routes {
+			info.Routes = append(info.Routes, DebugRoute{
+				Method:      meta.Method,
+				Path:        meta.Path,
+				OperationID: meta.OperationID,
+			})
+		}
+		return info, nil
+	})
+	for i := range middlewares {
+		handler = middlewares[i](handler)
+	}
+	r.handle("GET /debug/vel", handler, nil, callSite())
+}
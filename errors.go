@@ -0,0 +1,147 @@
+package vel
+
+import (
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+)
+
+// errorStatus is the response status of a handler error: the status set on the error,
+// the lowest status the spec of the route declares the code under, e.g. Errors: {404: {{Code: "NOT_FOUND"}}},
+// or the one mapped by GlobalOpts.MapCodeToStatus
+func errorStatus(r *http.Request, e *Error) int {
+	if e.Status != 0 {
+		return e.Status
+	}
+	if meta := MetaFromContext(r.Context()); meta != nil {
+		for _, status := range slices.Sorted(maps.Keys(meta.Spec.Errors)) {
+			if slices.ContainsFunc(meta.Spec.Errors[status], func(spec ErrorSpec) bool { return spec.Code == e.Code }) {
+				return status
+			}
+		}
+	}
+	return GlobalOpts.MapCodeToStatus(e.Code)
+}
+
+// ErrorEncoder writes handler errors to the response.
+// Schema describes the produced shape, it's used to generate OpenAPI error schemas and clients decoding.
+type ErrorEncoder interface {
+	EncodeError(w http.ResponseWriter, r *http.Request, status int, e *Error) error
+	Schema() ErrorSchema
+}
+
+// ErrorSchema describes the JSON shape of an encoded error
+type ErrorSchema struct {
+	// Envelope wraps the error object into a field, e.g. {"error": {"code": "..."}}, empty means no envelope
+	Envelope     string
+	CodeField    string
+	MessageField string
+	MetaField    string
+	// ViolationsField holds the violations of a failed validation
+	ViolationsField string
+	// Extra declares additional fields of the error object, e.g. requestId
+	Extra []KeyValueSpec
+}
+
+// DefaultErrorSchema is the shape of an Error encoded as is
+var DefaultErrorSchema = ErrorSchema{
+	CodeField:       "code",
+	MessageField:    "message",
+	MetaField:       "meta",
+	ViolationsField: "violations",
+}
+
+// WithDefaults fills the empty field names with the default ones
+func (s ErrorSchema) WithDefaults() ErrorSchema {
+	if s.CodeField == "" {
+		s.CodeField = DefaultErrorSchema.CodeField
+	}
+	if s.MessageField == "" {
+		s.MessageField = DefaultErrorSchema.MessageField
+	}
+	if s.MetaField == "" {
+		s.MetaField = DefaultErrorSchema.MetaField
+	}
+	if s.ViolationsField == "" {
+		s.ViolationsField = DefaultErrorSchema.ViolationsField
+	}
+	return s
+}
+
+// ErrorMeta is the meta of an Error, a plain map, e.g. map[string]string{"field": "email"},
+// encoding/json sorts its keys, and LogValue logs it as a group sorted by the keys, so the same error always logs the same
+type ErrorMeta map[string]string
+
+// LogValue logs the entries as a group sorted by the keys
+func (m ErrorMeta) LogValue() slog.Value {
+	attrs := make([]slog.Attr, 0, len(m))
+	for _, key := range slices.Sorted(maps.Keys(m)) {
+		attrs = append(attrs, slog.String(key, m[key]))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+type defaultErrorEncoder struct{}
+
+func (defaultErrorEncoder) EncodeError(w http.ResponseWriter, r *http.Request, status int, e *Error) error {
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(e)
+}
+
+func (defaultErrorEncoder) Schema() ErrorSchema {
+	return DefaultErrorSchema
+}
+
+// JSONErrorEncoder encodes errors following its schema,
+// ExtraFields provides the values of the declared extra fields, e.g. a request id or a timestamp.
+type JSONErrorEncoder struct {
+	ErrorSchema ErrorSchema
+	ExtraFields func(r *http.Request, e *Error) map[string]any
+}
+
+func (enc JSONErrorEncoder) EncodeError(w http.ResponseWriter, r *http.Request, status int, e *Error) error {
+	schema := enc.ErrorSchema.WithDefaults()
+
+	obj := make(map[string]any, 3+len(schema.Extra))
+	if enc.ExtraFields != nil {
+		for k, v := range enc.ExtraFields(r, e) {
+			obj[k] = v
+		}
+	}
+	obj[schema.CodeField] = e.Code
+	if e.Message != "" {
+		obj[schema.MessageField] = e.Message
+	}
+	if len(e.Meta) > 0 {
+		obj[schema.MetaField] = e.Meta
+	}
+	if len(e.Violations) > 0 {
+		obj[schema.ViolationsField] = e.Violations
+	}
+
+	var body any = obj
+	if schema.Envelope != "" {
+		body = map[string]any{schema.Envelope: obj}
+	}
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(body)
+}
+
+func (enc JSONErrorEncoder) Schema() ErrorSchema {
+	return enc.ErrorSchema.WithDefaults()
+}
+
+// SetErrorEncoder replaces how errors are written by the router and all its subrouters
+func (r *Router) SetErrorEncoder(enc ErrorEncoder) {
+	r.shared.errorEncoder = enc
+}
+
+// ErrorEncoder returns the encoder used by the router
+func (r *Router) ErrorEncoder() ErrorEncoder {
+	if r.shared.errorEncoder == nil {
+		return defaultErrorEncoder{}
+	}
+	return r.shared.errorEncoder
+}
@@ -0,0 +1,112 @@
+package vel
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+type ETagOpts struct {
+	// Weak marks the computed ETags weak, e.g. W/"…", the response is equivalent rather than byte-identical,
+	// e.g. it's compressed by a proxy later
+	Weak bool
+}
+
+// ETag is a middleware of GET routes answering the conditional requests: it computes the ETag of a successful response
+// from its body unless the handler supplies one by SetETag, and answers 304 Not Modified without the body
+// if the If-None-Match header of the request matches it. The response is buffered, so it doesn't suit the streams.
+// The generated clients remember the ETags and send them automatically.
+func ETag(opts ETagOpts) Middleware {
+	return func(next http.Handler) http.Handler {
+		return &etagHandler{next: next, opts: opts}
+	}
+}
+
+type etagHandler struct {
+	next http.Handler
+	opts ETagOpts
+}
+
+// requestHeaders documents the header in the meta of the route, see RegisterHandler
+func (h *etagHandler) requestHeaders() []KeyValueSpec {
+	return []KeyValueSpec{{
+		Key:         "If-None-Match",
+		ValueType:   String,
+		Description: "ETag of the response the client has, 304 Not Modified is answered if it's unchanged",
+	}}
+}
+
+func (h *etagHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	// the handler sets the headers of the response as is, only the status and the body are held back
+	buf := &bufferedResponse{header: w.Header()}
+	h.next.ServeHTTP(buf, r)
+	status := buf.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status == http.StatusOK {
+		etag := w.Header().Get("ETag")
+		if etag == "" {
+			etag = computeETag(buf.body.Bytes(), h.opts.Weak)
+			w.Header().Set("ETag", etag)
+		}
+		// cross-origin clients can read the header only if it's exposed
+		w.Header().Add("Access-Control-Expose-Headers", "ETag")
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			for _, key := range []string{"Content-Type", "Content-Length", "Content-Encoding"} {
+				w.Header().Del(key)
+			}
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.WriteHeader(status)
+	if _, err := w.Write(buf.body.Bytes()); err != nil {
+		slog.Default().ErrorContext(r.Context(), "failed to write response", "err", err)
+	}
+}
+
+// SetETag supplies the ETag of the response, e.g. a version of the entity, so the ETag middleware doesn't hash the body.
+// The value is quoted unless it's quoted already, e.g. W/"v2".
+func SetETag(ctx context.Context, etag string) {
+	w := WriterFromContext(ctx)
+	if w == nil {
+		return
+	}
+	if !strings.HasSuffix(etag, `"`) {
+		etag = `"` + etag + `"`
+	}
+	w.Header().Set("ETag", etag)
+}
+
+func computeETag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+	if weak {
+		return "W/" + etag
+	}
+	return etag
+}
+
+// etagMatches compares the ETags of If-None-Match with the weak comparison, * matches any ETag
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
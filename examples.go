@@ -0,0 +1,251 @@
+package vel
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/schema"
+)
+
+// Example is a named request of a route, RegisterExamplesEndpoint lets authorized users fire it against the handler
+type Example struct {
+	Name        string
+	Description string
+	// Request is the input of the handler, it's sent as the JSON body or as the query of GET routes
+	Request any
+}
+
+// examplesPage is rendered for an operation, Result is set after an example is fired
+type examplesPage struct {
+	OperationID string
+	Routes      []examplesRoute
+	Result      *exampleResult
+}
+
+type examplesRoute struct {
+	Index       int
+	Method      string
+	Path        string
+	Description string
+	Examples    []examplePayload
+}
+
+type examplePayload struct {
+	Name        string
+	Description string
+	Payload     string
+}
+
+type exampleResult struct {
+	Name    string
+	Status  int
+	Headers string
+	Body    string
+}
+
+var examplesTemplate = template.Must(template.New("examples").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.OperationID}} examples</title></head>
+<body>
+<h1>{{.OperationID}}</h1>
+{{range $route := .Routes}}
+<h2>{{.Method}} {{.Path}}</h2>
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+{{range .Examples}}
+<form method="post">
+<h3>{{.Name}}</h3>
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+<input type="hidden" name="route" value="{{$route.Index}}">
+<input type="hidden" name="name" value="{{.Name}}">
+<textarea name="payload" rows="8" cols="80">{{.Payload}}</textarea>
+<p><button type="submit">Send</button></p>
+</form>
+{{end}}
+{{end}}
+{{with .Result}}
+<h2>{{.Name}}: {{.Status}}</h2>
+<pre>{{.Headers}}</pre>
+<pre>{{.Body}}</pre>
+{{end}}
+</body>
+</html>
+`))
+
+var examplesIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>examples</title></head>
+<body>
+<h1>Examples</h1>
+<ul>
+{{range .}}<li><a href="/examples/{{.}}">{{.}}</a></li>
+{{end}}
+</ul>
+</body>
+</html>
+`))
+
+// RegisterExamplesEndpoint serves the declared examples of the routes as HTML forms on GET /examples/{operationId},
+// submitting a form fires the example against the live handler with the headers of the submitting request,
+// e.g. its authorization, and shows the response. GET /examples lists the operations having examples.
+// The endpoint is not a part of the router meta, protect it with the middlewares, e.g. an admin authorization.
+func (r *Router) RegisterExamplesEndpoint(middlewares ...Middleware) {
+	var index http.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var operations []string
+		seen := make(map[string]bool)
+		for _, meta := range r.shared.allRoutes() {
+			if len(meta.Spec.Examples) > 0 && !seen[meta.OperationID] {
+				seen[meta.OperationID] = true
+				operations = append(operations, meta.OperationID)
+			}
+		}
+		writeHTML(w, req, examplesIndexTemplate, operations)
+	})
+	var page http.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		routes := r.exampleRoutes(req.PathValue("operationId"))
+		if len(routes) == 0 {
+			http.NotFound(w, req)
+			return
+		}
+		data := examplesPage{OperationID: req.PathValue("operationId")}
+		for i, meta := range routes {
+			route := examplesRoute{Index: i, Method: meta.Method, Path: meta.Path, Description: meta.Spec.Description}
+			for _, example := range meta.Spec.Examples {
+				payload, err := encodeExample(meta, example.Request)
+				if err != nil {
+					slog.Default().ErrorContext(req.Context(), "failed to encode example", "err", err, "operation", meta.OperationID, "example", example.Name)
+				}
+				route.Examples = append(route.Examples, examplePayload{Name: example.Name, Description: example.Description, Payload: payload})
+			}
+			data.Routes = append(data.Routes, route)
+		}
+
+		if req.Method == http.MethodPost {
+			// browsers tell cross-site form submissions, they must not fire requests with the admin credentials
+			if site := req.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" {
+				http.Error(w, "cross-site request", http.StatusForbidden)
+				return
+			}
+			i, err := strconv.Atoi(req.PostFormValue("route"))
+			if err != nil || i < 0 || i >= len(routes) {
+				http.Error(w, "unknown route", http.StatusBadRequest)
+				return
+			}
+			data.Result = r.fireExample(req, routes[i], req.PostFormValue("payload"))
+			data.Result.Name = req.PostFormValue("name")
+		}
+		writeHTML(w, req, examplesTemplate, data)
+	})
+	for i := range middlewares {
+		index = middlewares[i](index)
+		page = middlewares[i](page)
+	}
+	site := callSite()
+	r.handle("GET /examples", index, nil, site)
+	r.handle("GET /examples/{operationId}", page, nil, site)
+	r.handle("POST /examples/{operationId}", page, nil, site)
+}
+
+// exampleRoutes returns the routes of the operation having examples, subrouters may register the same operation id
+func (r *Router) exampleRoutes(operationID string) []*HandlerMeta {
+	var routes []*HandlerMeta
+	for _, meta := range r.shared.allRoutes() {
+		if meta.OperationID == operationID && len(meta.Spec.Examples) > 0 {
+			routes = append(routes, meta)
+		}
+	}
+	return routes
+}
+
+// fireExample serves the payload by the router as a request to the route
+func (r *Router) fireExample(req *http.Request, meta *HandlerMeta, payload string) *exampleResult {
+	target, body := meta.Path, strings.NewReader(payload)
+	if meta.Method == http.MethodGet {
+		target += "?" + strings.TrimPrefix(strings.TrimSpace(payload), "?")
+		body = strings.NewReader("")
+	}
+	fired, err := http.NewRequestWithContext(req.Context(), meta.Method, target, body)
+	if err != nil {
+		return &exampleResult{Status: http.StatusBadRequest, Body: err.Error()}
+	}
+	fired.Header = req.Header.Clone()
+	fired.Header.Del("Content-Length")
+	fired.Header.Set("Content-Type", "application/json")
+	fired.Host, fired.RemoteAddr = req.Host, req.RemoteAddr
+
+	rec := &bufferedResponse{header: make(http.Header)}
+	r.mux.ServeHTTP(rec, fired)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	headers := &bytes.Buffer{}
+	rec.header.Write(headers)
+	result := &exampleResult{Status: rec.status, Headers: headers.String(), Body: rec.body.String()}
+	if indented := (&bytes.Buffer{}); json.Indent(indented, rec.body.Bytes(), "", "  ") == nil {
+		result.Body = indented.String()
+	}
+	return result
+}
+
+// encodeExample formats the request as the form payload: indented JSON or the query of GET routes
+func encodeExample(meta *HandlerMeta, request any) (string, error) {
+	if meta.Method != http.MethodGet {
+		data, err := json.MarshalIndent(request, "", "  ")
+		return string(data), err
+	}
+	if request == nil {
+		return "", nil
+	}
+	query := url.Values{}
+	if err := newQueryEncoder().Encode(request, query); err != nil {
+		return "", err
+	}
+	return query.Encode(), nil
+}
+
+func newQueryEncoder() *schema.Encoder {
+	encoder := schema.NewEncoder()
+	encoder.RegisterEncoder(time.Time{}, func(v reflect.Value) string {
+		return v.Interface().(time.Time).Format(time.RFC3339)
+	})
+	return encoder
+}
+
+func writeHTML(w http.ResponseWriter, r *http.Request, tpl *template.Template, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tpl.Execute(w, data); err != nil {
+		slog.Default().ErrorContext(r.Context(), "failed to render page", "err", err)
+	}
+}
+
+// bufferedResponse keeps the response of a request served by the router itself, e.g. a fired example
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *bufferedResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *bufferedResponse) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *bufferedResponse) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
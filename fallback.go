@@ -0,0 +1,157 @@
+package vel
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// UpstreamUnavailableCode is a conventional error code of a failed dependency, e.g. to trigger a fallback
+const UpstreamUnavailableCode = "UPSTREAM_UNAVAILABLE"
+
+// Warning header values of the fallback responses
+const (
+	FallbackWarningStale  = `110 - "Response is Stale"`
+	FallbackWarningStatic = `199 - "Fallback Response"`
+)
+
+const defaultFallbackEntries = 1000
+
+// Fallback declares a response served instead of an error,
+// so read endpoints degrade gracefully while a dependency is down.
+// Fallback responses have a Warning header and aren't cached by clients. Zero value disables the fallback.
+type Fallback struct {
+	// Codes lists the error codes triggering the fallback, e.g. UpstreamUnavailableCode
+	Codes []string
+	// Body is the static response encoded as JSON, it's served when no cached response is available
+	Body any
+	// Cached serves the last successful response of the same request, requests are keyed by Spec.Cache.Key.
+	// Only the GET and HEAD requests without credentials, an Authorization header or a cookie, are cached
+	// unless Spec.Cache.VaryHeaders lists them, so a response isn't served to another caller.
+	Cached bool
+	// MaxEntries bounds the cached responses of the route, 1000 if zero
+	MaxEntries int
+}
+
+func (f Fallback) handles(code string) bool {
+	return slices.Contains(f.Codes, code)
+}
+
+// fallbackCache keeps the last successful responses of a route
+type fallbackCache struct {
+	mu      sync.RWMutex
+	entries map[string][]byte
+}
+
+func (c *fallbackCache) get(key string) ([]byte, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	body, ok := c.entries[key]
+	return body, ok
+}
+
+func (c *fallbackCache) set(key string, body []byte, maxEntries int) {
+	if maxEntries <= 0 {
+		maxEntries = defaultFallbackEntries
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string][]byte)
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxEntries {
+		// evict an arbitrary entry, the cache only has to be bounded
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[key] = body
+}
+
+// writeResponse encodes the response to a buffer before writing it, so a failed encoding responds with 500
+// instead of a partial body, a route with a cached fallback keeps a copy of it
+func writeResponse(w http.ResponseWriter, r *http.Request, res any) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	encode, contentType := responseEncoding(r, res)
+	if err := encode(buf, res); err != nil {
+		writeError(w, r, http.StatusInternalServerError, &Error{
+			Code:    "FAILED_ENCODING_RESPONSE_BODY",
+			Message: err.Error(),
+		})
+		return
+	}
+	if rt := routeFromContext(r.Context()); rt != nil && rt.meta != nil && rt.meta.Spec.Fallback.Cached && rt.meta.Spec.Cache.shared(r) {
+		rt.fallback.set(rt.meta.Spec.Cache.Key(r), bytes.Clone(buf.Bytes()), rt.meta.Spec.Fallback.MaxEntries)
+	}
+	// the encoding is negotiated by Accept, a JSON response differs from the one of another Accept as well
+	w.Header().Add("Vary", "Accept")
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		slog.Default().ErrorContext(r.Context(), "failed to write response", "err", err)
+	}
+}
+
+// responseEncoding picks the encoding of the response the request accepts along with its content type, empty for JSON:
+// a protobuf message is protobuf or protojson, anything else is MessagePack or JSON
+func responseEncoding(r *http.Request, res any) (func(*bytes.Buffer, any) error, string) {
+	if isProtoMessage(res) {
+		if acceptsProtobuf(r) {
+			return encodeProto, Protobuf
+		}
+		return encodeProtoJSON, ""
+	}
+	if acceptsMsgPack(r) {
+		return encodeMsgPack, MsgPack
+	}
+	return encodeBody, ""
+}
+
+// writeFallback serves the fallback of the route if the error triggers it, it reports whether the response is written
+func writeFallback(w http.ResponseWriter, r *http.Request, e *Error) bool {
+	rt := routeFromContext(r.Context())
+	if rt == nil || rt.meta == nil || !rt.meta.Spec.Fallback.handles(e.Code) {
+		return false
+	}
+	spec := rt.meta.Spec
+
+	// the fallback is encoded as the output of the route would be
+	encode, contentType := responseEncoding(r, rt.meta.Output)
+	body, ok := []byte(nil), false
+	warning := FallbackWarningStale
+	if spec.Fallback.Cached && spec.Cache.shared(r) {
+		body, ok = rt.fallback.get(spec.Cache.Key(r))
+	}
+	if !ok {
+		if spec.Fallback.Body == nil {
+			return false
+		}
+		buf := new(bytes.Buffer)
+		if err := encode(buf, spec.Fallback.Body); err != nil {
+			slog.Default().ErrorContext(r.Context(), "failed to encode fallback response", "err", err, "code", e.Code)
+			return false
+		}
+		body, warning = buf.Bytes(), FallbackWarningStatic
+	}
+
+	markFallback(r.Context())
+	// the cached body is encoded as the request accepts, see CachePolicy.Key
+	// the encoding is negotiated by Accept, a JSON response differs from the one of another Accept as well
+	w.Header().Add("Vary", "Accept")
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Warning", warning)
+	w.Header().Set("Cache-Control", "no-store")
+	if _, err := w.Write(body); err != nil {
+		slog.Default().ErrorContext(r.Context(), "failed to write fallback response", "err", err, "code", e.Code)
+	}
+	return true
+}
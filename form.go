@@ -0,0 +1,29 @@
+package vel
+
+import (
+	"mime"
+	"net/http"
+)
+
+// FormURLEncoded is the content type of the form bodies: a route declaring it in Spec.RequestContentType
+// decodes the form bodies into its input by the schema tags like the query of a GET route,
+// the requests of another content type are decoded as JSON
+const FormURLEncoded = "application/x-www-form-urlencoded"
+
+// isForm reports whether the request sends a form the route accepts
+func isForm(r *http.Request) bool {
+	meta := MetaFromContext(r.Context())
+	if meta == nil || meta.Spec.RequestContentType != FormURLEncoded {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == FormURLEncoded
+}
+
+// decodeForm decodes the form of the body into the input, the query of the url is left out
+func decodeForm(r *http.Request, decoder *queryDecoder, v any) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	return uuidQueryError(decoder.Decode(v, r.PostForm))
+}
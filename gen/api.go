@@ -11,15 +11,41 @@ import (
 
 // ClientGeneratorConfig holds configuration for generating API clients
 type ClientGeneratorConfig struct {
-	TypeName    string
-	PackageName string
-	OutputDir   string
-	Language    string // "go" or "ts"
-	PostProcess string // e.g., "goimports" or "prettier"
+	TypeName    string `yaml:"typeName"`
+	PackageName string `yaml:"packageName"`
+	OutputDir   string `yaml:"outputDir"`
+	Language    string `yaml:"language"`    // "go" or "ts"
+	PostProcess string `yaml:"postProcess"` // e.g., "goimports" or "prettier --parser typescript", see PostProcessorOf
+	// PostProcessor formats the output in-process, it takes precedence over PostProcess
+	PostProcessor PostProcessor `yaml:"-"`
+	// Zod generates Zod schemas in the TS client to validate responses at runtime
+	Zod bool `yaml:"zod"`
+	// CheckResponses makes the TS client check the responses against the Zod schemas at runtime without failing the calls,
+	// a drift is logged to the console as a ResponseShapeError, it implies Zod
+	CheckResponses bool `yaml:"checkResponses"`
+	// MultiFile splits the client into types, errors and client files under OutputDir
+	MultiFile bool `yaml:"multiFile"`
+	// SkipUnchanged formats and writes only the files whose code has changed since the previous generation,
+	// see GenerateFilesSkipUnchanged
+	SkipUnchanged bool `yaml:"skipUnchanged"`
+	// Batch generates the calls of the batch endpoint, the router must register it, see vel.Router.RegisterBatchEndpoint
+	Batch bool `yaml:"batch"`
+	// MsgPack makes the Go client send the request bodies as MessagePack and accept the responses in it,
+	// a JSON response such as an error is still decoded, see vel.MsgPack
+	MsgPack bool `yaml:"msgpack"`
 }
 
 // GenerateClientToFile generates an API client and writes it to a file
 func GenerateClientToFile(router *vel.Router, config ClientGeneratorConfig) error {
+	generator, err := newClientGen(router, config)
+	if err != nil {
+		return err
+	}
+	return writeClient(generator, config)
+}
+
+// writeClient writes the generated client to the output directory of the config
+func writeClient(generator *ClientGen, config ClientGeneratorConfig) error {
 	// Determine file extension and template
 	var filename string
 	switch config.Language {
@@ -31,6 +57,18 @@ func GenerateClientToFile(router *vel.Router, config ClientGeneratorConfig) erro
 		return fmt.Errorf("language %s is not supported", config.Language)
 	}
 
+	if config.SkipUnchanged {
+		files := []struct{ name, part string }{{filename, ""}}
+		if config.MultiFile {
+			files = clientFiles[config.Language]
+		}
+		_, err := generator.writeChanged(config.OutputDir, config.Language, files, config.postProcessor())
+		return err
+	}
+	if config.MultiFile {
+		return generator.GenerateFilesWith(config.OutputDir, config.Language, config.postProcessor())
+	}
+
 	if err := os.MkdirAll(config.OutputDir, 0755); err != nil {
 		return err
 	}
@@ -42,16 +80,19 @@ func GenerateClientToFile(router *vel.Router, config ClientGeneratorConfig) erro
 	}
 	defer file.Close()
 
-	return GenerateClient(router, file, config)
+	return generator.GenerateWith(file, config.Language+":default", config.postProcessor())
+}
+
+func (c ClientGeneratorConfig) postProcessor() PostProcessor {
+	if c.PostProcessor != nil {
+		return c.PostProcessor
+	}
+	return PostProcessorOf(c.PostProcess)
 }
 
 // GenerateClient generates an API client and writes it to the provided writer
 func GenerateClient(router *vel.Router, w io.Writer, config ClientGeneratorConfig) error {
-	// Create generator
-	generator, err := New(ClientDesc{
-		TypeName:    config.TypeName,
-		PackageName: config.PackageName,
-	}, router.Meta())
+	generator, err := newClientGen(router, config)
 	if err != nil {
 		return err
 	}
@@ -68,7 +109,34 @@ func GenerateClient(router *vel.Router, w io.Writer, config ClientGeneratorConfi
 	}
 
 	// Generate client code
-	return generator.Generate(w, template, config.PostProcess)
+	return generator.GenerateWith(w, template, config.postProcessor())
+}
+
+func newClientGen(router *vel.Router, config ClientGeneratorConfig) (*ClientGen, error) {
+	return newRouterGen(router, clientDesc(router, config))
+}
+
+// newRouterGen makes a generator of the handlers of the router and its subrouters
+func newRouterGen(router *vel.Router, desc ClientDesc) (*ClientGen, error) {
+	meta, apis, groups, err := routerApis(router)
+	if err != nil {
+		return nil, err
+	}
+	g := assemble(desc, meta, apis, groups, specHash(apis))
+	g.webhooks = router.Webhooks()
+	return g, nil
+}
+
+func clientDesc(router *vel.Router, config ClientGeneratorConfig) ClientDesc {
+	return ClientDesc{
+		TypeName:       config.TypeName,
+		PackageName:    config.PackageName,
+		ErrorSchema:    router.ErrorEncoder().Schema(),
+		Zod:            config.Zod || config.CheckResponses,
+		CheckResponses: config.CheckResponses,
+		Batch:          config.Batch,
+		MsgPack:        config.MsgPack,
+	}
 }
 
 // GenerateOpenAPIToFile generates an OpenAPI specification and writes it to a file
@@ -83,12 +151,63 @@ func GenerateOpenAPIToFile(router *vel.Router, outputPath, title, version string
 }
 
 func GenerateOpenAPI(router *vel.Router, w io.Writer, title, version string) error {
-	generator, err := New(ClientDesc{
+	return GenerateOpenAPIWithConfig(router, w, OpenAPIConfig{Title: title, Version: version})
+}
+
+// OpenAPIConfig holds configuration for generating an OpenAPI specification
+type OpenAPIConfig struct {
+	Title   string `yaml:"title"`
+	Version string `yaml:"version"`
+	// OperationIDCase converts the operation ids, e.g. for codegen tools requiring snake_case,
+	// the generation fails if different ids become the same one
+	OperationIDCase OperationIDCase `yaml:"operationIdCase"`
+	// CodeSamplesURL attaches curl and HTTPie calls of the url to every operation as x-codeSamples, e.g. http://localhost:8080
+	CodeSamplesURL string `yaml:"codeSamplesUrl"`
+	// Examples attaches an example request and response to every operation, the declared examples of a spec come first,
+	// the others are built by Example
+	Examples bool `yaml:"examples"`
+}
+
+// GenerateOpenAPIWithConfig generates an OpenAPI specification and writes it to the provided writer
+func GenerateOpenAPIWithConfig(router *vel.Router, w io.Writer, config OpenAPIConfig) error {
+	generator, err := newRouterGen(router, ClientDesc{
+		TypeName:        "Client",
+		PackageName:     "client",
+		ErrorSchema:     router.ErrorEncoder().Schema(),
+		OperationIDCase: config.OperationIDCase,
+		CodeSamplesURL:  config.CodeSamplesURL,
+		Examples:        config.Examples,
+	})
+	if err != nil {
+		return err
+	}
+	return generator.GenerateOpenAPIYAML(w, config.Title, config.Version)
+}
+
+// GenerateHeaders generates a Go package with a constant for every header declared in the router specs,
+// so handlers and middlewares don't need to repeat the header names
+func GenerateHeaders(router *vel.Router, w io.Writer, packageName string) error {
+	generator, err := newRouterGen(router, ClientDesc{
 		TypeName:    "Client",
-		PackageName: "client",
-	}, router.Meta())
+		PackageName: packageName,
+	})
 	if err != nil {
 		return err
 	}
-	return generator.GenerateOpenAPIYAML(w, title, version)
+	return generator.Generate(w, "go:headers", "")
+}
+
+// GenerateHeadersToFile generates the headers package and writes it to headers.go in the output directory
+func GenerateHeadersToFile(router *vel.Router, outputDir, packageName string) error {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filepath.Join(outputDir, "headers.go"), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return GenerateHeaders(router, file, packageName)
 }
@@ -0,0 +1,70 @@
+package gen
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// OperationIDCase converts the operation ids in the OpenAPI output,
+// the registered ids stay the source of truth for the routes and the clients
+type OperationIDCase string
+
+const (
+	// OperationIDAsRegistered keeps the registered operation ids
+	OperationIDAsRegistered OperationIDCase = ""
+	// OperationIDCamel converts list_users to listUsers
+	OperationIDCamel OperationIDCase = "camel"
+	// OperationIDSnake converts listUsers to list_users
+	OperationIDSnake OperationIDCase = "snake"
+)
+
+// Apply converts the operation id to the case
+func (c OperationIDCase) Apply(id string) (string, error) {
+	switch c {
+	case OperationIDAsRegistered:
+		return id, nil
+	case OperationIDCamel:
+		words := splitWords(id)
+		for i := range words {
+			if i > 0 {
+				words[i] = Capitalize(words[i])
+			}
+		}
+		return strings.Join(words, ""), nil
+	case OperationIDSnake:
+		return strings.Join(splitWords(id), "_"), nil
+	default:
+		return "", fmt.Errorf("operation id case %s is not supported", c)
+	}
+}
+
+// splitWords splits an identifier to lower case words by separators and case changes,
+// an acronym is a single word, e.g. getHTTPStatus becomes get, http, status
+func splitWords(s string) []string {
+	var words []string
+	var word []rune
+	runes := []rune(s)
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, strings.ToLower(string(word)))
+			word = word[:0]
+		}
+	}
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+		if unicode.IsUpper(r) && len(word) > 0 {
+			prev := word[len(word)-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if !unicode.IsUpper(prev) || nextLower {
+				flush()
+			}
+		}
+		word = append(word, r)
+	}
+	flush()
+	return words
+}
@@ -0,0 +1,252 @@
+package gen
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dennypenta/vel"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConfigPath is the config file the vel command reads by default
+const DefaultConfigPath = "vel.yaml"
+
+// Config is the config file of the vel command, e.g.
+//
+//	router: github.com/me/app/api.NewRouter
+//	clients:
+//	  - language: ts
+//	    outputDir: ./sdk
+//	openapi:
+//	  output: ./openapi.yaml
+//	  title: My API
+//	  version: 1.0.0
+//	postman:
+//	  output: ./api.postman_collection.json
+//	  name: My API
+//	  authHeaders: [Authorization]
+//	contract:
+//	  output: ./api/contract_test.go
+//	json:
+//	  output: ./api/json.gen.go
+type Config struct {
+	// Router is the function constructing the router: an import path or a directory of the module followed by its name
+	Router  string                  `yaml:"router"`
+	Clients []ClientGeneratorConfig `yaml:"clients"`
+	OpenAPI OpenAPIFileConfig       `yaml:"openapi"`
+	Postman PostmanFileConfig       `yaml:"postman"`
+	// Contract is the test file of the router package checking the responses against the spec
+	Contract ContractFileConfig `yaml:"contract"`
+	// JSON is the file of the handler types package holding their generated JSON methods
+	JSON JSONFileConfig `yaml:"json"`
+}
+
+type OpenAPIFileConfig struct {
+	Output        string `yaml:"output"`
+	OpenAPIConfig `yaml:",inline"`
+}
+
+type PostmanFileConfig struct {
+	Output        string `yaml:"output"`
+	PostmanConfig `yaml:",inline"`
+}
+
+type ContractFileConfig struct {
+	Output         string `yaml:"output"`
+	ContractConfig `yaml:",inline"`
+}
+
+type JSONFileConfig struct {
+	Output     string `yaml:"output"`
+	JSONConfig `yaml:",inline"`
+}
+
+// LoadConfig reads the config file, the clients get the default type and package names
+// and the contract tests call the router constructor
+func LoadConfig(path string) (Config, error) {
+	var config Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if config.Router == "" {
+		return config, fmt.Errorf("%s: router is not set", path)
+	}
+
+	if config.Contract.Constructor == "" {
+		// the contract tests call the constructor of the config
+		config.Contract.Constructor = config.Router[strings.LastIndex(config.Router, ".")+1:]
+	}
+	for i := range config.Clients {
+		if config.Clients[i].TypeName == "" {
+			config.Clients[i].TypeName = "Client"
+		}
+		if config.Clients[i].PackageName == "" {
+			config.Clients[i].PackageName = "client"
+		}
+	}
+	return config, nil
+}
+
+// Main runs a command of the vel tool against the router, the vel command calls it with its arguments:
+//
+//	gen [client|openapi|postman|contract|json]  generates the clients, the spec, the collection, the contract tests and the JSON methods declared in the config
+//	routes                                      prints the routes of the router
+func Main(router *vel.Router, args []string) error {
+	return runCommand(router, args, os.Stdout)
+}
+
+func runCommand(router *vel.Router, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("vel", flag.ContinueOnError)
+	configPath := flags.String("config", DefaultConfigPath, "path to the config file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+	if len(args) == 0 {
+		return errors.New("command is required: gen or routes")
+	}
+
+	switch args[0] {
+	case "routes":
+		return printRoutes(router, stdout)
+	case "gen":
+		config, err := LoadConfig(*configPath)
+		if err != nil {
+			return err
+		}
+		return runGen(router, config, args[1:])
+	default:
+		return fmt.Errorf("unknown command %s", args[0])
+	}
+}
+
+func runGen(router *vel.Router, config Config, args []string) error {
+	target := ""
+	if len(args) > 0 && (args[0] == "client" || args[0] == "openapi" || args[0] == "postman" || args[0] == "contract" || args[0] == "json") {
+		target, args = args[0], args[1:]
+	}
+
+	flags := flag.NewFlagSet("gen", flag.ContinueOnError)
+	lang := flags.String("lang", "", "generate the clients of the language only")
+	out := flags.String("out", "", "output directory of the clients or the spec path")
+	skipUnchanged := flags.Bool("skip-unchanged", false, "format and write only the changed client files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if target == "" || target == "client" {
+		generated := false
+		for _, client := range config.Clients {
+			if *lang != "" && client.Language != *lang {
+				continue
+			}
+			if *out != "" && target == "client" {
+				client.OutputDir = *out
+			}
+			if *skipUnchanged {
+				client.SkipUnchanged = true
+			}
+			if err := GenerateClientToFile(router, client); err != nil {
+				return fmt.Errorf("%s client: %w", client.Language, err)
+			}
+			generated = true
+		}
+		if target == "client" && !generated {
+			return fmt.Errorf("no %s client is declared in the config", *lang)
+		}
+	}
+
+	if target == "" || target == "openapi" {
+		spec := config.OpenAPI
+		if *out != "" && target == "openapi" {
+			spec.Output = *out
+		}
+		if spec.Output == "" && target == "openapi" {
+			return errors.New("openapi output is not set")
+		}
+		if spec.Output != "" {
+			if err := writeOpenAPISpec(router, spec); err != nil {
+				return fmt.Errorf("openapi: %w", err)
+			}
+		}
+	}
+
+	if target == "" || target == "postman" {
+		collection := config.Postman
+		if *out != "" && target == "postman" {
+			collection.Output = *out
+		}
+		if collection.Output == "" && target == "postman" {
+			return errors.New("postman output is not set")
+		}
+		if collection.Output != "" {
+			if err := GeneratePostmanToFile(router, collection.Output, collection.PostmanConfig); err != nil {
+				return fmt.Errorf("postman: %w", err)
+			}
+		}
+	}
+
+	if target == "" || target == "contract" {
+		contract := config.Contract
+		if *out != "" && target == "contract" {
+			contract.Output = *out
+		}
+		if contract.Output == "" && target == "contract" {
+			return errors.New("contract output is not set")
+		}
+		if contract.Output != "" {
+			if err := GenerateContractToFile(router, contract.Output, contract.ContractConfig); err != nil {
+				return fmt.Errorf("contract: %w", err)
+			}
+		}
+	}
+
+	if target == "" || target == "json" {
+		methods := config.JSON
+		if *out != "" && target == "json" {
+			methods.Output = *out
+		}
+		if methods.Output == "" && target == "json" {
+			return errors.New("json output is not set")
+		}
+		if methods.Output != "" {
+			if err := GenerateJSONToFile(router, methods.Output, methods.JSONConfig); err != nil {
+				return fmt.Errorf("json: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func writeOpenAPISpec(router *vel.Router, spec OpenAPIFileConfig) error {
+	if err := os.MkdirAll(filepath.Dir(spec.Output), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(spec.Output, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return GenerateOpenAPIWithConfig(router, file, spec.OpenAPIConfig)
+}
+
+func printRoutes(router *vel.Router, stdout io.Writer) error {
+	routes := &routerRoutes{}
+	routes.walk(router, router.Prefix(), nil)
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tPATH\tOPERATION")
+	for _, m := range routes.meta {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", m.Method, m.Path, m.OperationID)
+	}
+	return w.Flush()
+}
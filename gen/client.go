@@ -2,106 +2,415 @@ package gen
 
 import (
 	"bytes"
+	"cmp"
 	_ "embed"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"net/http"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"text/template"
 	"unicode"
 
 	"github.com/dennypenta/vel"
 	"gopkg.in/yaml.v3"
 )
 
+var validatorType = reflect.TypeFor[vel.Validator]()
+
 var ErrorInlineStructForbidden = errors.New("inlined structs are forbidden to use, declare an explicit type")
 
+// NameInlineStructs names the anonymous structs instead of failing with ErrorInlineStructForbidden:
+// the struct of a field is named by its parent type and the field, e.g. HelloRequestAddress,
+// the input and the output of a handler by the operation, e.g. HelloRequest and HelloResponse.
+// Set it before generating.
+var NameInlineStructs = false
+
 // ClientGen defines api client generator
 // it doesn't support the following:
 // - anonymous nested struct
 type ClientGen struct {
 	meta ApiClientDesc
+	// webhooks are the webhooks documented on the router, see vel.Router.RegisterWebhook
+	webhooks []vel.WebhookMeta
 }
 
 func New(clientDesc ClientDesc, meta []vel.HandlerMeta) (*ClientGen, error) {
-	// Pre-calculate client description values
-	clientDesc.TypeNameLower = strings.ToLower(clientDesc.TypeName)
+	apis, err := extractApis(meta)
+	if err != nil {
+		return nil, err
+	}
+	return assemble(clientDesc, meta, apis, nil, specHash(apis)), nil
+}
 
+// extractApis describes every handler with all the data types it needs,
+// the result may be shared by generators of different subsets of the handlers.
+// The handlers are described in parallel, see Parallelism.
+func extractApis(meta []vel.HandlerMeta) ([]ApiDesc, error) {
 	desc := make([]ApiDesc, len(meta))
-	dataTypeSet := make(map[string]struct{}, len(meta)*2)
+	err := parallel(len(meta), func(i int) error {
+		var err error
+		desc[i], err = extractApi(meta[i])
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return desc, nil
+}
 
-	var err error
-	for i := range meta {
-		dataTypes := make([]DataType, 0, len(meta)*2)
-		desc[i], err = makeApiDesc(meta[i])
+// extractApi describes the handler and collects the data types of its input and output
+func extractApi(meta vel.HandlerMeta) (ApiDesc, error) {
+	desc, err := makeApiDesc(meta)
+	if err != nil {
+		return desc, err
+	}
+
+	dataTypeSet := make(map[string]struct{})
+	dataTypes := make([]DataType, 0)
+	name := desc.Input.Name
+	if _, ok := dataTypeSet[name]; !ok && len(desc.Input.Fields) > 0 {
+		dataTypes = append(dataTypes, desc.Input)
+		dataTypeSet[name] = struct{}{}
+	}
+	name = desc.Output.Name
+	if _, ok := dataTypeSet[name]; !ok && len(desc.Output.Fields) > 0 {
+		dataTypes = append(dataTypes, desc.Output)
+		dataTypeSet[name] = struct{}{}
+	}
+
+	for j := range desc.Input.Fields {
+		types, err := collectStructs(desc.Input.Fields[j], dataTypeSet)
 		if err != nil {
-			return nil, err
+			return desc, err
 		}
+		dataTypes = append(dataTypes, types...)
+	}
+	for j := range desc.Output.Fields {
+		types, err := collectStructs(desc.Output.Fields[j], dataTypeSet)
+		if err != nil {
+			return desc, err
+		}
+		dataTypes = append(dataTypes, types...)
+	}
 
-		name := desc[i].Input.Name
-		if _, ok := dataTypeSet[name]; !ok && len(desc[i].Input.Fields) > 0 {
-			dataTypes = append(dataTypes, desc[i].Input)
-			dataTypeSet[name] = struct{}{}
+	desc.DataTypes = dataTypes
+	return desc, nil
+}
+
+// assemble makes a generator of the extracted apis,
+// a data type is generated along with the first api using it.
+// The apis are sorted by the operation ids and the data types of an api by the names,
+// so the output doesn't depend on the registration order and reordering the handlers keeps the diff empty.
+// The spec hash is of all the apis of the router, not only the extracted ones.
+func assemble(clientDesc ClientDesc, meta []vel.HandlerMeta, extracted []ApiDesc, groups [][]string, hash string) *ClientGen {
+	// Pre-calculate client description values
+	clientDesc.TypeNameLower = strings.ToLower(clientDesc.TypeName)
+
+	desc := slices.Clone(extracted)
+	slices.SortStableFunc(desc, func(a, b ApiDesc) int {
+		return cmp.Or(strings.Compare(a.OperationID, b.OperationID), strings.Compare(a.Path, b.Path), strings.Compare(a.Method, b.Method),
+			strings.Compare(a.Version, b.Version))
+	})
+	supersedeVersions(desc)
+	dataTypeSet := make(map[string]struct{}, len(extracted)*2)
+	for i := range desc {
+		dataTypes := desc[i].DataTypes
+		desc[i].DataTypes = make([]DataType, 0, len(dataTypes))
+		for _, dataType := range dataTypes {
+			if _, ok := dataTypeSet[dataType.Name]; ok {
+				continue
+			}
+			dataTypeSet[dataType.Name] = struct{}{}
+			desc[i].DataTypes = append(desc[i].DataTypes, dataType)
 		}
-		name = desc[i].Output.Name
-		if _, ok := dataTypeSet[name]; !ok && len(desc[i].Output.Fields) > 0 {
-			dataTypes = append(dataTypes, desc[i].Output)
-			dataTypeSet[name] = struct{}{}
+		slices.SortStableFunc(desc[i].DataTypes, func(a, b DataType) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+		desc[i].Receiver = clientDesc.TypeName + strings.Join(desc[i].Group, "")
+		desc[i].ErrorTypeName = strings.Join(desc[i].Group, "") + desc[i].FuncName + "Error"
+	}
+
+	typeRefs, schemaRefs := clientRefs(desc)
+	unions := slices.ContainsFunc(desc, func(api ApiDesc) bool {
+		return slices.ContainsFunc(api.DataTypes, func(dataType DataType) bool { return dataType.Union != nil })
+	})
+	return &ClientGen{
+		meta: ApiClientDesc{
+			Client:           clientDesc,
+			Apis:             desc,
+			Groups:           makeGroupDescs(clientDesc.TypeName, groups, desc),
+			Headers:          collectHeaders(meta),
+			ErrorShape:       makeErrorShape(clientDesc.ErrorSchema),
+			ClientTypeRefs:   typeRefs,
+			ClientSchemaRefs: schemaRefs,
+			SpecHash:         hash,
+			Imports:          collectImports(desc),
+			Streams:          slices.ContainsFunc(desc, func(api ApiDesc) bool { return api.Spec.Stream != "" }),
+			Downloads:        slices.ContainsFunc(desc, func(api ApiDesc) bool { return api.RawOutput }),
+			Uploads:          slices.ContainsFunc(desc, func(api ApiDesc) bool { return api.InputItems }),
+			Async:            slices.ContainsFunc(desc, func(api ApiDesc) bool { return api.OperationsPath != "" }),
+			Unions:           unions,
+			CodeErrors:       collectCodeErrors(desc),
+		},
+	}
+}
+
+// supersedeVersions marks the versions of the sorted apis followed by a later version of the same operation,
+// the latest version keeps the method name, the others are suffixed by their versions, e.g. CreateUserV20240101
+func supersedeVersions(apis []ApiDesc) {
+	for i := 0; i+1 < len(apis); i++ {
+		api, next := apis[i], apis[i+1]
+		if api.Version != "" && next.Version != "" && api.OperationID == next.OperationID && api.Path == next.Path && api.Method == next.Method {
+			apis[i].Superseded = true
+			apis[i].FuncName += versionSuffix(api.Version)
 		}
+	}
+}
 
-		for j := range desc[i].Input.Fields {
-			types, err := collectStructs(desc[i].Input.Fields[j], dataTypeSet)
-			if err != nil {
-				return nil, err
+// versionSuffix makes an identifier suffix of the version keeping its letters and digits
+func versionSuffix(version string) string {
+	return "V" + strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, version)
+}
+
+// clientRefs lists the types and the Zod schemas the TS client refers to, it imports them from the types file
+func clientRefs(apis []ApiDesc) ([]string, []string) {
+	typeRefs := []string{"ApiErrorPayload", "Result"}
+	var schemaRefs []string
+	for _, api := range apis {
+		if api.Input.Name != "" && !api.RawInput && !slices.Contains(typeRefs, api.Input.Name) {
+			typeRefs = append(typeRefs, api.Input.Name)
+		}
+		if api.Output.Name != "" && !api.RawOutput && !slices.Contains(typeRefs, api.Output.Name) {
+			typeRefs = append(typeRefs, api.Output.Name)
+		}
+		if api.Output.Name != "" && !api.RawOutput && !slices.Contains(schemaRefs, api.Output.Name+"Schema") {
+			schemaRefs = append(schemaRefs, api.Output.Name+"Schema")
+		}
+		if len(api.Errors) > 0 {
+			typeRefs = append(typeRefs, api.ErrorTypeName)
+		}
+	}
+	return typeRefs, schemaRefs
+}
+
+// BatchApis returns the apis a batch may call: the ones neither streaming, async, versioned, raw nor taking items with an operation id unique across the groups,
+// the server can't tell the others apart
+func (d ApiClientDesc) BatchApis() []ApiDesc {
+	var apis []ApiDesc
+	for _, api := range d.Apis {
+		unique := !slices.ContainsFunc(d.Apis, func(other ApiDesc) bool {
+			return other.OperationID == api.OperationID && (other.Path != api.Path || other.Method != api.Method)
+		})
+		if unique && api.Spec.Stream == "" && api.OperationsPath == "" && api.Version == "" && !api.RawInput && !api.RawOutput && !api.InputItems && !api.ProtoInput && !api.ProtoOutput {
+			apis = append(apis, api)
+		}
+	}
+	return apis
+}
+
+// collectImports lists the packages of the mapped types used by the data types of the apis, sorted to keep the output stable
+func collectImports(apis []ApiDesc) []string {
+	var imports []string
+	for _, api := range apis {
+		for _, dataType := range api.DataTypes {
+			for _, field := range dataType.Fields {
+				for _, t := range leafTypes(field.Type) {
+					if mapping, ok := mappingOf(t); ok && mapping.GoImport != "" && !slices.Contains(imports, mapping.GoImport) {
+						imports = append(imports, mapping.GoImport)
+					}
+				}
 			}
-			dataTypes = append(dataTypes, types...)
 		}
-		for j := range desc[i].Output.Fields {
-			types, err := collectStructs(desc[i].Output.Fields[j], dataTypeSet)
-			if err != nil {
-				return nil, err
+	}
+	slices.Sort(imports)
+	return imports
+}
+
+func makeErrorShape(schema vel.ErrorSchema) ErrorShape {
+	schema = schema.WithDefaults()
+	shape := ErrorShape{
+		Envelope:        schema.Envelope,
+		CodeField:       schema.CodeField,
+		MessageField:    schema.MessageField,
+		MetaField:       schema.MetaField,
+		ViolationsField: schema.ViolationsField,
+		Rules:           vel.Rules,
+	}
+	for _, extra := range schema.Extra {
+		goType := primitiveGoType(extra.ValueType)
+		shape.Extra = append(shape.Extra, ErrorExtraField{
+			Name:   Capitalize(extra.Key),
+			Key:    extra.Key,
+			GoType: goType,
+			TSType: toTSType(goType, nil),
+			Spec:   extra,
+		})
+	}
+	return shape
+}
+
+func primitiveGoType(t vel.PrimitiveType) string {
+	switch t {
+	case vel.Bool, vel.Int, vel.Uint, vel.Float64:
+		return string(t)
+	default:
+		return "string"
+	}
+}
+
+// collectHeaders gathers every request and response header declared in the specs,
+// the result is sorted by the constant name to keep the output stable.
+func collectHeaders(meta []vel.HandlerMeta) []HeaderDesc {
+	headers := make([]HeaderDesc, 0)
+	seen := make(map[string]struct{})
+	for i := range meta {
+		for _, h := range append(meta[i].RequestHeaders(), meta[i].Spec.ResponseHeaders) {
+			if h.Key == "" {
+				continue
 			}
-			dataTypes = append(dataTypes, types...)
+			name := HeaderConstName(h.Key)
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			headers = append(headers, HeaderDesc{
+				Name:        name,
+				Key:         h.Key,
+				Description: h.Description,
+			})
 		}
+	}
+	slices.SortFunc(headers, func(a, b HeaderDesc) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return headers
+}
 
-		desc[i].DataTypes = dataTypes
+// HeaderConstName converts a header key to a Go constant name,
+// e.g. X-API-Key becomes HeaderXAPIKey.
+func HeaderConstName(key string) string {
+	parts := strings.FieldsFunc(key, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var b strings.Builder
+	b.WriteString("Header")
+	for _, part := range parts {
+		b.WriteString(Capitalize(part))
 	}
-	return &ClientGen{
-		meta: ApiClientDesc{
-			Client: clientDesc,
-			Apis:   desc,
-		},
-	}, nil
+	return b.String()
 }
 
 func collectStructs(field Field, dataTypeSet map[string]struct{}) ([]DataType, error) {
 	dataTypes := make([]DataType, 0)
 
-	if field.Type.Kind() == reflect.Slice || field.Type.Kind() == reflect.Map {
-		field.Type = field.Type.Elem()
+	for _, t := range leafTypes(field.Type) {
+		if primitive, ok := namedPrimitive(t); ok {
+			if _, ok := dataTypeSet[primitive.Name]; !ok {
+				dataTypeSet[primitive.Name] = struct{}{}
+				dataTypes = append(dataTypes, primitive)
+			}
+			continue
+		}
+		if t.Kind() == reflect.Struct {
+			field.Type, field.TypeName = t, goTypeName(t, field.inline)
+			subTypes, err := collectTypes(field, dataTypeSet)
+			if err != nil {
+				return nil, err
+			}
+			dataTypes = append(dataTypes, subTypes...)
+		}
+	}
+
+	return dataTypes, nil
+}
+
+// leafTypes unwraps pointers, slices and maps down to the types of their keys and elements,
+// e.g. map[UserID][]*Item gives UserID and Item
+func leafTypes(t reflect.Type) []reflect.Type {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice:
+		return leafTypes(t.Elem())
+	case reflect.Map:
+		return append(leafTypes(t.Key()), leafTypes(t.Elem())...)
 	}
-	if field.Type.Kind() == reflect.Pointer {
-		field.Type = field.Type.Elem()
+	return []reflect.Type{t}
+}
+
+// namedPrimitive describes a defined type over a primitive, e.g. type UserID string, once, see typeCache
+func namedPrimitive(t reflect.Type) (DataType, bool) {
+	return typeCache.primitiveType(t)
+}
+
+func describePrimitive(t reflect.Type) (DataType, bool) {
+	if t.PkgPath() == "" || !isPrimitiveKind(t.Kind()) {
+		return DataType{}, false
 	}
-	if field.Type.Kind() == reflect.Struct {
-		subTypes, err := collectTypes(field, dataTypeSet)
-		if err != nil {
-			return nil, err
+	if _, ok := mappingOf(t); ok {
+		return DataType{}, false
+	}
+
+	primitive, enum := t.Kind().String(), enumValues(t)
+	// protojson encodes a protobuf enum by the names of its values
+	if values, ok := protoEnumValues(t); ok {
+		primitive, enum = "string", values
+	}
+	dataType := DataType{
+		Name:      typeName(t),
+		Primitive: primitive,
+		TSType:    toTSType(primitive, nil) + " & { readonly __brand: '" + typeName(t) + "' }",
+		ZodType:   toZodType(primitive, nil) + ".brand<'" + typeName(t) + "'>()",
+		Enum:      enum,
+	}
+	if len(dataType.Enum) > 0 {
+		literals := make([]string, len(dataType.Enum))
+		for i, value := range dataType.Enum {
+			literals[i] = value.Literal
+			if primitive == "string" {
+				literals[i] = "'" + strings.ReplaceAll(value.Value.(string), "'", "\\'") + "'"
+			}
+		}
+		dataType.TSType = strings.Join(literals, " | ")
+		switch {
+		case primitive == "string":
+			dataType.ZodType = "z.enum([" + strings.Join(literals, ", ") + "])"
+		case len(literals) == 1:
+			dataType.ZodType = "z.literal(" + literals[0] + ")"
+		default:
+			dataType.ZodType = "z.union([z.literal(" + strings.Join(literals, "), z.literal(") + ")])"
 		}
-		dataTypes = append(dataTypes, subTypes...)
 	}
+	return dataType, true
+}
 
-	return dataTypes, nil
+func isPrimitiveKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
 }
 
 func collectTypes(field Field, dataTypeSet map[string]struct{}) ([]DataType, error) {
-	if _, ok := builtinTypes[field.TypeName]; ok {
+	if _, ok := mappingOf(field.Type); ok {
 		return nil, nil
 	}
 	dataTypes := make([]DataType, 0)
-	subType, err := extractDataType(field.Type)
+	subType, err := extractDataType(field.Type, field.inline)
 	if err != nil {
 		return nil, err
 	}
@@ -115,28 +424,11 @@ func collectTypes(field Field, dataTypeSet map[string]struct{}) ([]DataType, err
 			if subField.IsBuilting {
 				continue
 			}
-			if subField.Type.Kind() == reflect.Struct {
-				subTypes, err := collectTypes(subField, dataTypeSet)
-				if err != nil {
-					return nil, err
-				}
-
-				dataTypes = append(dataTypes, subTypes...)
-			}
-			if subField.Type.Kind() == reflect.Slice || subField.Type.Kind() == reflect.Map || subField.Type.Kind() == reflect.Pointer {
-				if subField.Type.Elem().Kind() == reflect.Pointer {
-					subField.Type = subField.Type.Elem()
-				}
-				if subField.Type.Elem().Kind() == reflect.Struct {
-					subField.Type = subField.Type.Elem()
-					subTypes, err := collectTypes(subField, dataTypeSet)
-					if err != nil {
-						return nil, err
-					}
-
-					dataTypes = append(dataTypes, subTypes...)
-				}
+			subTypes, err := collectStructs(subField, dataTypeSet)
+			if err != nil {
+				return nil, err
 			}
+			dataTypes = append(dataTypes, subTypes...)
 		}
 	}
 
@@ -145,66 +437,443 @@ func collectTypes(field Field, dataTypeSet map[string]struct{}) ([]DataType, err
 
 func makeApiDesc(meta vel.HandlerMeta) (ApiDesc, error) {
 	inputReflectType := reflect.TypeOf(meta.Input)
-	inputType, err := extractDataType(inputReflectType)
-	if err != nil {
-		return ApiDesc{}, err
-	}
 	outputReflectType := reflect.TypeOf(meta.Output)
-	outputType, err := extractDataType(outputReflectType)
-	if err != nil {
-		return ApiDesc{}, err
+	rawInput, rawOutput := vel.IsRawBody(inputReflectType), vel.IsRawBody(outputReflectType)
+	if rawInput && meta.Method == "GET" {
+		return ApiDesc{}, fmt.Errorf("%s takes the raw body, a GET request has none", meta.OperationID)
+	}
+	if (rawInput || rawOutput) && (meta.Spec.Stream != "" || meta.OperationsPath() != "") {
+		return ApiDesc{}, fmt.Errorf("%s passes a raw body, it can't stream nor run async", meta.OperationID)
+	}
+	if vel.IsOneOf(inputReflectType) {
+		return ApiDesc{}, fmt.Errorf("%s takes a OneOf, only an output may vary by kind", meta.OperationID)
+	}
+	// the clients send a sequence of the items of an items input, it's described by the item type
+	itemType, inputItems := vel.ItemType(inputReflectType)
+	if inputItems {
+		if meta.Method == "GET" || meta.OperationsPath() != "" {
+			return ApiDesc{}, fmt.Errorf("%s takes a stream of items, it can't be a GET request nor run async", meta.OperationID)
+		}
+		inputReflectType = itemType
+	}
+	// a protobuf message is described by its struct, see vel.Protobuf
+	inputMessage, protoInput := vel.ProtoMessageType(inputReflectType)
+	outputMessage, protoOutput := vel.ProtoMessageType(outputReflectType)
+	if protoInput {
+		if meta.Method == "GET" {
+			return ApiDesc{}, fmt.Errorf("%s takes a protobuf message, it's decoded from the body a GET request has none", meta.OperationID)
+		}
+		inputReflectType = inputMessage
+	}
+	if protoOutput {
+		if meta.Spec.Stream != "" || meta.OperationsPath() != "" {
+			return ApiDesc{}, fmt.Errorf("%s returns a protobuf message, it can't stream nor run async", meta.OperationID)
+		}
+		outputReflectType = outputMessage
+	}
+
+	// the clients send a raw input from a reader and return a raw output as bytes
+	inputType, outputType := DataType{Name: "io.Reader"}, DataType{Name: "[]byte"}
+	var err error
+	if !rawInput {
+		inputType, err = extractDataType(inputReflectType, inlineName(Capitalize(meta.OperationID)+"Request"))
+		if err != nil {
+			return ApiDesc{}, err
+		}
+	}
+	if !rawOutput {
+		outputType, err = extractDataType(outputReflectType, inlineName(Capitalize(meta.OperationID)+"Response"))
+		if err != nil {
+			return ApiDesc{}, err
+		}
+	}
+	// the client doesn't send the input the handler doesn't decode nor expects the output it doesn't encode
+	if !vel.HasBody(inputReflectType) {
+		inputType, inputReflectType = DataType{}, nil
+	}
+	if !vel.HasBody(outputReflectType) {
+		outputType, outputReflectType = DataType{}, nil
+	}
+	// a raw body has no example
+	if rawInput {
+		inputReflectType = nil
+	}
+	if rawOutput {
+		outputReflectType = nil
+	}
+	validated := inputReflectType != nil && reflect.PointerTo(inputReflectType).Implements(validatorType)
+	if meta.Spec.Stream != "" && outputType.Name == "" {
+		return ApiDesc{}, fmt.Errorf("%s streams items, its output type is the item type and can't be empty", meta.OperationID)
+	}
+
+	// a malformed UUID of the input fails the validation as well
+	formatted := inputReflectType != nil && vel.HasUUID(inputReflectType)
+	errs := makeErrorDescs(meta.Spec)
+	if validated || formatted {
+		errs = append(errs, ErrorDesc{
+			Code:        vel.ValidationFailedCode,
+			Status:      http.StatusUnprocessableEntity,
+			Description: "the request failed its validation",
+			Violations:  true,
+		})
+	}
+	if header := meta.Spec.RequestHeaders; meta.Spec.EnforceRequestHeaders && header.Key != "" {
+		// the declared errors are ordered by status
+		at := slices.IndexFunc(errs, func(e ErrorDesc) bool { return e.Status > http.StatusBadRequest })
+		if at < 0 {
+			at = len(errs)
+		}
+		errs = slices.Insert(errs, at, ErrorDesc{
+			Code:        vel.InvalidRequestHeaderCode,
+			Status:      http.StatusBadRequest,
+			Description: "the " + header.Key + " header failed its validation",
+			Violations:  true,
+		})
+	}
+
+	var params []QueryParam
+	if meta.Method == "GET" {
+		params = queryParams(inputType.Fields)
 	}
 
 	return ApiDesc{
-		Input:       inputType,
-		Output:      outputType,
-		OperationID: meta.OperationID,
-		Method:      meta.Method,
-		FuncName:    Capitalize(meta.OperationID),
-		Spec:        meta.Spec,
+		Input:          inputType,
+		Output:         outputType,
+		OperationID:    meta.OperationID,
+		Method:         meta.Method,
+		FuncName:       Capitalize(meta.OperationID),
+		Path:           meta.OperationID,
+		Spec:           meta.Spec,
+		RequestHeaders: meta.RequestHeaders(),
+		Security:       meta.SecuritySchemes(),
+		Errors:         errs,
+		Validated:      validated,
+		Formatted:      formatted,
+		Paginated:      meta.Spec.Stream == "" && !inputItems && vel.Paginated(inputReflectType, outputReflectType),
+		OperationsPath: strings.TrimPrefix(meta.OperationsPath(), "/"),
+		GoResults:      goResults(outputType.Name, meta.Spec.Stream, meta.OperationsPath() != ""),
+		Version:        meta.Version(),
+		RawInput:       rawInput,
+		RawOutput:      rawOutput,
+		InputItems:     inputItems,
+		ProtoInput:     protoInput,
+		ProtoOutput:    protoOutput,
+		Form:           meta.Method != "GET" && !rawInput && !inputItems && inputType.Name != "" && meta.Spec.RequestContentType == vel.FormURLEncoded,
+		QueryParams:    params,
+		input:          inputReflectType,
+		output:         outputReflectType,
 	}, nil
 }
 
-func extractDataType(t reflect.Type) (DataType, error) {
-	var fields []Field
+// GoInput is the input type of the Go method, a sequence of the items of an items input
+func (a ApiDesc) GoInput() string {
+	if a.InputItems {
+		return "iter.Seq[" + a.Input.Name + "]"
+	}
+	return a.Input.Name
+}
 
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		typeName := field.Type.String()
-		isBuiltin := false
+// goResults is the result list of a Go client method, a stream is iterated over
+func goResults(output string, stream vel.StreamFormat, async bool) string {
+	switch {
+	case async:
+		return "(Operation, error)"
+	case stream != "":
+		return "iter.Seq2[" + output + ", error]"
+	case output == "":
+		return "(error)"
+	}
+	return "(" + output + ", error)"
+}
 
-		if _, ok := builtinTypes[typeName]; ok {
-			isBuiltin = true
-		} else {
-			if field.Type.Kind() == reflect.Struct {
-				typeName = field.Type.Name()
+// makeErrorDescs lists the errors declared in the spec ordered by http status
+func makeErrorDescs(spec vel.Spec) []ErrorDesc {
+	statuses := slices.Sorted(maps.Keys(spec.Errors))
+	errs := make([]ErrorDesc, 0, len(spec.Errors))
+	for _, status := range statuses {
+		for _, errorSpec := range spec.Errors[status] {
+			desc := ErrorDesc{
+				Code:        errorSpec.Code,
+				Status:      status,
+				Description: errorSpec.Description,
 			}
-			if field.Type.Kind() == reflect.Pointer && field.Type.Elem().Kind() == reflect.Struct {
-				typeName = "*" + field.Type.Elem().Name()
+			for _, m := range errorSpec.Meta {
+				desc.Meta = append(desc.Meta, ErrorMetaDesc{
+					Key:      m.Key,
+					TSKey:    tsKey(m.Key),
+					Required: m.Validation.Required,
+					GoType:   primitiveGoType(m.ValueType),
+				})
 			}
-			if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
-				typeName = "[]" + field.Type.Elem().Name()
+			errs = append(errs, desc)
+		}
+	}
+	return errs
+}
+
+// collectCodeErrors makes a typed error of every error code declared by the apis sorted by the code,
+// the meta of a code declared by several apis is merged by the key
+func collectCodeErrors(apis []ApiDesc) []CodeErrorDesc {
+	taken := map[string]bool{"Error": true, "RedirectError": true, "Violation": true}
+	for _, api := range apis {
+		for _, dataType := range api.DataTypes {
+			taken[dataType.Name] = true
+		}
+	}
+	byCode := make(map[string]*CodeErrorDesc)
+	for _, api := range apis {
+		for _, e := range api.Errors {
+			codeErr, ok := byCode[e.Code]
+			if !ok {
+				codeErr = &CodeErrorDesc{Code: e.Code}
+				byCode[e.Code] = codeErr
+			}
+			for _, m := range e.Meta {
+				if !slices.ContainsFunc(codeErr.Meta, func(other CodeErrorMeta) bool { return other.Key == m.Key }) {
+					codeErr.Meta = append(codeErr.Meta, CodeErrorMeta{Key: m.Key, GoType: m.GoType})
+				}
 			}
-			if field.Type.Kind() == reflect.Map && field.Type.Elem().Kind() == reflect.Struct {
-				typeName = "map[" + field.Type.Key().Name() + "]" + field.Type.Elem().Name()
+		}
+	}
+
+	errs := make([]CodeErrorDesc, 0, len(byCode))
+	for _, code := range slices.Sorted(maps.Keys(byCode)) {
+		codeErr := byCode[code]
+		name := pascalCase(code)
+		if name == "" || !unicode.IsLetter(rune(name[0])) {
+			name = "Code" + name
+		}
+		codeErr.TypeName = name + "Error"
+		for taken[codeErr.TypeName] {
+			codeErr.TypeName = "Code" + codeErr.TypeName
+		}
+		taken[codeErr.TypeName] = true
+
+		fields := map[string]bool{"Err": true}
+		for i := range codeErr.Meta {
+			field := pascalCase(codeErr.Meta[i].Key)
+			if field == "" || !unicode.IsLetter(rune(field[0])) {
+				field = "Meta" + field
 			}
-			if field.Type.Kind() == reflect.String && typeName != reflect.String.String() {
-				typeName = reflect.String.String()
+			for fields[field] {
+				field += "Meta"
 			}
+			fields[field] = true
+			codeErr.Meta[i].Field = field
 		}
+		errs = append(errs, *codeErr)
+	}
+	return errs
+}
 
-		fields = append(fields, Field{
-			Name:       field.Name,
-			Type:       field.Type,
-			TypeName:   typeName,
-			TSTypeName: toTSType(typeName),
-			JsonTag:    field.Tag.Get("json"),
-			SchemaTag:  field.Tag.Get("schema"),
-			IsBuilting: isBuiltin,
-		})
+// goTypeName names the type as it's declared in the generated client:
+// structs and defined primitive types by their names without the package, e.g. map[int][]*Item,
+// instantiated generic types by their mangled names, see typeName.
+// An anonymous struct is named inline, empty unless NameInlineStructs is set.
+func goTypeName(t reflect.Type, inline string) string {
+	if mapping, ok := mappingOf(t); ok {
+		return mapping.GoType
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if t.Name() == "" && t.NumField() > 0 {
+			return inline
+		}
+		return typeName(t)
+	case reflect.Pointer:
+		return "*" + goTypeName(t.Elem(), inline)
+	case reflect.Slice:
+		return "[]" + goTypeName(t.Elem(), inline)
+	case reflect.Map:
+		return "map[" + goTypeName(t.Key(), inline) + "]" + goTypeName(t.Elem(), inline)
+	}
+	if isPrimitiveKind(t.Kind()) {
+		// a defined type keeps its name, it's declared in the client
+		if t.PkgPath() != "" {
+			return typeName(t)
+		}
+		return t.Kind().String()
+	}
+	return t.String()
+}
+
+// makeField describes the field of the struct named owner
+func makeField(field reflect.StructField, owner string) Field {
+	inline := inlineName(owner + field.Name)
+	typeName := goTypeName(field.Type, inline)
+	_, isBuiltin := mappingOf(field.Type)
+	mapped := mappedTypesOf(field.Type)
+
+	tag := jsonTag(field)
+	name, options, _ := strings.Cut(tag, ",")
+	f := Field{
+		Name:       field.Name,
+		Type:       field.Type,
+		TypeName:   typeName,
+		TSTypeName: toTSType(typeName, mapped),
+		ZodType:    toZodType(typeName, mapped),
+		ZodTSType:  toZodTSType(typeName, mapped),
+		JsonTag:    tag,
+		JsonName:   cmp.Or(name, field.Name),
+		TSKey:      tsKey(cmp.Or(name, field.Name)),
+		SchemaTag:  field.Tag.Get("schema"),
+		IsBuilting: isBuiltin,
+		inline:     inline,
+	}
+
+	// a query parameter tagged omitempty may be left out as well
+	_, schemaOptions, _ := strings.Cut(f.SchemaTag, ",")
+	omitted := hasTagOption(options, "omitempty") || hasTagOption(options, "omitzero") || hasTagOption(schemaOptions, "omitempty")
+	f.Optional = omitted || field.Type.Kind() == reflect.Pointer
+	if hasTagOption(options, "string") && quotable(field.Type) {
+		f.AsString = true
+		f.TSTypeName, f.ZodType, f.ZodTSType = "string", "z.string()", "string"
+		if field.Type.Kind() == reflect.Pointer {
+			f.TSTypeName, f.ZodType, f.ZodTSType = "string | undefined", "z.string().nullish()", "string | null | undefined"
+		}
+	}
+	if omitted && field.Type.Kind() != reflect.Pointer {
+		f.ZodType += ".optional()"
+		f.ZodTSType += " | undefined"
+	}
+	// the discriminator of a OneOf struct is always set to its value
+	if value, ok := field.Tag.Lookup("discriminator"); ok && field.Type.Kind() == reflect.String {
+		literal := "'" + strings.ReplaceAll(value, "'", "\\'") + "'"
+		f.Discriminator, f.Optional = value, false
+		f.TSTypeName, f.ZodType, f.ZodTSType = literal, "z.literal("+literal+")", literal
+	}
+	return f
+}
+
+// tsKey quotes the property name unless it's an identifier
+func tsKey(name string) string {
+	for i, r := range name {
+		if !(r == '_' || r == '$' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r))) {
+			return "'" + strings.ReplaceAll(name, "'", "\\'") + "'"
+		}
+	}
+	return name
+}
+
+func hasTagOption(options, option string) bool {
+	for options != "" {
+		var current string
+		current, options, _ = strings.Cut(options, ",")
+		if current == option {
+			return true
+		}
+	}
+	return false
+}
+
+// quotable reports whether the string option of a json tag applies to the type,
+// encoding/json quotes only strings, numbers and booleans
+func quotable(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return isPrimitiveKind(t.Kind())
+}
+
+// structFields lists the fields of the struct as encoding/json sees them:
+// the fields of embedded structs without a json name are promoted to the struct,
+// a promoted field is hidden by a shallower field of the same name or by a tagged one of the same depth,
+// the fields of the same name and depth hide each other. The anonymous structs of the fields are named after owner.
+func structFields(t reflect.Type, owner string) []Field {
+	type candidate struct {
+		field  Field
+		name   string
+		depth  int
+		tagged bool
+	}
+	var candidates []candidate
+	var walk func(t reflect.Type, depth int, path map[reflect.Type]bool)
+	walk = func(t reflect.Type, depth int, path map[reflect.Type]bool) {
+		path[t] = true
+		defer delete(path, t)
+
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := jsonTag(field)
+			if tag == "-" {
+				continue
+			}
+			name, _, _ := strings.Cut(tag, ",")
+			if field.Anonymous && name == "" {
+				embedded := field.Type
+				if embedded.Kind() == reflect.Pointer {
+					embedded = embedded.Elem()
+					// encoding/json can't allocate an unexported embedded struct
+					if !field.IsExported() {
+						continue
+					}
+				}
+				_, builtin := mappingOf(embedded)
+				if embedded.Kind() == reflect.Struct && !builtin {
+					if !path[embedded] {
+						walk(embedded, depth+1, path)
+					}
+					continue
+				}
+			}
+			if !field.IsExported() {
+				continue
+			}
+			tagged := name != ""
+			if !tagged {
+				name = field.Name
+			}
+			candidates = append(candidates, candidate{field: makeField(field, owner), name: name, depth: depth, tagged: tagged})
+		}
 	}
+	walk(t, 0, make(map[reflect.Type]bool))
 
-	name := t.Name()
+	byName := make(map[string][]int)
+	for i, c := range candidates {
+		byName[c.name] = append(byName[c.name], i)
+	}
+	var fields []Field
+	for i, c := range candidates {
+		group := byName[c.name]
+		if len(group) == 1 {
+			fields = append(fields, c.field)
+			continue
+		}
+		// the shallowest fields compete, a single tagged one wins over the untagged ones
+		minDepth := c.depth
+		for _, j := range group {
+			minDepth = min(minDepth, candidates[j].depth)
+		}
+		var shallowest, tagged []int
+		for _, j := range group {
+			if candidates[j].depth == minDepth {
+				shallowest = append(shallowest, j)
+				if candidates[j].tagged {
+					tagged = append(tagged, j)
+				}
+			}
+		}
+		if (len(shallowest) == 1 && shallowest[0] == i) || (len(shallowest) > 1 && len(tagged) == 1 && tagged[0] == i) {
+			fields = append(fields, c.field)
+		}
+	}
+	return fields
+}
+
+// extractDataType describes the struct once, see typeCache, an anonymous struct is named inline
+func extractDataType(t reflect.Type, inline string) (DataType, error) {
+	if t.Name() != "" {
+		inline = ""
+	}
+	return typeCache.structType(t, inline)
+}
+
+func describeStruct(t reflect.Type, inline string) (DataType, error) {
+	if vel.IsOneOf(t) {
+		return describeUnion(t)
+	}
+	name := cmp.Or(typeName(t), inline)
+	fields := structFields(t, name)
 	if len(fields) == 0 {
 		name = ""
 	}
@@ -213,20 +882,142 @@ func extractDataType(t reflect.Type) (DataType, error) {
 		return DataType{}, ErrorInlineStructForbidden
 	}
 	return DataType{
-		Name:   name,
-		Fields: fields,
+		Name:      name,
+		Fields:    fields,
+		Recursive: refersTo(t, fields, map[reflect.Type]bool{t: true}),
 	}, nil
 }
 
+// refersTo reports whether the fields lead to the target struct, visited holds the structs walked through already.
+// It reflects the fields directly, the cached descriptions of the structs on the way may not be complete yet.
+func refersTo(target reflect.Type, fields []Field, visited map[reflect.Type]bool) bool {
+	for _, field := range fields {
+		for _, t := range leafTypes(field.Type) {
+			if t == target {
+				return true
+			}
+			if _, mapped := mappingOf(t); mapped || t.Kind() != reflect.Struct || visited[t] {
+				continue
+			}
+			visited[t] = true
+			if refersTo(target, structFields(t, ""), visited) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 type ApiClientDesc struct {
 	Client ClientDesc
 	Apis   []ApiDesc
+	// Groups lists the sub-clients of the subrouters, a parent goes before its children
+	Groups     []GroupDesc
+	Headers    []HeaderDesc
+	ErrorShape ErrorShape
+	// File selects a part of the client in the multi-file mode, e.g. "types", empty means a single file
+	File string
+	// ClientTypeRefs and ClientSchemaRefs are imported by the TS client file in the multi-file mode
+	ClientTypeRefs   []string
+	ClientSchemaRefs []string
+	// SpecHash identifies the API the client is generated from, see SpecHash
+	SpecHash string
+	// Imports lists the packages of the mapped types the data types refer to, see Mapping.GoImport
+	Imports []string
+	// Streams is set if any api streams its output, the Go client declares the stream reader then
+	Streams bool
+	// Downloads is set if any api returns a raw body, the Go client declares the body canceling its call then
+	Downloads bool
+	// Uploads is set if any api consumes a stream of items, the clients declare the NDJSON body encoding them then
+	Uploads bool
+	// Async is set if any api is async, the clients declare the Operation and its polling then
+	Async bool
+	// Unions is set if any data type is a vel.OneOf, the Go types file imports the packages of its JSON methods then
+	Unions bool
+	// CodeErrors are the typed errors of the Go client, one for every declared error code
+	CodeErrors []CodeErrorDesc
+	// Operations is the code of the "operation" template executed for every api in the order of Apis,
+	// the apis are rendered in parallel before the template is executed
+	Operations []string
+}
+
+// OperationDesc is the data of the "operation" template: an api along with the client it's generated for
+type OperationDesc struct {
+	ApiDesc
+	Client     ClientDesc
+	ErrorShape ErrorShape
+	File       string
+}
+
+// MsgPack reports whether the operation talks MessagePack, a protobuf message is sent and received as protojson
+func (o OperationDesc) MsgPack() bool {
+	return o.Client.MsgPack && !o.ProtoInput && !o.ProtoOutput
+}
+
+// ErrorShape describes the error JSON produced by the server, see vel.ErrorSchema
+type ErrorShape struct {
+	Envelope        string
+	CodeField       string
+	MessageField    string
+	MetaField       string
+	ViolationsField string
+	// Rules lists the known validation rules of violations
+	Rules []string
+	Extra []ErrorExtraField
+}
+
+type ErrorExtraField struct {
+	// Name is the generated field name
+	Name   string
+	Key    string
+	GoType string
+	TSType string
+	Spec   vel.KeyValueSpec
+}
+
+// CodeErrorDesc is a typed error of the Go client wrapping the Error of a declared code
+type CodeErrorDesc struct {
+	Code     string
+	TypeName string
+	Meta     []CodeErrorMeta
+}
+
+// CodeErrorMeta is a meta value of a CodeErrorDesc parsed to its declared type
+type CodeErrorMeta struct {
+	Key    string
+	Field  string
+	GoType string
+}
+
+// HeaderDesc describes a header declared in a spec
+type HeaderDesc struct {
+	// Name is the generated constant name
+	Name        string
+	Key         string
+	Description string
 }
 
 type ClientDesc struct {
 	TypeName      string
 	PackageName   string
 	TypeNameLower string
+	// ErrorSchema defines the errors shape, vel.DefaultErrorSchema is used if empty
+	ErrorSchema vel.ErrorSchema
+	// Zod makes the TS client declare its types as Zod schemas and validate responses with them
+	Zod bool
+	// CheckResponses makes the TS client report the responses not matching their Zod schemas instead of failing the calls,
+	// it requires Zod
+	CheckResponses bool
+	// OperationIDCase converts the operation ids in the OpenAPI output
+	OperationIDCase OperationIDCase
+	// CodeSamplesURL is the base url of the curl and HTTPie calls attached to the OpenAPI operations, empty omits them
+	CodeSamplesURL string
+	// Examples attaches an example request and response to the OpenAPI operations
+	Examples bool
+	// Batch generates the calls of the batch endpoint, see vel.Router.RegisterBatchEndpoint
+	Batch bool
+	// MsgPack makes the Go client talk MessagePack, see vel.MsgPack
+	MsgPack bool
 }
 
 type ApiDesc struct {
@@ -237,13 +1028,91 @@ type ApiDesc struct {
 	FuncName    string
 	DataTypes   []DataType
 	Spec        vel.Spec
+	// RequestHeaders are the header of the spec and the ones documented by the middlewares of the route
+	RequestHeaders []vel.KeyValueSpec
+	// Security lists the schemes authenticating the requests documented by the middlewares of the route
+	Security []vel.SecurityScheme
+	// Errors defines the errors declared in the spec and the validation error
+	Errors []ErrorDesc
+	// Validated is set when the input implements vel.Validator
+	Validated bool
+	// Formatted is set when the input holds a UUID, a malformed one fails the validation, see vel.HasUUID
+	Formatted bool
+	// Paginated is set when the input embeds vel.Cursor and the output is a vel.Page, see vel.Paginated
+	Paginated bool
+	// Path is the request path relative to the client base url
+	Path string
+	// OperationsPath is the path of the status endpoint relative to the client base url, it's set for the async routes,
+	// see vel.RegisterAsync
+	OperationsPath string
+	// Group lists the names of the subrouters leading to the handler, it's empty for the handlers of the generated router
+	Group []string
+	// Receiver is the type name of the client or the sub-client making the call
+	Receiver string
+	// ErrorTypeName is the TS type of the declared errors, it's unique across the groups
+	ErrorTypeName string
+	// GoResults is the result list of the Go method, e.g. (User, error), iter.Seq2[Event, error] of a stream
+	// or (Operation, error) of an async route
+	GoResults string
+	// Version is the version of the operation sent in vel.AcceptVersionHeader, see vel.WithVersion
+	Version string
+	// Superseded is set for a version older than the latest one of the operation, its method is suffixed by the version
+	Superseded bool
+	// RawInput and RawOutput are set for the bodies passed as is, see vel.IsRawBody,
+	// their content types are declared by Spec.RequestContentType and Spec.ContentType
+	RawInput  bool
+	RawOutput bool
+	// InputItems is set for an input of vel.Items, Input is the item type sent as NDJSON
+	InputItems bool
+	// ProtoInput and ProtoOutput are set for the protobuf messages, see vel.Protobuf,
+	// the clients send and receive them as protojson
+	ProtoInput  bool
+	ProtoOutput bool
+	// Form is set for the input sent as a form by the schema tags, see vel.FormURLEncoded
+	Form bool
+	// QueryParams are the parameters of a GET input, see QueryParam
+	QueryParams []QueryParam
+
+	// input and output are the handler types, they build the examples
+	input  reflect.Type
+	output reflect.Type
+}
+
+type ErrorDesc struct {
+	Code        string
+	Status      int
+	Description string
+	Meta        []ErrorMetaDesc
+	// Violations is set for the validation error carrying the violations
+	Violations bool
+}
+
+type ErrorMetaDesc struct {
+	Key string
+	// TSKey is the key as a TS property name, quoted if needed
+	TSKey    string
+	Required bool
+	// GoType is the type the Go client parses the value to, e.g. int
+	GoType string
 }
 
 type DataType struct {
 	Name   string
 	Fields []Field
+	// Primitive is the underlying type of a defined primitive type, e.g. string of type UserID string, such a type has no fields
+	Primitive string
+	// Enum lists the values of a primitive type registered by RegisterEnum or implementing vel.Enum
+	Enum []EnumValue
+	// TSType and ZodType declare a primitive type in TS: a branded type or a union of the enum values
+	TSType  string
+	ZodType string
 	// OtherTypes defines a list of types required to generate the fields
 	OtherTypes []DataType
+	// Recursive is set for a struct referring to itself through its fields, e.g. a tree node,
+	// Zod can't infer such a type, so the TS client declares it explicitly
+	Recursive bool
+	// Union describes a vel.OneOf, its fields hold the structs, see describeUnion
+	Union *UnionDesc
 }
 
 type Field struct {
@@ -251,48 +1120,152 @@ type Field struct {
 	Type       reflect.Type
 	TypeName   string
 	TSTypeName string // TypeScript type name
-	JsonTag    string
-	SchemaTag  string
-	// IsBuiltin defines a flag that a field exists in std lib, therefore must not be broken down further
+	ZodType    string // Zod schema expression
+	// ZodTSType is the TS type of the value parsed by ZodType, it declares the recursive types of the Zod schemas
+	ZodTSType string
+	// JsonTag is the raw json tag, the generated Go types keep it as is
+	JsonTag string
+	// JsonName is the key of the field in JSON
+	JsonName string
+	// TSKey is the JSON key as a TS property name, quoted if needed
+	TSKey     string
+	SchemaTag string
+	// Optional is set for pointers and the fields omitted when empty, they aren't required
+	Optional bool
+	// AsString is set by the string option of the json tag, the value is encoded as a JSON string
+	AsString bool
+	// Discriminator is the value of the string field telling the structs of a vel.OneOf apart, it's typed as the literal
+	Discriminator string
+	// IsBuiltin defines a flag that a field is of a type mapped by RegisterTypeMapping, therefore must not be broken down further
 	// e.g. time.Time
 	IsBuilting bool
+
+	// inline names the anonymous struct of the field, see NameInlineStructs
+	inline string
+}
+
+// inlineName returns the name of an anonymous struct if NameInlineStructs is set
+func inlineName(name string) string {
+	if !NameInlineStructs {
+		return ""
+	}
+	return name
+}
+
+// Generate executes the template, the post-processing command is resolved by PostProcessorOf
+func (g *ClientGen) Generate(w io.Writer, templateName, postProcessing string) error {
+	return g.generate(w, templateName, PostProcessorOf(postProcessing), g.meta)
+}
+
+// GenerateWith executes the template and formats the result with the post-processor, nil skips formatting
+func (g *ClientGen) GenerateWith(w io.Writer, templateName string, postProcessor PostProcessor) error {
+	return g.generate(w, templateName, postProcessor, g.meta)
+}
+
+// clientFiles maps the files of the multi-file mode to the parts of the client they contain
+var clientFiles = map[string][]struct{ name, part string }{
+	"go": {{"types.go", "types"}, {"errors.go", "errors"}, {"client.go", "client"}},
+	"ts": {{"types.ts", "types"}, {"client.ts", "client"}},
+}
+
+// GenerateFiles splits the client into files in the output directory: the data types, the errors (Go only) and the client itself,
+// it keeps large APIs reviewable.
+func (g *ClientGen) GenerateFiles(outputDir, language, postProcessing string) error {
+	return g.GenerateFilesWith(outputDir, language, PostProcessorOf(postProcessing))
+}
+
+// GenerateFilesWith is GenerateFiles formatting every file with the post-processor
+func (g *ClientGen) GenerateFilesWith(outputDir, language string, postProcessor PostProcessor) error {
+	files, ok := clientFiles[language]
+	if !ok {
+		return fmt.Errorf("language %s is not supported", language)
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		buf := &bytes.Buffer{}
+		meta := g.meta
+		meta.File = file.part
+		if err := g.generate(buf, language+":default", postProcessor, meta); err != nil {
+			return fmt.Errorf("failed to generate %s: %w", file.name, err)
+		}
+		if err := os.WriteFile(filepath.Join(outputDir, file.name), buf.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *ClientGen) generate(w io.Writer, templateName string, postProcessor PostProcessor, meta ApiClientDesc) error {
+	source, err := g.render(templateName, meta)
+	if err != nil {
+		return err
+	}
+	output, err := postProcess(source, postProcessor)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(output); err != nil {
+		return err
+	}
+
+	return nil
 }
 
-func (g *ClientGen) Generate(w io.Writer, templateName, postProcessing string) error {
+// render executes the template without formatting
+func (g *ClientGen) render(templateName string, meta ApiClientDesc) ([]byte, error) {
 	pipe := bytes.NewBuffer(nil)
 	clientTpl, ok := templateRegistry[templateName]
 	if !ok {
-		return fmt.Errorf("template %s not found", templateName)
-	}
-
-	if err := clientTpl.Execute(pipe, g.meta); err != nil {
-		return err
+		return nil, fmt.Errorf("template %s not found", templateName)
 	}
 
-	bytes := pipe.Bytes()
-	if postProcessing != "" {
-		f, err := os.CreateTemp("", "")
+	if operationTpl := clientTpl.Lookup("operation"); operationTpl != nil {
+		operations, err := renderOperations(operationTpl, meta)
 		if err != nil {
-			return err
-		}
-		if _, err := f.Write(bytes); err != nil {
-			return err
+			return nil, err
 		}
-		defer f.Close()
-		cmdStr := fmt.Sprintf("%s < %s", postProcessing, f.Name())
+		meta.Operations = operations
+	}
+
+	if err := clientTpl.Execute(pipe, meta); err != nil {
+		return nil, err
+	}
+	return pipe.Bytes(), nil
+}
 
-		bytes, err = exec.Command("sh", "-c", cmdStr).Output()
+// renderOperations executes the operation template for every api in parallel, see Parallelism
+func renderOperations(tpl *template.Template, meta ApiClientDesc) ([]string, error) {
+	operations := make([]string, len(meta.Apis))
+	err := parallel(len(meta.Apis), func(i int) error {
+		buf := &strings.Builder{}
+		err := tpl.Execute(buf, OperationDesc{
+			ApiDesc:    meta.Apis[i],
+			Client:     meta.Client,
+			ErrorShape: meta.ErrorShape,
+			File:       meta.File,
+		})
 		if err != nil {
-			return fmt.Errorf("failed to format output: %w", err)
+			return fmt.Errorf("failed to render %s: %w", meta.Apis[i].OperationID, err)
 		}
+		operations[i] = buf.String()
+		return nil
+	})
+	return operations, err
+}
 
+func postProcess(source []byte, postProcessor PostProcessor) ([]byte, error) {
+	if postProcessor == nil {
+		return source, nil
 	}
-
-	if _, err := w.Write(bytes); err != nil {
-		return err
+	output, err := postProcessor(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to format output: %w", err)
 	}
-
-	return nil
+	return output, nil
 }
 
 func Capitalize(s string) string {
@@ -302,12 +1275,7 @@ func Capitalize(s string) string {
 	return s
 }
 
-var builtinTypes = map[string]struct{}{
-	"time.Time":     {},
-	"time.Duration": {},
-}
-
-func toTSType(goType string) string {
+func toTSType(goType string, mapped mappedTypes) string {
 	switch goType {
 	case "string":
 		return "string"
@@ -317,28 +1285,93 @@ func toTSType(goType string) string {
 		return "boolean"
 	case "[]uint8":
 		return "number[]"
-	case "time.Time":
-		return "string"
 	default:
 		if strings.HasPrefix(goType, "[]") {
-			elemType := goType[2:]
-			return toTSType(elemType) + "[]"
+			elemType := toTSType(goType[2:], mapped)
+			if strings.Contains(elemType, " | ") {
+				elemType = "(" + elemType + ")"
+			}
+			return elemType + "[]"
 		}
 		if strings.HasPrefix(goType, "map[") {
-			// Extract key and value types from map[K]V
-			parts := strings.Split(goType[4:], "]")
-			if len(parts) == 2 {
-				keyType := parts[0]
-				valueType := parts[1]
+			// Extract key and value types from map[K]V, the value may be a composite type itself
+			keyType, valueType, ok := strings.Cut(goType[4:], "]")
+			if ok {
 				tsKeyType := "string"
 				if keyType == "int" || keyType == "int64" || keyType == "uint" || keyType == "uint64" {
 					tsKeyType = "number"
 				}
-				return "Record<" + tsKeyType + ", " + toTSType(valueType) + ">"
+				return "Record<" + tsKeyType + ", " + toTSType(valueType, mapped) + ">"
+			}
+		}
+		if strings.HasPrefix(goType, "*") {
+			return toTSType(goType[1:], mapped) + " | undefined"
+		}
+		if mapping, ok := mapped[goType]; ok {
+			return mapping.TSType
+		}
+		return goType
+	}
+}
+
+// toZodType follows toTSType, but describes what encoding/json produces:
+// nil slices and maps are encoded as null, []byte as a base64 string
+func toZodType(goType string, mapped mappedTypes) string {
+	switch goType {
+	case "string", "[]uint8":
+		return "z.string()"
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64", "float32", "float64":
+		return "z.number()"
+	case "bool":
+		return "z.boolean()"
+	default:
+		if strings.HasPrefix(goType, "[]") {
+			return "z.array(" + toZodType(goType[2:], mapped) + ").nullable()"
+		}
+		if strings.HasPrefix(goType, "map[") {
+			// json object keys are strings even for numeric map keys
+			if _, valueType, ok := strings.Cut(goType[4:], "]"); ok {
+				return "z.record(z.string(), " + toZodType(valueType, mapped) + ").nullable()"
+			}
+		}
+		if strings.HasPrefix(goType, "*") {
+			return toZodType(goType[1:], mapped) + ".nullish()"
+		}
+		if mapping, ok := mapped[goType]; ok {
+			return mapping.ZodType
+		}
+		// lazy allows referencing a schema declared below
+		return "z.lazy(() => " + goType + "Schema)"
+	}
+}
+
+// toZodTSType follows toZodType, it gives the TS type of the parsed value, e.g. an array parsed by Zod may be null
+func toZodTSType(goType string, mapped mappedTypes) string {
+	switch goType {
+	case "string", "[]uint8":
+		return "string"
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64", "float32", "float64":
+		return "number"
+	case "bool":
+		return "boolean"
+	default:
+		if strings.HasPrefix(goType, "[]") {
+			elemType := toZodTSType(goType[2:], mapped)
+			if strings.Contains(elemType, " | ") {
+				elemType = "(" + elemType + ")"
+			}
+			return elemType + "[] | null"
+		}
+		if strings.HasPrefix(goType, "map[") {
+			if _, valueType, ok := strings.Cut(goType[4:], "]"); ok {
+				return "Record<string, " + toZodTSType(valueType, mapped) + "> | null"
 			}
 		}
 		if strings.HasPrefix(goType, "*") {
-			return toTSType(goType[1:]) + " | undefined"
+			return toZodTSType(goType[1:], mapped) + " | null | undefined"
+		}
+		if mapping, ok := mapped[goType]; ok {
+			return mapping.TSType
 		}
 		return goType
 	}
@@ -363,8 +1396,16 @@ type OpenAPISchema struct {
 	Description          string                    `yaml:"description,omitempty"`
 	Minimum              *int                      `yaml:"minimum,omitempty"`
 	Maximum              *int                      `yaml:"maximum,omitempty"`
-	Enum                 []string                  `yaml:"enum,omitempty"`
+	Enum                 []any                     `yaml:"enum,omitempty"`
 	Example              interface{}               `yaml:"example,omitempty"`
+	OneOf                []*OpenAPISchema          `yaml:"oneOf,omitempty"`
+	Discriminator        *OpenAPIDiscriminator     `yaml:"discriminator,omitempty"`
+}
+
+// OpenAPIDiscriminator names the property telling the schemas of oneOf apart and maps its values to them
+type OpenAPIDiscriminator struct {
+	PropertyName string            `yaml:"propertyName"`
+	Mapping      map[string]string `yaml:"mapping,omitempty"`
 }
 
 type OpenAPIParameter struct {
@@ -383,11 +1424,101 @@ type OpenAPIHeader struct {
 }
 
 type OpenAPIMediaType struct {
-	Schema *OpenAPISchema `yaml:"schema"`
+	Schema  *OpenAPISchema `yaml:"schema"`
+	Example any            `yaml:"example,omitempty"`
 }
 
 type OpenAPIContent struct {
-	ApplicationJSON *OpenAPIMediaType `yaml:"application/json,omitempty"`
+	ApplicationJSON   *OpenAPIMediaType `yaml:"application/json,omitempty"`
+	TextEventStream   *OpenAPIMediaType `yaml:"text/event-stream,omitempty"`
+	ApplicationNDJSON *OpenAPIMediaType `yaml:"application/x-ndjson,omitempty"`
+	ApplicationForm   *OpenAPIMediaType `yaml:"application/x-www-form-urlencoded,omitempty"`
+	// Raw maps the content types of the raw bodies to their binary schema, see vel.IsRawBody
+	Raw map[string]*OpenAPIMediaType `yaml:",inline"`
+}
+
+// formSchema is the schema of the form fields named by their schema tags like the query parameters of a GET api
+func (g *ClientGen) formSchema(api ApiDesc) *OpenAPISchema {
+	schema := &OpenAPISchema{Type: "object", Properties: make(map[string]*OpenAPISchema)}
+	for _, field := range api.Input.Fields {
+		if field.SchemaTag != "" {
+			schema.Properties[field.SchemaTag] = g.fieldToSchema(field)
+			schema.Required = append(schema.Required, field.SchemaTag)
+		}
+	}
+	return schema
+}
+
+// rawContent is the content of a raw body of the content type, OctetStream if empty
+func rawContent(contentType string) *OpenAPIContent {
+	return &OpenAPIContent{Raw: map[string]*OpenAPIMediaType{
+		cmp.Or(contentType, vel.OctetStream): {Schema: &OpenAPISchema{Type: "string", Format: "binary"}},
+	}}
+}
+
+// outputContent is the content of the successful response, a stream is documented by the schema of its items
+func outputContent(api ApiDesc) *OpenAPIContent {
+	if api.RawOutput {
+		return rawContent(api.Spec.ContentType)
+	}
+	media := &OpenAPIMediaType{
+		Schema: &OpenAPISchema{
+			Ref: "#/components/schemas/" + api.Output.Name,
+		},
+	}
+	switch api.Spec.Stream {
+	case vel.StreamSSE:
+		return &OpenAPIContent{TextEventStream: media}
+	case vel.StreamNDJSON:
+		return &OpenAPIContent{ApplicationNDJSON: media}
+	}
+	content := &OpenAPIContent{ApplicationJSON: media}
+	// a protobuf message is binary protobuf if the request accepts it
+	if api.ProtoOutput {
+		content.Raw = rawContent(vel.Protobuf).Raw
+	}
+	return content
+}
+
+// acceptOperation replaces the success response of an async operation by 202 Accepted with the Operation,
+// the output becomes the result of the operation
+func acceptOperation(operation *OpenAPIOperation, api ApiDesc) {
+	operation.Async = &OpenAPIAsync{StatusPath: "/" + api.OperationsPath + "{id}"}
+	if content := operation.Responses["200"].Content; content != nil {
+		operation.Async.Result = content.ApplicationJSON.Schema
+	}
+	delete(operation.Responses, "200")
+	var statuses []any
+	for _, status := range vel.OperationStatus("").EnumValues() {
+		statuses = append(statuses, status)
+	}
+	operation.Responses["202"] = &OpenAPIResponse{
+		Description: "Accepted, the operation runs in the background",
+		Headers: map[string]*OpenAPIHeader{
+			"Location": {
+				Description: "Status endpoint of the operation",
+				Required:    true,
+				Schema:      &OpenAPISchema{Type: "string"},
+			},
+		},
+		Content: &OpenAPIContent{
+			ApplicationJSON: &OpenAPIMediaType{
+				Schema: &OpenAPISchema{
+					Type:     "object",
+					Required: []string{"id", "name", "status", "createdAt", "updatedAt"},
+					Properties: map[string]*OpenAPISchema{
+						"id":        {Type: "string"},
+						"name":      {Type: "string", Description: "Operation id of the route started the operation"},
+						"status":    {Type: "string", Enum: statuses},
+						"result":    {Description: "Output of the succeeded operation, see x-async"},
+						"error":     {Type: "object", Description: "Error of the failed operation"},
+						"createdAt": {Type: "string", Format: "date-time"},
+						"updatedAt": {Type: "string", Format: "date-time"},
+					},
+				},
+			},
+		},
+	}
 }
 
 type OpenAPIRequestBody struct {
@@ -406,21 +1537,62 @@ type OpenAPIOperation struct {
 	Parameters  []*OpenAPIParameter         `yaml:"parameters,omitempty"`
 	RequestBody *OpenAPIRequestBody         `yaml:"requestBody,omitempty"`
 	Responses   map[string]*OpenAPIResponse `yaml:"responses"`
+	CodeSamples []*OpenAPICodeSample        `yaml:"x-codeSamples,omitempty"`
+	Pagination  *OpenAPIPagination          `yaml:"x-pagination,omitempty"`
+	Async       *OpenAPIAsync               `yaml:"x-async,omitempty"`
+	// RequiredPermissions are declared by the spec of the route, see vel.Authorize
+	RequiredPermissions []string `yaml:"x-required-permissions,omitempty"`
+	// Versions are the operations of the superseded versions by the version, the operation is the latest one, see vel.WithVersion
+	Versions map[string]*OpenAPIOperation `yaml:"x-versions,omitempty"`
+	// Security lists the requirements of the schemes of the components, a request meets one of them
+	Security []map[string][]string `yaml:"security,omitempty"`
+}
+
+// OpenAPIAsync describes the long-running operation answering 202 Accepted, see vel.RegisterAsync
+type OpenAPIAsync struct {
+	// StatusPath is polled with the operation id appended until the operation is done
+	StatusPath string `yaml:"statusPath"`
+	// Result is the schema of the result of the succeeded operation
+	Result *OpenAPISchema `yaml:"result,omitempty"`
 }
 
+// OpenAPIPagination describes the cursor pagination of an operation, see vel.Page
+type OpenAPIPagination struct {
+	CursorParam     string `yaml:"cursorParam"`
+	LimitParam      string `yaml:"limitParam"`
+	ItemsField      string `yaml:"itemsField"`
+	NextCursorField string `yaml:"nextCursorField"`
+}
+
+// OpenAPIPathItem holds the operations of a path, vel describes its routes by GET and POST,
+// the other methods are read from the specs of other origins, e.g. by DiffOpenAPI
 type OpenAPIPathItem struct {
-	Get  *OpenAPIOperation `yaml:"get,omitempty"`
-	Post *OpenAPIOperation `yaml:"post,omitempty"`
+	Get    *OpenAPIOperation `yaml:"get,omitempty"`
+	Post   *OpenAPIOperation `yaml:"post,omitempty"`
+	Put    *OpenAPIOperation `yaml:"put,omitempty"`
+	Patch  *OpenAPIOperation `yaml:"patch,omitempty"`
+	Delete *OpenAPIOperation `yaml:"delete,omitempty"`
 }
 
 type OpenAPIComponents struct {
-	Schemas map[string]*OpenAPISchema `yaml:"schemas"`
+	Schemas         map[string]*OpenAPISchema         `yaml:"schemas"`
+	SecuritySchemes map[string]*OpenAPISecurityScheme `yaml:"securitySchemes,omitempty"`
+}
+
+// OpenAPISecurityScheme is a header authenticating the requests, see vel.SecurityScheme
+type OpenAPISecurityScheme struct {
+	Type        string `yaml:"type"`
+	In          string `yaml:"in"`
+	Name        string `yaml:"name"`
+	Description string `yaml:"description,omitempty"`
 }
 
 type OpenAPISpec struct {
-	OpenAPI    string                      `yaml:"openapi"`
-	Info       *OpenAPIInfo                `yaml:"info"`
-	Paths      map[string]*OpenAPIPathItem `yaml:"paths"`
+	OpenAPI string                      `yaml:"openapi"`
+	Info    *OpenAPIInfo                `yaml:"info"`
+	Paths   map[string]*OpenAPIPathItem `yaml:"paths"`
+	// Webhooks are the requests the API sends to its receivers, see the velhook package
+	Webhooks   map[string]*OpenAPIPathItem `yaml:"webhooks,omitempty"`
 	Components *OpenAPIComponents          `yaml:"components"`
 }
 
@@ -447,13 +1619,21 @@ func (g *ClientGen) GenerateOpenAPI(title, version string) (*OpenAPISpec, error)
 		}
 	}
 
+	operationIDs, err := g.operationIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	// superseded holds the superseded versions of the operations by the method and the path until the latest version
+	superseded := make(map[string]map[string]*OpenAPIOperation)
+
 	// Add paths and operations
-	for _, api := range g.meta.Apis {
-		path := "/" + api.OperationID
+	for i, api := range g.meta.Apis {
+		path := "/" + api.Path
 		pathItem := &OpenAPIPathItem{}
 
 		operation := &OpenAPIOperation{
-			OperationID: api.OperationID,
+			OperationID: operationIDs[i],
 			Description: api.Spec.Description,
 			Responses: map[string]*OpenAPIResponse{
 				"200": {
@@ -462,15 +1642,53 @@ func (g *ClientGen) GenerateOpenAPI(title, version string) (*OpenAPISpec, error)
 			},
 		}
 
-		// Add request headers from spec
-		if reqHeaders := g.specToRequestHeaders(api.Spec); reqHeaders != nil {
-			operation.Parameters = append(operation.Parameters, reqHeaders...)
+		// Add request headers from the spec and the middlewares
+		operation.Parameters = append(operation.Parameters, g.specToRequestHeaders(api.RequestHeaders)...)
+		addSecurity(spec, operation, api.Security)
+		operation.RequiredPermissions = api.Spec.Permissions
+		if api.Paginated {
+			operation.Pagination = &OpenAPIPagination{CursorParam: "cursor", LimitParam: "limit", ItemsField: "items", NextCursorField: "nextCursor"}
 		}
 
 		// Add response headers from spec
 		if respHeaders := g.specToResponseHeaders(api.Spec); respHeaders != nil {
 			operation.Responses["200"].Headers = respHeaders
 		}
+		if cacheControl := api.Spec.Cache.HeaderValue(); cacheControl != "" {
+			if operation.Responses["200"].Headers == nil {
+				operation.Responses["200"].Headers = make(map[string]*OpenAPIHeader)
+			}
+			operation.Responses["200"].Headers["Cache-Control"] = &OpenAPIHeader{
+				Description: "Caching policy of the response",
+				Required:    true,
+				Schema: &OpenAPISchema{
+					Type: "string",
+					Enum: []any{cacheControl},
+				},
+			}
+			if len(api.Spec.Cache.VaryHeaders) > 0 {
+				operation.Responses["200"].Headers["Vary"] = &OpenAPIHeader{
+					Description: "Request headers the response depends on",
+					Required:    true,
+					Schema: &OpenAPISchema{
+						Type: "string",
+						Enum: []any{strings.Join(api.Spec.Cache.VaryHeaders, ", ")},
+					},
+				}
+			}
+		}
+		if len(api.Spec.Fallback.Codes) > 0 {
+			if operation.Responses["200"].Headers == nil {
+				operation.Responses["200"].Headers = make(map[string]*OpenAPIHeader)
+			}
+			operation.Responses["200"].Headers["Warning"] = &OpenAPIHeader{
+				Description: "Set on a fallback response served while a dependency is unavailable, such a response is never cached",
+				Schema: &OpenAPISchema{
+					Type: "string",
+					Enum: []any{vel.FallbackWarningStale, vel.FallbackWarningStatic},
+				},
+			}
+		}
 
 		// Add error responses from spec
 		if errorResponses := g.specToErrorResponses(api.Spec); errorResponses != nil {
@@ -478,36 +1696,71 @@ func (g *ClientGen) GenerateOpenAPI(title, version string) (*OpenAPISpec, error)
 				operation.Responses[code] = response
 			}
 		}
+		if api.Validated || api.Formatted {
+			g.addViolationsResponse(operation, http.StatusUnprocessableEntity, vel.ValidationFailedCode, "the request failed its validation")
+		}
+		if header := api.Spec.RequestHeaders; api.Spec.EnforceRequestHeaders && header.Key != "" {
+			g.addViolationsResponse(operation, http.StatusBadRequest, vel.InvalidRequestHeaderCode, "the "+header.Key+" header failed its validation")
+		}
+		for _, redirect := range api.Spec.Redirects {
+			description := redirect.Description
+			if description == "" {
+				description = http.StatusText(redirect.Status)
+			}
+			operation.Responses[strconv.Itoa(redirect.Status)] = &OpenAPIResponse{
+				Description: description,
+				Headers: map[string]*OpenAPIHeader{
+					"Location": {
+						Description: "URL the client is redirected to",
+						Required:    true,
+						Schema:      &OpenAPISchema{Type: "string"},
+					},
+				},
+			}
+		}
+
+		if g.meta.Client.CodeSamplesURL != "" {
+			if operation.CodeSamples, err = codeSamples(api, g.meta.Client.CodeSamplesURL); err != nil {
+				return nil, err
+			}
+		}
 
 		if api.Method == "GET" {
 			// Handle GET parameters
-			for _, field := range api.Input.Fields {
-				if field.SchemaTag != "" {
-					param := &OpenAPIParameter{
-						Name:     field.SchemaTag,
-						In:       "query",
-						Required: true,
-						Schema:   g.fieldToSchema(field),
-					}
-					operation.Parameters = append(operation.Parameters, param)
-				}
+			for _, param := range api.QueryParams {
+				operation.Parameters = append(operation.Parameters, &OpenAPIParameter{
+					Name:     param.Key,
+					In:       "query",
+					Required: !param.Optional,
+					Schema:   g.fieldToSchema(param.Field),
+				})
 			}
 
 			// Add response body if output has fields
-			if len(api.Output.Fields) > 0 {
-				operation.Responses["200"].Content = &OpenAPIContent{
-					ApplicationJSON: &OpenAPIMediaType{
-						Schema: &OpenAPISchema{
-							Ref: "#/components/schemas/" + api.Output.Name,
-						},
-					},
-				}
+			if len(api.Output.Fields) > 0 || api.RawOutput {
+				operation.Responses["200"].Content = outputContent(api)
 			}
 
 			pathItem.Get = operation
 		} else {
 			// Handle POST request body
-			if len(api.Input.Fields) > 0 {
+			if api.RawInput {
+				operation.RequestBody = &OpenAPIRequestBody{Content: rawContent(api.Spec.RequestContentType)}
+			} else if api.InputItems {
+				operation.RequestBody = &OpenAPIRequestBody{
+					Content: &OpenAPIContent{
+						ApplicationNDJSON: &OpenAPIMediaType{
+							Schema: &OpenAPISchema{
+								Ref: "#/components/schemas/" + api.Input.Name,
+							},
+						},
+					},
+				}
+			} else if api.Form {
+				operation.RequestBody = &OpenAPIRequestBody{
+					Content: &OpenAPIContent{ApplicationForm: &OpenAPIMediaType{Schema: g.formSchema(api)}},
+				}
+			} else if len(api.Input.Fields) > 0 {
 				operation.RequestBody = &OpenAPIRequestBody{
 					Content: &OpenAPIContent{
 						ApplicationJSON: &OpenAPIMediaType{
@@ -517,32 +1770,166 @@ func (g *ClientGen) GenerateOpenAPI(title, version string) (*OpenAPISpec, error)
 						},
 					},
 				}
+				if api.ProtoInput {
+					operation.RequestBody.Content.Raw = rawContent(vel.Protobuf).Raw
+				}
 			}
 
 			// Add response body if output has fields
-			if len(api.Output.Fields) > 0 {
-				operation.Responses["200"].Content = &OpenAPIContent{
-					ApplicationJSON: &OpenAPIMediaType{
-						Schema: &OpenAPISchema{
-							Ref: "#/components/schemas/" + api.Output.Name,
-						},
-					},
-				}
+			if len(api.Output.Fields) > 0 || api.RawOutput {
+				operation.Responses["200"].Content = outputContent(api)
 			}
 
 			pathItem.Post = operation
 		}
 
+		if api.OperationsPath != "" {
+			acceptOperation(operation, api)
+		}
+
+		if g.meta.Client.Examples {
+			if err := addExamples(operation, api); err != nil {
+				return nil, fmt.Errorf("failed to build the examples of %s: %w", api.OperationID, err)
+			}
+		}
+
+		if api.Version != "" {
+			key := api.Method + " " + path
+			if api.Superseded {
+				if superseded[key] == nil {
+					superseded[key] = make(map[string]*OpenAPIOperation)
+				}
+				superseded[key][api.Version] = operation
+				continue
+			}
+			addVersions(operation, api.Version, superseded[key])
+		}
+
 		spec.Paths[path] = pathItem
 	}
 
+	if err := g.addWebhooks(spec, allSchemas); err != nil {
+		return nil, err
+	}
+
 	// Add all schemas to components
 	spec.Components.Schemas = allSchemas
 
 	return spec, nil
 }
 
+// addVersions describes the version header of the latest version of the operation and lists the superseded ones
+func addVersions(operation *OpenAPIOperation, latest string, superseded map[string]*OpenAPIOperation) {
+	versions := append(slices.Sorted(maps.Keys(superseded)), latest)
+	enum := make([]any, len(versions))
+	for i, version := range versions {
+		enum[i] = version
+	}
+	operation.Parameters = append(operation.Parameters, &OpenAPIParameter{
+		Name:        vel.AcceptVersionHeader,
+		In:          "header",
+		Description: "version of the operation, the latest one before it is served, the latest version if omitted",
+		Schema:      &OpenAPISchema{Type: "string", Enum: enum},
+		Example:     latest,
+	})
+	// an async operation answers 202
+	response := cmp.Or(operation.Responses["200"], operation.Responses["202"])
+	if response.Headers == nil {
+		response.Headers = make(map[string]*OpenAPIHeader)
+	}
+	response.Headers[vel.APIVersionHeader] = &OpenAPIHeader{
+		Description: "version the response is served by",
+		Required:    true,
+		Schema:      &OpenAPISchema{Type: "string"},
+	}
+	operation.Versions = superseded
+}
+
+// addSecurity requires the schemes by the operation and declares them in the components
+func addSecurity(spec *OpenAPISpec, operation *OpenAPIOperation, schemes []vel.SecurityScheme) {
+	if len(schemes) == 0 {
+		return
+	}
+	// a single requirement lists every scheme, the request passes all of them
+	requirement := make(map[string][]string)
+	for _, scheme := range schemes {
+		if spec.Components.SecuritySchemes == nil {
+			spec.Components.SecuritySchemes = make(map[string]*OpenAPISecurityScheme)
+		}
+		spec.Components.SecuritySchemes[scheme.Name] = &OpenAPISecurityScheme{Type: "apiKey", In: "header", Name: scheme.Header, Description: scheme.Description}
+		requirement[scheme.Name] = []string{}
+	}
+	operation.Security = []map[string][]string{requirement}
+}
+
+// addWebhooks publishes the webhooks in the webhooks section, it needs OpenAPI 3.1,
+// the schemas of their bodies join the schemas of the components
+func (g *ClientGen) addWebhooks(spec *OpenAPISpec, schemas map[string]*OpenAPISchema) error {
+	if len(g.webhooks) == 0 {
+		return nil
+	}
+	spec.OpenAPI = "3.1.0"
+	spec.Webhooks = make(map[string]*OpenAPIPathItem)
+	for _, hook := range g.webhooks {
+		// the body is described as the input of a handler receiving the webhook
+		desc, err := extractApi(vel.HandlerMeta{Input: hook.Body, Output: struct{}{}, OperationID: hook.Name, Method: http.MethodPost})
+		if err != nil {
+			return fmt.Errorf("failed to describe the webhook %s: %w", hook.Name, err)
+		}
+		for _, dataType := range desc.DataTypes {
+			schemas[dataType.Name] = g.dataTypeToSchema(dataType)
+		}
+		operation := &OpenAPIOperation{
+			OperationID: hook.Name,
+			Description: hook.Description,
+			Parameters:  g.specToRequestHeaders(hook.Headers),
+			RequestBody: &OpenAPIRequestBody{
+				Content: &OpenAPIContent{
+					ApplicationJSON: &OpenAPIMediaType{
+						Schema: &OpenAPISchema{Ref: "#/components/schemas/" + desc.Input.Name},
+					},
+				},
+			},
+			Responses: map[string]*OpenAPIResponse{
+				"200": {Description: "The event is received, any 2xx status acknowledges it"},
+			},
+		}
+		addSecurity(spec, operation, hook.Security)
+		spec.Webhooks[hook.Name] = &OpenAPIPathItem{Post: operation}
+	}
+	return nil
+}
+
+// operationIDs converts the operation ids of the apis to the configured case,
+// it fails once different ids become the same one
+func (g *ClientGen) operationIDs() ([]string, error) {
+	ids := make([]string, len(g.meta.Apis))
+	sources := make(map[string]string, len(g.meta.Apis))
+	for i, api := range g.meta.Apis {
+		id, err := g.meta.Client.OperationIDCase.Apply(api.OperationID)
+		if err != nil {
+			return nil, err
+		}
+		if source, ok := sources[id]; ok && source != api.OperationID {
+			return nil, fmt.Errorf("operation ids %s and %s both become %s in %s case", source, api.OperationID, id, g.meta.Client.OperationIDCase)
+		}
+		sources[id] = api.OperationID
+		ids[i] = id
+	}
+	return ids, nil
+}
+
 func (g *ClientGen) dataTypeToSchema(dataType DataType) *OpenAPISchema {
+	if dataType.Primitive != "" {
+		schema := g.typeNameToSchema(dataType.Primitive, nil)
+		for _, value := range dataType.Enum {
+			schema.Enum = append(schema.Enum, value.Value)
+		}
+		return schema
+	}
+	if dataType.Union != nil {
+		return unionSchema(dataType.Union)
+	}
 	if len(dataType.Fields) == 0 {
 		return nil
 	}
@@ -554,16 +1941,10 @@ func (g *ClientGen) dataTypeToSchema(dataType DataType) *OpenAPISchema {
 	}
 
 	for _, field := range dataType.Fields {
-		propName := field.Name
-		if field.JsonTag != "" {
-			propName = field.JsonTag
-		}
+		schema.Properties[field.JsonName] = g.fieldToSchema(field)
 
-		schema.Properties[propName] = g.fieldToSchema(field)
-
-		// Add to required if not a pointer type
-		if !strings.HasPrefix(field.TypeName, "*") {
-			schema.Required = append(schema.Required, propName)
+		if !field.Optional {
+			schema.Required = append(schema.Required, field.JsonName)
 		}
 	}
 
@@ -571,27 +1952,31 @@ func (g *ClientGen) dataTypeToSchema(dataType DataType) *OpenAPISchema {
 }
 
 func (g *ClientGen) fieldToSchema(field Field) *OpenAPISchema {
-	return g.typeNameToSchema(field.TypeName)
-}
-
-func (g *ClientGen) specToRequestHeaders(spec vel.Spec) []*OpenAPIParameter {
-	if spec.RequestHeaders.Key == "" {
-		return nil
+	if field.AsString {
+		return &OpenAPISchema{Type: "string"}
 	}
-
-	param := &OpenAPIParameter{
-		Name:        spec.RequestHeaders.Key,
-		In:          "header",
-		Description: spec.RequestHeaders.Description,
-		Required:    spec.RequestHeaders.Validation.Required,
-		Schema:      g.primitiveTypeToSchemaWithValidation(spec.RequestHeaders.ValueType, spec.RequestHeaders.Validation),
+	if field.Discriminator != "" {
+		return &OpenAPISchema{Type: "string", Enum: []any{field.Discriminator}}
 	}
+	return g.typeNameToSchema(field.TypeName, mappedTypesOf(field.Type))
+}
 
-	if spec.RequestHeaders.ValueExample != "" {
-		param.Example = spec.RequestHeaders.ValueExample
+func (g *ClientGen) specToRequestHeaders(headers []vel.KeyValueSpec) []*OpenAPIParameter {
+	var params []*OpenAPIParameter
+	for _, h := range headers {
+		param := &OpenAPIParameter{
+			Name:        h.Key,
+			In:          "header",
+			Description: h.Description,
+			Required:    h.Validation.Required,
+			Schema:      g.primitiveTypeToSchemaWithValidation(h.ValueType, h.Validation),
+		}
+		if h.ValueExample != "" {
+			param.Example = h.ValueExample
+		}
+		params = append(params, param)
 	}
-
-	return []*OpenAPIParameter{param}
+	return params
 }
 
 func (g *ClientGen) specToResponseHeaders(spec vel.Spec) map[string]*OpenAPIHeader {
@@ -646,7 +2031,7 @@ func (g *ClientGen) primitiveTypeToSchemaWithValidation(primitiveType vel.Primit
 		schema.Maximum = &max
 	}
 	if len(validation.Enum) > 0 {
-		schema.Enum = validation.Enum
+		schema.Enum = stringValues(validation.Enum)
 	}
 
 	return schema
@@ -685,23 +2070,7 @@ func (g *ClientGen) specToErrorResponses(spec vel.Spec) map[string]*OpenAPIRespo
 			Description: consolidatedDescription,
 			Content: &OpenAPIContent{
 				ApplicationJSON: &OpenAPIMediaType{
-					Schema: &OpenAPISchema{
-						Type: "object",
-						Properties: map[string]*OpenAPISchema{
-							"code": {
-								Type: "string",
-								Enum: errorCodes,
-							},
-							"message": {
-								Type: "string",
-							},
-							"meta": {
-								Type:       "object",
-								Properties: allMetaProperties,
-							},
-						},
-						Required: []string{"code"},
-					},
+					Schema: g.errorSchema(errorCodes, allMetaProperties),
 				},
 			},
 		}
@@ -710,6 +2079,95 @@ func (g *ClientGen) specToErrorResponses(spec vel.Spec) map[string]*OpenAPIRespo
 	return responses
 }
 
+// errorSchema builds the schema of an error response following the error shape of the router
+func (g *ClientGen) errorSchema(errorCodes []string, metaProperties map[string]*OpenAPISchema) *OpenAPISchema {
+	shape := g.meta.ErrorShape
+	schema := &OpenAPISchema{
+		Type: "object",
+		Properties: map[string]*OpenAPISchema{
+			shape.CodeField: {
+				Type: "string",
+				Enum: stringValues(errorCodes),
+			},
+			shape.MessageField: {
+				Type: "string",
+			},
+			shape.MetaField: {
+				Type:       "object",
+				Properties: metaProperties,
+			},
+		},
+		Required: []string{shape.CodeField},
+	}
+	for _, extra := range shape.Extra {
+		extraSchema := g.primitiveTypeToSchemaWithValidation(extra.Spec.ValueType, extra.Spec.Validation)
+		extraSchema.Description = extra.Spec.Description
+		schema.Properties[extra.Key] = extraSchema
+		if extra.Spec.Validation.Required {
+			schema.Required = append(schema.Required, extra.Key)
+		}
+	}
+
+	if shape.Envelope == "" {
+		return schema
+	}
+	return &OpenAPISchema{
+		Type: "object",
+		Properties: map[string]*OpenAPISchema{
+			shape.Envelope: schema,
+		},
+		Required: []string{shape.Envelope},
+	}
+}
+
+// addViolationsResponse documents an error with its violations, e.g. VALIDATION_FAILED served with 422,
+// it extends the response of the status if the spec declares one already
+func (g *ClientGen) addViolationsResponse(operation *OpenAPIOperation, status int, code, reason string) {
+	description := fmt.Sprintf("* `%s` - %s", code, reason)
+	response, ok := operation.Responses[strconv.Itoa(status)]
+	if !ok {
+		response = &OpenAPIResponse{
+			Description: "Error codes:\n  " + description,
+			Content: &OpenAPIContent{
+				ApplicationJSON: &OpenAPIMediaType{
+					Schema: g.errorSchema(nil, nil),
+				},
+			},
+		}
+		operation.Responses[strconv.Itoa(status)] = response
+	} else {
+		response.Description += "\n  " + description
+	}
+
+	schema := response.Content.ApplicationJSON.Schema
+	if g.meta.ErrorShape.Envelope != "" {
+		schema = schema.Properties[g.meta.ErrorShape.Envelope]
+	}
+	schema.Properties[g.meta.ErrorShape.CodeField].Enum = append(schema.Properties[g.meta.ErrorShape.CodeField].Enum, code)
+	schema.Properties[g.meta.ErrorShape.ViolationsField] = &OpenAPISchema{
+		Type: "array",
+		Items: &OpenAPISchema{
+			Type: "object",
+			Properties: map[string]*OpenAPISchema{
+				"field": {
+					Type:        "string",
+					Description: "JSON name of the invalid field",
+				},
+				"rule": {
+					Type:        "string",
+					Description: "Failed rule, the known ones are " + strings.Join(g.meta.ErrorShape.Rules, ", "),
+				},
+				"params": {
+					Type:                 "object",
+					Description:          "Rule parameters, e.g. min for min_len",
+					AdditionalProperties: &OpenAPISchema{Type: "string"},
+				},
+			},
+			Required: []string{"field", "rule"},
+		},
+	}
+}
+
 func (g *ClientGen) errorMetaToProperties(meta []vel.KeyValueSpec) map[string]*OpenAPISchema {
 	if len(meta) == 0 {
 		return nil
@@ -750,7 +2208,7 @@ func (g *ClientGen) errorMetaToProperties(meta []vel.KeyValueSpec) map[string]*O
 			schema.Maximum = &max
 		}
 		if len(m.Validation.Enum) > 0 {
-			schema.Enum = m.Validation.Enum
+			schema.Enum = stringValues(m.Validation.Enum)
 		}
 
 		// Add description after all validation constraints
@@ -764,7 +2222,7 @@ func (g *ClientGen) errorMetaToProperties(meta []vel.KeyValueSpec) map[string]*O
 	return properties
 }
 
-func (g *ClientGen) typeNameToSchema(typeName string) *OpenAPISchema {
+func (g *ClientGen) typeNameToSchema(typeName string, mapped mappedTypes) *OpenAPISchema {
 	switch typeName {
 	case "string":
 		return &OpenAPISchema{Type: "string"}
@@ -779,11 +2237,9 @@ func (g *ClientGen) typeNameToSchema(typeName string) *OpenAPISchema {
 			Type:  "array",
 			Items: &OpenAPISchema{Type: "integer"},
 		}
-	case "time.Time":
-		return &OpenAPISchema{
-			Type:   "string",
-			Format: "date-time",
-		}
+	}
+	if mapping, ok := mapped[typeName]; ok {
+		return mapping.schema()
 	}
 
 	// Handle arrays
@@ -791,27 +2247,23 @@ func (g *ClientGen) typeNameToSchema(typeName string) *OpenAPISchema {
 		elemType := typeName[2:]
 		return &OpenAPISchema{
 			Type:  "array",
-			Items: g.typeNameToSchema(elemType),
+			Items: g.typeNameToSchema(elemType, mapped),
 		}
 	}
 
 	// Handle maps
 	if strings.HasPrefix(typeName, "map[") {
-		parts := strings.Split(typeName[4:], "]")
-		if len(parts) == 2 {
-			valueType := parts[1]
+		if _, valueType, ok := strings.Cut(typeName[4:], "]"); ok {
 			return &OpenAPISchema{
 				Type:                 "object",
-				AdditionalProperties: g.typeNameToSchema(valueType),
+				AdditionalProperties: g.typeNameToSchema(valueType, mapped),
 			}
 		}
 	}
 
-	// Handle pointers - remove the * and reference the type
+	// Handle pointers - remove the * and describe the underlying type
 	if strings.HasPrefix(typeName, "*") {
-		return &OpenAPISchema{
-			Ref: "#/components/schemas/" + typeName[1:],
-		}
+		return g.typeNameToSchema(typeName[1:], mapped)
 	}
 
 	// Reference to another schema
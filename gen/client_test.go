@@ -2,11 +2,38 @@ package gen
 
 import (
 	"bytes"
+	"cmp"
+	"context"
 	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"log/slog"
+	"maps"
+	"math/big"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/dennypenta/vel"
+	"github.com/dennypenta/vel/velhook"
+	_ "github.com/dennypenta/vel/veluuid"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
 )
 
 //go:embed testdata/test.go
@@ -15,6 +42,9 @@ var infoClientOutputGo string
 //go:embed testdata/test.ts
 var infoClientOutputTs string
 
+//go:embed testdata/test.zod.ts
+var infoClientOutputZod string
+
 //go:embed testdata/openapi.yaml
 var expectedOpenAPIYAML string
 
@@ -38,9 +68,8 @@ type TestStruct struct {
 	NextLevelSlice   []TestNextLevelElem   `json:"slice"`
 	Map              map[int]MapValue      `json:"map"`
 	NextLevelNestedP *TestNextLevelStructP `json:"nextP"`
-	// TODO: Highlight as not supported (who ever might need them??? )
-	// NextLevelSliceP  []*TestNextLevelElemP `json:"sliceP"`
-	// MapP             map[int]*MapValueP    `json:"mapP"`
+	NextLevelSliceP  []*TestNextLevelElemP `json:"sliceP"`
+	MapP             map[int]*MapValueP    `json:"mapP"`
 }
 
 type TestNextLevelStruct struct {
@@ -56,6 +85,12 @@ type MapValue struct {
 type TestNextLevelStructP struct {
 	Extra string `json:"extra"`
 }
+type TestNextLevelElemP struct {
+	Int int `json:"int"`
+}
+type MapValueP struct {
+	Value string
+}
 type HighElem struct {
 	Int int `json:"int"`
 }
@@ -68,6 +103,12 @@ type HighPointer struct {
 
 type Empty struct{}
 
+// HeaderRequest is filled by a middleware, the handler doesn't decode its body
+type HeaderRequest struct {
+	vel.NoBody
+	Tenant string `json:"tenant"`
+}
+
 // Custom assertion functions to replace testify
 func assertEqual(t *testing.T, expected, actual interface{}) {
 	t.Helper()
@@ -76,7 +117,7 @@ func assertEqual(t *testing.T, expected, actual interface{}) {
 	}
 }
 
-func requireNoError(t *testing.T, err error) {
+func requireNoError(t testing.TB, err error) {
 	t.Helper()
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
@@ -84,8 +125,9 @@ func requireNoError(t *testing.T, err error) {
 }
 
 type GetQuery struct {
-	Value string `schema:"value"`
-	Field int    `schema:"field"`
+	Value string    `schema:"value"`
+	Field int       `schema:"field"`
+	Since time.Time `schema:"since"`
 }
 
 type GetResp struct {
@@ -97,6 +139,13 @@ type TimeTestRequest struct {
 	Name      string    `json:"name"`
 }
 
+func (r TimeTestRequest) Validate() []vel.Violation {
+	if len(r.Name) < 3 {
+		return []vel.Violation{vel.ViolationMinLen("name", 3)}
+	}
+	return nil
+}
+
 type TimeTestResponse struct {
 	ProcessedAt time.Time `json:"processedAt"`
 	ID          string    `json:"id"`
@@ -104,7 +153,7 @@ type TimeTestResponse struct {
 
 func TestGenClient(t *testing.T) {
 	if testing.Short() {
-		t.Skip("skip: requires goimports installation")
+		t.Skip("skip: requires prettier installation")
 	}
 
 	type testCase struct {
@@ -112,6 +161,7 @@ func TestGenClient(t *testing.T) {
 		expected       string
 		templateName   string
 		postProcessing string
+		zod            bool
 	}
 
 	for _, tc := range []testCase{
@@ -127,6 +177,13 @@ func TestGenClient(t *testing.T) {
 			templateName:   "ts:default",
 			postProcessing: "prettier --parser typescript",
 		},
+		{
+			name:           "ts-zod",
+			expected:       infoClientOutputZod,
+			templateName:   "ts:default",
+			postProcessing: "prettier --parser typescript",
+			zod:            true,
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			buf := &bytes.Buffer{}
@@ -134,11 +191,14 @@ func TestGenClient(t *testing.T) {
 			gener, err := New(ClientDesc{
 				TypeName:    "Client",
 				PackageName: "client",
+				Zod:         tc.zod,
 			}, []vel.HandlerMeta{
 				{Input: TestTypeNoJsonTags{}, Output: TestTypeNoJsonTags{}, OperationID: "test1", Method: "POST"},
 				{Input: TestTypeNestedTypes{}, Output: TestTypeNestedTypes{}, OperationID: "test2", Method: "POST"},
 				{Input: struct{}{}, Output: Empty{}, OperationID: "testEmpty", Method: "POST"},
-				{Input: GetQuery{}, Output: GetResp{}, OperationID: "testGet", Method: "GET"},
+				{Input: GetQuery{}, Output: GetResp{}, OperationID: "testGet", Method: "GET", Spec: vel.Spec{
+					Cache: vel.CachePolicy{MaxAge: time.Minute, IgnoreQuery: []string{"trace"}, VaryHeaders: []string{"X-Tenant"}},
+				}},
 				{Input: TimeTestRequest{}, Output: TimeTestResponse{}, OperationID: "testTime", Method: "POST"},
 			})
 			requireNoError(t, err)
@@ -229,7 +289,9 @@ func TestGenOpenAPI(t *testing.T) {
 		},
 		{Input: TestTypeNestedTypes{}, Output: TestTypeNestedTypes{}, OperationID: "test2", Method: "POST"},
 		{Input: struct{}{}, Output: Empty{}, OperationID: "testEmpty", Method: "POST"},
-		{Input: GetQuery{}, Output: GetResp{}, OperationID: "testGet", Method: "GET"},
+		{Input: GetQuery{}, Output: GetResp{}, OperationID: "testGet", Method: "GET", Spec: vel.Spec{
+			Cache: vel.CachePolicy{MaxAge: time.Minute, VaryHeaders: []string{"X-Tenant"}},
+		}},
 		{Input: TimeTestRequest{}, Output: TimeTestResponse{}, OperationID: "testTime", Method: "POST"},
 	})
 	requireNoError(t, err)
@@ -239,3 +301,3371 @@ func TestGenOpenAPI(t *testing.T) {
 
 	assertEqual(t, expectedOpenAPIYAML, buf.String())
 }
+
+func TestGenHeaders(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	gener, err := New(ClientDesc{
+		TypeName:    "Client",
+		PackageName: "headers",
+	}, []vel.HandlerMeta{
+		{
+			Input: TestTypeNoJsonTags{}, Output: TestTypeNoJsonTags{}, OperationID: "test1", Method: "POST", Spec: vel.Spec{
+				RequestHeaders:  vel.KeyValueSpec{Key: "X-API-Key", Description: "API key for authentication"},
+				ResponseHeaders: vel.KeyValueSpec{Key: "X-Rate-Limit"},
+			},
+		},
+		{
+			Input: GetQuery{}, Output: GetResp{}, OperationID: "testGet", Method: "GET", Spec: vel.Spec{
+				RequestHeaders: vel.KeyValueSpec{Key: "X-API-Key"},
+			},
+		},
+	})
+	requireNoError(t, err)
+
+	err = gener.Generate(buf, "go:headers", "")
+	requireNoError(t, err)
+
+	expected := `package headers
+
+// Headers declared in the API specs.
+const (
+	// HeaderXAPIKey API key for authentication
+	HeaderXAPIKey = "X-API-Key"
+	HeaderXRateLimit = "X-Rate-Limit"
+)
+`
+	assertEqual(t, expected, buf.String())
+}
+
+func TestGenOpenAPIErrorSchema(t *testing.T) {
+	gener, err := New(ClientDesc{
+		TypeName:    "Client",
+		PackageName: "client",
+		ErrorSchema: vel.ErrorSchema{
+			Envelope: "error",
+			Extra:    []vel.KeyValueSpec{{Key: "requestId", ValueType: vel.String, Validation: vel.Validation{Required: true}}},
+		},
+	}, []vel.HandlerMeta{
+		{Input: TestTypeNoJsonTags{}, Output: TestTypeNoJsonTags{}, OperationID: "test1", Method: "POST", Spec: vel.Spec{
+			Errors: map[int][]vel.ErrorSpec{400: {{Code: "ERROR_CODE"}}},
+		}},
+	})
+	requireNoError(t, err)
+
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+
+	schema := spec.Paths["/test1"].Post.Responses["400"].Content.ApplicationJSON.Schema
+	envelope, ok := schema.Properties["error"]
+	if !ok {
+		t.Fatalf("expected error envelope, got %v", schema.Properties)
+	}
+	assertEqual(t, "ERROR_CODE", envelope.Properties["code"].Enum[0])
+	assertEqual(t, "string", envelope.Properties["requestId"].Type)
+	assertEqual(t, 2, len(envelope.Required))
+}
+
+func TestGenOpenAPIRedirects(t *testing.T) {
+	gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client"}, []vel.HandlerMeta{
+		{Input: GetQuery{}, Output: GetResp{}, OperationID: "testGet", Method: "GET", Spec: vel.Spec{
+			Redirects: []vel.RedirectSpec{{Status: 301}, {Status: 307, Description: "Moved to v2"}},
+		}},
+	})
+	requireNoError(t, err)
+
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+
+	responses := spec.Paths["/testGet"].Get.Responses
+	assertEqual(t, "Moved Permanently", responses["301"].Description)
+	assertEqual(t, "Moved to v2", responses["307"].Description)
+	assertEqual(t, true, responses["307"].Headers["Location"].Required)
+}
+
+func TestGenClientFiles(t *testing.T) {
+	meta := []vel.HandlerMeta{
+		{Input: TestTypeNoJsonTags{}, Output: TestTypeNoJsonTags{}, OperationID: "test1", Method: "POST"},
+		{Input: TestTypeNestedTypes{}, Output: TestTypeNestedTypes{}, OperationID: "test2", Method: "POST"},
+		{Input: GetQuery{}, Output: GetResp{}, OperationID: "testGet", Method: "GET"},
+		{Input: TimeTestRequest{}, Output: TimeTestResponse{}, OperationID: "testTime", Method: "POST"},
+	}
+	gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client"}, meta)
+	requireNoError(t, err)
+
+	t.Run("go", func(t *testing.T) {
+		dir := t.TempDir()
+		requireNoError(t, gener.GenerateFiles(dir, "go", "goimports"))
+
+		single := &bytes.Buffer{}
+		requireNoError(t, gener.Generate(single, "go:default", "goimports"))
+		expected := declNames(t, single.Bytes())
+
+		fileDecls := make(map[string]string)
+		for _, name := range []string{"types.go", "errors.go", "client.go"} {
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			requireNoError(t, err)
+			for decl := range declNames(t, data) {
+				if other, ok := fileDecls[decl]; ok {
+					t.Errorf("%s is declared in both %s and %s", decl, other, name)
+				}
+				fileDecls[decl] = name
+			}
+		}
+		assertEqual(t, len(expected), len(fileDecls))
+		for decl := range expected {
+			if _, ok := fileDecls[decl]; !ok {
+				t.Errorf("%s is missing in the split client", decl)
+			}
+		}
+		assertEqual(t, "types.go", fileDecls["TestStruct"])
+		assertEqual(t, "errors.go", fileDecls["Error"])
+		assertEqual(t, "client.go", fileDecls["Client"])
+	})
+
+	t.Run("ts", func(t *testing.T) {
+		dir := t.TempDir()
+		requireNoError(t, gener.GenerateFiles(dir, "ts", ""))
+
+		types, err := os.ReadFile(filepath.Join(dir, "types.ts"))
+		requireNoError(t, err)
+		client, err := os.ReadFile(filepath.Join(dir, "client.ts"))
+		requireNoError(t, err)
+
+		assertEqual(t, true, strings.Contains(string(types), "export type TestStruct = {"))
+		assertEqual(t, false, strings.Contains(string(types), "class Client"))
+		assertEqual(t, false, strings.Contains(string(client), "export type TestStruct = {"))
+		assertEqual(t, true, strings.Contains(string(client),
+			"import type { ApiErrorPayload, Result, TestTypeNoJsonTags, TestTypeNestedTypes, GetQuery, GetResp, TimeTestRequest, TimeTestResponse, TestTimeError } from './types'"))
+	})
+}
+
+// declNames collects the top level declarations of a Go file, methods are prefixed by their receiver
+func declNames(t *testing.T, src []byte) map[string]struct{} {
+	t.Helper()
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	requireNoError(t, err)
+
+	names := make(map[string]struct{})
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			name := decl.Name.Name
+			if decl.Recv != nil {
+				name = types.ExprString(decl.Recv.List[0].Type) + "." + name
+			}
+			names[name] = struct{}{}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					names[spec.Name.Name] = struct{}{}
+				case *ast.ValueSpec:
+					for _, n := range spec.Names {
+						names[n.Name] = struct{}{}
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+func TestRun(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "test2", vel.Handler[TestTypeNestedTypes, TestTypeNestedTypes](
+		func(ctx context.Context, req TestTypeNestedTypes) (TestTypeNestedTypes, *vel.Error) {
+			return req, nil
+		},
+	)).SetSpec(vel.Spec{Audiences: []vel.Audience{vel.AudiencePublic, vel.AudiencePartner}})
+	vel.RegisterPost(router, "test1", vel.Handler[TestTypeNoJsonTags, TestTypeNoJsonTags](
+		func(ctx context.Context, req TestTypeNoJsonTags) (TestTypeNoJsonTags, *vel.Error) {
+			return req, nil
+		},
+	)).SetSpec(vel.Spec{Audiences: []vel.Audience{vel.AudiencePartner}})
+	vel.RegisterGet(router, "testGet", vel.Handler[GetQuery, GetResp](
+		func(ctx context.Context, req GetQuery) (GetResp, *vel.Error) {
+			return GetResp{}, nil
+		},
+	))
+
+	dir := t.TempDir()
+	outputs := make([]AudienceOutput, 0, 3)
+	for _, audience := range []vel.Audience{vel.AudiencePublic, vel.AudiencePartner, vel.AudienceInternal} {
+		outputs = append(outputs, AudienceOutput{
+			Audience:    audience,
+			OpenAPIPath: filepath.Join(dir, string(audience)+".yaml"),
+			Title:       "Test API",
+			Version:     "1.0.0",
+			Clients: []ClientGeneratorConfig{{
+				TypeName: "Client", PackageName: "client", Language: "ts",
+				OutputDir: filepath.Join(dir, string(audience)),
+			}},
+		})
+	}
+	requireNoError(t, Run(router, outputs...))
+
+	expected := map[vel.Audience][]string{
+		vel.AudiencePublic:   {"/test2"},
+		vel.AudiencePartner:  {"/test2", "/test1"},
+		vel.AudienceInternal: {"/test2", "/test1", "/testGet"},
+	}
+	for audience, paths := range expected {
+		data, err := os.ReadFile(filepath.Join(dir, string(audience)+".yaml"))
+		requireNoError(t, err)
+		var spec OpenAPISpec
+		requireNoError(t, yaml.Unmarshal(data, &spec))
+		assertEqual(t, len(paths), len(spec.Paths))
+		for _, path := range paths {
+			if _, ok := spec.Paths[path]; !ok {
+				t.Errorf("%s spec misses %s", audience, path)
+			}
+		}
+		// the nested type keeps its name in every audience publishing it
+		if _, ok := spec.Components.Schemas["TestStruct"]; !ok {
+			t.Errorf("%s spec misses TestStruct schema", audience)
+		}
+
+		client, err := os.ReadFile(filepath.Join(dir, string(audience), "client.ts"))
+		requireNoError(t, err)
+		assertEqual(t, audience == vel.AudienceInternal, strings.Contains(string(client), "async TestGet("))
+	}
+}
+
+func TestGenClientGroups(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "test1", vel.Handler[TestTypeNoJsonTags, TestTypeNoJsonTags](
+		func(ctx context.Context, req TestTypeNoJsonTags) (TestTypeNoJsonTags, *vel.Error) {
+			return req, nil
+		},
+	))
+	v1 := router.Subrouter("v1")
+	vel.RegisterPost(v1, "test1", vel.Handler[TestTypeNoJsonTags, TestTypeNoJsonTags](
+		func(ctx context.Context, req TestTypeNoJsonTags) (TestTypeNoJsonTags, *vel.Error) {
+			return req, nil
+		},
+	)).SetSpec(vel.Spec{Errors: map[int][]vel.ErrorSpec{400: {{Code: "ERROR_CODE"}}}})
+	vel.RegisterGet(v1.Subrouter("admin"), "testGet", vel.Handler[GetQuery, GetResp](
+		func(ctx context.Context, req GetQuery) (GetResp, *vel.Error) {
+			return GetResp{}, nil
+		},
+	))
+
+	t.Run("go", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		requireNoError(t, GenerateClient(router, buf, ClientGeneratorConfig{TypeName: "Client", PackageName: "client", Language: "go"}))
+		decls := declNames(t, buf.Bytes())
+		for _, decl := range []string{"*Client.Test1", "ClientV1", "*ClientV1.Test1", "ClientV1Admin", "*ClientV1Admin.TestGet", "ClientV1AdminAPI", "*MockClientV1Admin.TestGet"} {
+			if _, ok := decls[decl]; !ok {
+				t.Errorf("%s is not declared", decl)
+			}
+		}
+		assertEqual(t, true, strings.Contains(buf.String(), `c.baseUrl+"/v1/admin/testGet?"`))
+	})
+
+	t.Run("ts", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		requireNoError(t, GenerateClient(router, buf, ClientGeneratorConfig{TypeName: "Client", PackageName: "client", Language: "ts"}))
+		client := buf.String()
+		assertEqual(t, true, strings.Contains(client, "this.V1 = new ClientV1(request)"))
+		assertEqual(t, true, strings.Contains(client, "this.Admin = new ClientV1Admin(request)"))
+		assertEqual(t, true, strings.Contains(client, "export type V1Test1Error ="))
+		assertEqual(t, true, strings.Contains(client, "return await this.get('v1/admin/testGet'"))
+	})
+
+	t.Run("openapi", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		requireNoError(t, GenerateOpenAPI(router, buf, "Test API", "1.0.0"))
+		var spec OpenAPISpec
+		requireNoError(t, yaml.Unmarshal(buf.Bytes(), &spec))
+		for _, path := range []string{"/test1", "/v1/test1", "/v1/admin/testGet"} {
+			if _, ok := spec.Paths[path]; !ok {
+				t.Errorf("%s is missing", path)
+			}
+		}
+	})
+}
+
+func TestOperationIDCase(t *testing.T) {
+	tests := []struct {
+		id    string
+		camel string
+		snake string
+	}{
+		{id: "listUsers", camel: "listUsers", snake: "list_users"},
+		{id: "list_users", camel: "listUsers", snake: "list_users"},
+		{id: "get-user", camel: "getUser", snake: "get_user"},
+		{id: "getHTTPStatus", camel: "getHttpStatus", snake: "get_http_status"},
+		{id: "test1", camel: "test1", snake: "test1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			camel, err := OperationIDCamel.Apply(tt.id)
+			requireNoError(t, err)
+			assertEqual(t, tt.camel, camel)
+			snake, err := OperationIDSnake.Apply(tt.id)
+			requireNoError(t, err)
+			assertEqual(t, tt.snake, snake)
+		})
+	}
+
+	t.Run("collision", func(t *testing.T) {
+		gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client", OperationIDCase: OperationIDSnake}, []vel.HandlerMeta{
+			{Input: TestTypeNoJsonTags{}, Output: TestTypeNoJsonTags{}, OperationID: "listUsers", Method: "POST"},
+			{Input: TestTypeNoJsonTags{}, Output: TestTypeNoJsonTags{}, OperationID: "list_users", Method: "POST"},
+		})
+		requireNoError(t, err)
+		_, err = gener.GenerateOpenAPI("Test API", "1.0.0")
+		assertEqual(t, "operation ids listUsers and list_users both become list_users in snake case", err.Error())
+	})
+}
+
+func TestPostProcessor(t *testing.T) {
+	t.Run("goimports", func(t *testing.T) {
+		out, err := PostProcessorOf("goimports")([]byte("package client\nfunc f() { fmt.Println(strings.ToLower(\"A\")) }\n"))
+		requireNoError(t, err)
+		assertEqual(t, "package client\n\nimport (\n\t\"fmt\"\n\t\"strings\"\n)\n\nfunc f() { fmt.Println(strings.ToLower(\"A\")) }\n", string(out))
+	})
+
+	t.Run("shell", func(t *testing.T) {
+		// the command is run by the shell, so it may pipe and quote
+		out, err := PostProcessorOf("tr a-z A-Z | sed 's/CLIENT/client/'")([]byte("package client\n"))
+		requireNoError(t, err)
+		assertEqual(t, "PACKAGE client\n", string(out))
+
+		_, err = PostProcessorOf("echo broken >&2; exit 1")([]byte("package client\n"))
+		assertEqual(t, true, err != nil && strings.Contains(err.Error(), "broken"))
+	})
+
+	t.Run("config", func(t *testing.T) {
+		router := vel.NewRouter()
+		vel.RegisterPost(router, "test1", vel.Handler[TestTypeNoJsonTags, TestTypeNoJsonTags](
+			func(ctx context.Context, req TestTypeNoJsonTags) (TestTypeNoJsonTags, *vel.Error) {
+				return req, nil
+			},
+		))
+
+		buf := &bytes.Buffer{}
+		requireNoError(t, GenerateClient(router, buf, ClientGeneratorConfig{
+			TypeName: "Client", PackageName: "client", Language: "ts",
+			PostProcess: "prettier",
+			PostProcessor: func(src []byte) ([]byte, error) {
+				return append([]byte("// formatted\n"), src...), nil
+			},
+		}))
+		assertEqual(t, true, strings.HasPrefix(buf.String(), "// formatted\n"))
+	})
+}
+
+func TestCommand(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "test1", vel.Handler[TestTypeNoJsonTags, TestTypeNoJsonTags](
+		func(ctx context.Context, req TestTypeNoJsonTags) (TestTypeNoJsonTags, *vel.Error) {
+			return req, nil
+		},
+	))
+	vel.RegisterGet(router.Subrouter("v1"), "testGet", vel.Handler[GetQuery, GetResp](
+		func(ctx context.Context, req GetQuery) (GetResp, *vel.Error) {
+			return GetResp{}, nil
+		},
+	))
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "vel.yaml")
+	config := `router: ./api.NewRouter
+clients:
+  - language: go
+    outputDir: ` + filepath.Join(dir, "go") + `
+  - language: ts
+    outputDir: ` + filepath.Join(dir, "ts") + `
+openapi:
+  output: ` + filepath.Join(dir, "openapi.yaml") + `
+  title: Test API
+  version: 1.0.0
+postman:
+  output: ` + filepath.Join(dir, "api.postman_collection.json") + `
+  name: Test API
+contract:
+  output: ` + filepath.Join(dir, "api", "contract_test.go") + `
+`
+	requireNoError(t, os.WriteFile(configPath, []byte(config), 0644))
+
+	t.Run("routes", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		requireNoError(t, runCommand(router, []string{"routes"}, buf))
+		assertEqual(t, "METHOD  PATH         OPERATION\nPOST    /test1       test1\nGET     /v1/testGet  testGet\n", buf.String())
+	})
+
+	t.Run("gen client", func(t *testing.T) {
+		out := filepath.Join(dir, "sdk")
+		requireNoError(t, runCommand(router, []string{"-config", configPath, "gen", "client", "-lang", "ts", "-out", out}, io.Discard))
+		_, err := os.Stat(filepath.Join(out, "client.ts"))
+		requireNoError(t, err)
+		_, err = os.Stat(filepath.Join(dir, "go", "client.go"))
+		assertEqual(t, true, os.IsNotExist(err))
+	})
+
+	t.Run("arguments", func(t *testing.T) {
+		emptyConfig := filepath.Join(dir, "empty.yaml")
+		requireNoError(t, os.WriteFile(emptyConfig, []byte("router: ./api.NewRouter\n"), 0644))
+		for _, tc := range []struct {
+			name    string
+			args    []string
+			wantErr string
+		}{
+			{"no command", nil, "command is required: gen or routes"},
+			{"unknown command", []string{"build"}, "unknown command build"},
+			{"unknown flag", []string{"-verbose", "routes"}, "flag provided but not defined: -verbose"},
+			{"missing config", []string{"-config", filepath.Join(dir, "missing.yaml"), "gen"}, "missing.yaml"},
+			{"unknown gen flag", []string{"-config", emptyConfig, "gen", "-format"}, "flag provided but not defined: -format"},
+			{"undeclared client", []string{"-config", configPath, "gen", "client", "-lang", "kotlin"}, "no kotlin client is declared in the config"},
+			{"openapi without output", []string{"-config", emptyConfig, "gen", "openapi"}, "openapi output is not set"},
+			{"postman without output", []string{"-config", emptyConfig, "gen", "postman"}, "postman output is not set"},
+			{"contract without output", []string{"-config", emptyConfig, "gen", "contract"}, "contract output is not set"},
+			{"json without output", []string{"-config", emptyConfig, "gen", "json"}, "json output is not set"},
+			{"nothing declared", []string{"-config", emptyConfig, "gen"}, ""},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				err := runCommand(router, tc.args, io.Discard)
+				if tc.wantErr == "" {
+					requireNoError(t, err)
+					return
+				}
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("expected an error containing %q, got %v", tc.wantErr, err)
+				}
+			})
+		}
+	})
+
+	t.Run("gen", func(t *testing.T) {
+		requireNoError(t, runCommand(router, []string{"-config", configPath, "gen"}, io.Discard))
+		for _, path := range []string{"go/client.go", "ts/client.ts", "openapi.yaml", "api.postman_collection.json"} {
+			_, err := os.Stat(filepath.Join(dir, path))
+			requireNoError(t, err)
+		}
+		// the tests call the constructor of the config
+		contract, err := os.ReadFile(filepath.Join(dir, "api", "contract_test.go"))
+		requireNoError(t, err)
+		assertEqual(t, true, strings.Contains(string(contract), "package api\n") && strings.Contains(string(contract), "NewRouter().Mux()"))
+	})
+}
+
+func TestWatch(t *testing.T) {
+	prev := WatchInterval
+	WatchInterval = 10 * time.Millisecond
+	defer func() { WatchInterval = prev }()
+
+	dir := t.TempDir()
+	t.Chdir(dir)
+	requireNoError(t, os.WriteFile("api.go", []byte("package api\n"), 0644))
+
+	built := make(chan struct{}, 10)
+	factory := func() *vel.Router {
+		router := vel.NewRouter()
+		vel.RegisterPost(router, "test1", vel.Handler[TestTypeNoJsonTags, TestTypeNoJsonTags](
+			func(ctx context.Context, req TestTypeNoJsonTags) (TestTypeNoJsonTags, *vel.Error) {
+				return req, nil
+			},
+		))
+		built <- struct{}{}
+		return router
+	}
+	// the outputs are absolute, so a generation running late doesn't write them to the package
+	config := Config{
+		Clients: []ClientGeneratorConfig{{TypeName: "Client", PackageName: "client", OutputDir: filepath.Join(dir, "sdk"), Language: "go"}},
+		OpenAPI: OpenAPIFileConfig{Output: filepath.Join(dir, "openapi.yaml")},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var watchErr error
+	stopped := make(chan struct{})
+	go func() {
+		watchErr = Watch(ctx, factory, config)
+		close(stopped)
+	}()
+	// the watch stops before the working directory is restored, even if the test fails
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+
+	waitBuilt := func() {
+		t.Helper()
+		select {
+		case <-built:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the router to be built")
+		}
+	}
+	waitBuilt()
+	// the router is built before the outputs are written
+	for _, path := range []string{"sdk/client.go", "openapi.yaml"} {
+		deadline := time.Now().Add(5 * time.Second)
+		for _, err := os.Stat(path); err != nil; _, err = os.Stat(path) {
+			if time.Now().After(deadline) {
+				t.Fatal(err)
+			}
+			time.Sleep(WatchInterval)
+		}
+	}
+
+	requireNoError(t, os.WriteFile("api.go", []byte("package api\n\nfunc NewRouter() {}\n"), 0644))
+	waitBuilt()
+
+	// the generated client is ignored, so it doesn't trigger another generation
+	time.Sleep(10 * WatchInterval)
+	assertEqual(t, 0, len(built))
+
+	cancel()
+	<-stopped
+	requireNoError(t, watchErr)
+}
+
+func TestCompositeTypeNames(t *testing.T) {
+	type Item struct {
+		Name string `json:"name"`
+	}
+	type Catalog struct {
+		Sections map[string][]*Item `json:"sections"`
+	}
+	dataType, err := extractDataType(reflect.TypeFor[Catalog](), "")
+	requireNoError(t, err)
+	field := dataType.Fields[0]
+
+	assertEqual(t, "map[string][]*Item", field.TypeName)
+	assertEqual(t, "Record<string, (Item | undefined)[]>", field.TSTypeName)
+	assertEqual(t, "z.record(z.string(), z.array(z.lazy(() => ItemSchema).nullish()).nullable()).nullable()", field.ZodType)
+
+	schema := (&ClientGen{}).typeNameToSchema(field.TypeName, nil)
+	assertEqual(t, "#/components/schemas/Item", schema.AdditionalProperties.Items.Ref)
+
+	types, err := collectStructs(Field{Type: reflect.TypeFor[Catalog](), TypeName: "Catalog"}, map[string]struct{}{})
+	requireNoError(t, err)
+	assertEqual(t, 2, len(types))
+	assertEqual(t, "Item", types[1].Name)
+}
+
+type embeddedAudit struct {
+	CreatedBy string `json:"createdBy"`
+	Version   int    `json:"version"`
+}
+
+type embeddedBase struct {
+	ID string `json:"id"`
+}
+
+type EmbeddedNamed struct {
+	Title string
+	Kind  string `json:"Kind"`
+}
+
+type EmbeddedAlias struct {
+	Title string
+	Kind  string
+}
+
+type EmbeddedTagged struct {
+	X int `json:"x"`
+}
+
+type EmbeddedEntity struct {
+	embeddedBase
+	*embeddedAudit
+	EmbeddedNamed
+	EmbeddedAlias
+	EmbeddedTagged `json:"tagged"`
+	Version        string `json:"version"`
+	Note           string `json:"-"`
+}
+
+func TestEmbeddedStructs(t *testing.T) {
+	dataType, err := extractDataType(reflect.TypeFor[EmbeddedEntity](), "")
+	requireNoError(t, err)
+
+	var fields []string
+	for _, field := range dataType.Fields {
+		fields = append(fields, field.JsonTag+" "+field.TypeName)
+	}
+	assertEqual(t, `id string,Kind string,tagged EmbeddedTagged,version string`, strings.Join(fields, ","))
+
+	// the fields are the keys encoding/json produces
+	data, err := json.Marshal(EmbeddedEntity{})
+	requireNoError(t, err)
+	var encoded map[string]any
+	requireNoError(t, json.Unmarshal(data, &encoded))
+	assertEqual(t, len(encoded), len(dataType.Fields))
+	for _, field := range dataType.Fields {
+		if _, ok := encoded[cmp.Or(field.JsonTag, field.Name)]; !ok {
+			t.Errorf("expected %s to be encoded, got %s", field.JsonTag, data)
+		}
+	}
+
+	gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client"}, []vel.HandlerMeta{
+		{Input: EmbeddedEntity{}, Output: EmbeddedEntity{}, OperationID: "entity", Method: "POST"},
+	})
+	requireNoError(t, err)
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+	schema := spec.Components.Schemas["EmbeddedEntity"]
+	assertEqual(t, 4, len(schema.Properties))
+	assertEqual(t, "#/components/schemas/EmbeddedTagged", schema.Properties["tagged"].Ref)
+	if _, ok := spec.Components.Schemas["EmbeddedNamed"]; ok {
+		t.Error("expected embedded struct not to be a schema")
+	}
+}
+
+func TestSpecHash(t *testing.T) {
+	newRouter := func(description string) *vel.Router {
+		router := vel.NewRouter()
+		vel.RegisterPost(router, "test1", func(ctx context.Context, req TestTypeNoJsonTags) (TestTypeNoJsonTags, *vel.Error) {
+			return req, nil
+		}).SetSpec(vel.Spec{Description: description, Audiences: []vel.Audience{vel.AudiencePublic}})
+		vel.RegisterGet(router, "testGet", func(ctx context.Context, req GetQuery) (GetResp, *vel.Error) {
+			return GetResp{}, nil
+		})
+		return router
+	}
+	hash, err := SpecHash(newRouter("first"))
+	requireNoError(t, err)
+
+	described, err := SpecHash(newRouter("second"))
+	requireNoError(t, err)
+	assertEqual(t, hash, described)
+
+	changed, err := SpecHash(vel.NewRouter())
+	requireNoError(t, err)
+	if changed == hash {
+		t.Error("expected the hash to change with the routes")
+	}
+
+	// a client of an audience carries the hash of the whole router
+	dir := t.TempDir()
+	requireNoError(t, Run(newRouter("first"), AudienceOutput{
+		Audience: vel.AudiencePublic,
+		Clients:  []ClientGeneratorConfig{{TypeName: "Client", PackageName: "client", OutputDir: dir, Language: "ts"}},
+	}))
+	client, err := os.ReadFile(filepath.Join(dir, "client.ts"))
+	requireNoError(t, err)
+	assertEqual(t, true, strings.Contains(string(client), "export const SPEC_HASH = '"+hash+"'"))
+}
+
+func TestGenerateFilesSkipUnchanged(t *testing.T) {
+	meta := []vel.HandlerMeta{
+		{Input: TestTypeNoJsonTags{}, Output: TestTypeNoJsonTags{}, OperationID: "test1", Method: "POST"},
+	}
+	formatted := 0
+	postProcessor := func(src []byte) ([]byte, error) {
+		formatted++
+		return src, nil
+	}
+	dir := t.TempDir()
+	generate := func(meta []vel.HandlerMeta) []string {
+		t.Helper()
+		gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client"}, meta)
+		requireNoError(t, err)
+		written, err := gener.GenerateFilesSkipUnchanged(dir, "ts", postProcessor)
+		requireNoError(t, err)
+		return written
+	}
+
+	assertEqual(t, "[types.ts client.ts]", fmt.Sprint(generate(meta)))
+	assertEqual(t, 2, formatted)
+
+	assertEqual(t, 0, len(generate(meta)))
+	assertEqual(t, 2, formatted)
+
+	// a new operation changes the client, its types are declared already
+	meta = append(meta, vel.HandlerMeta{Input: TestTypeNoJsonTags{}, Output: TestTypeNoJsonTags{}, OperationID: "test2", Method: "POST"})
+	written := generate(meta)
+	assertEqual(t, true, slices.Contains(written, "client.ts"))
+	assertEqual(t, len(written)+2, formatted)
+
+	// an edited file is generated again
+	requireNoError(t, os.WriteFile(filepath.Join(dir, "types.ts"), []byte("edited"), 0644))
+	assertEqual(t, "[types.ts]", fmt.Sprint(generate(meta)))
+}
+
+type TagOptions struct {
+	Name    string  `json:"name,omitempty"`
+	Hidden  string  `json:"-"`
+	Dash    string  `json:"-,"`
+	Count   int64   `json:"count,string"`
+	Limit   *int    `json:"limit,string"`
+	Nested  []int   `json:"nested,string"`
+	Note    string  `json:",omitzero"`
+	Version float64 `json:"version"`
+}
+
+func TestJSONTagOptions(t *testing.T) {
+	gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client"}, []vel.HandlerMeta{
+		{Input: TagOptions{}, Output: TagOptions{}, OperationID: "tags", Method: "POST"},
+	})
+	requireNoError(t, err)
+
+	buf := &bytes.Buffer{}
+	requireNoError(t, gener.GenerateWith(buf, "ts:default", nil))
+	for _, expected := range []string{
+		"name?: string\n",
+		"'-': string\n",
+		"count: string\n",
+		"limit?: string | undefined\n",
+		"nested: number[]\n",
+		"Note?: string\n",
+		"version: number\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected TS client to contain %q", expected)
+		}
+	}
+	if strings.Contains(buf.String(), "Hidden") {
+		t.Error("expected - field to be excluded")
+	}
+
+	gener.meta.Client.Zod = true
+	buf.Reset()
+	requireNoError(t, gener.GenerateWith(buf, "ts:default", nil))
+	for _, expected := range []string{"name: z.string().optional(),", "count: z.string(),", "limit: z.string().nullish(),"} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected Zod schema to contain %q", expected)
+		}
+	}
+
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+	schema := spec.Components.Schemas["TagOptions"]
+	assertEqual(t, "[- count nested version]", fmt.Sprint(schema.Required))
+	assertEqual(t, "string", schema.Properties["count"].Type)
+	assertEqual(t, "array", schema.Properties["nested"].Type)
+	assertEqual(t, 7, len(schema.Properties))
+}
+
+type UserID string
+
+type Level int
+
+type Status string
+
+func (Status) EnumValues() []string {
+	return []string{"active", "blocked"}
+}
+
+type UserRecord struct {
+	ID       UserID           `json:"id"`
+	Level    Level            `json:"level"`
+	Status   Status           `json:"status"`
+	Friends  map[UserID]Level `json:"friends"`
+	Previous *Status          `json:"previous"`
+}
+
+func TestNamedPrimitiveTypes(t *testing.T) {
+	gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client"}, []vel.HandlerMeta{
+		{Input: UserRecord{}, Output: UserRecord{}, OperationID: "user", Method: "POST"},
+	})
+	requireNoError(t, err)
+
+	t.Run("go", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		requireNoError(t, gener.GenerateWith(buf, "go:default", GoImports))
+		for _, expected := range []string{"type UserID string\n", "type Level int\n", "type Status string\n", "Friends  map[UserID]Level"} {
+			if !strings.Contains(buf.String(), expected) {
+				t.Errorf("expected Go client to contain %q", expected)
+			}
+		}
+	})
+
+	t.Run("ts", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		requireNoError(t, gener.GenerateWith(buf, "ts:default", nil))
+		for _, expected := range []string{
+			"export type UserID = string & { readonly __brand: 'UserID' }\n",
+			"export type Level = number & { readonly __brand: 'Level' }\n",
+			"export type Status = 'active' | 'blocked'\n",
+			"friends: Record<string, Level>\n",
+			"previous?: Status | undefined\n",
+		} {
+			if !strings.Contains(buf.String(), expected) {
+				t.Errorf("expected TS client to contain %q", expected)
+			}
+		}
+	})
+
+	t.Run("zod", func(t *testing.T) {
+		zodGener, err := New(ClientDesc{TypeName: "Client", PackageName: "client", Zod: true}, []vel.HandlerMeta{
+			{Input: UserRecord{}, Output: UserRecord{}, OperationID: "user", Method: "POST"},
+		})
+		requireNoError(t, err)
+		buf := &bytes.Buffer{}
+		requireNoError(t, zodGener.GenerateWith(buf, "ts:default", nil))
+		for _, expected := range []string{
+			"export const UserIDSchema = z.string().brand<'UserID'>()\n",
+			"export const StatusSchema = z.enum(['active', 'blocked'])\n",
+		} {
+			if !strings.Contains(buf.String(), expected) {
+				t.Errorf("expected Zod schemas to contain %q", expected)
+			}
+		}
+	})
+
+	t.Run("openapi", func(t *testing.T) {
+		spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+		requireNoError(t, err)
+		schemas := spec.Components.Schemas
+		assertEqual(t, "string", schemas["UserID"].Type)
+		assertEqual(t, "integer", schemas["Level"].Type)
+		assertEqual(t, "[active blocked]", fmt.Sprint(schemas["Status"].Enum))
+		assertEqual(t, "#/components/schemas/UserID", schemas["UserRecord"].Properties["id"].Ref)
+	})
+}
+
+// largeAPI describes a router with hundreds of operations sharing the test types
+func largeAPI(n int) []vel.HandlerMeta {
+	meta := make([]vel.HandlerMeta, 0, n)
+	for i := range n {
+		meta = append(meta,
+			vel.HandlerMeta{Input: TestTypeNestedTypes{}, Output: TimeTestResponse{}, OperationID: fmt.Sprintf("post%d", i), Method: "POST"},
+			vel.HandlerMeta{Input: GetQuery{}, Output: UserRecord{}, OperationID: fmt.Sprintf("get%d", i), Method: "GET"},
+		)
+	}
+	return meta
+}
+
+func generateAll(t testing.TB, meta []vel.HandlerMeta, parallelism int) string {
+	defer func(prev int) { Parallelism = prev }(Parallelism)
+	Parallelism = parallelism
+
+	gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client", Zod: true}, meta)
+	requireNoError(t, err)
+	buf := &bytes.Buffer{}
+	for _, templateName := range []string{"go:default", "ts:default"} {
+		requireNoError(t, gener.GenerateWith(buf, templateName, nil))
+	}
+	return buf.String()
+}
+
+func TestParallelGeneration(t *testing.T) {
+	meta := largeAPI(100)
+	sequential := generateAll(t, meta, 1)
+	for range 3 {
+		if parallel := generateAll(t, meta, 8); parallel != sequential {
+			t.Fatal("expected the parallel generation to produce the same code as the sequential one")
+		}
+	}
+}
+
+func BenchmarkGenerate(b *testing.B) {
+	meta := largeAPI(250)
+	for _, bc := range []struct {
+		name        string
+		parallelism int
+	}{
+		{"sequential", 1},
+		{"parallel", 0},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for b.Loop() {
+				generateAll(b, meta, bc.parallelism)
+			}
+		})
+	}
+}
+
+type Channel string
+
+const (
+	ChannelEmail Channel = "email"
+	ChannelSMS   Channel = "sms"
+)
+
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityHigh
+)
+
+func (p Priority) String() string {
+	return [...]string{"low", "high"}[p]
+}
+
+type Notification struct {
+	Channel  Channel  `json:"channel"`
+	Priority Priority `json:"priority"`
+}
+
+func TestRegisterEnum(t *testing.T) {
+	RegisterEnum(ChannelEmail, ChannelSMS)
+	RegisterEnum(PriorityLow, PriorityHigh)
+
+	for _, tc := range []struct {
+		name     string
+		template string
+		zod      bool
+		expected []string
+	}{
+		{"go", "go:default", false, []string{
+			"type Channel string\n\nconst (\n\tChannelEmail Channel = \"email\"\n\tChannelSms Channel = \"sms\"\n)\n",
+			"type Priority int\n\nconst (\n\tPriorityLow Priority = 0\n\tPriorityHigh Priority = 1\n)\n",
+		}},
+		{"ts", "ts:default", false, []string{
+			"export type Channel = 'email' | 'sms'\n",
+			"export type Priority = 0 | 1\n",
+		}},
+		{"zod", "ts:default", true, []string{
+			"export const ChannelSchema = z.enum(['email', 'sms'])\n",
+			"export const PrioritySchema = z.union([z.literal(0), z.literal(1)])\n",
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client", Zod: tc.zod}, []vel.HandlerMeta{
+				{Input: Notification{}, Output: Notification{}, OperationID: "notify", Method: "POST"},
+			})
+			requireNoError(t, err)
+			buf := &bytes.Buffer{}
+			requireNoError(t, gener.GenerateWith(buf, tc.template, nil))
+			for _, expected := range tc.expected {
+				if !strings.Contains(buf.String(), expected) {
+					t.Errorf("expected the client to contain %q", expected)
+				}
+			}
+		})
+	}
+
+	t.Run("openapi", func(t *testing.T) {
+		gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client"}, []vel.HandlerMeta{
+			{Input: Notification{}, Output: Notification{}, OperationID: "notify", Method: "POST"},
+		})
+		requireNoError(t, err)
+		buf := &bytes.Buffer{}
+		requireNoError(t, gener.GenerateOpenAPIYAML(buf, "Test API", "1.0.0"))
+		for _, expected := range []string{
+			"    Priority:\n      type: integer\n      enum:\n        - 0\n        - 1\n",
+			"    Channel:\n      type: string\n      enum:\n        - email\n        - sms\n",
+		} {
+			if !strings.Contains(buf.String(), expected) {
+				t.Errorf("expected the spec to contain %q", expected)
+			}
+		}
+	})
+}
+
+type Tier string
+
+type Subscription struct {
+	Tier Tier `json:"tier"`
+}
+
+func TestTypeCache(t *testing.T) {
+	meta := []vel.HandlerMeta{
+		{Input: Subscription{}, Output: Subscription{}, OperationID: "subscribe", Method: "POST"},
+	}
+	tierOf := func(gener *ClientGen) DataType {
+		for _, dataType := range gener.meta.Apis[0].DataTypes {
+			if dataType.Name == "Tier" {
+				return dataType
+			}
+		}
+		t.Fatal("expected Tier to be collected")
+		return DataType{}
+	}
+
+	goGener, err := New(ClientDesc{TypeName: "Client", PackageName: "client"}, meta)
+	requireNoError(t, err)
+	tsGener, err := New(ClientDesc{TypeName: "Client", PackageName: "client", Zod: true}, meta)
+	requireNoError(t, err)
+	// the targets share the description of the type instead of reflecting it again
+	if &goGener.meta.Apis[0].Input.Fields[0] != &tsGener.meta.Apis[0].Input.Fields[0] {
+		t.Error("expected the generators to share the fields of Subscription")
+	}
+	assertEqual(t, 0, len(tierOf(goGener).Enum))
+
+	// registering the values replaces the cached description
+	RegisterEnum[Tier]("free", "pro")
+	t.Cleanup(func() {
+		enumsMu.Lock()
+		defer enumsMu.Unlock()
+		delete(enums, reflect.TypeFor[Tier]())
+		typeCache.forget(reflect.TypeFor[Tier]())
+	})
+	gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client"}, meta)
+	requireNoError(t, err)
+	assertEqual(t, 2, len(tierOf(gener).Enum))
+}
+
+// Decimal and UUID stand for the types of third-party packages
+type Decimal struct {
+	value string
+}
+
+type UUID [16]byte
+
+type Payment struct {
+	ID      UUID             `json:"id"`
+	Amount  Decimal          `json:"amount"`
+	Refunds []Decimal        `json:"refunds"`
+	Parts   map[UUID]Decimal `json:"parts"`
+	Timeout time.Duration    `json:"timeout"`
+}
+
+type UUIDs struct {
+	StandIn []UUID       `json:"standIn"`
+	Google  []*uuid.UUID `json:"google"`
+}
+
+func TestRegisterTypeMapping(t *testing.T) {
+	RegisterTypeMapping(reflect.TypeFor[Decimal](), Mapping{
+		GoType:        "decimal.Decimal",
+		GoImport:      "github.com/shopspring/decimal",
+		TSType:        "string",
+		ZodType:       "z.string()",
+		OpenAPISchema: &OpenAPISchema{Type: "string", Format: "decimal"},
+	})
+	RegisterTypeMapping(reflect.TypeFor[UUID](), Mapping{
+		GoType:        "uuid.UUID",
+		TSType:        "string",
+		OpenAPISchema: &OpenAPISchema{Type: "string", Format: "uuid"},
+	})
+	t.Cleanup(func() {
+		unregisterTypeMapping(reflect.TypeFor[Decimal]())
+		unregisterTypeMapping(reflect.TypeFor[UUID]())
+	})
+
+	gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client"}, []vel.HandlerMeta{
+		{Input: Payment{}, Output: Payment{}, OperationID: "pay", Method: "POST"},
+	})
+	requireNoError(t, err)
+	// the mapped types aren't broken down
+	assertEqual(t, 1, len(gener.meta.Apis[0].DataTypes))
+	assertEqual(t, "Payment", gener.meta.Apis[0].DataTypes[0].Name)
+
+	for _, tc := range []struct {
+		name     string
+		template string
+		zod      bool
+		expected []string
+	}{
+		{"go", "go:default", false, []string{
+			"\t\"github.com/shopspring/decimal\"\n)",
+			"ID uuid.UUID `json:\"id\"`",
+			"Amount decimal.Decimal `json:\"amount\"`",
+			"Parts map[uuid.UUID]decimal.Decimal `json:\"parts\"`",
+		}},
+		{"ts", "ts:default", false, []string{
+			"id: string\n",
+			"refunds: string[]\n",
+			"parts: Record<string, string>\n",
+			"timeout: number\n",
+		}},
+		{"zod", "ts:default", true, []string{
+			"id: z.unknown(),\n",
+			"amount: z.string(),\n",
+			"timeout: z.number(),\n",
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client", Zod: tc.zod}, []vel.HandlerMeta{
+				{Input: Payment{}, Output: Payment{}, OperationID: "pay", Method: "POST"},
+			})
+			requireNoError(t, err)
+			buf := &bytes.Buffer{}
+			requireNoError(t, gener.GenerateWith(buf, tc.template, nil))
+			for _, expected := range tc.expected {
+				if !strings.Contains(buf.String(), expected) {
+					t.Errorf("expected the client to contain %q", expected)
+				}
+			}
+		})
+	}
+
+	t.Run("openapi", func(t *testing.T) {
+		spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+		requireNoError(t, err)
+		properties := spec.Components.Schemas["Payment"].Properties
+		assertEqual(t, "uuid", properties["id"].Format)
+		assertEqual(t, "decimal", properties["amount"].Format)
+		assertEqual(t, "decimal", properties["refunds"].Items.Format)
+		assertEqual(t, "decimal", properties["parts"].AdditionalProperties.Format)
+		assertEqual(t, "int64", properties["timeout"].Format)
+	})
+
+	t.Run("same name", func(t *testing.T) {
+		// the stand-in is named as the uuid.UUID of veluuid, every field gets the mapping of its own type
+		dataType, err := extractDataType(reflect.TypeFor[UUIDs](), "")
+		requireNoError(t, err)
+		assertEqual(t, "z.array(z.unknown()).nullable()", dataType.Fields[0].ZodType)
+		assertEqual(t, "z.array(z.string().uuid().nullish()).nullable()", dataType.Fields[1].ZodType)
+	})
+
+	t.Run("predeclared", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a mapping to a predeclared type to panic")
+			}
+		}()
+		RegisterTypeMapping(reflect.TypeFor[Decimal](), Mapping{GoType: "string"})
+	})
+}
+
+type Invite struct {
+	TeamID    uuid.UUID   `json:"teamId"`
+	MemberIDs []uuid.UUID `json:"memberIds"`
+	InvitedBy *uuid.UUID  `json:"invitedBy"`
+}
+
+func TestUUIDClient(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "invite", func(ctx context.Context, req Invite) (Invite, *vel.Error) {
+		return req, nil
+	})
+	gener, err := newClientGen(router, ClientGeneratorConfig{TypeName: "Client", PackageName: "client"})
+	requireNoError(t, err)
+	gener.meta.Client.Examples = true
+
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+	properties := spec.Components.Schemas["Invite"].Properties
+	assertEqual(t, "string", properties["teamId"].Type)
+	assertEqual(t, "uuid", properties["teamId"].Format)
+	assertEqual(t, "uuid", properties["memberIds"].Items.Format)
+	// a malformed UUID fails the validation
+	if spec.Paths["/invite"].Post.Responses["422"] == nil {
+		t.Error("expected the validation error documented")
+	}
+	example, _ := json.Marshal(spec.Paths["/invite"].Post.RequestBody.Content.ApplicationJSON.Example)
+	if !strings.Contains(string(example), `"teamId":"3f2b8c4e-1a7d-4e9b-9c2a-5d6e7f8a9b0c"`) {
+		t.Errorf("expected a UUID example, got %s", example)
+	}
+
+	for _, tc := range []struct {
+		lang     string
+		zod      bool
+		expected []string
+	}{
+		{"go:default", false, []string{
+			"\t\"github.com/google/uuid\"\n",
+			"TeamID uuid.UUID `json:\"teamId\"`",
+			"MemberIDs []uuid.UUID `json:\"memberIds\"`",
+		}},
+		{"ts:default", false, []string{
+			"teamId: string\n",
+			"memberIds: string[]\n",
+		}},
+		{"ts:default", true, []string{
+			"teamId: z.string().uuid(),\n",
+		}},
+	} {
+		t.Run(tc.lang, func(t *testing.T) {
+			gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client", Zod: tc.zod}, router.Meta())
+			requireNoError(t, err)
+			buf := &bytes.Buffer{}
+			requireNoError(t, gener.GenerateWith(buf, tc.lang, nil))
+			for _, expected := range tc.expected {
+				if !strings.Contains(buf.String(), expected) {
+					t.Errorf("expected the client to contain %q", expected)
+				}
+			}
+		})
+	}
+}
+
+type Invoice struct {
+	Total  *big.Float   `json:"total"`
+	Prices []*big.Float `json:"prices"`
+	Rate   *big.Float   `json:"rate"`
+	Supply *big.Int     `json:"supply"`
+	// a vel.BigInt is a string the TS client doesn't round
+	Balance vel.BigInt `json:"balance"`
+}
+
+func TestDecimalClient(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "bill", func(ctx context.Context, req Invoice) (Invoice, *vel.Error) {
+		return req, nil
+	})
+	gener, err := newClientGen(router, ClientGeneratorConfig{TypeName: "Client", PackageName: "client"})
+	requireNoError(t, err)
+	gener.meta.Client.Examples = true
+	// the numbers aren't broken down
+	assertEqual(t, 1, len(gener.meta.Apis[0].DataTypes))
+
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+	properties := spec.Components.Schemas["Invoice"].Properties
+	assertEqual(t, "string", properties["total"].Type)
+	assertEqual(t, "decimal", properties["total"].Format)
+	assertEqual(t, "decimal", properties["prices"].Items.Format)
+	assertEqual(t, "decimal", properties["rate"].Format)
+	// a big.Int is a JSON number
+	assertEqual(t, "integer", properties["supply"].Type)
+	assertEqual(t, "decimal", properties["balance"].Format)
+	example, _ := json.Marshal(spec.Paths["/bill"].Post.RequestBody.Content.ApplicationJSON.Example)
+	assertEqual(t, `{"balance":"19","prices":["19.99"],"rate":"42","supply":42,"total":"10"}`, string(example))
+
+	for _, tc := range []struct {
+		lang     string
+		zod      bool
+		expected []string
+	}{
+		{"go:default", false, []string{
+			"\t\"math/big\"\n",
+			"Total *big.Float `json:\"total\"`",
+			"Rate *big.Float `json:\"rate\"`",
+			"Supply *big.Int `json:\"supply\"`",
+			"\t\"github.com/dennypenta/vel\"\n",
+			"Balance vel.BigInt `json:\"balance\"`",
+		}},
+		{"ts:default", false, []string{
+			"total?: string | undefined\n",
+			"prices: (string | undefined)[]\n",
+			"supply?: number | undefined\n",
+			"balance: string\n",
+		}},
+		{"ts:default", true, []string{
+			"total: z.string().nullish(),\n",
+			"supply: z.number().int().nullish(),\n",
+		}},
+	} {
+		t.Run(tc.lang, func(t *testing.T) {
+			gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client", Zod: tc.zod}, router.Meta())
+			requireNoError(t, err)
+			buf := &bytes.Buffer{}
+			requireNoError(t, gener.GenerateWith(buf, tc.lang, nil))
+			for _, expected := range tc.expected {
+				if !strings.Contains(buf.String(), expected) {
+					t.Errorf("expected the client to contain %q", expected)
+				}
+			}
+		})
+	}
+}
+
+type TreeNode struct {
+	Name     string     `json:"name"`
+	Children []TreeNode `json:"children"`
+	Parent   *TreeNode  `json:"parent"`
+}
+
+type Author struct {
+	Books []Book `json:"books"`
+}
+
+type Book struct {
+	Author *Author `json:"author,omitempty"`
+}
+
+type Library struct {
+	Root    TreeNode `json:"root"`
+	Authors []Author `json:"authors"`
+}
+
+func TestRecursiveTypes(t *testing.T) {
+	meta := []vel.HandlerMeta{
+		{Input: Library{}, Output: Library{}, OperationID: "library", Method: "POST"},
+	}
+	gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client"}, meta)
+	requireNoError(t, err)
+
+	recursive := make(map[string]bool)
+	for _, dataType := range gener.meta.Apis[0].DataTypes {
+		if _, ok := recursive[dataType.Name]; ok {
+			t.Errorf("expected %s to be collected once", dataType.Name)
+		}
+		recursive[dataType.Name] = dataType.Recursive
+	}
+	assertEqual(t, 4, len(recursive))
+	assertEqual(t, false, recursive["Library"])
+	assertEqual(t, true, recursive["TreeNode"])
+	assertEqual(t, true, recursive["Author"])
+	assertEqual(t, true, recursive["Book"])
+
+	t.Run("go", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		requireNoError(t, gener.GenerateWith(buf, "go:default", nil))
+		for _, expected := range []string{
+			"Children []TreeNode `json:\"children\"`",
+			"Author *Author `json:\"author,omitempty\"`",
+		} {
+			if !strings.Contains(buf.String(), expected) {
+				t.Errorf("expected the client to contain %q", expected)
+			}
+		}
+	})
+
+	t.Run("zod", func(t *testing.T) {
+		zodGener, err := New(ClientDesc{TypeName: "Client", PackageName: "client", Zod: true}, meta)
+		requireNoError(t, err)
+		buf := &bytes.Buffer{}
+		requireNoError(t, zodGener.GenerateWith(buf, "ts:default", nil))
+		for _, expected := range []string{
+			"export type TreeNode = {\n  name: string\n  children: TreeNode[] | null\n  parent?: TreeNode | null | undefined\n}\n",
+			"export const TreeNodeSchema: z.ZodType<TreeNode> = z.object({\n",
+			"  children: z.array(z.lazy(() => TreeNodeSchema)).nullable(),\n",
+			"export type Book = {\n  author?: Author | null | undefined\n}\n",
+			"export const BookSchema: z.ZodType<Book> = z.object({\n",
+			"export type Library = z.infer<typeof LibrarySchema>\n",
+		} {
+			if !strings.Contains(buf.String(), expected) {
+				t.Errorf("expected Zod schemas to contain %q", expected)
+			}
+		}
+	})
+
+	t.Run("openapi", func(t *testing.T) {
+		spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+		requireNoError(t, err)
+		node := spec.Components.Schemas["TreeNode"]
+		assertEqual(t, "#/components/schemas/TreeNode", node.Properties["children"].Items.Ref)
+		assertEqual(t, "#/components/schemas/TreeNode", node.Properties["parent"].Ref)
+		assertEqual(t, "#/components/schemas/Author", spec.Components.Schemas["Book"].Properties["author"].Ref)
+	})
+}
+
+func TestScaffold(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "billing")
+	written, err := Scaffold(ScaffoldConfig{Name: "billing", Module: "example.com/billing", Dir: dir})
+	requireNoError(t, err)
+	assertEqual(t, 7, len(written))
+
+	goMod, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	requireNoError(t, err)
+	if !strings.HasPrefix(string(goMod), "module example.com/billing\n") {
+		t.Errorf("unexpected go.mod:\n%s", goMod)
+	}
+	config, err := LoadConfig(filepath.Join(dir, "vel.yaml"))
+	requireNoError(t, err)
+	assertEqual(t, "./api.NewRouter", config.Router)
+	assertEqual(t, "billing", config.OpenAPI.Title)
+
+	for _, path := range written {
+		if !strings.HasSuffix(path, ".go") {
+			continue
+		}
+		_, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+		requireNoError(t, err)
+	}
+	main, err := os.ReadFile(filepath.Join(dir, "main.go"))
+	requireNoError(t, err)
+	if !strings.Contains(string(main), `"example.com/billing/api"`) {
+		t.Errorf("main.go doesn't import the api package:\n%s", main)
+	}
+
+	// the project is never overwritten
+	_, err = Scaffold(ScaffoldConfig{Name: "billing", Dir: dir})
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("expected existing files to be kept, got %v", err)
+	}
+	_, err = Scaffold(ScaffoldConfig{Name: "billing/api"})
+	if err == nil {
+		t.Error("expected a name with a slash to be rejected")
+	}
+}
+
+type Page[T any] struct {
+	Items []T    `json:"items"`
+	Next  string `json:"next"`
+}
+
+type Result[T any] struct {
+	Value T      `json:"value"`
+	Error string `json:"error"`
+}
+
+type Pair[K comparable, V any] struct {
+	Key   K `json:"key"`
+	Value V `json:"value"`
+}
+
+type Order struct {
+	ID string `json:"id"`
+}
+
+type GenericRequest struct {
+	Filter Pair[string, []*Order] `json:"filter"`
+}
+
+func TestGenericTypes(t *testing.T) {
+	for name, expected := range map[reflect.Type]string{
+		reflect.TypeFor[Page[Order]]():                    "PageOrder",
+		reflect.TypeFor[Result[[]Order]]():                "ResultOrderList",
+		reflect.TypeFor[Result[Page[Order]]]():            "ResultPageOrder",
+		reflect.TypeFor[Page[*Order]]():                   "PageOrderPtr",
+		reflect.TypeFor[Pair[string, []*Order]]():         "PairStringOrderPtrList",
+		reflect.TypeFor[Page[map[string]Result[Order]]](): "PageStringResultOrderMap",
+	} {
+		assertEqual(t, expected, typeName(name))
+	}
+
+	meta := []vel.HandlerMeta{
+		{Input: GenericRequest{}, Output: Page[Order]{}, OperationID: "listOrders", Method: "POST"},
+		{Input: struct{}{}, Output: Result[Page[Order]]{}, OperationID: "firstPage", Method: "POST"},
+	}
+	gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client"}, meta)
+	requireNoError(t, err)
+
+	for _, tc := range []struct {
+		name     string
+		template string
+		expected []string
+	}{
+		{"go", "go:default", []string{
+			"type PageOrder struct {",
+			"Items []Order `json:\"items\"`",
+			"type ResultPageOrder struct {",
+			"Value PageOrder `json:\"value\"`",
+			"Filter PairStringOrderPtrList `json:\"filter\"`",
+			"Value []*Order `json:\"value\"`",
+		}},
+		{"ts", "ts:default", []string{
+			"export type PageOrder = {",
+			"value: PageOrder\n",
+			"filter: PairStringOrderPtrList\n",
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			requireNoError(t, gener.GenerateWith(buf, tc.template, nil))
+			if strings.Contains(buf.String(), "[github.com") {
+				t.Error("expected no qualified type arguments in the client")
+			}
+			for _, expected := range tc.expected {
+				if !strings.Contains(buf.String(), expected) {
+					t.Errorf("expected the client to contain %q", expected)
+				}
+			}
+		})
+	}
+
+	t.Run("openapi", func(t *testing.T) {
+		spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+		requireNoError(t, err)
+		for _, name := range []string{"PageOrder", "ResultPageOrder", "PairStringOrderPtrList", "Order"} {
+			if _, ok := spec.Components.Schemas[name]; !ok {
+				t.Errorf("expected the %s schema", name)
+			}
+		}
+		assertEqual(t, "#/components/schemas/PageOrder", spec.Components.Schemas["ResultPageOrder"].Properties["value"].Ref)
+	})
+}
+
+type InlineRequest struct {
+	Name    string `json:"name"`
+	Address struct {
+		City string `json:"city"`
+		Geo  *struct {
+			Lat float64 `json:"lat"`
+		} `json:"geo"`
+	} `json:"address"`
+	Tags []struct {
+		Label string `json:"label"`
+	} `json:"tags"`
+}
+
+func TestNameInlineStructs(t *testing.T) {
+	meta := []vel.HandlerMeta{
+		{Input: InlineRequest{}, Output: struct {
+			Greeting string `json:"greeting"`
+		}{}, OperationID: "hello", Method: "POST"},
+	}
+	_, err := New(ClientDesc{TypeName: "Client", PackageName: "client"}, meta)
+	if !errors.Is(err, ErrorInlineStructForbidden) {
+		t.Fatalf("expected ErrorInlineStructForbidden, got %v", err)
+	}
+
+	// the described types depend on the option
+	NameInlineStructs = true
+	typeCache.reset()
+	t.Cleanup(func() {
+		NameInlineStructs = false
+		typeCache.reset()
+	})
+	gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client"}, meta)
+	requireNoError(t, err)
+	api := gener.meta.Apis[0]
+	assertEqual(t, "InlineRequest", api.Input.Name)
+	assertEqual(t, "HelloResponse", api.Output.Name)
+	names := make([]string, len(api.DataTypes))
+	for i := range api.DataTypes {
+		names[i] = api.DataTypes[i].Name
+	}
+	assertEqual(t, "HelloResponse InlineRequest InlineRequestAddress InlineRequestAddressGeo InlineRequestTags", strings.Join(names, " "))
+
+	for _, tc := range []struct {
+		name           string
+		template       string
+		postProcessing string
+		expected       []string
+	}{
+		{"go", "go:default", "goimports", []string{
+			"Address InlineRequestAddress `json:\"address\"`",
+			"Geo  *InlineRequestAddressGeo `json:\"geo\"`",
+			"Tags    []InlineRequestTags  `json:\"tags\"`",
+			"type HelloResponse struct {",
+		}},
+		{"ts", "ts:default", "", []string{
+			"address: InlineRequestAddress\n",
+			"tags: InlineRequestTags[]\n",
+			"export type InlineRequestAddressGeo = {",
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			requireNoError(t, gener.Generate(buf, tc.template, tc.postProcessing))
+			for _, expected := range tc.expected {
+				if !strings.Contains(buf.String(), expected) {
+					t.Errorf("expected the client to contain %q", expected)
+				}
+			}
+		})
+	}
+
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+	assertEqual(t, "#/components/schemas/InlineRequestAddress", spec.Components.Schemas["InlineRequest"].Properties["address"].Ref)
+	if _, ok := spec.Components.Schemas["HelloResponse"]; !ok {
+		t.Error("expected the HelloResponse schema")
+	}
+}
+
+func TestNoBody(t *testing.T) {
+	gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client"}, []vel.HandlerMeta{
+		{Input: HeaderRequest{}, Output: GetResp{}, OperationID: "tenant", Method: "POST"},
+	})
+	requireNoError(t, err)
+	api := gener.meta.Apis[0]
+	assertEqual(t, "", api.Input.Name)
+	for _, dataType := range api.DataTypes {
+		if dataType.Name == "HeaderRequest" {
+			t.Error("expected the input without a body not to be declared")
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	requireNoError(t, gener.Generate(buf, "go:default", "goimports"))
+	if !strings.Contains(buf.String(), "Tenant(ctx context.Context, opts ...CallOption) (GetResp, error)") {
+		t.Errorf("expected the method without a request, got\n%s", buf)
+	}
+}
+
+func TestDeterministicOutput(t *testing.T) {
+	meta := []vel.HandlerMeta{
+		{Input: TestTypeNestedTypes{}, Output: TestTypeNestedTypes{}, OperationID: "nested", Method: "POST"},
+		{Input: GetQuery{}, Output: GetResp{}, OperationID: "get", Method: "GET"},
+		{Input: TimeTestRequest{}, Output: UserRecord{}, OperationID: "audit", Method: "POST"},
+		{Input: struct{}{}, Output: TestTypeNestedTypes{}, OperationID: "current", Method: "POST"},
+	}
+	generate := func(meta []vel.HandlerMeta) map[string]string {
+		gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client", Zod: true}, meta)
+		requireNoError(t, err)
+		outputs := make(map[string]string)
+		for _, template := range []string{"go:default", "ts:default"} {
+			buf := &bytes.Buffer{}
+			requireNoError(t, gener.GenerateWith(buf, template, nil))
+			outputs[template] = buf.String()
+		}
+		buf := &bytes.Buffer{}
+		requireNoError(t, gener.GenerateOpenAPIYAML(buf, "Test API", "1.0.0"))
+		outputs["openapi"] = buf.String()
+		return outputs
+	}
+
+	expected := generate(meta)
+	api := expected["go:default"]
+	if strings.Index(api, "func (c *Client) Audit(") > strings.Index(api, "func (c *Client) Nested(") {
+		t.Error("expected the operations sorted by their ids")
+	}
+	if strings.Index(api, "type HighElem struct") > strings.Index(api, "type TestStruct struct") {
+		t.Error("expected the data types sorted by their names")
+	}
+	for range 5 {
+		shuffled := slices.Clone(meta)
+		rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		for name, output := range generate(shuffled) {
+			if output != expected[name] {
+				t.Fatalf("expected %s not to depend on the registration order", name)
+			}
+		}
+	}
+}
+
+func TestStreamOperations(t *testing.T) {
+	meta := []vel.HandlerMeta{
+		{Input: GetQuery{}, Output: GetResp{}, OperationID: "watch", Method: "GET", Spec: vel.Spec{Stream: vel.StreamSSE}},
+		{Input: TestTypeNestedTypes{}, Output: UserRecord{}, OperationID: "export", Method: "POST", Spec: vel.Spec{Stream: vel.StreamNDJSON}},
+	}
+	gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client"}, meta)
+	requireNoError(t, err)
+
+	buf := &bytes.Buffer{}
+	requireNoError(t, gener.GenerateWith(buf, "go:default", nil))
+	for _, expected := range []string{
+		"func (c *Client) Watch(ctx context.Context, req GetQuery, opts ...CallOption) iter.Seq2[GetResp, error] {",
+		"func (c *Client) Export(ctx context.Context, req TestTypeNestedTypes, opts ...CallOption) iter.Seq2[UserRecord, error] {",
+		"func (c *Client) WatchStream(ctx context.Context, req GetQuery, opts ...CallOption) (*Stream[GetResp], error) {",
+		"iter.Pull2(streamItems(resp.Body, true))",
+		"iter.Pull2(streamItems(resp.Body, false))",
+		"item, err := stream.Recv()",
+		"Watch(ctx context.Context, req GetQuery, opts ...CallOption) iter.Seq2[GetResp, error]\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected the client to contain %q", expected)
+		}
+	}
+
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+	if content := spec.Paths["/watch"].Get.Responses["200"].Content; content.TextEventStream == nil || content.ApplicationJSON != nil {
+		t.Error("expected the watch response to be an event stream")
+	}
+	if content := spec.Paths["/export"].Post.Responses["200"].Content; content.ApplicationNDJSON == nil {
+		t.Error("expected the export response to be NDJSON")
+	}
+
+	_, err = New(ClientDesc{TypeName: "Client", PackageName: "client"}, []vel.HandlerMeta{
+		{Input: struct{}{}, Output: struct{}{}, OperationID: "empty", Method: "POST", Spec: vel.Spec{Stream: vel.StreamSSE}},
+	})
+	if err == nil {
+		t.Error("expected an error for a stream without an item type")
+	}
+}
+
+func TestTSStreamOperations(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterGet(router, "watch", func(ctx context.Context, req GetQuery) (GetResp, *vel.Error) {
+		return GetResp{}, nil
+	}).SetSpec(vel.Spec{Stream: vel.StreamSSE})
+	vel.RegisterPost(router.Subrouter("users"), "export", func(ctx context.Context, req TestTypeNestedTypes) (UserRecord, *vel.Error) {
+		return UserRecord{}, nil
+	}).SetSpec(vel.Spec{Stream: vel.StreamNDJSON})
+	gener, err := newClientGen(router, ClientGeneratorConfig{TypeName: "Client", PackageName: "client"})
+	requireNoError(t, err)
+
+	buf := &bytes.Buffer{}
+	requireNoError(t, gener.GenerateWith(buf, "ts:default", nil))
+	for _, expected := range []string{
+		"Watch(req: GetQuery, opts?: CallOptions): AsyncIterable<GetResp> {",
+		"return this.stream('GET', 'watch', true, { ...opts, operationId: 'watch', query })",
+		"Export(req: TestTypeNestedTypes, opts?: CallOptions): AsyncIterable<UserRecord> {",
+		"return this.stream('POST', 'users/export', false, { ...opts, operationId: 'export', body: JSON.stringify(req) })",
+		"const stream: StreamFn = this.stream.bind(this)",
+		"private stream: StreamFn,",
+		"headers['Last-Event-ID'] = lastEventId",
+		"export class StreamError<E = ApiErrorPayload> extends Error {",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected the client to contain %q", expected)
+		}
+	}
+}
+
+type ListUsersRequest struct {
+	vel.Cursor
+	Team string `json:"team" schema:"team"`
+}
+
+func TestPagination(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterGet(router, "listUsers", func(ctx context.Context, req ListUsersRequest) (vel.Page[UserRecord], *vel.Error) {
+		return vel.Page[UserRecord]{}, nil
+	})
+	vel.RegisterGet(router.Subrouter("admin"), "listTeams", func(ctx context.Context, req ListUsersRequest) (vel.Page[string], *vel.Error) {
+		return vel.Page[string]{}, nil
+	})
+	vel.RegisterGet(router, "find", func(ctx context.Context, req GetQuery) (GetResp, *vel.Error) {
+		return GetResp{}, nil
+	})
+	gener, err := newClientGen(router, ClientGeneratorConfig{TypeName: "Client", PackageName: "client"})
+	requireNoError(t, err)
+
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+	pagination := spec.Paths["/listUsers"].Get.Pagination
+	if pagination == nil || pagination.CursorParam != "cursor" || pagination.NextCursorField != "nextCursor" {
+		t.Errorf("expected the pagination of listUsers, got %+v", pagination)
+	}
+	if spec.Paths["/find"].Get.Pagination != nil {
+		t.Error("expected find not to be paginated")
+	}
+	var params []string
+	for _, param := range spec.Paths["/listUsers"].Get.Parameters {
+		params = append(params, param.Name)
+	}
+	assertEqual(t, "cursor limit team", strings.Join(slices.Sorted(slices.Values(params)), " "))
+
+	buf := &bytes.Buffer{}
+	requireNoError(t, gener.GenerateWith(buf, "go:default", nil))
+	for _, expected := range []string{
+		"func (c *Client) ListUsersPages(ctx context.Context, req ListUsersRequest, opts ...CallOption) iter.Seq2[PageUserRecord, error] {",
+		"func (g *ClientAdmin) ListTeamsPages(ctx context.Context, req ListUsersRequest, opts ...CallOption) iter.Seq2[PageString, error] {",
+		"req.After = page.NextCursor",
+		"NextCursor string `json:\"nextCursor,omitempty\"`",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected the client to contain %q", expected)
+		}
+	}
+	if strings.Contains(buf.String(), "FindPages") {
+		t.Error("expected no pages of find")
+	}
+}
+
+func TestAsyncClient(t *testing.T) {
+	router := vel.NewRouter()
+	api := router.Subrouter("api")
+	api.RegisterOperationsEndpoint(vel.NewMemoryJobStore(0))
+	vel.RegisterAsync(api, "export", func(ctx context.Context, req GetQuery) (UserRecord, *vel.Error) {
+		return UserRecord{}, nil
+	}).SetSpec(vel.Spec{Description: "exports the users"})
+	vel.RegisterPost(api, "create", func(ctx context.Context, req TestTypeNestedTypes) (UserRecord, *vel.Error) {
+		return UserRecord{}, nil
+	})
+	gener, err := newClientGen(router, ClientGeneratorConfig{TypeName: "Client", PackageName: "client", Batch: true})
+	requireNoError(t, err)
+
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+	export := spec.Paths["/api/export"].Post
+	if export.Async == nil || export.Async.StatusPath != "/api/operations/{id}" || export.Async.Result.Ref != "#/components/schemas/UserRecord" {
+		t.Errorf("expected the async extension of export, got %+v", export.Async)
+	}
+	if _, ok := export.Responses["202"]; !ok || export.Responses["200"] != nil {
+		t.Errorf("expected 202 instead of 200, got %v", slices.Sorted(maps.Keys(export.Responses)))
+	}
+	if spec.Paths["/api/create"].Post.Async != nil {
+		t.Error("expected create not to be async")
+	}
+
+	for _, tc := range []struct {
+		lang     string
+		expected []string
+	}{
+		{"go:default", []string{
+			"func (g *ClientApi) Export(ctx context.Context, req GetQuery, opts ...CallOption) (Operation, error) {",
+			"func (g *ClientApi) ExportWait(ctx context.Context, op Operation, opts ...CallOption) (UserRecord, error) {",
+			`op, err := c.waitOperation(ctx, "api/operations/", op, opts)`,
+			"Export(ctx context.Context, req GetQuery, opts ...CallOption) (Operation, error)\n",
+			"return Operation{}, nil",
+		}},
+		{"ts:default", []string{
+			"async Export(req: GetQuery, opts?: CallOptions): Promise<Result<Operation>> {",
+			"async ExportWait(op: Operation, opts?: CallOptions): Promise<Result<UserRecord>> {",
+			"this.get<Operation>('api/operations/' + encodeURIComponent(op.id), { ...opts, operationId: 'getOperation' })",
+			"export type Operation = {",
+		}},
+	} {
+		t.Run(tc.lang, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			requireNoError(t, gener.GenerateWith(buf, tc.lang, nil))
+			for _, expected := range tc.expected {
+				if !strings.Contains(buf.String(), expected) {
+					t.Errorf("expected the client to contain %q", expected)
+				}
+			}
+			// the batch can't wait for the operation
+			if strings.Contains(buf.String(), "Batch) Export(") || strings.Contains(buf.String(), "  Export(req: GetQuery): Promise") {
+				t.Error("expected no batch call of the async operation")
+			}
+		})
+	}
+}
+
+func TestVersionedClient(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "create", func(ctx context.Context, req TestTypeNoJsonTags) (UserRecord, *vel.Error) {
+		return UserRecord{}, nil
+	}, vel.WithVersion("2024-01-01"))
+	vel.RegisterPost(router, "create", func(ctx context.Context, req TestTypeNestedTypes) (UserRecord, *vel.Error) {
+		return UserRecord{}, nil
+	}, vel.WithVersion("2024-06-01"))
+	gener, err := newClientGen(router, ClientGeneratorConfig{TypeName: "Client", PackageName: "client", Batch: true})
+	requireNoError(t, err)
+
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+	create := spec.Paths["/create"].Post
+	assertEqual(t, "#/components/schemas/TestTypeNestedTypes", create.RequestBody.Content.ApplicationJSON.Schema.Ref)
+	if old := create.Versions["2024-01-01"]; old == nil || old.RequestBody.Content.ApplicationJSON.Schema.Ref != "#/components/schemas/TestTypeNoJsonTags" {
+		t.Errorf("expected the superseded version in x-versions, got %+v", create.Versions)
+	}
+	param := create.Parameters[len(create.Parameters)-1]
+	if param.Name != vel.AcceptVersionHeader || fmt.Sprint(param.Schema.Enum) != "[2024-01-01 2024-06-01]" {
+		t.Errorf("expected the version header, got %+v", param)
+	}
+	if create.Responses["200"].Headers[vel.APIVersionHeader] == nil {
+		t.Error("expected the served version header")
+	}
+
+	for _, tc := range []struct {
+		lang     string
+		expected []string
+	}{
+		{"go:default", []string{
+			"func (c *Client) Create(ctx context.Context, req TestTypeNestedTypes, opts ...CallOption) (UserRecord, error) {",
+			"func (c *Client) CreateV20240101(ctx context.Context, req TestTypeNoJsonTags, opts ...CallOption) (UserRecord, error) {",
+			`r.Header.Set("Accept-Version", "2024-06-01")`,
+			`r.Header.Set("Accept-Version", "2024-01-01")`,
+		}},
+		{"ts:default", []string{
+			"async Create(req: TestTypeNestedTypes, opts?: CallOptions): Promise<Result<UserRecord>> {",
+			"async CreateV20240101(req: TestTypeNoJsonTags, opts?: CallOptions): Promise<Result<UserRecord>> {",
+			"opts = { ...opts, headers: { 'Accept-Version': '2024-01-01', ...opts?.headers } }",
+		}},
+	} {
+		t.Run(tc.lang, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			requireNoError(t, gener.GenerateWith(buf, tc.lang, nil))
+			for _, expected := range tc.expected {
+				if !strings.Contains(buf.String(), expected) {
+					t.Errorf("expected the client to contain %q", expected)
+				}
+			}
+			// the batch can't pick the version
+			if strings.Contains(buf.String(), "Batch) Create(") || strings.Contains(buf.String(), "  Create(req: TestTypeNestedTypes): Promise") {
+				t.Error("expected no batch call of the versioned operation")
+			}
+		})
+	}
+}
+
+func TestRawClient(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "importCsv", func(ctx context.Context, body io.Reader) (UserRecord, *vel.Error) {
+		return UserRecord{}, nil
+	}).SetSpec(vel.Spec{RequestContentType: "text/csv"})
+	vel.RegisterGet(router, "export", func(ctx context.Context, req GetQuery) ([]byte, *vel.Error) {
+		return nil, nil
+	}).SetSpec(vel.Spec{ContentType: "text/csv"})
+	vel.RegisterPost(router, "echo", func(ctx context.Context, body []byte) ([]byte, *vel.Error) {
+		return body, nil
+	})
+	gener, err := newClientGen(router, ClientGeneratorConfig{TypeName: "Client", PackageName: "client", Batch: true})
+	requireNoError(t, err)
+
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+	if media := spec.Paths["/importCsv"].Post.RequestBody.Content.Raw["text/csv"]; media == nil || media.Schema.Format != "binary" {
+		t.Errorf("expected the binary request body, got %+v", spec.Paths["/importCsv"].Post.RequestBody.Content)
+	}
+	if media := spec.Paths["/export"].Get.Responses["200"].Content.Raw["text/csv"]; media == nil || media.Schema.Format != "binary" {
+		t.Errorf("expected the binary response, got %+v", spec.Paths["/export"].Get.Responses["200"].Content)
+	}
+	echo := spec.Paths["/echo"].Post
+	if echo.RequestBody.Content.Raw[vel.OctetStream] == nil || echo.Responses["200"].Content.Raw[vel.OctetStream] == nil {
+		t.Errorf("expected the octet streams by default, got %+v", echo)
+	}
+
+	for _, tc := range []struct {
+		lang     string
+		expected []string
+	}{
+		{"go:default", []string{
+			"func (c *Client) ImportCsv(ctx context.Context, req io.Reader, opts ...CallOption) (UserRecord, error) {",
+			`r.Header.Set("Content-Type", "text/csv")`,
+			"func (c *Client) Export(ctx context.Context, req GetQuery, opts ...CallOption) ([]byte, error) {",
+			"func (c *Client) ExportReader(ctx context.Context, req GetQuery, opts ...CallOption) (io.ReadCloser, error) {",
+			"res, err := io.ReadAll(body)",
+			"return cancelBody{ReadCloser: resp.Body, cancel: cancel}, nil",
+			`r.Header.Set("Content-Type", "application/octet-stream")`,
+		}},
+		{"ts:default", []string{
+			"async ImportCsv(body: BodyInit, opts?: CallOptions): Promise<Result<UserRecord>> {",
+			"opts = { ...opts, headers: { 'Content-Type': 'text/csv', ...opts?.headers } }",
+			"return await this.request('POST', 'importCsv', { ...opts, operationId: 'importCsv', body })",
+			"async Export(req: GetQuery, opts?: CallOptions): Promise<Result<Blob>> {",
+			"return await this.get('export', { ...opts, operationId: 'export', query, raw: true })",
+			"return await this.request('POST', 'echo', { ...opts, operationId: 'echo', body, raw: true })",
+		}},
+	} {
+		t.Run(tc.lang, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			requireNoError(t, gener.GenerateWith(buf, tc.lang, nil))
+			for _, expected := range tc.expected {
+				if !strings.Contains(buf.String(), expected) {
+					t.Errorf("expected the client to contain %q", expected)
+				}
+			}
+			// the batch encodes the bodies as JSON
+			if strings.Contains(buf.String(), "Batch) Echo(") || strings.Contains(buf.String(), "  Echo(body: BodyInit): Promise") {
+				t.Error("expected no batch call of the raw operation")
+			}
+		})
+	}
+}
+
+func TestItemsClient(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "importUsers", func(ctx context.Context, items vel.Items[UserRecord]) (GetResp, *vel.Error) {
+		return GetResp{}, nil
+	})
+	vel.RegisterPost(router, "syncUsers", func(ctx context.Context, items vel.Items[UserRecord]) (UserRecord, *vel.Error) {
+		return UserRecord{}, nil
+	}).SetSpec(vel.Spec{Stream: vel.StreamNDJSON})
+	gener, err := newClientGen(router, ClientGeneratorConfig{TypeName: "Client", PackageName: "client", Batch: true})
+	requireNoError(t, err)
+	gener.meta.Client.Examples = true
+	gener.meta.Client.CodeSamplesURL = "http://localhost:8080"
+
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+	content := spec.Paths["/importUsers"].Post.RequestBody.Content
+	if content.ApplicationJSON != nil || content.ApplicationNDJSON == nil || content.ApplicationNDJSON.Schema.Ref != "#/components/schemas/UserRecord" {
+		t.Fatalf("expected the NDJSON request body of the items, got %+v", content)
+	}
+	if content.ApplicationNDJSON.Example == nil {
+		t.Error("expected the example item")
+	}
+	if source := spec.Paths["/importUsers"].Post.CodeSamples[0].Source; !strings.Contains(source, "Content-Type: application/x-ndjson") {
+		t.Errorf("expected the NDJSON in the curl sample, got %s", source)
+	}
+	if spec.Paths["/syncUsers"].Post.Responses["200"].Content.ApplicationNDJSON == nil {
+		t.Error("expected the NDJSON response of the stream")
+	}
+
+	for _, tc := range []struct {
+		lang     string
+		expected []string
+	}{
+		{"go:default", []string{
+			"func (c *Client) ImportUsers(ctx context.Context, req iter.Seq[UserRecord], opts ...CallOption) (GetResp, error) {",
+			"body := ndjsonBody(req)",
+			`r.Header.Set("Content-Type", "application/x-ndjson")`,
+			"func ndjsonBody[T any](items iter.Seq[T]) io.Reader {",
+			"func (c *Client) SyncUsersStream(ctx context.Context, req iter.Seq[UserRecord], opts ...CallOption) (*Stream[UserRecord], error) {",
+		}},
+		{"ts:default", []string{
+			"async ImportUsers(items: AsyncIterable<UserRecord> | Iterable<UserRecord>, opts?: CallOptions): Promise<Result<GetResp>> {",
+			"opts = { ...opts, headers: { 'Content-Type': 'application/x-ndjson', ...opts?.headers } }",
+			"return await this.request('POST', 'importUsers', { ...opts, operationId: 'importUsers', body: ndjsonBody(items) })",
+			"SyncUsers(items: AsyncIterable<UserRecord> | Iterable<UserRecord>, opts?: CallOptions): AsyncIterable<UserRecord> {",
+			"return this.stream('POST', 'syncUsers', false, { ...opts, operationId: 'syncUsers', body: ndjsonBody(items) })",
+			"function ndjsonBody<T>(items: AsyncIterable<T> | Iterable<T>): ReadableStream<Uint8Array> {",
+		}},
+	} {
+		t.Run(tc.lang, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			requireNoError(t, gener.GenerateWith(buf, tc.lang, nil))
+			for _, expected := range tc.expected {
+				if !strings.Contains(buf.String(), expected) {
+					t.Errorf("expected the client to contain %q", expected)
+				}
+			}
+			// the batch encodes a single body
+			if strings.Contains(buf.String(), "Batch) ImportUsers(") {
+				t.Error("expected no batch call of the items operation")
+			}
+		})
+	}
+}
+
+func TestMsgPackClient(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "createUser", func(ctx context.Context, req UserRecord) (GetResp, *vel.Error) {
+		return GetResp{}, nil
+	})
+	vel.RegisterGet(router, "getUser", func(ctx context.Context, req GetQuery) (UserRecord, *vel.Error) {
+		return UserRecord{}, nil
+	})
+
+	for _, msgpack := range []bool{true, false} {
+		gener, err := newClientGen(router, ClientGeneratorConfig{TypeName: "Client", PackageName: "client", MsgPack: msgpack})
+		requireNoError(t, err)
+		buf := &bytes.Buffer{}
+		requireNoError(t, gener.GenerateWith(buf, "go:default", nil))
+		for _, expected := range []string{
+			`"github.com/dennypenta/vel/msgpack"`,
+			"bodyBytes, err := msgpack.Marshal(req)",
+			`r.Header.Set("Content-Type", "application/msgpack")`,
+			`r.Header.Set("Accept", "application/msgpack, application/json;q=0.9")`,
+			"err = decodeBody(resp, &res)",
+			"func decodeBody(resp *http.Response, v any) error {",
+		} {
+			if strings.Contains(buf.String(), expected) != msgpack {
+				t.Errorf("msgpack %v: expected the client to contain %q only with msgpack", msgpack, expected)
+			}
+		}
+	}
+}
+
+func TestFormClient(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "subscribe", func(ctx context.Context, req GetQuery) (GetResp, *vel.Error) {
+		return GetResp{}, nil
+	}).SetSpec(vel.Spec{RequestContentType: vel.FormURLEncoded})
+	gener, err := newClientGen(router, ClientGeneratorConfig{TypeName: "Client", PackageName: "client"})
+	requireNoError(t, err)
+	gener.meta.Client.Examples = true
+	gener.meta.Client.CodeSamplesURL = "http://localhost:8080"
+
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+	content := spec.Paths["/subscribe"].Post.RequestBody.Content
+	if content.ApplicationJSON != nil || content.ApplicationForm == nil {
+		t.Fatalf("expected the form request body, got %+v", content)
+	}
+	schema := content.ApplicationForm.Schema
+	assertEqual(t, "[field since value]", fmt.Sprint(slices.Sorted(maps.Keys(schema.Properties))))
+	assertEqual(t, "date-time", schema.Properties["since"].Format)
+	if example, ok := content.ApplicationForm.Example.(map[string]string); !ok || len(example) != 3 {
+		t.Errorf("expected the example fields, got %+v", content.ApplicationForm.Example)
+	}
+	if source := spec.Paths["/subscribe"].Post.CodeSamples[0].Source; !strings.Contains(source, "Content-Type: application/x-www-form-urlencoded") {
+		t.Errorf("expected the form in the curl sample, got %s", source)
+	}
+
+	for _, tc := range []struct {
+		lang     string
+		expected []string
+	}{
+		{"go:default", []string{
+			`form.Set("value", queryValue(req.Value))`,
+			"body := strings.NewReader(form.Encode())",
+			`r.Header.Set("Content-Type", "application/x-www-form-urlencoded")`,
+		}},
+		{"ts:default", []string{
+			"form.set('since', String(req.Since))",
+			"opts = { ...opts, headers: { 'Content-Type': 'application/x-www-form-urlencoded', ...opts?.headers } }",
+			"return await this.request('POST', 'subscribe', { ...opts, operationId: 'subscribe', body: form })",
+		}},
+	} {
+		t.Run(tc.lang, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			requireNoError(t, gener.GenerateWith(buf, tc.lang, nil))
+			for _, expected := range tc.expected {
+				if !strings.Contains(buf.String(), expected) {
+					t.Errorf("expected the client to contain %q", expected)
+				}
+			}
+		})
+	}
+}
+
+type SearchFilter struct {
+	Status []string   `json:"status" schema:"status"`
+	Before *time.Time `json:"before" schema:"before"`
+}
+
+type SearchQuery struct {
+	Filter *SearchFilter `json:"filter" schema:"filter"`
+	Days   []time.Time   `json:"days" schema:"day"`
+	Limit  *int          `json:"limit" schema:"limit"`
+	Term   string        `json:"term" schema:"term"`
+}
+
+func TestNestedQueryClient(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterGet(router, "search", func(ctx context.Context, req SearchQuery) (GetResp, *vel.Error) {
+		return GetResp{}, nil
+	})
+	gener, err := newClientGen(router, ClientGeneratorConfig{TypeName: "Client", PackageName: "client"})
+	requireNoError(t, err)
+
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+	var params []string
+	for _, param := range spec.Paths["/search"].Get.Parameters {
+		params = append(params, fmt.Sprintf("%s:%s:%t", param.Name, param.Schema.Type, param.Required))
+	}
+	assertEqual(t, "filter.status:array:false filter.before:string:false day:array:false limit:integer:false term:string:true", strings.Join(params, " "))
+
+	for _, tc := range []struct {
+		lang     string
+		expected []string
+	}{
+		{"go:default", []string{
+			"if req.Filter != nil {",
+			`q.Add("filter.status", queryValue(v))`,
+			"if req.Filter != nil && req.Filter.Before != nil {",
+			`q.Set("filter.before", queryValue(*req.Filter.Before))`,
+			"for _, v := range req.Days {",
+			`q.Set("limit", queryValue(*req.Limit))`,
+		}},
+		{"ts:default", []string{
+			"query['filter.status'] = req.filter?.status",
+			"query['day'] = req.days",
+			"url.searchParams.append(key, String(item))",
+		}},
+	} {
+		t.Run(tc.lang, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			requireNoError(t, gener.GenerateWith(buf, tc.lang, nil))
+			for _, expected := range tc.expected {
+				if !strings.Contains(buf.String(), expected) {
+					t.Errorf("expected the client to contain %q", expected)
+				}
+			}
+		})
+	}
+}
+
+type PageQuery struct {
+	Limit *int      `json:"limit" schema:"limit"`
+	Sort  string    `json:"sort" schema:"sort,omitempty"`
+	Since time.Time `json:"since" schema:"since,omitempty"`
+	Token string    `json:"token" schema:"token"`
+}
+
+func TestOptionalQueryParams(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterGet(router, "page", func(ctx context.Context, req PageQuery) (GetResp, *vel.Error) {
+		return GetResp{}, nil
+	})
+	gener, err := newClientGen(router, ClientGeneratorConfig{TypeName: "Client", PackageName: "client"})
+	requireNoError(t, err)
+
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+	var params []string
+	for _, param := range spec.Paths["/page"].Get.Parameters {
+		params = append(params, fmt.Sprintf("%s:%t", param.Name, param.Required))
+	}
+	assertEqual(t, "limit:false sort:false since:false token:true", strings.Join(params, " "))
+
+	for _, tc := range []struct {
+		lang     string
+		expected []string
+	}{
+		{"go:default", []string{
+			"if req.Limit != nil {",
+			`if req.Sort != "" {`,
+			"if !req.Since.IsZero() {",
+			`q.Set("token", queryValue(req.Token))`,
+		}},
+		{"ts:default", []string{
+			"limit?: number",
+			"sort?: string",
+			"token: string",
+		}},
+	} {
+		t.Run(tc.lang, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			requireNoError(t, gener.GenerateWith(buf, tc.lang, nil))
+			for _, expected := range tc.expected {
+				if !strings.Contains(buf.String(), expected) {
+					t.Errorf("expected the client to contain %q", expected)
+				}
+			}
+		})
+	}
+}
+
+func TestEnforcedHeaderError(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "create", func(ctx context.Context, req GetQuery) (GetResp, *vel.Error) {
+		return GetResp{}, nil
+	}).SetSpec(vel.Spec{
+		RequestHeaders:        vel.KeyValueSpec{Key: "X-Region", Validation: vel.Validation{Required: true}},
+		EnforceRequestHeaders: true,
+		Errors: map[int][]vel.ErrorSpec{
+			http.StatusNotFound:            {{Code: "NOT_FOUND"}},
+			http.StatusInternalServerError: {{Code: "BROKEN"}},
+		},
+	})
+	gener, err := newClientGen(router, ClientGeneratorConfig{TypeName: "Client", PackageName: "client"})
+	requireNoError(t, err)
+
+	var codes []string
+	for _, e := range gener.meta.Apis[0].Errors {
+		codes = append(codes, fmt.Sprintf("%d:%s:%t", e.Status, e.Code, e.Violations))
+	}
+	assertEqual(t, "400:INVALID_REQUEST_HEADER:true 404:NOT_FOUND:false 500:BROKEN:false", strings.Join(codes, " "))
+
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+	if _, ok := spec.Paths["/create"].Post.Responses["400"]; !ok {
+		t.Error("expected the 400 response of the enforced header")
+	}
+}
+
+func TestCheckResponses(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterGet(router, "find", func(ctx context.Context, req GetQuery) (GetResp, *vel.Error) {
+		return GetResp{}, nil
+	})
+	gener, err := newClientGen(router, ClientGeneratorConfig{TypeName: "Client", PackageName: "client", CheckResponses: true})
+	requireNoError(t, err)
+	if !gener.meta.Client.Zod {
+		t.Error("expected checking the responses to generate the Zod schemas")
+	}
+
+	buf := &bytes.Buffer{}
+	requireNoError(t, gener.GenerateWith(buf, "ts:default", nil))
+	for _, expected := range []string{
+		"export const GetRespSchema = z.object(",
+		"export class ResponseShapeError extends Error {",
+		"const parsed = schema.safeParse(data)",
+		"console.error(new ResponseShapeError(path, parsed.error.issues))",
+		"parseResponse(resp, schema, path)",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected the client to contain %q", expected)
+		}
+	}
+}
+
+func TestCodeErrors(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "find", func(ctx context.Context, req GetQuery) (GetResp, *vel.Error) {
+		return GetResp{}, nil
+	}).SetSpec(vel.Spec{Errors: map[int][]vel.ErrorSpec{
+		http.StatusNotFound: {{Code: "USER_NOT_FOUND", Meta: []vel.KeyValueSpec{
+			{Key: "user_id", ValueType: vel.String},
+			{Key: "attempts", ValueType: vel.Int},
+		}}},
+		http.StatusConflict: {{Code: "409_TAKEN", Meta: []vel.KeyValueSpec{{Key: "err", ValueType: vel.Bool}}}},
+	}})
+	vel.RegisterPost(router, "remove", func(ctx context.Context, req GetQuery) (GetResp, *vel.Error) {
+		return GetResp{}, nil
+	}).SetSpec(vel.Spec{Errors: map[int][]vel.ErrorSpec{
+		http.StatusNotFound: {{Code: "USER_NOT_FOUND", Meta: []vel.KeyValueSpec{{Key: "ratio", ValueType: vel.Float64}}}},
+	}})
+	gener, err := newClientGen(router, ClientGeneratorConfig{TypeName: "Client", PackageName: "client"})
+	requireNoError(t, err)
+
+	var names []string
+	for _, e := range gener.meta.CodeErrors {
+		fields := []string{e.TypeName}
+		for _, m := range e.Meta {
+			fields = append(fields, m.Field+":"+m.GoType)
+		}
+		names = append(names, strings.Join(fields, " "))
+	}
+	assertEqual(t, "Code409TakenError ErrMeta:bool, UserNotFoundError UserId:string Attempts:int Ratio:float64", strings.Join(names, ", "))
+
+	buf := &bytes.Buffer{}
+	requireNoError(t, gener.GenerateWith(buf, "go:default", nil))
+	out := buf.String()
+	for _, expected := range []string{
+		"type UserNotFoundError struct {\n\tErr *Error\n\tUserId string\n\tAttempts int\n\tRatio float64\n}",
+		"func (e *UserNotFoundError) Unwrap() error {",
+		"typed.Attempts, _ = strconv.Atoi(v)",
+		"typed.ErrMeta, _ = strconv.ParseBool(v)",
+		"return typedError(errResp)",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in the client", expected)
+		}
+	}
+}
+
+func TestTSClientRetry(t *testing.T) {
+	gener, err := New(ClientDesc{
+		TypeName:    "Client",
+		PackageName: "client",
+		ErrorSchema: vel.ErrorSchema{Envelope: "error"},
+	}, []vel.HandlerMeta{
+		{Input: GetQuery{}, Output: GetResp{}, OperationID: "testGet", Method: "GET"},
+	})
+	requireNoError(t, err)
+
+	buf := &bytes.Buffer{}
+	requireNoError(t, gener.GenerateWith(buf, "ts:default", nil))
+	for _, expected := range []string{
+		"retry?: RetryPolicy",
+		"export type RetryPolicy = {",
+		"res = await this.fetchFn(url, init)",
+		"private async send(url: string, init: RequestInit): Promise<Response> {",
+		"const code = payload?.['error']?.['code']",
+		"res.headers.get('Retry-After')",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected the client to contain %q", expected)
+		}
+	}
+}
+
+func TestTSClientErrorMeta(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "find", func(ctx context.Context, req GetQuery) (GetResp, *vel.Error) {
+		return GetResp{}, nil
+	}).SetSpec(vel.Spec{Errors: map[int][]vel.ErrorSpec{
+		http.StatusNotFound: {{Code: "USER_NOT_FOUND", Meta: []vel.KeyValueSpec{
+			{Key: "x-request-id", ValueType: vel.String, Validation: vel.Validation{Required: true}},
+			{Key: "user_id", ValueType: vel.String},
+		}}},
+	}})
+	gener, err := newClientGen(router, ClientGeneratorConfig{TypeName: "Client", PackageName: "client"})
+	requireNoError(t, err)
+
+	buf := &bytes.Buffer{}
+	requireNoError(t, gener.GenerateWith(buf, "ts:default", nil))
+	for _, expected := range []string{
+		"meta: { 'x-request-id': string; user_id?: string; }",
+		"constructor(baseUrl: string, opts?: ClientOptions)\n",
+		"constructor(baseUrl: string, fetchFn?: FetchFn, cache?: ResponseCache)\n",
+		"opts = { fetch: opts, cache }",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected the client to contain %q", expected)
+		}
+	}
+}
+
+func TestClientHooks(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "create", func(ctx context.Context, req GetQuery) (GetResp, *vel.Error) {
+		return GetResp{}, nil
+	})
+	gener, err := newClientGen(router, ClientGeneratorConfig{TypeName: "Client", PackageName: "client"})
+	requireNoError(t, err)
+
+	for _, tc := range []struct {
+		templateName string
+		expected     []string
+	}{
+		{"go:default", []string{
+			"func (c *Client) WithHooks(hooks Hooks) *Client {",
+			`ctx = context.WithValue(ctx, operationIDKey{}, "create")`,
+			"info.Code = errResp.Code",
+			"return c.observe(r, next)",
+		}},
+		{"ts:default", []string{
+			"hooks?: Hooks",
+			"export type CallInfo = {",
+			"operationId: 'create'",
+			"const res = await this.call(opts.operationId ?? '', url, {",
+			"info.code = await errorCode(res)",
+		}},
+	} {
+		t.Run(tc.templateName, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			requireNoError(t, gener.GenerateWith(buf, tc.templateName, nil))
+			for _, expected := range tc.expected {
+				if !strings.Contains(buf.String(), expected) {
+					t.Errorf("expected the client to contain %q", expected)
+				}
+			}
+		})
+	}
+}
+
+func TestBatchClient(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "create", func(ctx context.Context, req TestTypeNestedTypes) (UserRecord, *vel.Error) {
+		return UserRecord{}, nil
+	})
+	vel.RegisterGet(router, "find", func(ctx context.Context, req GetQuery) (GetResp, *vel.Error) {
+		return GetResp{}, nil
+	})
+	vel.RegisterPost(router, "ping", func(ctx context.Context, req struct{}) (struct{}, *vel.Error) {
+		return struct{}{}, nil
+	})
+	vel.RegisterGet(router, "watch", func(ctx context.Context, req GetQuery) (GetResp, *vel.Error) {
+		return GetResp{}, nil
+	}).SetSpec(vel.Spec{Stream: vel.StreamSSE})
+	// the server can't tell the finds apart
+	vel.RegisterGet(router.Subrouter("v1"), "find", func(ctx context.Context, req GetQuery) (GetResp, *vel.Error) {
+		return GetResp{}, nil
+	})
+	gener, err := newClientGen(router, ClientGeneratorConfig{TypeName: "Client", PackageName: "client", Batch: true})
+	requireNoError(t, err)
+
+	var names []string
+	for _, api := range gener.meta.BatchApis() {
+		names = append(names, api.OperationID)
+	}
+	assertEqual(t, "create ping", strings.Join(names, " "))
+
+	for _, tc := range []struct {
+		template string
+		expected []string
+	}{
+		{"go:default", []string{
+			"func (c *Client) NewBatch() *Batch {",
+			"func (b *Batch) Create(req TestTypeNestedTypes) *BatchCall[UserRecord] {",
+			`b.add("create", req, func(status int, body json.RawMessage) {`,
+			"func (b *Batch) Ping() *BatchCall[struct{}] {",
+			`b.add("ping", nil, func(status int, body json.RawMessage) {`,
+		}},
+		{"ts:default", []string{
+			"batch(): Batch {",
+			"Create(req: TestTypeNestedTypes): Promise<Result<UserRecord>> {",
+			"return this.add('ping', undefined)",
+		}},
+	} {
+		t.Run(tc.template, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			requireNoError(t, gener.GenerateWith(buf, tc.template, nil))
+			for _, expected := range tc.expected {
+				if !strings.Contains(buf.String(), expected) {
+					t.Errorf("expected the client to contain %q", expected)
+				}
+			}
+			if strings.Contains(buf.String(), "Batch) Find(") || strings.Contains(buf.String(), "  Find(req") {
+				t.Error("expected no batch call of the ambiguous operation")
+			}
+		})
+	}
+
+	// the batch is generated only on demand
+	gener, err = newClientGen(router, ClientGeneratorConfig{TypeName: "Client", PackageName: "client"})
+	requireNoError(t, err)
+	buf := &bytes.Buffer{}
+	requireNoError(t, gener.GenerateWith(buf, "go:default", nil))
+	if strings.Contains(buf.String(), "Batch") {
+		t.Error("expected no batch without the option")
+	}
+}
+
+func TestPostmanCollection(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "createUser", vel.Handler[TestTypeNoJsonTags, TestTypeNoJsonTags](
+		func(ctx context.Context, req TestTypeNoJsonTags) (TestTypeNoJsonTags, *vel.Error) {
+			return req, nil
+		},
+	)).SetSpec(vel.Spec{
+		Description:    "Creates a user",
+		RequestHeaders: vel.KeyValueSpec{Key: "X-Tenant", Description: "tenant id"},
+		Examples:       []vel.Example{{Name: "bob", Request: TestTypeNoJsonTags{Value: "bob"}}},
+	})
+	vel.RegisterGet(router.Subrouter("v1").Subrouter("admin"), "testGet", vel.Handler[GetQuery, GetResp](
+		func(ctx context.Context, req GetQuery) (GetResp, *vel.Error) {
+			return GetResp{}, nil
+		},
+	))
+
+	buf := &bytes.Buffer{}
+	requireNoError(t, GeneratePostman(router, buf, PostmanConfig{Name: "Test API", AuthHeaders: []string{"Authorization"}}))
+	var collection PostmanCollection
+	requireNoError(t, json.Unmarshal(buf.Bytes(), &collection))
+
+	assertEqual(t, postmanSchema, collection.Info.Schema)
+	if expected := []PostmanVariable{
+		{Key: "baseUrl", Value: "http://localhost:8080"},
+		{Key: "authorization"},
+		{Key: "xTenant"},
+	}; !reflect.DeepEqual(expected, collection.Variable) {
+		t.Errorf("expected variables %v, got %v", expected, collection.Variable)
+	}
+
+	// the folders go before the requests
+	if len(collection.Item) != 2 {
+		t.Fatalf("expected 2 items, got %d", len(collection.Item))
+	}
+	create := collection.Item[1]
+	if create.Name != "createUser" || create.Description != "Creates a user" {
+		t.Errorf("unexpected item %s", create.Name)
+	}
+	assertEqual(t, "{{baseUrl}}/createUser", create.Request.URL.Raw)
+	assertEqual(t, "{\n  \"Value\": \"bob\"\n}", create.Request.Body.Raw)
+	if expected := []PostmanHeader{
+		{Key: "Authorization", Value: "{{authorization}}"},
+		{Key: "X-Tenant", Value: "{{xTenant}}", Description: "tenant id"},
+		{Key: "Content-Type", Value: "application/json"},
+	}; !reflect.DeepEqual(expected, create.Request.Header) {
+		t.Errorf("expected headers %v, got %v", expected, create.Request.Header)
+	}
+
+	folder := collection.Item[0]
+	if folder.Name != "V1" || len(folder.Item) != 1 || folder.Item[0].Name != "Admin" {
+		t.Fatalf("expected the V1/Admin folders, got %+v", folder)
+	}
+	get := folder.Item[0].Item[0].Request
+	assertEqual(t, "GET", get.Method)
+	assertEqual(t, "v1/admin/testGet", strings.Join(get.URL.Path, "/"))
+	assertEqual(t, "{{baseUrl}}/v1/admin/testGet?value=example&field=42&since=2024-01-15T09:30:00Z", get.URL.Raw)
+	if get.Body != nil {
+		t.Error("expected no body of a GET request")
+	}
+}
+
+func TestCodeSamples(t *testing.T) {
+	meta := []vel.HandlerMeta{
+		{Input: GetQuery{}, Output: GetResp{}, OperationID: "get", Method: "GET"},
+		{Input: TestTypeNoJsonTags{}, Output: TestTypeNoJsonTags{}, OperationID: "create", Method: "POST", Spec: vel.Spec{
+			RequestHeaders: vel.KeyValueSpec{Key: "X-Tenant"},
+			Examples:       []vel.Example{{Name: "quote", Request: TestTypeNoJsonTags{Value: "it's"}}},
+		}},
+	}
+	gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client", CodeSamplesURL: "http://localhost:8080/"}, meta)
+	requireNoError(t, err)
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+
+	samples := spec.Paths["/get"].Get.CodeSamples
+	if len(samples) != 2 {
+		t.Fatalf("expected 2 samples, got %d", len(samples))
+	}
+	assertEqual(t, "curl 'http://localhost:8080/get?value=example&field=42&since=2024-01-15T09%3A30%3A00Z'", samples[0].Source)
+	assertEqual(t, "http GET 'http://localhost:8080/get?value=example&field=42&since=2024-01-15T09%3A30%3A00Z'", samples[1].Source)
+
+	samples = spec.Paths["/create"].Post.CodeSamples
+	assertEqual(t, `curl -X POST 'http://localhost:8080/create' \
+  -H "X-Tenant: $X_TENANT" \
+  -H 'Content-Type: application/json' \
+  -d '{
+  "Value": "it'\''s"
+}'`, samples[0].Source)
+	assertEqual(t, `http POST 'http://localhost:8080/create' "X-Tenant:$X_TENANT" \
+  --raw '{
+  "Value": "it'\''s"
+}'`, samples[1].Source)
+
+	gener, err = New(ClientDesc{TypeName: "Client", PackageName: "client"}, meta)
+	requireNoError(t, err)
+	spec, err = gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+	if spec.Paths["/get"].Get.CodeSamples != nil {
+		t.Error("expected no samples without the url")
+	}
+}
+
+func TestFromOpenAPI(t *testing.T) {
+	document := `openapi: 3.0.0
+paths:
+  /pets/{id}:
+    put:
+      operationId: updatePet
+      summary: Updates a pet
+      parameters:
+        - name: id
+          in: path
+          required: true
+          schema:
+            type: integer
+            format: int64
+        - name: X-Tenant
+          in: header
+          schema:
+            type: string
+      requestBody:
+        content:
+          application/json:
+            schema:
+              type: object
+              required: [name]
+              properties:
+                name:
+                  type: string
+                owner:
+                  type: object
+                  properties:
+                    email:
+                      type: string
+      responses:
+        "200":
+          description: the pet
+          content:
+            application/json:
+              schema:
+                $ref: "#/components/schemas/Pet"
+        "404":
+          description: no such pet
+  /pets:
+    get:
+      parameters:
+        - name: status
+          in: query
+          schema:
+            $ref: "#/components/schemas/Status"
+      responses:
+        "200":
+          description: the pets
+          content:
+            application/json:
+              schema:
+                $ref: "#/components/schemas/Pets"
+components:
+  schemas:
+    Pet:
+      type: object
+      required: [id]
+      properties:
+        id:
+          type: integer
+        born_at:
+          type: string
+          format: date-time
+    Pets:
+      type: array
+      items:
+        $ref: "#/components/schemas/Pet"
+    Status:
+      type: string
+      enum: [available, sold_out]
+`
+	desc, err := importOpenAPI([]byte(document), "pets")
+	requireNoError(t, err)
+	files, err := renderImport(desc)
+	requireNoError(t, err)
+
+	for name, expected := range map[string][]string{
+		"types.go": {
+			"type Pet struct {\n\tBornAt time.Time `json:\"born_at,omitempty\"`\n",
+			"type Pets []Pet",
+			"StatusSoldOut   Status = \"sold_out\"",
+			"func (Status) EnumValues() []string {\n\treturn []string{\"available\", \"sold_out\"}\n}",
+			"type GetPetsRequest struct {\n\tStatus Status `schema:\"status\"`\n}",
+			"type UpdatePetRequest struct {\n\tId    int64 ",
+			"Owner UpdatePetRequestOwner `json:\"owner,omitempty\"`",
+			"type UpdatePetRequestOwner struct {",
+		},
+		"handlers.go": {
+			"// UpdatePet serves PUT /pets/{id} of the original API.\n// Updates a pet\nfunc UpdatePet(ctx context.Context, req UpdatePetRequest) (Pet, *vel.Error) {",
+			"func GetPets(ctx context.Context, req GetPetsRequest) (Pets, *vel.Error) {",
+		},
+		"router.go": {
+			"// GET /pets\n\tvel.RegisterGet(r, \"getPets\", GetPets)\n",
+			"// PUT /pets/{id}\n\tvel.RegisterPost(r, \"updatePet\", UpdatePet).SetSpec(vel.Spec{",
+			"RequestHeaders: vel.KeyValueSpec{Key: \"X-Tenant\", Description: \"\"},",
+			"404: {\n\t\t\t\t{Code: \"NOT_FOUND\", Description: \"no such pet\"},",
+		},
+	} {
+		for _, snippet := range expected {
+			if !strings.Contains(string(files[name]), snippet) {
+				t.Errorf("expected %s to contain %q, got\n%s", name, snippet, files[name])
+			}
+		}
+	}
+
+	dir := t.TempDir()
+	specPath := filepath.Join(dir, "openapi.yaml")
+	requireNoError(t, os.WriteFile(specPath, []byte(document), 0644))
+	out := filepath.Join(dir, "pets")
+	requireNoError(t, FromOpenAPI(specPath, out))
+	written, err := os.ReadFile(filepath.Join(out, "router.go"))
+	requireNoError(t, err)
+	assertEqual(t, true, strings.HasPrefix(string(written), "package pets\n"))
+	if err := FromOpenAPI(specPath, out); err == nil {
+		t.Error("expected the existing files not to be overwritten")
+	}
+}
+
+func TestDiffOpenAPI(t *testing.T) {
+	old := `openapi: 3.0.0
+paths:
+  /createUser:
+    post:
+      operationId: createUser
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: "#/components/schemas/CreateUser"
+      responses:
+        "200":
+          description: Success
+          content:
+            application/json:
+              schema:
+                $ref: "#/components/schemas/User"
+        "201":
+          description: Created
+          content:
+            application/json:
+              schema:
+                type: object
+                properties:
+                  location:
+                    type: string
+                  id:
+                    type: string
+        "404":
+          description: Not found
+          content:
+            application/json:
+              schema:
+                type: object
+                properties:
+                  code:
+                    type: string
+  /listUsers:
+    get:
+      operationId: listUsers
+      parameters:
+        - name: page
+          in: query
+          required: true
+          schema:
+            type: integer
+      responses:
+        "200":
+          description: Success
+  /deleteUser:
+    post:
+      operationId: deleteUser
+      responses:
+        "200":
+          description: Success
+  /updateUser:
+    put:
+      operationId: updateUser
+      responses:
+        "200":
+          description: Success
+components:
+  schemas:
+    CreateUser:
+      type: object
+      properties:
+        name:
+          type: string
+        nickname:
+          type: string
+        role:
+          $ref: "#/components/schemas/Role"
+      required: [name]
+    Role:
+      type: string
+      enum: [admin, guest, owner]
+    User:
+      type: object
+      properties:
+        id:
+          type: string
+        email:
+          type: string
+        friends:
+          type: array
+          items:
+            $ref: "#/components/schemas/User"
+      required: [id, email, friends]
+`
+	new := `openapi: 3.0.0
+paths:
+  /createUser:
+    post:
+      operationId: createUser
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: "#/components/schemas/CreateUser"
+      responses:
+        "200":
+          description: Success
+          content:
+            application/json:
+              schema:
+                $ref: "#/components/schemas/User"
+        "201":
+          description: Created
+          content:
+            application/json:
+              schema:
+                type: object
+                properties:
+                  id:
+                    type: string
+  /listUsers:
+    get:
+      operationId: listUsers
+      parameters:
+        - name: page
+          in: query
+          required: true
+          schema:
+            type: integer
+        - name: tenant
+          in: query
+          required: true
+          schema:
+            type: string
+      responses:
+        "200":
+          description: Success
+components:
+  schemas:
+    CreateUser:
+      type: object
+      properties:
+        name:
+          type: string
+        nickname:
+          type: string
+        role:
+          $ref: "#/components/schemas/Role"
+        team:
+          type: string
+        bio:
+          type: string
+      required: [name, nickname, team]
+    Role:
+      type: string
+      enum: [admin, owner, auditor]
+    User:
+      type: object
+      properties:
+        id:
+          type: string
+        friends:
+          type: array
+          items:
+            $ref: "#/components/schemas/User"
+        avatar:
+          type: string
+      required: [id, friends]
+`
+	dir := t.TempDir()
+	oldPath, newPath := filepath.Join(dir, "old.yaml"), filepath.Join(dir, "new.yaml")
+	requireNoError(t, os.WriteFile(oldPath, []byte(old), 0644))
+	requireNoError(t, os.WriteFile(newPath, []byte(new), 0644))
+
+	changes, err := DiffOpenAPIFiles(oldPath, newPath)
+	requireNoError(t, err)
+	var got []string
+	for _, change := range changes {
+		got = append(got, change.String())
+	}
+	assertEqual(t, strings.Join([]string{
+		"POST /createUser request.nickname: the field became required (new-required-field)",
+		"POST /createUser request.team: the new field is required (new-required-field)",
+		"POST /createUser request.role: the value guest isn't accepted anymore (narrowed-enum)",
+		"POST /createUser response.email: the field is removed (removed-response-field)",
+		"POST /createUser response 201.location: the field is removed (removed-response-field)",
+		"POST /deleteUser: the operation is removed (removed-operation)",
+		"GET /listUsers query tenant: the new parameter is required (new-required-field)",
+		"PUT /updateUser: the operation is removed (removed-operation)",
+	}, "\n"), strings.Join(got, "\n"))
+
+	changes, err = DiffOpenAPIFiles(newPath, newPath)
+	requireNoError(t, err)
+	assertEqual(t, 0, len(changes))
+}
+
+func TestContractTests(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "createEvent", vel.Handler[TimeTestRequest, GetResp](
+		func(ctx context.Context, req TimeTestRequest) (GetResp, *vel.Error) {
+			return GetResp{}, nil
+		},
+	)).SetSpec(vel.Spec{
+		RequestHeaders: vel.KeyValueSpec{Key: "X-Tenant", ValueExample: "acme"},
+		Examples:       []vel.Example{{Name: "launch", Request: TimeTestRequest{Name: "launch"}}},
+	})
+	vel.RegisterGet(router.Subrouter("v1"), "testGet", vel.Handler[GetQuery, GetResp](
+		func(ctx context.Context, req GetQuery) (GetResp, *vel.Error) {
+			return GetResp{}, nil
+		},
+	))
+
+	buf := &bytes.Buffer{}
+	requireNoError(t, GenerateContract(router, buf, ContractConfig{
+		Package:     "api",
+		Constructor: "NewRouter",
+		Headers:     map[string]string{"Authorization": "Bearer test"},
+	}))
+	source := buf.String()
+
+	for _, snippet := range []string{
+		"package api",
+		"httptest.NewServer(NewRouter().Mux())",
+		`"Authorization": "Bearer test"`,
+		`"createEvent/valid"`,
+		`"{\"createdAt\":\"0001-01-01T00:00:00Z\",\"name\":\"launch\"}"`,
+		`"X-Tenant": "acme"`,
+		`"createEvent/malformed body"`,
+		// createdAt decodes itself, so name gets the wrong type
+		`"createEvent/wrong type of name"`,
+		`"{\"name\": 0}"`,
+		`"createEvent/invalid"`,
+		`wantCode:   "VALIDATION_FAILED"`,
+		`"v1/testGet/valid"`,
+		`"/v1/testGet?field=42&since=2024-01-15T09%3A30%3A00Z&value=example"`,
+		`"v1/testGet/wrong type of field"`,
+		`"/v1/testGet?field=x"`,
+		`wantCode:   "FAILED_DECODING_QUERY"`,
+	} {
+		if !strings.Contains(source, snippet) {
+			t.Errorf("expected the tests to contain %s", snippet)
+		}
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "contract_test.go", source, 0); err != nil {
+		t.Errorf("the tests don't parse: %v", err)
+	}
+}
+
+type ExampleAddress struct {
+	City     string `json:"city"`
+	Postcode string `json:"postcode"`
+}
+
+type ExampleNode struct {
+	Name     string        `json:"name"`
+	Children []ExampleNode `json:"children"`
+	Parent   *ExampleNode  `json:"parent"`
+}
+
+type ExampleSignup struct {
+	UserID    UserID            `json:"user_id"`
+	Email     string            `json:"email"`
+	FirstName string            `json:"firstName"`
+	Username  string            `json:"username"`
+	Age       int               `json:"age"`
+	Plan      string            `json:"plan"`
+	Status    Status            `json:"status"`
+	Tags      []string          `json:"tags"`
+	Labels    map[string]string `json:"labels"`
+	Address   *ExampleAddress   `json:"address"`
+	Tree      ExampleNode       `json:"tree"`
+	CreatedAt time.Time         `json:"createdAt"`
+	Note      string            `json:"-"`
+	Password  string            `json:"password" redact:"true"`
+	Pin       int               `json:"pin" redact:"true"`
+}
+
+func (r ExampleSignup) Validate() []vel.Violation {
+	var violations []vel.Violation
+	if len(r.Username) < 12 {
+		violations = append(violations, vel.ViolationMinLen("username", 12))
+	}
+	if len(r.FirstName) > 2 {
+		violations = append(violations, vel.ViolationMaxLen("firstName", 2))
+	}
+	if r.Age < 40 {
+		violations = append(violations, vel.ViolationMin("age", 40))
+	}
+	if r.Plan != "free" && r.Plan != "pro" {
+		violations = append(violations, vel.ViolationEnum("plan", []string{"free", "pro"}))
+	}
+	if len(r.Tags) < 3 {
+		violations = append(violations, vel.ViolationMinLen("tags", 3))
+	}
+	if len(r.Address.City) > 3 {
+		violations = append(violations, vel.ViolationMaxLen("address.city", 3))
+	}
+	return violations
+}
+
+func TestExample(t *testing.T) {
+	example := ExampleOf[ExampleSignup]()
+
+	assertEqual(t, UserID("3f2b8c4e-1a7d-4e9b-9c2a-5d6e7f8a9b0c"), example.UserID)
+	assertEqual(t, "jane.doe@example.com", example.Email)
+	assertEqual(t, Status("active"), example.Status)
+	assertEqual(t, "key", slices.Collect(maps.Keys(example.Labels))[0])
+	assertEqual(t, "NW1 6XE", example.Address.Postcode)
+	assertEqual(t, "2024-01-15T09:30:00Z", example.CreatedAt.Format(time.RFC3339))
+	assertEqual(t, "", example.Note)
+	// the secrets aren't shown
+	assertEqual(t, vel.Redacted, example.Password)
+	assertEqual(t, 0, example.Pin)
+	if example.Tree.Name != "Jane Doe" || example.Tree.Children != nil || example.Tree.Parent != nil {
+		t.Errorf("expected a recursive type to stop at its first level, got %+v", example.Tree)
+	}
+
+	// the violations are fixed
+	assertEqual(t, "jane.doexxxx", example.Username)
+	assertEqual(t, "Ja", example.FirstName)
+	assertEqual(t, 40, example.Age)
+	assertEqual(t, "free", example.Plan)
+	assertEqual(t, 3, len(example.Tags))
+	assertEqual(t, "Lon", example.Address.City)
+	assertEqual(t, 0, len(example.Validate()))
+
+	// the examples are stable
+	if !reflect.DeepEqual(example, ExampleOf[ExampleSignup]()) {
+		t.Error("expected the same example on every call")
+	}
+}
+
+func TestOpenAPIExamples(t *testing.T) {
+	meta := []vel.HandlerMeta{
+		{Input: GetQuery{}, Output: GetResp{}, OperationID: "get", Method: "GET"},
+		{Input: TimeTestRequest{}, Output: TimeTestResponse{}, OperationID: "create", Method: "POST", Spec: vel.Spec{
+			Examples: []vel.Example{{Name: "launch", Request: TimeTestRequest{Name: "launch"}}},
+		}},
+	}
+	gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client", Examples: true}, meta)
+	requireNoError(t, err)
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+
+	get := spec.Paths["/get"].Get
+	examples := make(map[string]any)
+	for _, param := range get.Parameters {
+		examples[param.Name] = param.Example
+	}
+	assertEqual(t, "map[field:42 since:2024-01-15T09:30:00Z value:example]", fmt.Sprint(examples))
+	assertEqual(t, "map[Getting:42]", fmt.Sprint(get.Responses["200"].Content.ApplicationJSON.Example))
+
+	create := spec.Paths["/create"].Post
+	// the declared example comes first
+	assertEqual(t, "map[createdAt:0001-01-01T00:00:00Z name:launch]", fmt.Sprint(create.RequestBody.Content.ApplicationJSON.Example))
+	assertEqual(t, "map[id:3f2b8c4e-1a7d-4e9b-9c2a-5d6e7f8a9b0c processedAt:2024-01-15T09:30:00Z]", fmt.Sprint(create.Responses["200"].Content.ApplicationJSON.Example))
+}
+
+func TestOpenAPIMiddlewareHeaders(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "pay", func(ctx context.Context, req TestTypeNoJsonTags) (TestTypeNoJsonTags, *vel.Error) {
+		return req, nil
+	}, vel.Idempotency(vel.NewMemoryIdempotencyStore(0), vel.IdempotencyOpts{Required: true})).SetSpec(vel.Spec{
+		RequestHeaders: vel.KeyValueSpec{Key: "X-Tenant"},
+	})
+	gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client"}, router.Meta())
+	requireNoError(t, err)
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+
+	params := spec.Paths["/pay"].Post.Parameters
+	if len(params) != 2 {
+		t.Fatalf("expected the spec and the middleware headers, got %d parameters", len(params))
+	}
+	assertEqual(t, "X-Tenant", params[0].Name)
+	assertEqual(t, vel.IdempotencyKeyHeader, params[1].Name)
+	assertEqual(t, "header", params[1].In)
+	assertEqual(t, true, params[1].Required)
+}
+
+func TestOpenAPISecurity(t *testing.T) {
+	router := vel.NewRouter()
+	secret := func(ctx context.Context, keyID string) ([]byte, error) { return []byte("secret"), nil }
+	vel.RegisterPost(router, "webhook", func(ctx context.Context, req TestTypeNoJsonTags) (TestTypeNoJsonTags, *vel.Error) {
+		return req, nil
+	}, vel.Signature(vel.SignatureOpts{Secret: secret}))
+	vel.RegisterPost(router, "open", func(ctx context.Context, req TestTypeNoJsonTags) (TestTypeNoJsonTags, *vel.Error) {
+		return req, nil
+	})
+	gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client"}, router.Meta())
+	requireNoError(t, err)
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+
+	scheme := spec.Components.SecuritySchemes["hmacSignature"]
+	if scheme == nil || scheme.Type != "apiKey" || scheme.In != "header" || scheme.Name != vel.SignatureHeader {
+		t.Fatalf("expected the signature scheme, got %+v", scheme)
+	}
+	webhook := spec.Paths["/webhook"].Post
+	if len(webhook.Security) != 1 || webhook.Security[0]["hmacSignature"] == nil {
+		t.Errorf("expected webhook to require the signature, got %v", webhook.Security)
+	}
+	var params []string
+	for _, param := range webhook.Parameters {
+		params = append(params, param.Name)
+	}
+	assertEqual(t, vel.SignatureTimestampHeader+" "+vel.SignatureKeyIDHeader, strings.Join(params, " "))
+	if spec.Paths["/open"].Post.Security != nil {
+		t.Error("expected open to require no security")
+	}
+
+	out, err := yaml.Marshal(spec)
+	requireNoError(t, err)
+	if !strings.Contains(string(out), "security:\n                - hmacSignature: []") {
+		t.Errorf("expected the security requirement in the spec, got\n%s", out)
+	}
+}
+
+func TestOpenAPIPermissions(t *testing.T) {
+	router := vel.NewRouter()
+	router.Use(vel.Authorize(vel.PermissionPolicy))
+	vel.RegisterPost(router, "deleteUser", func(ctx context.Context, req TestTypeNoJsonTags) (TestTypeNoJsonTags, *vel.Error) {
+		return req, nil
+	}).SetSpec(vel.Spec{Permissions: []string{"users:read", "users:write"}})
+	vel.RegisterPost(router, "open", func(ctx context.Context, req TestTypeNoJsonTags) (TestTypeNoJsonTags, *vel.Error) {
+		return req, nil
+	})
+	gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client"}, router.Meta())
+	requireNoError(t, err)
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+
+	assertEqual(t, "users:read users:write", strings.Join(spec.Paths["/deleteUser"].Post.RequiredPermissions, " "))
+	if spec.Paths["/open"].Post.RequiredPermissions != nil {
+		t.Error("expected open to require no permissions")
+	}
+	out, err := yaml.Marshal(spec)
+	requireNoError(t, err)
+	if !strings.Contains(string(out), "x-required-permissions:\n                - users:read\n                - users:write") {
+		t.Errorf("expected the permissions in the spec, got\n%s", out)
+	}
+}
+
+type userCreatedEvent struct {
+	Name string `json:"name"`
+}
+
+func TestOpenAPIWebhooks(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "hello", func(ctx context.Context, req TestTypeNoJsonTags) (TestTypeNoJsonTags, *vel.Error) {
+		return req, nil
+	})
+	hooks := velhook.New(router, velhook.Opts{})
+	velhook.Register[userCreatedEvent](hooks, "userCreated", "a user signed up")
+	gener, err := newRouterGen(router, ClientDesc{TypeName: "Client", PackageName: "client"})
+	requireNoError(t, err)
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+
+	assertEqual(t, "3.1.0", spec.OpenAPI)
+	hook := spec.Webhooks["userCreated"]
+	if hook == nil || hook.Post == nil {
+		t.Fatalf("expected the userCreated webhook, got %v", spec.Webhooks)
+	}
+	assertEqual(t, "a user signed up", hook.Post.Description)
+	if len(hook.Post.Security) != 1 || hook.Post.Security[0]["hmacSignature"] == nil || spec.Components.SecuritySchemes["hmacSignature"] == nil {
+		t.Errorf("expected the webhook to be signed, got %v", hook.Post.Security)
+	}
+	var params []string
+	for _, param := range hook.Post.Parameters {
+		params = append(params, param.Name)
+	}
+	assertEqual(t, strings.Join([]string{velhook.EventIDHeader, velhook.EventTypeHeader, vel.SignatureTimestampHeader, vel.SignatureKeyIDHeader}, " "), strings.Join(params, " "))
+
+	ref := hook.Post.RequestBody.Content.ApplicationJSON.Schema.Ref
+	envelope := spec.Components.Schemas[strings.TrimPrefix(ref, "#/components/schemas/")]
+	if envelope == nil || envelope.Properties["data"] == nil || envelope.Properties["id"] == nil {
+		t.Fatalf("expected the envelope schema by %s, got %+v", ref, envelope)
+	}
+	if spec.Components.Schemas[strings.TrimPrefix(envelope.Properties["data"].Ref, "#/components/schemas/")] == nil {
+		t.Errorf("expected the payload schema, got %+v", envelope.Properties["data"])
+	}
+	if spec.Paths["/hello"] == nil || spec.Paths["/userCreated"] != nil {
+		t.Error("expected the webhooks to stay out of the paths")
+	}
+}
+
+// driftingResp encodes itself other than its type declares, the way a hand-written MarshalJSON drifts from the spec
+type driftingResp struct {
+	Message string `json:"message"`
+}
+
+func (r driftingResp) MarshalJSON() ([]byte, error) {
+	return []byte(`{"msg":"` + r.Message + `"}`), nil
+}
+
+func TestValidateResponses(t *testing.T) {
+	newRouter := func(config ResponseValidationConfig) *vel.Router {
+		router := vel.NewRouter()
+		router.Use(ValidateResponses(router, config))
+		vel.RegisterPost(router, "hello", func(ctx context.Context, req TimeTestRequest) (TimeTestResponse, *vel.Error) {
+			switch req.Name {
+			case "gone":
+				return TimeTestResponse{}, &vel.Error{Code: "GONE"}
+			case "missing":
+				return TimeTestResponse{}, &vel.Error{Code: "NOT_FOUND"}
+			}
+			return TimeTestResponse{ID: req.Name}, nil
+		}).SetSpec(vel.Spec{Errors: map[int][]vel.ErrorSpec{http.StatusBadRequest: {{Code: "NOT_FOUND"}}}})
+		vel.RegisterPost(router, "drift", func(ctx context.Context, req TimeTestRequest) (driftingResp, *vel.Error) {
+			return driftingResp{Message: req.Name}, nil
+		})
+		return router
+	}
+
+	tests := []struct {
+		name      string
+		operation string
+		body      string
+		status    int
+		mismatch  string
+	}{
+		{name: "valid", operation: "hello", body: `{"name":"vel"}`, status: http.StatusOK},
+		{name: "declared error", operation: "hello", body: `{"name":"missing"}`, status: http.StatusBadRequest},
+		{name: "decoding error", operation: "hello", body: `{`, status: http.StatusBadRequest},
+		{name: "undeclared code", operation: "hello", body: `{"name":"gone"}`, status: http.StatusBadRequest, mismatch: "body.code: GONE isn't one of [NOT_FOUND]"},
+		{name: "undocumented field", operation: "drift", body: `{"name":"vel"}`, status: http.StatusOK, mismatch: "body.message is missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			logger := slog.New(slog.NewTextHandler(&logs, nil))
+
+			w := httptest.NewRecorder()
+			newRouter(ResponseValidationConfig{Logger: logger}).Mux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/"+tt.operation, strings.NewReader(tt.body)))
+			// only logged
+			assertEqual(t, tt.status, w.Code)
+			if tt.mismatch == "" && logs.Len() > 0 {
+				t.Errorf("unexpected mismatch %s", logs.String())
+			}
+			if tt.mismatch != "" && !strings.Contains(logs.String(), tt.mismatch) {
+				t.Errorf("expected the mismatch %q to be logged, got %s", tt.mismatch, logs.String())
+			}
+
+			w = httptest.NewRecorder()
+			newRouter(ResponseValidationConfig{Logger: logger, Fail: true}).Mux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/"+tt.operation, strings.NewReader(tt.body)))
+			if tt.mismatch == "" {
+				assertEqual(t, tt.status, w.Code)
+				return
+			}
+			assertEqual(t, http.StatusInternalServerError, w.Code)
+			if !strings.Contains(w.Body.String(), ResponseMismatchCode) || !strings.Contains(w.Body.String(), tt.mismatch) {
+				t.Errorf("expected the %s error, got %s", ResponseMismatchCode, w.Body.String())
+			}
+		})
+	}
+}
+
+type jsonTestOrder struct {
+	ID    int64              `json:"id"`
+	Lines []*TimeTestRequest `json:"lines,omitempty"`
+	Meta  map[string]string  `json:"meta"`
+	Note  json.RawMessage    `json:"note"`
+}
+
+func TestGenerateJSON(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "order", func(ctx context.Context, req jsonTestOrder) (TimeTestResponse, *vel.Error) {
+		return TimeTestResponse{}, nil
+	})
+	vel.RegisterGet(router, "testGet", func(ctx context.Context, req GetQuery) (GetResp, *vel.Error) {
+		return GetResp{}, nil
+	})
+
+	buf := &bytes.Buffer{}
+	requireNoError(t, GenerateJSON(router, buf, JSONConfig{}))
+	source := buf.String()
+	for _, snippet := range []string{
+		"package gen",
+		`"github.com/dennypenta/vel/veljson"`,
+		"func (v GetResp) AppendJSON(b []byte) ([]byte, error) {",
+		"func (v *jsonTestOrder) UnmarshalJSON(data []byte) error {",
+		`var jsonFieldsjsonTestOrder = []string{"id", "lines", "meta", "note"}`,
+		"if len(v.Lines) != 0 {",
+		"if b, err = (*v.Lines[i0]).AppendJSON(b); err != nil {",
+		"for _, key0 := range slices.Sorted(maps.Keys(v.Meta)) {",
+		// RawMessage encodes itself
+		"if b, err = veljson.AppendValue(b, v.Note); err != nil {",
+		"r.Value(&v.Note)",
+		"if b, err = veljson.AppendTime(b, v.CreatedAt); err != nil {",
+		"r.Time(&v.CreatedAt)",
+		"item0 = new(TimeTestRequest)",
+		"func (v *TimeTestResponse) readJSON(r *veljson.Reader) {",
+	} {
+		if !strings.Contains(source, snippet) {
+			t.Errorf("expected the methods to contain %s", snippet)
+		}
+	}
+	// the GET query isn't decoded from JSON
+	if strings.Contains(source, "func (v GetQuery)") {
+		t.Error("expected no methods of the GET query")
+	}
+
+	vel.RegisterPost(router, "error", func(ctx context.Context, req TimeTestRequest) (vel.Error, *vel.Error) {
+		return vel.Error{}, nil
+	})
+	requireNoError(t, GenerateJSON(router, io.Discard, JSONConfig{}))
+	vel.RegisterPost(router, "link", func(ctx context.Context, req TimeTestRequest) (*url.URL, *vel.Error) {
+		return nil, nil
+	})
+	if err := GenerateJSON(router, io.Discard, JSONConfig{}); err == nil {
+		t.Error("expected the types of several packages to require the package")
+	}
+}
+
+type Card struct {
+	Kind  string `json:"kind" discriminator:"card"`
+	Last4 string `json:"last4"`
+}
+
+type BankAccount struct {
+	Kind string `json:"kind" discriminator:"bank"`
+	IBAN string `json:"iban"`
+}
+
+type PaymentMethodRequest struct {
+	UserID string `schema:"userId"`
+}
+
+// PaymentMethod names the union by embedding it
+type PaymentMethod struct {
+	vel.OneOf[Card, BankAccount]
+}
+
+func TestOneOfClient(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterGet(router, "paymentMethod", func(ctx context.Context, req PaymentMethodRequest) (vel.OneOf[Card, BankAccount], *vel.Error) {
+		return vel.OneOfA[Card, BankAccount](Card{Last4: "4242"}), nil
+	})
+	vel.RegisterGet(router, "defaultPaymentMethod", func(ctx context.Context, req PaymentMethodRequest) (PaymentMethod, *vel.Error) {
+		return PaymentMethod{}, nil
+	})
+	gener, err := newClientGen(router, ClientGeneratorConfig{TypeName: "Client", PackageName: "client"})
+	requireNoError(t, err)
+	gener.meta.Client.Examples = true
+
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	requireNoError(t, err)
+	union := spec.Components.Schemas["OneOfCardBankAccount"]
+	assertEqual(t, 2, len(union.OneOf))
+	assertEqual(t, "#/components/schemas/Card", union.OneOf[0].Ref)
+	assertEqual(t, "kind", union.Discriminator.PropertyName)
+	assertEqual(t, "#/components/schemas/BankAccount", union.Discriminator.Mapping["bank"])
+	assertEqual(t, "[card]", fmt.Sprint(spec.Components.Schemas["Card"].Properties["kind"].Enum))
+	assertEqual(t, "kind", spec.Components.Schemas["PaymentMethod"].Discriminator.PropertyName)
+	content := spec.Paths["/paymentMethod"].Get.Responses["200"].Content.ApplicationJSON
+	example, _ := json.Marshal(content.Example)
+	assertEqual(t, `{"kind":"card","last4":"example"}`, string(example))
+	// the example conforms to the struct its discriminator maps to
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(example))
+	decoder.UseNumber()
+	requireNoError(t, decoder.Decode(&value))
+	requireNoError(t, conforms(content.Schema, value, "body", spec.Components.Schemas))
+	value.(map[string]any)["kind"] = "cash"
+	if conforms(content.Schema, value, "body", spec.Components.Schemas) == nil {
+		t.Error("expected an unknown kind not to conform")
+	}
+
+	for _, tc := range []struct {
+		lang     string
+		zod      bool
+		post     PostProcessor
+		expected []string
+	}{
+		{"go:default", false, GoImports, []string{
+			"type OneOfCardBankAccount struct {\n\tCard        *Card\n\tBankAccount *BankAccount\n}",
+			"case \"bank\":\n\t\tu.BankAccount = new(BankAccount)",
+			"v.Kind = \"card\"",
+			"func (u *PaymentMethod) UnmarshalJSON(data []byte) error {",
+		}},
+		{"ts:default", false, nil, []string{
+			"export type OneOfCardBankAccount = Card | BankAccount\n",
+			"kind: 'card'\n",
+		}},
+		{"ts:default", true, nil, []string{
+			"export const OneOfCardBankAccountSchema = z.union([z.lazy(() => CardSchema), z.lazy(() => BankAccountSchema)])\n",
+			"kind: z.literal('bank'),\n",
+		}},
+	} {
+		t.Run(tc.lang, func(t *testing.T) {
+			gener, err := New(ClientDesc{TypeName: "Client", PackageName: "client", Zod: tc.zod}, router.Meta())
+			requireNoError(t, err)
+			buf := &bytes.Buffer{}
+			requireNoError(t, gener.GenerateWith(buf, tc.lang, tc.post))
+			for _, expected := range tc.expected {
+				if !strings.Contains(buf.String(), expected) {
+					t.Errorf("expected the client to contain %q", expected)
+				}
+			}
+		})
+	}
+
+	// only an output varies by kind
+	vel.RegisterPost(router, "addPaymentMethod", func(ctx context.Context, req vel.OneOf[Card, BankAccount]) (struct{}, *vel.Error) {
+		return struct{}{}, nil
+	})
+	if _, err := New(ClientDesc{TypeName: "Client", PackageName: "client"}, router.Meta()); err == nil {
+		t.Error("expected a OneOf input to fail")
+	}
+}
+
+// TestGoClientRoundTrip generates the client of testdata/roundtrip/api into a module using this one,
+// builds and vets it and runs the calls of testdata/roundtrip against the router in-process
+func TestGoClientRoundTrip(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip: builds a module")
+	}
+
+	root, err := filepath.Abs("..")
+	requireNoError(t, err)
+	dir := t.TempDir()
+	requireNoError(t, os.CopyFS(dir, os.DirFS("testdata/roundtrip")))
+	sum, err := os.ReadFile(filepath.Join(root, "go.sum"))
+	requireNoError(t, err)
+	requireNoError(t, os.WriteFile(filepath.Join(dir, "go.sum"), sum, 0644))
+	mod := "module roundtrip\n\ngo 1.24.0\n\nrequire github.com/dennypenta/vel v0.0.0\n\nreplace github.com/dennypenta/vel => " + root + "\n"
+	requireNoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte(mod), 0644))
+
+	for _, args := range [][]string{
+		{"run", "./generate"},
+		{"build", "./..."},
+		{"vet", "./..."},
+		{"test", "./..."},
+	} {
+		cmd := exec.Command("go", args...)
+		cmd.Dir = dir
+		// the requirements of this module are resolved from its go.sum
+		cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOWORK=off")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("go %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+}
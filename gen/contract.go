@@ -0,0 +1,363 @@
+package gen
+
+import (
+	"bytes"
+	"cmp"
+	_ "embed"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/dennypenta/vel"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed templates/contract.tpl
+var contractTemplate string
+
+var (
+	jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// ContractConfig configures the generated contract tests
+type ContractConfig struct {
+	// Package is the package of the test file, it must be the package of the router constructor,
+	// the test file is named after its directory by default
+	Package string `yaml:"package"`
+	// Constructor is the function of the package building the router, NewRouter by default
+	Constructor string `yaml:"constructor"`
+	// Headers are sent by every request, e.g. the credentials the middlewares require
+	Headers map[string]string `yaml:"headers"`
+}
+
+// ContractDesc is the data of the contract template, the strings are Go literals
+type ContractDesc struct {
+	Package     string
+	Constructor string
+	// Components holds the JSON schemas of the spec the responses refer to
+	Components string
+	// CodePath is the path of the error code in an error response, e.g. []string{"error", "code"}
+	CodePath string
+	Headers  []ContractHeader
+	Cases    []ContractCase
+}
+
+type ContractHeader struct {
+	Key   string
+	Value string
+}
+
+// ContractCase is a request of an operation, a valid request has no WantStatus
+type ContractCase struct {
+	Name    string
+	Method  string
+	Target  string
+	Body    string
+	Headers []ContractHeader
+	// WantStatus and WantCode are the error an invalid request is rejected with
+	WantStatus int
+	WantCode   string
+	// Stream is the content type of a streaming or a raw operation, its body isn't checked
+	Stream string
+	// Responses maps the documented statuses to the JSON schemas of their bodies, an empty schema means no body
+	Responses []ContractResponse
+}
+
+type ContractResponse struct {
+	Status int
+	Schema string
+}
+
+// GenerateContractTests writes a Go test serving the router with httptest and calling every operation
+// with a valid request and the invalid ones derived from the input type: a malformed body, a field of a wrong type
+// and the zero value rejected by its Validate method. A valid request is the first example of the spec
+// or the one built by Example. The responses of the documented statuses must conform to the schemas of the spec.
+func (g *ClientGen) GenerateContractTests(w io.Writer, config ContractConfig) error {
+	spec, err := g.GenerateOpenAPI("", "")
+	if err != nil {
+		return err
+	}
+	components, err := jsonLiteral(spec.Components.Schemas)
+	if err != nil {
+		return err
+	}
+	desc := ContractDesc{
+		Package:     config.Package,
+		Constructor: config.Constructor,
+		Components:  components,
+		CodePath:    codePathLiteral(g.meta.ErrorShape),
+	}
+	for _, key := range slices.Sorted(maps.Keys(config.Headers)) {
+		desc.Headers = append(desc.Headers, ContractHeader{Key: strconv.Quote(key), Value: strconv.Quote(config.Headers[key])})
+	}
+
+	for _, api := range g.meta.Apis {
+		if api.Superseded {
+			// the requests without a version are served by the latest one
+			continue
+		}
+		item := spec.Paths["/"+api.Path]
+		operation := item.Post
+		if api.Method == "GET" {
+			operation = item.Get
+		}
+		cases, err := contractCases(api, operation)
+		if err != nil {
+			return fmt.Errorf("%s: %w", api.OperationID, err)
+		}
+		desc.Cases = append(desc.Cases, cases...)
+	}
+
+	tpl, err := template.New("contract").Parse(contractTemplate)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, desc); err != nil {
+		return err
+	}
+	content, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to format the contract tests: %w", err)
+	}
+	_, err = w.Write(content)
+	return err
+}
+
+// contractCases lists the requests of the api, the invalid ones are left out if the input type can't produce them
+func contractCases(api ApiDesc, operation *OpenAPIOperation) ([]ContractCase, error) {
+	base := ContractCase{Method: api.Method}
+	if h := api.Spec.RequestHeaders; h.Key != "" && h.ValueExample != "" {
+		base.Headers = []ContractHeader{{Key: strconv.Quote(h.Key), Value: strconv.Quote(h.ValueExample)}}
+	}
+	if api.Spec.Stream != "" {
+		base.Stream = strconv.Quote(api.Spec.Stream.ContentType())
+	}
+	if api.RawOutput {
+		// a raw body isn't JSON, its content type is checked like the one of a stream
+		base.Stream = strconv.Quote(cmp.Or(api.Spec.ContentType, vel.OctetStream))
+	}
+	for _, status := range slices.Sorted(maps.Keys(operation.Responses)) {
+		code, err := strconv.Atoi(status)
+		if err != nil {
+			continue
+		}
+		schema := `""`
+		if content := operation.Responses[status].Content; content != nil && content.ApplicationJSON != nil {
+			if schema, err = jsonLiteral(content.ApplicationJSON.Schema); err != nil {
+				return nil, err
+			}
+		}
+		base.Responses = append(base.Responses, ContractResponse{Status: code, Schema: schema})
+	}
+
+	var cases []ContractCase
+	add := func(name string, input any, body string, status int, code string) error {
+		c := base
+		c.Name = strconv.Quote(api.Path + "/" + name)
+		c.WantStatus, c.WantCode = status, strconv.Quote(code)
+		target := "/" + api.Path
+		if input != nil && api.Method == "GET" {
+			target += contractQuery(api, input)
+		} else if input != nil {
+			data, err := marshalExample(input, "")
+			if err != nil {
+				return fmt.Errorf("failed to marshal the %s request: %w", name, err)
+			}
+			body = string(data)
+		}
+		c.Target = strconv.Quote(target)
+		if body != "" {
+			c.Body = strconv.Quote(body)
+		}
+		cases = append(cases, c)
+		return nil
+	}
+
+	example := exampleInput(api)
+	if example == nil || len(contractViolations(api, example)) == 0 {
+		if err := add("valid", example, "", 0, ""); err != nil {
+			return nil, err
+		}
+	}
+	// a raw body is passed as is, it can't be malformed
+	if api.Input.Name == "" || api.RawInput {
+		return cases, nil
+	}
+
+	if api.Method == "GET" {
+		if param, ok := wrongQueryParam(api); ok {
+			c := base
+			c.Name = strconv.Quote(api.Path + "/wrong type of " + param)
+			c.Target = strconv.Quote("/" + api.Path + "?" + url.QueryEscape(param) + "=x")
+			c.WantStatus, c.WantCode = 400, strconv.Quote("FAILED_DECODING_QUERY")
+			cases = append(cases, c)
+		}
+	} else {
+		if err := add("malformed body", nil, "{", 400, "FAILED_DECODING_REQUEST_BODY"); err != nil {
+			return nil, err
+		}
+		if field, value, ok := wrongBodyField(api); ok {
+			if err := add("wrong type of "+field, nil, fmt.Sprintf("{%q: %s}", field, value), 400, "FAILED_DECODING_REQUEST_BODY"); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if api.input != nil {
+		zero := reflect.Zero(api.input).Interface()
+		if len(contractViolations(api, zero)) > 0 {
+			if err := add("invalid", zero, "", 422, vel.ValidationFailedCode); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return cases, nil
+}
+
+// contractViolations validates the request the way the handler does, a request of another type isn't validated
+func contractViolations(api ApiDesc, request any) []vel.Violation {
+	if !api.Validated || reflect.TypeOf(request) != api.input {
+		return nil
+	}
+	value := reflect.New(api.input)
+	value.Elem().Set(reflect.ValueOf(request))
+	return value.Interface().(vel.Validator).Validate()
+}
+
+// contractQuery encodes the query of a GET request, a slice is sent as repeated parameters
+func contractQuery(api ApiDesc, input any) string {
+	query := url.Values{}
+	for _, param := range exampleQuery(api, input) {
+		query.Add(param.Key, param.Value)
+	}
+	if len(query) == 0 {
+		return ""
+	}
+	return "?" + query.Encode()
+}
+
+// wrongQueryParam finds a numeric or a boolean query parameter, x can't be decoded into it
+func wrongQueryParam(api ApiDesc) (string, bool) {
+	for _, param := range api.QueryParams {
+		t := param.Field.Type
+		if t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Slice {
+			t = t.Elem()
+		}
+		if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+			continue
+		}
+		switch t.Kind() {
+		case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+			return param.Key, true
+		}
+	}
+	return "", false
+}
+
+// wrongBodyField finds a field of the body and a JSON value of another type,
+// the fields decoding themselves are skipped since they may accept any value
+func wrongBodyField(api ApiDesc) (string, string, bool) {
+	for _, field := range api.Input.Fields {
+		t := field.Type
+		if t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if field.AsString || reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+			continue
+		}
+		switch t.Kind() {
+		case reflect.String:
+			return field.JsonName, "0", true
+		case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+			return field.JsonName, `"x"`, true
+		case reflect.Slice, reflect.Array:
+			return field.JsonName, "{}", true
+		case reflect.Map, reflect.Struct:
+			return field.JsonName, "[]", true
+		}
+	}
+	return "", "", false
+}
+
+// jsonLiteral converts a part of the spec to JSON quoted as a Go string
+func jsonLiteral(v any) (string, error) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	var value any
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return "", err
+	}
+	data, err = json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Quote(string(data)), nil
+}
+
+func codePathLiteral(shape ErrorShape) string {
+	path := errorCodePath(shape)
+	for i := range path {
+		path[i] = strconv.Quote(path[i])
+	}
+	return "[]string{" + strings.Join(path, ", ") + "}"
+}
+
+// errorCodePath is the path of the error code in an error response, e.g. error, code
+func errorCodePath(shape ErrorShape) []string {
+	if shape.Envelope != "" {
+		return []string{shape.Envelope, shape.CodeField}
+	}
+	return []string{shape.CodeField}
+}
+
+// GenerateContract generates the contract tests of the router and writes them to the provided writer
+func GenerateContract(router *vel.Router, w io.Writer, config ContractConfig) error {
+	generator, err := newRouterGen(router, ClientDesc{
+		TypeName:    "Client",
+		PackageName: "client",
+		ErrorSchema: router.ErrorEncoder().Schema(),
+	})
+	if err != nil {
+		return err
+	}
+	return generator.GenerateContractTests(w, config)
+}
+
+// GenerateContractToFile generates the contract tests to a file, e.g. internal/api/contract_test.go,
+// the package and the constructor default to the directory name and NewRouter
+func GenerateContractToFile(router *vel.Router, outputPath string, config ContractConfig) error {
+	if config.Package == "" {
+		config.Package = importPackageName(filepath.Dir(outputPath))
+	}
+	if config.Constructor == "" {
+		config.Constructor = "NewRouter"
+	}
+	var buf bytes.Buffer
+	if err := GenerateContract(router, &buf, config); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(outputPath, buf.Bytes(), 0644)
+}
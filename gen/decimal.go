@@ -0,0 +1,61 @@
+package gen
+
+import (
+	"encoding"
+	"math/big"
+	"reflect"
+	"strconv"
+
+	"github.com/dennypenta/vel"
+)
+
+// The numbers of arbitrary precision are described as encoding/json encodes them: a big.Int is a JSON number
+// the TS client rounds past 2^53, a vel.BigInt, a big.Float and a decimal are strings of the decimal format, so the clients don't round them.
+// The Go client keeps the types. The gen/decimal package registers shopspring decimal.Decimal.
+
+func init() {
+	RegisterTypeMapping(reflect.TypeFor[big.Int](), Mapping{
+		GoType:        "big.Int",
+		GoImport:      "math/big",
+		TSType:        "number",
+		ZodType:       "z.number().int()",
+		OpenAPISchema: &OpenAPISchema{Type: "integer"},
+	})
+	RegisterDecimalType(reflect.TypeFor[big.Float](), "big.Float", "math/big")
+	RegisterDecimalType(reflect.TypeFor[vel.BigInt](), "vel.BigInt", "github.com/dennypenta/vel")
+}
+
+// RegisterDecimalType teaches the generator a decimal type encoded as a JSON string, e.g. cockroachdb/apd,
+// the spec describes it as a string of the decimal format, the TS client as a string and the Go client keeps the type:
+//
+//	gen.RegisterDecimalType(reflect.TypeFor[apd.Decimal](), "apd.Decimal", "github.com/cockroachdb/apd/v3")
+//
+// big.Float and vel.BigInt are registered by default, the gen/decimal package registers shopspring decimal.Decimal.
+func RegisterDecimalType(t reflect.Type, goType, goImport string) {
+	RegisterTypeMapping(t, Mapping{
+		GoType:        goType,
+		GoImport:      goImport,
+		TSType:        "string",
+		ZodType:       "z.string()",
+		OpenAPISchema: &OpenAPISchema{Type: "string", Format: "decimal"},
+	})
+}
+
+// fillNumberExample sets a mapped number parsed from its text, e.g. a decimal or a big.Int, by the name of its field,
+// it reports false for any other type
+func fillNumberExample(v reflect.Value, name string) bool {
+	mapping, ok := mappingOf(v.Type())
+	if !ok || mapping.OpenAPISchema.Format != "decimal" && mapping.OpenAPISchema.Type != "integer" && mapping.OpenAPISchema.Type != "number" {
+		return false
+	}
+	unmarshaler, ok := v.Addr().Interface().(encoding.TextUnmarshaler)
+	if !ok {
+		return false
+	}
+	number := exampleNumber(name)
+	// an integer doesn't parse a fraction, e.g. a price, it's truncated
+	if unmarshaler.UnmarshalText([]byte(strconv.FormatFloat(number, 'f', -1, 64))) != nil {
+		_ = unmarshaler.UnmarshalText([]byte(strconv.FormatInt(int64(number), 10)))
+	}
+	return true
+}
@@ -0,0 +1,18 @@
+// Package decimal teaches the generator decimal.Decimal of github.com/shopspring/decimal, so gen doesn't depend on it.
+// The program generating the clients imports it for the side effect:
+//
+//	import _ "github.com/dennypenta/vel/gen/decimal"
+//
+// A decimal is a string of the decimal format in OpenAPI, a string in the TS client and keeps decimal.Decimal in the Go client.
+package decimal
+
+import (
+	"reflect"
+
+	"github.com/dennypenta/vel/gen"
+	"github.com/shopspring/decimal"
+)
+
+func init() {
+	gen.RegisterDecimalType(reflect.TypeFor[decimal.Decimal](), "decimal.Decimal", "github.com/shopspring/decimal")
+}
@@ -0,0 +1,78 @@
+package decimal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/dennypenta/vel"
+	"github.com/dennypenta/vel/gen"
+	"github.com/shopspring/decimal"
+)
+
+type Invoice struct {
+	Total    decimal.Decimal   `json:"total"`
+	Prices   []decimal.Decimal `json:"prices"`
+	Discount *decimal.Decimal  `json:"discount"`
+}
+
+func TestDecimalClient(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "bill", func(ctx context.Context, req Invoice) (Invoice, *vel.Error) {
+		return req, nil
+	})
+	gener, err := gen.New(gen.ClientDesc{TypeName: "Client", PackageName: "client", Examples: true}, router.Meta())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	properties := spec.Components.Schemas["Invoice"].Properties
+	if properties["total"].Type != "string" || properties["total"].Format != "decimal" || properties["prices"].Items.Format != "decimal" {
+		t.Errorf("expected the strings of the decimal format, got %+v", properties)
+	}
+	example, _ := json.Marshal(spec.Paths["/bill"].Post.RequestBody.Content.ApplicationJSON.Example)
+	if want := `{"discount":"42","prices":["19.99"],"total":"10"}`; string(example) != want {
+		t.Errorf("expected %s, got %s", want, example)
+	}
+
+	for _, tc := range []struct {
+		lang     string
+		zod      bool
+		expected []string
+	}{
+		{"go:default", false, []string{
+			"\t\"github.com/shopspring/decimal\"\n",
+			"Total decimal.Decimal `json:\"total\"`",
+			"Discount *decimal.Decimal `json:\"discount\"`",
+		}},
+		{"ts:default", false, []string{
+			"total: string\n",
+			"prices: string[]\n",
+		}},
+		{"ts:default", true, []string{
+			"total: z.string(),\n",
+		}},
+	} {
+		t.Run(tc.lang, func(t *testing.T) {
+			gener, err := gen.New(gen.ClientDesc{TypeName: "Client", PackageName: "client", Zod: tc.zod}, router.Meta())
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := &bytes.Buffer{}
+			if err := gener.GenerateWith(buf, tc.lang, nil); err != nil {
+				t.Fatal(err)
+			}
+			for _, expected := range tc.expected {
+				if !strings.Contains(buf.String(), expected) {
+					t.Errorf("expected the client to contain %q", expected)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package gen
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BreakingChangeKind classifies a breaking change found by DiffOpenAPI
+type BreakingChangeKind string
+
+const (
+	// RemovedOperation is an operation the new spec doesn't have
+	RemovedOperation BreakingChangeKind = "removed-operation"
+	// NarrowedEnum is an enum value of a request the new spec doesn't accept
+	NarrowedEnum BreakingChangeKind = "narrowed-enum"
+	// NewRequiredField is a request field or a parameter the new spec requires while the old one didn't
+	NewRequiredField BreakingChangeKind = "new-required-field"
+	// RemovedResponseField is a response field the new spec doesn't return
+	RemovedResponseField BreakingChangeKind = "removed-response-field"
+)
+
+// BreakingChange is a change of a spec breaking the clients of its previous version
+type BreakingChange struct {
+	Kind BreakingChangeKind
+	// Location is the operation followed by the path of the field, e.g. POST /createUser request.address.city,
+	// a response other than 200 is followed by its status, e.g. POST /createUser response 201.id
+	Location string
+	Message  string
+}
+
+func (c BreakingChange) String() string {
+	return fmt.Sprintf("%s: %s (%s)", c.Location, c.Message, c.Kind)
+}
+
+// DiffOpenAPI compares two versions of a spec and reports the changes breaking the existing clients:
+// removed operations, enum values a request doesn't accept anymore, newly required request fields and parameters
+// and removed response fields. Additions are compatible, as well as the changes of the schemas of removed operations.
+// The operations of every method are compared along with the bodies of every documented response status,
+// a success status the new spec doesn't document anymore removes its body, an error status doesn't break the clients.
+func DiffOpenAPI(old, new *OpenAPISpec) []BreakingChange {
+	d := &specDiff{old: old, new: new}
+	for _, path := range slices.Sorted(maps.Keys(old.Paths)) {
+		oldOperations, newOperations := pathOperations(old.Paths[path]), pathOperations(new.Paths[path])
+		for i, oldOperation := range oldOperations {
+			if oldOperation == nil {
+				continue
+			}
+			location := pathMethods[i] + " " + path
+			if newOperations[i] == nil {
+				d.add(RemovedOperation, location, "the operation is removed")
+				continue
+			}
+			d.operation(location, oldOperation, newOperations[i])
+		}
+	}
+	return d.changes
+}
+
+// DiffOpenAPIFiles compares two spec files, see DiffOpenAPI
+func DiffOpenAPIFiles(oldPath, newPath string) ([]BreakingChange, error) {
+	old, err := readOpenAPI(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	new, err := readOpenAPI(newPath)
+	if err != nil {
+		return nil, err
+	}
+	return DiffOpenAPI(old, new), nil
+}
+
+func readOpenAPI(path string) (*OpenAPISpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var spec OpenAPISpec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &spec, nil
+}
+
+// pathMethods are the methods of the operations returned by pathOperations
+var pathMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+
+// pathOperations returns the operations of the path in the order of pathMethods, nil for a missing one
+func pathOperations(item *OpenAPIPathItem) []*OpenAPIOperation {
+	if item == nil {
+		return make([]*OpenAPIOperation, len(pathMethods))
+	}
+	return []*OpenAPIOperation{item.Get, item.Post, item.Put, item.Patch, item.Delete}
+}
+
+// specDiff collects the breaking changes of the operations present in both specs
+type specDiff struct {
+	old, new *OpenAPISpec
+	changes  []BreakingChange
+}
+
+func (d *specDiff) add(kind BreakingChangeKind, location, message string) {
+	d.changes = append(d.changes, BreakingChange{Kind: kind, Location: location, Message: message})
+}
+
+func (d *specDiff) operation(location string, old, new *OpenAPIOperation) {
+	oldParams := make(map[string]*OpenAPIParameter)
+	for _, param := range old.Parameters {
+		oldParams[param.In+" "+param.Name] = param
+	}
+	for _, param := range new.Parameters {
+		oldParam, ok := oldParams[param.In+" "+param.Name]
+		where := location + " " + param.In + " " + param.Name
+		switch {
+		case !ok && param.Required:
+			d.add(NewRequiredField, where, "the new parameter is required")
+		case ok && param.Required && !oldParam.Required:
+			d.add(NewRequiredField, where, "the parameter became required")
+		case ok:
+			d.request(where, oldParam.Schema, param.Schema, nil)
+		}
+	}
+
+	if old.RequestBody != nil && new.RequestBody != nil {
+		d.request(location+" request", jsonSchema(old.RequestBody.Content), jsonSchema(new.RequestBody.Content), nil)
+	} else if old.RequestBody == nil && new.RequestBody != nil {
+		if schema := d.resolve(d.new, jsonSchema(new.RequestBody.Content)); schema != nil && len(schema.Required) > 0 {
+			d.add(NewRequiredField, location+" request", "the request body with required fields is new")
+		}
+	}
+
+	for _, status := range slices.Sorted(maps.Keys(old.Responses)) {
+		oldResponse := old.Responses[status]
+		if oldResponse == nil || oldResponse.Content == nil {
+			continue
+		}
+		newResponse, documented := new.Responses[status]
+		if !documented && !strings.HasPrefix(status, "2") {
+			// the clients don't get the error anymore
+			continue
+		}
+		var newSchema *OpenAPISchema
+		if newResponse != nil && newResponse.Content != nil {
+			newSchema = jsonSchema(newResponse.Content)
+		}
+		where := location + " response"
+		if status != "200" {
+			where += " " + status
+		}
+		d.response(where, jsonSchema(oldResponse.Content), newSchema, nil)
+	}
+}
+
+func jsonSchema(content *OpenAPIContent) *OpenAPISchema {
+	if content == nil {
+		return nil
+	}
+	for _, media := range []*OpenAPIMediaType{content.ApplicationJSON, content.TextEventStream, content.ApplicationNDJSON, content.ApplicationForm} {
+		if media != nil {
+			return media.Schema
+		}
+	}
+	return nil
+}
+
+// resolve follows the reference of the schema to the components of the spec
+func (d *specDiff) resolve(spec *OpenAPISpec, schema *OpenAPISchema) *OpenAPISchema {
+	if schema == nil || schema.Ref == "" {
+		return schema
+	}
+	if spec.Components == nil {
+		return nil
+	}
+	return spec.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+}
+
+// request compares the schemas of a request, seen holds the compared references to stop at recursive types
+func (d *specDiff) request(location string, old, new *OpenAPISchema, seen map[string]bool) {
+	if old == nil || new == nil || visited(&seen, old, new) {
+		return
+	}
+	old, new = d.resolve(d.old, old), d.resolve(d.new, new)
+	if old == nil || new == nil {
+		return
+	}
+
+	// a schema without an enum accepts any value
+	for _, value := range old.Enum {
+		if len(new.Enum) > 0 && !slices.ContainsFunc(new.Enum, func(v any) bool { return fmt.Sprint(v) == fmt.Sprint(value) }) {
+			d.add(NarrowedEnum, location, fmt.Sprintf("the value %v isn't accepted anymore", value))
+		}
+	}
+	for _, name := range new.Required {
+		if !slices.Contains(old.Required, name) {
+			if _, existed := old.Properties[name]; existed {
+				d.add(NewRequiredField, location+"."+name, "the field became required")
+			} else {
+				d.add(NewRequiredField, location+"."+name, "the new field is required")
+			}
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(old.Properties)) {
+		if property, ok := new.Properties[name]; ok {
+			d.request(location+"."+name, old.Properties[name], property, seen)
+		}
+	}
+	d.request(location+"[]", old.Items, new.Items, seen)
+	d.request(location+"{}", old.AdditionalProperties, new.AdditionalProperties, seen)
+}
+
+// response compares the schemas of a response, a nil new schema means the response has no body anymore
+func (d *specDiff) response(location string, old, new *OpenAPISchema, seen map[string]bool) {
+	if old == nil || visited(&seen, old, new) {
+		return
+	}
+	old, new = d.resolve(d.old, old), d.resolve(d.new, new)
+	if old == nil {
+		return
+	}
+	if new == nil {
+		if len(old.Properties) > 0 {
+			d.add(RemovedResponseField, location, "the response body is removed")
+		}
+		return
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(old.Properties)) {
+		property, ok := new.Properties[name]
+		if !ok {
+			d.add(RemovedResponseField, location+"."+name, "the field is removed")
+			continue
+		}
+		d.response(location+"."+name, old.Properties[name], property, seen)
+	}
+	if old.Items != nil && new.Items != nil {
+		d.response(location+"[]", old.Items, new.Items, seen)
+	}
+	if old.AdditionalProperties != nil && new.AdditionalProperties != nil {
+		d.response(location+"{}", old.AdditionalProperties, new.AdditionalProperties, seen)
+	}
+}
+
+// visited reports whether the pair of references is compared already, it allocates the set on the first reference
+func visited(seen *map[string]bool, old, new *OpenAPISchema) bool {
+	if old.Ref == "" {
+		return false
+	}
+	key := old.Ref
+	if new != nil {
+		key += " " + new.Ref
+	}
+	if *seen == nil {
+		*seen = make(map[string]bool)
+	} else {
+		*seen = maps.Clone(*seen)
+	}
+	if (*seen)[key] {
+		return true
+	}
+	(*seen)[key] = true
+	return false
+}
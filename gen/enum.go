@@ -0,0 +1,126 @@
+package gen
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dennypenta/vel"
+)
+
+// EnumKind is the underlying types of the enums RegisterEnum accepts
+type EnumKind interface {
+	~string | ~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64
+}
+
+var (
+	enumsMu sync.RWMutex
+	enums   = make(map[reflect.Type][]any)
+)
+
+// RegisterEnum declares the values of a defined string or integer type, usually the constants of its const block:
+//
+//	gen.RegisterEnum(StatusActive, StatusDisabled)
+//
+// OpenAPI lists the values as the enum of the type, TS declares the type as a union of the values
+// and the Go client declares a constant for every value. The registered values take precedence over vel.Enum.
+// It panics if no values are given, call it before generating, e.g. in init.
+func RegisterEnum[T EnumKind](values ...T) {
+	t := reflect.TypeFor[T]()
+	if t.PkgPath() == "" {
+		panic("enum must be a defined type, got " + t.String())
+	}
+	if len(values) == 0 {
+		panic("enum " + t.String() + " has no values")
+	}
+
+	registered := make([]any, len(values))
+	for i := range values {
+		registered[i] = values[i]
+	}
+	enumsMu.Lock()
+	defer enumsMu.Unlock()
+	enums[t] = registered
+	typeCache.forget(t)
+}
+
+// EnumValue is a value of an enum type
+type EnumValue struct {
+	// Const is the name of the constant declaring the value in the Go client, e.g. StatusActive
+	Const string
+	// Value is the string or the number the value is encoded to in JSON
+	Value any
+	// Literal is the value in the Go code, e.g. "active"
+	Literal string
+}
+
+// enumValues returns the values of the type registered by RegisterEnum or listed by vel.Enum
+func enumValues(t reflect.Type) []EnumValue {
+	enumsMu.RLock()
+	registered, ok := enums[t]
+	enumsMu.RUnlock()
+	if !ok && t.Kind() == reflect.String {
+		enum, implemented := reflect.Zero(t).Interface().(vel.Enum)
+		if !implemented {
+			enum, implemented = reflect.New(t).Interface().(vel.Enum)
+		}
+		if implemented {
+			for _, value := range enum.EnumValues() {
+				registered = append(registered, reflect.ValueOf(value).Convert(t).Interface())
+			}
+		}
+	}
+
+	values := make([]EnumValue, 0, len(registered))
+	consts := make(map[string]bool, len(registered))
+	for i, value := range registered {
+		v := reflect.ValueOf(value)
+		enumValue := EnumValue{}
+		label := ""
+		switch {
+		case v.Kind() == reflect.String:
+			enumValue.Value = v.String()
+			enumValue.Literal = strconv.Quote(v.String())
+			label = v.String()
+		case v.CanInt():
+			enumValue.Value = v.Int()
+			enumValue.Literal = strconv.FormatInt(v.Int(), 10)
+			label = strings.Replace(enumValue.Literal, "-", "Minus", 1)
+		default:
+			enumValue.Value = v.Uint()
+			enumValue.Literal = strconv.FormatUint(v.Uint(), 10)
+			label = enumValue.Literal
+		}
+		// integer enums usually have names, e.g. generated by stringer
+		if stringer, ok := value.(fmt.Stringer); ok && v.Kind() != reflect.String {
+			label = stringer.String()
+		}
+
+		enumValue.Const = typeName(t) + pascalCase(label)
+		if enumValue.Const == typeName(t) || consts[enumValue.Const] {
+			enumValue.Const = typeName(t) + strconv.Itoa(i)
+		}
+		consts[enumValue.Const] = true
+		values = append(values, enumValue)
+	}
+	return values
+}
+
+func pascalCase(s string) string {
+	words := splitWords(s)
+	for i := range words {
+		words[i] = Capitalize(words[i])
+	}
+	return strings.Join(words, "")
+}
+
+// stringValues lists the strings as the values of an OpenAPI enum
+func stringValues(values []string) []any {
+	result := make([]any, len(values))
+	for i := range values {
+		result[i] = values[i]
+	}
+	return result
+}
@@ -0,0 +1,528 @@
+package gen
+
+import (
+	"bytes"
+	"cmp"
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dennypenta/vel"
+)
+
+// exampleUUID is the value of every UUID and id string
+const exampleUUID = "3f2b8c4e-1a7d-4e9b-9c2a-5d6e7f8a9b0c"
+
+// exampleTime is the time of every example, a fixed one keeps the generated files stable
+var exampleTime = time.Date(2024, time.January, 15, 9, 30, 0, 0, time.UTC)
+
+// exampleStrings are the values of the string fields by the words of their names, the first match wins
+var exampleStrings = []struct {
+	words []string
+	value string
+}{
+	{[]string{"email", "mail"}, "jane.doe@example.com"},
+	{[]string{"url", "uri", "link", "website", "homepage"}, "https://example.com"},
+	{[]string{"avatar", "image", "photo", "picture"}, "https://example.com/image.png"},
+	{[]string{"phone", "mobile"}, "+14155550123"},
+	{[]string{"firstname", "givenname"}, "Jane"},
+	{[]string{"lastname", "surname", "familyname"}, "Doe"},
+	{[]string{"username", "login", "nickname", "handle"}, "jane.doe"},
+	{[]string{"password", "secret"}, "correct-horse-battery-staple"},
+	{[]string{"token", "apikey"}, "tok_5f2b8c4e1a7d"},
+	{[]string{"uuid", "guid"}, exampleUUID},
+	{[]string{"company", "organization", "organisation"}, "Acme Inc."},
+	{[]string{"name", "fullname"}, "Jane Doe"},
+	{[]string{"title", "subject", "headline"}, "Getting started"},
+	{[]string{"description", "summary", "comment", "message", "text", "body", "content", "note", "bio"}, "Lorem ipsum dolor sit amet."},
+	{[]string{"street", "address"}, "221B Baker Street"},
+	{[]string{"city"}, "London"},
+	{[]string{"zip", "zipcode", "postcode", "postalcode"}, "NW1 6XE"},
+	{[]string{"country"}, "GB"},
+	{[]string{"currency"}, "USD"},
+	{[]string{"language", "lang", "locale"}, "en"},
+	{[]string{"timezone", "tz"}, "Europe/London"},
+	{[]string{"color", "colour"}, "#3366ff"},
+	{[]string{"ip"}, "192.0.2.1"},
+	{[]string{"slug"}, "getting-started"},
+	{[]string{"tag", "label", "category"}, "news"},
+	{[]string{"code", "sku"}, "ABC123"},
+	{[]string{"version"}, "1.0.0"},
+	{[]string{"date", "day"}, "2024-01-15"},
+}
+
+// exampleNumbers are the values of the number fields by the words of their names, the first match wins
+var exampleNumbers = []struct {
+	words []string
+	value float64
+}{
+	{[]string{"age"}, 30},
+	{[]string{"year"}, 2024},
+	{[]string{"month"}, 1},
+	{[]string{"page", "version"}, 1},
+	{[]string{"limit", "size", "count", "quantity", "qty", "total"}, 10},
+	{[]string{"offset", "skip"}, 0},
+	{[]string{"price", "amount", "cost", "balance"}, 19.99},
+	{[]string{"port"}, 8080},
+	{[]string{"latitude", "lat"}, 51.5237},
+	{[]string{"longitude", "lng", "lon"}, -0.1585},
+	{[]string{"rating", "score"}, 4.5},
+	{[]string{"percent", "percentage"}, 50},
+}
+
+// Example builds a realistic value of the type: the fields are filled by their names, e.g. an email or a city,
+// an enum gets its first value and a time is a fixed one, so the examples are the same on every run.
+// A string field tagged by redact:"true" is vel.Redacted, see vel.IsRedacted.
+// A vel.OneOf holds its first struct, a discriminator field is set to its value.
+// An input implementing vel.Validator is adjusted to the violations it reports, e.g. a string is padded
+// to its min_len and a number is raised to its min, so the example passes the validation of its rules.
+// It describes the requests of the OpenAPI spec, the Postman collection and the contract tests.
+func Example(t reflect.Type) any {
+	v := reflect.New(t).Elem()
+	fillExample(v, "", map[reflect.Type]bool{})
+	satisfyValidation(v)
+	return v.Interface()
+}
+
+// ExampleOf builds an example of the type to use as a test fixture, see Example
+func ExampleOf[T any]() T {
+	return Example(reflect.TypeFor[T]()).(T)
+}
+
+// fillExample sets the value by its type and the name of its field, filling stops at a type already being filled
+func fillExample(v reflect.Value, name string, filling map[reflect.Type]bool) {
+	t := v.Type()
+	if holdsAnyJSON(t) || t.Kind() == reflect.Pointer && holdsAnyJSON(t.Elem()) {
+		return
+	}
+	if values := enumValues(t); len(values) > 0 {
+		v.Set(reflect.ValueOf(values[0].Value).Convert(t))
+		return
+	}
+	if number, ok := protoEnumExample(t); ok {
+		v.SetInt(int64(number))
+		return
+	}
+	if fillProtoExample(v) {
+		return
+	}
+	if vel.IsUUID(t) {
+		_ = v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(exampleUUID))
+		return
+	}
+	switch t {
+	case reflect.TypeFor[time.Time]():
+		v.Set(reflect.ValueOf(exampleTime))
+		return
+	case reflect.TypeFor[time.Duration]():
+		v.SetInt(int64(5 * time.Minute))
+		return
+	}
+	if fillNumberExample(v, name) {
+		return
+	}
+	if vel.IsOneOf(t) {
+		fillUnionExample(v, name, filling)
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		if filling[t.Elem()] {
+			return
+		}
+		elem := reflect.New(t.Elem())
+		fillExample(elem.Elem(), name, filling)
+		v.Set(elem)
+	case reflect.Struct:
+		if filling[t] {
+			return
+		}
+		filling[t] = true
+		defer delete(filling, t)
+		for i := range t.NumField() {
+			field := t.Field(i)
+			if !field.IsExported() || jsonTag(field) == "-" {
+				continue
+			}
+			if vel.IsRedacted(field) {
+				// a secret isn't shown even as an example, a string says it's redacted and anything else is left zero
+				if field.Type.Kind() == reflect.String {
+					v.Field(i).SetString(vel.Redacted)
+				}
+				continue
+			}
+			if value, ok := field.Tag.Lookup("discriminator"); ok && field.Type.Kind() == reflect.String {
+				v.Field(i).SetString(value)
+				continue
+			}
+			fillExample(v.Field(i), exampleFieldName(field), filling)
+		}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			v.SetBytes([]byte("example"))
+			return
+		}
+		if filling[t.Elem()] || t.Elem().Kind() == reflect.Pointer && filling[t.Elem().Elem()] {
+			// a tree ends with its leaves
+			return
+		}
+		items := reflect.MakeSlice(t, 1, 1)
+		fillExample(items.Index(0), name, filling)
+		v.Set(items)
+	case reflect.Array:
+		for i := range v.Len() {
+			fillExample(v.Index(i), name, filling)
+		}
+	case reflect.Map:
+		if filling[t.Elem()] {
+			return
+		}
+		key := reflect.New(t.Key()).Elem()
+		if t.Key().Kind() == reflect.String {
+			key.SetString("key")
+		} else {
+			fillExample(key, name, filling)
+		}
+		value := reflect.New(t.Elem()).Elem()
+		fillExample(value, name, filling)
+		m := reflect.MakeMapWithSize(t, 1)
+		m.SetMapIndex(key, value)
+		v.Set(m)
+	case reflect.String:
+		v.SetString(exampleString(name))
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(exampleNumber(name)))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(exampleNumber(name)))
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(exampleNumber(name))
+	}
+}
+
+// exampleFieldName is the name of the field in JSON or in the query, e.g. user_id
+func exampleFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(jsonTag(field), ",")
+	query, _, _ := strings.Cut(field.Tag.Get("schema"), ",")
+	return cmp.Or(name, query, field.Name)
+}
+
+func exampleString(name string) string {
+	if isIDName(name) {
+		return exampleUUID
+	}
+	words := exampleWords(name)
+	for _, example := range exampleStrings {
+		if matchesWords(words, example.words) {
+			return example.value
+		}
+	}
+	return "example"
+}
+
+func exampleNumber(name string) float64 {
+	words := exampleWords(name)
+	for _, example := range exampleNumbers {
+		if matchesWords(words, example.words) {
+			return example.value
+		}
+	}
+	if isIDName(name) {
+		return 1
+	}
+	return 42
+}
+
+// isIDName reports whether the field is an identifier, e.g. id or userId
+func isIDName(name string) bool {
+	words := splitWords(name)
+	return len(words) > 0 && words[len(words)-1] == "id"
+}
+
+// exampleWords splits a field name to lower case words, the joined words match too, e.g. first_name is firstname
+func exampleWords(name string) []string {
+	words := splitWords(name)
+	if len(words) > 1 {
+		words = append(words, strings.Join(words, ""))
+	}
+	return words
+}
+
+func matchesWords(words, candidates []string) bool {
+	for _, word := range words {
+		for _, candidate := range candidates {
+			if word == candidate || word == candidate+"s" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// satisfyValidation fixes the fields of a validated value by its violations until it passes
+// or a violation can't be fixed, e.g. a custom rule
+func satisfyValidation(v reflect.Value) {
+	validator, ok := v.Addr().Interface().(vel.Validator)
+	if !ok {
+		return
+	}
+	for range 10 {
+		violations := validator.Validate()
+		fixed := false
+		for _, violation := range violations {
+			if field, ok := fieldByPath(v, violation.Field); ok && fixViolation(field, violation) {
+				fixed = true
+			}
+		}
+		if !fixed {
+			return
+		}
+	}
+}
+
+// fieldByPath finds the field a violation is reported for, e.g. address.city
+func fieldByPath(v reflect.Value, path string) (reflect.Value, bool) {
+	if path == "" {
+		return reflect.Value{}, false
+	}
+	for name := range strings.SplitSeq(path, ".") {
+		for v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, false
+		}
+		found := false
+		for _, field := range reflect.VisibleFields(v.Type()) {
+			if field.IsExported() && !field.Anonymous && exampleFieldName(field) == name {
+				v, found = v.FieldByIndex(field.Index), true
+				break
+			}
+		}
+		if !found {
+			return reflect.Value{}, false
+		}
+	}
+	return v, v.CanSet()
+}
+
+// fixViolation changes the field to follow the rule, it reports whether the field changed
+func fixViolation(field reflect.Value, violation vel.Violation) bool {
+	for field.Kind() == reflect.Pointer {
+		if field.IsNil() {
+			field.Set(reflect.New(field.Type().Elem()))
+		}
+		field = field.Elem()
+	}
+	param := func(key string) (float64, bool) {
+		n, err := strconv.ParseFloat(violation.Params[key], 64)
+		return n, err == nil
+	}
+
+	switch violation.Rule {
+	case vel.RuleRequired:
+		if !field.IsZero() {
+			return false
+		}
+		fillExample(field, violation.Field, map[reflect.Type]bool{})
+		return !field.IsZero()
+	case vel.RuleMinLen:
+		n, ok := param("min")
+		if !ok {
+			return false
+		}
+		return setLen(field, int(n), true)
+	case vel.RuleMaxLen:
+		n, ok := param("max")
+		if !ok {
+			return false
+		}
+		return setLen(field, int(n), false)
+	case vel.RuleMin:
+		n, ok := param("min")
+		return ok && setNumber(field, n)
+	case vel.RuleMax:
+		n, ok := param("max")
+		return ok && setNumber(field, n)
+	case vel.RuleEnum:
+		first, _, _ := strings.Cut(violation.Params["values"], ",")
+		if first == "" {
+			return false
+		}
+		if field.Kind() == reflect.String {
+			if field.String() == first {
+				return false
+			}
+			field.SetString(first)
+			return true
+		}
+		n, err := strconv.ParseFloat(first, 64)
+		return err == nil && setNumber(field, n)
+	}
+	return false
+}
+
+// setLen grows the string or the slice to at least n or cuts it to at most n
+func setLen(field reflect.Value, n int, grow bool) bool {
+	switch field.Kind() {
+	case reflect.String:
+		s := field.String()
+		length := utf8.RuneCountInString(s)
+		switch {
+		case grow && length < n:
+			field.SetString(s + strings.Repeat("x", n-length))
+		case !grow && length > n:
+			field.SetString(string([]rune(s)[:n]))
+		default:
+			return false
+		}
+		return true
+	case reflect.Slice:
+		switch {
+		case grow && field.Len() < n:
+			item := reflect.New(field.Type().Elem()).Elem()
+			if field.Len() > 0 {
+				item = field.Index(0)
+			}
+			for field.Len() < n {
+				field.Set(reflect.Append(field, item))
+			}
+		case !grow && field.Len() > n:
+			field.Set(field.Slice(0, n))
+		default:
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// setNumber sets the number field to n, it reports whether the field changed
+func setNumber(field reflect.Value, n float64) bool {
+	switch {
+	case field.CanInt():
+		if field.Int() == int64(n) {
+			return false
+		}
+		field.SetInt(int64(n))
+	case field.CanUint():
+		if n < 0 || field.Uint() == uint64(n) {
+			return false
+		}
+		field.SetUint(uint64(n))
+	case field.CanFloat():
+		if field.Float() == n {
+			return false
+		}
+		field.SetFloat(n)
+	default:
+		return false
+	}
+	return true
+}
+
+// addExamples attaches the example request and response of the api to its operation
+func addExamples(operation *OpenAPIOperation, api ApiDesc) error {
+	input := exampleInput(api)
+	if api.Method == "GET" {
+		values := make(map[string][]string)
+		for _, param := range exampleQuery(api, input) {
+			values[param.Key] = append(values[param.Key], param.Value)
+		}
+		for _, param := range operation.Parameters {
+			value, ok := values[param.Name]
+			if !ok || param.In != "query" {
+				continue
+			}
+			// a repeated parameter is an array
+			if param.Schema != nil && param.Schema.Type == "array" {
+				param.Example = value
+			} else {
+				param.Example = value[0]
+			}
+		}
+	} else if operation.RequestBody != nil && input != nil && api.Form {
+		values := make(map[string]string)
+		for _, param := range exampleQuery(api, input) {
+			values[param.Key] = param.Value
+		}
+		operation.RequestBody.Content.ApplicationForm.Example = values
+	} else if operation.RequestBody != nil && input != nil {
+		example, err := exampleJSON(input)
+		if err != nil {
+			return err
+		}
+		if api.InputItems {
+			operation.RequestBody.Content.ApplicationNDJSON.Example = example
+		} else {
+			operation.RequestBody.Content.ApplicationJSON.Example = example
+		}
+	}
+
+	content := operation.Responses["200"].Content
+	if content == nil || api.output == nil {
+		return nil
+	}
+	example, err := exampleJSON(Example(api.output))
+	if err != nil {
+		return err
+	}
+	for _, media := range []*OpenAPIMediaType{content.ApplicationJSON, content.TextEventStream, content.ApplicationNDJSON} {
+		if media != nil {
+			media.Example = example
+		}
+	}
+	return nil
+}
+
+// exampleJSON converts an example to its JSON form, so YAML writes the fields by their JSON names
+func exampleJSON(example any) (any, error) {
+	data, err := marshalExample(example, "")
+	if err != nil {
+		return nil, err
+	}
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// holdsAnyJSON reports whether the type is mapped as json.RawMessage, e.g. a protobuf Any or Struct, an example leaves it out
+func holdsAnyJSON(t reflect.Type) bool {
+	mapping, ok := mappingOf(t)
+	return ok && mapping.GoType == "json.RawMessage"
+}
+
+// marshalExample encodes the example as the handler decodes it, a protobuf message by protojson,
+// indented by indent unless it's empty
+func marshalExample(example any, indent string) ([]byte, error) {
+	message, ok := protoMessage(example)
+	if !ok {
+		if indent == "" {
+			return json.Marshal(example)
+		}
+		return json.MarshalIndent(example, "", indent)
+	}
+	data, err := registeredProtoTypes().Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	// protojson varies its spaces on purpose, the examples are stable
+	var buf bytes.Buffer
+	if indent == "" {
+		err = json.Compact(&buf, data)
+	} else {
+		err = json.Indent(&buf, data, "", indent)
+	}
+	return buf.Bytes(), err
+}
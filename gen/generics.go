@@ -0,0 +1,99 @@
+package gen
+
+import (
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// typeName names a defined type in the generated code, an instantiated generic type gets the names
+// of its type arguments appended, e.g. Page[User] becomes PageUser and Result[[]Order] becomes ResultOrderList,
+// so every instantiation is declared as a concrete type
+func typeName(t reflect.Type) string {
+	return mangleTypeName(t.Name())
+}
+
+// mangleTypeName mangles a type name as reflect prints it, the type arguments are qualified by the package paths,
+// e.g. Page[github.com/me/app.User]
+func mangleTypeName(name string) string {
+	base, args, generic := strings.Cut(name, "[")
+	if !generic {
+		return name
+	}
+	var sb strings.Builder
+	sb.WriteString(base)
+	for _, arg := range splitTypeArgs(strings.TrimSuffix(args, "]")) {
+		sb.WriteString(mangleTypeArg(arg))
+	}
+	return sb.String()
+}
+
+// mangleTypeArg names a type argument: a package is dropped, slices, maps and pointers are named by their elements,
+// e.g. []*app.User is UserPtrList and map[string]int is StringIntMap,
+// so Page[User] and Page[*User] holding the optional users don't collide
+func mangleTypeArg(arg string) string {
+	switch {
+	case strings.HasPrefix(arg, "[]"):
+		return mangleTypeArg(arg[2:]) + "List"
+	case strings.HasPrefix(arg, "*"):
+		return mangleTypeArg(arg[1:]) + "Ptr"
+	case strings.HasPrefix(arg, "map["):
+		key, elem := splitMapType(arg)
+		return mangleTypeArg(key) + mangleTypeArg(elem) + "Map"
+	}
+
+	// the package path ends by the last dot before the type arguments
+	qualified, _, _ := strings.Cut(arg, "[")
+	if i := strings.LastIndex(qualified, "."); i >= 0 {
+		arg = arg[i+1:]
+	}
+	name := mangleTypeName(arg)
+	// anonymous types like struct{} or interface {} keep the letters only
+	name = strings.Map(func(r rune) rune {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, name)
+	if name == "" {
+		return ""
+	}
+	return Capitalize(name)
+}
+
+// splitTypeArgs splits the type arguments by the commas outside of brackets
+func splitTypeArgs(args string) []string {
+	var result []string
+	depth, start := 0, 0
+	for i, r := range args {
+		switch r {
+		case '[', '{', '(':
+			depth++
+		case ']', '}', ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				result = append(result, args[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(result, args[start:])
+}
+
+// splitMapType returns the key and the element of a map type, e.g. map[string][]int
+func splitMapType(m string) (string, string) {
+	depth := 0
+	for i, r := range m {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return m[len("map["):i], m[i+1:]
+			}
+		}
+	}
+	return m, ""
+}
@@ -0,0 +1,211 @@
+// Package gentest guards the generated clients and specs of a router with golden files:
+//
+//	func TestSDK(t *testing.T) {
+//		gentest.AssertGolden(t, api.NewRouter(),
+//			gentest.Client(gen.ClientGeneratorConfig{Language: "go", TypeName: "Client", PackageName: "client", OutputDir: "../../sdk"}),
+//			gentest.OpenAPI("../../openapi.yaml", gen.OpenAPIConfig{Title: "My API", Version: "1.0.0"}),
+//		)
+//	}
+//
+// A changed handler fails the test until the artifacts are regenerated, go test -update rewrites the golden files.
+package gentest
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dennypenta/vel"
+	"github.com/dennypenta/vel/gen"
+)
+
+// Update rewrites the golden files instead of comparing them, it's the -update flag of the test binary,
+// tests keeping golden files of their own may read it instead of declaring the flag again
+var Update = flag.Bool("update", false, "rewrite the golden files instead of comparing them")
+
+// Target generates artifacts of a router, the files are keyed by the paths of their golden files
+type Target func(router *vel.Router) (map[string][]byte, error)
+
+// Client generates a client to its output directory, a multi-file client has a golden file per file
+func Client(config gen.ClientGeneratorConfig) Target {
+	return func(router *vel.Router) (map[string][]byte, error) {
+		tmp, err := os.MkdirTemp("", "gentest")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmp)
+
+		generated := config
+		generated.OutputDir, generated.SkipUnchanged = tmp, false
+		if err := gen.GenerateClientToFile(router, generated); err != nil {
+			return nil, err
+		}
+		entries, err := os.ReadDir(tmp)
+		if err != nil {
+			return nil, err
+		}
+		files := make(map[string][]byte, len(entries))
+		for _, entry := range entries {
+			if files[filepath.Join(config.OutputDir, entry.Name())], err = os.ReadFile(filepath.Join(tmp, entry.Name())); err != nil {
+				return nil, err
+			}
+		}
+		return files, nil
+	}
+}
+
+// OpenAPI generates the spec to the golden file
+func OpenAPI(golden string, config gen.OpenAPIConfig) Target {
+	return File(golden, func(router *vel.Router, w io.Writer) error {
+		return gen.GenerateOpenAPIWithConfig(router, w, config)
+	})
+}
+
+// Postman generates the collection to the golden file
+func Postman(golden string, config gen.PostmanConfig) Target {
+	return File(golden, func(router *vel.Router, w io.Writer) error {
+		return gen.GeneratePostman(router, w, config)
+	})
+}
+
+// Contract generates the contract tests to the golden file, the package defaults to its directory name
+func Contract(golden string, config gen.ContractConfig) Target {
+	return func(router *vel.Router) (map[string][]byte, error) {
+		abs, err := filepath.Abs(golden)
+		if err != nil {
+			return nil, err
+		}
+		tmp, err := os.MkdirTemp("", "gentest")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmp)
+
+		// the file keeps the directory name its package is named after
+		path := filepath.Join(tmp, filepath.Base(filepath.Dir(abs)), filepath.Base(abs))
+		if err := gen.GenerateContractToFile(router, path, config); err != nil {
+			return nil, err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]byte{golden: content}, nil
+	}
+}
+
+// JSON generates the JSON methods of the handler types to the golden file
+func JSON(golden string, config gen.JSONConfig) Target {
+	return File(golden, func(router *vel.Router, w io.Writer) error {
+		return gen.GenerateJSON(router, w, config)
+	})
+}
+
+// File generates a single artifact to the golden file, e.g. the header constants
+func File(golden string, generate func(router *vel.Router, w io.Writer) error) Target {
+	return func(router *vel.Router) (map[string][]byte, error) {
+		var buf bytes.Buffer
+		if err := generate(router, &buf); err != nil {
+			return nil, err
+		}
+		return map[string][]byte{golden: buf.Bytes()}, nil
+	}
+}
+
+// FromConfig lists the targets declared in the config file of the vel command, so a test checks the generated files are up to date.
+// The relative outputs are resolved against the directory of the config file.
+func FromConfig(path string) ([]Target, error) {
+	config, err := gen.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	resolve := func(output string) string {
+		if filepath.IsAbs(output) {
+			return output
+		}
+		return filepath.Join(filepath.Dir(path), output)
+	}
+
+	var targets []Target
+	for _, client := range config.Clients {
+		client.OutputDir = resolve(client.OutputDir)
+		targets = append(targets, Client(client))
+	}
+	if config.OpenAPI.Output != "" {
+		targets = append(targets, OpenAPI(resolve(config.OpenAPI.Output), config.OpenAPI.OpenAPIConfig))
+	}
+	if config.Postman.Output != "" {
+		targets = append(targets, Postman(resolve(config.Postman.Output), config.Postman.PostmanConfig))
+	}
+	if config.Contract.Output != "" {
+		targets = append(targets, Contract(resolve(config.Contract.Output), config.Contract.ContractConfig))
+	}
+	if config.JSON.Output != "" {
+		targets = append(targets, JSON(resolve(config.JSON.Output), config.JSON.JSONConfig))
+	}
+	return targets, nil
+}
+
+// AssertGolden generates the targets and compares them with their golden files, the test fails on the first different line
+// of every outdated file. With -update the golden files are written instead.
+func AssertGolden(t testing.TB, router *vel.Router, targets ...Target) {
+	t.Helper()
+	for _, target := range targets {
+		files, err := target(router)
+		if err != nil {
+			t.Fatalf("failed to generate: %v", err)
+		}
+		for _, path := range slices.Sorted(maps.Keys(files)) {
+			if *Update {
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, files[path], 0644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+
+			golden, err := os.ReadFile(path)
+			if os.IsNotExist(err) {
+				t.Errorf("%s doesn't exist, run the test with -update to create it", path)
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := firstDiff(golden, files[path]); diff != "" {
+				t.Errorf("%s is outdated, run the test with -update to regenerate it\n%s", path, diff)
+			}
+		}
+	}
+}
+
+// firstDiff describes the first different line, empty if the contents are the same
+func firstDiff(golden, generated []byte) string {
+	if bytes.Equal(golden, generated) {
+		return ""
+	}
+	goldenLines := strings.Split(string(golden), "\n")
+	generatedLines := strings.Split(string(generated), "\n")
+	for i := range max(len(goldenLines), len(generatedLines)) {
+		var want, got string
+		if i < len(goldenLines) {
+			want = goldenLines[i]
+		}
+		if i < len(generatedLines) {
+			got = generatedLines[i]
+		}
+		if want != got || i >= len(goldenLines) || i >= len(generatedLines) {
+			return fmt.Sprintf("line %d:\n  golden:    %q\n  generated: %q", i+1, want, got)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,142 @@
+package gentest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dennypenta/vel"
+	"github.com/dennypenta/vel/gen"
+)
+
+type helloRequest struct {
+	Name string `json:"name"`
+}
+
+type helloResponse struct {
+	Message string `json:"message"`
+}
+
+func testRouter(operations ...string) *vel.Router {
+	router := vel.NewRouter()
+	for _, operation := range operations {
+		vel.RegisterPost(router, operation, func(ctx context.Context, req helloRequest) (helloResponse, *vel.Error) {
+			return helloResponse{Message: "hello " + req.Name}, nil
+		})
+	}
+	return router
+}
+
+// recorder collects the failures instead of failing the test
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func withUpdate(t *testing.T) {
+	prev := *Update
+	*Update = true
+	t.Cleanup(func() { *Update = prev })
+}
+
+func TestAssertGolden(t *testing.T) {
+	dir := t.TempDir()
+	targets := []Target{
+		Client(gen.ClientGeneratorConfig{Language: "go", TypeName: "Client", PackageName: "client", OutputDir: filepath.Join(dir, "sdk"), PostProcess: "goimports"}),
+		Client(gen.ClientGeneratorConfig{Language: "go", TypeName: "Client", PackageName: "client", OutputDir: filepath.Join(dir, "multi"), MultiFile: true}),
+		OpenAPI(filepath.Join(dir, "openapi.yaml"), gen.OpenAPIConfig{Title: "Test API", Version: "1.0.0"}),
+	}
+
+	r := &recorder{TB: t}
+	AssertGolden(r, testRouter("hello"), targets...)
+	if len(r.errors) != 5 || !strings.Contains(r.errors[0], "run the test with -update to create it") {
+		t.Fatalf("expected the missing golden files to fail, got %v", r.errors)
+	}
+
+	t.Run("update", func(t *testing.T) {
+		withUpdate(t)
+		AssertGolden(t, testRouter("hello"), targets...)
+	})
+	for _, path := range []string{"sdk/client.go", "multi/client.go", "multi/types.go", "multi/errors.go", "openapi.yaml"} {
+		if _, err := os.Stat(filepath.Join(dir, path)); err != nil {
+			t.Errorf("expected %s to be written: %v", path, err)
+		}
+	}
+	AssertGolden(t, testRouter("hello"), targets...)
+
+	r = &recorder{TB: t}
+	AssertGolden(r, testRouter("hello", "bye"), targets...)
+	// the types of the multi-file client are the same
+	if len(r.errors) != 3 {
+		t.Fatalf("expected the changed files to fail, got %v", r.errors)
+	}
+	if !strings.Contains(r.errors[0], filepath.Join(dir, "sdk", "client.go")+" is outdated") || !strings.Contains(r.errors[0], "line ") {
+		t.Errorf("unexpected failure %s", r.errors[0])
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	dir := t.TempDir()
+	config := `router: ./api.NewRouter
+clients:
+  - language: go
+    outputDir: ./sdk
+openapi:
+  output: ./openapi.yaml
+contract:
+  output: ./api/contract_test.go
+`
+	path := filepath.Join(dir, "vel.yaml")
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	targets, err := FromConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 3 {
+		t.Fatalf("expected 3 targets, got %d", len(targets))
+	}
+
+	withUpdate(t)
+	AssertGolden(t, testRouter("hello"), targets...)
+	contract, err := os.ReadFile(filepath.Join(dir, "api", "contract_test.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(contract), "package api\n") || !strings.Contains(string(contract), "NewRouter().Mux()") {
+		t.Errorf("unexpected contract tests:\n%s", contract)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sdk", "client.go")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFirstDiff(t *testing.T) {
+	tests := []struct {
+		name      string
+		golden    string
+		generated string
+		expected  string
+	}{
+		{name: "same", golden: "a\nb\n", generated: "a\nb\n", expected: ""},
+		{name: "changed line", golden: "a\nb\n", generated: "a\nc\n", expected: "line 2:\n  golden:    \"b\"\n  generated: \"c\""},
+		{name: "added line", golden: "a\n", generated: "a\nb\n", expected: "line 2:\n  golden:    \"\"\n  generated: \"b\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := firstDiff([]byte(tt.golden), []byte(tt.generated)); diff != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, diff)
+			}
+		})
+	}
+}
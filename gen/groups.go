@@ -0,0 +1,134 @@
+package gen
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/dennypenta/vel"
+)
+
+// GroupDesc is a sub-client of a subrouter, e.g. client.V1 calls the handlers of the v1 subrouter
+type GroupDesc struct {
+	// Name is the field of the parent client
+	Name string
+	// TypeName is the client type name followed by the names of the group path, e.g. ClientV1Admin
+	TypeName string
+	// Parent is the type name of the parent client
+	Parent string
+	// Access is the field path from the client, e.g. V1.Admin
+	Access string
+}
+
+// Receivers lists the type names of the client and its sub-clients
+func (d ApiClientDesc) Receivers() []string {
+	receivers := []string{d.Client.TypeName}
+	for _, group := range d.Groups {
+		receivers = append(receivers, group.TypeName)
+	}
+	return receivers
+}
+
+// ApisOf returns the apis called by the client or the sub-client of the type
+func (d ApiClientDesc) ApisOf(receiver string) []ApiDesc {
+	var apis []ApiDesc
+	for _, api := range d.Apis {
+		if api.Receiver == receiver {
+			apis = append(apis, api)
+		}
+	}
+	return apis
+}
+
+// GroupsOf returns the sub-clients of the client or the sub-client of the type
+func (d ApiClientDesc) GroupsOf(parent string) []GroupDesc {
+	var groups []GroupDesc
+	for _, group := range d.Groups {
+		if group.Parent == parent {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// routerRoutes holds the handlers of a router and its subrouters
+type routerRoutes struct {
+	meta []vel.HandlerMeta
+	// paths holds the path of every handler relative to the router
+	paths []string
+	// metaGroups holds the group of every handler
+	metaGroups [][]string
+	// groups lists the groups of the subrouters, a parent goes before its children
+	groups [][]string
+}
+
+// routerApis describes the handlers of the router and its subrouters,
+// the handlers of a subrouter are grouped by its prefix
+func routerApis(router *vel.Router) ([]vel.HandlerMeta, []ApiDesc, [][]string, error) {
+	routes := &routerRoutes{}
+	routes.walk(router, router.Prefix(), nil)
+
+	apis, err := extractApis(routes.meta)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for i := range apis {
+		apis[i].Path = routes.paths[i]
+		apis[i].Group = routes.metaGroups[i]
+		if path := routes.meta[i].OperationsPath(); path != "" {
+			apis[i].OperationsPath = strings.TrimPrefix(strings.TrimPrefix(path, router.Prefix()), "/")
+		}
+	}
+	return routes.meta, apis, routes.groups, nil
+}
+
+func (rr *routerRoutes) walk(r *vel.Router, root string, group []string) {
+	for _, meta := range r.Meta() {
+		rr.meta = append(rr.meta, meta)
+		rr.paths = append(rr.paths, strings.TrimPrefix(strings.TrimPrefix(meta.Path, root), "/"))
+		rr.metaGroups = append(rr.metaGroups, group)
+	}
+	for _, sub := range r.Subrouters() {
+		subGroup := group
+		// a subrouter without a prefix keeps the handlers in the parent group
+		if name := groupName(strings.TrimPrefix(sub.Prefix(), r.Prefix())); name != "" {
+			subGroup = append(slices.Clone(group), name)
+			if !slices.ContainsFunc(rr.groups, func(g []string) bool { return slices.Equal(g, subGroup) }) {
+				rr.groups = append(rr.groups, subGroup)
+			}
+		}
+		rr.walk(sub, root, subGroup)
+	}
+}
+
+// groupName converts a subrouter prefix to a field name, e.g. /api/v2 becomes ApiV2
+func groupName(prefix string) string {
+	parts := strings.FieldsFunc(prefix, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var b strings.Builder
+	for _, part := range parts {
+		b.WriteString(Capitalize(part))
+	}
+	return b.String()
+}
+
+// makeGroupDescs describes the groups having at least one of the apis
+func makeGroupDescs(typeName string, groups [][]string, apis []ApiDesc) []GroupDesc {
+	desc := make([]GroupDesc, 0, len(groups))
+	for _, group := range groups {
+		used := slices.ContainsFunc(apis, func(api ApiDesc) bool {
+			return len(api.Group) >= len(group) && slices.Equal(api.Group[:len(group)], group)
+		})
+		if !used {
+			continue
+		}
+		desc = append(desc, GroupDesc{
+			Name:     group[len(group)-1],
+			TypeName: typeName + strings.Join(group, ""),
+			Parent:   typeName + strings.Join(group[:len(group)-1], ""),
+			Access:   strings.Join(group, "."),
+		})
+	}
+	return desc
+}
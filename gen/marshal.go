@@ -0,0 +1,563 @@
+package gen
+
+import (
+	"bytes"
+	"cmp"
+	_ "embed"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/dennypenta/vel"
+	"github.com/dennypenta/vel/veljson"
+)
+
+//go:embed templates/json.tpl
+var jsonTemplate string
+
+var (
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+	jsonAppenderType  = reflect.TypeFor[vel.JSONAppender]()
+	timeType          = reflect.TypeFor[time.Time]()
+)
+
+// JSONConfig configures the generated JSON methods
+type JSONConfig struct {
+	// Package is the import path of the package declaring the handler types, the file must be generated to it.
+	// It defaults to the package of the handler types when they are declared in a single one.
+	Package string `yaml:"package"`
+}
+
+// JSONDesc is the data of the JSON template
+type JSONDesc struct {
+	Package string
+	// Imports are the standard packages, Packages are the other ones
+	Imports  []string
+	Packages []string
+	Types    []JSONType
+}
+
+// JSONType holds the generated methods of a type, Encode and Decode are their bodies
+type JSONType struct {
+	Name string
+	// FieldsVar is the variable listing the keys of the fields, Fields is its content
+	FieldsVar string
+	Fields    string
+	Encode    string
+	Decode    string
+}
+
+// jsonField is an encoded field of a struct
+type jsonField struct {
+	index     int
+	key       string
+	omitEmpty bool
+}
+
+// jsonGen generates the JSON methods of the structs declared in a package
+type jsonGen struct {
+	pkg     string
+	types   []reflect.Type
+	fields  map[reflect.Type][]jsonField
+	imports map[string]bool
+	// packages maps the names of the imported packages to their paths, so two packages of the same name aren't imported
+	packages map[string]string
+	// usesErr is set when the type body being generated assigns err
+	usesErr bool
+}
+
+// GenerateJSON writes the AppendJSON, MarshalJSON and UnmarshalJSON methods of the request and response types
+// of the router, so a handler encodes and decodes them without the reflection of encoding/json, see vel.JSONAppender.
+// The methods are generated for the structs declared in the package of the config reachable from the handler types,
+// the file must be generated to that package. A field of another type, e.g. one with its own MarshalJSON, an interface
+// or a struct of another package, is encoded by encoding/json. The structs with embedded fields or with the string
+// and omitzero options are left to encoding/json as a whole.
+func GenerateJSON(router *vel.Router, w io.Writer, config JSONConfig) error {
+	routes := &routerRoutes{}
+	routes.walk(router, router.Prefix(), nil)
+	var roots []reflect.Type
+	for _, meta := range routes.meta {
+		if meta.Method != "GET" && meta.Input != nil && vel.HasBody(reflect.TypeOf(meta.Input)) {
+			input := reflect.TypeOf(meta.Input)
+			// the items of a stream are decoded one by one
+			if item, ok := vel.ItemType(input); ok {
+				input = item
+			}
+			roots = append(roots, input)
+		}
+		if meta.Output != nil && vel.HasBody(reflect.TypeOf(meta.Output)) {
+			roots = append(roots, reflect.TypeOf(meta.Output))
+		}
+	}
+
+	pkg := config.Package
+	if pkg == "" {
+		var packages []string
+		for _, t := range roots {
+			for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+				t = t.Elem()
+			}
+			if t.PkgPath() != "" && t.PkgPath() != jsonAppenderType.PkgPath() && !slices.Contains(packages, t.PkgPath()) {
+				packages = append(packages, t.PkgPath())
+			}
+		}
+		if len(packages) != 1 {
+			return fmt.Errorf("the handler types are declared in the packages %v, set the package of the file", packages)
+		}
+		pkg = packages[0]
+	}
+
+	g := &jsonGen{
+		pkg:      pkg,
+		fields:   make(map[reflect.Type][]jsonField),
+		imports:  map[string]bool{"github.com/dennypenta/vel/veljson": true},
+		packages: make(map[string]string),
+	}
+	for _, t := range roots {
+		g.collect(t)
+	}
+	if len(g.types) == 0 {
+		return fmt.Errorf("no handler type of %s can have the JSON methods", pkg)
+	}
+	slices.SortFunc(g.types, func(a, b reflect.Type) int { return cmp.Compare(a.Name(), b.Name()) })
+
+	desc := JSONDesc{}
+	desc.Package, _, _ = strings.Cut(g.types[0].String(), ".")
+	for _, t := range g.types {
+		desc.Types = append(desc.Types, g.jsonType(t))
+	}
+	for _, path := range slices.Sorted(maps.Keys(g.imports)) {
+		if first, _, _ := strings.Cut(path, "/"); strings.Contains(first, ".") {
+			desc.Packages = append(desc.Packages, path)
+		} else {
+			desc.Imports = append(desc.Imports, path)
+		}
+	}
+
+	tpl, err := template.New("json").Parse(jsonTemplate)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, desc); err != nil {
+		return err
+	}
+	content, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to format the JSON methods: %w", err)
+	}
+	_, err = w.Write(content)
+	return err
+}
+
+// GenerateJSONToFile generates the JSON methods to a file of the package declaring the handler types, e.g. api/json.gen.go
+func GenerateJSONToFile(router *vel.Router, outputPath string, config JSONConfig) error {
+	var buf bytes.Buffer
+	if err := GenerateJSON(router, &buf, config); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(outputPath, buf.Bytes(), 0644)
+}
+
+// collect finds the structs getting the methods
+func (g *jsonGen) collect(t reflect.Type) {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		g.collect(t.Elem())
+	case reflect.Struct:
+		if _, ok := g.fields[t]; ok || !g.generated(t) {
+			return
+		}
+		g.types = append(g.types, t)
+		for _, field := range g.fields[t] {
+			g.collect(t.Field(field.index).Type)
+		}
+	}
+}
+
+// generated reports whether the struct gets the methods, its fields are cached
+func (g *jsonGen) generated(t reflect.Type) bool {
+	if fields, ok := g.fields[t]; ok {
+		return fields != nil
+	}
+	if t.Kind() != reflect.Struct || t.Name() == "" || t.PkgPath() != g.pkg || strings.Contains(t.Name(), "[") || customJSON(t) {
+		return false
+	}
+	fields, ok := jsonFields(t)
+	if !ok {
+		return false
+	}
+	g.fields[t] = fields
+	return true
+}
+
+// customJSON reports whether the type encodes itself, the methods generated before don't count
+func customJSON(t reflect.Type) bool {
+	if t.Implements(jsonAppenderType) {
+		return false
+	}
+	for _, custom := range []reflect.Type{jsonMarshalerType, jsonUnmarshalerType, textMarshalerType, textUnmarshalerType} {
+		if t.Implements(custom) || reflect.PointerTo(t).Implements(custom) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonFields lists the fields encoding/json encodes, it's false if the struct needs encoding/json
+func jsonFields(t reflect.Type) ([]jsonField, bool) {
+	fields := []jsonField{}
+	keys := make(map[string]bool)
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.Anonymous {
+			return nil, false
+		}
+		tag := field.Tag.Get("json")
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		f := jsonField{index: i, key: cmp.Or(name, field.Name)}
+		for _, option := range strings.Split(options, ",") {
+			switch option {
+			case "":
+			case "omitempty":
+				f.omitEmpty = true
+			default:
+				return nil, false
+			}
+		}
+		if keys[f.key] {
+			return nil, false
+		}
+		keys[f.key] = true
+		fields = append(fields, f)
+	}
+	return fields, true
+}
+
+func (g *jsonGen) jsonType(t reflect.Type) JSONType {
+	desc := JSONType{Name: t.Name(), FieldsVar: "jsonFields" + t.Name()}
+	fields := g.fields[t]
+	keys := make([]string, len(fields))
+	for i, field := range fields {
+		keys[i] = strconv.Quote(field.key)
+	}
+	desc.Fields = strings.Join(keys, ", ")
+
+	var encode strings.Builder
+	g.usesErr = false
+	if len(fields) == 0 {
+		encode.WriteString("return append(b, \"{}\"...), nil")
+	} else {
+		encode.WriteString("start := len(b)\nb = append(b, '{')\n")
+		for _, field := range fields {
+			f := t.Field(field.index)
+			place := "v." + f.Name
+			cond := ""
+			if field.omitEmpty {
+				cond = nonEmpty(place, f.Type)
+			}
+			if cond != "" {
+				fmt.Fprintf(&encode, "if %s {\n", cond)
+			}
+			key := string(veljson.AppendString(nil, field.key)) + ":"
+			fmt.Fprintf(&encode, "b = append(veljson.Comma(b, start), %s...)\n", strconv.Quote(key))
+			g.encode(&encode, place, f.Type, 0, cond != "" && f.Type.Name() == "")
+			if cond != "" {
+				encode.WriteString("}\n")
+			}
+		}
+		if g.usesErr {
+			encode.WriteString("return append(b, '}'), err")
+		} else {
+			encode.WriteString("return append(b, '}'), nil")
+		}
+	}
+	desc.Encode = encode.String()
+	if g.usesErr {
+		desc.Encode = "var err error\n" + desc.Encode
+	}
+
+	var decode strings.Builder
+	decode.WriteString("if !r.Object() {\nreturn\n}\nfor r.NextKey() {\n")
+	if len(fields) == 0 {
+		decode.WriteString("r.Skip()\n}")
+	} else {
+		fmt.Fprintf(&decode, "switch veljson.Field(r.Key(), %s) {\n", desc.FieldsVar)
+		for i, field := range fields {
+			f := t.Field(field.index)
+			fmt.Fprintf(&decode, "case %d:\n", i)
+			g.decode(&decode, "v."+f.Name, f.Type, 0)
+		}
+		decode.WriteString("default:\nr.Skip()\n}\n}")
+	}
+	desc.Decode = decode.String()
+	return desc
+}
+
+// nonEmpty is the condition of an omitempty field, empty if the field is never omitted
+func nonEmpty(place string, t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return place
+	case reflect.String:
+		return place + ` != ""`
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return place + " != 0"
+	case reflect.Pointer, reflect.Interface:
+		return place + " != nil"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return "len(" + place + ") != 0"
+	}
+	return ""
+}
+
+// operand parenthesizes a dereference, so a method or an index applies to the pointed value
+func operand(place string) string {
+	if strings.HasPrefix(place, "*") {
+		return "(" + place + ")"
+	}
+	return place
+}
+
+// convert converts the value to the type unless it's the type already
+func convert(t reflect.Type, kind, value string) string {
+	if t.PkgPath() == "" && t.Name() == kind {
+		return value
+	}
+	return kind + "(" + value + ")"
+}
+
+// fallible appends a call returning the buffer and an error
+func (g *jsonGen) fallible(w *strings.Builder, call string) {
+	g.usesErr = true
+	fmt.Fprintf(w, "if b, err = %s; err != nil {\nreturn b, err\n}\n", call)
+}
+
+// encode appends the value of the place to b, notNil is set when an omitempty condition checked the place isn't nil
+func (g *jsonGen) encode(w *strings.Builder, place string, t reflect.Type, depth int, notNil bool) {
+	switch {
+	case t.Kind() == reflect.Pointer && t.Name() == "":
+		// encoding/json encodes the pointed value, a nil pointer is null
+		if !notNil {
+			fmt.Fprintf(w, "if %s == nil {\nb = append(b, \"null\"...)\n} else {\n", place)
+		}
+		g.encode(w, "*"+place, t.Elem(), depth, false)
+		if !notNil {
+			w.WriteString("}\n")
+		}
+		return
+	case t == timeType:
+		g.fallible(w, fmt.Sprintf("veljson.AppendTime(b, %s)", place))
+		return
+	case g.generated(t):
+		g.fallible(w, fmt.Sprintf("%s.AppendJSON(b)", operand(place)))
+		return
+	case customJSON(t):
+		g.fallible(w, fmt.Sprintf("veljson.AppendValue(b, %s)", place))
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		fmt.Fprintf(w, "b = veljson.AppendString(b, %s)\n", convert(t, "string", place))
+	case reflect.Bool:
+		g.imports["strconv"] = true
+		fmt.Fprintf(w, "b = strconv.AppendBool(b, %s)\n", convert(t, "bool", place))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		g.imports["strconv"] = true
+		fmt.Fprintf(w, "b = strconv.AppendInt(b, %s, 10)\n", convert(t, "int64", place))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		g.imports["strconv"] = true
+		fmt.Fprintf(w, "b = strconv.AppendUint(b, %s, 10)\n", convert(t, "uint64", place))
+	case reflect.Float32, reflect.Float64:
+		g.fallible(w, fmt.Sprintf("veljson.AppendFloat(b, %s, %d)", convert(t, "float64", place), t.Bits()))
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// encoding/json encodes the bytes in base64
+			g.fallible(w, fmt.Sprintf("veljson.AppendValue(b, %s)", place))
+			return
+		}
+		i := fmt.Sprintf("i%d", depth)
+		if !notNil {
+			fmt.Fprintf(w, "if %s == nil {\nb = append(b, \"null\"...)\n} else {\n", place)
+		}
+		fmt.Fprintf(w, "b = append(b, '[')\nfor %s := range %s {\nif %s > 0 {\nb = append(b, ',')\n}\n", i, place, i)
+		g.encode(w, fmt.Sprintf("%s[%s]", operand(place), i), t.Elem(), depth+1, false)
+		w.WriteString("}\nb = append(b, ']')\n")
+		if !notNil {
+			w.WriteString("}\n")
+		}
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String || customJSON(t.Key()) {
+			g.fallible(w, fmt.Sprintf("veljson.AppendValue(b, %s)", place))
+			return
+		}
+		g.imports["maps"], g.imports["slices"] = true, true
+		start, key := fmt.Sprintf("start%d", depth), fmt.Sprintf("key%d", depth)
+		if !notNil {
+			fmt.Fprintf(w, "if %s == nil {\nb = append(b, \"null\"...)\n} else {\n", place)
+		}
+		fmt.Fprintf(w, "%s := len(b)\nb = append(b, '{')\n", start)
+		fmt.Fprintf(w, "for _, %s := range slices.Sorted(maps.Keys(%s)) {\n", key, place)
+		fmt.Fprintf(w, "b = veljson.AppendString(veljson.Comma(b, %s), %s)\nb = append(b, ':')\n", start, convert(t.Key(), "string", key))
+		g.encode(w, fmt.Sprintf("%s[%s]", operand(place), key), t.Elem(), depth+1, false)
+		w.WriteString("}\nb = append(b, '}')\n")
+		if !notNil {
+			w.WriteString("}\n")
+		}
+	default:
+		g.fallible(w, fmt.Sprintf("veljson.AppendValue(b, %s)", place))
+	}
+}
+
+// decode reads the value of the place, the place is addressable
+func (g *jsonGen) decode(w *strings.Builder, place string, t reflect.Type, depth int) {
+	switch {
+	case t.Kind() == reflect.Pointer && t.Name() == "":
+		elem, ok := g.typeName(t.Elem())
+		if !ok {
+			fmt.Fprintf(w, "r.Value(&%s)\n", place)
+			return
+		}
+		fmt.Fprintf(w, "if r.Null() {\n%s = nil\n} else {\nif %s == nil {\n%s = new(%s)\n}\n", place, place, place, elem)
+		g.decode(w, "*"+place, t.Elem(), depth)
+		w.WriteString("}\n")
+		return
+	case t == timeType:
+		fmt.Fprintf(w, "r.Time(&%s)\n", place)
+		return
+	case g.generated(t):
+		fmt.Fprintf(w, "%s.readJSON(r)\n", operand(place))
+		return
+	case customJSON(t):
+		fmt.Fprintf(w, "r.Value(&%s)\n", place)
+		return
+	}
+
+	// read assigns the value of a reader method returning the kind, converted to the type of the place
+	read := func(method, kind, value string) {
+		name, ok := g.typeName(t)
+		if !ok {
+			fmt.Fprintf(w, "r.Value(&%s)\n", place)
+			return
+		}
+		converted := value
+		if name != kind {
+			converted = name + "(" + value + ")"
+		}
+		fmt.Fprintf(w, "if %s, ok := r.%s; ok {\n%s = %s\n}\n", value, method, place, converted)
+	}
+	switch t.Kind() {
+	case reflect.String:
+		read("String()", "string", "s")
+	case reflect.Bool:
+		read("Bool()", "bool", "x")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		read(fmt.Sprintf("Int(%d)", t.Bits()), "int64", "n")
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		read(fmt.Sprintf("Uint(%d)", t.Bits()), "uint64", "n")
+	case reflect.Float32, reflect.Float64:
+		read(fmt.Sprintf("Float(%d)", t.Bits()), "float64", "n")
+	case reflect.Slice:
+		name, ok := g.typeName(t)
+		if !ok || t.Elem().Kind() == reflect.Uint8 {
+			fmt.Fprintf(w, "r.Value(&%s)\n", place)
+			return
+		}
+		elem, _ := g.typeName(t.Elem())
+		slice, item := fmt.Sprintf("slice%d", depth), fmt.Sprintf("item%d", depth)
+		fmt.Fprintf(w, "if r.Null() {\n%s = nil\n} else if r.Array() {\n%s := %s[:0]\nfor r.NextItem() {\nvar %s %s\n", place, slice, place, item, elem)
+		g.decode(w, item, t.Elem(), depth+1)
+		fmt.Fprintf(w, "%s = append(%s, %s)\n}\n", slice, slice, item)
+		fmt.Fprintf(w, "if %s == nil {\n%s = %s{}\n}\n%s = %s\n}\n", slice, slice, name, place, slice)
+	case reflect.Map:
+		name, ok := g.typeName(t)
+		if !ok || t.Key().Kind() != reflect.String || customJSON(t.Key()) {
+			fmt.Fprintf(w, "r.Value(&%s)\n", place)
+			return
+		}
+		elem, _ := g.typeName(t.Elem())
+		keyType, _ := g.typeName(t.Key())
+		key, item := fmt.Sprintf("key%d", depth), fmt.Sprintf("item%d", depth)
+		fmt.Fprintf(w, "if r.Null() {\n%s = nil\n} else if r.Object() {\nif %s == nil {\n%s = make(%s)\n}\n", place, place, place, name)
+		fmt.Fprintf(w, "for r.NextKey() {\n%s := %s(r.Key())\nvar %s %s\n", key, keyType, item, elem)
+		g.decode(w, item, t.Elem(), depth+1)
+		fmt.Fprintf(w, "%s[%s] = %s\n}\n}\n", operand(place), key, item)
+	default:
+		fmt.Fprintf(w, "r.Value(&%s)\n", place)
+	}
+}
+
+// typeName spells the type in the generated file, the packages of the named types are imported.
+// It's false for the types that can't be spelled, e.g. an anonymous struct.
+func (g *jsonGen) typeName(t reflect.Type) (string, bool) {
+	imports := make(map[string]string)
+	name, ok := g.spell(t, imports)
+	if !ok {
+		return "", false
+	}
+	for pkg, path := range imports {
+		g.packages[pkg] = path
+		g.imports[path] = true
+	}
+	return name, true
+}
+
+func (g *jsonGen) spell(t reflect.Type, imports map[string]string) (string, bool) {
+	if t.Name() != "" {
+		switch {
+		case strings.Contains(t.Name(), "["):
+			return "", false
+		case t.PkgPath() == "" || t.PkgPath() == g.pkg:
+			return t.Name(), true
+		}
+		pkg, _, _ := strings.Cut(t.String(), ".")
+		for _, known := range []map[string]string{g.packages, imports} {
+			if path, ok := known[pkg]; ok && path != t.PkgPath() {
+				return "", false
+			}
+		}
+		imports[pkg] = t.PkgPath()
+		return t.String(), true
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		elem, ok := g.spell(t.Elem(), imports)
+		return "*" + elem, ok
+	case reflect.Slice:
+		elem, ok := g.spell(t.Elem(), imports)
+		return "[]" + elem, ok
+	case reflect.Array:
+		elem, ok := g.spell(t.Elem(), imports)
+		return fmt.Sprintf("[%d]%s", t.Len(), elem), ok
+	case reflect.Map:
+		key, ok := g.spell(t.Key(), imports)
+		if !ok {
+			return "", false
+		}
+		elem, ok := g.spell(t.Elem(), imports)
+		return fmt.Sprintf("map[%s]%s", key, elem), ok
+	}
+	return "", false
+}
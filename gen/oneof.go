@@ -0,0 +1,103 @@
+package gen
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/dennypenta/vel"
+)
+
+// UnionDesc describes a vel.OneOf: the structs it holds told apart by the discriminator field they share
+type UnionDesc struct {
+	// Discriminator is the JSON key of the discriminator field
+	Discriminator string
+	Variants      []VariantDesc
+}
+
+// VariantDesc is a struct of a union
+type VariantDesc struct {
+	// Name is the declared name of the struct
+	Name string
+	// Field is the Go name of the discriminator field of the struct, Value is its value
+	Field string
+	Value string
+	// ZodType refers to the schema of the struct
+	ZodType string
+}
+
+// describeUnion describes the OneOf by a field per struct, so the structs are declared like the ones of the fields,
+// the Go client sets one of the fields
+func describeUnion(t reflect.Type) (DataType, error) {
+	union, err := vel.OneOfUnion(t)
+	if err != nil {
+		return DataType{}, err
+	}
+	desc := &UnionDesc{Discriminator: union.Discriminator}
+	fields := make([]Field, len(union.Variants))
+	for i, variant := range union.Variants {
+		name := goTypeName(variant.Type, "")
+		if name == "" {
+			return DataType{}, fmt.Errorf("%s holds an anonymous struct: %w", t, ErrorInlineStructForbidden)
+		}
+		mapped := mappedTypesOf(variant.Type)
+		desc.Variants = append(desc.Variants, VariantDesc{
+			Name:    name,
+			Field:   variant.Field.Name,
+			Value:   variant.Value,
+			ZodType: toZodType(name, mapped),
+		})
+		fields[i] = Field{
+			Name:       name,
+			Type:       reflect.PointerTo(variant.Type),
+			TypeName:   "*" + name,
+			TSTypeName: toTSType(name, mapped),
+			ZodType:    toZodType(name, mapped),
+			ZodTSType:  toZodTSType(name, mapped),
+			JsonTag:    "-",
+			JsonName:   name,
+			TSKey:      tsKey(name),
+			Optional:   true,
+		}
+	}
+	return DataType{
+		Name:      typeName(t),
+		Fields:    fields,
+		Union:     desc,
+		Recursive: refersTo(t, fields, map[reflect.Type]bool{t: true}),
+	}, nil
+}
+
+// unionSchema describes the union as oneOf its structs, the discriminator maps its values to them
+func unionSchema(union *UnionDesc) *OpenAPISchema {
+	schema := &OpenAPISchema{
+		Discriminator: &OpenAPIDiscriminator{
+			PropertyName: union.Discriminator,
+			Mapping:      make(map[string]string, len(union.Variants)),
+		},
+	}
+	for _, variant := range union.Variants {
+		ref := "#/components/schemas/" + variant.Name
+		schema.OneOf = append(schema.OneOf, &OpenAPISchema{Ref: ref})
+		schema.Discriminator.Mapping[variant.Value] = ref
+	}
+	return schema
+}
+
+// fillUnionExample sets the OneOf to an example of its first struct
+func fillUnionExample(v reflect.Value, name string, filling map[reflect.Type]bool) {
+	union, err := vel.OneOfUnion(v.Type())
+	if err != nil || filling[v.Type()] {
+		return
+	}
+	filling[v.Type()] = true
+	defer delete(filling, v.Type())
+
+	variant := reflect.New(union.Variants[0].Type)
+	fillExample(variant.Elem(), name, filling)
+	data, err := json.Marshal(variant.Interface())
+	if err != nil {
+		return
+	}
+	_ = json.Unmarshal(data, v.Addr().Interface())
+}
@@ -0,0 +1,495 @@
+package gen
+
+import (
+	"bytes"
+	"cmp"
+	_ "embed"
+	"errors"
+	"fmt"
+	"go/format"
+	"io/fs"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/dennypenta/vel"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed templates/import.tpl
+var importTemplate string
+
+// importMethods are the operations of a path item FromOpenAPI imports
+var importMethods = []string{"get", "post", "put", "patch", "delete"}
+
+// importedErrorCode matches an error code documented by vel, e.g. * `USER_NOT_FOUND` - the user doesn't exist
+var importedErrorCode = regexp.MustCompile("^\\s*\\*\\s*`([^`]+)`\\s*(?:-\\s*(.*))?$")
+
+// openAPIDocument is the part of an OpenAPI document FromOpenAPI reads
+type openAPIDocument struct {
+	Paths      map[string]map[string]yaml.Node `yaml:"paths"`
+	Components struct {
+		Schemas map[string]*OpenAPISchema `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+type openAPIDocumentOperation struct {
+	OperationID string              `yaml:"operationId"`
+	Summary     string              `yaml:"summary"`
+	Description string              `yaml:"description"`
+	Parameters  []*OpenAPIParameter `yaml:"parameters"`
+	RequestBody *struct {
+		Content map[string]*OpenAPIMediaType `yaml:"content"`
+	} `yaml:"requestBody"`
+	Responses map[string]*struct {
+		Description string                       `yaml:"description"`
+		Content     map[string]*OpenAPIMediaType `yaml:"content"`
+	} `yaml:"responses"`
+}
+
+// ImportDesc is the data of the import template: the types, the handlers and the router of an imported API
+type ImportDesc struct {
+	Package    string
+	Types      []ImportType
+	Operations []ImportOperation
+	// UsesTime is set if a type refers to time.Time
+	UsesTime bool
+}
+
+type ImportType struct {
+	Name string
+	Doc  []string
+	// Underlying is the type of a non-struct declaration, e.g. string of an enum or []Pet
+	Underlying string
+	Fields     []ImportField
+	Enum       []ImportEnumValue
+}
+
+type ImportField struct {
+	Name string
+	Type string
+	Tag  string
+	Doc  []string
+}
+
+type ImportEnumValue struct {
+	Name string
+	// Value is the quoted value
+	Value string
+}
+
+type ImportOperation struct {
+	OperationID string
+	FuncName    string
+	// Register is the registration function, vel serves GET and POST only, so the other methods become POST
+	Register string
+	// Route is the method and the path of the original API
+	Route  string
+	Input  string
+	Output string
+	Doc    []string
+	// Description, Header and Errors are quoted for the Spec declaration
+	Description    string
+	Header         string
+	HeaderDesc     string
+	HeaderRequired bool
+	Errors         []ImportStatusErrors
+}
+
+// ImportStatusErrors are the errors of a response status
+type ImportStatusErrors struct {
+	Status int
+	Errors []ImportError
+}
+
+type ImportError struct {
+	Code        string
+	Description string
+}
+
+// FromOpenAPI reads an OpenAPI document and writes a vel skeleton of the API to outDir: the request and response types
+// in types.go, a stub of every handler in handlers.go and NewRouter registering them with their Spec in router.go.
+// vel serves an operation at its id, so the original paths aren't kept, the path parameters become fields of the request.
+// The package is named after the directory, the existing files are never overwritten.
+func FromOpenAPI(specPath, outDir string) error {
+	data, err := os.ReadFile(specPath)
+	if err != nil {
+		return err
+	}
+	desc, err := importOpenAPI(data, importPackageName(outDir))
+	if err != nil {
+		return fmt.Errorf("failed to import %s: %w", specPath, err)
+	}
+	files, err := renderImport(desc)
+	if err != nil {
+		return err
+	}
+
+	for name := range files {
+		if _, err := os.Stat(filepath.Join(outDir, name)); err == nil {
+			return fmt.Errorf("%s already exists", filepath.Join(outDir, name))
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return err
+	}
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		if err := os.WriteFile(filepath.Join(outDir, name), files[name], 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderImport renders the files of the imported API
+func renderImport(desc ImportDesc) (map[string][]byte, error) {
+	tpl, err := template.New("import").Parse(importTemplate)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]byte)
+	for _, part := range []string{"types", "handlers", "router"} {
+		var buf bytes.Buffer
+		if err := tpl.ExecuteTemplate(&buf, part, desc); err != nil {
+			return nil, err
+		}
+		content, err := format.Source(buf.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("failed to format %s.go: %w", part, err)
+		}
+		files[part+".go"] = content
+	}
+	return files, nil
+}
+
+func importPackageName(dir string) string {
+	name := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, filepath.Base(dir))
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		return "api"
+	}
+	return name
+}
+
+// openAPIImporter converts the schemas of a document to Go types
+type openAPIImporter struct {
+	types []ImportType
+	// names holds the declared type names, an inline schema gets a unique one
+	names    map[string]bool
+	usesTime bool
+}
+
+func importOpenAPI(data []byte, pkg string) (ImportDesc, error) {
+	var doc openAPIDocument
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return ImportDesc{}, err
+	}
+	im := &openAPIImporter{names: make(map[string]bool)}
+
+	componentNames := slices.Sorted(maps.Keys(doc.Components.Schemas))
+	for _, name := range componentNames {
+		im.names[importIdent(name)] = true
+	}
+	for _, name := range componentNames {
+		im.declare(importIdent(name), doc.Components.Schemas[name])
+	}
+
+	var operations []ImportOperation
+	ids := make(map[string]string)
+	for _, path := range slices.Sorted(maps.Keys(doc.Paths)) {
+		item := doc.Paths[path]
+		for _, method := range importMethods {
+			node, ok := item[method]
+			if !ok {
+				continue
+			}
+			var op openAPIDocumentOperation
+			if err := node.Decode(&op); err != nil {
+				return ImportDesc{}, fmt.Errorf("%s %s: %w", strings.ToUpper(method), path, err)
+			}
+			route := strings.ToUpper(method) + " " + path
+			if op.OperationID == "" {
+				op.OperationID, _ = OperationIDCamel.Apply(method + " " + path)
+			}
+			if other, ok := ids[op.OperationID]; ok {
+				return ImportDesc{}, fmt.Errorf("%s and %s have the same operation id %s", other, route, op.OperationID)
+			}
+			ids[op.OperationID] = route
+			operations = append(operations, im.operation(op, method, route))
+		}
+	}
+	slices.SortFunc(operations, func(a, b ImportOperation) int {
+		return strings.Compare(a.OperationID, b.OperationID)
+	})
+	slices.SortFunc(im.types, func(a, b ImportType) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return ImportDesc{Package: pkg, Types: im.types, Operations: operations, UsesTime: im.usesTime}, nil
+}
+
+func (im *openAPIImporter) operation(op openAPIDocumentOperation, method, route string) ImportOperation {
+	funcName := importIdent(op.OperationID)
+	result := ImportOperation{
+		OperationID: op.OperationID,
+		FuncName:    funcName,
+		Register:    "RegisterPost",
+		Route:       route,
+		Input:       "struct{}",
+		Output:      "struct{}",
+		Doc:         importDoc(cmp.Or(op.Summary, op.Description)),
+	}
+	if description := cmp.Or(op.Description, op.Summary); description != "" {
+		result.Description = strconv.Quote(description)
+	}
+	if method == "get" {
+		result.Register = "RegisterGet"
+	}
+
+	var fields []ImportField
+	for _, param := range op.Parameters {
+		if param == nil || param.Name == "" {
+			continue
+		}
+		switch param.In {
+		case "header":
+			if result.Header != "" {
+				result.Doc = append(result.Doc, "The header "+param.Name+" isn't declared, a Spec declares a single request header.")
+				continue
+			}
+			result.Header = strconv.Quote(param.Name)
+			result.HeaderDesc = strconv.Quote(param.Description)
+			result.HeaderRequired = param.Required
+		case "query", "path":
+			field := ImportField{
+				Name: importIdent(param.Name),
+				Type: im.goType(param.Schema, funcName+importIdent(param.Name)),
+				Doc:  importDoc(param.Description),
+			}
+			if method == "get" {
+				field.Tag = fmt.Sprintf("`schema:%q`", param.Name)
+			} else {
+				field.Tag = importJSONTag(param.Name, param.Required)
+			}
+			fields = append(fields, field)
+		}
+	}
+
+	var body *OpenAPISchema
+	if op.RequestBody != nil && method != "get" {
+		if media := op.RequestBody.Content["application/json"]; media != nil {
+			body = media.Schema
+		}
+	}
+	switch {
+	case body != nil && body.Ref != "" && len(fields) == 0:
+		result.Input = im.goType(body, "")
+	case body != nil || len(fields) > 0:
+		if body != nil && body.Ref != "" {
+			// the fields of the embedded body are promoted by encoding/json
+			fields = append([]ImportField{{Type: im.goType(body, "")}}, fields...)
+		} else if body != nil {
+			fields = append(fields, im.structFields(funcName+"Request", body)...)
+		}
+		result.Input = im.add(ImportType{Name: im.unique(funcName + "Request"), Fields: fields})
+	}
+
+	for _, status := range slices.Sorted(maps.Keys(op.Responses)) {
+		response := op.Responses[status]
+		code, err := strconv.Atoi(status)
+		if err != nil || response == nil {
+			continue
+		}
+		if code >= 200 && code < 300 && result.Output == "struct{}" {
+			if media := response.Content["application/json"]; media != nil && media.Schema != nil {
+				result.Output = im.goType(media.Schema, funcName+"Response")
+			}
+		}
+		if errs := importErrors(code, response.Description); code >= 400 && len(errs) > 0 {
+			result.Errors = append(result.Errors, ImportStatusErrors{Status: code, Errors: errs})
+		}
+	}
+	return result
+}
+
+// importErrors reads the error codes documented by vel from the description of an error response,
+// another response becomes an error named after its status, e.g. NOT_FOUND.
+// The validation error is skipped, vel documents it for the inputs implementing vel.Validator.
+func importErrors(status int, description string) []ImportError {
+	var errs []ImportError
+	documented := false
+	for _, line := range strings.Split(description, "\n") {
+		if m := importedErrorCode.FindStringSubmatch(line); m != nil {
+			documented = true
+			if m[1] != vel.ValidationFailedCode {
+				errs = append(errs, ImportError{Code: strconv.Quote(m[1]), Description: strconv.Quote(strings.TrimSpace(m[2]))})
+			}
+		}
+	}
+	if documented {
+		return errs
+	}
+	code := strings.ToUpper(strings.Join(splitWords(http.StatusText(status)), "_"))
+	if code == "" {
+		code = "ERROR_" + strconv.Itoa(status)
+	}
+	return []ImportError{{Code: strconv.Quote(code), Description: strconv.Quote(description)}}
+}
+
+// declare declares a named type of the schema
+func (im *openAPIImporter) declare(name string, schema *OpenAPISchema) {
+	if schema == nil {
+		im.add(ImportType{Name: name, Underlying: "any"})
+		return
+	}
+	declared := ImportType{Name: name, Doc: importDoc(schema.Description)}
+	switch {
+	case schema.Type == "string" && len(schema.Enum) > 0:
+		declared.Underlying = "string"
+		for _, value := range schema.Enum {
+			value := fmt.Sprint(value)
+			declared.Enum = append(declared.Enum, ImportEnumValue{Name: name + importIdent(value), Value: strconv.Quote(value)})
+		}
+	case (schema.Type == "object" || schema.Type == "") && len(schema.Properties) > 0:
+		declared.Fields = im.structFields(name, schema)
+	default:
+		declared.Underlying = im.goType(schema, name+"Item")
+	}
+	im.add(declared)
+}
+
+func (im *openAPIImporter) add(t ImportType) string {
+	im.names[t.Name] = true
+	im.types = append(im.types, t)
+	return t.Name
+}
+
+// unique returns the name or the name followed by a number if a type is declared already
+func (im *openAPIImporter) unique(name string) string {
+	if !im.names[name] {
+		im.names[name] = true
+		return name
+	}
+	for i := 2; ; i++ {
+		if candidate := name + strconv.Itoa(i); !im.names[candidate] {
+			im.names[candidate] = true
+			return candidate
+		}
+	}
+}
+
+// structFields converts the properties of an object, an inline object is named after its owner and the field
+func (im *openAPIImporter) structFields(owner string, schema *OpenAPISchema) []ImportField {
+	fields := make([]ImportField, 0, len(schema.Properties))
+	seen := make(map[string]bool)
+	for _, key := range slices.Sorted(maps.Keys(schema.Properties)) {
+		name := importIdent(key)
+		for i := 2; seen[name]; i++ {
+			name = importIdent(key) + strconv.Itoa(i)
+		}
+		seen[name] = true
+		property := schema.Properties[key]
+		field := ImportField{
+			Name: name,
+			Type: im.goType(property, owner+name),
+			Tag:  importJSONTag(key, slices.Contains(schema.Required, key)),
+		}
+		if property != nil {
+			field.Doc = importDoc(property.Description)
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// goType returns the Go type of the schema, an inline object is declared with the name
+func (im *openAPIImporter) goType(schema *OpenAPISchema, name string) string {
+	if schema == nil {
+		return "any"
+	}
+	if schema.Ref != "" {
+		return importIdent(strings.TrimPrefix(schema.Ref, "#/components/schemas/"))
+	}
+	switch schema.Type {
+	case "string":
+		switch schema.Format {
+		case "date-time":
+			im.usesTime = true
+			return "time.Time"
+		case "byte", "binary":
+			return "[]byte"
+		}
+		return "string"
+	case "integer":
+		switch schema.Format {
+		case "int32":
+			return "int32"
+		case "int64":
+			return "int64"
+		}
+		return "int"
+	case "number":
+		if schema.Format == "float" {
+			return "float32"
+		}
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "array":
+		return "[]" + im.goType(schema.Items, name+"Item")
+	case "object", "":
+		if len(schema.Properties) > 0 {
+			name = im.unique(name)
+			return im.add(ImportType{Name: name, Doc: importDoc(schema.Description), Fields: im.structFields(name, schema)})
+		}
+		if schema.AdditionalProperties != nil {
+			return "map[string]" + im.goType(schema.AdditionalProperties, name+"Value")
+		}
+		if schema.Type == "object" {
+			return "map[string]any"
+		}
+	}
+	return "any"
+}
+
+// importIdent converts a name of the document to an exported Go identifier, e.g. created_at becomes CreatedAt
+func importIdent(name string) string {
+	var b strings.Builder
+	for _, word := range splitWords(name) {
+		b.WriteString(Capitalize(word))
+	}
+	ident := b.String()
+	if ident == "" || unicode.IsDigit(rune(ident[0])) {
+		return "X" + ident
+	}
+	return ident
+}
+
+func importJSONTag(name string, required bool) string {
+	if required {
+		return fmt.Sprintf("`json:%q`", name)
+	}
+	return fmt.Sprintf("`json:%q`", name+",omitempty")
+}
+
+// importDoc splits a description to the lines of a comment
+func importDoc(description string) []string {
+	description = strings.TrimSpace(description)
+	if description == "" {
+		return nil
+	}
+	return strings.Split(description, "\n")
+}
@@ -0,0 +1,55 @@
+package gen
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Parallelism limits the goroutines describing the handlers and rendering the operations,
+// zero or less uses GOMAXPROCS, 1 generates sequentially
+var Parallelism = 0
+
+// parallel calls fn for every index in [0, n) by the workers,
+// fn must only write the results of its index so the assembly doesn't depend on the scheduling.
+// It returns the error of the lowest index, the same as a sequential loop would.
+func parallel(n int, fn func(i int) error) error {
+	workers := Parallelism
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, n)
+	if workers <= 1 {
+		for i := range n {
+			if err := fn(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	errs := make([]error, n)
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= n {
+					return
+				}
+				errs[i] = fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
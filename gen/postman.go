@@ -0,0 +1,251 @@
+package gen
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dennypenta/vel"
+)
+
+const postmanSchema = "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"
+
+// PostmanConfig holds configuration for generating a Postman collection
+type PostmanConfig struct {
+	Name string `yaml:"name"`
+	// BaseURL is the initial value of the baseUrl variable, http://localhost:8080 if empty
+	BaseURL string `yaml:"baseUrl"`
+	// AuthHeaders are sent by every request, e.g. Authorization, their values are the collection variables
+	// along with the headers declared in the specs
+	AuthHeaders []string `yaml:"authHeaders"`
+}
+
+// PostmanCollection is a Postman collection of the v2.1 format
+type PostmanCollection struct {
+	Info     PostmanInfo       `json:"info"`
+	Item     []PostmanItem     `json:"item"`
+	Variable []PostmanVariable `json:"variable"`
+}
+
+type PostmanInfo struct {
+	Name   string `json:"name"`
+	Schema string `json:"schema"`
+}
+
+// PostmanItem is a request or a folder of the items of a subrouter
+type PostmanItem struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Item        []PostmanItem   `json:"item,omitempty"`
+	Request     *PostmanRequest `json:"request,omitempty"`
+}
+
+type PostmanRequest struct {
+	Method string          `json:"method"`
+	Header []PostmanHeader `json:"header"`
+	Body   *PostmanBody    `json:"body,omitempty"`
+	URL    PostmanURL      `json:"url"`
+}
+
+type PostmanHeader struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	Description string `json:"description,omitempty"`
+}
+
+type PostmanBody struct {
+	Mode    string             `json:"mode"`
+	Raw     string             `json:"raw"`
+	Options PostmanBodyOptions `json:"options"`
+	// URLEncoded lists the fields of a form body
+	URLEncoded []PostmanQueryParam `json:"urlencoded,omitempty"`
+}
+
+type PostmanBodyOptions struct {
+	Raw struct {
+		Language string `json:"language"`
+	} `json:"raw"`
+}
+
+type PostmanURL struct {
+	Raw   string              `json:"raw"`
+	Host  []string            `json:"host"`
+	Path  []string            `json:"path"`
+	Query []PostmanQueryParam `json:"query,omitempty"`
+}
+
+type PostmanQueryParam struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type PostmanVariable struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// GeneratePostmanCollection builds a collection with a request of every api, the sub-clients become folders.
+// A request is the first example declared in its spec or the one built by Example.
+func (g *ClientGen) GeneratePostmanCollection(config PostmanConfig) (*PostmanCollection, error) {
+	baseURL := config.BaseURL
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	collection := &PostmanCollection{
+		Info:     PostmanInfo{Name: config.Name, Schema: postmanSchema},
+		Variable: []PostmanVariable{{Key: "baseUrl", Value: baseURL}},
+	}
+
+	variables := map[string]bool{"baseUrl": true}
+	addVariable := func(header string) string {
+		name, _ := OperationIDCamel.Apply(header)
+		if !variables[name] {
+			variables[name] = true
+			collection.Variable = append(collection.Variable, PostmanVariable{Key: name})
+		}
+		return "{{" + name + "}}"
+	}
+	var authHeaders []PostmanHeader
+	for _, header := range config.AuthHeaders {
+		authHeaders = append(authHeaders, PostmanHeader{Key: header, Value: addVariable(header)})
+	}
+
+	var items func(receiver string) ([]PostmanItem, error)
+	items = func(receiver string) ([]PostmanItem, error) {
+		var result []PostmanItem
+		for _, group := range g.meta.GroupsOf(receiver) {
+			folder, err := items(group.TypeName)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, PostmanItem{Name: group.Name, Item: folder})
+		}
+		for _, api := range g.meta.ApisOf(receiver) {
+			headers := append([]PostmanHeader{}, authHeaders...)
+			if h := api.Spec.RequestHeaders; h.Key != "" {
+				headers = append(headers, PostmanHeader{Key: h.Key, Value: addVariable(h.Key), Description: h.Description})
+			}
+			name := api.OperationID
+			if api.Version != "" {
+				headers = append(headers, PostmanHeader{Key: vel.AcceptVersionHeader, Value: api.Version})
+				name += " " + api.Version
+			}
+			request, err := postmanRequest(api, headers)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", api.OperationID, err)
+			}
+			result = append(result, PostmanItem{Name: name, Description: api.Spec.Description, Request: request})
+		}
+		return result, nil
+	}
+
+	var err error
+	if collection.Item, err = items(g.meta.Client.TypeName); err != nil {
+		return nil, err
+	}
+	return collection, nil
+}
+
+func postmanRequest(api ApiDesc, headers []PostmanHeader) (*PostmanRequest, error) {
+	request := &PostmanRequest{
+		Method: api.Method,
+		Header: headers,
+		URL: PostmanURL{
+			Raw:  "{{baseUrl}}/" + api.Path,
+			Host: []string{"{{baseUrl}}"},
+			Path: strings.Split(api.Path, "/"),
+		},
+	}
+
+	if api.RawInput {
+		// the body is a file the user picks
+		request.Header = append(request.Header, PostmanHeader{Key: "Content-Type", Value: cmp.Or(api.Spec.RequestContentType, vel.OctetStream)})
+		return request, nil
+	}
+	example := exampleInput(api)
+	if example == nil {
+		return request, nil
+	}
+
+	if api.Method == "GET" {
+		var query []string
+		for _, param := range exampleQuery(api, example) {
+			request.URL.Query = append(request.URL.Query, PostmanQueryParam{Key: param.Key, Value: param.Value})
+			query = append(query, param.Key+"="+param.Value)
+		}
+		if len(query) > 0 {
+			request.URL.Raw += "?" + strings.Join(query, "&")
+		}
+		return request, nil
+	}
+
+	if api.Form {
+		request.Header = append(request.Header, PostmanHeader{Key: "Content-Type", Value: vel.FormURLEncoded})
+		request.Body = &PostmanBody{Mode: "urlencoded"}
+		for _, param := range exampleQuery(api, example) {
+			request.Body.URLEncoded = append(request.Body.URLEncoded, PostmanQueryParam{Key: param.Key, Value: param.Value})
+		}
+		return request, nil
+	}
+
+	if api.InputItems {
+		// an item is a single line of the stream
+		body, err := marshalExample(example, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal the example item: %w", err)
+		}
+		request.Header = append(request.Header, PostmanHeader{Key: "Content-Type", Value: vel.NDJSON})
+		request.Body = &PostmanBody{Mode: "raw", Raw: string(body) + "\n"}
+		return request, nil
+	}
+
+	body, err := marshalExample(example, "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the example request: %w", err)
+	}
+	request.Header = append(request.Header, PostmanHeader{Key: "Content-Type", Value: "application/json"})
+	request.Body = &PostmanBody{Mode: "raw", Raw: string(body)}
+	request.Body.Options.Raw.Language = "json"
+	return request, nil
+}
+
+// GeneratePostmanJSON writes the collection as JSON
+func (g *ClientGen) GeneratePostmanJSON(w io.Writer, config PostmanConfig) error {
+	collection, err := g.GeneratePostmanCollection(config)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(collection)
+}
+
+// GeneratePostman generates a Postman collection of the router and writes it to the provided writer
+func GeneratePostman(router *vel.Router, w io.Writer, config PostmanConfig) error {
+	generator, err := newRouterGen(router, ClientDesc{
+		TypeName:    "Client",
+		PackageName: "client",
+	})
+	if err != nil {
+		return err
+	}
+	return generator.GeneratePostmanJSON(w, config)
+}
+
+// GeneratePostmanToFile generates a Postman collection and writes it to a file
+func GeneratePostmanToFile(router *vel.Router, outputPath string, config PostmanConfig) error {
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(outputPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return GeneratePostman(router, file, config)
+}
@@ -0,0 +1,54 @@
+package gen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/tools/imports"
+)
+
+// PostProcessor formats the generated code, e.g. a Go client is formatted by GoImports
+type PostProcessor func(src []byte) ([]byte, error)
+
+// GoImports formats Go code and adds the missing imports in-process like the goimports tool does
+func GoImports(src []byte) ([]byte, error) {
+	return imports.Process("client.go", src, nil)
+}
+
+// GoFormat formats Go code in-process like the gofmt tool does
+func GoFormat(src []byte) ([]byte, error) {
+	return format.Source(src)
+}
+
+// PostProcessorOf returns the post-processor of a shell command, goimports and gofmt without arguments run in-process,
+// any other command is run by sh -c getting the code on stdin and printing the result, e.g. "prettier --parser typescript"
+// or "npx prettier --parser typescript | sed 's/foo/bar/'". An empty command returns nil, the code is written as generated.
+func PostProcessorOf(command string) PostProcessor {
+	switch command {
+	case "":
+		return nil
+	case "goimports":
+		return GoImports
+	case "gofmt":
+		return GoFormat
+	}
+
+	return func(src []byte) ([]byte, error) {
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Stdin = bytes.NewReader(src)
+		stderr := &bytes.Buffer{}
+		cmd.Stderr = stderr
+		out, err := cmd.Output()
+		if err != nil && stderr.Len() > 0 {
+			return nil, errors.Join(err, fmt.Errorf("%s: %s", command, strings.TrimSpace(stderr.String())))
+		}
+		if err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+}
@@ -0,0 +1,151 @@
+package gen
+
+import (
+	"cmp"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dennypenta/vel"
+)
+
+// The messages of vel.Protobuf handlers are described as protojson encodes them: the fields are named by their JSON names,
+// the 64-bit integers are strings, the enums are the names of their values, a Timestamp is an RFC 3339 time
+// and an Any or a Struct is any JSON. The gen/protobuf package teaches the generator the enums and the well-known types.
+
+// ProtoTypes describes the protobuf types the generator can't tell by their struct tags,
+// the gen/protobuf package registers it by RegisterProtoTypes, so the generator doesn't depend on protobuf
+type ProtoTypes interface {
+	// EnumValues lists the values of a protobuf enum in their order and reports whether the type is one
+	EnumValues(t reflect.Type) ([]ProtoEnumValue, bool)
+	// FillExample sets the value of a well-known type to the example time, e.g. a Timestamp, and reports whether it's one
+	FillExample(v reflect.Value, t time.Time) bool
+	// Marshal encodes the message, a pointer to a generated struct, as protojson
+	Marshal(message any) ([]byte, error)
+}
+
+// ProtoEnumValue is a value of a protobuf enum, see ProtoTypes
+type ProtoEnumValue struct {
+	Name   string
+	Number int32
+}
+
+var (
+	protoTypesMu sync.RWMutex
+	protoTypes   ProtoTypes
+)
+
+// RegisterProtoTypes teaches the generator the protobuf types, see ProtoTypes, call it before generating, e.g. in init
+func RegisterProtoTypes(types ProtoTypes) {
+	protoTypesMu.Lock()
+	defer protoTypesMu.Unlock()
+	protoTypes = types
+}
+
+// registeredProtoTypes returns the types registered by RegisterProtoTypes, nil without them
+func registeredProtoTypes() ProtoTypes {
+	protoTypesMu.RLock()
+	defer protoTypesMu.RUnlock()
+	return protoTypes
+}
+
+// jsonTag is the json tag of the field, a field of a protobuf message is tagged as protojson encodes it, see protoJSONTag
+func jsonTag(field reflect.StructField) string {
+	if tag, ok := protoJSONTag(field); ok {
+		return tag
+	}
+	return field.Tag.Get("json")
+}
+
+// protoJSONTag tags the field of a protobuf message by its JSON name, the zero values are left out
+// and the 64-bit integers are quoted. It reports false for a field of any other struct.
+// A oneof field has no name of its own, it's left out.
+func protoJSONTag(field reflect.StructField) (string, bool) {
+	if _, ok := field.Tag.Lookup("protobuf_oneof"); ok {
+		return "-", true
+	}
+	tag, ok := field.Tag.Lookup("protobuf")
+	if !ok {
+		return "", false
+	}
+	var name, jsonName string
+	for part := range strings.SplitSeq(tag, ",") {
+		if value, ok := strings.CutPrefix(part, "name="); ok {
+			name = value
+		} else if value, ok := strings.CutPrefix(part, "json="); ok {
+			jsonName = value
+		}
+	}
+	// protoc-gen-go writes the JSON name only if it differs from the name
+	jsonTag := cmp.Or(jsonName, name) + ",omitempty"
+	t := field.Type
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Int64 || t.Kind() == reflect.Uint64 {
+		jsonTag += ",string"
+	}
+	return jsonTag, true
+}
+
+// protoEnumValues lists the names of the values of a protobuf enum and reports whether the type is one,
+// the constants of the Go client drop the prefix of the names repeating the type, e.g. StatusActive of STATUS_ACTIVE
+func protoEnumValues(t reflect.Type) ([]EnumValue, bool) {
+	types := registeredProtoTypes()
+	if types == nil {
+		return nil, false
+	}
+	enum, ok := types.EnumValues(t)
+	if !ok {
+		return nil, false
+	}
+	values := make([]EnumValue, len(enum))
+	for i, value := range enum {
+		label := pascalCase(value.Name)
+		if trimmed := strings.TrimPrefix(label, typeName(t)); trimmed != "" {
+			label = trimmed
+		}
+		values[i] = EnumValue{
+			Const:   typeName(t) + label,
+			Value:   value.Name,
+			Literal: strconv.Quote(value.Name),
+		}
+	}
+	return values, true
+}
+
+// protoEnumExample is the number of the value of a protobuf enum an example gets,
+// the first one after the zero value which protojson leaves out
+func protoEnumExample(t reflect.Type) (int32, bool) {
+	types := registeredProtoTypes()
+	if types == nil {
+		return 0, false
+	}
+	enum, ok := types.EnumValues(t)
+	if !ok || len(enum) == 0 {
+		return 0, ok
+	}
+	return enum[min(1, len(enum)-1)].Number, true
+}
+
+// fillProtoExample sets the value of a well-known type like a Timestamp, see ProtoTypes.FillExample
+func fillProtoExample(v reflect.Value) bool {
+	types := registeredProtoTypes()
+	return types != nil && types.FillExample(v, exampleTime)
+}
+
+// protoMessage returns the protobuf message of an example, the examples are the structs of the messages
+func protoMessage(example any) (any, bool) {
+	v := reflect.ValueOf(example)
+	if !v.IsValid() || v.Kind() != reflect.Struct {
+		return nil, false
+	}
+	if _, ok := vel.ProtoMessageType(reflect.PointerTo(v.Type())); !ok || registeredProtoTypes() == nil {
+		return nil, false
+	}
+	ptr := reflect.New(v.Type())
+	ptr.Elem().Set(v)
+	return ptr.Interface(), true
+}
@@ -0,0 +1,76 @@
+// Package protobuf teaches the generator the protobuf messages of google.golang.org/protobuf, so gen doesn't depend on protobuf.
+// The program generating the clients imports it for the side effect, it registers the messages of vel as well, see velproto:
+//
+//	import _ "github.com/dennypenta/vel/gen/protobuf"
+//
+// The enums are described by the names of their values, a Timestamp as an RFC 3339 time,
+// an Any, a Struct, a Value or a ListValue as any JSON the clients pass as json.RawMessage.
+package protobuf
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/dennypenta/vel/gen"
+	_ "github.com/dennypenta/vel/velproto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var enumType = reflect.TypeFor[protoreflect.Enum]()
+
+// dynamicTypes are the well-known types holding any JSON
+var dynamicTypes = []reflect.Type{
+	reflect.TypeFor[anypb.Any](),
+	reflect.TypeFor[structpb.Struct](),
+	reflect.TypeFor[structpb.Value](),
+	reflect.TypeFor[structpb.ListValue](),
+}
+
+func init() {
+	gen.RegisterTypeMapping(reflect.TypeFor[timestamppb.Timestamp](), gen.Mapping{
+		GoType:        "time.Time",
+		TSType:        "string",
+		ZodType:       "z.string()",
+		OpenAPISchema: &gen.OpenAPISchema{Type: "string", Format: "date-time"},
+	})
+	for _, t := range dynamicTypes {
+		gen.RegisterTypeMapping(t, gen.Mapping{GoType: "json.RawMessage"})
+	}
+	gen.RegisterProtoTypes(types{})
+}
+
+// types is the gen.ProtoTypes of the types generated by protoc-gen-go
+type types struct{}
+
+func (types) EnumValues(t reflect.Type) ([]gen.ProtoEnumValue, bool) {
+	if !t.Implements(enumType) {
+		return nil, false
+	}
+	descriptors := reflect.Zero(t).Interface().(protoreflect.Enum).Descriptor().Values()
+	values := make([]gen.ProtoEnumValue, descriptors.Len())
+	for i := range values {
+		values[i] = gen.ProtoEnumValue{
+			Name:   string(descriptors.Get(i).Name()),
+			Number: int32(descriptors.Get(i).Number()),
+		}
+	}
+	return values, true
+}
+
+func (types) FillExample(v reflect.Value, t time.Time) bool {
+	if v.Type() != reflect.TypeFor[timestamppb.Timestamp]() {
+		return false
+	}
+	v.FieldByName("Seconds").SetInt(t.Unix())
+	v.FieldByName("Nanos").SetInt(int64(t.Nanosecond()))
+	return true
+}
+
+func (types) Marshal(message any) ([]byte, error) {
+	return protojson.Marshal(message.(proto.Message))
+}
@@ -0,0 +1,92 @@
+package protobuf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dennypenta/vel"
+	"github.com/dennypenta/vel/gen"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/apipb"
+)
+
+func TestProtoClient(t *testing.T) {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "describe", func(ctx context.Context, req *apipb.Method) (*apipb.Method, *vel.Error) {
+		return req, nil
+	})
+	vel.RegisterPost(router, "interpret", func(ctx context.Context, req *descriptorpb.UninterpretedOption) (*descriptorpb.UninterpretedOption, *vel.Error) {
+		return req, nil
+	})
+	gener, err := gen.New(gen.ClientDesc{TypeName: "Client", PackageName: "client", MsgPack: true, Examples: true}, router.Meta())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec, err := gener.GenerateOpenAPI("Test API", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := spec.Paths["/describe"].Post.RequestBody.Content
+	if content.ApplicationJSON == nil || content.Raw[vel.Protobuf] == nil {
+		t.Fatalf("expected the JSON and the protobuf request bodies, got %+v", content)
+	}
+	if spec.Paths["/describe"].Post.Responses["200"].Content.Raw[vel.Protobuf] == nil {
+		t.Error("expected the protobuf response")
+	}
+	// the example is protojson, the zero values are left out
+	example, _ := json.Marshal(content.ApplicationJSON.Example)
+	if !strings.Contains(string(example), `"requestTypeUrl":`) || !strings.Contains(string(example), `"syntax":"SYNTAX_PROTO3"`) {
+		t.Errorf("expected the protojson example, got %s", example)
+	}
+	schema := spec.Components.Schemas["Method"]
+	if _, ok := schema.Properties["requestTypeUrl"]; !ok {
+		t.Errorf("expected the protojson names, got %v", slices.Sorted(maps.Keys(schema.Properties)))
+	}
+	if enum := fmt.Sprint(spec.Components.Schemas["Syntax"].Enum); enum != "[SYNTAX_PROTO2 SYNTAX_PROTO3 SYNTAX_EDITIONS]" {
+		t.Errorf("expected the names of the values, got %s", enum)
+	}
+
+	for _, tc := range []struct {
+		lang     string
+		expected []string
+	}{
+		{"go:default", []string{
+			"RequestTypeUrl string `json:\"requestTypeUrl,omitempty\"`",
+			"PositiveIntValue *uint64 `json:\"positiveIntValue,omitempty,string\"`",
+			"type Syntax string",
+			`SyntaxProto3 Syntax = "SYNTAX_PROTO3"`,
+			// a message is protojson, it's not negotiated as MessagePack
+			"bodyBytes, err := json.Marshal(req)",
+		}},
+		{"ts:default", []string{
+			"requestTypeUrl?: string",
+			"positiveIntValue?: string",
+			"export type Syntax = 'SYNTAX_PROTO2' | 'SYNTAX_PROTO3' | 'SYNTAX_EDITIONS'",
+		}},
+	} {
+		t.Run(tc.lang, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			if err := gener.GenerateWith(buf, tc.lang, nil); err != nil {
+				t.Fatal(err)
+			}
+			for _, expected := range tc.expected {
+				if !strings.Contains(buf.String(), expected) {
+					t.Errorf("expected the client to contain %q", expected)
+				}
+			}
+			// a oneof and the internal fields of a message aren't described
+			for _, unexpected := range []string{"sizeCache", "unknownFields", "msgpack.Marshal(req)"} {
+				if strings.Contains(buf.String(), unexpected) {
+					t.Errorf("expected the client not to contain %q", unexpected)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package gen
+
+import (
+	"cmp"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// QueryParam is a parameter of a GET query. The fields of the nested structs are flattened to dotted keys,
+// e.g. filter.status, the way the router decodes them.
+type QueryParam struct {
+	// Key is the key of the parameter in the query
+	Key string
+	// Field is the field the parameter is decoded into
+	Field Field
+	// GoExpr and TSExpr read the value from the request, e.g. req.Filter.Status and req.Filter?.status
+	GoExpr string
+	TSExpr string
+	// GoGuard checks the pointers leading to the value are set, e.g. req.Filter != nil, empty if there are none
+	GoGuard string
+	// Deref is set for a pointer value, it's sent dereferenced
+	Deref bool
+	// Repeated is set for a slice, every item is sent under the key
+	Repeated bool
+	// Optional is set for the pointers and the slices and the fields of the optional structs, they may be left out
+	Optional bool
+
+	// names is the path of the field names from the input
+	names []string
+}
+
+// queryParams flattens the fields of a GET input tagged by schema, a recursive struct is flattened once
+func queryParams(fields []Field) []QueryParam {
+	var params []QueryParam
+	var walk func(fields []Field, parent QueryParam, path map[reflect.Type]bool)
+	walk = func(fields []Field, parent QueryParam, path map[reflect.Type]bool) {
+		for _, field := range fields {
+			name, options, _ := strings.Cut(field.SchemaTag, ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			param := QueryParam{
+				Key:      name,
+				Field:    field,
+				GoExpr:   "req." + field.Name,
+				TSExpr:   "req" + tsAccess(field.TSKey, false),
+				GoGuard:  parent.GoGuard,
+				Optional: parent.Optional,
+				names:    append(slices.Clone(parent.names), field.Name),
+			}
+			if parent.Key != "" {
+				param.Key = parent.Key + "." + name
+				param.GoExpr = parent.GoExpr + "." + field.Name
+				param.TSExpr = parent.TSExpr + tsAccess(field.TSKey, parent.Field.Optional)
+			}
+
+			t := field.Type
+			pointer := t.Kind() == reflect.Pointer
+			omitted := hasTagOption(options, "omitempty")
+			if pointer {
+				t = t.Elem()
+				param.GoGuard = guardJoin(param.GoGuard, param.GoExpr+" != nil")
+			}
+			param.Optional = param.Optional || pointer || omitted
+			if nestedQuery(t) {
+				if !path[t] {
+					path[t] = true
+					walk(structFields(t, cmp.Or(typeName(t), field.inline)), param, path)
+					delete(path, t)
+				}
+				continue
+			}
+			param.Deref = pointer
+			param.Repeated = t.Kind() == reflect.Slice
+			param.Optional = param.Optional || param.Repeated
+			if omitted && !pointer {
+				// the zero value is left out like the server leaves it out when it's empty
+				if check := nonZero(t, param.GoExpr); check != "" {
+					param.GoGuard = guardJoin(param.GoGuard, check)
+				}
+			}
+			params = append(params, param)
+		}
+	}
+	walk(fields, QueryParam{}, make(map[reflect.Type]bool))
+	return params
+}
+
+// nestedQuery reports whether the fields of the struct are the parameters, the mapped types and the ones
+// parsed from a string are the values themselves
+func nestedQuery(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	_, mapped := mappingOf(t)
+	return !mapped && !reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
+
+// tsAccess reads the property of a TS object, optional chaining is used for an object that may be missing
+func tsAccess(key string, optional bool) string {
+	chain := ""
+	if optional {
+		chain = "?."
+	}
+	if strings.HasPrefix(key, "'") {
+		return chain + "[" + key + "]"
+	}
+	return cmp.Or(chain, ".") + key
+}
+
+// nonZero checks the value of the type isn't zero, empty if the client can't check it
+func nonZero(t reflect.Type, expr string) string {
+	// a mapped type is another one in the client unless it's mapped to itself, e.g. time.Time
+	mapping, mapped := mappingOf(t)
+	if _, ok := t.MethodByName("IsZero"); ok && (!mapped || mapping.GoType == t.String()) {
+		return "!" + expr + ".IsZero()"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return expr + ` != ""`
+	case reflect.Bool:
+		return expr
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return expr + " != 0"
+	}
+	return ""
+}
+
+func guardJoin(guard, cond string) string {
+	if guard == "" {
+		return cond
+	}
+	return guard + " && " + cond
+}
+
+// value reads the value of the parameter from the input, it's invalid if a pointer on the way is nil
+func (p QueryParam) value(input reflect.Value) reflect.Value {
+	v := input
+	for _, name := range p.names {
+		v = reflect.Indirect(v)
+		if !v.IsValid() || v.Kind() != reflect.Struct {
+			return reflect.Value{}
+		}
+		v = v.FieldByName(name)
+	}
+	if p.Deref {
+		v = reflect.Indirect(v)
+	}
+	return v
+}
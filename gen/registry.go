@@ -13,6 +13,9 @@ var goTemplate string
 //go:embed templates/ts.tpl
 var tsTemplate string
 
+//go:embed templates/headers.tpl
+var headersTemplate string
+
 var templateRegistry map[string]*template.Template
 
 func init() {
@@ -29,6 +32,12 @@ func init() {
 		panic("failed to registry ts template: " + err.Error())
 	}
 	templateRegistry["ts:default"] = tplTs
+
+	tplHeaders, err := template.New("headersTemplate").Parse(headersTemplate)
+	if err != nil {
+		panic("failed to registry headers template: " + err.Error())
+	}
+	templateRegistry["go:headers"] = tplHeaders
 }
 
 func RegisterTemplate(name string, tpl *template.Template) {
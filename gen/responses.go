@@ -0,0 +1,280 @@
+package gen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dennypenta/vel"
+)
+
+// ResponseMismatchCode is the error replacing a response not matching the spec when ResponseValidationConfig.Fail is set
+const ResponseMismatchCode = "RESPONSE_MISMATCH"
+
+// ResponseValidationConfig configures ValidateResponses
+type ResponseValidationConfig struct {
+	// Logger reports the mismatches, slog.Default() by default
+	Logger *slog.Logger
+	// Fail replaces a mismatching response with a 500 RESPONSE_MISMATCH error, so an integration test can't miss it
+	Fail bool
+}
+
+// velErrorCodes are the errors vel responds with before or after the handler, they aren't documented
+var velErrorCodes = []string{"FAILED_DECODING_QUERY", "FAILED_DECODING_REQUEST_BODY", "FAILED_ENCODING_RESPONSE_BODY"}
+
+// responseOperation is the documentation of an operation the responses are checked against
+type responseOperation struct {
+	id        string
+	responses map[string]*OpenAPIResponse
+	// codePath is the path of the error code in an error response
+	codePath []string
+}
+
+// ValidateResponses returns a middleware checking the responses of the router operations against the schemas
+// of its OpenAPI spec: a successful status must be documented and a JSON body must conform to the schema of its status,
+// e.g. a missing required field or an error code the spec doesn't declare is a mismatch.
+// It's meant for development and integration tests, a response is buffered before it's written.
+// The spec is built on the first request, so the middleware may be used before the routes are registered,
+// e.g. router.Use(gen.ValidateResponses(router, gen.ResponseValidationConfig{Fail: true})), or wrap the mux.
+// The streams and the requests of other handlers aren't checked.
+func ValidateResponses(router *vel.Router, config ResponseValidationConfig) func(http.Handler) http.Handler {
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	type documented struct {
+		operations map[string]responseOperation
+		components map[string]*OpenAPISchema
+	}
+	load := sync.OnceValues(func() (documented, error) {
+		g, err := newRouterGen(router, ClientDesc{
+			TypeName:    "Client",
+			PackageName: "client",
+			ErrorSchema: router.ErrorEncoder().Schema(),
+		})
+		if err != nil {
+			return documented{}, err
+		}
+		spec, err := g.GenerateOpenAPI("", "")
+		if err != nil {
+			return documented{}, err
+		}
+		doc := documented{operations: make(map[string]responseOperation), components: spec.Components.Schemas}
+		codePath := errorCodePath(g.meta.ErrorShape)
+		for _, api := range g.meta.Apis {
+			if api.Spec.Stream != "" {
+				continue
+			}
+			item := spec.Paths["/"+api.Path]
+			operation := item.Post
+			if api.Method == "GET" {
+				operation = item.Get
+			}
+			if api.Superseded {
+				operation = operation.Versions[api.Version]
+			}
+			key := api.Method + " " + router.Prefix() + "/" + api.Path
+			if api.Version != "" {
+				// the response is checked against the version it's served by, see vel.APIVersionHeader
+				doc.operations[key+" "+api.Version] = responseOperation{id: api.OperationID, responses: operation.Responses, codePath: codePath}
+			}
+			if !api.Superseded {
+				doc.operations[key] = responseOperation{id: api.OperationID, responses: operation.Responses, codePath: codePath}
+			}
+		}
+		return doc, nil
+	})
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			doc, err := load()
+			if err != nil {
+				logger.ErrorContext(r.Context(), "failed to build the spec the responses are validated against", "err", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			operation, ok := doc.operations[r.Method+" "+r.URL.Path]
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			buf := &responseBuffer{header: w.Header(), status: http.StatusOK}
+			next.ServeHTTP(buf, r)
+			if version := buf.header.Get(vel.APIVersionHeader); version != "" {
+				if versioned, ok := doc.operations[r.Method+" "+r.URL.Path+" "+version]; ok {
+					operation = versioned
+				}
+			}
+
+			if err := checkResponse(operation, buf.status, buf.body.Bytes(), doc.components); err != nil {
+				logger.ErrorContext(r.Context(), "response doesn't match the spec", "operation", operation.id, "status", buf.status, "err", err)
+				if config.Fail {
+					w.Header().Del("Content-Length")
+					velErr := &vel.Error{Code: ResponseMismatchCode, Message: fmt.Sprintf("%s responded with status %d: %v", operation.id, buf.status, err)}
+					if err := router.ErrorEncoder().EncodeError(w, r, http.StatusInternalServerError, velErr); err != nil {
+						logger.ErrorContext(r.Context(), "failed to write error response", "err", err, "code", velErr.Code)
+					}
+					return
+				}
+			}
+			w.WriteHeader(buf.status)
+			w.Write(buf.body.Bytes())
+		})
+	}
+}
+
+// responseBuffer holds the response until it's checked, the headers are written to the underlying writer
+type responseBuffer struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) WriteHeader(status int) {
+	if !b.wroteHeader {
+		b.status, b.wroteHeader = status, true
+	}
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	b.wroteHeader = true
+	return b.body.Write(p)
+}
+
+// checkResponse checks the body against the schema of its status, an undocumented error status isn't checked:
+// vel doesn't document the decoding errors and a middleware may reject a request, e.g. with 401
+func checkResponse(operation responseOperation, status int, body []byte, components map[string]*OpenAPISchema) error {
+	response, ok := operation.responses[strconv.Itoa(status)]
+	if !ok {
+		if status < http.StatusBadRequest {
+			return fmt.Errorf("status %d isn't documented", status)
+		}
+		return nil
+	}
+	if response.Content == nil || response.Content.ApplicationJSON == nil || len(body) == 0 {
+		return nil
+	}
+
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("the body isn't JSON: %w", err)
+	}
+	if status >= http.StatusBadRequest && slices.Contains(velErrorCodes, errorCode(value, operation.codePath)) {
+		return nil
+	}
+	return conforms(response.Content.ApplicationJSON.Schema, value, "body", components)
+}
+
+// errorCode reads the error code of a response
+func errorCode(value any, path []string) string {
+	for _, key := range path {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return ""
+		}
+		value = object[key]
+	}
+	code, _ := value.(string)
+	return code
+}
+
+// conforms checks a value decoded with UseNumber against the schema, it's the check of the generated contract tests
+func conforms(schema *OpenAPISchema, value any, path string, components map[string]*OpenAPISchema) error {
+	if schema == nil {
+		return nil
+	}
+	if schema.Ref != "" {
+		target, ok := components[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", path, schema.Ref)
+		}
+		return conforms(target, value, path, components)
+	}
+	if value == nil {
+		// encoding/json writes nil pointers, slices and maps as null
+		return nil
+	}
+	if schema.Discriminator != nil {
+		// a union conforms to the schema its discriminator maps to
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: %v isn't an object", path, value)
+		}
+		kind, _ := object[schema.Discriminator.PropertyName].(string)
+		ref, ok := schema.Discriminator.Mapping[kind]
+		if !ok {
+			return fmt.Errorf("%s.%s: %v isn't one of %v", path, schema.Discriminator.PropertyName, object[schema.Discriminator.PropertyName], slices.Sorted(maps.Keys(schema.Discriminator.Mapping)))
+		}
+		return conforms(&OpenAPISchema{Ref: ref}, value, path, components)
+	}
+	if len(schema.Enum) > 0 && !slices.ContainsFunc(schema.Enum, func(v any) bool { return fmt.Sprint(v) == fmt.Sprint(value) }) {
+		return fmt.Errorf("%s: %v isn't one of %v", path, value, schema.Enum)
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: %v isn't an object", path, value)
+		}
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s.%s is missing", path, name)
+			}
+		}
+		for _, key := range slices.Sorted(maps.Keys(object)) {
+			property, ok := schema.Properties[key]
+			if !ok {
+				property = schema.AdditionalProperties
+			}
+			if property == nil && len(schema.Properties) > 0 {
+				return fmt.Errorf("%s.%s isn't documented", path, key)
+			}
+			if err := conforms(property, object[key], path+"."+key, components); err != nil {
+				return err
+			}
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s: %v isn't an array", path, value)
+		}
+		for i, item := range items {
+			if err := conforms(schema.Items, item, fmt.Sprintf("%s[%d]", path, i), components); err != nil {
+				return err
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s: %v isn't a string", path, value)
+		}
+	case "integer":
+		if n, ok := value.(json.Number); !ok || strings.ContainsAny(string(n), ".eE") {
+			return fmt.Errorf("%s: %v isn't an integer", path, value)
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			return fmt.Errorf("%s: %v isn't a number", path, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: %v isn't a boolean", path, value)
+		}
+	}
+	return nil
+}
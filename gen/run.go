@@ -0,0 +1,85 @@
+package gen
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/dennypenta/vel"
+)
+
+// AudienceOutput describes the documents generated for an audience
+type AudienceOutput struct {
+	Audience vel.Audience
+	// OpenAPIPath is the path of the spec file, empty skips the spec
+	OpenAPIPath string
+	Title       string
+	Version     string
+	// OperationIDCase converts the operation ids of the spec
+	OperationIDCase OperationIDCase
+	// CodeSamplesURL attaches curl and HTTPie calls to the operations of the spec, see OpenAPIConfig
+	CodeSamplesURL string
+	// Examples attaches example requests and responses to the operations of the spec, see OpenAPIConfig
+	Examples bool
+	Clients  []ClientGeneratorConfig
+}
+
+// Run generates the specs and clients of every audience in one pass.
+// The handlers are described once and filtered per audience,
+// so a type has the same schema name in every document it appears in.
+func Run(router *vel.Router, outputs ...AudienceOutput) error {
+	meta, apis, groups, err := routerApis(router)
+	if err != nil {
+		return err
+	}
+	hash := specHash(apis)
+
+	for _, out := range outputs {
+		if out.Audience == "" {
+			return errors.New("audience of the output is not set")
+		}
+
+		visibleMeta := make([]vel.HandlerMeta, 0, len(meta))
+		visibleApis := make([]ApiDesc, 0, len(apis))
+		for i := range meta {
+			if meta[i].Spec.VisibleTo(out.Audience) {
+				visibleMeta = append(visibleMeta, meta[i])
+				visibleApis = append(visibleApis, apis[i])
+			}
+		}
+
+		if out.OpenAPIPath != "" {
+			generator := assemble(ClientDesc{
+				TypeName:        "Client",
+				PackageName:     "client",
+				ErrorSchema:     router.ErrorEncoder().Schema(),
+				OperationIDCase: out.OperationIDCase,
+				CodeSamplesURL:  out.CodeSamplesURL,
+				Examples:        out.Examples,
+			}, visibleMeta, visibleApis, groups, hash)
+			// the webhooks are sent to the receivers of every audience
+			generator.webhooks = router.Webhooks()
+			if err := writeOpenAPI(generator, out); err != nil {
+				return fmt.Errorf("%s openapi: %w", out.Audience, err)
+			}
+		}
+
+		for _, config := range out.Clients {
+			generator := assemble(clientDesc(router, config), visibleMeta, visibleApis, groups, hash)
+			if err := writeClient(generator, config); err != nil {
+				return fmt.Errorf("%s %s client: %w", out.Audience, config.Language, err)
+			}
+		}
+	}
+	return nil
+}
+
+func writeOpenAPI(generator *ClientGen, out AudienceOutput) error {
+	file, err := os.OpenFile(out.OpenAPIPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return generator.GenerateOpenAPIYAML(file, out.Title, out.Version)
+}
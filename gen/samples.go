@@ -0,0 +1,176 @@
+package gen
+
+import (
+	"cmp"
+	"encoding"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/dennypenta/vel"
+)
+
+// OpenAPICodeSample is an entry of the x-codeSamples extension rendered by Redoc and other viewers
+type OpenAPICodeSample struct {
+	Lang   string `yaml:"lang"`
+	Label  string `yaml:"label"`
+	Source string `yaml:"source"`
+}
+
+// queryParam is a query parameter of an example request
+type queryParam struct {
+	Key   string
+	Value string
+}
+
+// exampleInput is the first example declared in the spec or a generated one, nil if the api has no input or a raw one
+func exampleInput(api ApiDesc) any {
+	if api.Input.Name == "" || api.RawInput {
+		return nil
+	}
+	if len(api.Spec.Examples) > 0 {
+		return api.Spec.Examples[0].Request
+	}
+	if api.input != nil {
+		return Example(api.input)
+	}
+	return nil
+}
+
+// exampleQuery lists the query parameters of the example of a GET api or the fields of a form in the order of the input fields,
+// the items of a slice are repeated parameters
+func exampleQuery(api ApiDesc, example any) []queryParam {
+	value := reflect.Indirect(reflect.ValueOf(example))
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+	var params []queryParam
+	if api.Method != "GET" {
+		for _, field := range api.Input.Fields {
+			if field.SchemaTag == "" {
+				continue
+			}
+			params = append(params, queryParam{Key: field.SchemaTag, Value: exampleQueryValue(value.FieldByName(field.Name))})
+		}
+		return params
+	}
+	for _, param := range api.QueryParams {
+		v := param.value(value)
+		if !v.IsValid() || (v.Kind() == reflect.Pointer && v.IsNil()) {
+			continue
+		}
+		if !param.Repeated {
+			params = append(params, queryParam{Key: param.Key, Value: exampleQueryValue(v)})
+			continue
+		}
+		for i := range v.Len() {
+			params = append(params, queryParam{Key: param.Key, Value: exampleQueryValue(v.Index(i))})
+		}
+	}
+	return params
+}
+
+// exampleQueryValue formats a query parameter the way the Go client does
+func exampleQueryValue(v reflect.Value) string {
+	if !v.IsValid() {
+		return ""
+	}
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		if text, err := m.MarshalText(); err == nil {
+			return string(text)
+		}
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// codeSamples renders curl and HTTPie calls of the api, the headers declared in the spec are read from environment variables,
+// e.g. X-Tenant from $X_TENANT
+func codeSamples(api ApiDesc, baseURL string) ([]*OpenAPICodeSample, error) {
+	target := strings.TrimSuffix(baseURL, "/") + "/" + api.Path
+	example := exampleInput(api)
+
+	var headers []string
+	if h := api.Spec.RequestHeaders; h.Key != "" {
+		name, _ := OperationIDSnake.Apply(h.Key)
+		headers = append(headers, h.Key+": $"+strings.ToUpper(name))
+	}
+	if api.Version != "" {
+		headers = append(headers, vel.AcceptVersionHeader+": "+api.Version)
+	}
+
+	var body string
+	contentType := "application/json"
+	if api.Method == "GET" {
+		var query []string
+		for _, param := range exampleQuery(api, example) {
+			query = append(query, url.QueryEscape(param.Key)+"="+url.QueryEscape(param.Value))
+		}
+		if len(query) > 0 {
+			target += "?" + strings.Join(query, "&")
+		}
+	} else if api.Form && example != nil {
+		form := url.Values{}
+		for _, param := range exampleQuery(api, example) {
+			form.Set(param.Key, param.Value)
+		}
+		body, contentType = form.Encode(), vel.FormURLEncoded
+	} else if api.InputItems && example != nil {
+		// an item is a single line of the stream
+		data, err := marshalExample(example, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal the example item of %s: %w", api.OperationID, err)
+		}
+		body, contentType = string(data), vel.NDJSON
+	} else if example != nil {
+		data, err := marshalExample(example, "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal the example request of %s: %w", api.OperationID, err)
+		}
+		body = string(data)
+	}
+
+	curl := []string{"curl"}
+	if api.Spec.Stream != "" {
+		// print the items as they come
+		curl = append(curl, "-N")
+	}
+	if api.Method != "GET" {
+		curl = append(curl, "-X", api.Method)
+	}
+	curl = append(curl, shellQuote(target))
+	httpie := []string{"http"}
+	if api.Spec.Stream != "" {
+		httpie = append(httpie, "--stream")
+	}
+	httpie = append(httpie, api.Method, shellQuote(target))
+	for _, h := range headers {
+		// double quotes expand the variable
+		curl = append(curl, "\\\n  -H \""+h+"\"")
+		key, value, _ := strings.Cut(h, ": ")
+		httpie = append(httpie, "\""+key+":"+value+"\"")
+	}
+	if body != "" {
+		curl = append(curl, "\\\n  -H 'Content-Type: "+contentType+"'", "\\\n  -d "+shellQuote(body))
+		if api.Form || api.InputItems {
+			httpie = append(httpie, "\"Content-Type:"+contentType+"\"")
+		}
+		httpie = append(httpie, "\\\n  --raw "+shellQuote(body))
+	}
+	if api.RawInput {
+		// the raw body is read from a file
+		contentType := cmp.Or(api.Spec.RequestContentType, vel.OctetStream)
+		curl = append(curl, "\\\n  -H 'Content-Type: "+contentType+"'", "\\\n  --data-binary @body")
+		httpie = append(httpie, "\"Content-Type:"+contentType+"\"", "\\\n  < body")
+	}
+
+	return []*OpenAPICodeSample{
+		{Lang: "Shell", Label: "curl", Source: strings.Join(curl, " ")},
+		{Lang: "Shell", Label: "HTTPie", Source: strings.Join(httpie, " ")},
+	}, nil
+}
+
+// shellQuote quotes a single argument of a shell command
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
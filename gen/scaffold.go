@@ -0,0 +1,105 @@
+package gen
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"go/format"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/scaffold
+var scaffoldFS embed.FS
+
+// ScaffoldConfig describes the service created by Scaffold
+type ScaffoldConfig struct {
+	// Name is the name of the service, e.g. billing, it names the binary and the spec
+	Name string
+	// Module is the module path of the service, the name if empty
+	Module string
+	// Dir is the directory the project is written to, the name if empty
+	Dir string
+}
+
+// Scaffold writes the skeleton of a new service: the router with an example handler and its Spec,
+// vel.yaml declaring the clients and the spec, a test serving the router by httptest
+// and a Makefile with run, build, test and gen targets a container build can call.
+// It returns the written paths, the files existing in the directory are never overwritten.
+func Scaffold(config ScaffoldConfig) ([]string, error) {
+	if config.Name == "" || strings.ContainsAny(config.Name, `/\ `) {
+		return nil, fmt.Errorf("service name %q must be a single path element", config.Name)
+	}
+	if config.Module == "" {
+		config.Module = config.Name
+	}
+	if config.Dir == "" {
+		config.Dir = config.Name
+	}
+
+	files, err := renderScaffold(config)
+	if err != nil {
+		return nil, err
+	}
+	for name := range files {
+		if _, err := os.Stat(filepath.Join(config.Dir, name)); err == nil {
+			return nil, fmt.Errorf("%s already exists", filepath.Join(config.Dir, name))
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	written := make([]string, 0, len(files))
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		target := filepath.Join(config.Dir, name)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return written, err
+		}
+		if err := os.WriteFile(target, files[name], 0644); err != nil {
+			return written, err
+		}
+		written = append(written, target)
+	}
+	return written, nil
+}
+
+// renderScaffold renders the templates of the project, the keys are the slash-separated paths of the files
+func renderScaffold(config ScaffoldConfig) (map[string][]byte, error) {
+	const root = "templates/scaffold"
+	files := make(map[string][]byte)
+	err := fs.WalkDir(scaffoldFS, root, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		source, err := scaffoldFS.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		tpl, err := template.New(path.Base(name)).Parse(string(source))
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := tpl.Execute(&buf, config); err != nil {
+			return err
+		}
+
+		out := strings.TrimSuffix(strings.TrimPrefix(name, root+"/"), ".tpl")
+		content := buf.Bytes()
+		if strings.HasSuffix(out, ".go") {
+			if content, err = format.Source(content); err != nil {
+				return fmt.Errorf("failed to format %s: %w", out, err)
+			}
+		}
+		files[out] = content
+		return nil
+	})
+	return files, err
+}
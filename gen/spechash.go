@@ -0,0 +1,64 @@
+package gen
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dennypenta/vel"
+)
+
+// SpecHash identifies the API of the router, the generated TS clients send the hash of the router they're made of.
+// Serve it with vel.SpecHashMiddleware to tell the outdated clients.
+func SpecHash(router *vel.Router) (string, error) {
+	_, apis, _, err := routerApis(router)
+	if err != nil {
+		return "", err
+	}
+	return specHash(apis), nil
+}
+
+// specHash covers the wire contract of the apis: methods, paths, types and errors.
+// Descriptions don't affect it, so documenting a route doesn't make the clients outdated.
+func specHash(apis []ApiDesc) string {
+	var lines []string
+	types := make(map[string]bool)
+	for _, api := range apis {
+		route := api.Method + " " + api.Path
+		lines = append(lines, fmt.Sprintf("%s in=%s out=%s validated=%t", route, api.Input.Name, api.Output.Name, api.Validated))
+		if api.Spec.Stream != "" {
+			lines = append(lines, route+" stream "+string(api.Spec.Stream))
+		}
+		for _, e := range api.Errors {
+			lines = append(lines, route+" error "+strconv.Itoa(e.Status)+" "+e.Code)
+		}
+		for _, dataType := range api.DataTypes {
+			if types[dataType.Name] {
+				continue
+			}
+			types[dataType.Name] = true
+			if dataType.Primitive != "" {
+				enum := make([]string, len(dataType.Enum))
+				for i, value := range dataType.Enum {
+					enum[i] = fmt.Sprint(value.Value)
+				}
+				lines = append(lines, fmt.Sprintf("type %s %s enum=%q", dataType.Name, dataType.Primitive, enum))
+			}
+			if dataType.Union != nil {
+				for _, variant := range dataType.Union.Variants {
+					lines = append(lines, fmt.Sprintf("type %s %s %s=%q", dataType.Name, variant.Name, dataType.Union.Discriminator, variant.Value))
+				}
+			}
+			for _, field := range dataType.Fields {
+				lines = append(lines, fmt.Sprintf("type %s %s json=%q schema=%q %s", dataType.Name, field.Name, field.JsonTag, field.SchemaTag, field.TypeName))
+			}
+		}
+	}
+	slices.Sort(lines)
+
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
@@ -1,30 +1,119 @@
 package {{ .Client.PackageName }}
-
+{{ if eq .File "types" }}
+{{- if or .Imports .Unions }}
+import (
+	{{- if .Unions }}
+	"encoding/json"
+	"fmt"
+	{{- end }}
+	{{- range .Imports }}
+	"{{ . }}"
+	{{- end }}
+)
+{{ end }}
+{{- range .Apis }}
+{{- template "dataTypes" . }}
+{{- end }}
+{{- else if eq .File "errors" }}
+{{ template "errors" . }}
+{{- else }}
 import (
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"math/rand/v2"
 	"net/http"
-)
+	{{- if .Client.MsgPack }}
+	"mime"
 
+	"github.com/dennypenta/vel/msgpack"
+	{{- end }}
+	{{- if ne .File "client" }}
+	{{- range .Imports }}
+	"{{ . }}"
+	{{- end }}
+	{{- end }}
+)
+{{ if .Headers }}
+// Headers declared in the API specs.
+const (
+	{{- range .Headers }}
+	{{ .Name }} = "{{ .Key }}"
+	{{- end }}
+)
+{{ end }}
 type {{ .Client.TypeName }} struct {
 	client *http.Client
 
-	baseUrl string
-	headers http.Header
+	baseUrl      string
+	headers      http.Header
+	interceptors []Interceptor
+	retry        *RetryPolicy
+	hooks        Hooks
+	cache        Cache
+	validators   *validatorCache
+	{{- with .GroupsOf .Client.TypeName }}
+{{ range . }}
+	{{ .Name }} *{{ .TypeName }}
+	{{- end }}
+	{{- end }}
 }
 
+// RoundTripFunc sends a request and returns its response, see Interceptor.
+type RoundTripFunc func(r *http.Request) (*http.Response, error)
+
+// Interceptor wraps every call made by the client,
+// it may modify the request, inspect the response or skip calling next entirely.
+type Interceptor func(r *http.Request, next RoundTripFunc) (*http.Response, error)
+
 func New{{ .Client.TypeName }}(baseUrl string, client *http.Client, headers map[string]string) *{{ .Client.TypeName }} {
 	h := make(http.Header)
 	for k, v := range headers {
 		h.Set(k, v)
 	}
+	{{- if .Groups }}
+	c := &{{ .Client.TypeName }}{
+		client:     client,
+		baseUrl:    baseUrl,
+		headers:    h,
+		validators: newValidatorCache(),
+	}
+	c.bindGroups()
+	return c
+	{{- else }}
 	return &{{ .Client.TypeName }}{
-		client:  client,
-		baseUrl: baseUrl,
-		headers: h,
+		client:     client,
+		baseUrl:    baseUrl,
+		headers:    h,
+		validators: newValidatorCache(),
+	}
+	{{- end }}
+}
+
+// New{{ .Client.TypeName }}FromHandler creates a client dispatching calls to the handler in-process without opening sockets,
+// e.g. New{{ .Client.TypeName }}FromHandler(router.Mux()) in service tests.
+func New{{ .Client.TypeName }}FromHandler(handler http.Handler) *{{ .Client.TypeName }} {
+	return New{{ .Client.TypeName }}("http://in-process", &http.Client{Transport: handlerTransport{handler: handler}}, nil)
+}
+
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	serverReq := r.Clone(r.Context())
+	serverReq.RequestURI = r.URL.RequestURI()
+	serverReq.RemoteAddr = "127.0.0.1:0"
+	if serverReq.Body == nil {
+		serverReq.Body = http.NoBody
 	}
+
+	rec := httptest.NewRecorder()
+	t.handler.ServeHTTP(rec, serverReq)
+	resp := rec.Result()
+	resp.Request = r
+	return resp, nil
 }
 
 func (c *{{ .Client.TypeName }}) WithHeaders(headers map[string]string) *{{ .Client.TypeName }} {
@@ -33,61 +122,1186 @@ func (c *{{ .Client.TypeName }}) WithHeaders(headers map[string]string) *{{ .Cli
 	for k, v := range headers {
 		hCopy.Set(k, v)
 	}
-	return &{{ .Client.TypeName }}{
-		client:  c.client,
-		baseUrl: c.baseUrl,
-		headers: hCopy,
+	cCopy := c.clone()
+	cCopy.headers = hCopy
+	return cCopy
+}
+
+// WithInterceptor returns a copy of the client calling the given interceptors on every request,
+// the first given interceptor is the outermost one.
+func (c *{{ .Client.TypeName }}) WithInterceptor(interceptors ...Interceptor) *{{ .Client.TypeName }} {
+	cCopy := c.clone()
+	cCopy.interceptors = append(slices.Clone(c.interceptors), interceptors...)
+	return cCopy
+}
+
+func (c *{{ .Client.TypeName }}) clone() *{{ .Client.TypeName }} {
+	cCopy := *c
+	{{- if .Groups }}
+	cCopy.bindGroups()
+	{{- end }}
+	return &cCopy
+}
+{{- if .Groups }}
+
+// bindGroups points the sub-clients to the client, so a copy of the client calls with its own options
+func (c *{{ .Client.TypeName }}) bindGroups() {
+	{{- range .Groups }}
+	c.{{ .Access }} = &{{ .TypeName }}{root: c}
+	{{- end }}
+}
+{{- range .Groups }}
+
+// {{ .TypeName }} calls the handlers of the {{ .Access }} subrouter
+type {{ .TypeName }} struct {
+	root *{{ $.Client.TypeName }}
+	{{- with $.GroupsOf .TypeName }}
+{{ range . }}
+	{{ .Name }} *{{ .TypeName }}
+	{{- end }}
+	{{- end }}
+}
+{{- end }}
+{{- end }}
+
+func (c *{{ .Client.TypeName }}) do(r *http.Request) (*http.Response, error) {
+	if r.Method != http.MethodGet {
+		return c.send(r)
+	}
+	if c.cache != nil {
+		return c.cachedSend(r)
+	}
+	return c.conditionalSend(r)
+}
+
+func (c *{{ .Client.TypeName }}) send(r *http.Request) (*http.Response, error) {
+	return c.roundTrip(r, c.client.Do)
+}
+
+// roundTrip passes the request to the transport through the interceptors, the retry policy and the hooks
+func (c *{{ .Client.TypeName }}) roundTrip(r *http.Request, transport RoundTripFunc) (*http.Response, error) {
+	// the server bounds the call by the time left, see vel.Deadline
+	if deadline, ok := r.Context().Deadline(); ok && r.Header.Get("X-Request-Timeout") == "" {
+		r.Header.Set("X-Request-Timeout", strconv.FormatInt(max(time.Until(deadline), 0).Milliseconds(), 10)+"ms")
+	}
+	next := transport
+	for i := len(c.interceptors) - 1; i >= 0; i-- {
+		interceptor, inner := c.interceptors[i], next
+		next = func(r *http.Request) (*http.Response, error) {
+			return interceptor(r, inner)
+		}
+	}
+	if c.retry != nil {
+		attempt := next
+		next = func(r *http.Request) (*http.Response, error) {
+			return c.retry.do(r, attempt)
+		}
+	}
+	return c.observe(r, next)
+}
+
+// WithHooks returns a copy of the client calling the hooks around every call, e.g. to record metrics or traces.
+func (c *{{ .Client.TypeName }}) WithHooks(hooks Hooks) *{{ .Client.TypeName }} {
+	cCopy := c.clone()
+	cCopy.hooks = hooks
+	return cCopy
+}
+
+// Hooks observe the calls of the client, every hook is optional.
+// OnRequest is called before a call is sent, OnResponse once its response is received
+// and OnError once the call fails with an error response or without a response at all.
+type Hooks struct {
+	OnRequest  func(ctx context.Context, info CallInfo)
+	OnResponse func(ctx context.Context, info CallInfo)
+	OnError    func(ctx context.Context, info CallInfo)
+}
+
+// CallInfo describes a call observed by Hooks.
+type CallInfo struct {
+	OperationID string
+	// Duration lasts until the response headers are received including the retries, it's zero in OnRequest
+	Duration time.Duration
+	// Status is zero if no response is received
+	Status int
+	// Code is the code of the error response
+	Code string
+	// Err is the failure of the call without a response
+	Err error
+}
+
+type operationIDKey struct{}
+
+func (c *{{ .Client.TypeName }}) observe(r *http.Request, next RoundTripFunc) (*http.Response, error) {
+	hooks := c.hooks
+	if hooks.OnRequest == nil && hooks.OnResponse == nil && hooks.OnError == nil {
+		return next(r)
+	}
+
+	ctx := r.Context()
+	var info CallInfo
+	info.OperationID, _ = ctx.Value(operationIDKey{}).(string)
+	if hooks.OnRequest != nil {
+		hooks.OnRequest(ctx, info)
+	}
+	start := time.Now()
+	resp, err := next(r)
+	info.Duration = time.Since(start)
+	if err != nil {
+		info.Err = err
+		if hooks.OnError != nil {
+			hooks.OnError(ctx, info)
+		}
+		return resp, err
+	}
+
+	info.Status = resp.StatusCode
+	if resp.StatusCode >= 400 {
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		if err == nil {
+			if errResp, err := decodeError(bytes.NewReader(body)); err == nil {
+				info.Code = errResp.Code
+			}
+		}
+	}
+	if hooks.OnResponse != nil {
+		hooks.OnResponse(ctx, info)
+	}
+	if resp.StatusCode >= 400 && hooks.OnError != nil {
+		hooks.OnError(ctx, info)
+	}
+	return resp, nil
+}
+
+// WithCache returns a copy of the client caching successful GET responses,
+// a response is stored only if the server allows it with Cache-Control max-age, no-store is always respected.
+func (c *{{ .Client.TypeName }}) WithCache(cache Cache) *{{ .Client.TypeName }} {
+	cCopy := c.clone()
+	cCopy.cache = cache
+	return cCopy
+}
+
+// Cache stores the responses as they're sent over the wire, the status, the headers and the body,
+// see NewMemoryCache for an in-memory implementation.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, body []byte, ttl time.Duration)
+}
+
+func (c *{{ .Client.TypeName }}) cachedSend(r *http.Request) (*http.Response, error) {
+	key := cacheKey(r)
+	if stored, ok := c.cache.Get(key); ok {
+		// an entry that isn't a response is a miss
+		if cached, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(stored)), r); err == nil {
+			// a hit passes the interceptors and the hooks like a call does
+			return c.roundTrip(r, func(r *http.Request) (*http.Response, error) {
+				return cached, nil
+			})
+		}
+	}
+
+	resp, err := c.conditionalSend(r)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	ttl, ok := cacheTTL(resp.Header.Get("Cache-Control"))
+	if !ok {
+		return resp, nil
+	}
+
+	// the body of the response is replaced by the read one
+	stored, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	c.cache.Set(key, stored, ttl)
+	return resp, nil
+}
+
+// conditionalSend revalidates a GET response the client got before by its ETag in If-None-Match,
+// the server answers 304 Not Modified if the response is unchanged and the remembered body is returned then.
+func (c *{{ .Client.TypeName }}) conditionalSend(r *http.Request) (*http.Response, error) {
+	key := cacheKey(r)
+	validator, ok := c.validators.get(key)
+	// the caller's own validator takes precedence
+	ok = ok && r.Header.Get("If-None-Match") == ""
+	if ok {
+		r.Header.Set("If-None-Match", validator.etag)
+	}
+
+	resp, err := c.send(r)
+	if err != nil {
+		return resp, err
+	}
+	if ok && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		resp.Status, resp.StatusCode = "200 OK", http.StatusOK
+		resp.Body = io.NopCloser(bytes.NewReader(validator.body))
+		return resp, nil
+	}
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" || strings.Contains(resp.Header.Get("Cache-Control"), "no-store") {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	c.validators.set(key, validatorEntry{etag: etag, body: body})
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// validatorCacheSize limits the remembered ETags, a random one is dropped once it's full
+const validatorCacheSize = 1000
+
+// validatorCache remembers the ETags of the GET responses along with their bodies, the copies of a client share it
+type validatorCache struct {
+	mu      sync.Mutex
+	entries map[string]validatorEntry
+}
+
+type validatorEntry struct {
+	etag string
+	body []byte
+}
+
+func newValidatorCache() *validatorCache {
+	return &validatorCache{entries: make(map[string]validatorEntry)}
+}
+
+func (v *validatorCache) get(key string) (validatorEntry, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	entry, ok := v.entries[key]
+	return entry, ok
+}
+
+func (v *validatorCache) set(key string, entry validatorEntry) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.entries[key]; !ok && len(v.entries) >= validatorCacheSize {
+		for k := range v.entries {
+			delete(v.entries, k)
+			break
+		}
+	}
+	v.entries[key] = entry
+}
+
+// cacheKeyPolicy customizes the cache key of an operation as declared in its spec
+type cacheKeyPolicy struct {
+	ignoreQuery []string
+	varyHeaders []string
+}
+
+type cacheKeyPolicyKey struct{}
+
+// cacheKey identifies a cached response like the server does:
+// the url with the sorted query params except the ignored ones and the values of the vary headers.
+func cacheKey(r *http.Request) string {
+	policy, _ := r.Context().Value(cacheKeyPolicyKey{}).(cacheKeyPolicy)
+	q := r.URL.Query()
+	for _, name := range policy.ignoreQuery {
+		q.Del(name)
+	}
+	u := *r.URL
+	u.RawQuery = q.Encode()
+	key := u.String()
+	for _, name := range policy.varyHeaders {
+		key += "\n" + http.CanonicalHeaderKey(name) + ": " + r.Header.Get(name)
+	}
+	return key
+}
+
+func cacheTTL(cacheControl string) (time.Duration, bool) {
+	var ttl time.Duration
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.TrimSpace(directive)
+		switch {
+		case directive == "no-store", directive == "no-cache":
+			return 0, false
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err != nil {
+				return 0, false
+			}
+			ttl = time.Duration(seconds) * time.Second
+		}
+	}
+	return ttl, ttl > 0
+}
+
+// MemoryCache is a Cache keeping entries in memory until they expire.
+type MemoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryCacheEntry
+}
+
+type memoryCacheEntry struct {
+	body      []byte
+	expiresAt time.Time
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: make(map[string]memoryCacheEntry)}
+}
+
+func (m *MemoryCache) Get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(m.entries, key)
+		return nil, false
+	}
+	return entry.body, true
+}
+
+func (m *MemoryCache) Set(key string, body []byte, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = memoryCacheEntry{body: body, expiresAt: time.Now().Add(ttl)}
+}
+
+// WithRetry returns a copy of the client retrying failed calls according to the policy.
+func (c *{{ .Client.TypeName }}) WithRetry(policy RetryPolicy) *{{ .Client.TypeName }} {
+	cCopy := c.clone()
+	cCopy.retry = &policy
+	return cCopy
+}
+
+// RetryPolicy defines when and how often a failed call is retried.
+// Network errors are retried, responses are retried when their status is in Statuses
+// or the returned error code is in Codes. A call of a method that isn't idempotent, e.g. POST,
+// is retried only if it carries an Idempotency-Key header or it failed before the connection was made.
+// Retry-After header is respected on 429 and 503 responses up to MaxDelay.
+type RetryPolicy struct {
+	// MaxAttempts includes the first call, 3 by default
+	MaxAttempts int
+	// BaseDelay is doubled on every attempt, 100ms by default
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff, 5s by default
+	MaxDelay time.Duration
+	// Statuses defaults to 429, 502, 503, 504
+	Statuses []int
+	Codes    []string
+}
+
+func (p *RetryPolicy) do(r *http.Request, next RoundTripFunc) (*http.Response, error) {
+	attempts := p.MaxAttempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+	for attempt := 1; ; attempt++ {
+		resp, err := next(r)
+		if attempt >= attempts || !p.shouldRetry(r, resp, err) {
+			return resp, err
+		}
+		if r.Body != nil && r.GetBody == nil {
+			return resp, err
+		}
+
+		delay := p.delay(attempt, resp)
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if r.GetBody != nil {
+			body, err := r.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewind request body: %w", err)
+			}
+			r.Body = body
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-r.Context().Done():
+			timer.Stop()
+			return nil, r.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (p *RetryPolicy) shouldRetry(r *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		// a failed dial never reached the server
+		var opErr *net.OpError
+		return r.Context().Err() == nil && (idempotent(r) || errors.As(err, &opErr) && opErr.Op == "dial")
+	}
+	if !idempotent(r) {
+		return false
+	}
+
+	statuses := p.Statuses
+	if statuses == nil {
+		statuses = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+	}
+	if slices.Contains(statuses, resp.StatusCode) {
+		return true
+	}
+
+	if len(p.Codes) == 0 || resp.StatusCode < 400 {
+		return false
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+	errResp, err := decodeError(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+	return slices.Contains(p.Codes, errResp.Code)
+}
+
+// idempotent reports whether sending the request twice has the effect of sending it once,
+// by its method or by the Idempotency-Key header the server deduplicates the calls by
+func idempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return r.Header.Get("Idempotency-Key") != ""
+}
+
+func (p *RetryPolicy) delay(attempt int, resp *http.Response) time.Duration {
+	maxDelay := p.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = 5 * time.Second
+	}
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+			if seconds, err := strconv.Atoi(retryAfter); err == nil {
+				return min(time.Duration(seconds)*time.Second, maxDelay)
+			}
+			if at, err := http.ParseTime(retryAfter); err == nil {
+				return min(time.Until(at), maxDelay)
+			}
+		}
+	}
+
+	base := p.BaseDelay
+	if base <= 0 {
+		base = 100 * time.Millisecond
+	}
+	backoff := min(base<<(attempt-1), maxDelay)
+	if backoff <= 0 {
+		backoff = maxDelay
+	}
+	// full jitter
+	return rand.N(backoff) + 1
+}
+
+{{- if not .File }}
+{{ template "errors" . }}
+{{- end }}
+
+// CallOption customizes a single call, it takes precedence over the client configuration.
+type CallOption func(o *callOptions)
+
+type callOptions struct {
+	headers http.Header
+	query   url.Values
+	timeout time.Duration
+}
+
+// WithHeader sets a request header for a single call.
+func WithHeader(key, value string) CallOption {
+	return func(o *callOptions) {
+		o.headers.Set(key, value)
+	}
+}
+
+// WithQuery overrides a query parameter for a single call.
+func WithQuery(key, value string) CallOption {
+	return func(o *callOptions) {
+		o.query.Set(key, value)
+	}
+}
+
+// WithTimeout limits a single call including reading its response.
+func WithTimeout(timeout time.Duration) CallOption {
+	return func(o *callOptions) {
+		o.timeout = timeout
+	}
+}
+
+func applyCallOptions(ctx context.Context, r *http.Request, opts []CallOption) (context.Context, context.CancelFunc) {
+	if len(opts) == 0 {
+		return ctx, func() {}
+	}
+
+	o := callOptions{
+		headers: make(http.Header),
+		query:   make(url.Values),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	for k, v := range o.headers {
+		r.Header[k] = v
+	}
+	if len(o.query) > 0 {
+		q := r.URL.Query()
+		for k, v := range o.query {
+			q[k] = v
+		}
+		r.URL.RawQuery = q.Encode()
+	}
+	if o.timeout > 0 {
+		return context.WithTimeout(ctx, o.timeout)
+	}
+	return ctx, func() {}
+}
+
+// queryValue encodes a query parameter the same way the server decodes it,
+// types implementing encoding.TextMarshaler (e.g. time.Time) use their text form.
+func queryValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case encoding.TextMarshaler:
+		text, err := v.MarshalText()
+		if err == nil {
+			return string(text)
+		}
+	}
+	return fmt.Sprint(v)
+}
+{{- if .Streams }}
+
+// streamItems reads the items of a streaming response: the data of the server-sent events or the lines of NDJSON,
+// an error sent by the server ends the stream.
+func streamItems(body io.Reader, sse bool) iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(nil, 16<<20)
+		var event string
+		var data [][]byte
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if !sse {
+				if len(bytes.TrimSpace(line)) == 0 {
+					continue
+				}
+				if bytes.HasPrefix(line, []byte(`{"$error":`)) {
+					var e struct {
+						Error json.RawMessage `json:"$error"`
+					}
+					if json.Unmarshal(line, &e) == nil {
+						yield(nil, streamError(e.Error))
+						return
+					}
+				}
+				if !yield(bytes.Clone(line), nil) {
+					return
+				}
+				continue
+			}
+
+			field, value, _ := bytes.Cut(line, []byte(":"))
+			value = bytes.TrimPrefix(value, []byte(" "))
+			switch {
+			case len(line) == 0:
+				// a blank line dispatches the event, comments and other events are skipped
+				if len(data) > 0 && event == "error" {
+					yield(nil, streamError(bytes.Join(data, []byte("\n"))))
+					return
+				}
+				if len(data) > 0 && (event == "" || event == "message") && !yield(bytes.Join(data, []byte("\n")), nil) {
+					return
+				}
+				event, data = "", nil
+			case string(field) == "event":
+				event = string(value)
+			case string(field) == "data":
+				data = append(data, bytes.Clone(value))
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			yield(nil, fmt.Errorf("failed to read stream: %w", err))
+		}
+	}
+}
+
+// Stream reads the items of a streaming response one by one, close it once done.
+type Stream[T any] struct {
+	operationID string
+	next        func() ([]byte, error, bool)
+	stop        func()
+	body        io.Closer
+	cancel      context.CancelFunc
+}
+
+// Recv returns the next item, io.EOF once the server ends the stream.
+// An error the server sends ends the stream as well.
+func (s *Stream[T]) Recv() (T, error) {
+	var item T
+	data, err, ok := s.next()
+	if !ok {
+		return item, io.EOF
+	}
+	if err != nil {
+		return item, err
+	}
+	if err := json.Unmarshal(data, &item); err != nil {
+		return item, fmt.Errorf("failed to decode %s item: %w", s.operationID, err)
+	}
+	return item, nil
+}
+
+// Close closes the response and cancels the call.
+func (s *Stream[T]) Close() error {
+	s.stop()
+	s.cancel()
+	return s.body.Close()
+}
+
+func streamError(data []byte) error {
+	errResp, err := decodeError(bytes.NewReader(data))
+	if err != nil {
+		return &Error{
+			Code:    "UNKNOWN",
+			Message: "failed to decode stream error: " + err.Error(),
+		}
+	}
+	return typedError(errResp)
+}
+{{- end }}
+{{- if .Downloads }}
+
+// cancelBody cancels the call once its response body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+{{- end }}
+{{- if .Client.MsgPack }}
+
+// decodeBody decodes a MessagePack response or falls back to JSON, e.g. for a server not negotiating MessagePack.
+// A cached body without its header is told apart by its first byte, a MessagePack map starts beyond ASCII unlike a JSON object.
+func decodeBody(resp *http.Response, v any) error {
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "application/msgpack" || mediaType == "" && len(data) > 0 && data[0] >= 0x80 {
+		return msgpack.Unmarshal(data, v)
+	}
+	return json.Unmarshal(data, v)
+}
+{{- end }}
+{{- if .Uploads }}
+
+// ndjsonBody streams the items as lines of JSON while the request is sent,
+// the items are encoded as they're requested and the first error fails the call.
+func ndjsonBody[T any](items iter.Seq[T]) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		enc := json.NewEncoder(pw)
+		for item := range items {
+			if err := enc.Encode(item); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+	return pr
+}
+{{- end }}
+{{- if .Async }}
+
+// Operation is the state of an async operation, the calls of the async routes start it
+// and their Wait methods poll it until it's done.
+type Operation struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Result is the output of the succeeded operation
+	Result json.RawMessage `json:"result,omitempty"`
+	// Error is the error of the failed operation
+	Error     json.RawMessage `json:"error,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+// Done reports whether the operation succeeded or failed.
+func (o Operation) Done() bool {
+	return o.Status == "succeeded" || o.Status == "failed"
+}
+
+// waitOperation polls the status endpoint until the operation is done, as often as the server asks by Retry-After,
+// a failed operation returns its error.
+func (c *{{ .Client.TypeName }}) waitOperation(ctx context.Context, path string, op Operation, opts []CallOption) (Operation, error) {
+	delay := time.Second
+	for !op.Done() {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return op, ctx.Err()
+		case <-timer.C:
+		}
+		next, retryAfter, err := c.getOperation(ctx, path+url.PathEscape(op.ID), opts)
+		if err != nil {
+			return op, err
+		}
+		op, delay = next, retryAfter
+	}
+	if op.Status == "failed" {
+		errResp, err := decodeError(bytes.NewReader(op.Error))
+		if err != nil {
+			return op, &Error{
+				Code:    "UNKNOWN",
+				Message: "failed to decode operation error: " + err.Error(),
+			}
+		}
+		return op, typedError(errResp)
+	}
+	return op, nil
+}
+
+func (c *{{ .Client.TypeName }}) getOperation(ctx context.Context, path string, opts []CallOption) (Operation, time.Duration, error) {
+	var op Operation
+	r, err := http.NewRequest("GET", c.baseUrl+"/"+path, nil)
+	if err != nil {
+		return op, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	r.Header = c.headers.Clone()
+	ctx, cancel := applyCallOptions(ctx, r, opts)
+	defer cancel()
+	r = r.WithContext(context.WithValue(ctx, operationIDKey{}, "getOperation"))
+
+	// the status changes, it's never cached
+	resp, err := c.send(r)
+	if err != nil {
+		return op, 0, fmt.Errorf("failed to get operation: %w", err)
+	}
+	defer resp.Body.Close()
+
+	err = HandleErr(resp)
+	if err != nil {
+		return op, 0, err
+	}
+	err = json.NewDecoder(resp.Body).Decode(&op)
+	if err != nil {
+		return op, 0, fmt.Errorf("failed to decode operation: %w", err)
+	}
+	delay := time.Second
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		delay = time.Duration(seconds) * time.Second
 	}
+	return op, delay, nil
 }
+{{- end }}
+{{- range .Operations }}
+{{- . }}
+{{- end }}
+{{- if .Client.Batch }}
+{{- template "batch" . }}
+{{- end }}
+
+{{- range $receiver := .Receivers }}
+
+
+// {{ $receiver }}API is the API surface of {{ $receiver }}, depend on it to replace the client in tests.
+type {{ $receiver }}API interface {
+{{- range $.ApisOf $receiver }}
+	{{ .FuncName }}(ctx context.Context{{ if ne .Input.Name "" }}, req {{ .GoInput }}{{ end }}, opts ...CallOption) {{ .GoResults }}
+{{- end }}
+}
+
+var (
+	_ {{ $receiver }}API = (*{{ $receiver }})(nil)
+	_ {{ $receiver }}API = (*Mock{{ $receiver }})(nil)
+)
+
+// Mock{{ $receiver }} implements {{ $receiver }}API with the configured functions,
+// a method without a function returns zero values.
+type Mock{{ $receiver }} struct {
+{{- range $.ApisOf $receiver }}
+	{{ .FuncName }}Func func(ctx context.Context{{ if ne .Input.Name "" }}, req {{ .GoInput }}{{ end }}, opts ...CallOption) {{ .GoResults }}
+{{- end }}
+}
+{{- range $.ApisOf $receiver }}
+
+func (m *Mock{{ $receiver }}) {{ .FuncName }}(ctx context.Context{{ if ne .Input.Name "" }}, req {{ .GoInput }}{{ end }}, opts ...CallOption) {{ .GoResults }} {
+	if m.{{ .FuncName }}Func == nil {
+		{{- if .Spec.Stream }}
+		return func(func({{ .Output.Name }}, error) bool) {}
+		{{- else if .OperationsPath }}
+		return Operation{}, nil
+		{{- else }}
+		return {{if ne .Output.Name "" }}{{ .Output.Name }}{}, {{ end }}nil
+		{{- end }}
+	}
+	return m.{{ .FuncName }}Func(ctx{{ if ne .Input.Name "" }}, req{{ end }}, opts...)
+}
+{{- end }}
+{{- end }}
+{{- end }}
 
+{{- define "errors" }}
 type Error struct {
-	Code    string            `json:"code"`
-	Message string            `json:"message"`
-	Meta    map[string]string `json:"meta"`
+	Code    string            `json:"{{ .ErrorShape.CodeField }}"`
+	Message string            `json:"{{ .ErrorShape.MessageField }}"`
+	Meta    map[string]string `json:"{{ .ErrorShape.MetaField }}"`
+	Violations []Violation `json:"{{ .ErrorShape.ViolationsField }},omitempty"`
+	{{- range .ErrorShape.Extra }}
+	{{ .Name }} {{ .GoType }} `json:"{{ .Key }}"`
+	{{- end }}
+}
+
+// Violation is a failed validation rule of a request, Params holds the rule parameters, e.g. min of min_len.
+type Violation struct {
+	Field  string            `json:"field"`
+	Rule   string            `json:"rule"`
+	Params map[string]string `json:"params"`
 }
 
 func (e *Error) Error() string {
 	return fmt.Sprintf("%s, %s", e.Code, e.Message)
 }
 
+// RedirectError is returned for a redirect the http.Client doesn't follow,
+// e.g. its CheckRedirect returns http.ErrUseLastResponse.
+type RedirectError struct {
+	Status   int
+	Location string
+}
+
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("redirected with %d to %s", e.Status, e.Location)
+}
+{{ range .CodeErrors }}
+// {{ .TypeName }} is the Error of the {{ .Code }} code with its meta parsed, see errors.As.
+type {{ .TypeName }} struct {
+	Err *Error
+	{{- range .Meta }}
+	{{ .Field }} {{ .GoType }}
+	{{- end }}
+}
+
+func (e *{{ .TypeName }}) Error() string {
+	return e.Err.Error()
+}
+
+func (e *{{ .TypeName }}) Unwrap() error {
+	return e.Err
+}
+{{ end }}
+// typedError wraps the Error of a declared code into its typed error, a meta value failing to parse is left zero.
+func typedError(e *Error) error {
+	{{- if .CodeErrors }}
+	switch e.Code {
+	{{- range .CodeErrors }}
+	case "{{ .Code }}":
+		{{- if not .Meta }}
+		return &{{ .TypeName }}{Err: e}
+		{{- else }}
+		typed := &{{ .TypeName }}{Err: e}
+		{{- range .Meta }}
+		if v, ok := e.Meta["{{ .Key }}"]; ok {
+			{{- if eq .GoType "int" }}
+			typed.{{ .Field }}, _ = strconv.Atoi(v)
+			{{- else if eq .GoType "uint" }}
+			n, _ := strconv.ParseUint(v, 10, 0)
+			typed.{{ .Field }} = uint(n)
+			{{- else if eq .GoType "float64" }}
+			typed.{{ .Field }}, _ = strconv.ParseFloat(v, 64)
+			{{- else if eq .GoType "bool" }}
+			typed.{{ .Field }}, _ = strconv.ParseBool(v)
+			{{- else }}
+			typed.{{ .Field }} = v
+			{{- end }}
+		}
+		{{- end }}
+		return typed
+		{{- end }}
+	{{- end }}
+	}
+	{{- end }}
+	return e
+}
+
 func HandleErr(resp *http.Response) error {
-	if resp.StatusCode >= 500 {
-		var errResp Error
-		err := json.NewDecoder(resp.Body).Decode(&errResp)
-		if err != nil {
-			return &Error{
-				Code:    "UNKNOWN",
-				Message: "failed to decode error response: " + err.Error(),
-			}
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 && resp.StatusCode != http.StatusNotModified {
+		return &RedirectError{Status: resp.StatusCode, Location: resp.Header.Get("Location")}
+	}
+	if resp.StatusCode < 400 {
+		return nil
+	}
+
+	errResp, err := decodeError(resp.Body)
+	if err != nil {
+		return &Error{
+			Code:    "UNKNOWN",
+			Message: "failed to decode error response: " + err.Error(),
 		}
-		return &errResp
 	}
+	return typedError(errResp)
+}
 
-	if resp.StatusCode >= 400 {
-		var errResp Error
-		err := json.NewDecoder(resp.Body).Decode(&errResp)
+func decodeError(r io.Reader) (*Error, error) {
+	var errResp Error
+	{{- if .ErrorShape.Envelope }}
+	envelope := struct {
+		Error *Error `json:"{{ .ErrorShape.Envelope }}"`
+	}{Error: &errResp}
+	err := json.NewDecoder(r).Decode(&envelope)
+	{{- else }}
+	err := json.NewDecoder(r).Decode(&errResp)
+	{{- end }}
+	return &errResp, err
+}
+{{- end }}
+
+{{- define "batch" }}
+
+// Batch collects calls the server serves concurrently in a single request, see {{ .Client.TypeName }}.NewBatch.
+type Batch struct {
+	c       *{{ .Client.TypeName }}
+	items   []batchItem
+	results []func(status int, body json.RawMessage)
+	err     error
+}
+
+type batchItem struct {
+	OperationID string          `json:"operationId"`
+	Body        json.RawMessage `json:"body,omitempty"`
+}
+
+type batchResult struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// BatchCall is the result of a call added to a Batch, it's set once the batch is sent.
+type BatchCall[T any] struct {
+	Value T
+	Err   error
+}
+
+// NewBatch starts a batch of calls sent to the batch endpoint by Send.
+func (c *{{ .Client.TypeName }}) NewBatch() *Batch {
+	return &Batch{c: c}
+}
+
+func (b *Batch) add(operationID string, req any, result func(status int, body json.RawMessage)) {
+	item := batchItem{OperationID: operationID}
+	if req != nil {
+		body, err := json.Marshal(req)
 		if err != nil {
-			return &Error{
-				Code:    "UNKNOWN",
-				Message: "failed to decode error response: " + err.Error(),
-			}
+			b.err = fmt.Errorf("failed to marshal %s request: %w", operationID, err)
 		}
-		return &errResp
+		item.Body = body
 	}
+	b.items = append(b.items, item)
+	b.results = append(b.results, result)
+}
+
+// Send calls the batch endpoint and sets the results of the added calls.
+// The error is the failure of the batch request itself, a failed call sets the error of its result only.
+func (b *Batch) Send(ctx context.Context, opts ...CallOption) error {
+	if b.err != nil {
+		return b.err
+	}
+	c := b.c
+	bodyBytes, err := json.Marshal(b.items)
+	if err != nil {
+		return fmt.Errorf("failed to marshal batch: %w", err)
+	}
+	r, err := http.NewRequest("POST", c.baseUrl+"/batch", bytes.NewBuffer(bodyBytes))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	r.Header = c.headers.Clone()
+	ctx, cancel := applyCallOptions(ctx, r, opts)
+	defer cancel()
+	r = r.WithContext(context.WithValue(ctx, operationIDKey{}, "batch"))
+
+	// a batch is never cached
+	resp, err := c.send(r)
+	if err != nil {
+		return fmt.Errorf("failed to call batch: %w", err)
+	}
+	defer resp.Body.Close()
 
+	if err := HandleErr(resp); err != nil {
+		return err
+	}
+	var results []batchResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return fmt.Errorf("failed to decode batch response: %w", err)
+	}
+	if len(results) != len(b.items) {
+		return fmt.Errorf("expected %d batch results, got %d", len(b.items), len(results))
+	}
+	for i, result := range results {
+		b.results[i](result.Status, result.Body)
+	}
 	return nil
 }
-{{- range .Apis }}
+
+// batchErr is the error of a failed batch call like HandleErr returns for a single call.
+func batchErr(status int, body json.RawMessage) error {
+	if status < 400 {
+		return nil
+	}
+	errResp, err := decodeError(bytes.NewReader(body))
+	if err != nil {
+		return &Error{
+			Code:    "UNKNOWN",
+			Message: "failed to decode error response: " + err.Error(),
+		}
+	}
+	return typedError(errResp)
+}
+{{- range .BatchApis }}
+
+// {{ .FuncName }} adds a {{ .OperationID }} call to the batch.
+func (b *Batch) {{ .FuncName }}({{ if ne .Input.Name "" }}req {{ .Input.Name }}{{ end }}) *BatchCall[{{ if ne .Output.Name "" }}{{ .Output.Name }}{{ else }}struct{}{{ end }}] {
+	call := &BatchCall[{{ if ne .Output.Name "" }}{{ .Output.Name }}{{ else }}struct{}{{ end }}]{}
+	b.add("{{ .OperationID }}", {{ if ne .Input.Name "" }}req{{ else }}nil{{ end }}, func(status int, body json.RawMessage) {
+		call.Err = batchErr(status, body)
+		{{- if gt (len .Output.Fields) 0 }}
+		if call.Err == nil {
+			if err := json.Unmarshal(body, &call.Value); err != nil {
+				call.Err = fmt.Errorf("failed to decode {{ .OperationID }} response: %w", err)
+			}
+		}
+		{{- end }}
+	})
+	return call
+}
+{{- end }}
+{{- end }}
+
+{{- define "dataTypes" }}
 {{- range .DataTypes }}
+{{- if .Primitive }}
+type {{ .Name }} {{ .Primitive }}
+{{- if .Enum }}
+{{- $type := .Name }}
+
+const (
+	{{- range .Enum }}
+	{{ .Const }} {{ $type }} = {{ .Literal }}
+	{{- end }}
+)
+{{- end }}
+{{- else if .Union }}
+{{- $type := .Name }}
+{{- $union := .Union }}
+
+// {{ $type }} holds one of the structs told apart by {{ $union.Discriminator }}, the one set is encoded.
+type {{ $type }} struct {
+	{{- range $union.Variants }}
+	{{ .Name }} *{{ .Name }}
+	{{- end }}
+}
+
+func (u {{ $type }}) MarshalJSON() ([]byte, error) {
+	switch {
+	{{- range $union.Variants }}
+	case u.{{ .Name }} != nil:
+		v := *u.{{ .Name }}
+		v.{{ .Field }} = {{ printf "%q" .Value }}
+		return json.Marshal(v)
+	{{- end }}
+	}
+	return []byte("null"), nil
+}
+
+func (u *{{ $type }}) UnmarshalJSON(data []byte) error {
+	*u = {{ $type }}{}
+	if string(data) == "null" {
+		return nil
+	}
+	var discriminator struct {
+		Value string `json:"{{ $union.Discriminator }}"`
+	}
+	if err := json.Unmarshal(data, &discriminator); err != nil {
+		return err
+	}
+	switch discriminator.Value {
+	{{- range $union.Variants }}
+	case {{ printf "%q" .Value }}:
+		u.{{ .Name }} = new({{ .Name }})
+		return json.Unmarshal(data, u.{{ .Name }})
+	{{- end }}
+	}
+	return fmt.Errorf("unknown {{ $union.Discriminator }} %q of {{ $type }}", discriminator.Value)
+}
+{{- else }}
 type {{ .Name }} struct {
 	{{- range .Fields }}
 	{{ .Name }} {{ .TypeName }}{{ if ne .JsonTag "" }} `json:"{{ .JsonTag }}"`{{ end }}
 	{{- end }}
 }
+{{- end }}
 
 {{ end }}
+{{- end }}
+
+{{- define "operation" }}
+{{- if not $.File }}
+{{- template "dataTypes" . }}
+{{- end }}
+{{- if .Spec.Stream }}
+{{- template "streamOperation" . }}
+{{- else if .OperationsPath }}
+{{- template "asyncOperation" . }}
+{{- else }}
+{{- $res := "" }}
+{{- if .RawOutput }}{{ $res = "nil, " }}{{ else if ne .Output.Name "" }}{{ $res = "res, " }}{{ end }}
+{{- if .RawOutput }}
+
+// {{ .FuncName }} reads the whole response of {{ .FuncName }}Reader.
+func ({{ if .Group }}g{{ else }}c{{ end }} *{{ .Receiver }}) {{ .FuncName }}(ctx context.Context{{ if ne .Input.Name "" }}, req {{ .GoInput }}{{ end }}, opts ...CallOption) {{ .GoResults }} {
+	body, err := {{ if .Group }}g{{ else }}c{{ end }}.{{ .FuncName }}Reader(ctx{{ if ne .Input.Name "" }}, req{{ end }}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	res, err := io.ReadAll(body)
+	if err != nil {
+		return res, fmt.Errorf("failed to read {{ .OperationID }} response: %w", err)
+	}
+	return res, nil
+}
+
+// {{ .FuncName }}Reader returns the response body of {{ .OperationID }} without reading it,
+// the context bounds the call until the body is closed.
+func ({{ if .Group }}g{{ else }}c{{ end }} *{{ .Receiver }}) {{ .FuncName }}Reader(ctx context.Context{{ if ne .Input.Name "" }}, req {{ .GoInput }}{{ end }}, opts ...CallOption) (io.ReadCloser, error) {
+    {{- else }}
 
-func (c *{{ $.Client.TypeName }}) {{ .FuncName }}(ctx context.Context{{ if ne .Input.Name "" }}, req {{ .Input.Name }}{{ end }}) ({{if ne .Output.Name "" }}{{ .Output.Name }}, {{ end }}error) {
+func ({{ if .Group }}g{{ else }}c{{ end }} *{{ .Receiver }}) {{ .FuncName }}(ctx context.Context{{ if ne .Input.Name "" }}, req {{ .GoInput }}{{ end }}, opts ...CallOption) {{ .GoResults }} {
+    {{- end }}
+    {{- if .Group }}
+	c := g.root
+    {{- end }}
     {{- if gt (len .Output.Fields) 0 }}
     var res {{ .Output.Name }}
 
@@ -95,50 +1309,299 @@ func (c *{{ $.Client.TypeName }}) {{ .FuncName }}(ctx context.Context{{ if ne .I
     {{- if eq .Method "GET" }}
 	q := make(url.Values)
 
-	{{- range .Input.Fields }}
-    q.Set("{{ .SchemaTag }}", req.{{ .Name }})
+	{{- range .QueryParams }}
+	{{- if .GoGuard }}
+	if {{ .GoGuard }} {
+	{{- end }}
+	{{- if .Repeated }}
+	for _, v := range {{ if .Deref }}*{{ end }}{{ .GoExpr }} {
+		q.Add("{{ .Key }}", queryValue(v))
+	}
+	{{- else }}
+    q.Set("{{ .Key }}", queryValue({{ if .Deref }}*{{ end }}{{ .GoExpr }}))
+	{{- end }}
+	{{- if .GoGuard }}
+	}
+	{{- end }}
 	{{- end }}
 
-    r, err := http.NewRequest("GET", c.baseUrl+"/{{ .OperationID }}?" + q.Encode(), nil)
+    r, err := http.NewRequest("GET", c.baseUrl+"/{{ .Path }}?" + q.Encode(), nil)
     {{- else }}
-    {{- if ne .Input.Name "" }}
-	bodyBytes, err := json.Marshal(req)
+    {{- if .RawInput }}
+	body := req
+    {{- else if .InputItems }}
+	body := ndjsonBody(req)
+    {{- else if .Form }}
+	form := make(url.Values)
+	{{- range .Input.Fields }}
+	{{- if .SchemaTag }}
+	form.Set("{{ .SchemaTag }}", queryValue(req.{{ .Name }}))
+	{{- end }}
+	{{- end }}
+	body := strings.NewReader(form.Encode())
+    {{- else if ne .Input.Name "" }}
+	bodyBytes, err := {{ if .MsgPack }}msgpack{{ else }}json{{ end }}.Marshal(req)
 	if err != nil {
-		return {{if ne .Output.Name "" }}res, {{ end }}fmt.Errorf("failed to marshal request: %w", err)
+		return {{ $res }}fmt.Errorf("failed to marshal request: %w", err)
 	}
     body := bytes.NewBuffer(bodyBytes)
     {{- else }}
     body := bytes.NewBuffer(nil)
     {{- end }}
 
-	r, err := http.NewRequest("POST", c.baseUrl+"/{{ .OperationID }}", body)
+	r, err := http.NewRequest("POST", c.baseUrl+"/{{ .Path }}", body)
     {{- end }}
 	if err != nil {
-		return {{if ne .Output.Name "" }}res, {{ end }}fmt.Errorf("failed to create request: %w", err)
+		return {{ $res }}fmt.Errorf("failed to create request: %w", err)
 	}
+	r.Header = c.headers.Clone()
+	{{- if .Version }}
+	r.Header.Set("Accept-Version", "{{ .Version }}")
+	{{- end }}
+	{{- if .RawInput }}
+	r.Header.Set("Content-Type", "{{ or .Spec.RequestContentType "application/octet-stream" }}")
+	{{- else if .InputItems }}
+	r.Header.Set("Content-Type", "application/x-ndjson")
+	{{- else if .Form }}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	{{- else if and .MsgPack (ne .Method "GET") (ne .Input.Name "") }}
+	r.Header.Set("Content-Type", "application/msgpack")
+	{{- end }}
+	{{- if and .MsgPack (gt (len .Output.Fields) 0) }}
+	r.Header.Set("Accept", "application/msgpack, application/json;q=0.9")
+	{{- end }}
+	ctx, cancel := applyCallOptions(ctx, r, opts)
+	{{- if not .RawOutput }}
+	defer cancel()
+	{{- end }}
+	ctx = context.WithValue(ctx, operationIDKey{}, "{{ .OperationID }}")
+	{{- if and (eq .Method "GET") (or .Spec.Cache.IgnoreQuery .Spec.Cache.VaryHeaders) }}
+	ctx = context.WithValue(ctx, cacheKeyPolicyKey{}, cacheKeyPolicy{
+		{{- if .Spec.Cache.IgnoreQuery }}
+		ignoreQuery: []string{ {{- range $i, $q := .Spec.Cache.IgnoreQuery }}{{ if $i }}, {{ end }}"{{ $q }}"{{ end -}} },
+		{{- end }}
+		{{- if .Spec.Cache.VaryHeaders }}
+		varyHeaders: []string{ {{- range $i, $h := .Spec.Cache.VaryHeaders }}{{ if $i }}, {{ end }}"{{ $h }}"{{ end -}} },
+		{{- end }}
+	})
+	{{- end }}
 	r = r.WithContext(ctx)
-	r.Header = c.headers
 
-	resp, err := c.client.Do(r)
+	resp, err := c.do(r)
+	if err != nil {
+		{{- if .RawOutput }}
+		cancel()
+		{{- end }}
+		return {{ $res }}fmt.Errorf("failed to call {{ .OperationID }}: %w", err)
+	}
+	{{- if .RawOutput }}
+
+	err = HandleErr(resp)
 	if err != nil {
-		return {{if ne .Output.Name "" }}res, {{ end }}fmt.Errorf("failed to call {{ .OperationID }}: %w", err)
+		resp.Body.Close()
+		cancel()
+		return nil, err
 	}
+	return cancelBody{ReadCloser: resp.Body, cancel: cancel}, nil
+}
+	{{- else }}
 	defer resp.Body.Close()
 
 	err = HandleErr(resp)
 	if err != nil {
-		return {{if ne .Output.Name "" }}res, {{ end }}err
+		return {{ $res }}err
 	}
 	{{- if gt (len .Output.Fields) 0 }}
 
-	err = json.NewDecoder(resp.Body).Decode(&res)
+	err = {{ if .MsgPack }}decodeBody(resp, &res){{ else }}json.NewDecoder(resp.Body).Decode(&res){{ end }}
 	if err != nil {
-		return {{if ne .Output.Name "" }}res, {{ end }}fmt.Errorf("failed to decode {{ .OperationID }} response: %w", err)
+		return {{ $res }}fmt.Errorf("failed to decode {{ .OperationID }} response: %w", err)
 	}
 	{{- end }}
 
-	return {{if ne .Output.Name "" }}res, {{ end }}nil
+	return {{ $res }}nil
+}
+	{{- end }}
+{{- if .Paginated }}
+
+// {{ .FuncName }}Pages iterates over the pages of {{ .FuncName }} starting at the cursor of the request,
+// it stops after the last page or the first error.
+func ({{ if .Group }}g{{ else }}c{{ end }} *{{ .Receiver }}) {{ .FuncName }}Pages(ctx context.Context, req {{ .Input.Name }}, opts ...CallOption) iter.Seq2[{{ .Output.Name }}, error] {
+	return func(yield func({{ .Output.Name }}, error) bool) {
+		for {
+			page, err := {{ if .Group }}g{{ else }}c{{ end }}.{{ .FuncName }}(ctx, req, opts...)
+			if !yield(page, err) || err != nil || page.NextCursor == "" {
+				return
+			}
+			req.After = page.NextCursor
+		}
+	}
 }
+{{- end }}
+{{- end }}
 
 {{- end }}
 
+{{- define "asyncOperation" }}
+
+// {{ .FuncName }} starts the operation running in the background, see {{ .FuncName }}Wait.
+func ({{ if .Group }}g{{ else }}c{{ end }} *{{ .Receiver }}) {{ .FuncName }}(ctx context.Context{{ if ne .Input.Name "" }}, req {{ .GoInput }}{{ end }}, opts ...CallOption) {{ .GoResults }} {
+	{{- if .Group }}
+	c := g.root
+	{{- end }}
+	var op Operation
+	{{- if ne .Input.Name "" }}
+	bodyBytes, err := json.Marshal(req)
+	if err != nil {
+		return op, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	body := bytes.NewBuffer(bodyBytes)
+	{{- else }}
+	body := bytes.NewBuffer(nil)
+	{{- end }}
+
+	r, err := http.NewRequest("POST", c.baseUrl+"/{{ .Path }}", body)
+	if err != nil {
+		return op, fmt.Errorf("failed to create request: %w", err)
+	}
+	r.Header = c.headers.Clone()
+	{{- if .Version }}
+	r.Header.Set("Accept-Version", "{{ .Version }}")
+	{{- end }}
+	ctx, cancel := applyCallOptions(ctx, r, opts)
+	defer cancel()
+	r = r.WithContext(context.WithValue(ctx, operationIDKey{}, "{{ .OperationID }}"))
+
+	resp, err := c.do(r)
+	if err != nil {
+		return op, fmt.Errorf("failed to call {{ .OperationID }}: %w", err)
+	}
+	defer resp.Body.Close()
+
+	err = HandleErr(resp)
+	if err != nil {
+		return op, err
+	}
+	err = json.NewDecoder(resp.Body).Decode(&op)
+	if err != nil {
+		return op, fmt.Errorf("failed to decode {{ .OperationID }} response: %w", err)
+	}
+	return op, nil
+}
+
+// {{ .FuncName }}Wait polls the operation started by {{ .FuncName }} until it's done{{ if ne .Output.Name "" }} and decodes its result{{ end }},
+// a failed operation returns its error.
+func ({{ if .Group }}g{{ else }}c{{ end }} *{{ .Receiver }}) {{ .FuncName }}Wait(ctx context.Context, op Operation, opts ...CallOption) {{ if ne .Output.Name "" }}({{ .Output.Name }}, error){{ else }}error{{ end }} {
+	{{- if .Group }}
+	c := g.root
+	{{- end }}
+	{{- if ne .Output.Name "" }}
+	var res {{ .Output.Name }}
+	op, err := c.waitOperation(ctx, "{{ .OperationsPath }}", op, opts)
+	if err != nil {
+		return res, err
+	}
+	err = json.Unmarshal(op.Result, &res)
+	if err != nil {
+		return res, fmt.Errorf("failed to decode {{ .OperationID }} result: %w", err)
+	}
+	return res, nil
+	{{- else }}
+	_, err := c.waitOperation(ctx, "{{ .OperationsPath }}", op, opts)
+	return err
+	{{- end }}
+}
+{{- end }}
+
+{{- define "streamOperation" }}
+
+func ({{ if .Group }}g{{ else }}c{{ end }} *{{ .Receiver }}) {{ .FuncName }}(ctx context.Context{{ if ne .Input.Name "" }}, req {{ .GoInput }}{{ end }}, opts ...CallOption) {{ .GoResults }} {
+	return func(yield func({{ .Output.Name }}, error) bool) {
+		stream, err := {{ if .Group }}g{{ else }}c{{ end }}.{{ .FuncName }}Stream(ctx{{ if ne .Input.Name "" }}, req{{ end }}, opts...)
+		if err != nil {
+			var zero {{ .Output.Name }}
+			yield(zero, err)
+			return
+		}
+		defer stream.Close()
+
+		for {
+			item, err := stream.Recv()
+			if err == io.EOF || !yield(item, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// {{ .FuncName }}Stream opens the stream of {{ .FuncName }}, the context bounds the call until the stream is closed.
+func ({{ if .Group }}g{{ else }}c{{ end }} *{{ .Receiver }}) {{ .FuncName }}Stream(ctx context.Context{{ if ne .Input.Name "" }}, req {{ .GoInput }}{{ end }}, opts ...CallOption) (*Stream[{{ .Output.Name }}], error) {
+	{{- if .Group }}
+	c := g.root
+	{{- end }}
+	{{- if eq .Method "GET" }}
+	q := make(url.Values)
+
+	{{- range .QueryParams }}
+	{{- if .GoGuard }}
+	if {{ .GoGuard }} {
+	{{- end }}
+	{{- if .Repeated }}
+	for _, v := range {{ if .Deref }}*{{ end }}{{ .GoExpr }} {
+		q.Add("{{ .Key }}", queryValue(v))
+	}
+	{{- else }}
+	q.Set("{{ .Key }}", queryValue({{ if .Deref }}*{{ end }}{{ .GoExpr }}))
+	{{- end }}
+	{{- if .GoGuard }}
+	}
+	{{- end }}
+	{{- end }}
+
+	r, err := http.NewRequest("GET", c.baseUrl+"/{{ .Path }}?" + q.Encode(), nil)
+	{{- else }}
+	{{- if .InputItems }}
+	body := ndjsonBody(req)
+	{{- else if ne .Input.Name "" }}
+	bodyBytes, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	body := bytes.NewBuffer(bodyBytes)
+	{{- else }}
+	body := bytes.NewBuffer(nil)
+	{{- end }}
+
+	r, err := http.NewRequest("POST", c.baseUrl+"/{{ .Path }}", body)
+	{{- end }}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	r.Header = c.headers.Clone()
+	{{- if .Version }}
+	r.Header.Set("Accept-Version", "{{ .Version }}")
+	{{- end }}
+	r.Header.Set("Accept", "{{ .Spec.Stream.ContentType }}")
+	{{- if .InputItems }}
+	r.Header.Set("Content-Type", "application/x-ndjson")
+	{{- end }}
+	ctx, cancel := applyCallOptions(ctx, r, opts)
+	r = r.WithContext(context.WithValue(ctx, operationIDKey{}, "{{ .OperationID }}"))
+
+	// a stream is never cached
+	resp, err := c.send(r)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to call {{ .OperationID }}: %w", err)
+	}
+
+	err = HandleErr(resp)
+	if err != nil {
+		resp.Body.Close()
+		cancel()
+		return nil, err
+	}
+	next, stop := iter.Pull2(streamItems(resp.Body, {{ if eq .Spec.Stream "sse" }}true{{ else }}false{{ end }}))
+	return &Stream[{{ .Output.Name }}]{operationID: "{{ .OperationID }}", next: next, stop: stop, body: resp.Body, cancel: cancel}, nil
+}
+{{- end }}
@@ -0,0 +1,13 @@
+package {{ .Client.PackageName }}
+{{- if .Headers }}
+
+// Headers declared in the API specs.
+const (
+	{{- range .Headers }}
+	{{- if ne .Description "" }}
+	// {{ .Name }} {{ .Description }}
+	{{- end }}
+	{{ .Name }} = "{{ .Key }}"
+	{{- end }}
+)
+{{- end }}
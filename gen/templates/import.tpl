@@ -0,0 +1,93 @@
+{{- define "types" -}}
+package {{ .Package }}
+{{ if .UsesTime }}
+import "time"
+{{ end }}
+{{- range .Types }}
+{{ range .Doc }}
+// {{ . }}
+{{- end }}
+{{- if .Fields }}
+type {{ .Name }} struct {
+	{{- range .Fields }}
+	{{- range .Doc }}
+	// {{ . }}
+	{{- end }}
+	{{ .Name }} {{ .Type }} {{ .Tag }}
+	{{- end }}
+}
+{{- else }}
+type {{ .Name }} {{ .Underlying }}
+{{- end }}
+{{- if .Enum }}
+{{- $type := .Name }}
+
+const (
+	{{- range .Enum }}
+	{{ .Name }} {{ $type }} = {{ .Value }}
+	{{- end }}
+)
+
+func ({{ $type }}) EnumValues() []string {
+	return []string{ {{- range $i, $v := .Enum }}{{ if $i }}, {{ end }}{{ $v.Value }}{{ end -}} }
+}
+{{- end }}
+{{ end }}
+{{- end }}
+
+{{- define "handlers" -}}
+package {{ .Package }}
+
+import (
+	"context"
+
+	"github.com/dennypenta/vel"
+)
+{{ range .Operations }}
+// {{ .FuncName }} serves {{ .Route }} of the original API.
+{{- range .Doc }}
+// {{ . }}
+{{- end }}
+func {{ .FuncName }}(ctx context.Context, req {{ .Input }}) ({{ .Output }}, *vel.Error) {
+	var res {{ .Output }}
+	return res, &vel.Error{Code: "NOT_IMPLEMENTED"}
+}
+{{ end }}
+{{- end }}
+
+{{- define "router" -}}
+package {{ .Package }}
+
+import "github.com/dennypenta/vel"
+
+// NewRouter registers the handlers of the imported API, an operation is served at its id, e.g. POST /createUser.
+func NewRouter() *vel.Router {
+	r := vel.NewRouter()
+	{{- range .Operations }}
+
+	// {{ .Route }}
+	vel.{{ .Register }}(r, "{{ .OperationID }}", {{ .FuncName }})
+	{{- if or .Description .Header .Errors }}.SetSpec(vel.Spec{
+		{{- if .Description }}
+		Description: {{ .Description }},
+		{{- end }}
+		{{- if .Header }}
+		RequestHeaders: vel.KeyValueSpec{Key: {{ .Header }}, Description: {{ .HeaderDesc }}{{ if .HeaderRequired }}, Validation: vel.Validation{Required: true}{{ end }}},
+		{{- end }}
+		{{- if .Errors }}
+		Errors: map[int][]vel.ErrorSpec{
+			{{- range .Errors }}
+			{{ .Status }}: {
+				{{- range .Errors }}
+				{Code: {{ .Code }}, Description: {{ .Description }}},
+				{{- end }}
+			},
+			{{- end }}
+		},
+		{{- end }}
+	})
+	{{- end }}
+	{{- end }}
+	return r
+}
+{{- end }}
@@ -0,0 +1,36 @@
+// Code generated by vel. DO NOT EDIT.
+
+package {{ .Package }}
+
+import (
+	{{- range .Imports }}
+	"{{ . }}"
+	{{- end }}
+	{{ range .Packages }}
+	"{{ . }}"
+	{{- end }}
+)
+{{ range .Types }}
+{{- if .Fields }}
+// {{ .FieldsVar }} are the JSON keys of the {{ .Name }} fields
+var {{ .FieldsVar }} = []string{ {{- .Fields -}} }
+{{ end }}
+// AppendJSON appends the JSON encoding of {{ .Name }} to b
+func (v {{ .Name }}) AppendJSON(b []byte) ([]byte, error) {
+	{{ .Encode }}
+}
+
+func (v {{ .Name }}) MarshalJSON() ([]byte, error) {
+	return v.AppendJSON(nil)
+}
+
+func (v *{{ .Name }}) UnmarshalJSON(data []byte) error {
+	r := veljson.NewReader(data)
+	v.readJSON(&r)
+	return r.End()
+}
+
+func (v *{{ .Name }}) readJSON(r *veljson.Reader) {
+	{{ .Decode }}
+}
+{{ end -}}
@@ -0,0 +1,25 @@
+VEL ?= go run github.com/dennypenta/vel/cmd/vel
+BIN ?= bin/{{ .Name }}
+
+.PHONY: run build test gen routes tidy
+
+# run serves the API on ADDR, :8080 by default
+run:
+	go run .
+
+# build is the hook of container builds, e.g. RUN make build
+build:
+	CGO_ENABLED=0 go build -o $(BIN) .
+
+test:
+	go test ./...
+
+# gen writes the clients and the spec declared in vel.yaml
+gen:
+	$(VEL) gen
+
+routes:
+	$(VEL) routes
+
+tidy:
+	go mod tidy
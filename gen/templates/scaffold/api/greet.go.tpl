@@ -0,0 +1,31 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/dennypenta/vel"
+)
+
+type GreetRequest struct {
+	Name string `json:"name"`
+}
+
+type GreetResponse struct {
+	Message string `json:"message"`
+}
+
+// GreetSpec documents the handler in the spec and the clients
+var GreetSpec = vel.Spec{
+	Description: "greets the caller by name",
+	Errors: map[int][]vel.ErrorSpec{
+		http.StatusBadRequest: {{ "{{" }}Code: "EMPTY_NAME", Description: "the name is empty"{{ "}}" }},
+	},
+}
+
+func Greet(ctx context.Context, req GreetRequest) (GreetResponse, *vel.Error) {
+	if req.Name == "" {
+		return GreetResponse{}, &vel.Error{Code: "EMPTY_NAME", Message: "name is required"}
+	}
+	return GreetResponse{Message: "Hello, " + req.Name}, nil
+}
@@ -0,0 +1,11 @@
+// Package api declares the routes of {{ .Name }}, vel.yaml points the vel command to NewRouter
+package api
+
+import "github.com/dennypenta/vel"
+
+// NewRouter registers the handlers, the clients and the spec are generated from it
+func NewRouter() *vel.Router {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "greet", Greet).SetSpec(GreetSpec)
+	return router
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/dennypenta/vel/veltest"
+)
+
+func TestGreet(t *testing.T) {
+	resp, velErr := veltest.Call[GreetRequest, GreetResponse](t, NewRouter(), "greet", GreetRequest{Name: "vel"})
+	if velErr != nil {
+		t.Fatalf("unexpected error %v", velErr)
+	}
+	if resp.Message != "Hello, vel" {
+		t.Errorf("unexpected message %q", resp.Message)
+	}
+}
+
+func TestGreetEmptyName(t *testing.T) {
+	_, velErr := veltest.Call[GreetRequest, GreetResponse](t, NewRouter(), "greet", GreetRequest{}, veltest.ExpectStatus(http.StatusBadRequest))
+	if velErr == nil || velErr.Code != "EMPTY_NAME" {
+		t.Errorf("expected EMPTY_NAME, got %v", velErr)
+	}
+}
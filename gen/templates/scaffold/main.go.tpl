@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/dennypenta/vel"
+	"{{ .Module }}/api"
+)
+
+func main() {
+	addr := os.Getenv("ADDR")
+	if addr == "" {
+		addr = ":8080"
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	router := api.NewRouter()
+	log.Printf("{{ .Name }} listens on %s", addr)
+	err := router.Serve(ctx, &http.Server{Addr: addr}, vel.ShutdownOpts{Grace: 5 * time.Second})
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,12 @@
+router: ./api.NewRouter
+clients:
+  - language: go
+    outputDir: ./client
+    postProcess: goimports
+  - language: ts
+    outputDir: ./sdk
+    zod: true
+openapi:
+  output: ./openapi.yaml
+  title: {{ .Name }}
+  version: 0.1.0
@@ -1,120 +1,1027 @@
+{{- if .Client.Zod }}
+import { z } from 'zod'
+
+{{ end -}}
+{{- if eq .File "types" }}
+{{- template "resultTypes" . }}
+{{- template "apiTypes" . }}
+{{- else }}
+{{- if eq .File "client" }}
+import type { {{ range $i, $t := .ClientTypeRefs }}{{ if $i }}, {{ end }}{{ $t }}{{ end }} } from './types'
+{{- if .Client.Zod }}
+import { {{ range $i, $t := .ClientSchemaRefs }}{{ if $i }}, {{ end }}{{ $t }}{{ end }} } from './types'
+{{- end }}
+
+{{ end -}}
 type FetchFn = typeof fetch
 
-type RequestOptions = Omit<RequestInit, 'method'> & {
-  query?: Record<string, string | number | boolean>
+export type ClientOptions = {
+  fetch?: FetchFn
+  // headers sent with every call
   headers?: Record<string, string>
+  cache?: ResponseCache
+  retry?: RetryPolicy
+  hooks?: Hooks
+  // sendSpecHash sends SPEC_HASH in the X-Spec-Hash header, a cross-origin call then needs a CORS preflight allowing it
+  sendSpecHash?: boolean
+  // onOutdated is called when the server runs another version of the API, e.g. to ask the user to reload
+  onOutdated?: () => void
 }
 
-export type Failure = {
-  error: ApiErrorPayload
+// RetryPolicy defines when and how often a failed call is retried.
+// Network errors are always retried, responses are retried when their status is in statuses
+// or the returned error code is in codes.
+// Retry-After header is respected on 429 and 503 responses.
+export type RetryPolicy = {
+  // maxAttempts includes the first call, 3 by default
+  maxAttempts?: number
+  // baseDelayMs is doubled on every attempt, 100 by default
+  baseDelayMs?: number
+  // maxDelayMs caps the backoff, 5000 by default
+  maxDelayMs?: number
+  // statuses defaults to 429, 502, 503, 504
+  statuses?: number[]
+  codes?: string[]
 }
 
-export type Success<T = void> = {
-  data: T
+// Hooks observe the calls of the client, e.g. to record metrics or traces, every hook is optional.
+// onRequest is called before a call is sent, onResponse once its response is received
+// and onError once the call fails with an error response or without a response at all.
+export type Hooks = {
+  onRequest?: (info: CallInfo) => void
+  onResponse?: (info: CallInfo) => void
+  onError?: (info: CallInfo) => void
 }
 
-export type Result<T = void> = Failure | Success<T>
+// CallInfo describes a call observed by Hooks
+export type CallInfo = {
+  operationId: string
+  // durationMs lasts until the response headers are received including the retries, it's 0 in onRequest
+  durationMs: number
+  // status is 0 if no response is received
+  status: number
+  // code is the code of the error response
+  code?: string
+  // error is the failure of the call without a response
+  error?: unknown
+}
 
-export type ApiErrorPayload = {
-  code: string
-  message: string
-  meta: Record<string, string>
+export type CallOptions = {
+  // baseUrl overrides the client base url for a single call
+  baseUrl?: string
+  headers?: Record<string, string>
+  signal?: AbortSignal
+  timeoutMs?: number
 }
 
-{{- range .Apis }}
-{{- range .DataTypes }}
-export type {{ .Name }} = {
-  {{- range .Fields }}
-  {{- if ne .JsonTag "" }}
-  {{ .JsonTag }}: {{ .TSTypeName }}
-  {{- else }}
-  {{ .Name }}: {{ .TSTypeName }}
+// QueryValue is a query parameter, the items of an array are repeated and a missing value is left out
+type QueryValue = string | number | boolean | null | undefined | Array<string | number | boolean>
+
+type RequestOptions = CallOptions & {
+  operationId?: string
+  query?: Record<string, QueryValue>
+  body?: BodyInit
+  cacheKey?: CacheKeyPolicy
+  // raw reads the response as a Blob
+  raw?: boolean
+}
+
+// CacheKeyPolicy customizes the cache key of an operation as declared in its spec
+type CacheKeyPolicy = {
+  ignoreQuery?: string[]
+  varyHeaders?: string[]
+}
+{{- if .Groups }}
+
+// RequestFn sends the calls of the sub-clients through the client
+type RequestFn = <T, E = ApiErrorPayload>(
+  method: string,
+  path: string,
+  opts?: RequestOptions,
+  {{- if .Client.Zod }}
+  schema?: z.ZodType<T>,
   {{- end }}
+) => Promise<Result<T, E>>
+{{- if .Streams }}
+
+// StreamFn sends the streaming calls of the sub-clients through the client
+type StreamFn = <T>(
+  method: string,
+  path: string,
+  sse: boolean,
+  opts?: RequestOptions,
+  {{- if .Client.Zod }}
+  schema?: z.ZodType<T>,
   {{- end }}
+) => AsyncGenerator<T>
+{{- end }}
+{{- end }}
+{{- if .Async }}
+
+// Operation is the state of an async operation, the calls of the async routes start it
+// and their Wait methods poll it until it's done
+export type Operation = {
+  id: string
+  name: string
+  status: 'pending' | 'running' | 'succeeded' | 'failed'
+  // result is the output of the succeeded operation
+  result?: unknown
+  // error is the error of the failed operation
+  error?: unknown
+  createdAt: string
+  updatedAt: string
 }
+{{- end }}
 
-{{ end }}
+{{- if not .File }}
+{{ template "resultTypes" . }}
+{{- end }}
 
-{{ end }}
+export interface ResponseCache {
+  get(key: string): string | undefined
+  set(key: string, value: string, ttlMs: number): void
+}
 
-class {{ .Client.TypeName }} {
-  constructor(
-    private baseUrl: string,
-    private fetchFn: FetchFn = window.fetch.bind(window),
-  ) {
-    if (!baseUrl.endsWith('/')) {
-      baseUrl = baseUrl + '/'
+export class MemoryCache implements ResponseCache {
+  private entries = new Map<string, { value: string; expiresAt: number }>()
+
+  get(key: string): string | undefined {
+    const entry = this.entries.get(key)
+    if (!entry) {
+      return undefined
+    }
+    if (entry.expiresAt < Date.now()) {
+      this.entries.delete(key)
+      return undefined
+    }
+    return entry.value
+  }
+
+  set(key: string, value: string, ttlMs: number): void {
+    this.entries.set(key, { value, expiresAt: Date.now() + ttlMs })
+  }
+}
+
+// ValidatorCache remembers the ETags of the GET responses along with their bodies to revalidate them by If-None-Match,
+// the oldest entry is dropped once it's full
+class ValidatorCache {
+  private entries = new Map<string, { etag: string; body: string }>()
+
+  constructor(private limit: number) {}
+
+  get(key: string): { etag: string; body: string } | undefined {
+    return this.entries.get(key)
+  }
+
+  set(key: string, etag: string, body: string): void {
+    this.entries.delete(key)
+    this.entries.set(key, { etag, body })
+    if (this.entries.size > this.limit) {
+      const oldest = this.entries.keys().next().value
+      if (oldest !== undefined) {
+        this.entries.delete(oldest)
+      }
+    }
+  }
+}
+
+// cacheKey identifies a cached response like the server does:
+// the url with the sorted query params except the ignored ones and the values of the vary headers
+function cacheKey(url: string, headers: Record<string, string>, policy: CacheKeyPolicy = {}): string {
+  const u = new URL(url)
+  for (const name of policy.ignoreQuery ?? []) {
+    u.searchParams.delete(name)
+  }
+  u.searchParams.sort()
+  let key = u.toString()
+  const lowerHeaders: Record<string, string> = {}
+  for (const [name, value] of Object.entries(headers)) {
+    lowerHeaders[name.toLowerCase()] = value
+  }
+  for (const name of policy.varyHeaders ?? []) {
+    key += '\n' + name + ': ' + (lowerHeaders[name.toLowerCase()] ?? '')
+  }
+  return key
+}
+
+// cacheTTL returns how long a response may be cached according to its Cache-Control header, 0 means never
+function cacheTTL(cacheControl: string | null): number {
+  if (!cacheControl) {
+    return 0
+  }
+  let ttl = 0
+  for (const part of cacheControl.split(',')) {
+    const directive = part.trim()
+    if (directive === 'no-store' || directive === 'no-cache') {
+      return 0
+    }
+    if (directive.startsWith('max-age=')) {
+      ttl = Number(directive.slice('max-age='.length)) * 1000
     }
-    this.baseUrl = baseUrl
-    this.fetchFn = fetchFn
   }
+  return Number.isFinite(ttl) ? ttl : 0
+}
+
+{{- if not .File }}
+{{- template "apiTypes" . }}
+{{- end }}
+
+{{- range $receiver := .Receivers }}
+{{- if eq $receiver $.Client.TypeName }}
 
-  private buildUrl(path: string, query?: Record<string, string | number | boolean>): string {
+// SPEC_HASH identifies the API the client is generated from, it's sent in the X-Spec-Hash header if sendSpecHash is set
+export const SPEC_HASH = '{{ $.SpecHash }}'
+
+class {{ $.Client.TypeName }} {
+  private baseUrl: string
+  private fetchFn: FetchFn
+  private headers: Record<string, string>
+  private cache?: ResponseCache
+  private retry?: RetryPolicy
+  private hooks?: Hooks
+  private validators = new ValidatorCache(1000)
+  private onOutdated?: () => void
+  {{- range $.GroupsOf $receiver }}
+  readonly {{ .Name }}: {{ .TypeName }}
+  {{- end }}
+
+  constructor(baseUrl: string, opts?: ClientOptions)
+  /** @deprecated pass the fetch and the cache in ClientOptions */
+  constructor(baseUrl: string, fetchFn?: FetchFn, cache?: ResponseCache)
+  constructor(baseUrl: string, opts?: ClientOptions | FetchFn, cache?: ResponseCache) {
+    if (typeof opts !== 'object') {
+      opts = { fetch: opts, cache }
+    }
+    this.baseUrl = withTrailingSlash(baseUrl)
+    this.fetchFn = opts.fetch ?? window.fetch.bind(window)
+    this.headers = opts.sendSpecHash ? { 'X-Spec-Hash': SPEC_HASH, ...opts.headers } : (opts.headers ?? {})
+    this.cache = opts.cache
+    this.retry = opts.retry
+    this.hooks = opts.hooks
+    this.onOutdated = opts.onOutdated
+    {{- with $.GroupsOf $receiver }}
+    const request: RequestFn = this.request.bind(this)
+    {{- if $.Streams }}
+    const stream: StreamFn = this.stream.bind(this)
+    {{- end }}
+    {{- range . }}
+    this.{{ .Name }} = new {{ .TypeName }}(request{{ if $.Streams }}, stream{{ end }})
+    {{- end }}
+    {{- end }}
+  }
+
+  private buildUrl(
+    path: string,
+    query?: Record<string, QueryValue>,
+    baseUrl?: string,
+  ): string {
     if (path.startsWith('/')) {
       path = path.slice(1)
     }
-    const url = new URL(path, this.baseUrl)
+    const url = new URL(path, baseUrl ? withTrailingSlash(baseUrl) : this.baseUrl)
     if (query) {
       for (const [key, val] of Object.entries(query)) {
-        url.searchParams.set(key, String(val))
+        if (val === undefined || val === null) {
+          continue
+        }
+        for (const item of Array.isArray(val) ? val : [val]) {
+          url.searchParams.append(key, String(item))
+        }
       }
     }
     return url.toString()
   }
 
-  private async request<T>(
+  private async request<T, E = ApiErrorPayload>(
     method: string,
     path: string,
     opts: RequestOptions = {},
-  ): Promise<Result<T>> {
-    const url = this.buildUrl(path, opts.query)
-    const res = await this.fetchFn(url, {
+    {{- if $.Client.Zod }}
+    schema?: z.ZodType<T>,
+    {{- end }}
+  ): Promise<Result<T, E>> {
+    const url = this.buildUrl(path, opts.query, opts.baseUrl)
+    const headers: Record<string, string> = {
+      'Content-Type': 'application/json',
+      ...this.headers,
+      ...opts.headers,
+    }
+    const key = cacheKey(url, headers, opts.cacheKey)
+    const cached = method === 'GET' ? this.cache?.get(key) : undefined
+    if (cached !== undefined) {
+      return { data: {{ if $.Client.Zod }}parseResponse(cached ? JSON.parse(cached) : {}, schema{{ if $.Client.CheckResponses }}, path{{ end }}){{ else }}(cached ? JSON.parse(cached) : {}) as T{{ end }} }
+    }
+    // the response got before is revalidated by its ETag, the caller's own validator takes precedence
+    const validator = method === 'GET' && !('If-None-Match' in headers) ? this.validators.get(key) : undefined
+    if (validator) {
+      headers['If-None-Match'] = validator.etag
+    }
+
+    let signal = opts.signal
+    if (opts.timeoutMs) {
+      const timeout = AbortSignal.timeout(opts.timeoutMs)
+      signal = signal ? AbortSignal.any([signal, timeout]) : timeout
+    }
+
+    const res = await this.call(opts.operationId ?? '', url, {
       method,
       credentials: 'include',
-      ...opts,
-      headers: {
-        'Content-Type': 'application/json',
-        ...opts.headers,
-      },
+      body: opts.body,
+      signal,
+      headers,
     })
+    if (res.headers.get('X-Spec-Outdated') === 'true') {
+      this.onOutdated?.()
+    }
 
-    if (!res.ok) {
+    // 304 Not Modified answers the revalidation of an unchanged response
+    const revalidated = res.status === 304 ? validator?.body : undefined
+    if (!res.ok && revalidated === undefined) {
       if (res.status >= 500) {
         const errText = await res.text()
         throw Error('http error: ' + errText)
       }
       const jsonErr = await res.json()
-      return { error: jsonErr as ApiErrorPayload }
+      return { error: {{ if $.ErrorShape.Envelope }}jsonErr['{{ $.ErrorShape.Envelope }}']{{ else }}jsonErr{{ end }} as E }
+    }
+    if (opts.raw) {
+      return { data: (await res.blob()) as T }
     }
 
-    const response = await res.text()
+    const response = revalidated ?? (await res.text())
+    const etag = res.headers.get('ETag')
+    if (method === 'GET' && revalidated === undefined && etag && !res.headers.get('Cache-Control')?.includes('no-store')) {
+      this.validators.set(key, etag, response)
+    }
+    if (method === 'GET' && this.cache) {
+      const ttl = cacheTTL(res.headers.get('Cache-Control'))
+      if (ttl > 0) {
+        this.cache.set(key, response, ttl)
+      }
+    }
     if (response) {
       const resp = JSON.parse(response)
-      return { data: resp as T }
+      return { data: {{ if $.Client.Zod }}parseResponse(resp, schema{{ if $.Client.CheckResponses }}, path{{ end }}){{ else }}resp as T{{ end }} }
     }
     return { data: {} as T }
   }
 
-  private async post<T>(path: string, body?: unknown, opts?: RequestOptions): Promise<Result<T>> {
-    return await this.request('POST', path, { ...opts, body: JSON.stringify(body) })
+{{- if $.Streams }}
+
+  // stream sends a call of a streaming route and yields its items,
+  // an SSE stream dropped by the network is resumed from the last event id the server sent
+  private async *stream<T, E = ApiErrorPayload>(
+    method: string,
+    path: string,
+    sse: boolean,
+    opts: RequestOptions = {},
+    {{- if $.Client.Zod }}
+    schema?: z.ZodType<T>,
+    {{- end }}
+  ): AsyncGenerator<T> {
+    const url = this.buildUrl(path, opts.query, opts.baseUrl)
+    const headers: Record<string, string> = {
+      'Content-Type': 'application/json',
+      Accept: sse ? 'text/event-stream' : 'application/x-ndjson',
+      ...this.headers,
+      ...opts.headers,
+    }
+    let signal = opts.signal
+    if (opts.timeoutMs) {
+      const timeout = AbortSignal.timeout(opts.timeoutMs)
+      signal = signal ? AbortSignal.any([signal, timeout]) : timeout
+    }
+
+    let lastEventId: string | undefined
+    let reconnectMs = 1000
+    for (;;) {
+      if (lastEventId !== undefined) {
+        headers['Last-Event-ID'] = lastEventId
+      }
+      const res = await this.call(opts.operationId ?? '', url, {
+        method,
+        credentials: 'include',
+        body: opts.body,
+        signal,
+        headers,
+      })
+      if (!res.ok) {
+        if (res.status >= 500) {
+          const errText = await res.text()
+          throw Error('http error: ' + errText)
+        }
+        const jsonErr = await res.json()
+        throw new StreamError({{ if $.ErrorShape.Envelope }}jsonErr['{{ $.ErrorShape.Envelope }}']{{ else }}jsonErr{{ end }} as E)
+      }
+      if (!res.body) {
+        return
+      }
+
+      try {
+        for await (const event of readEvents(res.body, sse)) {
+          lastEventId = event.id ?? lastEventId
+          reconnectMs = event.retry ?? reconnectMs
+          const payload = event.data === undefined ? undefined : JSON.parse(event.data)
+          if (event.event === 'error' || (!sse && event.data?.startsWith('{"$error":'))) {
+            const jsonErr = sse ? payload : payload['$error']
+            throw new StreamError({{ if $.ErrorShape.Envelope }}jsonErr['{{ $.ErrorShape.Envelope }}']{{ else }}jsonErr{{ end }} as E)
+          }
+          if (payload !== undefined && (event.event === undefined || event.event === 'message')) {
+            yield {{ if $.Client.Zod }}parseResponse(payload, schema{{ if $.Client.CheckResponses }}, path{{ end }}){{ else }}payload as T{{ end }}
+          }
+        }
+        return
+      } catch (err) {
+        // a stream without event ids can't be resumed, it would start over, nor can a streamed body be sent again
+        if (!sse || err instanceof StreamError || signal?.aborted || lastEventId === undefined || opts.body instanceof ReadableStream) {
+          throw err
+        }
+      }
+      await sleep(reconnectMs, signal)
+    }
+  }
+{{- end }}
+
+  // call sends the request calling the hooks around it
+  private async call(operationId: string, url: string, init: RequestInit): Promise<Response> {
+    // fetch sends a streamed body only in the half duplex mode
+    if (init.body instanceof ReadableStream) {
+      init = { ...init, duplex: 'half' } as RequestInit
+    }
+    const hooks = this.hooks
+    if (!hooks) {
+      return await this.send(url, init)
+    }
+    hooks.onRequest?.({ operationId, durationMs: 0, status: 0 })
+    const start = Date.now()
+    let res: Response
+    try {
+      res = await this.send(url, init)
+    } catch (error) {
+      hooks.onError?.({ operationId, durationMs: Date.now() - start, status: 0, error })
+      throw error
+    }
+    const info: CallInfo = { operationId, durationMs: Date.now() - start, status: res.status }
+    if (res.status >= 400) {
+      info.code = await errorCode(res)
+    }
+    hooks.onResponse?.(info)
+    if (res.status >= 400) {
+      hooks.onError?.(info)
+    }
+    return res
+  }
+
+  // send calls fetch, retrying the failed calls according to the retry policy
+  private async send(url: string, init: RequestInit): Promise<Response> {
+    const policy = this.retry
+    // a streamed body can't be sent twice
+    if (!policy || init.body instanceof ReadableStream) {
+      return await this.fetchFn(url, init)
+    }
+    const attempts = policy.maxAttempts && policy.maxAttempts > 0 ? policy.maxAttempts : 3
+    for (let attempt = 1; ; attempt++) {
+      let res: Response
+      try {
+        res = await this.fetchFn(url, init)
+      } catch (err) {
+        if (attempt >= attempts || init.signal?.aborted) {
+          throw err
+        }
+        await sleep(retryDelay(policy, attempt), init.signal)
+        continue
+      }
+      if (attempt >= attempts || !(await shouldRetry(policy, res))) {
+        return res
+      }
+      await res.body?.cancel()
+      await sleep(retryDelay(policy, attempt, res), init.signal)
+    }
+  }
+
+  private async post<T, E = ApiErrorPayload>(path: string, body?: unknown, opts?: RequestOptions{{ if $.Client.Zod }}, schema?: z.ZodType<T>{{ end }}): Promise<Result<T, E>> {
+    return await this.request('POST', path, { ...opts, body: JSON.stringify(body) }{{ if $.Client.Zod }}, schema{{ end }})
+  }
+
+  private async get<T, E = ApiErrorPayload>(path: string, opts?: RequestOptions{{ if $.Client.Zod }}, schema?: z.ZodType<T>{{ end }}): Promise<Result<T, E>> {
+    return await this.request('GET', path, opts{{ if $.Client.Zod }}, schema{{ end }})
+  }
+{{- if $.Client.Batch }}
+
+  // batch starts a batch of calls the server serves concurrently in a single request
+  batch(): Batch {
+    return new Batch((items, opts) => this.post<BatchResult[]>('batch', items, { ...opts, operationId: 'batch' }))
+  }
+{{- end }}
+
+{{- else }}
+
+// {{ $receiver }} calls the handlers of a subrouter through the client
+class {{ $receiver }} {
+  {{- range $.GroupsOf $receiver }}
+  readonly {{ .Name }}: {{ .TypeName }}
+  {{- end }}
+
+  constructor(
+    private request: RequestFn,
+    {{- if $.Streams }}
+    private stream: StreamFn,
+    {{- end }}
+  ) {
+    {{- range $.GroupsOf $receiver }}
+    this.{{ .Name }} = new {{ .TypeName }}(request{{ if $.Streams }}, stream{{ end }})
+    {{- end }}
   }
 
-  private async get<T>(path: string, opts?: RequestOptions): Promise<Result<T>> {
-    return await this.request('GET', path, opts)
+  private async post<T, E = ApiErrorPayload>(path: string, body?: unknown, opts?: RequestOptions{{ if $.Client.Zod }}, schema?: z.ZodType<T>{{ end }}): Promise<Result<T, E>> {
+    return await this.request('POST', path, { ...opts, body: JSON.stringify(body) }{{ if $.Client.Zod }}, schema{{ end }})
   }
 
-{{- range .Apis }}
-  async {{ .FuncName }}({{ if ne .Input.Name "" }}req: {{ .Input.Name }}{{ end }}): Promise<Result<{{ if ne .Output.Name "" }}{{ .Output.Name }}{{ else }}void{{ end }}>> {
+  private async get<T, E = ApiErrorPayload>(path: string, opts?: RequestOptions{{ if $.Client.Zod }}, schema?: z.ZodType<T>{{ end }}): Promise<Result<T, E>> {
+    return await this.request('GET', path, opts{{ if $.Client.Zod }}, schema{{ end }})
+  }
+{{- end }}
+{{- range $.ApisOf $receiver }}
+{{- if .OperationsPath }}
+  async {{ .FuncName }}({{ if ne .Input.Name "" }}req: {{ .Input.Name }}, {{ end }}opts?: CallOptions): Promise<Result<Operation{{ if .Errors }}, {{ .ErrorTypeName }}{{ end }}>> {
+    {{- if .Version }}
+    opts = { ...opts, headers: { 'Accept-Version': '{{ .Version }}', ...opts?.headers } }
+    {{- end }}
+    return await this.post('{{ .Path }}', {{ if ne .Input.Name "" }}req{{ else }}undefined{{ end }}, { ...opts, operationId: '{{ .OperationID }}' })
+  }
+
+  // {{ .FuncName }}Wait polls the operation started by {{ .FuncName }} until it's done, a failed operation is an error
+  async {{ .FuncName }}Wait(op: Operation, opts?: CallOptions): Promise<Result<{{ if ne .Output.Name "" }}{{ .Output.Name }}{{ else }}void{{ end }}{{ if .Errors }}, {{ .ErrorTypeName }}{{ end }}>> {
+    while (op.status === 'pending' || op.status === 'running') {
+      await new Promise((resolve) => setTimeout(resolve, 1000))
+      opts?.signal?.throwIfAborted()
+      const res = await this.get<Operation{{ if .Errors }}, {{ .ErrorTypeName }}{{ end }}>('{{ .OperationsPath }}' + encodeURIComponent(op.id), { ...opts, operationId: 'getOperation' })
+      if ('error' in res) {
+        return { error: res.error }
+      }
+      op = res.data
+    }
+    if (op.status === 'failed') {
+      return { error: {{ if $.ErrorShape.Envelope }}(op.error as Record<string, unknown>)['{{ $.ErrorShape.Envelope }}']{{ else }}op.error{{ end }} as {{ if .Errors }}{{ .ErrorTypeName }}{{ else }}ApiErrorPayload{{ end }} }
+    }
+    {{- if ne .Output.Name "" }}
+    return { data: {{ if $.Client.Zod }}parseResponse(op.result ?? {}, {{ .Output.Name }}Schema{{ if $.Client.CheckResponses }}, '{{ .Path }}'{{ end }}){{ else }}(op.result ?? {}) as {{ .Output.Name }}{{ end }} }
+    {{- else }}
+    return { data: undefined }
+    {{- end }}
+  }
+{{ else if not .Spec.Stream }}
+  async {{ .FuncName }}({{ if .RawInput }}body: BodyInit, {{ else if .InputItems }}items: AsyncIterable<{{ .Input.Name }}> | Iterable<{{ .Input.Name }}>, {{ else if ne .Input.Name "" }}req: {{ .Input.Name }}, {{ end }}opts?: CallOptions): Promise<Result<{{ if .RawOutput }}Blob{{ else if ne .Output.Name "" }}{{ .Output.Name }}{{ else }}void{{ end }}{{ if .Errors }}, {{ .ErrorTypeName }}{{ end }}>> {
+    {{- if .Version }}
+    opts = { ...opts, headers: { 'Accept-Version': '{{ .Version }}', ...opts?.headers } }
+    {{- end }}
+    {{- if .RawInput }}
+    opts = { ...opts, headers: { 'Content-Type': '{{ or .Spec.RequestContentType "application/octet-stream" }}', ...opts?.headers } }
+    {{- else if .InputItems }}
+    opts = { ...opts, headers: { 'Content-Type': 'application/x-ndjson', ...opts?.headers } }
+    {{- end }}
     {{- if eq .Method "GET" }}
-    const query: Record<string, string | number | boolean> = {}
+    const query: Record<string, QueryValue> = {}
+    {{- range .QueryParams }}
+    query['{{ .Key }}'] = {{ .TSExpr }}
+    {{- end }}
+    {{- if or .Spec.Cache.IgnoreQuery .Spec.Cache.VaryHeaders }}
+    const cacheKey: CacheKeyPolicy = {
+      {{- if .Spec.Cache.IgnoreQuery }}
+      ignoreQuery: [{{ range $i, $q := .Spec.Cache.IgnoreQuery }}{{ if $i }}, {{ end }}'{{ $q }}'{{ end }}],
+      {{- end }}
+      {{- if .Spec.Cache.VaryHeaders }}
+      varyHeaders: [{{ range $i, $h := .Spec.Cache.VaryHeaders }}{{ if $i }}, {{ end }}'{{ $h }}'{{ end }}],
+      {{- end }}
+    }
+    return await this.get('{{ .Path }}', { ...opts, operationId: '{{ .OperationID }}', query, cacheKey{{ if .RawOutput }}, raw: true{{ end }} }{{ if and $.Client.Zod (ne .Output.Name "") (not .RawOutput) }}, {{ .Output.Name }}Schema{{ end }})
+    {{- else }}
+    return await this.get('{{ .Path }}', { ...opts, operationId: '{{ .OperationID }}', query{{ if .RawOutput }}, raw: true{{ end }} }{{ if and $.Client.Zod (ne .Output.Name "") (not .RawOutput) }}, {{ .Output.Name }}Schema{{ end }})
+    {{- end }}
+    {{- else if .Form }}
+    const form = new URLSearchParams()
     {{- range .Input.Fields }}
-    query['{{ .SchemaTag }}'] = req.{{ .Name }}
+    {{- if .SchemaTag }}
+    form.set('{{ .SchemaTag }}', String(req.{{ .Name }}))
+    {{- end }}
+    {{- end }}
+    opts = { ...opts, headers: { 'Content-Type': 'application/x-www-form-urlencoded', ...opts?.headers } }
+    return await this.request('POST', '{{ .Path }}', { ...opts, operationId: '{{ .OperationID }}', body: form{{ if .RawOutput }}, raw: true{{ end }} }{{ if and $.Client.Zod (ne .Output.Name "") (not .RawOutput) }}, {{ .Output.Name }}Schema{{ end }})
+    {{- else if .InputItems }}
+    return await this.request('POST', '{{ .Path }}', { ...opts, operationId: '{{ .OperationID }}', body: ndjsonBody(items){{ if .RawOutput }}, raw: true{{ end }} }{{ if and $.Client.Zod (ne .Output.Name "") (not .RawOutput) }}, {{ .Output.Name }}Schema{{ end }})
+    {{- else if .RawInput }}
+    return await this.request('POST', '{{ .Path }}', { ...opts, operationId: '{{ .OperationID }}', body{{ if .RawOutput }}, raw: true{{ end }} }{{ if and $.Client.Zod (ne .Output.Name "") (not .RawOutput) }}, {{ .Output.Name }}Schema{{ end }})
+    {{- else }}
+    return await this.post('{{ .Path }}', {{ if ne .Input.Name "" }}req{{ else }}undefined{{ end }}, { ...opts, operationId: '{{ .OperationID }}'{{ if .RawOutput }}, raw: true{{ end }} }{{ if and $.Client.Zod (ne .Output.Name "") (not .RawOutput) }}, {{ .Output.Name }}Schema{{ end }})
+    {{- end }}
+  }
+{{ else }}
+  // {{ .FuncName }} yields the items the server streams, an error the server sends is thrown as a StreamError
+  {{ .FuncName }}({{ if .InputItems }}items: AsyncIterable<{{ .Input.Name }}> | Iterable<{{ .Input.Name }}>, {{ else if ne .Input.Name "" }}req: {{ .Input.Name }}, {{ end }}opts?: CallOptions): AsyncIterable<{{ .Output.Name }}> {
+    {{- if .Version }}
+    opts = { ...opts, headers: { 'Accept-Version': '{{ .Version }}', ...opts?.headers } }
+    {{- end }}
+    {{- if .InputItems }}
+    opts = { ...opts, headers: { 'Content-Type': 'application/x-ndjson', ...opts?.headers } }
     {{- end }}
-    return await this.get('{{ .OperationID }}', { query })
+    {{- if eq .Method "GET" }}
+    const query: Record<string, QueryValue> = {}
+    {{- range .QueryParams }}
+    query['{{ .Key }}'] = {{ .TSExpr }}
+    {{- end }}
+    return this.stream('GET', '{{ .Path }}', {{ if eq .Spec.Stream "sse" }}true{{ else }}false{{ end }}, { ...opts, operationId: '{{ .OperationID }}', query }{{ if $.Client.Zod }}, {{ .Output.Name }}Schema{{ end }})
+    {{- else if .InputItems }}
+    return this.stream('POST', '{{ .Path }}', {{ if eq .Spec.Stream "sse" }}true{{ else }}false{{ end }}, { ...opts, operationId: '{{ .OperationID }}', body: ndjsonBody(items) }{{ if $.Client.Zod }}, {{ .Output.Name }}Schema{{ end }})
     {{- else }}
-    return await this.post('{{ .OperationID }}'{{ if ne .Input.Name "" }}, req{{ end }})
+    return this.stream('POST', '{{ .Path }}', {{ if eq .Spec.Stream "sse" }}true{{ else }}false{{ end }}, { ...opts, operationId: '{{ .OperationID }}', body: JSON.stringify({{ if ne .Input.Name "" }}req{{ else }}undefined{{ end }}) }{{ if $.Client.Zod }}, {{ .Output.Name }}Schema{{ end }})
     {{- end }}
   }
 {{ end }}
+{{- end }}
+}
+{{- end }}
+{{- if .Client.Batch }}
+{{ template "batch" . }}
+{{- end }}
+
+function withTrailingSlash(url: string): string {
+  return url.endsWith('/') ? url : url + '/'
+}
+{{- if .Uploads }}
+
+// ndjsonBody streams the items as lines of JSON while the request is sent, the items are encoded as they're read
+function ndjsonBody<T>(items: AsyncIterable<T> | Iterable<T>): ReadableStream<Uint8Array> {
+  const encoder = new TextEncoder()
+  const lines = (async function* () {
+    for await (const item of items) {
+      yield encoder.encode(JSON.stringify(item) + '\n')
+    }
+  })()
+  return new ReadableStream({
+    async pull(controller) {
+      const { value, done } = await lines.next()
+      if (done) {
+        controller.close()
+      } else {
+        controller.enqueue(value)
+      }
+    },
+    async cancel() {
+      await lines.return(undefined)
+    },
+  })
+}
+{{- end }}
+{{- if .Streams }}
+
+// StreamError is thrown by a stream once the server answers an error or ends the stream with an error
+export class StreamError<E = ApiErrorPayload> extends Error {
+  constructor(readonly error: E) {
+    super('stream error: ' + JSON.stringify(error))
+    this.name = 'StreamError'
+  }
+}
+
+// StreamEvent is a server-sent event, an NDJSON line is the data of an event
+type StreamEvent = {
+  event?: string
+  data?: string
+  id?: string
+  retry?: number
+}
+
+// readEvents parses the server-sent events or the NDJSON lines of a stream body, comments are skipped
+async function* readEvents(body: ReadableStream<Uint8Array>, sse: boolean): AsyncGenerator<StreamEvent> {
+  const reader = body.pipeThrough(new TextDecoderStream()).getReader()
+  let buffer = ''
+  let event: StreamEvent = {}
+  let data: string[] = []
+  try {
+    for (;;) {
+      const { done, value } = await reader.read()
+      if (done) {
+        return
+      }
+      buffer += value
+      const lines = buffer.split('\n')
+      buffer = lines.pop() ?? ''
+      for (let line of lines) {
+        if (line.endsWith('\r')) {
+          line = line.slice(0, -1)
+        }
+        if (!sse) {
+          if (line.trim()) {
+            yield { data: line }
+          }
+          continue
+        }
+        // a blank line dispatches the event
+        if (line === '') {
+          if (data.length > 0 || event.id !== undefined || event.retry !== undefined) {
+            yield { ...event, data: data.length > 0 ? data.join('\n') : undefined }
+          }
+          event = {}
+          data = []
+          continue
+        }
+        const colon = line.indexOf(':')
+        if (colon === 0) {
+          continue
+        }
+        const field = colon < 0 ? line : line.slice(0, colon)
+        let value = colon < 0 ? '' : line.slice(colon + 1)
+        if (value.startsWith(' ')) {
+          value = value.slice(1)
+        }
+        if (field === 'event') {
+          event.event = value
+        } else if (field === 'data') {
+          data.push(value)
+        } else if (field === 'id') {
+          event.id = value
+        } else if (field === 'retry' && /^\d+$/.test(value)) {
+          event.retry = Number(value)
+        }
+      }
+    }
+  } finally {
+    // breaking the loop closes the response
+    await reader.cancel().catch(() => {})
+  }
+}
+{{- end }}
+
+async function shouldRetry(policy: RetryPolicy, res: Response): Promise<boolean> {
+  if ((policy.statuses ?? [429, 502, 503, 504]).includes(res.status)) {
+    return true
+  }
+  if (!policy.codes?.length || res.status < 400) {
+    return false
+  }
+  const code = await errorCode(res)
+  return code !== undefined && policy.codes.includes(code)
+}
+
+// errorCode reads the code of an error response, the body stays unread for the caller
+async function errorCode(res: Response): Promise<string | undefined> {
+  const payload = await res.clone().json().catch(() => undefined)
+  const code = {{ if .ErrorShape.Envelope }}payload?.['{{ .ErrorShape.Envelope }}']?.['{{ .ErrorShape.CodeField }}']{{ else }}payload?.['{{ .ErrorShape.CodeField }}']{{ end }}
+  return typeof code === 'string' ? code : undefined
+}
+
+function retryDelay(policy: RetryPolicy, attempt: number, res?: Response): number {
+  const retryAfter = res?.status === 429 || res?.status === 503 ? res.headers.get('Retry-After') : null
+  if (retryAfter) {
+    const seconds = Number(retryAfter)
+    if (Number.isInteger(seconds)) {
+      return seconds * 1000
+    }
+    const at = Date.parse(retryAfter)
+    if (!Number.isNaN(at)) {
+      return Math.max(at - Date.now(), 0)
+    }
+  }
+
+  const maxDelay = policy.maxDelayMs && policy.maxDelayMs > 0 ? policy.maxDelayMs : 5000
+  const base = policy.baseDelayMs && policy.baseDelayMs > 0 ? policy.baseDelayMs : 100
+  // full jitter
+  return Math.random() * Math.min(base * 2 ** (attempt - 1), maxDelay)
+}
+
+// sleep waits for the given time, it rejects with the abort reason once the signal is aborted
+function sleep(ms: number, signal?: AbortSignal | null): Promise<void> {
+  return new Promise((resolve, reject) => {
+    if (signal?.aborted) {
+      reject(signal.reason)
+      return
+    }
+    const onAbort = () => {
+      clearTimeout(timer)
+      reject(signal?.reason)
+    }
+    const timer = setTimeout(() => {
+      signal?.removeEventListener('abort', onAbort)
+      resolve()
+    }, ms)
+    signal?.addEventListener('abort', onAbort, { once: true })
+  })
 }
+{{- if .Client.CheckResponses }}
 
+// ResponseShapeError reports a response that doesn't match the schema of the server type,
+// the server and the client drifted apart
+export class ResponseShapeError extends Error {
+  constructor(
+    readonly path: string,
+    readonly issues: { path: PropertyKey[]; message: string }[],
+  ) {
+    super(`the response of ${path} doesn't match its schema: ` + issues.map((i) => i.path.join('.') + ': ' + i.message).join('; '))
+    this.name = 'ResponseShapeError'
+  }
+}
+
+// parseResponse checks a response against the schema of the server type, a mismatch is logged as a ResponseShapeError
+// and the response is returned as is, so the drift surfaces without breaking the call
+function parseResponse<T>(data: unknown, schema: z.ZodType<T> | undefined, path: string): T {
+  if (!schema) {
+    return data as T
+  }
+  const parsed = schema.safeParse(data)
+  if (!parsed.success) {
+    console.error(new ResponseShapeError(path, parsed.error.issues))
+    return data as T
+  }
+  return parsed.data
+}
+{{- else if .Client.Zod }}
+
+// parseResponse validates a response against the schema of the server type,
+// it throws a ZodError once the server and the client drift apart
+function parseResponse<T>(data: unknown, schema?: z.ZodType<T>): T {
+  return schema ? schema.parse(data) : (data as T)
+}
+{{- end }}
+{{- end }}
+
+{{- define "batch" }}
+type BatchItem = {
+  operationId: string
+  body?: unknown
+}
+
+type BatchResult = {
+  status: number
+  body?: unknown
+}
+
+type BatchCall = {
+  settle: (result: BatchResult) => void
+  fail: (error: unknown) => void
+  reject: (err: unknown) => void
+}
+
+// Batch collects calls the server serves concurrently in a single request,
+// the promises of the calls settle once the batch is sent
+export class Batch {
+  private items: BatchItem[] = []
+  private calls: BatchCall[] = []
+
+  constructor(private sendFn: (items: BatchItem[], opts?: CallOptions) => Promise<Result<BatchResult[]>>) {}
+
+  private add<T, E = ApiErrorPayload>(operationId: string, body: unknown{{ if .Client.Zod }}, schema?: z.ZodType<T>{{ end }}): Promise<Result<T, E>> {
+    const call = new Promise<Result<T, E>>((resolve, reject) => {
+      this.calls.push({
+        settle: (result) => {
+          if (result.status >= 500) {
+            reject(Error('http error: ' + JSON.stringify(result.body)))
+          } else if (result.status >= 400) {
+            resolve({ error: {{ if .ErrorShape.Envelope }}(result.body as Record<string, unknown>)['{{ .ErrorShape.Envelope }}']{{ else }}result.body{{ end }} as E })
+          } else {
+            resolve({ data: {{ if .Client.Zod }}parseResponse(result.body ?? {}, schema){{ else }}(result.body ?? {}) as T{{ end }} })
+          }
+        },
+        fail: (error) => resolve({ error: error as E }),
+        reject,
+      })
+    })
+    // a call nobody awaits doesn't make an unhandled rejection once the batch fails
+    call.catch(() => {})
+    this.items.push({ operationId, body })
+    return call
+  }
+
+  // send calls the batch endpoint and settles the calls, a failed batch fails every call with its error
+  async send(opts?: CallOptions): Promise<Result> {
+    let res: Result<BatchResult[]>
+    try {
+      res = await this.sendFn(this.items, opts)
+    } catch (err) {
+      this.calls.forEach((call) => call.reject(err))
+      throw err
+    }
+    if ('error' in res) {
+      const error = res.error
+      this.calls.forEach((call) => call.fail(error))
+      return res
+    }
+    if (res.data.length !== this.calls.length) {
+      const err = Error('expected ' + this.calls.length + ' batch results, got ' + res.data.length)
+      this.calls.forEach((call) => call.reject(err))
+      throw err
+    }
+    res.data.forEach((result, i) => this.calls[i].settle(result))
+    return { data: undefined }
+  }
+{{- range .BatchApis }}
+
+  {{ .FuncName }}({{ if ne .Input.Name "" }}req: {{ .Input.Name }}{{ end }}): Promise<Result<{{ if ne .Output.Name "" }}{{ .Output.Name }}{{ else }}void{{ end }}{{ if .Errors }}, {{ .ErrorTypeName }}{{ end }}>> {
+    return this.add('{{ .OperationID }}', {{ if ne .Input.Name "" }}req{{ else }}undefined{{ end }}{{ if and $.Client.Zod (ne .Output.Name "") }}, {{ .Output.Name }}Schema{{ end }})
+  }
+{{- end }}
+}
+{{- end }}
+
+{{- define "resultTypes" }}
+export type Failure<E = ApiErrorPayload> = {
+  error: E
+}
+
+export type Success<T = void> = {
+  data: T
+}
+
+export type Result<T = void, E = ApiErrorPayload> = Failure<E> | Success<T>
+
+export type ApiErrorPayload = {
+  {{ .ErrorShape.CodeField }}: string
+  {{ .ErrorShape.MessageField }}: string
+  {{ .ErrorShape.MetaField }}: Record<string, string>
+  {{ .ErrorShape.ViolationsField }}?: Violation[]
+  {{- range .ErrorShape.Extra }}
+  {{ .Key }}: {{ .TSType }}
+  {{- end }}
+}
+
+// ViolationRule lists the known rules, handlers may report their own ones
+export type ViolationRule = {{ range .ErrorShape.Rules }}'{{ . }}' | {{ end }}(string & {})
+
+export type Violation = {
+  field: string
+  rule: ViolationRule
+  params?: Record<string, string>
+}
+{{- end }}
+
+{{- define "apiTypes" }}
+{{- range .Operations }}
+{{- . }}
+{{- end }}
+{{- end }}
+
+{{- define "operation" }}
+{{- if .Errors }}
+export type {{ .ErrorTypeName }} =
+  {{- range .Errors }}
+  | {
+      {{ $.ErrorShape.CodeField }}: '{{ .Code }}'
+      {{ $.ErrorShape.MessageField }}: string
+      {{ $.ErrorShape.MetaField }}: { {{- range .Meta }} {{ .TSKey }}{{ if not .Required }}?{{ end }}: string;{{ end }} }
+      {{- if .Violations }}
+      {{ $.ErrorShape.ViolationsField }}: Violation[]
+      {{- end }}
+      {{- range $.ErrorShape.Extra }}
+      {{ .Key }}: {{ .TSType }}
+      {{- end }}
+    }
+  {{- end }}
+
+{{ end }}
+{{- range .DataTypes }}
+{{- if .Primitive }}
+{{- if $.Client.Zod }}
+export const {{ .Name }}Schema = {{ .ZodType }}
+
+export type {{ .Name }} = z.infer<typeof {{ .Name }}Schema>
+{{- else }}
+export type {{ .Name }} = {{ .TSType }}
+{{- end }}
+{{- else if and .Union $.Client.Zod .Recursive }}
+export type {{ .Name }} = {{ range $i, $v := .Union.Variants }}{{ if $i }} | {{ end }}{{ $v.Name }}{{ end }}
+
+export const {{ .Name }}Schema: z.ZodType<{{ .Name }}> = z.union([{{ range $i, $v := .Union.Variants }}{{ if $i }}, {{ end }}{{ $v.ZodType }}{{ end }}])
+{{- else if and .Union $.Client.Zod }}
+export const {{ .Name }}Schema = z.union([{{ range $i, $v := .Union.Variants }}{{ if $i }}, {{ end }}{{ $v.ZodType }}{{ end }}])
+
+export type {{ .Name }} = z.infer<typeof {{ .Name }}Schema>
+{{- else if .Union }}
+export type {{ .Name }} = {{ range $i, $v := .Union.Variants }}{{ if $i }} | {{ end }}{{ $v.Name }}{{ end }}
+{{- else if and $.Client.Zod .Recursive }}
+export type {{ .Name }} = {
+  {{- range .Fields }}
+  {{ .TSKey }}{{ if .Optional }}?{{ end }}: {{ .ZodTSType }}
+  {{- end }}
+}
+
+export const {{ .Name }}Schema: z.ZodType<{{ .Name }}> = z.object({
+  {{- range .Fields }}
+  {{ .TSKey }}: {{ .ZodType }},
+  {{- end }}
+})
+{{- else if $.Client.Zod }}
+export const {{ .Name }}Schema = z.object({
+  {{- range .Fields }}
+  {{ .TSKey }}: {{ .ZodType }},
+  {{- end }}
+})
+
+export type {{ .Name }} = z.infer<typeof {{ .Name }}Schema>
+{{- else }}
+export type {{ .Name }} = {
+  {{- range .Fields }}
+  {{ .TSKey }}{{ if .Optional }}?{{ end }}: {{ .TSTypeName }}
+  {{- end }}
+}
+{{- end }}
+
+{{ end }}
+{{- end }}
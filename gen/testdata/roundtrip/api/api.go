@@ -0,0 +1,80 @@
+// Package api is the server the generated client of the round trip test calls, see TestGoClientRoundTrip
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/dennypenta/vel"
+)
+
+type PriceQuery struct {
+	Symbol string `schema:"symbol"`
+}
+
+type Price struct {
+	Symbol string `json:"symbol"`
+	Cents  int    `json:"cents"`
+}
+
+type ChargeRequest struct {
+	Amount int `json:"amount"`
+}
+
+type Charge struct {
+	ID     string `json:"id"`
+	Amount int    `json:"amount"`
+}
+
+type FindUserRequest struct {
+	ID string `json:"id"`
+}
+
+type User struct {
+	ID string `json:"id"`
+}
+
+// Server counts the calls of the handlers, the first call of flaky and charge fails with 503
+type Server struct {
+	Flaky    atomic.Int32
+	Charges  atomic.Int32
+	Prices   atomic.Int32
+	Profiles atomic.Int32
+}
+
+func NewRouter(s *Server) *vel.Router {
+	router := vel.NewRouter()
+	unavailable := &vel.Error{Code: "UNAVAILABLE", Status: http.StatusServiceUnavailable}
+
+	vel.RegisterGet(router, "flaky", func(ctx context.Context, req PriceQuery) (Price, *vel.Error) {
+		if s.Flaky.Add(1) == 1 {
+			return Price{}, unavailable
+		}
+		return Price{Symbol: req.Symbol, Cents: 100}, nil
+	})
+	vel.RegisterPost(router, "charge", func(ctx context.Context, req ChargeRequest) (Charge, *vel.Error) {
+		if s.Charges.Add(1) == 1 {
+			return Charge{}, unavailable
+		}
+		return Charge{ID: "ch_1", Amount: req.Amount}, nil
+	}, vel.Idempotency(vel.NewMemoryIdempotencyStore(time.Minute), vel.IdempotencyOpts{}))
+	vel.RegisterGet(router, "price", func(ctx context.Context, req PriceQuery) (Price, *vel.Error) {
+		s.Prices.Add(1)
+		return Price{Symbol: req.Symbol, Cents: 200}, nil
+	}).SetSpec(vel.Spec{Cache: vel.CachePolicy{MaxAge: time.Minute}})
+	vel.RegisterGet(router, "profile", func(ctx context.Context, req PriceQuery) (User, *vel.Error) {
+		s.Profiles.Add(1)
+		return User{ID: req.Symbol}, nil
+	}, vel.ETag(vel.ETagOpts{}))
+	vel.RegisterPost(router, "findUser", func(ctx context.Context, req FindUserRequest) (User, *vel.Error) {
+		return User{}, &vel.Error{Code: "USER_NOT_FOUND", Meta: vel.ErrorMeta{"user_id": req.ID, "attempts": "2"}}
+	}).SetSpec(vel.Spec{Errors: map[int][]vel.ErrorSpec{
+		http.StatusNotFound: {{Code: "USER_NOT_FOUND", Meta: []vel.KeyValueSpec{
+			{Key: "user_id", ValueType: vel.String},
+			{Key: "attempts", ValueType: vel.Int},
+		}}},
+	}})
+	return router
+}
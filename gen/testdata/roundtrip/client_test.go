@@ -0,0 +1,102 @@
+package roundtrip
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"roundtrip/api"
+	"roundtrip/client"
+)
+
+func newClient(t *testing.T) (*client.Client, *api.Server) {
+	t.Helper()
+	s := &api.Server{}
+	c := client.NewClientFromHandler(api.NewRouter(s).Mux())
+	return c.WithRetry(client.RetryPolicy{BaseDelay: time.Millisecond}), s
+}
+
+func TestRetryUnavailable(t *testing.T) {
+	c, s := newClient(t)
+	price, err := c.Flaky(context.Background(), client.PriceQuery{Symbol: "VEL"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if price.Cents != 100 || s.Flaky.Load() != 2 {
+		t.Errorf("expected the price after 2 calls, got %+v after %d", price, s.Flaky.Load())
+	}
+}
+
+func TestRetryIdempotentPost(t *testing.T) {
+	c, s := newClient(t)
+	// a POST without the key isn't retried
+	_, err := c.Charge(context.Background(), client.ChargeRequest{Amount: 5})
+	var e *client.Error
+	if !errors.As(err, &e) || e.Code != "UNAVAILABLE" || s.Charges.Load() != 1 {
+		t.Fatalf("expected UNAVAILABLE after 1 call, got %v after %d", err, s.Charges.Load())
+	}
+
+	s.Charges.Store(0)
+	charge, err := c.Charge(context.Background(), client.ChargeRequest{Amount: 5}, client.WithHeader("Idempotency-Key", "k1"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if charge.Amount != 5 || s.Charges.Load() != 2 {
+		t.Errorf("expected the charge after 2 calls, got %+v after %d", charge, s.Charges.Load())
+	}
+
+	// the stored response is replayed
+	replayed, err := c.Charge(context.Background(), client.ChargeRequest{Amount: 5}, client.WithHeader("Idempotency-Key", "k1"))
+	if err != nil || replayed != charge || s.Charges.Load() != 2 {
+		t.Errorf("expected the replayed charge %+v, got %+v %v after %d", charge, replayed, err, s.Charges.Load())
+	}
+}
+
+func TestCacheHit(t *testing.T) {
+	c, s := newClient(t)
+	c = c.WithCache(client.NewMemoryCache())
+	for range 2 {
+		price, err := c.Price(context.Background(), client.PriceQuery{Symbol: "VEL"})
+		if err != nil || price.Cents != 200 {
+			t.Fatalf("expected the price, got %+v %v", price, err)
+		}
+	}
+	if s.Prices.Load() != 1 {
+		t.Errorf("expected the second call served from the cache, got %d calls", s.Prices.Load())
+	}
+}
+
+func TestRevalidation(t *testing.T) {
+	c, s := newClient(t)
+	var statuses []int
+	c = c.WithInterceptor(func(r *http.Request, next client.RoundTripFunc) (*http.Response, error) {
+		resp, err := next(r)
+		if err == nil {
+			statuses = append(statuses, resp.StatusCode)
+		}
+		return resp, err
+	})
+	for range 2 {
+		user, err := c.Profile(context.Background(), client.PriceQuery{Symbol: "u1"})
+		if err != nil || user.ID != "u1" {
+			t.Fatalf("expected the profile, got %+v %v", user, err)
+		}
+	}
+	if len(statuses) != 2 || statuses[0] != http.StatusOK || statuses[1] != http.StatusNotModified || s.Profiles.Load() != 2 {
+		t.Errorf("expected 200 and 304, got %v after %d calls", statuses, s.Profiles.Load())
+	}
+}
+
+func TestTypedError(t *testing.T) {
+	c, _ := newClient(t)
+	_, err := c.FindUser(context.Background(), client.FindUserRequest{ID: "u1"})
+	var notFound *client.UserNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("expected UserNotFoundError, got %T %v", err, err)
+	}
+	if notFound.UserId != "u1" || notFound.Attempts != 2 || notFound.Err.Code != "USER_NOT_FOUND" {
+		t.Errorf("expected the parsed meta, got %+v", notFound)
+	}
+}
@@ -0,0 +1,23 @@
+// Command generate writes the client of the api router to the client directory
+package main
+
+import (
+	"log"
+
+	"github.com/dennypenta/vel/gen"
+
+	"roundtrip/api"
+)
+
+func main() {
+	err := gen.GenerateClientToFile(api.NewRouter(&api.Server{}), gen.ClientGeneratorConfig{
+		TypeName:    "Client",
+		PackageName: "client",
+		OutputDir:   "client",
+		Language:    "go",
+		PostProcess: "goimports",
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -1,33 +1,83 @@
 package client
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"maps"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
 	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 type Client struct {
 	client *http.Client
 
-	baseUrl string
-	headers http.Header
+	baseUrl      string
+	headers      http.Header
+	interceptors []Interceptor
+	retry        *RetryPolicy
+	hooks        Hooks
+	cache        Cache
+	validators   *validatorCache
 }
 
+// RoundTripFunc sends a request and returns its response, see Interceptor.
+type RoundTripFunc func(r *http.Request) (*http.Response, error)
+
+// Interceptor wraps every call made by the client,
+// it may modify the request, inspect the response or skip calling next entirely.
+type Interceptor func(r *http.Request, next RoundTripFunc) (*http.Response, error)
+
 func NewClient(baseUrl string, client *http.Client, headers map[string]string) *Client {
 	h := make(http.Header)
 	for k, v := range headers {
 		h.Set(k, v)
 	}
 	return &Client{
-		client:  client,
-		baseUrl: baseUrl,
-		headers: h,
+		client:     client,
+		baseUrl:    baseUrl,
+		headers:    h,
+		validators: newValidatorCache(),
+	}
+}
+
+// NewClientFromHandler creates a client dispatching calls to the handler in-process without opening sockets,
+// e.g. NewClientFromHandler(router.Mux()) in service tests.
+func NewClientFromHandler(handler http.Handler) *Client {
+	return NewClient("http://in-process", &http.Client{Transport: handlerTransport{handler: handler}}, nil)
+}
+
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	serverReq := r.Clone(r.Context())
+	serverReq.RequestURI = r.URL.RequestURI()
+	serverReq.RemoteAddr = "127.0.0.1:0"
+	if serverReq.Body == nil {
+		serverReq.Body = http.NoBody
 	}
+
+	rec := httptest.NewRecorder()
+	t.handler.ServeHTTP(rec, serverReq)
+	resp := rec.Result()
+	resp.Request = r
+	return resp, nil
 }
 
 func (c *Client) WithHeaders(headers map[string]string) *Client {
@@ -36,56 +86,617 @@ func (c *Client) WithHeaders(headers map[string]string) *Client {
 	for k, v := range headers {
 		hCopy.Set(k, v)
 	}
-	return &Client{
-		client:  c.client,
-		baseUrl: c.baseUrl,
-		headers: hCopy,
+	cCopy := c.clone()
+	cCopy.headers = hCopy
+	return cCopy
+}
+
+// WithInterceptor returns a copy of the client calling the given interceptors on every request,
+// the first given interceptor is the outermost one.
+func (c *Client) WithInterceptor(interceptors ...Interceptor) *Client {
+	cCopy := c.clone()
+	cCopy.interceptors = append(slices.Clone(c.interceptors), interceptors...)
+	return cCopy
+}
+
+func (c *Client) clone() *Client {
+	cCopy := *c
+	return &cCopy
+}
+
+func (c *Client) do(r *http.Request) (*http.Response, error) {
+	if r.Method != http.MethodGet {
+		return c.send(r)
+	}
+	if c.cache != nil {
+		return c.cachedSend(r)
+	}
+	return c.conditionalSend(r)
+}
+
+func (c *Client) send(r *http.Request) (*http.Response, error) {
+	return c.roundTrip(r, c.client.Do)
+}
+
+// roundTrip passes the request to the transport through the interceptors, the retry policy and the hooks
+func (c *Client) roundTrip(r *http.Request, transport RoundTripFunc) (*http.Response, error) {
+	// the server bounds the call by the time left, see vel.Deadline
+	if deadline, ok := r.Context().Deadline(); ok && r.Header.Get("X-Request-Timeout") == "" {
+		r.Header.Set("X-Request-Timeout", strconv.FormatInt(max(time.Until(deadline), 0).Milliseconds(), 10)+"ms")
+	}
+	next := transport
+	for i := len(c.interceptors) - 1; i >= 0; i-- {
+		interceptor, inner := c.interceptors[i], next
+		next = func(r *http.Request) (*http.Response, error) {
+			return interceptor(r, inner)
+		}
+	}
+	if c.retry != nil {
+		attempt := next
+		next = func(r *http.Request) (*http.Response, error) {
+			return c.retry.do(r, attempt)
+		}
+	}
+	return c.observe(r, next)
+}
+
+// WithHooks returns a copy of the client calling the hooks around every call, e.g. to record metrics or traces.
+func (c *Client) WithHooks(hooks Hooks) *Client {
+	cCopy := c.clone()
+	cCopy.hooks = hooks
+	return cCopy
+}
+
+// Hooks observe the calls of the client, every hook is optional.
+// OnRequest is called before a call is sent, OnResponse once its response is received
+// and OnError once the call fails with an error response or without a response at all.
+type Hooks struct {
+	OnRequest  func(ctx context.Context, info CallInfo)
+	OnResponse func(ctx context.Context, info CallInfo)
+	OnError    func(ctx context.Context, info CallInfo)
+}
+
+// CallInfo describes a call observed by Hooks.
+type CallInfo struct {
+	OperationID string
+	// Duration lasts until the response headers are received including the retries, it's zero in OnRequest
+	Duration time.Duration
+	// Status is zero if no response is received
+	Status int
+	// Code is the code of the error response
+	Code string
+	// Err is the failure of the call without a response
+	Err error
+}
+
+type operationIDKey struct{}
+
+func (c *Client) observe(r *http.Request, next RoundTripFunc) (*http.Response, error) {
+	hooks := c.hooks
+	if hooks.OnRequest == nil && hooks.OnResponse == nil && hooks.OnError == nil {
+		return next(r)
+	}
+
+	ctx := r.Context()
+	var info CallInfo
+	info.OperationID, _ = ctx.Value(operationIDKey{}).(string)
+	if hooks.OnRequest != nil {
+		hooks.OnRequest(ctx, info)
+	}
+	start := time.Now()
+	resp, err := next(r)
+	info.Duration = time.Since(start)
+	if err != nil {
+		info.Err = err
+		if hooks.OnError != nil {
+			hooks.OnError(ctx, info)
+		}
+		return resp, err
+	}
+
+	info.Status = resp.StatusCode
+	if resp.StatusCode >= 400 {
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		if err == nil {
+			if errResp, err := decodeError(bytes.NewReader(body)); err == nil {
+				info.Code = errResp.Code
+			}
+		}
+	}
+	if hooks.OnResponse != nil {
+		hooks.OnResponse(ctx, info)
 	}
+	if resp.StatusCode >= 400 && hooks.OnError != nil {
+		hooks.OnError(ctx, info)
+	}
+	return resp, nil
+}
+
+// WithCache returns a copy of the client caching successful GET responses,
+// a response is stored only if the server allows it with Cache-Control max-age, no-store is always respected.
+func (c *Client) WithCache(cache Cache) *Client {
+	cCopy := c.clone()
+	cCopy.cache = cache
+	return cCopy
+}
+
+// Cache stores the responses as they're sent over the wire, the status, the headers and the body,
+// see NewMemoryCache for an in-memory implementation.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, body []byte, ttl time.Duration)
+}
+
+func (c *Client) cachedSend(r *http.Request) (*http.Response, error) {
+	key := cacheKey(r)
+	if stored, ok := c.cache.Get(key); ok {
+		// an entry that isn't a response is a miss
+		if cached, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(stored)), r); err == nil {
+			// a hit passes the interceptors and the hooks like a call does
+			return c.roundTrip(r, func(r *http.Request) (*http.Response, error) {
+				return cached, nil
+			})
+		}
+	}
+
+	resp, err := c.conditionalSend(r)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	ttl, ok := cacheTTL(resp.Header.Get("Cache-Control"))
+	if !ok {
+		return resp, nil
+	}
+
+	// the body of the response is replaced by the read one
+	stored, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	c.cache.Set(key, stored, ttl)
+	return resp, nil
+}
+
+// conditionalSend revalidates a GET response the client got before by its ETag in If-None-Match,
+// the server answers 304 Not Modified if the response is unchanged and the remembered body is returned then.
+func (c *Client) conditionalSend(r *http.Request) (*http.Response, error) {
+	key := cacheKey(r)
+	validator, ok := c.validators.get(key)
+	// the caller's own validator takes precedence
+	ok = ok && r.Header.Get("If-None-Match") == ""
+	if ok {
+		r.Header.Set("If-None-Match", validator.etag)
+	}
+
+	resp, err := c.send(r)
+	if err != nil {
+		return resp, err
+	}
+	if ok && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		resp.Status, resp.StatusCode = "200 OK", http.StatusOK
+		resp.Body = io.NopCloser(bytes.NewReader(validator.body))
+		return resp, nil
+	}
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" || strings.Contains(resp.Header.Get("Cache-Control"), "no-store") {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	c.validators.set(key, validatorEntry{etag: etag, body: body})
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// validatorCacheSize limits the remembered ETags, a random one is dropped once it's full
+const validatorCacheSize = 1000
+
+// validatorCache remembers the ETags of the GET responses along with their bodies, the copies of a client share it
+type validatorCache struct {
+	mu      sync.Mutex
+	entries map[string]validatorEntry
+}
+
+type validatorEntry struct {
+	etag string
+	body []byte
+}
+
+func newValidatorCache() *validatorCache {
+	return &validatorCache{entries: make(map[string]validatorEntry)}
+}
+
+func (v *validatorCache) get(key string) (validatorEntry, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	entry, ok := v.entries[key]
+	return entry, ok
+}
+
+func (v *validatorCache) set(key string, entry validatorEntry) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.entries[key]; !ok && len(v.entries) >= validatorCacheSize {
+		for k := range v.entries {
+			delete(v.entries, k)
+			break
+		}
+	}
+	v.entries[key] = entry
+}
+
+// cacheKeyPolicy customizes the cache key of an operation as declared in its spec
+type cacheKeyPolicy struct {
+	ignoreQuery []string
+	varyHeaders []string
+}
+
+type cacheKeyPolicyKey struct{}
+
+// cacheKey identifies a cached response like the server does:
+// the url with the sorted query params except the ignored ones and the values of the vary headers.
+func cacheKey(r *http.Request) string {
+	policy, _ := r.Context().Value(cacheKeyPolicyKey{}).(cacheKeyPolicy)
+	q := r.URL.Query()
+	for _, name := range policy.ignoreQuery {
+		q.Del(name)
+	}
+	u := *r.URL
+	u.RawQuery = q.Encode()
+	key := u.String()
+	for _, name := range policy.varyHeaders {
+		key += "\n" + http.CanonicalHeaderKey(name) + ": " + r.Header.Get(name)
+	}
+	return key
+}
+
+func cacheTTL(cacheControl string) (time.Duration, bool) {
+	var ttl time.Duration
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.TrimSpace(directive)
+		switch {
+		case directive == "no-store", directive == "no-cache":
+			return 0, false
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err != nil {
+				return 0, false
+			}
+			ttl = time.Duration(seconds) * time.Second
+		}
+	}
+	return ttl, ttl > 0
+}
+
+// MemoryCache is a Cache keeping entries in memory until they expire.
+type MemoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryCacheEntry
+}
+
+type memoryCacheEntry struct {
+	body      []byte
+	expiresAt time.Time
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: make(map[string]memoryCacheEntry)}
+}
+
+func (m *MemoryCache) Get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(m.entries, key)
+		return nil, false
+	}
+	return entry.body, true
+}
+
+func (m *MemoryCache) Set(key string, body []byte, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = memoryCacheEntry{body: body, expiresAt: time.Now().Add(ttl)}
+}
+
+// WithRetry returns a copy of the client retrying failed calls according to the policy.
+func (c *Client) WithRetry(policy RetryPolicy) *Client {
+	cCopy := c.clone()
+	cCopy.retry = &policy
+	return cCopy
+}
+
+// RetryPolicy defines when and how often a failed call is retried.
+// Network errors are retried, responses are retried when their status is in Statuses
+// or the returned error code is in Codes. A call of a method that isn't idempotent, e.g. POST,
+// is retried only if it carries an Idempotency-Key header or it failed before the connection was made.
+// Retry-After header is respected on 429 and 503 responses up to MaxDelay.
+type RetryPolicy struct {
+	// MaxAttempts includes the first call, 3 by default
+	MaxAttempts int
+	// BaseDelay is doubled on every attempt, 100ms by default
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff, 5s by default
+	MaxDelay time.Duration
+	// Statuses defaults to 429, 502, 503, 504
+	Statuses []int
+	Codes    []string
+}
+
+func (p *RetryPolicy) do(r *http.Request, next RoundTripFunc) (*http.Response, error) {
+	attempts := p.MaxAttempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+	for attempt := 1; ; attempt++ {
+		resp, err := next(r)
+		if attempt >= attempts || !p.shouldRetry(r, resp, err) {
+			return resp, err
+		}
+		if r.Body != nil && r.GetBody == nil {
+			return resp, err
+		}
+
+		delay := p.delay(attempt, resp)
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if r.GetBody != nil {
+			body, err := r.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewind request body: %w", err)
+			}
+			r.Body = body
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-r.Context().Done():
+			timer.Stop()
+			return nil, r.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (p *RetryPolicy) shouldRetry(r *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		// a failed dial never reached the server
+		var opErr *net.OpError
+		return r.Context().Err() == nil && (idempotent(r) || errors.As(err, &opErr) && opErr.Op == "dial")
+	}
+	if !idempotent(r) {
+		return false
+	}
+
+	statuses := p.Statuses
+	if statuses == nil {
+		statuses = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+	}
+	if slices.Contains(statuses, resp.StatusCode) {
+		return true
+	}
+
+	if len(p.Codes) == 0 || resp.StatusCode < 400 {
+		return false
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+	errResp, err := decodeError(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+	return slices.Contains(p.Codes, errResp.Code)
+}
+
+// idempotent reports whether sending the request twice has the effect of sending it once,
+// by its method or by the Idempotency-Key header the server deduplicates the calls by
+func idempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return r.Header.Get("Idempotency-Key") != ""
+}
+
+func (p *RetryPolicy) delay(attempt int, resp *http.Response) time.Duration {
+	maxDelay := p.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = 5 * time.Second
+	}
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+			if seconds, err := strconv.Atoi(retryAfter); err == nil {
+				return min(time.Duration(seconds)*time.Second, maxDelay)
+			}
+			if at, err := http.ParseTime(retryAfter); err == nil {
+				return min(time.Until(at), maxDelay)
+			}
+		}
+	}
+
+	base := p.BaseDelay
+	if base <= 0 {
+		base = 100 * time.Millisecond
+	}
+	backoff := min(base<<(attempt-1), maxDelay)
+	if backoff <= 0 {
+		backoff = maxDelay
+	}
+	// full jitter
+	return rand.N(backoff) + 1
 }
 
 type Error struct {
-	Code    string            `json:"code"`
-	Message string            `json:"message"`
-	Meta    map[string]string `json:"meta"`
+	Code       string            `json:"code"`
+	Message    string            `json:"message"`
+	Meta       map[string]string `json:"meta"`
+	Violations []Violation       `json:"violations,omitempty"`
+}
+
+// Violation is a failed validation rule of a request, Params holds the rule parameters, e.g. min of min_len.
+type Violation struct {
+	Field  string            `json:"field"`
+	Rule   string            `json:"rule"`
+	Params map[string]string `json:"params"`
 }
 
 func (e *Error) Error() string {
 	return fmt.Sprintf("%s, %s", e.Code, e.Message)
 }
 
+// RedirectError is returned for a redirect the http.Client doesn't follow,
+// e.g. its CheckRedirect returns http.ErrUseLastResponse.
+type RedirectError struct {
+	Status   int
+	Location string
+}
+
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("redirected with %d to %s", e.Status, e.Location)
+}
+
+// ValidationFailedError is the Error of the VALIDATION_FAILED code with its meta parsed, see errors.As.
+type ValidationFailedError struct {
+	Err *Error
+}
+
+func (e *ValidationFailedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ValidationFailedError) Unwrap() error {
+	return e.Err
+}
+
+// typedError wraps the Error of a declared code into its typed error, a meta value failing to parse is left zero.
+func typedError(e *Error) error {
+	switch e.Code {
+	case "VALIDATION_FAILED":
+		return &ValidationFailedError{Err: e}
+	}
+	return e
+}
+
 func HandleErr(resp *http.Response) error {
-	if resp.StatusCode >= 500 {
-		var errResp Error
-		err := json.NewDecoder(resp.Body).Decode(&errResp)
-		if err != nil {
-			return &Error{
-				Code:    "UNKNOWN",
-				Message: "failed to decode error response: " + err.Error(),
-			}
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 && resp.StatusCode != http.StatusNotModified {
+		return &RedirectError{Status: resp.StatusCode, Location: resp.Header.Get("Location")}
+	}
+	if resp.StatusCode < 400 {
+		return nil
+	}
+
+	errResp, err := decodeError(resp.Body)
+	if err != nil {
+		return &Error{
+			Code:    "UNKNOWN",
+			Message: "failed to decode error response: " + err.Error(),
 		}
-		return &errResp
 	}
+	return typedError(errResp)
+}
 
-	if resp.StatusCode >= 400 {
-		var errResp Error
-		err := json.NewDecoder(resp.Body).Decode(&errResp)
-		if err != nil {
-			return &Error{
-				Code:    "UNKNOWN",
-				Message: "failed to decode error response: " + err.Error(),
-			}
+func decodeError(r io.Reader) (*Error, error) {
+	var errResp Error
+	err := json.NewDecoder(r).Decode(&errResp)
+	return &errResp, err
+}
+
+// CallOption customizes a single call, it takes precedence over the client configuration.
+type CallOption func(o *callOptions)
+
+type callOptions struct {
+	headers http.Header
+	query   url.Values
+	timeout time.Duration
+}
+
+// WithHeader sets a request header for a single call.
+func WithHeader(key, value string) CallOption {
+	return func(o *callOptions) {
+		o.headers.Set(key, value)
+	}
+}
+
+// WithQuery overrides a query parameter for a single call.
+func WithQuery(key, value string) CallOption {
+	return func(o *callOptions) {
+		o.query.Set(key, value)
+	}
+}
+
+// WithTimeout limits a single call including reading its response.
+func WithTimeout(timeout time.Duration) CallOption {
+	return func(o *callOptions) {
+		o.timeout = timeout
+	}
+}
+
+func applyCallOptions(ctx context.Context, r *http.Request, opts []CallOption) (context.Context, context.CancelFunc) {
+	if len(opts) == 0 {
+		return ctx, func() {}
+	}
+
+	o := callOptions{
+		headers: make(http.Header),
+		query:   make(url.Values),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	for k, v := range o.headers {
+		r.Header[k] = v
+	}
+	if len(o.query) > 0 {
+		q := r.URL.Query()
+		for k, v := range o.query {
+			q[k] = v
 		}
-		return &errResp
+		r.URL.RawQuery = q.Encode()
+	}
+	if o.timeout > 0 {
+		return context.WithTimeout(ctx, o.timeout)
 	}
+	return ctx, func() {}
+}
 
-	return nil
+// queryValue encodes a query parameter the same way the server decodes it,
+// types implementing encoding.TextMarshaler (e.g. time.Time) use their text form.
+func queryValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case encoding.TextMarshaler:
+		text, err := v.MarshalText()
+		if err == nil {
+			return string(text)
+		}
+	}
+	return fmt.Sprint(v)
 }
 
 type TestTypeNoJsonTags struct {
 	Value string
 }
 
-func (c *Client) Test1(ctx context.Context, req TestTypeNoJsonTags) (TestTypeNoJsonTags, error) {
+func (c *Client) Test1(ctx context.Context, req TestTypeNoJsonTags, opts ...CallOption) (TestTypeNoJsonTags, error) {
 	var res TestTypeNoJsonTags
 
 	bodyBytes, err := json.Marshal(req)
@@ -98,10 +709,13 @@ func (c *Client) Test1(ctx context.Context, req TestTypeNoJsonTags) (TestTypeNoJ
 	if err != nil {
 		return res, fmt.Errorf("failed to create request: %w", err)
 	}
+	r.Header = c.headers.Clone()
+	ctx, cancel := applyCallOptions(ctx, r, opts)
+	defer cancel()
+	ctx = context.WithValue(ctx, operationIDKey{}, "test1")
 	r = r.WithContext(ctx)
-	r.Header = c.headers
 
-	resp, err := c.client.Do(r)
+	resp, err := c.do(r)
 	if err != nil {
 		return res, fmt.Errorf("failed to call test1: %w", err)
 	}
@@ -120,52 +734,62 @@ func (c *Client) Test1(ctx context.Context, req TestTypeNoJsonTags) (TestTypeNoJ
 	return res, nil
 }
 
-type TestTypeNestedTypes struct {
-	Data             TestStruct          `json:"data"`
-	Chunk            []uint8             `json:"chunk"`
-	NextLevelSlice   []HighElem          `json:"slice"`
-	Map              map[int]HighMapElem `json:"map"`
-	NextLevelNestedP *HighPointer        `json:"nextP"`
+type HighElem struct {
+	Int int `json:"int"`
 }
 
-type TestStruct struct {
-	Row              int                   `json:"row"`
-	Line             string                `json:"line"`
-	NextLevelNested  TestNextLevelStruct   `json:"next"`
-	NextLevelSlice   []TestNextLevelElem   `json:"slice"`
-	Map              map[int]MapValue      `json:"map"`
-	NextLevelNestedP *TestNextLevelStructP `json:"nextP"`
+type HighMapElem struct {
+	Value string
 }
 
-type TestNextLevelStruct struct {
+type HighPointer struct {
 	Extra string `json:"extra"`
 }
 
-type TestNextLevelElem struct {
-	Int int `json:"int"`
+type MapValue struct {
+	Value string
 }
 
-type MapValue struct {
+type MapValueP struct {
 	Value string
 }
 
-type TestNextLevelStructP struct {
-	Extra string `json:"extra"`
+type TestNextLevelElem struct {
+	Int int `json:"int"`
 }
 
-type HighElem struct {
+type TestNextLevelElemP struct {
 	Int int `json:"int"`
 }
 
-type HighMapElem struct {
-	Value string
+type TestNextLevelStruct struct {
+	Extra string `json:"extra"`
 }
 
-type HighPointer struct {
+type TestNextLevelStructP struct {
 	Extra string `json:"extra"`
 }
 
-func (c *Client) Test2(ctx context.Context, req TestTypeNestedTypes) (TestTypeNestedTypes, error) {
+type TestStruct struct {
+	Row              int                   `json:"row"`
+	Line             string                `json:"line"`
+	NextLevelNested  TestNextLevelStruct   `json:"next"`
+	NextLevelSlice   []TestNextLevelElem   `json:"slice"`
+	Map              map[int]MapValue      `json:"map"`
+	NextLevelNestedP *TestNextLevelStructP `json:"nextP"`
+	NextLevelSliceP  []*TestNextLevelElemP `json:"sliceP"`
+	MapP             map[int]*MapValueP    `json:"mapP"`
+}
+
+type TestTypeNestedTypes struct {
+	Data             TestStruct          `json:"data"`
+	Chunk            []uint8             `json:"chunk"`
+	NextLevelSlice   []HighElem          `json:"slice"`
+	Map              map[int]HighMapElem `json:"map"`
+	NextLevelNestedP *HighPointer        `json:"nextP"`
+}
+
+func (c *Client) Test2(ctx context.Context, req TestTypeNestedTypes, opts ...CallOption) (TestTypeNestedTypes, error) {
 	var res TestTypeNestedTypes
 
 	bodyBytes, err := json.Marshal(req)
@@ -178,10 +802,13 @@ func (c *Client) Test2(ctx context.Context, req TestTypeNestedTypes) (TestTypeNe
 	if err != nil {
 		return res, fmt.Errorf("failed to create request: %w", err)
 	}
+	r.Header = c.headers.Clone()
+	ctx, cancel := applyCallOptions(ctx, r, opts)
+	defer cancel()
+	ctx = context.WithValue(ctx, operationIDKey{}, "test2")
 	r = r.WithContext(ctx)
-	r.Header = c.headers
 
-	resp, err := c.client.Do(r)
+	resp, err := c.do(r)
 	if err != nil {
 		return res, fmt.Errorf("failed to call test2: %w", err)
 	}
@@ -200,17 +827,20 @@ func (c *Client) Test2(ctx context.Context, req TestTypeNestedTypes) (TestTypeNe
 	return res, nil
 }
 
-func (c *Client) TestEmpty(ctx context.Context) error {
+func (c *Client) TestEmpty(ctx context.Context, opts ...CallOption) error {
 	body := bytes.NewBuffer(nil)
 
 	r, err := http.NewRequest("POST", c.baseUrl+"/testEmpty", body)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
+	r.Header = c.headers.Clone()
+	ctx, cancel := applyCallOptions(ctx, r, opts)
+	defer cancel()
+	ctx = context.WithValue(ctx, operationIDKey{}, "testEmpty")
 	r = r.WithContext(ctx)
-	r.Header = c.headers
 
-	resp, err := c.client.Do(r)
+	resp, err := c.do(r)
 	if err != nil {
 		return fmt.Errorf("failed to call testEmpty: %w", err)
 	}
@@ -227,27 +857,36 @@ func (c *Client) TestEmpty(ctx context.Context) error {
 type GetQuery struct {
 	Value string
 	Field int
+	Since time.Time
 }
 
 type GetResp struct {
 	Getting int
 }
 
-func (c *Client) TestGet(ctx context.Context, req GetQuery) (GetResp, error) {
+func (c *Client) TestGet(ctx context.Context, req GetQuery, opts ...CallOption) (GetResp, error) {
 	var res GetResp
 
 	q := make(url.Values)
-	q.Set("value", req.Value)
-	q.Set("field", req.Field)
+	q.Set("value", queryValue(req.Value))
+	q.Set("field", queryValue(req.Field))
+	q.Set("since", queryValue(req.Since))
 
 	r, err := http.NewRequest("GET", c.baseUrl+"/testGet?"+q.Encode(), nil)
 	if err != nil {
 		return res, fmt.Errorf("failed to create request: %w", err)
 	}
+	r.Header = c.headers.Clone()
+	ctx, cancel := applyCallOptions(ctx, r, opts)
+	defer cancel()
+	ctx = context.WithValue(ctx, operationIDKey{}, "testGet")
+	ctx = context.WithValue(ctx, cacheKeyPolicyKey{}, cacheKeyPolicy{
+		ignoreQuery: []string{"trace"},
+		varyHeaders: []string{"X-Tenant"},
+	})
 	r = r.WithContext(ctx)
-	r.Header = c.headers
 
-	resp, err := c.client.Do(r)
+	resp, err := c.do(r)
 	if err != nil {
 		return res, fmt.Errorf("failed to call testGet: %w", err)
 	}
@@ -276,7 +915,7 @@ type TimeTestResponse struct {
 	ID          string    `json:"id"`
 }
 
-func (c *Client) TestTime(ctx context.Context, req TimeTestRequest) (TimeTestResponse, error) {
+func (c *Client) TestTime(ctx context.Context, req TimeTestRequest, opts ...CallOption) (TimeTestResponse, error) {
 	var res TimeTestResponse
 
 	bodyBytes, err := json.Marshal(req)
@@ -289,10 +928,13 @@ func (c *Client) TestTime(ctx context.Context, req TimeTestRequest) (TimeTestRes
 	if err != nil {
 		return res, fmt.Errorf("failed to create request: %w", err)
 	}
+	r.Header = c.headers.Clone()
+	ctx, cancel := applyCallOptions(ctx, r, opts)
+	defer cancel()
+	ctx = context.WithValue(ctx, operationIDKey{}, "testTime")
 	r = r.WithContext(ctx)
-	r.Header = c.headers
 
-	resp, err := c.client.Do(r)
+	resp, err := c.do(r)
 	if err != nil {
 		return res, fmt.Errorf("failed to call testTime: %w", err)
 	}
@@ -310,3 +952,62 @@ func (c *Client) TestTime(ctx context.Context, req TimeTestRequest) (TimeTestRes
 
 	return res, nil
 }
+
+// ClientAPI is the API surface of Client, depend on it to replace the client in tests.
+type ClientAPI interface {
+	Test1(ctx context.Context, req TestTypeNoJsonTags, opts ...CallOption) (TestTypeNoJsonTags, error)
+	Test2(ctx context.Context, req TestTypeNestedTypes, opts ...CallOption) (TestTypeNestedTypes, error)
+	TestEmpty(ctx context.Context, opts ...CallOption) error
+	TestGet(ctx context.Context, req GetQuery, opts ...CallOption) (GetResp, error)
+	TestTime(ctx context.Context, req TimeTestRequest, opts ...CallOption) (TimeTestResponse, error)
+}
+
+var (
+	_ ClientAPI = (*Client)(nil)
+	_ ClientAPI = (*MockClient)(nil)
+)
+
+// MockClient implements ClientAPI with the configured functions,
+// a method without a function returns zero values.
+type MockClient struct {
+	Test1Func     func(ctx context.Context, req TestTypeNoJsonTags, opts ...CallOption) (TestTypeNoJsonTags, error)
+	Test2Func     func(ctx context.Context, req TestTypeNestedTypes, opts ...CallOption) (TestTypeNestedTypes, error)
+	TestEmptyFunc func(ctx context.Context, opts ...CallOption) error
+	TestGetFunc   func(ctx context.Context, req GetQuery, opts ...CallOption) (GetResp, error)
+	TestTimeFunc  func(ctx context.Context, req TimeTestRequest, opts ...CallOption) (TimeTestResponse, error)
+}
+
+func (m *MockClient) Test1(ctx context.Context, req TestTypeNoJsonTags, opts ...CallOption) (TestTypeNoJsonTags, error) {
+	if m.Test1Func == nil {
+		return TestTypeNoJsonTags{}, nil
+	}
+	return m.Test1Func(ctx, req, opts...)
+}
+
+func (m *MockClient) Test2(ctx context.Context, req TestTypeNestedTypes, opts ...CallOption) (TestTypeNestedTypes, error) {
+	if m.Test2Func == nil {
+		return TestTypeNestedTypes{}, nil
+	}
+	return m.Test2Func(ctx, req, opts...)
+}
+
+func (m *MockClient) TestEmpty(ctx context.Context, opts ...CallOption) error {
+	if m.TestEmptyFunc == nil {
+		return nil
+	}
+	return m.TestEmptyFunc(ctx, opts...)
+}
+
+func (m *MockClient) TestGet(ctx context.Context, req GetQuery, opts ...CallOption) (GetResp, error) {
+	if m.TestGetFunc == nil {
+		return GetResp{}, nil
+	}
+	return m.TestGetFunc(ctx, req, opts...)
+}
+
+func (m *MockClient) TestTime(ctx context.Context, req TimeTestRequest, opts ...CallOption) (TimeTestResponse, error) {
+	if m.TestTimeFunc == nil {
+		return TimeTestResponse{}, nil
+	}
+	return m.TestTimeFunc(ctx, req, opts...)
+}
@@ -0,0 +1,699 @@
+import { z } from "zod";
+
+type FetchFn = typeof fetch;
+
+export type ClientOptions = {
+  fetch?: FetchFn;
+  // headers sent with every call
+  headers?: Record<string, string>;
+  cache?: ResponseCache;
+  retry?: RetryPolicy;
+  hooks?: Hooks;
+  // sendSpecHash sends SPEC_HASH in the X-Spec-Hash header, a cross-origin call then needs a CORS preflight allowing it
+  sendSpecHash?: boolean;
+  // onOutdated is called when the server runs another version of the API, e.g. to ask the user to reload
+  onOutdated?: () => void;
+};
+
+// RetryPolicy defines when and how often a failed call is retried.
+// Network errors are always retried, responses are retried when their status is in statuses
+// or the returned error code is in codes.
+// Retry-After header is respected on 429 and 503 responses.
+export type RetryPolicy = {
+  // maxAttempts includes the first call, 3 by default
+  maxAttempts?: number;
+  // baseDelayMs is doubled on every attempt, 100 by default
+  baseDelayMs?: number;
+  // maxDelayMs caps the backoff, 5000 by default
+  maxDelayMs?: number;
+  // statuses defaults to 429, 502, 503, 504
+  statuses?: number[];
+  codes?: string[];
+};
+
+// Hooks observe the calls of the client, e.g. to record metrics or traces, every hook is optional.
+// onRequest is called before a call is sent, onResponse once its response is received
+// and onError once the call fails with an error response or without a response at all.
+export type Hooks = {
+  onRequest?: (info: CallInfo) => void;
+  onResponse?: (info: CallInfo) => void;
+  onError?: (info: CallInfo) => void;
+};
+
+// CallInfo describes a call observed by Hooks
+export type CallInfo = {
+  operationId: string;
+  // durationMs lasts until the response headers are received including the retries, it's 0 in onRequest
+  durationMs: number;
+  // status is 0 if no response is received
+  status: number;
+  // code is the code of the error response
+  code?: string;
+  // error is the failure of the call without a response
+  error?: unknown;
+};
+
+export type CallOptions = {
+  // baseUrl overrides the client base url for a single call
+  baseUrl?: string;
+  headers?: Record<string, string>;
+  signal?: AbortSignal;
+  timeoutMs?: number;
+};
+
+// QueryValue is a query parameter, the items of an array are repeated and a missing value is left out
+type QueryValue =
+  | string
+  | number
+  | boolean
+  | null
+  | undefined
+  | Array<string | number | boolean>;
+
+type RequestOptions = CallOptions & {
+  operationId?: string;
+  query?: Record<string, QueryValue>;
+  body?: BodyInit;
+  cacheKey?: CacheKeyPolicy;
+  // raw reads the response as a Blob
+  raw?: boolean;
+};
+
+// CacheKeyPolicy customizes the cache key of an operation as declared in its spec
+type CacheKeyPolicy = {
+  ignoreQuery?: string[];
+  varyHeaders?: string[];
+};
+
+export type Failure<E = ApiErrorPayload> = {
+  error: E;
+};
+
+export type Success<T = void> = {
+  data: T;
+};
+
+export type Result<T = void, E = ApiErrorPayload> = Failure<E> | Success<T>;
+
+export type ApiErrorPayload = {
+  code: string;
+  message: string;
+  meta: Record<string, string>;
+  violations?: Violation[];
+};
+
+// ViolationRule lists the known rules, handlers may report their own ones
+export type ViolationRule =
+  | "required"
+  | "min_len"
+  | "max_len"
+  | "min"
+  | "max"
+  | "enum"
+  | "format"
+  | (string & {});
+
+export type Violation = {
+  field: string;
+  rule: ViolationRule;
+  params?: Record<string, string>;
+};
+
+export interface ResponseCache {
+  get(key: string): string | undefined;
+  set(key: string, value: string, ttlMs: number): void;
+}
+
+export class MemoryCache implements ResponseCache {
+  private entries = new Map<string, { value: string; expiresAt: number }>();
+
+  get(key: string): string | undefined {
+    const entry = this.entries.get(key);
+    if (!entry) {
+      return undefined;
+    }
+    if (entry.expiresAt < Date.now()) {
+      this.entries.delete(key);
+      return undefined;
+    }
+    return entry.value;
+  }
+
+  set(key: string, value: string, ttlMs: number): void {
+    this.entries.set(key, { value, expiresAt: Date.now() + ttlMs });
+  }
+}
+
+// ValidatorCache remembers the ETags of the GET responses along with their bodies to revalidate them by If-None-Match,
+// the oldest entry is dropped once it's full
+class ValidatorCache {
+  private entries = new Map<string, { etag: string; body: string }>();
+
+  constructor(private limit: number) {}
+
+  get(key: string): { etag: string; body: string } | undefined {
+    return this.entries.get(key);
+  }
+
+  set(key: string, etag: string, body: string): void {
+    this.entries.delete(key);
+    this.entries.set(key, { etag, body });
+    if (this.entries.size > this.limit) {
+      const oldest = this.entries.keys().next().value;
+      if (oldest !== undefined) {
+        this.entries.delete(oldest);
+      }
+    }
+  }
+}
+
+// cacheKey identifies a cached response like the server does:
+// the url with the sorted query params except the ignored ones and the values of the vary headers
+function cacheKey(
+  url: string,
+  headers: Record<string, string>,
+  policy: CacheKeyPolicy = {},
+): string {
+  const u = new URL(url);
+  for (const name of policy.ignoreQuery ?? []) {
+    u.searchParams.delete(name);
+  }
+  u.searchParams.sort();
+  let key = u.toString();
+  const lowerHeaders: Record<string, string> = {};
+  for (const [name, value] of Object.entries(headers)) {
+    lowerHeaders[name.toLowerCase()] = value;
+  }
+  for (const name of policy.varyHeaders ?? []) {
+    key += "\n" + name + ": " + (lowerHeaders[name.toLowerCase()] ?? "");
+  }
+  return key;
+}
+
+// cacheTTL returns how long a response may be cached according to its Cache-Control header, 0 means never
+function cacheTTL(cacheControl: string | null): number {
+  if (!cacheControl) {
+    return 0;
+  }
+  let ttl = 0;
+  for (const part of cacheControl.split(",")) {
+    const directive = part.trim();
+    if (directive === "no-store" || directive === "no-cache") {
+      return 0;
+    }
+    if (directive.startsWith("max-age=")) {
+      ttl = Number(directive.slice("max-age=".length)) * 1000;
+    }
+  }
+  return Number.isFinite(ttl) ? ttl : 0;
+}
+export const TestTypeNoJsonTagsSchema = z.object({
+  Value: z.string(),
+});
+
+export type TestTypeNoJsonTags = z.infer<typeof TestTypeNoJsonTagsSchema>;
+
+export const HighElemSchema = z.object({
+  int: z.number(),
+});
+
+export type HighElem = z.infer<typeof HighElemSchema>;
+
+export const HighMapElemSchema = z.object({
+  Value: z.string(),
+});
+
+export type HighMapElem = z.infer<typeof HighMapElemSchema>;
+
+export const HighPointerSchema = z.object({
+  extra: z.string(),
+});
+
+export type HighPointer = z.infer<typeof HighPointerSchema>;
+
+export const MapValueSchema = z.object({
+  Value: z.string(),
+});
+
+export type MapValue = z.infer<typeof MapValueSchema>;
+
+export const MapValuePSchema = z.object({
+  Value: z.string(),
+});
+
+export type MapValueP = z.infer<typeof MapValuePSchema>;
+
+export const TestNextLevelElemSchema = z.object({
+  int: z.number(),
+});
+
+export type TestNextLevelElem = z.infer<typeof TestNextLevelElemSchema>;
+
+export const TestNextLevelElemPSchema = z.object({
+  int: z.number(),
+});
+
+export type TestNextLevelElemP = z.infer<typeof TestNextLevelElemPSchema>;
+
+export const TestNextLevelStructSchema = z.object({
+  extra: z.string(),
+});
+
+export type TestNextLevelStruct = z.infer<typeof TestNextLevelStructSchema>;
+
+export const TestNextLevelStructPSchema = z.object({
+  extra: z.string(),
+});
+
+export type TestNextLevelStructP = z.infer<typeof TestNextLevelStructPSchema>;
+
+export const TestStructSchema = z.object({
+  row: z.number(),
+  line: z.string(),
+  next: z.lazy(() => TestNextLevelStructSchema),
+  slice: z.array(z.lazy(() => TestNextLevelElemSchema)).nullable(),
+  map: z.record(z.string(), z.lazy(() => MapValueSchema)).nullable(),
+  nextP: z.lazy(() => TestNextLevelStructPSchema).nullish(),
+  sliceP: z.array(z.lazy(() => TestNextLevelElemPSchema).nullish()).nullable(),
+  mapP: z.record(
+    z.string(),
+    z.lazy(() => MapValuePSchema).nullish(),
+  ).nullable(),
+});
+
+export type TestStruct = z.infer<typeof TestStructSchema>;
+
+export const TestTypeNestedTypesSchema = z.object({
+  data: z.lazy(() => TestStructSchema),
+  chunk: z.string(),
+  slice: z.array(z.lazy(() => HighElemSchema)).nullable(),
+  map: z.record(z.string(), z.lazy(() => HighMapElemSchema)).nullable(),
+  nextP: z.lazy(() => HighPointerSchema).nullish(),
+});
+
+export type TestTypeNestedTypes = z.infer<typeof TestTypeNestedTypesSchema>;
+
+export const GetQuerySchema = z.object({
+  Value: z.string(),
+  Field: z.number(),
+  Since: z.string(),
+});
+
+export type GetQuery = z.infer<typeof GetQuerySchema>;
+
+export const GetRespSchema = z.object({
+  Getting: z.number(),
+});
+
+export type GetResp = z.infer<typeof GetRespSchema>;
+
+export type TestTimeError = {
+  code: "VALIDATION_FAILED";
+  message: string;
+  meta: {};
+  violations: Violation[];
+};
+
+export const TimeTestRequestSchema = z.object({
+  createdAt: z.string(),
+  name: z.string(),
+});
+
+export type TimeTestRequest = z.infer<typeof TimeTestRequestSchema>;
+
+export const TimeTestResponseSchema = z.object({
+  processedAt: z.string(),
+  id: z.string(),
+});
+
+export type TimeTestResponse = z.infer<typeof TimeTestResponseSchema>;
+
+// SPEC_HASH identifies the API the client is generated from, it's sent in the X-Spec-Hash header if sendSpecHash is set
+export const SPEC_HASH = "825e16a3eb458e58";
+
+class Client {
+  private baseUrl: string;
+  private fetchFn: FetchFn;
+  private headers: Record<string, string>;
+  private cache?: ResponseCache;
+  private retry?: RetryPolicy;
+  private hooks?: Hooks;
+  private validators = new ValidatorCache(1000);
+  private onOutdated?: () => void;
+
+  constructor(baseUrl: string, opts?: ClientOptions);
+  /** @deprecated pass the fetch and the cache in ClientOptions */
+  constructor(baseUrl: string, fetchFn?: FetchFn, cache?: ResponseCache);
+  constructor(
+    baseUrl: string,
+    opts?: ClientOptions | FetchFn,
+    cache?: ResponseCache,
+  ) {
+    if (typeof opts !== "object") {
+      opts = { fetch: opts, cache };
+    }
+    this.baseUrl = withTrailingSlash(baseUrl);
+    this.fetchFn = opts.fetch ?? window.fetch.bind(window);
+    this.headers = opts.sendSpecHash
+      ? { "X-Spec-Hash": SPEC_HASH, ...opts.headers }
+      : (opts.headers ?? {});
+    this.cache = opts.cache;
+    this.retry = opts.retry;
+    this.hooks = opts.hooks;
+    this.onOutdated = opts.onOutdated;
+  }
+
+  private buildUrl(
+    path: string,
+    query?: Record<string, QueryValue>,
+    baseUrl?: string,
+  ): string {
+    if (path.startsWith("/")) {
+      path = path.slice(1);
+    }
+    const url = new URL(
+      path,
+      baseUrl ? withTrailingSlash(baseUrl) : this.baseUrl,
+    );
+    if (query) {
+      for (const [key, val] of Object.entries(query)) {
+        if (val === undefined || val === null) {
+          continue;
+        }
+        for (const item of Array.isArray(val) ? val : [val]) {
+          url.searchParams.append(key, String(item));
+        }
+      }
+    }
+    return url.toString();
+  }
+
+  private async request<T, E = ApiErrorPayload>(
+    method: string,
+    path: string,
+    opts: RequestOptions = {},
+    schema?: z.ZodType<T>,
+  ): Promise<Result<T, E>> {
+    const url = this.buildUrl(path, opts.query, opts.baseUrl);
+    const headers: Record<string, string> = {
+      "Content-Type": "application/json",
+      ...this.headers,
+      ...opts.headers,
+    };
+    const key = cacheKey(url, headers, opts.cacheKey);
+    const cached = method === "GET" ? this.cache?.get(key) : undefined;
+    if (cached !== undefined) {
+      return { data: parseResponse(cached ? JSON.parse(cached) : {}, schema) };
+    }
+    // the response got before is revalidated by its ETag, the caller's own validator takes precedence
+    const validator =
+      method === "GET" && !("If-None-Match" in headers)
+        ? this.validators.get(key)
+        : undefined;
+    if (validator) {
+      headers["If-None-Match"] = validator.etag;
+    }
+
+    let signal = opts.signal;
+    if (opts.timeoutMs) {
+      const timeout = AbortSignal.timeout(opts.timeoutMs);
+      signal = signal ? AbortSignal.any([signal, timeout]) : timeout;
+    }
+
+    const res = await this.call(opts.operationId ?? "", url, {
+      method,
+      credentials: "include",
+      body: opts.body,
+      signal,
+      headers,
+    });
+    if (res.headers.get("X-Spec-Outdated") === "true") {
+      this.onOutdated?.();
+    }
+
+    // 304 Not Modified answers the revalidation of an unchanged response
+    const revalidated = res.status === 304 ? validator?.body : undefined;
+    if (!res.ok && revalidated === undefined) {
+      if (res.status >= 500) {
+        const errText = await res.text();
+        throw Error("http error: " + errText);
+      }
+      const jsonErr = await res.json();
+      return { error: jsonErr as E };
+    }
+    if (opts.raw) {
+      return { data: (await res.blob()) as T };
+    }
+
+    const response = revalidated ?? (await res.text());
+    const etag = res.headers.get("ETag");
+    if (
+      method === "GET" &&
+      revalidated === undefined &&
+      etag &&
+      !res.headers.get("Cache-Control")?.includes("no-store")
+    ) {
+      this.validators.set(key, etag, response);
+    }
+    if (method === "GET" && this.cache) {
+      const ttl = cacheTTL(res.headers.get("Cache-Control"));
+      if (ttl > 0) {
+        this.cache.set(key, response, ttl);
+      }
+    }
+    if (response) {
+      const resp = JSON.parse(response);
+      return { data: parseResponse(resp, schema) };
+    }
+    return { data: {} as T };
+  }
+
+  // call sends the request calling the hooks around it
+  private async call(
+    operationId: string,
+    url: string,
+    init: RequestInit,
+  ): Promise<Response> {
+    // fetch sends a streamed body only in the half duplex mode
+    if (init.body instanceof ReadableStream) {
+      init = { ...init, duplex: "half" } as RequestInit;
+    }
+    const hooks = this.hooks;
+    if (!hooks) {
+      return await this.send(url, init);
+    }
+    hooks.onRequest?.({ operationId, durationMs: 0, status: 0 });
+    const start = Date.now();
+    let res: Response;
+    try {
+      res = await this.send(url, init);
+    } catch (error) {
+      hooks.onError?.({
+        operationId,
+        durationMs: Date.now() - start,
+        status: 0,
+        error,
+      });
+      throw error;
+    }
+    const info: CallInfo = {
+      operationId,
+      durationMs: Date.now() - start,
+      status: res.status,
+    };
+    if (res.status >= 400) {
+      info.code = await errorCode(res);
+    }
+    hooks.onResponse?.(info);
+    if (res.status >= 400) {
+      hooks.onError?.(info);
+    }
+    return res;
+  }
+
+  // send calls fetch, retrying the failed calls according to the retry policy
+  private async send(url: string, init: RequestInit): Promise<Response> {
+    const policy = this.retry;
+    // a streamed body can't be sent twice
+    if (!policy || init.body instanceof ReadableStream) {
+      return await this.fetchFn(url, init);
+    }
+    const attempts =
+      policy.maxAttempts && policy.maxAttempts > 0 ? policy.maxAttempts : 3;
+    for (let attempt = 1; ; attempt++) {
+      let res: Response;
+      try {
+        res = await this.fetchFn(url, init);
+      } catch (err) {
+        if (attempt >= attempts || init.signal?.aborted) {
+          throw err;
+        }
+        await sleep(retryDelay(policy, attempt), init.signal);
+        continue;
+      }
+      if (attempt >= attempts || !(await shouldRetry(policy, res))) {
+        return res;
+      }
+      await res.body?.cancel();
+      await sleep(retryDelay(policy, attempt, res), init.signal);
+    }
+  }
+
+  private async post<T, E = ApiErrorPayload>(
+    path: string,
+    body?: unknown,
+    opts?: RequestOptions,
+    schema?: z.ZodType<T>,
+  ): Promise<Result<T, E>> {
+    return await this.request(
+      "POST",
+      path,
+      { ...opts, body: JSON.stringify(body) },
+      schema,
+    );
+  }
+
+  private async get<T, E = ApiErrorPayload>(
+    path: string,
+    opts?: RequestOptions,
+    schema?: z.ZodType<T>,
+  ): Promise<Result<T, E>> {
+    return await this.request("GET", path, opts, schema);
+  }
+  async Test1(
+    req: TestTypeNoJsonTags,
+    opts?: CallOptions,
+  ): Promise<Result<TestTypeNoJsonTags>> {
+    return await this.post(
+      "test1",
+      req,
+      { ...opts, operationId: "test1" },
+      TestTypeNoJsonTagsSchema,
+    );
+  }
+
+  async Test2(
+    req: TestTypeNestedTypes,
+    opts?: CallOptions,
+  ): Promise<Result<TestTypeNestedTypes>> {
+    return await this.post(
+      "test2",
+      req,
+      { ...opts, operationId: "test2" },
+      TestTypeNestedTypesSchema,
+    );
+  }
+
+  async TestEmpty(opts?: CallOptions): Promise<Result<void>> {
+    return await this.post("testEmpty", undefined, {
+      ...opts,
+      operationId: "testEmpty",
+    });
+  }
+
+  async TestGet(req: GetQuery, opts?: CallOptions): Promise<Result<GetResp>> {
+    const query: Record<string, QueryValue> = {};
+    query["value"] = req.Value;
+    query["field"] = req.Field;
+    query["since"] = req.Since;
+    const cacheKey: CacheKeyPolicy = {
+      ignoreQuery: ["trace"],
+      varyHeaders: ["X-Tenant"],
+    };
+    return await this.get(
+      "testGet",
+      { ...opts, operationId: "testGet", query, cacheKey },
+      GetRespSchema,
+    );
+  }
+
+  async TestTime(
+    req: TimeTestRequest,
+    opts?: CallOptions,
+  ): Promise<Result<TimeTestResponse, TestTimeError>> {
+    return await this.post(
+      "testTime",
+      req,
+      { ...opts, operationId: "testTime" },
+      TimeTestResponseSchema,
+    );
+  }
+}
+
+function withTrailingSlash(url: string): string {
+  return url.endsWith("/") ? url : url + "/";
+}
+
+async function shouldRetry(
+  policy: RetryPolicy,
+  res: Response,
+): Promise<boolean> {
+  if ((policy.statuses ?? [429, 502, 503, 504]).includes(res.status)) {
+    return true;
+  }
+  if (!policy.codes?.length || res.status < 400) {
+    return false;
+  }
+  const code = await errorCode(res);
+  return code !== undefined && policy.codes.includes(code);
+}
+
+// errorCode reads the code of an error response, the body stays unread for the caller
+async function errorCode(res: Response): Promise<string | undefined> {
+  const payload = await res.clone().json().catch(() => undefined);
+  const code = payload?.["code"];
+  return typeof code === "string" ? code : undefined;
+}
+
+function retryDelay(
+  policy: RetryPolicy,
+  attempt: number,
+  res?: Response,
+): number {
+  const retryAfter =
+    res?.status === 429 || res?.status === 503
+      ? res.headers.get("Retry-After")
+      : null;
+  if (retryAfter) {
+    const seconds = Number(retryAfter);
+    if (Number.isInteger(seconds)) {
+      return seconds * 1000;
+    }
+    const at = Date.parse(retryAfter);
+    if (!Number.isNaN(at)) {
+      return Math.max(at - Date.now(), 0);
+    }
+  }
+
+  const maxDelay =
+    policy.maxDelayMs && policy.maxDelayMs > 0 ? policy.maxDelayMs : 5000;
+  const base =
+    policy.baseDelayMs && policy.baseDelayMs > 0 ? policy.baseDelayMs : 100;
+  // full jitter
+  return Math.random() * Math.min(base * 2 ** (attempt - 1), maxDelay);
+}
+
+// sleep waits for the given time, it rejects with the abort reason once the signal is aborted
+function sleep(ms: number, signal?: AbortSignal | null): Promise<void> {
+  return new Promise((resolve, reject) => {
+    if (signal?.aborted) {
+      reject(signal.reason);
+      return;
+    }
+    const onAbort = () => {
+      clearTimeout(timer);
+      reject(signal?.reason);
+    };
+    const timer = setTimeout(() => {
+      signal?.removeEventListener("abort", onAbort);
+      resolve();
+    }, ms);
+    signal?.addEventListener("abort", onAbort, { once: true });
+  });
+}
+
+// parseResponse validates a response against the schema of the server type,
+// it throws a ZodError once the server and the client drift apart
+function parseResponse<T>(data: unknown, schema?: z.ZodType<T>): T {
+  return schema ? schema.parse(data) : (data as T);
+}
@@ -0,0 +1,76 @@
+package gen
+
+import (
+	"reflect"
+	"sync"
+)
+
+// typeCache keeps the descriptions of the reflected types shared by the handlers and the generation targets,
+// a type is reflected once per process, so generating several clients and specs of a router
+// scales with the unique types rather than the targets.
+// The cached descriptions are shared, they must not be modified.
+var typeCache = &typeModel{}
+
+type typeModel struct {
+	// structs maps structKey to structEntry
+	structs sync.Map
+	// primitives maps reflect.Type to primitiveEntry
+	primitives sync.Map
+}
+
+// structKey is a struct and the name of an anonymous one, the same anonymous struct may be named by several fields
+type structKey struct {
+	t      reflect.Type
+	inline string
+}
+
+type structEntry struct {
+	dataType DataType
+	err      error
+}
+
+type primitiveEntry struct {
+	dataType DataType
+	ok       bool
+}
+
+// structType returns the description of the struct, see describeStruct
+func (m *typeModel) structType(t reflect.Type, inline string) (DataType, error) {
+	key := structKey{t: t, inline: inline}
+	if cached, ok := m.structs.Load(key); ok {
+		entry := cached.(structEntry)
+		return entry.dataType, entry.err
+	}
+	dataType, err := describeStruct(t, inline)
+	// concurrent extractions may describe the same type, they store equal entries
+	m.structs.Store(key, structEntry{dataType: dataType, err: err})
+	return dataType, err
+}
+
+// primitiveType returns the description of the defined primitive type, see describePrimitive
+func (m *typeModel) primitiveType(t reflect.Type) (DataType, bool) {
+	if cached, ok := m.primitives.Load(t); ok {
+		entry := cached.(primitiveEntry)
+		return entry.dataType, entry.ok
+	}
+	dataType, ok := describePrimitive(t)
+	m.primitives.Store(t, primitiveEntry{dataType: dataType, ok: ok})
+	return dataType, ok
+}
+
+// reset drops every description, e.g. once a type mapping is registered
+func (m *typeModel) reset() {
+	m.structs.Clear()
+	m.primitives.Clear()
+}
+
+// forget drops the description of the type, e.g. once its enum values are registered
+func (m *typeModel) forget(t reflect.Type) {
+	m.structs.Range(func(key, _ any) bool {
+		if key.(structKey).t == t {
+			m.structs.Delete(key)
+		}
+		return true
+	})
+	m.primitives.Delete(t)
+}
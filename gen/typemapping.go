@@ -0,0 +1,170 @@
+package gen
+
+import (
+	"go/token"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/dennypenta/vel"
+)
+
+// Mapping describes how the generated code represents a type the generator can't break down,
+// e.g. a struct with a custom JSON encoding like decimal.Decimal or a type that isn't a struct like uuid.UUID
+type Mapping struct {
+	// GoType is the type in the Go client, e.g. uuid.UUID, it must not be a predeclared type like string
+	GoType string
+	// GoImport is the package of GoType imported by the Go client, e.g. github.com/google/uuid,
+	// empty leaves it to the post-processor, e.g. goimports
+	GoImport string
+	// TSType is the type in the TS client, unknown if empty
+	TSType string
+	// ZodType is the schema validating the type in the TS client, z.unknown() if empty
+	ZodType string
+	// OpenAPISchema is the schema of the type in OpenAPI, any value if nil
+	OpenAPISchema *OpenAPISchema
+}
+
+var (
+	mappingsMu sync.RWMutex
+	// mappings are looked up by the reflected types of the fields
+	mappings = make(map[reflect.Type]Mapping)
+)
+
+func init() {
+	RegisterTypeMapping(reflect.TypeFor[time.Time](), Mapping{
+		GoType:        "time.Time",
+		TSType:        "string",
+		ZodType:       "z.string()",
+		OpenAPISchema: &OpenAPISchema{Type: "string", Format: "date-time"},
+	})
+	// encoding/json encodes a duration as the number of nanoseconds
+	RegisterTypeMapping(reflect.TypeFor[time.Duration](), Mapping{
+		GoType:        "time.Duration",
+		TSType:        "number",
+		ZodType:       "z.number()",
+		OpenAPISchema: &OpenAPISchema{Type: "integer", Format: "int64"},
+	})
+}
+
+// RegisterUUIDType teaches the generator and the decoding a UUID type named otherwise in the Go client,
+// the spec describes it as a string of the uuid format, the TS client as a string and the Go client names it by goType:
+//
+//	gen.RegisterUUIDType(reflect.TypeFor[uuid.UUID](), "uuid.UUID", "github.com/gofrs/uuid/v5")
+//
+// A type registered by vel.RegisterUUIDType only, e.g. by importing veluuid, is mapped as well keeping its name and package,
+// the service registers it that way to validate the UUIDs of the requests.
+func RegisterUUIDType(t reflect.Type, goType, goImport string) {
+	vel.RegisterUUIDType(t)
+	RegisterTypeMapping(t, uuidMapping(goType, goImport))
+}
+
+func uuidMapping(goType, goImport string) Mapping {
+	return Mapping{
+		GoType:        goType,
+		GoImport:      goImport,
+		TSType:        "string",
+		ZodType:       "z.string().uuid()",
+		OpenAPISchema: &OpenAPISchema{Type: "string", Format: vel.FormatUUID},
+	}
+}
+
+// RegisterTypeMapping teaches the generator a type it represents as is instead of breaking it down,
+// a registered type replaces the previous mapping of the type:
+//
+//	gen.RegisterTypeMapping(reflect.TypeFor[netip.Addr](), gen.Mapping{
+//		GoType:        "netip.Addr",
+//		GoImport:      "net/netip",
+//		TSType:        "string",
+//		ZodType:       "z.string().ip()",
+//		OpenAPISchema: &gen.OpenAPISchema{Type: "string", Format: "ip"},
+//	})
+//
+// time.Time and time.Duration are registered by default, the gen/protobuf package registers the protobuf Timestamp, Any and Struct.
+// The UUID types registered by vel.RegisterUUIDType are mapped on their own, see RegisterUUIDType. It panics if GoType isn't a type name,
+// call it before generating, e.g. in init.
+func RegisterTypeMapping(t reflect.Type, mapping Mapping) {
+	if mapping.GoType == "" || token.IsKeyword(mapping.GoType) || isPredeclared(mapping.GoType) {
+		panic("mapping of " + t.String() + " must name a type of the Go client, got " + mapping.GoType)
+	}
+	if mapping.TSType == "" {
+		mapping.TSType = "unknown"
+	}
+	if mapping.ZodType == "" {
+		mapping.ZodType = "z.unknown()"
+	}
+	if mapping.OpenAPISchema == nil {
+		mapping.OpenAPISchema = &OpenAPISchema{}
+	}
+
+	mappingsMu.Lock()
+	defer mappingsMu.Unlock()
+	mappings[t] = mapping
+	// the cached fields refer to the types by the previous names
+	typeCache.reset()
+}
+
+// unregisterTypeMapping drops the mapping of the type, the tests registering their stand-ins clean up by it
+func unregisterTypeMapping(t reflect.Type) {
+	mappingsMu.Lock()
+	defer mappingsMu.Unlock()
+	delete(mappings, t)
+	typeCache.reset()
+}
+
+// mappingOf returns the mapping of the type registered by RegisterTypeMapping,
+// a UUID type registered by vel.RegisterUUIDType only gets the mapping of its name on the first lookup
+func mappingOf(t reflect.Type) (Mapping, bool) {
+	mappingsMu.RLock()
+	mapping, ok := mappings[t]
+	mappingsMu.RUnlock()
+	if ok || t.Name() == "" || !vel.IsUUID(t) {
+		return mapping, ok
+	}
+	mapping = uuidMapping(t.String(), t.PkgPath())
+	mappingsMu.Lock()
+	defer mappingsMu.Unlock()
+	mappings[t] = mapping
+	return mapping, true
+}
+
+// mappedTypes maps the Go client names of the mapped types a field refers to, e.g. uuid.UUID of []uuid.UUID,
+// to their mappings. The type names of a field are resolved by them rather than by all the registered mappings,
+// so the types of different packages named alike, e.g. uuid.UUID of google/uuid and gofrs/uuid, don't collide.
+type mappedTypes map[string]Mapping
+
+func mappedTypesOf(t reflect.Type) mappedTypes {
+	mapped := make(mappedTypes)
+	mapped.add(t)
+	return mapped
+}
+
+func (m mappedTypes) add(t reflect.Type) {
+	if mapping, ok := mappingOf(t); ok {
+		m[mapping.GoType] = mapping
+		return
+	}
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		m.add(t.Elem())
+	case reflect.Map:
+		m.add(t.Key())
+		m.add(t.Elem())
+	}
+}
+
+// schema returns a copy of the schema of the mapping, the spec generation may complete it, e.g. by a description
+func (m Mapping) schema() *OpenAPISchema {
+	schema := *m.OpenAPISchema
+	return &schema
+}
+
+func isPredeclared(name string) bool {
+	switch name {
+	case "bool", "string", "byte", "rune", "error", "any",
+		"int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64", "uintptr",
+		"float32", "float64", "complex64", "complex128":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package gen
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ManifestName is the file of an output directory keeping the hashes of the files written by SkipUnchanged
+const ManifestName = ".vel-gen.json"
+
+type manifest struct {
+	Files map[string]manifestEntry `json:"files"`
+}
+
+type manifestEntry struct {
+	// Source is the hash of the unformatted code
+	Source string `json:"source"`
+	// Output is the hash of the written file, a file edited since then is generated again
+	Output string `json:"output"`
+}
+
+func readManifest(dir string) manifest {
+	m := manifest{Files: make(map[string]manifestEntry)}
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		return m
+	}
+	// a broken manifest only makes every file generated again
+	if json.Unmarshal(data, &m) != nil || m.Files == nil {
+		m.Files = make(map[string]manifestEntry)
+	}
+	return m
+}
+
+func (m manifest) write(dir string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, ManifestName), append(data, '\n'), 0644)
+}
+
+func hashOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateFilesSkipUnchanged is GenerateFilesWith skipping the post-processing and the writing of the files whose code
+// hasn't changed since the previous generation, so a slow post-processor runs only for the changed files.
+// The whole client is still rendered from the handlers every time, only the formatting and the writes are saved.
+// The hashes of the written files are kept in ManifestName of the output directory, remove it to write every file again,
+// e.g. after changing the post-processor. It returns the written files.
+func (g *ClientGen) GenerateFilesSkipUnchanged(outputDir, language string, postProcessor PostProcessor) ([]string, error) {
+	files, ok := clientFiles[language]
+	if !ok {
+		return nil, fmt.Errorf("language %s is not supported", language)
+	}
+	return g.writeChanged(outputDir, language, files, postProcessor)
+}
+
+func (g *ClientGen) writeChanged(outputDir, language string, files []struct{ name, part string }, postProcessor PostProcessor) ([]string, error) {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return nil, err
+	}
+	m := readManifest(outputDir)
+
+	var written []string
+	for _, file := range files {
+		meta := g.meta
+		meta.File = file.part
+		source, err := g.render(language+":default", meta)
+		if err != nil {
+			return written, fmt.Errorf("failed to generate %s: %w", file.name, err)
+		}
+
+		path := filepath.Join(outputDir, file.name)
+		sourceHash := hashOf(source)
+		if entry, ok := m.Files[file.name]; ok && entry.Source == sourceHash {
+			current, err := os.ReadFile(path)
+			if err == nil && hashOf(current) == entry.Output {
+				continue
+			}
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return written, err
+			}
+		}
+
+		output, err := postProcess(source, postProcessor)
+		if err != nil {
+			return written, fmt.Errorf("failed to generate %s: %w", file.name, err)
+		}
+		if err := os.WriteFile(path, output, 0644); err != nil {
+			return written, err
+		}
+		m.Files[file.name] = manifestEntry{Source: sourceHash, Output: hashOf(output)}
+		written = append(written, file.name)
+	}
+
+	if len(written) == 0 {
+		return nil, nil
+	}
+	return written, m.write(outputDir)
+}
@@ -0,0 +1,143 @@
+package gen
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dennypenta/vel"
+)
+
+// WatchInterval is how often the watched sources are checked for changes
+var WatchInterval = 500 * time.Millisecond
+
+// Watch generates the clients and the specs of the configs and regenerates them on every change of the Go sources
+// in the working directory until ctx is done. The router is built by the factory before every generation,
+// failures are logged and the watch goes on. The Router field of the configs is ignored.
+// The clients skip the unchanged files, so only the changed files are formatted and written, see SkipUnchanged.
+//
+// A Go program has to be rebuilt to pick up changed handlers, the vel command does it with vel gen -watch.
+func Watch(ctx context.Context, routerFactory func() *vel.Router, configs ...Config) error {
+	for i := range configs {
+		configs[i].Clients = slices.Clone(configs[i].Clients)
+		for j := range configs[i].Clients {
+			configs[i].Clients[j].SkipUnchanged = true
+		}
+	}
+	generate := func() {
+		router := routerFactory()
+		for _, config := range configs {
+			if err := runGen(router, config, nil); err != nil {
+				slog.Default().ErrorContext(ctx, "failed to generate", "err", err)
+			}
+		}
+	}
+
+	var ignore []string
+	for _, config := range configs {
+		ignore = append(ignore, config.OutputDirs()...)
+	}
+	ignore = cleanPaths(ignore)
+	// the sources are taken before the first generation, so a change made while it runs triggers another one
+	last, err := snapshotSources(".", ignore)
+	if err != nil {
+		return err
+	}
+	generate()
+	return pollSources(ctx, ".", ignore, last, generate)
+}
+
+// WatchSources calls onChange whenever a Go file under dir is created, modified or removed, until ctx is done.
+// The tests don't change the router, so the _test.go files, e.g. the contract tests, aren't watched.
+// Hidden directories, vendor, testdata and the ignored directories aren't watched,
+// ignore the directories of generated Go code so writing it doesn't trigger another change.
+func WatchSources(ctx context.Context, dir string, ignore []string, onChange func()) error {
+	ignore = cleanPaths(ignore)
+	last, err := snapshotSources(dir, ignore)
+	if err != nil {
+		return err
+	}
+	return pollSources(ctx, dir, ignore, last, onChange)
+}
+
+// pollSources calls onChange whenever the sources differ from the last ones, see WatchSources
+func pollSources(ctx context.Context, dir string, ignore []string, last map[string]sourceState, onChange func()) error {
+	ticker := time.NewTicker(WatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		current, err := snapshotSources(dir, ignore)
+		if err != nil {
+			// files may be moved while they're edited, the next tick sees a consistent tree
+			continue
+		}
+		if !maps.Equal(last, current) {
+			last = current
+			onChange()
+		}
+	}
+}
+
+func cleanPaths(paths []string) []string {
+	paths = slices.Clone(paths)
+	for i := range paths {
+		paths[i] = filepath.Clean(paths[i])
+	}
+	return paths
+}
+
+type sourceState struct {
+	modTime time.Time
+	size    int64
+}
+
+func snapshotSources(dir string, ignore []string) (map[string]sourceState, error) {
+	sources := make(map[string]sourceState)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			name := d.Name()
+			if path != dir && (strings.HasPrefix(name, ".") || name == "vendor" || name == "testdata") {
+				return filepath.SkipDir
+			}
+			for _, ignored := range ignore {
+				if filepath.Clean(path) == ignored {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		sources[path] = sourceState{modTime: info.ModTime(), size: info.Size()}
+		return nil
+	})
+	return sources, err
+}
+
+// OutputDirs lists the output directories of the clients, e.g. to ignore them in WatchSources
+func (c Config) OutputDirs() []string {
+	var dirs []string
+	for _, client := range c.Clients {
+		if client.OutputDir != "" {
+			dirs = append(dirs, client.OutputDir)
+		}
+	}
+	return dirs
+}
@@ -3,6 +3,15 @@ module github.com/dennypenta/vel
 go 1.24.0
 
 require (
+	github.com/google/uuid v1.6.0
 	github.com/gorilla/schema v1.4.1
+	github.com/shopspring/decimal v1.4.0
+	golang.org/x/tools v0.38.0
+	google.golang.org/protobuf v1.36.9
 	gopkg.in/yaml.v3 v3.0.1
 )
+
+require (
+	golang.org/x/mod v0.29.0 // indirect
+	golang.org/x/sync v0.17.0 // indirect
+)
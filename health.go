@@ -0,0 +1,171 @@
+package vel
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	HealthStatusOK     = "ok"
+	HealthStatusFailed = "failed"
+)
+
+// HealthReport is the body of /livez and /readyz, see RegisterHealthEndpoints
+type HealthReport struct {
+	// Status is failed if any check failed
+	Status string              `json:"status"`
+	Checks []HealthCheckResult `json:"checks"`
+}
+
+// HealthCheckResult is the outcome of a named check, the latency and the error are reported only by the verbose probes
+type HealthCheckResult struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type HealthOpts struct {
+	// Verbose reports the latency and the error of every check, the errors may expose the internals,
+	// e.g. the address of a database, so the endpoints should be protected by the middlewares then
+	Verbose bool
+}
+
+// RegisterHealthEndpoints serves the probes running the checks: GET /livez reports the liveness checks
+// and GET /readyz all of them, a failed check makes the probe answer 503. The probes report the names
+// and the statuses of the checks only, unless the opts are verbose. The endpoints are not a part of the router meta.
+func (r *Router) RegisterHealthEndpoints(opts HealthOpts, middlewares ...Middleware) {
+	var livez http.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.serveLivez(w, req, opts)
+	})
+	var readyz http.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.serveReadyz(w, req, opts)
+	})
+	for i := range middlewares {
+		livez, readyz = middlewares[i](livez), middlewares[i](readyz)
+	}
+	site := callSite()
+	r.handle("GET /livez", livez, nil, site)
+	r.handle("GET /readyz", readyz, nil, site)
+}
+
+// HealthCheck reports whether a dependency or a part of the process works, e.g. pings a database
+type HealthCheck func(ctx context.Context) error
+
+type namedCheck struct {
+	name     string
+	check    HealthCheck
+	liveness bool
+}
+
+// healthChecks is the registry of the checks shared by a router and its subrouters
+type healthChecks struct {
+	mu     sync.Mutex
+	checks []namedCheck
+}
+
+// AddHealthCheck registers a readiness check, /readyz answers 503 while it fails, see RegisterHealthEndpoints,
+// e.g. the database is unreachable and the instance shouldn't get traffic
+func (r *Router) AddHealthCheck(name string, check HealthCheck) {
+	r.shared.health.add(namedCheck{name: name, check: check})
+}
+
+// AddLivenessCheck registers a liveness check, both /livez and /readyz answer 503 while it fails,
+// e.g. a worker loop is stuck and the process should be restarted
+func (r *Router) AddLivenessCheck(name string, check HealthCheck) {
+	r.shared.health.add(namedCheck{name: name, check: check, liveness: true})
+}
+
+func (h *healthChecks) add(check namedCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, check)
+}
+
+// run runs the checks concurrently with the request context, the results keep the registration order
+func (h *healthChecks) run(ctx context.Context, livenessOnly, verbose bool) HealthReport {
+	h.mu.Lock()
+	var checks []namedCheck
+	for _, c := range h.checks {
+		if c.liveness || !livenessOnly {
+			checks = append(checks, c)
+		}
+	}
+	h.mu.Unlock()
+
+	report := HealthReport{Status: HealthStatusOK, Checks: make([]HealthCheckResult, len(checks))}
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := c.check(ctx)
+			result := HealthCheckResult{Name: c.name, Status: HealthStatusOK}
+			if verbose {
+				result.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+			}
+			if err != nil {
+				result.Status = HealthStatusFailed
+				if verbose {
+					result.Error = err.Error()
+				} else {
+					slog.Default().WarnContext(ctx, "health check failed", "err", err, "check", c.name)
+				}
+			}
+			report.Checks[i] = result
+		}()
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != HealthStatusOK {
+			report.Status = HealthStatusFailed
+		}
+	}
+	return report
+}
+
+// serveHealthz answers 200 until the router starts shutting down, it runs no checks
+func (r *Router) serveHealthz(w http.ResponseWriter, req *http.Request) {
+	if r.shared.unready.Load() {
+		writeShuttingDown(w, req)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// serveLivez reports the liveness checks, the process stays alive while shutting down
+func (r *Router) serveLivez(w http.ResponseWriter, req *http.Request, opts HealthOpts) {
+	writeHealthReport(w, req, r.shared.health.run(req.Context(), true, opts.Verbose))
+}
+
+// serveReadyz reports all the checks, the router isn't ready once it starts shutting down
+func (r *Router) serveReadyz(w http.ResponseWriter, req *http.Request, opts HealthOpts) {
+	if r.shared.unready.Load() {
+		writeShuttingDown(w, req)
+		return
+	}
+	writeHealthReport(w, req, r.shared.health.run(req.Context(), false, opts.Verbose))
+}
+
+func writeShuttingDown(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusServiceUnavailable, &Error{Code: ShuttingDownCode, Message: "the server is shutting down"})
+}
+
+func writeHealthReport(w http.ResponseWriter, r *http.Request, report HealthReport) {
+	status := http.StatusOK
+	if report.Status != HealthStatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		slog.Default().ErrorContext(r.Context(), "failed to write health report", "err", err)
+	}
+}
@@ -0,0 +1,284 @@
+package vel
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// IdempotencyKeyHeader carries the key identifying the retries of a request, see Idempotency
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is "true" in the responses replayed from the store
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	IdempotencyKeyRequiredCode = "IDEMPOTENCY_KEY_REQUIRED"
+	IdempotencyConflictCode    = "IDEMPOTENCY_CONFLICT"
+	IdempotencyMismatchCode    = "IDEMPOTENCY_KEY_MISMATCH"
+
+	defaultIdempotencyBodySize = 1 << 20
+)
+
+// ErrIdempotencyInProgress is returned by an IdempotencyStore reserving a key another request holds
+var ErrIdempotencyInProgress = errors.New("the request with the idempotency key is in progress")
+
+// IdempotentResponse is a response stored to be replayed on the retries
+type IdempotentResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// RequestHash is the hash of the request body, a retry with another body is rejected
+	RequestHash string
+}
+
+// IdempotencyStore keeps the responses by the idempotency keys, e.g. in Redis to share them between instances.
+// The keys are scoped by the route and the caller, the store gets them as is.
+type IdempotencyStore interface {
+	// Reserve claims the key for a request, it returns the stored response if the key has one,
+	// ErrIdempotencyInProgress if another request holds the key, and nil if the key is claimed
+	Reserve(ctx context.Context, key string) (*IdempotentResponse, error)
+	// Save stores the response of the request holding the key
+	Save(ctx context.Context, key string, resp IdempotentResponse) error
+	// Release drops the claim of a request whose response isn't stored, so a retry is served again
+	Release(ctx context.Context, key string) error
+}
+
+type IdempotencyOpts struct {
+	// Required rejects the requests without the key by 400, otherwise they're served as is
+	Required bool
+	// MaxBodySize limits the stored response body, a larger response isn't stored, 1MB if zero
+	MaxBodySize int
+	// KeyFunc returns the caller the keys are scoped by, so a caller can't get the response of another one,
+	// the id of the principal if nil, see PrincipalFromContext, the requests without one share the keys then
+	KeyFunc func(r *http.Request) string
+}
+
+// Idempotency is a per-route middleware implementing the Idempotency-Key pattern, e.g. for payments:
+// the first response to a key is stored and replayed to the retries with the IdempotentReplayedHeader,
+// a retry arriving while the first request is in progress is rejected by 409 Conflict
+// and a retry with another body than the first request by 422 Unprocessable Entity.
+// The keys are scoped by the route and the caller, see IdempotencyOpts.KeyFunc.
+// The server errors (5xx) aren't stored, the retries are served again then.
+// The header is documented in the OpenAPI spec of the routes using the middleware.
+func Idempotency(store IdempotencyStore, opts IdempotencyOpts) Middleware {
+	if opts.MaxBodySize == 0 {
+		opts.MaxBodySize = defaultIdempotencyBodySize
+	}
+	if opts.KeyFunc == nil {
+		opts.KeyFunc = principalID
+	}
+	return func(next http.Handler) http.Handler {
+		return &idempotencyHandler{next: next, store: store, opts: opts}
+	}
+}
+
+type idempotencyHandler struct {
+	next  http.Handler
+	store IdempotencyStore
+	opts  IdempotencyOpts
+}
+
+// requestHeaders documents the header in the meta of the route, see RegisterHandler
+func (h *idempotencyHandler) requestHeaders() []KeyValueSpec {
+	return []KeyValueSpec{{
+		Key:         IdempotencyKeyHeader,
+		ValueType:   String,
+		Description: "unique key of the request, its retries get the response of the first one",
+		Validation:  Validation{Required: h.opts.Required},
+	}}
+}
+
+func (h *idempotencyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key == "" {
+		if h.opts.Required {
+			writeError(w, r, http.StatusBadRequest, &Error{Code: IdempotencyKeyRequiredCode, Message: "the " + IdempotencyKeyHeader + " header is required"})
+			return
+		}
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	ctx := r.Context()
+	key = h.opts.KeyFunc(r) + " " + key
+	// a handler without a route meta, e.g. served by the mux directly, is keyed by the request path
+	if meta := MetaFromContext(ctx); meta != nil {
+		key = meta.Method + " " + meta.Path + " " + key
+	} else {
+		key = r.Method + " " + r.URL.Path + " " + key
+	}
+	stored, err := h.store.Reserve(ctx, key)
+	switch {
+	case errors.Is(err, ErrIdempotencyInProgress):
+		writeError(w, r, http.StatusConflict, &Error{Code: IdempotencyConflictCode, Message: "a request with the same idempotency key is in progress"})
+		return
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, &Error{Err: err})
+		return
+	case stored != nil:
+		requestHash, err := hashBody(sha256.New(), r.Body)
+		if err != nil {
+			bodyErr := bodyError(http.StatusBadRequest, "FAILED_DECODING_REQUEST_BODY", err)
+			writeError(w, r, bodyErr.Status, bodyErr)
+			return
+		}
+		if requestHash != stored.RequestHash {
+			writeError(w, r, http.StatusUnprocessableEntity, &Error{Code: IdempotencyMismatchCode, Message: "the idempotency key is used by a request with another body"})
+			return
+		}
+		w.Header().Set(IdempotentReplayedHeader, "true")
+		writeStored(w, r, stored.Status, stored.Header, stored.Body)
+		return
+	}
+
+	saved := false
+	defer func() {
+		// the claim is dropped also if the handler panics
+		if !saved {
+			if err := h.store.Release(context.WithoutCancel(ctx), key); err != nil {
+				slog.Default().ErrorContext(ctx, "failed to release idempotency key", "err", err)
+			}
+		}
+	}()
+	// the body is hashed as the handler reads it, so it isn't buffered
+	hasher := sha256.New()
+	original := r.Body
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(original, hasher), original}
+	// the headers set by the outer middlewares belong to the request, e.g. a request id, they aren't replayed
+	outer := w.Header().Clone()
+	cw := &captureWriter{ResponseWriter: w, capture: true, body: cappedBuffer{limit: h.opts.MaxBodySize}}
+	h.next.ServeHTTP(cw, r)
+
+	status := cw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status >= http.StatusInternalServerError || cw.body.truncated {
+		return
+	}
+	// the rest of the body the handler didn't read, it's read past the tee not to be hashed twice
+	requestHash, err := hashBody(hasher, original)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "failed to read idempotent request body", "err", err)
+		return
+	}
+	resp := IdempotentResponse{Status: status, Header: changedHeaders(outer, w.Header()), Body: cw.body.Bytes(), RequestHash: requestHash}
+	if err := h.store.Save(context.WithoutCancel(ctx), key, resp); err != nil {
+		slog.Default().ErrorContext(ctx, "failed to store idempotent response", "err", err)
+		return
+	}
+	saved = true
+}
+
+func principalID(r *http.Request) string {
+	if p := PrincipalFromContext(r.Context()); p != nil {
+		return p.ID
+	}
+	return ""
+}
+
+// hashBody returns the hash of the body read to the end
+func hashBody(h hash.Hash, body io.Reader) (string, error) {
+	if _, err := io.Copy(h, body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// changedHeaders returns the headers set since the snapshot, e.g. by a handler, to be stored along with its response
+func changedHeaders(snapshot, current http.Header) http.Header {
+	changed := make(http.Header)
+	for key, values := range current {
+		if !slices.Equal(snapshot[key], values) {
+			changed[key] = slices.Clone(values)
+		}
+	}
+	return changed
+}
+
+// writeStored writes a stored response over the headers set already
+func writeStored(w http.ResponseWriter, r *http.Request, status int, header http.Header, body []byte) {
+	for key, values := range header {
+		w.Header()[key] = slices.Clone(values)
+	}
+	w.WriteHeader(status)
+	if _, err := w.Write(body); err != nil {
+		slog.Default().ErrorContext(r.Context(), "failed to write stored response", "err", err)
+	}
+}
+
+// MemoryIdempotencyStore keeps the responses in memory for the TTL, it suits a single instance
+type MemoryIdempotencyStore struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]idempotencyEntry
+	swept   time.Time
+}
+
+// idempotencyEntry is a stored response or a claim if the response is nil
+type idempotencyEntry struct {
+	resp    *IdempotentResponse
+	expires time.Time
+}
+
+// NewMemoryIdempotencyStore makes a store keeping the responses for the TTL, 24 hours if zero
+func NewMemoryIdempotencyStore(ttl time.Duration) *MemoryIdempotencyStore {
+	if ttl == 0 {
+		ttl = 24 * time.Hour
+	}
+	return &MemoryIdempotencyStore{ttl: ttl, entries: make(map[string]idempotencyEntry), swept: time.Now()}
+}
+
+func (s *MemoryIdempotencyStore) Reserve(_ context.Context, key string) (*IdempotentResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.sweep(now)
+	if entry, ok := s.entries[key]; ok && (entry.resp == nil || now.Before(entry.expires)) {
+		if entry.resp == nil {
+			return nil, ErrIdempotencyInProgress
+		}
+		return entry.resp, nil
+	}
+	s.entries[key] = idempotencyEntry{}
+	return nil, nil
+}
+
+func (s *MemoryIdempotencyStore) Save(_ context.Context, key string, resp IdempotentResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = idempotencyEntry{resp: &resp, expires: time.Now().Add(s.ttl)}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+// sweep drops the expired responses once per TTL, the claims are dropped by their requests
+func (s *MemoryIdempotencyStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < s.ttl {
+		return
+	}
+	s.swept = now
+	for key, entry := range s.entries {
+		if entry.resp != nil && now.After(entry.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
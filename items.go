@@ -0,0 +1,87 @@
+package vel
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"iter"
+	"net/http"
+	"reflect"
+)
+
+// NDJSON is the content type of the items streamed as lines of JSON, see Items and StreamNDJSON
+const NDJSON = "application/x-ndjson"
+
+// Items is the input of a handler consuming a stream of items sent as NDJSON, e.g. a bulk import,
+// the handler ranges over the items while the request body is still being read:
+//
+//	func(ctx context.Context, items vel.Items[Event]) (Summary, *vel.Error) {
+//		for event, err := range items {
+//			if err != nil {
+//				return Summary{}, err
+//			}
+//			...
+//		}
+//	}
+//
+// An item is validated like an input if it implements Validator, a line failing to decode or to validate
+// ends the iteration with its error. The items are read once, the iteration can't be restarted.
+type Items[T any] iter.Seq2[T, *Error]
+
+// itemsInput decodes the items of a request body, it's implemented by Items
+type itemsInput interface {
+	itemType() reflect.Type
+	read(body io.Reader) any
+}
+
+func (Items[T]) itemType() reflect.Type {
+	return reflect.TypeFor[T]()
+}
+
+func (Items[T]) read(body io.Reader) any {
+	return Items[T](func(yield func(T, *Error) bool) {
+		dec := json.NewDecoder(body)
+		for {
+			var item T
+			err := dec.Decode(&item)
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				yield(item, &Error{
+					Code:   "FAILED_DECODING_REQUEST_BODY",
+					Status: http.StatusBadRequest,
+					Err:    err,
+				})
+				return
+			}
+			if validationErr := validate(&item); validationErr != nil {
+				validationErr.Status = http.StatusUnprocessableEntity
+				yield(item, validationErr)
+				return
+			}
+			if !yield(item, nil) {
+				return
+			}
+		}
+	})
+}
+
+// ItemType returns the item type of an Items input and whether the type is one,
+// the meta describes the input by the Items and the generated clients send a sequence of the items.
+func ItemType(t reflect.Type) (reflect.Type, bool) {
+	if t == nil || !t.Implements(reflect.TypeFor[itemsInput]()) {
+		return nil, false
+	}
+	return reflect.Zero(t).Interface().(itemsInput).itemType(), true
+}
+
+// readItems passes the items of the request body to the Items input,
+// the response of a route streaming its output is written while the items are still read
+func readItems[I any](w http.ResponseWriter, r *http.Request, i *I) {
+	if meta := MetaFromContext(r.Context()); meta != nil && meta.Spec.Stream != "" {
+		// HTTP/1 closes the request body once the response is written unless it's full duplex
+		_ = http.NewResponseController(w).EnableFullDuplex()
+	}
+	*i = any(*i).(itemsInput).read(r.Body).(I)
+}
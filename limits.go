@@ -0,0 +1,123 @@
+package vel
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"time"
+)
+
+const (
+	// RequestTooLargeCode answers 413 to a request body exceeding the size limit of its route, see Opts.MaxBodyBytes
+	RequestTooLargeCode = "REQUEST_TOO_LARGE"
+	// RequestTimeoutCode answers 408 to a request body not read within the decode timeout of its route, see Opts.DecodeTimeout
+	RequestTimeoutCode = "REQUEST_TIMEOUT"
+)
+
+// limitBody bounds the request body decoded by the handler by the size and the time limits of its route,
+// the returned function restores the read deadline of the server once the body is decoded, so it doesn't cut the connection afterwards.
+// The deadline is set on the connection, a ResponseWriter not supporting it, e.g. a recorder, reads without one.
+func limitBody(w http.ResponseWriter, r *http.Request) (release func()) {
+	var spec Spec
+	if meta := MetaFromContext(r.Context()); meta != nil {
+		spec = meta.Spec
+	}
+	maxBytes, timeout := bodyLimits(spec)
+	if maxBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	}
+	if timeout <= 0 {
+		return func() {}
+	}
+	now := time.Now()
+	deadline := now.Add(timeout)
+	// the server bounds the whole request by its ReadTimeout since it started reading it, a bit earlier than now,
+	// the decode timeout doesn't outlast it, zero is no deadline of a server without one
+	var serverDeadline time.Time
+	if srv, ok := r.Context().Value(http.ServerContextKey).(*http.Server); ok && srv.ReadTimeout > 0 {
+		serverDeadline = now.Add(srv.ReadTimeout)
+		if serverDeadline.Before(deadline) {
+			deadline = serverDeadline
+		}
+	}
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		return func() {}
+	}
+	return func() { _ = rc.SetReadDeadline(serverDeadline) }
+}
+
+// bodyError is the error of a failed decoding of the request body or query: an overrun limit, the violations of the malformed values, e.g. UUIDs,
+// or the failure of the status and the code
+func bodyError(status int, code string, err error) *Error {
+	var tooLarge *http.MaxBytesError
+	var malformed *violationsError
+	switch {
+	case errors.As(err, &malformed):
+		return &Error{Code: ValidationFailedCode, Status: http.StatusUnprocessableEntity, Violations: malformed.violations, Err: err}
+	case errors.As(err, &tooLarge):
+		return &Error{Code: RequestTooLargeCode, Status: http.StatusRequestEntityTooLarge, Message: "the request body exceeds the limit", Err: err}
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return &Error{Code: RequestTimeoutCode, Status: http.StatusRequestTimeout, Message: "the request body isn't read in time", Err: err}
+	case errors.Is(err, errMsgPackRejected):
+		return &Error{Code: UnsupportedMediaTypeCode, Status: http.StatusUnsupportedMediaType, Message: err.Error(), Err: err}
+	}
+	return &Error{Code: code, Status: status, Err: err}
+}
+
+// bodyLimits returns the size and the time limits of the route spec, the global ones unless the spec replaces them,
+// a non-positive limit doesn't bound the body
+func bodyLimits(spec Spec) (maxBytes int64, timeout time.Duration) {
+	maxBytes, timeout = GlobalOpts.MaxBodyBytes, GlobalOpts.DecodeTimeout
+	if spec.MaxBodyBytes != 0 {
+		maxBytes = spec.MaxBodyBytes
+	}
+	if spec.DecodeTimeout != 0 {
+		timeout = spec.DecodeTimeout
+	}
+	return maxBytes, timeout
+}
+
+// limitErrors are the errors documented for the routes decoding their request bodies within the limits, see documentLimits
+var limitErrors = map[int]ErrorSpec{
+	http.StatusRequestTimeout:        {Code: RequestTimeoutCode, Description: "the request body isn't read within the decode timeout"},
+	http.StatusRequestEntityTooLarge: {Code: RequestTooLargeCode, Description: "the request body exceeds the size limit"},
+}
+
+// documentLimits adds the errors of the set body limits to the spec of a route decoding its body,
+// the errors map of the spec may be shared by the routes, so it's copied
+func (m *HandlerMeta) documentLimits() {
+	if !decodesBody(m) {
+		return
+	}
+	maxBytes, timeout := bodyLimits(m.Spec)
+	if maxBytes <= 0 && timeout <= 0 {
+		return
+	}
+	errs := maps.Clone(m.Spec.Errors)
+	if errs == nil {
+		errs = make(map[int][]ErrorSpec, len(limitErrors))
+	}
+	for status, spec := range limitErrors {
+		if (status == http.StatusRequestEntityTooLarge && maxBytes <= 0) || (status == http.StatusRequestTimeout && timeout <= 0) {
+			continue
+		}
+		if !slices.ContainsFunc(errs[status], func(other ErrorSpec) bool { return other.Code == spec.Code }) {
+			errs[status] = append(slices.Clip(errs[status]), spec)
+		}
+	}
+	m.Spec.Errors = errs
+}
+
+// decodesBody reports whether the handler of the route decodes its request body, the raw bodies and the items are read by the handler
+func decodesBody(m *HandlerMeta) bool {
+	if m.Method == "GET" || m.Input == nil {
+		return false
+	}
+	t := reflect.TypeOf(m.Input)
+	_, items := ItemType(t)
+	return HasBody(t) && !IsRawBody(t) && !items
+}
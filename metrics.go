@@ -0,0 +1,157 @@
+package vel
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type MetricLabel string
+
+const (
+	MetricLabelOperation MetricLabel = "operation"
+	MetricLabelMethod    MetricLabel = "method"
+	MetricLabelStatus    MetricLabel = "status"
+	// MetricLabelFallback is "true" for the fallback responses, it's recorded only if enabled explicitly
+	MetricLabelFallback MetricLabel = "fallback"
+)
+
+// MetricUnmatched is the operation of requests not matching any registered route,
+// 404s on random paths are grouped under it instead of producing a label value per path
+const MetricUnmatched = "unmatched"
+
+// MetricsRecorder records served requests,
+// the labels map is compatible with prometheus.Labels, e.g. histogramVec.With(labels).Observe(d.Seconds())
+type MetricsRecorder interface {
+	RecordRequest(labels map[string]string, duration time.Duration)
+}
+
+type MetricsRecorderFunc func(labels map[string]string, duration time.Duration)
+
+func (f MetricsRecorderFunc) RecordRequest(labels map[string]string, duration time.Duration) {
+	f(labels, duration)
+}
+
+type MetricsOpts struct {
+	// Labels enabled in the recorded metrics, all of them if empty
+	Labels []MetricLabel
+	// StatusClass records statuses as 2xx, 3xx, 4xx or 5xx
+	StatusClass bool
+}
+
+// LabelNames returns the enabled label names in a stable order, e.g. to declare a metric vector
+func (o MetricsOpts) LabelNames() []string {
+	labels := o.Labels
+	if len(labels) == 0 {
+		labels = []MetricLabel{MetricLabelOperation, MetricLabelMethod, MetricLabelStatus}
+	}
+	names := make([]string, len(labels))
+	for i := range labels {
+		names[i] = string(labels[i])
+	}
+	return names
+}
+
+// knownMethods bounds the method label, any other method is recorded as OTHER
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// MetricsHandler wraps the router mux recording every request.
+// The label values are bounded by the registered routes:
+// the operation is the operation id of the matched route prefixed by its subrouters, e.g. v1/items,
+// so the same operation id in several subrouters isn't merged, or the pattern for routes registered on the mux directly,
+// unknown paths are recorded as MetricUnmatched.
+func (r *Router) MetricsHandler(rec MetricsRecorder, opts MetricsOpts) http.Handler {
+	names := opts.LabelNames()
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		operation := MetricUnmatched
+		if _, pattern := r.mux.Handler(req); pattern != "" {
+			operation = pattern
+			if meta := r.shared.patterns[pattern]; meta != nil {
+				operation = strings.TrimPrefix(meta.Path, "/")
+			}
+		}
+		method := req.Method
+		if !knownMethods[method] {
+			method = "OTHER"
+		}
+
+		sw := &statusWriter{ResponseWriter: w}
+		record := &metricsRecord{}
+		req = req.WithContext(metricsKey.Set(req.Context(), record))
+		start := time.Now()
+		r.mux.ServeHTTP(sw, req)
+		duration := time.Since(start)
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		statusLabel := strconv.Itoa(status)
+		if opts.StatusClass {
+			statusLabel = strconv.Itoa(status/100) + "xx"
+		}
+
+		labels := make(map[string]string, len(names))
+		for _, name := range names {
+			switch MetricLabel(name) {
+			case MetricLabelOperation:
+				labels[name] = operation
+			case MetricLabelMethod:
+				labels[name] = method
+			case MetricLabelStatus:
+				labels[name] = statusLabel
+			case MetricLabelFallback:
+				labels[name] = strconv.FormatBool(record.fallback)
+			}
+		}
+		rec.RecordRequest(labels, duration)
+	})
+}
+
+// metricsRecord collects the facts about a request reported by the handler
+type metricsRecord struct {
+	fallback bool
+}
+
+// markFallback records the request served by a fallback
+func markFallback(ctx context.Context) {
+	if record, ok := metricsKey.From(ctx); ok {
+		record.fallback = true
+	}
+}
+
+// statusWriter remembers the status code written by a handler
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
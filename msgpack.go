@@ -0,0 +1,85 @@
+package vel
+
+import (
+	"bytes"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dennypenta/vel/msgpack"
+)
+
+// MsgPack is the content type of the MessagePack bodies negotiated instead of JSON, e.g. between internal services:
+// a request body of the type is decoded by the msgpack package once the router accepts them, see Router.AcceptMsgPack,
+// and a response is encoded by it if the request accepts it at least as much as JSON.
+// The errors are encoded by the ErrorEncoder as they are.
+const MsgPack = msgpack.ContentType
+
+// UnsupportedMediaTypeCode answers 415 to a MessagePack request body sent to a router not accepting them
+const UnsupportedMediaTypeCode = "UNSUPPORTED_MEDIA_TYPE"
+
+var errMsgPackRejected = errors.New("the router doesn't accept MessagePack request bodies")
+
+// AcceptMsgPack makes the routes of the router and its subrouters decode the request bodies sent as MsgPack,
+// e.g. by the Go clients generated with MsgPack set. A router decodes JSON only unless it opts in,
+// a MessagePack body is answered by 415 UNSUPPORTED_MEDIA_TYPE then.
+func (r *Router) AcceptMsgPack() {
+	r.shared.msgPack = true
+}
+
+// msgPackAccepted reports whether the router of the route decodes MessagePack request bodies
+func msgPackAccepted(r *http.Request) bool {
+	rt := routeFromContext(r.Context())
+	return rt != nil && rt.shared.msgPack
+}
+
+// isMsgPack reports whether the request body is MessagePack
+func isMsgPack(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == MsgPack
+}
+
+// acceptsMsgPack reports whether the response is MessagePack, see acceptsOverJSON
+func acceptsMsgPack(r *http.Request) bool {
+	return acceptsOverJSON(r, MsgPack)
+}
+
+// acceptsOverJSON reports whether Accept lists the media type with a quality not lower than the one of JSON
+func acceptsOverJSON(r *http.Request, want string) bool {
+	accept := r.Header.Get("Accept")
+	if !strings.Contains(accept, want) {
+		return false
+	}
+	wantQ, jsonQ := 0.0, 0.0
+	for part := range strings.SplitSeq(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case want:
+			wantQ = q
+		case "application/json":
+			jsonQ = q
+		}
+	}
+	return wantQ > 0 && wantQ >= jsonQ
+}
+
+// encodeMsgPack encodes the value to the buffer
+func encodeMsgPack(buf *bytes.Buffer, v any) error {
+	b, err := msgpack.Append(buf.AvailableBuffer(), v)
+	if err != nil {
+		return err
+	}
+	buf.Write(b)
+	return nil
+}
@@ -0,0 +1,1172 @@
+// Package msgpack encodes and decodes MessagePack the way encoding/json does JSON, so a body has the same shape in both:
+// a struct is a map keyed by the json tag names of its fields honoring omitempty, omitzero and "-",
+// the fields of an embedded struct are inlined, a map key is a string, time.Time is its RFC 3339 string
+// and a type with its own MarshalJSON or MarshalText is encoded as the value of its JSON or as its text.
+// A []byte is a MessagePack bin instead of a base64 string.
+//
+// vel decodes a request body and encodes a response by it once the client negotiates ContentType,
+// the generated Go clients use it to talk to the services by MessagePack.
+package msgpack
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContentType is the media type of the MessagePack bodies
+const ContentType = "application/msgpack"
+
+var (
+	timeType            = reflect.TypeFor[time.Time]()
+	numberType          = reflect.TypeFor[json.Number]()
+	jsonMarshalerType   = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType   = reflect.TypeFor[encoding.TextMarshaler]()
+	jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+	isZeroerType        = reflect.TypeFor[interface{ IsZero() bool }]()
+)
+
+// Marshal returns the MessagePack encoding of v
+func Marshal(v any) ([]byte, error) {
+	return Append(nil, v)
+}
+
+// Append appends the MessagePack encoding of v to b
+func Append(b []byte, v any) ([]byte, error) {
+	return appendValue(b, reflect.ValueOf(v))
+}
+
+func appendValue(b []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() || (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil() {
+		return append(b, 0xc0), nil
+	}
+	t := v.Type()
+	switch {
+	case t == timeType:
+		return appendString(b, v.Interface().(time.Time).Format(time.RFC3339Nano)), nil
+	case t == numberType:
+		return appendNumber(b, v.String())
+	}
+	if m, ok := marshaler(v, jsonMarshalerType); ok {
+		data, err := m.(json.Marshaler).MarshalJSON()
+		if err != nil {
+			return b, err
+		}
+		return appendJSON(b, data)
+	}
+	if m, ok := marshaler(v, textMarshalerType); ok {
+		text, err := m.(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return b, err
+		}
+		return appendString(b, string(text)), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendInt(b, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendUint(b, v.Uint()), nil
+	case reflect.Float32:
+		return binary.BigEndian.AppendUint32(append(b, 0xca), math.Float32bits(float32(v.Float()))), nil
+	case reflect.Float64:
+		return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(v.Float())), nil
+	case reflect.String:
+		return appendString(b, v.String()), nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		if t.Elem().Kind() == reflect.Uint8 && !implementsMarshaler(t.Elem()) {
+			return appendBin(b, v.Bytes()), nil
+		}
+		return appendArray(b, v)
+	case reflect.Array:
+		return appendArray(b, v)
+	case reflect.Map:
+		return appendMap(b, v)
+	case reflect.Struct:
+		return appendStruct(b, v)
+	case reflect.Pointer, reflect.Interface:
+		return appendValue(b, v.Elem())
+	}
+	return b, fmt.Errorf("msgpack: unsupported type %s", t)
+}
+
+// marshaler returns the value as the interface if it or its pointer implements it the way encoding/json calls the methods
+func marshaler(v reflect.Value, iface reflect.Type) (any, bool) {
+	if v.Type().Implements(iface) {
+		return v.Interface(), true
+	}
+	if v.CanAddr() && reflect.PointerTo(v.Type()).Implements(iface) {
+		return v.Addr().Interface(), true
+	}
+	return nil, false
+}
+
+func implementsMarshaler(t reflect.Type) bool {
+	p := reflect.PointerTo(t)
+	return p.Implements(jsonMarshalerType) || p.Implements(textMarshalerType)
+}
+
+// appendJSON appends the value of the JSON produced by a MarshalJSON method
+func appendJSON(b []byte, data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return b, fmt.Errorf("msgpack: invalid JSON of MarshalJSON: %w", err)
+	}
+	return appendValue(b, reflect.ValueOf(v))
+}
+
+// appendNumber appends a JSON number as an integer if it's one, as a float otherwise
+func appendNumber(b []byte, s string) ([]byte, error) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return appendInt(b, i), nil
+	}
+	if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return appendUint(b, u), nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return b, fmt.Errorf("msgpack: invalid number %q", s)
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(f)), nil
+}
+
+func appendInt(b []byte, n int64) []byte {
+	switch {
+	case n >= 0:
+		return appendUint(b, uint64(n))
+	case n >= -32:
+		return append(b, byte(n))
+	case n >= math.MinInt8:
+		return append(b, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(n))
+	case n >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(n))
+}
+
+func appendUint(b []byte, n uint64) []byte {
+	switch {
+	case n <= 0x7f:
+		return append(b, byte(n))
+	case n <= math.MaxUint8:
+		return append(b, 0xcc, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xcf), n)
+}
+
+func appendString(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func appendBin(b []byte, data []byte) []byte {
+	n := len(data)
+	switch {
+	case n <= math.MaxUint8:
+		b = append(b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xc5), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xc6), uint32(n))
+	}
+	return append(b, data...)
+}
+
+func appendArrayLen(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xdc), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdd), uint32(n))
+}
+
+func appendMapLen(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xde), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdf), uint32(n))
+}
+
+func appendArray(b []byte, v reflect.Value) ([]byte, error) {
+	b = appendArrayLen(b, v.Len())
+	var err error
+	for i := range v.Len() {
+		if b, err = appendValue(b, v.Index(i)); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+// appendMap appends a map with its keys sorted like encoding/json does to keep the encoding stable
+func appendMap(b []byte, v reflect.Value) ([]byte, error) {
+	if v.IsNil() {
+		return append(b, 0xc0), nil
+	}
+	type entry struct {
+		key   string
+		value reflect.Value
+	}
+	entries := make([]entry, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := keyString(iter.Key())
+		if err != nil {
+			return b, err
+		}
+		entries = append(entries, entry{key, iter.Value()})
+	}
+	slices.SortFunc(entries, func(a, b entry) int { return strings.Compare(a.key, b.key) })
+
+	b = appendMapLen(b, len(entries))
+	var err error
+	for _, e := range entries {
+		b = appendString(b, e.key)
+		if b, err = appendValue(b, e.value); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+// keyString is the string of a map key: a string, the text of a TextMarshaler or a decimal integer
+func keyString(k reflect.Value) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+	if m, ok := k.Interface().(encoding.TextMarshaler); ok {
+		if k.Kind() == reflect.Pointer && k.IsNil() {
+			return "", nil
+		}
+		text, err := m.MarshalText()
+		return string(text), err
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("msgpack: unsupported map key type %s", k.Type())
+}
+
+func appendStruct(b []byte, v reflect.Value) ([]byte, error) {
+	fields := fieldsOf(v.Type())
+	values := make([]reflect.Value, len(fields))
+	n := 0
+	for i, f := range fields {
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok || f.omitEmpty && isEmpty(fv) || f.omitZero && isZero(fv) {
+			continue
+		}
+		values[i] = fv
+		n++
+	}
+
+	b = appendMapLen(b, n)
+	var err error
+	for i, f := range fields {
+		if !values[i].IsValid() {
+			continue
+		}
+		b = appendString(b, f.name)
+		if b, err = appendValue(b, values[i]); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+// fieldByIndex returns the field of the value, it's missing if it's in a nil embedded struct
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// isEmpty reports whether the value is left out by omitempty
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
+}
+
+// isZero reports whether the value is left out by omitzero, by its IsZero method if it has one
+func isZero(v reflect.Value) bool {
+	if v.Type().Implements(isZeroerType) {
+		if v.Kind() == reflect.Pointer && v.IsNil() {
+			return true
+		}
+		return v.Interface().(interface{ IsZero() bool }).IsZero()
+	}
+	return v.IsZero()
+}
+
+// field is an encoded field of a struct, the index leads to it through the embedded structs
+type field struct {
+	name      string
+	index     []int
+	tagged    bool
+	omitEmpty bool
+	omitZero  bool
+}
+
+// fields caches the fields of the structs by their types
+var fields sync.Map
+
+func fieldsOf(t reflect.Type) []field {
+	if cached, ok := fields.Load(t); ok {
+		return cached.([]field)
+	}
+	cached, _ := fields.LoadOrStore(t, dominantFields(collectFields(t, nil, map[reflect.Type]bool{})))
+	return cached.([]field)
+}
+
+// collectFields lists the fields of the struct in their order, the fields of an embedded struct without a name take its place
+func collectFields(t reflect.Type, index []int, visited map[reflect.Type]bool) []field {
+	visited[t] = true
+	defer delete(visited, t)
+
+	var collected []field
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if sf.Anonymous {
+			ft := sf.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if !sf.IsExported() && ft.Kind() != reflect.Struct {
+				continue
+			}
+			if name == "" && ft.Kind() == reflect.Struct {
+				if !visited[ft] {
+					collected = append(collected, collectFields(ft, append(slices.Clone(index), i), visited)...)
+				}
+				continue
+			}
+		} else if !sf.IsExported() {
+			continue
+		}
+
+		f := field{
+			name:   name,
+			index:  append(slices.Clone(index), i),
+			tagged: name != "",
+		}
+		if f.name == "" {
+			f.name = sf.Name
+		}
+		for opt := range strings.SplitSeq(opts, ",") {
+			f.omitEmpty = f.omitEmpty || opt == "omitempty"
+			f.omitZero = f.omitZero || opt == "omitzero"
+		}
+		collected = append(collected, f)
+	}
+	return collected
+}
+
+// dominantFields keeps a single field of a name like encoding/json does: the shallowest one, a tagged one of the same depth,
+// the fields of a name left ambiguous are dropped
+func dominantFields(collected []field) []field {
+	var dominant []field
+	for _, f := range collected {
+		won := true
+		for _, other := range collected {
+			if other.name != f.name || slices.Equal(other.index, f.index) {
+				continue
+			}
+			if len(other.index) < len(f.index) || len(other.index) == len(f.index) && (other.tagged || !f.tagged) {
+				won = false
+				break
+			}
+		}
+		if won {
+			dominant = append(dominant, f)
+		}
+	}
+	return dominant
+}
+
+// Unmarshal decodes the MessagePack data into the value v points to,
+// the fields are matched by their names like encoding/json does and the unknown ones are skipped
+func Unmarshal(data []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("msgpack: Unmarshal of non-pointer %T", v)
+	}
+	d := decoder{data: data}
+	if err := d.value(rv.Elem()); err != nil {
+		return err
+	}
+	if d.off < len(d.data) {
+		return errors.New("msgpack: data after the top-level value")
+	}
+	return nil
+}
+
+var (
+	errShort = errors.New("msgpack: unexpected end of data")
+	errDepth = errors.New("msgpack: exceeded max depth")
+)
+
+const (
+	// maxDepth bounds the nesting of the arrays and the maps like encoding/json does,
+	// every level takes a byte only, so a deep value would exhaust the stack otherwise
+	maxDepth = 10000
+	// maxPrealloc bounds the items allocated ahead of decoding them, a longer array grows as its items are decoded
+	maxPrealloc = 4096
+)
+
+type decoder struct {
+	data  []byte
+	off   int
+	depth int
+}
+
+// enter steps into an array or a map, leave steps out of it
+func (d *decoder) enter() error {
+	d.depth++
+	if d.depth > maxDepth {
+		return errDepth
+	}
+	return nil
+}
+
+func (d *decoder) leave() {
+	d.depth--
+}
+
+// capacity is the number of the n items to allocate ahead, every item is encoded by size bytes at least,
+// so the remaining data can't hold more of them
+func (d *decoder) capacity(n, size int) int {
+	return min(n, (len(d.data)-d.off)/size, maxPrealloc)
+}
+
+// mismatchError reports a value of another type than the Go value it's decoded into
+type mismatchError struct {
+	format byte
+}
+
+func (e mismatchError) Error() string {
+	return fmt.Sprintf("msgpack: unexpected format 0x%02x", e.format)
+}
+
+func (d *decoder) peek() (byte, error) {
+	if d.off >= len(d.data) {
+		return 0, errShort
+	}
+	return d.data[d.off], nil
+}
+
+func (d *decoder) read(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.off < n {
+		return nil, errShort
+	}
+	b := d.data[d.off : d.off+n]
+	d.off += n
+	return b, nil
+}
+
+// length reads a big-endian length of the size in bytes
+func (d *decoder) length(size int) (int, error) {
+	b, err := d.read(size)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, x := range b {
+		n = n<<8 | uint64(x)
+	}
+	if n > uint64(len(d.data)-d.off) {
+		// every item takes a byte at least, a longer length can't be satisfied
+		return 0, errShort
+	}
+	return int(n), nil
+}
+
+func (d *decoder) value(v reflect.Value) error {
+	c, err := d.peek()
+	if err != nil {
+		return err
+	}
+	if c == 0xc0 {
+		d.off++
+		switch v.Kind() {
+		case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Interface:
+			v.SetZero()
+		}
+		return nil
+	}
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.value(v.Elem())
+	}
+
+	t := v.Type()
+	if u, ok := unmarshaler(v, jsonUnmarshalerType); ok && t != timeType {
+		x, err := d.any()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(x)
+		if err != nil {
+			return err
+		}
+		return u.(json.Unmarshaler).UnmarshalJSON(data)
+	}
+	if u, ok := unmarshaler(v, textUnmarshalerType); ok && isStr(c) {
+		s, err := d.string()
+		if err != nil {
+			return err
+		}
+		return u.(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	err = d.kind(v)
+	var mismatch mismatchError
+	if errors.As(err, &mismatch) {
+		return fmt.Errorf("msgpack: can't decode format 0x%02x into %s", mismatch.format, t)
+	}
+	return err
+}
+
+func unmarshaler(v reflect.Value, iface reflect.Type) (any, bool) {
+	if v.CanAddr() && reflect.PointerTo(v.Type()).Implements(iface) {
+		return v.Addr().Interface(), true
+	}
+	return nil, false
+}
+
+// kind decodes the value by its kind
+func (d *decoder) kind(v reflect.Value) error {
+	t := v.Type()
+	switch v.Kind() {
+	case reflect.Interface:
+		if t.NumMethod() != 0 {
+			return fmt.Errorf("msgpack: can't decode into interface %s", t)
+		}
+		x, err := d.any()
+		if err != nil {
+			return err
+		}
+		if x == nil {
+			v.SetZero()
+		} else {
+			v.Set(reflect.ValueOf(x))
+		}
+		return nil
+	case reflect.Bool:
+		c, _ := d.peek()
+		if c != 0xc2 && c != 0xc3 {
+			return mismatchError{c}
+		}
+		d.off++
+		v.SetBool(c == 0xc3)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := d.number()
+		if err != nil {
+			return err
+		}
+		if n.kind == 'f' || n.kind == 'u' && n.u > math.MaxInt64 || v.OverflowInt(n.int()) {
+			return fmt.Errorf("msgpack: number %s overflows %s", n, t)
+		}
+		v.SetInt(n.int())
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := d.number()
+		if err != nil {
+			return err
+		}
+		if n.kind == 'f' || n.kind == 'i' || v.OverflowUint(n.u) {
+			return fmt.Errorf("msgpack: number %s overflows %s", n, t)
+		}
+		v.SetUint(n.u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		n, err := d.number()
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n.float())
+		return nil
+	case reflect.String:
+		if t == numberType {
+			n, err := d.number()
+			if err != nil {
+				return err
+			}
+			v.SetString(n.String())
+			return nil
+		}
+		s, err := d.string()
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+		return nil
+	case reflect.Slice:
+		if c, _ := d.peek(); t.Elem().Kind() == reflect.Uint8 && (isBin(c) || isStr(c)) {
+			b, err := d.bytes()
+			if err != nil {
+				return err
+			}
+			v.SetBytes(bytes.Clone(b))
+			return nil
+		}
+		n, err := d.arrayLen()
+		if err != nil {
+			return err
+		}
+		if err := d.enter(); err != nil {
+			return err
+		}
+		defer d.leave()
+		if v.IsNil() || v.Cap() < n {
+			v.Set(reflect.MakeSlice(t, 0, d.capacity(n, 1)))
+		}
+		v.SetLen(0)
+		for i := range n {
+			if i == v.Cap() {
+				v.Grow(1)
+			}
+			v.SetLen(i + 1)
+			if err := d.value(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Array:
+		n, err := d.arrayLen()
+		if err != nil {
+			return err
+		}
+		if err := d.enter(); err != nil {
+			return err
+		}
+		defer d.leave()
+		for i := range n {
+			if i >= v.Len() {
+				if err := d.skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.value(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		for i := n; i < v.Len(); i++ {
+			v.Index(i).SetZero()
+		}
+		return nil
+	case reflect.Map:
+		return d.mapValue(v)
+	case reflect.Struct:
+		return d.structValue(v)
+	}
+	return fmt.Errorf("msgpack: unsupported type %s", t)
+}
+
+func (d *decoder) mapValue(v reflect.Value) error {
+	t := v.Type()
+	n, err := d.mapLen()
+	if err != nil {
+		return err
+	}
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+	if v.IsNil() {
+		// an entry is a key and a value, a byte each at least
+		v.Set(reflect.MakeMapWithSize(t, d.capacity(n, 2)))
+	}
+	for range n {
+		s, err := d.key()
+		if err != nil {
+			return err
+		}
+		key, err := keyValue(t.Key(), s)
+		if err != nil {
+			return err
+		}
+		elem := reflect.New(t.Elem()).Elem()
+		if err := d.value(elem); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, elem)
+	}
+	return nil
+}
+
+// keyValue parses a map key of the type from its string, see keyString
+func keyValue(t reflect.Type, s string) (reflect.Value, error) {
+	if t.Kind() == reflect.String {
+		return reflect.ValueOf(s).Convert(t), nil
+	}
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		key := reflect.New(t)
+		err := key.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		return key.Elem(), err
+	}
+	key := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || key.OverflowInt(n) {
+			return key, fmt.Errorf("msgpack: invalid map key %q of %s", s, t)
+		}
+		key.SetInt(n)
+		return key, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || key.OverflowUint(n) {
+			return key, fmt.Errorf("msgpack: invalid map key %q of %s", s, t)
+		}
+		key.SetUint(n)
+		return key, nil
+	}
+	return key, fmt.Errorf("msgpack: unsupported map key type %s", t)
+}
+
+func (d *decoder) structValue(v reflect.Value) error {
+	n, err := d.mapLen()
+	if err != nil {
+		return err
+	}
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+	fields := fieldsOf(v.Type())
+	for range n {
+		name, err := d.key()
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(fields, func(f field) bool { return f.name == name })
+		if i < 0 {
+			i = slices.IndexFunc(fields, func(f field) bool { return strings.EqualFold(f.name, name) })
+		}
+		fv, ok := reflect.Value{}, false
+		if i >= 0 {
+			fv, ok = settableField(v, fields[i].index)
+		}
+		if !ok {
+			if err := d.skip(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := d.value(fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// settableField returns the field of the value allocating the nil embedded structs on the way,
+// it's missing behind an unexported embedded pointer
+func settableField(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, v.CanSet()
+}
+
+func isStr(c byte) bool {
+	return c >= 0xa0 && c <= 0xbf || c >= 0xd9 && c <= 0xdb
+}
+
+func isBin(c byte) bool {
+	return c >= 0xc4 && c <= 0xc6
+}
+
+// bytes reads a string or a bin, the result refers to the data
+func (d *decoder) bytes() ([]byte, error) {
+	c, err := d.peek()
+	if err != nil {
+		return nil, err
+	}
+	var n int
+	switch {
+	case c >= 0xa0 && c <= 0xbf:
+		d.off++
+		n = int(c & 0x1f)
+	case c == 0xd9 || c == 0xc4:
+		d.off++
+		n, err = d.length(1)
+	case c == 0xda || c == 0xc5:
+		d.off++
+		n, err = d.length(2)
+	case c == 0xdb || c == 0xc6:
+		d.off++
+		n, err = d.length(4)
+	default:
+		return nil, mismatchError{c}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d.read(n)
+}
+
+func (d *decoder) string() (string, error) {
+	b, err := d.bytes()
+	return string(b), err
+}
+
+// key reads a map key, a string or an integer
+func (d *decoder) key() (string, error) {
+	c, err := d.peek()
+	if err != nil {
+		return "", err
+	}
+	if isStr(c) || isBin(c) {
+		return d.string()
+	}
+	n, err := d.number()
+	if err != nil {
+		return "", err
+	}
+	return n.String(), nil
+}
+
+func (d *decoder) arrayLen() (int, error) {
+	c, err := d.peek()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case c >= 0x90 && c <= 0x9f:
+		d.off++
+		return int(c & 0x0f), nil
+	case c == 0xdc:
+		d.off++
+		return d.length(2)
+	case c == 0xdd:
+		d.off++
+		return d.length(4)
+	}
+	return 0, mismatchError{c}
+}
+
+func (d *decoder) mapLen() (int, error) {
+	c, err := d.peek()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case c >= 0x80 && c <= 0x8f:
+		d.off++
+		return int(c & 0x0f), nil
+	case c == 0xde:
+		d.off++
+		return d.length(2)
+	case c == 0xdf:
+		d.off++
+		return d.length(4)
+	}
+	return 0, mismatchError{c}
+}
+
+// number is a decoded integer or float, kind is 'i' for a negative integer, 'u' for a positive one and 'f' for a float
+type number struct {
+	kind byte
+	i    int64
+	u    uint64
+	f    float64
+}
+
+func (n number) int() int64 {
+	if n.kind == 'u' {
+		return int64(n.u)
+	}
+	return n.i
+}
+
+func (n number) float() float64 {
+	switch n.kind {
+	case 'u':
+		return float64(n.u)
+	case 'i':
+		return float64(n.i)
+	}
+	return n.f
+}
+
+func (n number) String() string {
+	switch n.kind {
+	case 'u':
+		return strconv.FormatUint(n.u, 10)
+	case 'i':
+		return strconv.FormatInt(n.i, 10)
+	}
+	return strconv.FormatFloat(n.f, 'g', -1, 64)
+}
+
+func (d *decoder) number() (number, error) {
+	c, err := d.peek()
+	if err != nil {
+		return number{}, err
+	}
+	switch {
+	case c <= 0x7f:
+		d.off++
+		return number{kind: 'u', u: uint64(c)}, nil
+	case c >= 0xe0:
+		d.off++
+		return number{kind: 'i', i: int64(int8(c))}, nil
+	case c >= 0xcc && c <= 0xcf:
+		d.off++
+		b, err := d.read(1 << (c - 0xcc))
+		if err != nil {
+			return number{}, err
+		}
+		return number{kind: 'u', u: bigEndian(b)}, nil
+	case c >= 0xd0 && c <= 0xd3:
+		d.off++
+		b, err := d.read(1 << (c - 0xd0))
+		if err != nil {
+			return number{}, err
+		}
+		shift := 64 - 8*len(b)
+		i := int64(bigEndian(b)<<shift) >> shift
+		if i >= 0 {
+			return number{kind: 'u', u: uint64(i)}, nil
+		}
+		return number{kind: 'i', i: i}, nil
+	case c == 0xca:
+		d.off++
+		b, err := d.read(4)
+		if err != nil {
+			return number{}, err
+		}
+		return number{kind: 'f', f: float64(math.Float32frombits(binary.BigEndian.Uint32(b)))}, nil
+	case c == 0xcb:
+		d.off++
+		b, err := d.read(8)
+		if err != nil {
+			return number{}, err
+		}
+		return number{kind: 'f', f: math.Float64frombits(binary.BigEndian.Uint64(b))}, nil
+	}
+	return number{}, mismatchError{c}
+}
+
+func bigEndian(b []byte) uint64 {
+	var n uint64
+	for _, x := range b {
+		n = n<<8 | uint64(x)
+	}
+	return n
+}
+
+// extLen reads the header of an extension returning the length of its data and its type
+func (d *decoder) extLen() (int, int8, error) {
+	c, err := d.peek()
+	if err != nil {
+		return 0, 0, err
+	}
+	d.off++
+	var n int
+	switch {
+	case c >= 0xd4 && c <= 0xd8:
+		n = 1 << (c - 0xd4)
+	case c == 0xc7:
+		n, err = d.length(1)
+	case c == 0xc8:
+		n, err = d.length(2)
+	case c == 0xc9:
+		n, err = d.length(4)
+	default:
+		d.off--
+		return 0, 0, mismatchError{c}
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	typ, err := d.read(1)
+	if err != nil {
+		return 0, 0, err
+	}
+	return n, int8(typ[0]), nil
+}
+
+// any decodes a value of any type the way encoding/json decodes into an interface:
+// a map is a map[string]any and an array is a []any, an integer is an int64 or a uint64 beyond it,
+// a bin is a []byte and the timestamp extension is a time.Time
+func (d *decoder) any() (any, error) {
+	c, err := d.peek()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c == 0xc0:
+		d.off++
+		return nil, nil
+	case c == 0xc2 || c == 0xc3:
+		d.off++
+		return c == 0xc3, nil
+	case isStr(c):
+		return d.string()
+	case isBin(c):
+		b, err := d.bytes()
+		return bytes.Clone(b), err
+	case c >= 0x90 && c <= 0x9f || c == 0xdc || c == 0xdd:
+		n, err := d.arrayLen()
+		if err != nil {
+			return nil, err
+		}
+		if err := d.enter(); err != nil {
+			return nil, err
+		}
+		defer d.leave()
+		items := make([]any, 0, d.capacity(n, 1))
+		for range n {
+			item, err := d.any()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case c >= 0x80 && c <= 0x8f || c == 0xde || c == 0xdf:
+		n, err := d.mapLen()
+		if err != nil {
+			return nil, err
+		}
+		if err := d.enter(); err != nil {
+			return nil, err
+		}
+		defer d.leave()
+		m := make(map[string]any, d.capacity(n, 2))
+		for range n {
+			key, err := d.key()
+			if err != nil {
+				return nil, err
+			}
+			if m[key], err = d.any(); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case c >= 0xd4 && c <= 0xd8 || c >= 0xc7 && c <= 0xc9:
+		n, typ, err := d.extLen()
+		if err != nil {
+			return nil, err
+		}
+		data, err := d.read(n)
+		if err != nil {
+			return nil, err
+		}
+		if typ == -1 {
+			return timestamp(data)
+		}
+		return bytes.Clone(data), nil
+	}
+	n, err := d.number()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case n.kind == 'f':
+		return n.f, nil
+	case n.kind == 'u' && n.u > math.MaxInt64:
+		return n.u, nil
+	}
+	return n.int(), nil
+}
+
+// timestamp decodes the data of the timestamp extension
+func timestamp(data []byte) (time.Time, error) {
+	switch len(data) {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(data)), 0).UTC(), nil
+	case 8:
+		n := binary.BigEndian.Uint64(data)
+		return time.Unix(int64(n&(1<<34-1)), int64(n>>34)).UTC(), nil
+	case 12:
+		return time.Unix(int64(binary.BigEndian.Uint64(data[4:])), int64(binary.BigEndian.Uint32(data))).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("msgpack: invalid timestamp of %d bytes", len(data))
+}
+
+func (d *decoder) skip() error {
+	_, err := d.any()
+	return err
+}
@@ -0,0 +1,195 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Base struct {
+	ID      int    `json:"id"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type Status string
+
+type Point struct {
+	X, Y int
+}
+
+func (p Point) MarshalText() ([]byte, error) {
+	return []byte(strings.Repeat("x", p.X) + strings.Repeat("y", p.Y)), nil
+}
+
+func (p *Point) UnmarshalText(text []byte) error {
+	p.X, p.Y = strings.Count(string(text), "x"), strings.Count(string(text), "y")
+	return nil
+}
+
+type Record struct {
+	Base
+	*Extra
+	Name     string            `json:"name"`
+	Status   Status            `json:"status"`
+	Score    float64           `json:"score"`
+	Small    int8              `json:"small"`
+	Big      uint64            `json:"big"`
+	Negative int64             `json:"negative"`
+	Tags     []string          `json:"tags"`
+	Counts   map[int]uint16    `json:"counts"`
+	Points   map[Point]bool    `json:"points"`
+	Parent   *Record           `json:"parent"`
+	Created  time.Time         `json:"created"`
+	Raw      json.RawMessage   `json:"raw"`
+	Data     []byte            `json:"data"`
+	Any      any               `json:"any"`
+	Nested   map[string][]Base `json:"nested"`
+	Skipped  string            `json:"-"`
+	Zero     time.Time         `json:"zero,omitzero"`
+	hidden   string
+}
+
+type Extra struct {
+	Note string `json:"note"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := Record{
+		Base:     Base{ID: 7},
+		Extra:    &Extra{Note: "embedded"},
+		Name:     strings.Repeat("long name ", 40),
+		Status:   "active",
+		Score:    1.5,
+		Small:    -100,
+		Big:      1 << 63,
+		Negative: -1 << 40,
+		Tags:     []string{"a", "b"},
+		Counts:   map[int]uint16{1: 2, -3: 65535},
+		Points:   map[Point]bool{{X: 1, Y: 2}: true},
+		Parent:   &Record{Name: "parent", Data: []byte{}},
+		Created:  time.Date(2024, 5, 6, 7, 8, 9, 10, time.UTC),
+		Raw:      json.RawMessage(`{"k":[1,2.5,"s",null,true]}`),
+		Data:     []byte{0, 1, 2, 255},
+		Any:      map[string]any{"n": int64(-5), "list": []any{"x", 3.25}},
+		Nested:   map[string][]Base{"b": {{ID: 1, Comment: "c"}}},
+		Skipped:  "skipped",
+		hidden:   "hidden",
+	}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out Record
+	if err := Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	in.Skipped, in.hidden = "", ""
+	// the raw JSON is decoded back from its value
+	if !json.Valid(out.Raw) || !bytes.Contains(out.Raw, []byte(`"s"`)) {
+		t.Errorf("unexpected raw JSON %s", out.Raw)
+	}
+	in.Raw, out.Raw = nil, nil
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("expected\n%+v\ngot\n%+v", in, out)
+	}
+}
+
+func TestShapeOfJSON(t *testing.T) {
+	in := Record{Base: Base{ID: 1}, Name: "n", Created: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Counts: map[int]uint16{2: 3}}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromMsgPack map[string]any
+	if err := Unmarshal(data, &fromMsgPack); err != nil {
+		t.Fatal(err)
+	}
+	jsonData, _ := json.Marshal(in)
+	var fromJSON map[string]any
+	json.Unmarshal(jsonData, &fromJSON)
+
+	// the generic numbers differ, their JSON doesn't
+	a, _ := json.Marshal(fromMsgPack)
+	b, _ := json.Marshal(fromJSON)
+	if string(a) != string(b) {
+		t.Errorf("expected the shape of JSON\n%s\ngot\n%s", b, a)
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		value any
+		want  []byte
+	}{
+		{map[string]int{"a": 1}, []byte{0x81, 0xa1, 'a', 0x01}},
+		{[]int{-1, 200, -200}, []byte{0x93, 0xff, 0xcc, 0xc8, 0xd1, 0xff, 0x38}},
+		{nil, []byte{0xc0}},
+		{true, []byte{0xc3}},
+		{1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{[]byte("hi"), []byte{0xc4, 0x02, 'h', 'i'}},
+		{Base{ID: 1}, []byte{0x81, 0xa2, 'i', 'd', 0x01}},
+	} {
+		got, err := Marshal(tc.value)
+		if err != nil || !bytes.Equal(got, tc.want) {
+			t.Errorf("%v: expected % x, got % x, %v", tc.value, tc.want, got, err)
+		}
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	valid, _ := Marshal(Base{ID: 300, Comment: "comment"})
+	var base Base
+	for name, data := range map[string][]byte{
+		"truncated":   valid[:len(valid)-2],
+		"trailing":    append(bytes.Clone(valid), 0x01),
+		"wrong type":  {0x81, 0xa2, 'i', 'd', 0xa1, 'x'},
+		"overflow":    {0x81, 0xa2, 'i', 'd', 0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"huge length": {0xdd, 0xff, 0xff, 0xff, 0xff},
+	} {
+		if err := Unmarshal(data, &base); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	var small int8
+	if err := Unmarshal([]byte{0xcc, 0xc8}, &small); err == nil {
+		t.Error("expected 200 to overflow int8")
+	}
+}
+
+func TestUnmarshalLimits(t *testing.T) {
+	// {"x": [[[...]]]} nested deeper than maxDepth, a byte per level
+	deep := append([]byte{0x81, 0xa1, 'x'}, bytes.Repeat([]byte{0x91}, 5<<20)...)
+	deep = append(deep, 0xc0)
+	var named struct{ Name string }
+	if err := Unmarshal(deep, &named); err != errDepth {
+		t.Errorf("expected the skipped value too deep, got %v", err)
+	}
+	var value any
+	if err := Unmarshal(deep, &value); err != errDepth {
+		t.Errorf("expected the value too deep, got %v", err)
+	}
+	var nested map[string][][][]int
+	if err := Unmarshal(deep, &nested); err == nil {
+		t.Error("expected the typed value to fail")
+	}
+
+	// the items beyond the preallocation are appended as they're decoded
+	items := make([]int, 3*maxPrealloc)
+	for i := range items {
+		items[i] = i
+	}
+	data, _ := Marshal(items)
+	var decoded []int
+	if err := Unmarshal(data, &decoded); err != nil || !reflect.DeepEqual(decoded, items) {
+		t.Errorf("expected %d items decoded, got %d: %v", len(items), len(decoded), err)
+	}
+	// a large declared length of large items isn't allocated ahead
+	var large [][1 << 10]byte
+	if err := Unmarshal(append([]byte{0xdd, 0x00, 0x01, 0x00, 0x00}, bytes.Repeat([]byte{0x90}, 1<<16)...), &large); err != nil || len(large) != 1<<16 {
+		t.Errorf("expected the items decoded, got %d: %v", len(large), err)
+	}
+}
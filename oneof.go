@@ -0,0 +1,212 @@
+package vel
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// OneOf is an output whose shape varies by kind, it holds a value of either of the structs.
+// The structs tell each other apart by a string field tagged with their discriminator values:
+//
+//	type Card struct {
+//		Kind  string `json:"kind" discriminator:"card"`
+//		Last4  string `json:"last4"`
+//	}
+//
+//	type BankAccount struct {
+//		Kind string `json:"kind" discriminator:"bank"`
+//		IBAN string `json:"iban"`
+//	}
+//
+//	vel.RegisterGet(router, "paymentMethod", func(ctx context.Context, req PaymentMethodRequest) (vel.OneOf[Card, BankAccount], *vel.Error) {
+//		return vel.OneOfA[Card, BankAccount](Card{Last4: "4242"}), nil
+//	})
+//
+// The value is encoded as the struct with the discriminator field set to its value, a OneOf is decoded by the field.
+// The spec describes it as oneOf the structs with the discriminator, the TS client as their discriminated union.
+type OneOf[A, B any] struct {
+	value any
+}
+
+// OneOfA holds the value of the first struct
+func OneOfA[A, B any](a A) OneOf[A, B] {
+	return OneOf[A, B]{value: a}
+}
+
+// OneOfB holds the value of the second struct
+func OneOfB[A, B any](b B) OneOf[A, B] {
+	return OneOf[A, B]{value: b}
+}
+
+// A returns the value of the first struct and whether it's held
+func (o OneOf[A, B]) A() (A, bool) {
+	a, ok := o.value.(A)
+	return a, ok
+}
+
+// B returns the value of the second struct and whether it's held
+func (o OneOf[A, B]) B() (B, bool) {
+	b, ok := o.value.(B)
+	return b, ok
+}
+
+// Value returns the held value, nil if the OneOf is empty
+func (o OneOf[A, B]) Value() any {
+	return o.value
+}
+
+func (o OneOf[A, B]) oneOfTypes() []reflect.Type {
+	return []reflect.Type{reflect.TypeFor[A](), reflect.TypeFor[B]()}
+}
+
+func (o OneOf[A, B]) MarshalJSON() ([]byte, error) {
+	return marshalOneOf(reflect.TypeFor[OneOf[A, B]](), o.value)
+}
+
+func (o *OneOf[A, B]) UnmarshalJSON(data []byte) error {
+	value, err := unmarshalOneOf(reflect.TypeFor[OneOf[A, B]](), data)
+	if err != nil {
+		return err
+	}
+	o.value = value
+	return nil
+}
+
+// Variant is a struct of a OneOf along with the value of its discriminator
+type Variant struct {
+	Type  reflect.Type
+	Value string
+	// Field is the discriminator field of the struct
+	Field reflect.StructField
+}
+
+// Union describes a OneOf type: its structs and the JSON name of the discriminator field they share
+type Union struct {
+	Discriminator string
+	Variants      []Variant
+}
+
+var (
+	oneOfType  = reflect.TypeFor[interface{ oneOfTypes() []reflect.Type }]()
+	unionCache sync.Map // reflect.Type -> unionEntry
+)
+
+type unionEntry struct {
+	union Union
+	err   error
+}
+
+// IsOneOf reports whether the type is a OneOf
+func IsOneOf(t reflect.Type) bool {
+	return t != nil && t.Implements(oneOfType)
+}
+
+// OneOfUnion describes the OneOf type, it fails if a struct has no discriminator field tagged,
+// the structs name the field differently or share a discriminator value
+func OneOfUnion(t reflect.Type) (Union, error) {
+	if !IsOneOf(t) {
+		return Union{}, fmt.Errorf("%s isn't a OneOf", t)
+	}
+	if cached, ok := unionCache.Load(t); ok {
+		entry := cached.(unionEntry)
+		return entry.union, entry.err
+	}
+	union, err := describeUnion(reflect.Zero(t).Interface().(interface{ oneOfTypes() []reflect.Type }).oneOfTypes())
+	if err != nil {
+		err = fmt.Errorf("%s: %w", t.String(), err)
+	}
+	unionCache.Store(t, unionEntry{union: union, err: err})
+	return union, err
+}
+
+func describeUnion(types []reflect.Type) (Union, error) {
+	var union Union
+	values := make(map[string]bool, len(types))
+	for _, t := range types {
+		if t.Kind() != reflect.Struct {
+			return Union{}, fmt.Errorf("%s isn't a struct", t)
+		}
+		variant, name, ok := discriminatorOf(t)
+		if !ok {
+			return Union{}, fmt.Errorf("%s has no string field tagged by discriminator", t)
+		}
+		if union.Discriminator != "" && union.Discriminator != name {
+			return Union{}, fmt.Errorf("%s names its discriminator %s, not %s", t, name, union.Discriminator)
+		}
+		if values[variant.Value] {
+			return Union{}, fmt.Errorf("%s repeats the discriminator value %s", t, variant.Value)
+		}
+		union.Discriminator, values[variant.Value] = name, true
+		union.Variants = append(union.Variants, variant)
+	}
+	return union, nil
+}
+
+// discriminatorOf finds the string field of the struct tagged by discriminator, it returns its JSON name
+func discriminatorOf(t reflect.Type) (Variant, string, bool) {
+	for _, field := range reflect.VisibleFields(t) {
+		value, ok := field.Tag.Lookup("discriminator")
+		if !ok || !field.IsExported() || field.Type.Kind() != reflect.String {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		return Variant{Type: t, Value: value, Field: field}, cmp.Or(name, field.Name), true
+	}
+	return Variant{}, "", false
+}
+
+// marshalOneOf encodes the held value with its discriminator field set, so a handler doesn't have to set it
+func marshalOneOf(t reflect.Type, value any) ([]byte, error) {
+	if value == nil {
+		return []byte("null"), nil
+	}
+	union, err := OneOfUnion(t)
+	if err != nil {
+		return nil, err
+	}
+	for _, variant := range union.Variants {
+		if reflect.TypeOf(value) != variant.Type {
+			continue
+		}
+		v := reflect.New(variant.Type).Elem()
+		v.Set(reflect.ValueOf(value))
+		v.FieldByIndex(variant.Field.Index).SetString(variant.Value)
+		return json.Marshal(v.Addr().Interface())
+	}
+	return nil, fmt.Errorf("%T isn't a variant of %s", value, t.String())
+}
+
+// unmarshalOneOf decodes the struct named by the discriminator field of the data
+func unmarshalOneOf(t reflect.Type, data []byte) (any, error) {
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return nil, nil
+	}
+	union, err := OneOfUnion(t)
+	if err != nil {
+		return nil, err
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+	var value string
+	if raw, ok := object[union.Discriminator]; !ok || json.Unmarshal(raw, &value) != nil {
+		return nil, errors.New("the " + union.Discriminator + " discriminator of " + t.String() + " is missing")
+	}
+	for _, variant := range union.Variants {
+		if variant.Value == value {
+			v := reflect.New(variant.Type)
+			if err := json.Unmarshal(data, v.Interface()); err != nil {
+				return nil, err
+			}
+			return v.Elem().Interface(), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown %s %q of %s", union.Discriminator, value, t.String())
+}
@@ -1,5 +1,13 @@
 package vel
 
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
 type PrimitiveType string
 
 const (
@@ -18,6 +26,101 @@ type Spec struct {
 	RequestHeaders  KeyValueSpec
 	ResponseHeaders KeyValueSpec
 	Errors          map[int][]ErrorSpec
+	Redirects       []RedirectSpec
+	Cache           CachePolicy
+	// Audiences lists who the route is published to, a route without audiences is internal only
+	Audiences []Audience
+	Fallback  Fallback
+	// Examples are the requests served by RegisterExamplesEndpoint
+	Examples []Example
+	// Stream declares the route streams items of its output type, see WriteItem
+	Stream StreamFormat
+	// Permissions are required from the principal calling the route, see Authorize
+	Permissions []string
+	// RequestContentType is the content type of a raw input, OctetStream if empty, see IsRawBody,
+	// FormURLEncoded accepts the form bodies
+	RequestContentType string
+	// ContentType is the content type of a raw output, OctetStream if empty
+	ContentType string
+	// EnforceRequestHeaders checks RequestHeaders against its validation before the request is decoded,
+	// a violation is answered by 400 InvalidRequestHeaderCode, otherwise the header is documented only
+	EnforceRequestHeaders bool
+	// MaxBodyBytes and DecodeTimeout replace Opts.MaxBodyBytes and Opts.DecodeTimeout for the route unless zero,
+	// a negative one lifts the limit, e.g. for a large upload
+	MaxBodyBytes  int64
+	DecodeTimeout time.Duration
+}
+
+// Audience of a published API, generators emit a spec and clients per audience
+type Audience string
+
+const (
+	AudiencePublic   Audience = "public"
+	AudiencePartner  Audience = "partner"
+	AudienceInternal Audience = "internal"
+)
+
+// VisibleTo reports whether the route is published to the audience,
+// the internal audience sees every route
+func (s Spec) VisibleTo(a Audience) bool {
+	return a == AudienceInternal || slices.Contains(s.Audiences, a)
+}
+
+// Enum is implemented by named string types with a fixed set of values, e.g. type Status string,
+// the generated TS clients declare such a type as a union of the values, the Go clients declare a constant of every value
+// and OpenAPI lists them as its enum
+type Enum interface {
+	EnumValues() []string
+}
+
+// CachePolicy declares cacheability of a successful response,
+// it is emitted as Cache-Control header and documented in OpenAPI.
+// Zero value doesn't emit any header.
+type CachePolicy struct {
+	MaxAge time.Duration
+	// Public allows shared caches to store the response, otherwise it's private
+	Public  bool
+	NoStore bool
+	// IgnoreQuery lists query params not affecting the response, e.g. a trace id or a url signature,
+	// they're excluded from the cache key
+	IgnoreQuery []string
+	// VaryHeaders lists request headers affecting the response, e.g. a tenant header,
+	// they're included in the cache key and sent in the Vary header
+	VaryHeaders []string
+}
+
+// shared reports whether a server side cache may serve the response of the request to other callers,
+// e.g. ResponseCache or a cached Fallback: it's a GET or a HEAD request without the credentials of a caller,
+// an Authorization header or a cookie, unless the policy varies by them, so they're part of the key
+func (p CachePolicy) shared(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	for _, name := range []string{"Authorization", "Cookie"} {
+		if r.Header.Get(name) != "" && !slices.ContainsFunc(p.VaryHeaders, func(vary string) bool { return strings.EqualFold(vary, name) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// HeaderValue returns Cache-Control header value of the policy
+func (p CachePolicy) HeaderValue() string {
+	if p.NoStore {
+		return "no-store"
+	}
+	if p.MaxAge <= 0 {
+		return ""
+	}
+
+	directives := make([]string, 0, 2)
+	if p.Public {
+		directives = append(directives, "public")
+	} else {
+		directives = append(directives, "private")
+	}
+	directives = append(directives, "max-age="+strconv.Itoa(int(p.MaxAge/time.Second)))
+	return strings.Join(directives, ", ")
 }
 
 type ErrorSpec struct {
@@ -34,6 +137,15 @@ type KeyValueSpec struct {
 	Validation   Validation
 }
 
+// SecurityScheme documents how a middleware authenticates the requests of a route by a header,
+// it's published in the security section of the OpenAPI spec, see Signature
+type SecurityScheme struct {
+	// Name identifies the scheme among the schemes of the API
+	Name        string
+	Header      string
+	Description string
+}
+
 type Validation struct {
 	Required bool
 	MinLen   int
@@ -42,3 +154,27 @@ type Validation struct {
 	MaxValue uint
 	Enum     []string
 }
+
+// Key identifies the response of the request in a cache:
+// the path with the sorted query params except the ignored ones, the values of the vary headers
+// and MsgPack or Protobuf if the response is negotiated as MessagePack or binary protobuf. Generated clients compute their cache keys the same way.
+func (p CachePolicy) Key(r *http.Request) string {
+	q := r.URL.Query()
+	for _, name := range p.IgnoreQuery {
+		q.Del(name)
+	}
+	key := r.URL.Path
+	if len(q) > 0 {
+		key += "?" + q.Encode()
+	}
+	for _, name := range p.VaryHeaders {
+		key += "\n" + http.CanonicalHeaderKey(name) + ": " + r.Header.Get(name)
+	}
+	// a MessagePack or a protobuf response is kept apart from the JSON one
+	if acceptsMsgPack(r) {
+		key += "\nAccept: " + MsgPack
+	} else if acceptsProtobuf(r) {
+		key += "\nAccept: " + Protobuf
+	}
+	return key
+}
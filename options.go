@@ -0,0 +1,56 @@
+package vel
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// allowedMethods collects the methods registered for every path,
+// it's read on every OPTIONS request, so routes registered later are reflected in the Allow header
+type allowedMethods struct {
+	mu      sync.RWMutex
+	methods map[string][]string
+}
+
+func (a *allowedMethods) add(path, method string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.methods == nil {
+		a.methods = make(map[string][]string)
+	}
+	if !slices.Contains(a.methods[path], method) {
+		a.methods[path] = append(a.methods[path], method)
+	}
+}
+
+// header returns the Allow header value of the path,
+// HEAD is allowed along with GET since the mux serves it by GET handlers
+func (a *allowedMethods) header(path string) string {
+	a.mu.RLock()
+	methods := append([]string{http.MethodOptions}, a.methods[path]...)
+	a.mu.RUnlock()
+
+	if slices.Contains(methods, http.MethodGet) && !slices.Contains(methods, http.MethodHead) {
+		methods = append(methods, http.MethodHead)
+	}
+	slices.Sort(methods)
+	return strings.Join(methods, ", ")
+}
+
+// registerOptions serves OPTIONS of the path with the Allow header of all the methods registered on it,
+// the middlewares of the route registering it first wrap the handler, e.g. to answer CORS preflight requests
+func (r *Router) registerOptions(path string, middlewares []Middleware) {
+	allowed := &r.shared.allowed
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Allow", allowed.header(path))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	for _, m := range slices.Concat(middlewares, r.middlewares) {
+		handler = m(handler)
+	}
+
+	r.shared.optionsRouters[path] = r
+	r.handle(http.MethodOptions+" "+path, handler, nil, "OPTIONS of "+callSite())
+}
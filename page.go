@@ -0,0 +1,51 @@
+package vel
+
+import "reflect"
+
+// Cursor is embedded in the input of a paginated route, it declares the standard query params:
+//
+//	type ListUsersRequest struct {
+//		vel.Cursor
+//		Team string `json:"team" schema:"team"`
+//	}
+//
+// The route returning a Page is recognized by the generators, the Go clients iterate over its pages.
+type Cursor struct {
+	// Limit is the page size asked by the client, see PageSize
+	Limit int `json:"limit,omitempty" schema:"limit"`
+	// After is the NextCursor of the previous page sent as the cursor param, empty for the first page
+	After string `json:"cursor,omitempty" schema:"cursor"`
+}
+
+// PageSize returns the limit asked by the client bounded by the max, def if the client didn't ask
+func (c Cursor) PageSize(def, max int) int {
+	if c.Limit <= 0 {
+		return def
+	}
+	return min(c.Limit, max)
+}
+
+func (c Cursor) pageCursor() string {
+	return c.After
+}
+
+// Page is the output of a paginated route, an empty NextCursor ends the pages
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+func (p Page[T]) nextPageCursor() string {
+	return p.NextCursor
+}
+
+var (
+	cursorType = reflect.TypeFor[interface{ pageCursor() string }]()
+	pageType   = reflect.TypeFor[interface{ nextPageCursor() string }]()
+)
+
+// Paginated reports whether a route of the input and output types is paginated: the input embeds Cursor
+// and the output is a Page
+func Paginated(input, output reflect.Type) bool {
+	return input != nil && output != nil && input.Implements(cursorType) && output.Implements(pageType)
+}
@@ -0,0 +1,82 @@
+package vel
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/dennypenta/vel/msgpack"
+)
+
+// maxPooledBuffer bounds the buffers returned to the pool, a rare large body doesn't keep its memory
+const maxPooledBuffer = 64 << 10
+
+// buffers holds the buffers the request bodies are read to and the responses are encoded to
+var buffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+func getBuffer() *bytes.Buffer {
+	buf := buffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		buffers.Put(buf)
+	}
+}
+
+// JSONAppender is implemented by the types with the JSON methods generated by gen.GenerateJSON,
+// a handler appends such a response to its buffer without the reflection of encoding/json.
+// A request type implementing it along with json.Unmarshaler is decoded by its UnmarshalJSON directly,
+// so the method must reject malformed JSON itself as the generated one does.
+type JSONAppender interface {
+	AppendJSON(b []byte) ([]byte, error)
+}
+
+// decodeBody decodes the JSON body by a json.Decoder, the data after the JSON value isn't read.
+// The read part is kept in a pooled buffer to explain a failed decoding by the malformed UUIDs, see uuidBodyError.
+// A MessagePack body is read whole and decoded as such, see MsgPack.
+func decodeBody(r *http.Request, v any) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if isMsgPack(r) {
+		if !msgPackAccepted(r) {
+			return errMsgPackRejected
+		}
+		if _, err := buf.ReadFrom(r.Body); err != nil {
+			return err
+		}
+		return msgpack.Unmarshal(buf.Bytes(), v)
+	}
+	decoder := json.NewDecoder(io.TeeReader(r.Body, buf))
+	if codec, ok := v.(interface {
+		JSONAppender
+		json.Unmarshaler
+	}); ok {
+		// an empty body is left to the codec
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil && err != io.EOF {
+			return err
+		}
+		return uuidBodyError(v, raw, codec.UnmarshalJSON(raw))
+	}
+	err := decoder.Decode(v)
+	return uuidBodyError(v, buf.Bytes(), err)
+}
+
+// encodeBody encodes the value to the buffer followed by a newline the way a json.Encoder does
+func encodeBody(buf *bytes.Buffer, v any) error {
+	appender, ok := v.(JSONAppender)
+	if !ok {
+		return json.NewEncoder(buf).Encode(v)
+	}
+	b, err := appender.AppendJSON(buf.AvailableBuffer())
+	if err != nil {
+		return err
+	}
+	buf.Write(append(b, '\n'))
+	return nil
+}
@@ -0,0 +1,123 @@
+package vel
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+// Protobuf is the content type of the binary bodies of the handlers taking or returning protobuf messages,
+// the pointers to the structs generated by protoc-gen-go. The velproto package teaches vel the messages:
+//
+//	import _ "github.com/dennypenta/vel/velproto"
+//
+//	vel.RegisterPost(router, "createUser", func(ctx context.Context, req *pb.CreateUserRequest) (*pb.User, *vel.Error) {
+//		...
+//	})
+//
+// A message input is decoded from protobuf if the request body is of the type and from protojson otherwise,
+// a message output is encoded to protobuf if the request accepts it at least as much as JSON and to protojson otherwise.
+// The errors are encoded by the ErrorEncoder as they are. The spec and the clients describe a message by its protojson form.
+const Protobuf = "application/x-protobuf"
+
+// ProtoCodec encodes and decodes the protobuf messages, the velproto package registers it by RegisterProtoCodec,
+// so the core package doesn't depend on protobuf. The messages are the pointers to the generated structs.
+type ProtoCodec interface {
+	// IsMessage reports whether the type is a protobuf message
+	IsMessage(t reflect.Type) bool
+	// Unmarshal decodes the data to the message, from binary protobuf if binary is set and from protojson otherwise
+	Unmarshal(data []byte, binary bool, message any) error
+	// Append encodes the message to b, as binary protobuf if binary is set and as protojson otherwise
+	Append(b []byte, binary bool, message any) ([]byte, error)
+}
+
+var (
+	protoCodecMu sync.RWMutex
+	protoCodec   ProtoCodec
+)
+
+// RegisterProtoCodec teaches vel the protobuf messages, see ProtoCodec, a registered codec replaces the previous one.
+// It must be called before the handlers taking or returning the messages are registered.
+func RegisterProtoCodec(codec ProtoCodec) {
+	protoCodecMu.Lock()
+	defer protoCodecMu.Unlock()
+	protoCodec = codec
+}
+
+// registeredProtoCodec returns the codec registered by RegisterProtoCodec, nil without one
+func registeredProtoCodec() ProtoCodec {
+	protoCodecMu.RLock()
+	defer protoCodecMu.RUnlock()
+	return protoCodec
+}
+
+// isProtoMessage reports whether the value is a protobuf message of the registered codec
+func isProtoMessage(v any) bool {
+	codec := registeredProtoCodec()
+	return codec != nil && v != nil && codec.IsMessage(reflect.TypeOf(v))
+}
+
+// ProtoMessageType returns the struct of a protobuf message type and whether the type is a pointer to a message,
+// the meta describes the message by the struct, the generated clients name its fields as protojson does.
+// No type is a message unless a codec is registered by RegisterProtoCodec.
+func ProtoMessageType(t reflect.Type) (reflect.Type, bool) {
+	if t == nil || t.Kind() != reflect.Pointer {
+		return nil, false
+	}
+	if codec := registeredProtoCodec(); codec == nil || !codec.IsMessage(t) {
+		return nil, false
+	}
+	return t.Elem(), true
+}
+
+// isProtobuf reports whether the request body is binary protobuf
+func isProtobuf(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == Protobuf
+}
+
+// acceptsProtobuf reports whether a message response is binary protobuf, see acceptsOverJSON
+func acceptsProtobuf(r *http.Request) bool {
+	return acceptsOverJSON(r, Protobuf)
+}
+
+// readProto allocates the message input and decodes the request body to it by the registered codec
+func readProto[I any](r *http.Request, i *I) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		return err
+	}
+	message := reflect.New(reflect.TypeFor[I]().Elem()).Interface()
+	*i = message.(I)
+	return registeredProtoCodec().Unmarshal(buf.Bytes(), isProtobuf(r), message)
+}
+
+// encodeProto encodes the message to the buffer as binary protobuf
+func encodeProto(buf *bytes.Buffer, v any) error {
+	return appendProto(buf, v, true)
+}
+
+// encodeProtoJSON encodes the message to the buffer as protojson followed by a newline like encodeBody
+func encodeProtoJSON(buf *bytes.Buffer, v any) error {
+	if err := appendProto(buf, v, false); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	return nil
+}
+
+func appendProto(buf *bytes.Buffer, v any, binary bool) error {
+	if !isProtoMessage(v) {
+		return fmt.Errorf("%T is not a protobuf message", v)
+	}
+	b, err := registeredProtoCodec().Append(buf.AvailableBuffer(), binary, v)
+	if err != nil {
+		return err
+	}
+	buf.Write(b)
+	return nil
+}
@@ -0,0 +1,38 @@
+package vel
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// ProxyFailedCode is the code of the error answering a proxied request the target didn't answer
+const ProxyFailedCode = "PROXY_FAILED"
+
+// Proxy forwards the operation to the target keeping the request path, e.g. POST /v1/users to http://legacy/v1/users,
+// while the meta documents it like any other route, so the clients and the OpenAPI spec cover it during a migration.
+// The meta defines the method, POST if empty, the input and output types, empty if nil, and the spec,
+// the operation id overrides the one of the meta. The X-Forwarded headers are set for the target.
+func (r *Router) Proxy(operationID string, target *url.URL, meta HandlerMeta, middlewares ...Middleware) *HandlerMeta {
+	meta.OperationID = operationID
+	if meta.Method == "" {
+		meta.Method = http.MethodPost
+	}
+	if meta.Input == nil {
+		meta.Input = struct{}{}
+	}
+	if meta.Output == nil {
+		meta.Output = struct{}{}
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			writeError(w, req, http.StatusBadGateway, &Error{Code: ProxyFailedCode, Message: "the proxied backend failed", Err: err})
+		},
+	}
+	return RegisterHandler(r, proxy, meta, middlewares...)
+}
@@ -0,0 +1,293 @@
+package vel
+
+import (
+	"cmp"
+	"encoding"
+	"maps"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/schema"
+)
+
+var textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+
+var (
+	queryConvertersMu sync.RWMutex
+	queryConverters   = map[reflect.Type]schema.Converter{
+		reflect.TypeFor[time.Time](): func(s string) reflect.Value {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return reflect.Value{}
+			}
+			return reflect.ValueOf(t)
+		},
+	}
+)
+
+// RegisterQueryConverter teaches GET query decoding to parse a custom type,
+// it must be called before the handlers using the type are registered.
+// time.Time is supported out of the box and expects RFC3339 format.
+func RegisterQueryConverter[T any](parse func(s string) (T, error)) {
+	converter := func(s string) reflect.Value {
+		v, err := parse(s)
+		if err != nil {
+			return reflect.Value{}
+		}
+		return reflect.ValueOf(v)
+	}
+	queryConvertersMu.Lock()
+	defer queryConvertersMu.Unlock()
+	queryConverters[reflect.TypeFor[T]()] = converter
+}
+
+// queryConverter returns the converter registered for the type, nil if there is none
+func queryConverter(t reflect.Type) schema.Converter {
+	queryConvertersMu.RLock()
+	defer queryConvertersMu.RUnlock()
+	return queryConverters[t]
+}
+
+// queryDecoder decodes the GET queries of a handler input. The fields of a flat struct of the basic types
+// and the types with a converter are cached once, a query setting each of them once is decoded by them.
+// The other inputs and queries, e.g. with an unknown, a repeated or an empty key, are decoded by gorilla schema,
+// so both ways give the same result.
+type queryDecoder struct {
+	schema *schema.Decoder
+	// fields maps the query keys to the fields of a flat struct, nil if the input isn't one
+	fields map[string]queryField
+	// cursor is the index of the embedded Cursor, gorilla schema takes the cursor param for the embedded struct itself
+	cursor []int
+	// indexed holds the keys of the slices of the structs parsed from a string, e.g. []time.Time,
+	// gorilla schema takes them for the slices of the structs and decodes only the indexed keys, e.g. day.0
+	indexed map[string]bool
+	// optional holds the keys of the pointers and the fields tagged omitempty, an empty value is a missing one
+	optional map[string]bool
+}
+
+type queryField struct {
+	index   int
+	kind    reflect.Kind
+	convert schema.Converter
+}
+
+func newQueryDecoder(t reflect.Type) *queryDecoder {
+	d := &queryDecoder{schema: schema.NewDecoder()}
+	queryConvertersMu.RLock()
+	for t, converter := range queryConverters {
+		d.schema.RegisterConverter(reflect.Zero(t).Interface(), converter)
+	}
+	queryConvertersMu.RUnlock()
+	d.fields = queryFields(t)
+	d.indexed, d.optional = make(map[string]bool), make(map[string]bool)
+	walkQuery(t, "", make(map[reflect.Type]bool), func(key string, field reflect.StructField, options string) {
+		if parsedSlice(field.Type) {
+			d.indexed[key] = true
+		}
+		if field.Type.Kind() == reflect.Pointer || hasQueryOption(options, "omitempty") {
+			d.optional[key] = true
+		}
+	})
+	if t.Kind() == reflect.Struct {
+		if f, ok := t.FieldByName("Cursor"); ok && f.Anonymous && f.Type == reflect.TypeFor[Cursor]() {
+			d.cursor = f.Index
+		}
+	}
+	return d
+}
+
+// queryFields caches the fields of a flat struct, nil if a field needs gorilla schema,
+// e.g. a slice, an embedded struct, a text unmarshaler or a required one
+func queryFields(t reflect.Type) map[string]queryField {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	fields := make(map[string]queryField, t.NumField())
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("schema")
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous || (options != "" && options != "omitempty") {
+			return nil
+		}
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		f := queryField{index: i, kind: field.Type.Kind(), convert: queryConverter(field.Type)}
+		if f.convert == nil {
+			if field.Type.Implements(textUnmarshalerType) || reflect.PointerTo(field.Type).Implements(textUnmarshalerType) {
+				return nil
+			}
+			switch f.kind {
+			case reflect.String, reflect.Bool,
+				reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+				reflect.Float32, reflect.Float64:
+			default:
+				return nil
+			}
+		}
+		fields[cmp.Or(name, field.Name)] = f
+	}
+	return fields
+}
+
+// walkQuery calls fn with the dotted key of every field of t decoded from the query values,
+// the nested structs are walked through, the fields of an embedded one are keyed as its own
+func walkQuery(t reflect.Type, prefix string, path map[reflect.Type]bool, fn func(key string, field reflect.StructField, options string)) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || path[t] {
+		return
+	}
+	path[t] = true
+	defer delete(path, t)
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("schema"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		key := prefix + cmp.Or(name, field.Name)
+		if !nestedQuery(field.Type) {
+			fn(key, field, options)
+			continue
+		}
+		nested := key + "."
+		if field.Anonymous && name == "" {
+			nested = prefix
+		}
+		walkQuery(field.Type, nested, path, fn)
+	}
+}
+
+// nestedQuery reports whether the fields of the struct are decoded rather than the struct itself
+func nestedQuery(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && queryConverter(t) == nil && !reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
+
+// parsedSlice reports whether t is a slice of the structs parsed from a string
+func parsedSlice(t reflect.Type) bool {
+	if t.Kind() != reflect.Slice {
+		return false
+	}
+	elem := t.Elem()
+	if elem.Kind() == reflect.Pointer {
+		elem = elem.Elem()
+	}
+	return elem.Kind() == reflect.Struct && !nestedQuery(elem)
+}
+
+func hasQueryOption(options, option string) bool {
+	for o := range strings.SplitSeq(options, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *queryDecoder) Decode(dst any, query url.Values) error {
+	v := reflect.ValueOf(dst).Elem()
+	if d.fields != nil && d.decodeFields(v, query) {
+		return nil
+	}
+	v.SetZero()
+	query = d.prepare(query)
+	if d.cursor == nil || !query.Has("cursor") {
+		return d.schema.Decode(dst, query)
+	}
+	after := query.Get("cursor")
+	query = maps.Clone(query)
+	query.Del("cursor")
+	if err := d.schema.Decode(dst, query); err != nil {
+		return err
+	}
+	v.FieldByIndex(d.cursor).Addr().Interface().(*Cursor).After = after
+	return nil
+}
+
+// prepare leaves out the empty values of the optional keys and numbers the repeated values of the indexed ones,
+// day=a&day=b is decoded as day.0=a&day.1=b
+func (d *queryDecoder) prepare(query url.Values) url.Values {
+	var prepared url.Values
+	for key, values := range query {
+		empty := d.optional[key] && !slices.ContainsFunc(values, func(v string) bool { return v != "" })
+		if !empty && !d.indexed[key] {
+			continue
+		}
+		if prepared == nil {
+			prepared = maps.Clone(query)
+		}
+		delete(prepared, key)
+		if empty {
+			continue
+		}
+		for i, value := range values {
+			prepared[key+"."+strconv.Itoa(i)] = []string{value}
+		}
+	}
+	if prepared == nil {
+		return query
+	}
+	return prepared
+}
+
+// decodeFields sets the cached fields, it's false if gorilla schema has to decode the query
+func (d *queryDecoder) decodeFields(v reflect.Value, query url.Values) bool {
+	for key, values := range query {
+		f, ok := d.fields[key]
+		if !ok || len(values) != 1 || values[0] == "" {
+			return false
+		}
+		field, value := v.Field(f.index), values[0]
+		if f.convert != nil {
+			converted := f.convert(value)
+			if !converted.IsValid() {
+				return false
+			}
+			field.Set(converted.Convert(field.Type()))
+			continue
+		}
+
+		switch f.kind {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return false
+			}
+			field.SetBool(b)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+			if err != nil {
+				return false
+			}
+			field.SetInt(n)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+			if err != nil {
+				return false
+			}
+			field.SetUint(n)
+		case reflect.Float32, reflect.Float64:
+			n, err := strconv.ParseFloat(value, field.Type().Bits())
+			if err != nil {
+				return false
+			}
+			field.SetFloat(n)
+		}
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package vel
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"reflect"
+)
+
+// OctetStream is the content type of the raw bodies of a route declaring none, see IsRawBody
+const OctetStream = "application/octet-stream"
+
+var (
+	bytesType  = reflect.TypeFor[[]byte]()
+	readerType = reflect.TypeFor[io.Reader]()
+)
+
+// IsRawBody reports whether a handler input or output of the type is passed as is skipping JSON,
+// e.g. a CSV import or a binary protocol: an input of []byte gets the read body and an io.Reader one reads it itself,
+// an output of []byte or io.Reader is written with the content type declared by Spec.ContentType.
+// The meta describes both types by []byte.
+func IsRawBody(t reflect.Type) bool {
+	return t == bytesType || t == readerType
+}
+
+// metaValue is the zero value describing the handler input or output in the meta, a raw io.Reader is described by []byte
+func metaValue[T any]() any {
+	if reflect.TypeFor[T]() == readerType {
+		return []byte(nil)
+	}
+	var v T
+	return v
+}
+
+// readRaw passes the request body to the raw input
+func readRaw(r *http.Request, v any) error {
+	switch v := v.(type) {
+	case *[]byte:
+		body, err := io.ReadAll(r.Body)
+		*v = body
+		return err
+	case *io.Reader:
+		*v = r.Body
+	}
+	return nil
+}
+
+// writeRaw writes the raw output with the content type of the route unless the handler has set its own
+func writeRaw(w http.ResponseWriter, r *http.Request, res any) {
+	if w.Header().Get("Content-Type") == "" {
+		contentType := OctetStream
+		if meta := MetaFromContext(r.Context()); meta != nil && meta.Spec.ContentType != "" {
+			contentType = meta.Spec.ContentType
+		}
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	var err error
+	switch res := res.(type) {
+	case []byte:
+		_, err = w.Write(res)
+	case io.Reader:
+		if closer, ok := res.(io.Closer); ok {
+			defer closer.Close()
+		}
+		_, err = io.Copy(w, res)
+	}
+	if err != nil {
+		slog.Default().ErrorContext(r.Context(), "failed to write response", "err", err)
+	}
+}
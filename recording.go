@@ -0,0 +1,181 @@
+package vel
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// Recording is a sample saved by RecordingSink, the bodies are kept as JSON, so the files are readable fixtures
+type Recording struct {
+	OperationID string `json:"operationId"`
+	Method      string `json:"method"`
+	// URL is the path and the query of the request
+	URL             string          `json:"url"`
+	Status          int             `json:"status"`
+	RequestHeaders  http.Header     `json:"requestHeaders,omitempty"`
+	RequestBody     json.RawMessage `json:"requestBody,omitempty"`
+	ResponseHeaders http.Header     `json:"responseHeaders,omitempty"`
+	ResponseBody    json.RawMessage `json:"responseBody,omitempty"`
+	// RedactedFields are the fields tagged by redact:"true" redacted in addition to the options, see Sample
+	RedactedFields []string `json:"redactedFields,omitempty"`
+}
+
+// key identifies the request of the recording, a replayed request is looked up by it
+func (rec Recording) key() string {
+	hash := sha256.Sum256(fmt.Appendf(nil, "%s %s\n%s", rec.Method, rec.URL, compactJSON(rec.RequestBody)))
+	return hex.EncodeToString(hash[:6])
+}
+
+// compactJSON removes the indentation of a saved body
+func compactJSON(data []byte) []byte {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
+
+// RecordingSink saves the samples to the directory as the JSON files of the Recording format,
+// a file is in the directory of its operation and named by the hash of its request, e.g. createUser/3f2b8c4e1a7d.json,
+// so the same request is saved once
+func RecordingSink(dir string) SampleSink {
+	var mu sync.Mutex
+	return SampleSinkFunc(func(ctx context.Context, sample Sample) {
+		recording := Recording{
+			OperationID:     sample.OperationID,
+			Method:          sample.Method,
+			URL:             sample.URL,
+			Status:          sample.Status,
+			RequestHeaders:  sample.RequestHeaders,
+			RequestBody:     sample.RequestBody,
+			ResponseHeaders: sample.ResponseHeaders,
+			ResponseBody:    sample.ResponseBody,
+			RedactedFields:  sample.RedactedFields,
+		}
+		data, err := json.MarshalIndent(recording, "", "  ")
+		if err != nil {
+			slog.Default().ErrorContext(ctx, "failed to encode recording", "err", err, "operation", sample.OperationID)
+			return
+		}
+		path := filepath.Join(dir, cmp.Or(sample.OperationID, "unknown"), recording.key()+".json")
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+			err = os.WriteFile(path, append(data, '\n'), 0644)
+		}
+		if err != nil {
+			slog.Default().ErrorContext(ctx, "failed to write recording", "err", err, "operation", sample.OperationID)
+		}
+	})
+}
+
+// Recorder is a per-route middleware saving every request along with its response to the directory by RecordingSink,
+// e.g. to replay the real traffic shapes in the client tests with ReplayTransport.
+// The payloads are redacted by the options the way Sampling redacts them, the rate and the error threshold are ignored.
+func Recorder(dir string, opts SamplingOpts) Middleware {
+	opts.Rate, opts.ErrorThreshold = 1, 0
+	return Sampling(RecordingSink(dir), opts)
+}
+
+// ReplayTransport responds with the recordings instead of sending the requests,
+// a generated Go client replays them with &http.Client{Transport: transport}
+type ReplayTransport struct {
+	redactor    *redactor
+	maxBodySize int
+	recordings  map[string]Recording
+	// fieldSets are the distinct tagged fields of the recordings, a request is redacted by each of them to match its recording
+	fieldSets [][]string
+}
+
+// NewReplayTransport loads the recordings of the directory, the options must redact the same fields as the recording ones,
+// so a request matches its recording. The fields tagged by redact:"true" are saved along with the recordings, so they are redacted as recorded.
+func NewReplayTransport(dir string, opts SamplingOpts) (*ReplayTransport, error) {
+	t := &ReplayTransport{
+		redactor:    newRedactor(opts),
+		maxBodySize: cmp.Or(opts.MaxBodySize, defaultSampleBodySize),
+		recordings:  make(map[string]Recording),
+	}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || filepath.Ext(path) != ".json" {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var recording Recording
+		if err := json.Unmarshal(data, &recording); err != nil {
+			return fmt.Errorf("failed to decode recording %s: %w", path, err)
+		}
+		t.recordings[recording.key()] = recording
+		if !slices.ContainsFunc(t.fieldSets, func(fields []string) bool { return slices.Equal(fields, recording.RedactedFields) }) {
+			t.fieldSets = append(t.fieldSets, recording.RedactedFields)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// RoundTrip responds with the recording of the request, it fails if the request wasn't recorded
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := &cappedBuffer{limit: t.maxBodySize}
+	if req.Body != nil {
+		_, err := io.Copy(body, req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	// the recorded url is the one served, without the scheme and the host
+	target := url.URL{Path: req.URL.Path, RawPath: req.URL.RawPath, RawQuery: req.URL.RawQuery}
+	var recording Recording
+	ok := false
+	for _, fields := range t.fieldSets {
+		// the body is consumed by the redaction, every attempt reads a copy
+		attempt := &cappedBuffer{limit: body.limit, truncated: body.truncated}
+		attempt.Write(body.Bytes())
+		redactor := t.redactor.with(fields)
+		key := Recording{Method: req.Method, URL: redactor.url(target), RequestBody: redactor.body(attempt)}.key()
+		if recording, ok = t.recordings[key]; ok {
+			break
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("no recording of %s %s matches the request", req.Method, req.URL.RequestURI())
+	}
+
+	responseBody := compactJSON(recording.ResponseBody)
+	header := recording.ResponseHeaders.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recording.Status, http.StatusText(recording.Status)),
+		StatusCode:    recording.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(responseBody)),
+		ContentLength: int64(len(responseBody)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,85 @@
+package vel
+
+import (
+	"cmp"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// IsRedacted reports whether the field holds a secret, e.g. a password or a token, tagged by redact:"true":
+//
+//	type Login struct {
+//		User     string `json:"user"`
+//		Password string `json:"password" redact:"true"`
+//	}
+//
+// Sampling and Recorder replace the field by Redacted in the payloads of the route taking or returning it
+// and the generated examples show Redacted instead of a realistic value.
+func IsRedacted(field reflect.StructField) bool {
+	redact, _ := strconv.ParseBool(field.Tag.Get("redact"))
+	return redact
+}
+
+var redactedFieldsCache sync.Map // reflect.Type -> []string
+
+// redactedFields lists the JSON and the query names of the redacted fields of the types at any depth
+func redactedFields(types ...reflect.Type) []string {
+	var fields []string
+	for _, t := range types {
+		if t == nil {
+			continue
+		}
+		cached, ok := redactedFieldsCache.Load(t)
+		if !ok {
+			var names []string
+			collectRedacted(t, map[reflect.Type]bool{}, &names)
+			slices.Sort(names)
+			cached, _ = redactedFieldsCache.LoadOrStore(t, slices.Compact(names))
+		}
+		fields = append(fields, cached.([]string)...)
+	}
+	slices.Sort(fields)
+	return slices.Compact(fields)
+}
+
+func collectRedacted(t reflect.Type, seen map[reflect.Type]bool, names *[]string) {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		collectRedacted(t.Elem(), seen, names)
+		return
+	case reflect.Struct:
+	default:
+		return
+	}
+	if seen[t] {
+		return
+	}
+	seen[t] = true
+	// a OneOf holds one of its structs in an unexported field
+	if IsOneOf(t) {
+		union, _ := OneOfUnion(t)
+		for _, variant := range union.Variants {
+			collectRedacted(variant.Type, seen, names)
+		}
+		return
+	}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if !IsRedacted(field) {
+			collectRedacted(field.Type, seen, names)
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		query, _, _ := strings.Cut(field.Tag.Get("schema"), ",")
+		*names = append(*names, strings.ToLower(cmp.Or(name, field.Name)))
+		if query != "" && query != "-" {
+			*names = append(*names, strings.ToLower(query))
+		}
+	}
+}
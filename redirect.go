@@ -0,0 +1,39 @@
+package vel
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// RedirectCode is the code of the error returned by Redirect, it's never written as an error response
+const RedirectCode = "REDIRECT"
+
+// Redirect makes a handler respond with a 3xx status and the Location header instead of the response body,
+// e.g. return Response{}, vel.Redirect(http.StatusFound, "/v2/items").
+// Declare the redirect in Spec.Redirects to document it.
+// It panics if the status isn't a redirect one, 300 to 308, since a mistyped status isn't a redirect anymore.
+func Redirect(status int, location string) *Error {
+	if status < http.StatusMultipleChoices || status > http.StatusPermanentRedirect {
+		panic("vel: Redirect requires a 3xx status, got " + strconv.Itoa(status))
+	}
+	return &Error{
+		Code:     RedirectCode,
+		redirect: &redirect{status: status, location: location},
+	}
+}
+
+type redirect struct {
+	status   int
+	location string
+}
+
+// RedirectSpec documents a redirect returned by a handler
+type RedirectSpec struct {
+	Status      int
+	Description string
+}
+
+func writeRedirect(w http.ResponseWriter, rd *redirect) {
+	w.Header().Set("Location", rd.location)
+	w.WriteHeader(rd.status)
+}
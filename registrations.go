@@ -0,0 +1,108 @@
+package vel
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// DuplicateRoutePolicy decides what happens when a method and a path are registered again,
+// e.g. by two subrouters sharing a prefix
+type DuplicateRoutePolicy int
+
+const (
+	// DuplicateRoutePanic panics with a *DuplicateRouteError naming both registrations, it's the default
+	DuplicateRoutePanic DuplicateRoutePolicy = iota
+	// DuplicateRouteKeepFirst ignores the later registration and reports it by Diagnostics
+	DuplicateRouteKeepFirst
+	// DuplicateRouteReplace serves the route by the later registration and reports it by Diagnostics
+	DuplicateRouteReplace
+)
+
+// DuplicateRouteError describes a method and a path registered twice
+type DuplicateRouteError struct {
+	Pattern string
+	// First and Second are the call sites of the registrations, file:line
+	First  string
+	Second string
+}
+
+func (e *DuplicateRouteError) Error() string {
+	return fmt.Sprintf("%s is registered twice: first at %s, then at %s", e.Pattern, e.First, e.Second)
+}
+
+// registration is a pattern served by the mux, the mux can't replace a handler,
+// so the registration swaps it when the pattern is registered again
+type registration struct {
+	handler atomic.Pointer[http.Handler]
+	site    string
+	router  *Router
+	// meta is nil for the endpoints of the router, e.g. OPTIONS or /healthz
+	meta *HandlerMeta
+}
+
+func (reg *registration) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*reg.handler.Load()).ServeHTTP(w, r)
+}
+
+// handle serves the pattern by the handler, a pattern registered again is resolved by GlobalOpts.DuplicateRoutes.
+// It reports whether the handler serves the pattern.
+func (r *Router) handle(pattern string, handler http.Handler, meta *HandlerMeta, site string) bool {
+	return r.register(pattern, handler, meta, site, func(reg *registration) {
+		r.mux.Handle(pattern, reg)
+	})
+}
+
+// register records the registration of the pattern, serve makes the first one served, e.g. by the mux
+func (r *Router) register(pattern string, handler http.Handler, meta *HandlerMeta, site string, serve func(reg *registration)) bool {
+	prev, ok := r.shared.registrations[pattern]
+	if !ok {
+		reg := &registration{site: site, router: r, meta: meta}
+		reg.handler.Store(&handler)
+		r.shared.registrations[pattern] = reg
+		serve(reg)
+		return true
+	}
+
+	err := &DuplicateRouteError{Pattern: pattern, First: prev.site, Second: site}
+	switch GlobalOpts.DuplicateRoutes {
+	case DuplicateRouteKeepFirst:
+		r.shared.diagnostics.add(DiagnosticDuplicateRoute, err.Error()+", the first one is served")
+		return false
+	case DuplicateRouteReplace:
+		r.shared.diagnostics.add(DiagnosticDuplicateRoute, err.Error()+", the second one is served")
+		if prev.meta != nil {
+			prev.router.handlersMeta = slices.DeleteFunc(prev.router.handlersMeta, func(m *HandlerMeta) bool { return m == prev.meta })
+			r.shared.routesMu.Lock()
+			r.shared.routes = slices.DeleteFunc(r.shared.routes, func(m *HandlerMeta) bool { return m == prev.meta })
+			r.shared.routesMu.Unlock()
+		}
+		prev.handler.Store(&handler)
+		prev.site, prev.router, prev.meta = site, r, meta
+		return true
+	default:
+		panic(err)
+	}
+}
+
+var packagePath = reflect.TypeFor[Router]().PkgPath()
+
+// callSite returns file:line of the first caller outside the package, i.e. the code registering a route
+func callSite() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		internal := strings.HasPrefix(frame.Function, packagePath+".") && !strings.HasSuffix(frame.File, "_test.go")
+		if !internal {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"reflect"
+	"slices"
 	"strings"
-	"unsafe"
-
-	"github.com/gorilla/schema"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Handler[I, O any] func(ctx context.Context, i I) (O, *Error)
@@ -18,6 +20,15 @@ type Opts struct {
 	ProcessErr       func(r *http.Request, e *Error)
 	MapCodeToStatus  func(code string) int
 	SkipOptionMethod bool
+	// DuplicateRoutes decides what happens when a method and a path are registered again, it panics by default
+	DuplicateRoutes DuplicateRoutePolicy
+	// MaxBodyBytes bounds the request bodies the handlers decode, a larger one is answered by 413 RequestTooLargeCode,
+	// zero doesn't bound them, e.g. 10 << 20 bounds them by 10 MiB. The raw bodies and the items are read by the handlers,
+	// they aren't bounded. Spec.MaxBodyBytes replaces it for a route.
+	MaxBodyBytes int64
+	// DecodeTimeout bounds the time of reading the request bodies the handlers decode, a slower one is answered
+	// by 408 RequestTimeoutCode, zero doesn't bound it, e.g. 30 * time.Second. Spec.DecodeTimeout replaces it for a route.
+	DecodeTimeout time.Duration
 }
 
 var GlobalOpts = Opts{
@@ -30,83 +41,217 @@ var GlobalOpts = Opts{
 	},
 }
 
+// NoBody is embedded in a handler input or output to skip decoding or encoding it,
+// e.g. a request filled by a middleware from the headers
+type NoBody struct{}
+
+func (NoBody) noBody() {}
+
+var noBodyType = reflect.TypeFor[interface{ noBody() }]()
+
+// HasBody reports whether a handler input or output of the type is decoded and encoded,
+// a struct without fields besides the blank ones and a type embedding NoBody aren't
+func HasBody(t reflect.Type) bool {
+	if t.Implements(noBodyType) {
+		return false
+	}
+	if t.Kind() != reflect.Struct {
+		return true
+	}
+	for i := range t.NumField() {
+		if t.Field(i).Name != "_" {
+			return true
+		}
+	}
+	return false
+}
+
 func NewHandler[I, O any](call Handler[I, O]) http.HandlerFunc {
-	var iType I
-	var oType O
-	hasReqBody := unsafe.Sizeof(iType) != 0
-	hasResBody := unsafe.Sizeof(oType) != 0
+	hasReqBody := HasBody(reflect.TypeFor[I]())
+	hasResBody := HasBody(reflect.TypeFor[O]())
+	rawReq := IsRawBody(reflect.TypeFor[I]())
+	rawRes := IsRawBody(reflect.TypeFor[O]())
+	_, itemsReq := ItemType(reflect.TypeFor[I]())
+	_, protoReq := ProtoMessageType(reflect.TypeFor[I]())
 
-	decoder := schema.NewDecoder()
+	decoder := newQueryDecoder(reflect.TypeFor[I]())
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		*r = *r.WithContext(RequestWithContext(r.Context(), r))
-		*r = *r.WithContext(WriterWithContext(r.Context(), w))
+		*r = *r.WithContext(WriterWithContext(RequestWithContext(r.Context(), r), w))
+		if meta := MetaFromContext(r.Context()); meta != nil && meta.Spec.EnforceRequestHeaders {
+			if headerErr := validateHeader(r, meta.Spec.RequestHeaders); headerErr != nil {
+				writeError(w, r, http.StatusBadRequest, headerErr)
+				return
+			}
+		}
 		var i I
 
-		if hasReqBody {
+		if itemsReq {
+			readItems(w, r, &i)
+		} else if protoReq {
+			release := limitBody(w, r)
+			err := readProto(r, &i)
+			release()
+			if err != nil {
+				bodyErr := bodyError(http.StatusBadRequest, "FAILED_DECODING_REQUEST_BODY", err)
+				writeError(w, r, bodyErr.Status, bodyErr)
+				return
+			}
+			// the message is the pointer implementing the validation
+			if validationErr := validate(i); validationErr != nil {
+				writeError(w, r, http.StatusUnprocessableEntity, validationErr)
+				return
+			}
+		} else if rawReq {
+			if err := readRaw(r, &i); err != nil {
+				writeError(w, r, http.StatusBadRequest, &Error{
+					Code: "FAILED_READING_REQUEST_BODY",
+					Err:  err,
+				})
+				return
+			}
+		} else if hasReqBody {
 			if r.Method == "GET" {
-				if err := decoder.Decode(&i, r.URL.Query()); err != nil {
-					w.WriteHeader(http.StatusBadRequest)
-					err = json.NewEncoder(w).Encode(Error{
-						Code: "FAILED_DECODING_QUERY",
-						Err:  err,
-					})
-					if err != nil {
-						slog.Default().ErrorContext(r.Context(), "failed to write request marshal error", "err", err)
-					}
+				if err := uuidQueryError(decoder.Decode(&i, r.URL.Query())); err != nil {
+					queryErr := bodyError(http.StatusBadRequest, "FAILED_DECODING_QUERY", err)
+					writeError(w, r, queryErr.Status, queryErr)
 					return
 				}
 			} else {
-				if err := json.NewDecoder(r.Body).Decode(&i); err != nil {
-					w.WriteHeader(http.StatusBadRequest)
-					err = json.NewEncoder(w).Encode(Error{
-						Code: "FAILED_DECODING_REQUEST_BODY",
-						Err:  err,
-					})
-					if err != nil {
-						slog.Default().ErrorContext(r.Context(), "failed to write request marshal error", "err", err)
-					}
+				release := limitBody(w, r)
+				var err error
+				if isForm(r) {
+					err = decodeForm(r, decoder, &i)
+				} else {
+					err = decodeBody(r, &i)
+				}
+				release()
+				if err != nil {
+					bodyErr := bodyError(http.StatusBadRequest, "FAILED_DECODING_REQUEST_BODY", err)
+					writeError(w, r, bodyErr.Status, bodyErr)
 					return
 				}
 			}
+			if validationErr := validate(&i); validationErr != nil {
+				writeError(w, r, http.StatusUnprocessableEntity, validationErr)
+				return
+			}
+		}
+
+		var stream *streamState
+		if meta := MetaFromContext(r.Context()); meta != nil && meta.Spec.Stream != "" {
+			stream = &streamState{format: meta.Spec.Stream}
+			*r = *r.WithContext(streamKey.Set(r.Context(), stream))
+			w.Header().Set("Content-Type", meta.Spec.Stream.ContentType())
+			w.Header().Set("Cache-Control", "no-cache")
 		}
 
 		res, callErr := call(r.Context(), i)
+		if stream != nil {
+			if callErr != nil && stream.started {
+				writeStreamError(w, r, stream, callErr)
+				return
+			}
+			if callErr == nil {
+				if !stream.started {
+					// an empty stream
+					w.WriteHeader(http.StatusOK)
+				}
+				return
+			}
+			// the error is a regular response
+			w.Header().Del("Content-Type")
+			w.Header().Del("Cache-Control")
+		}
 		if callErr != nil {
+			if callErr.redirect != nil {
+				writeRedirect(w, callErr.redirect)
+				return
+			}
 			if GlobalOpts.ProcessErr != nil {
 				GlobalOpts.ProcessErr(r, callErr)
 			}
-			status := GlobalOpts.MapCodeToStatus(callErr.Code)
-			w.WriteHeader(status)
-			err := json.NewEncoder(w).Encode(callErr)
-			if err != nil {
-				slog.Default().ErrorContext(r.Context(), "failed to write api call error", "err", err)
+			if writeFallback(w, r, callErr) {
+				return
 			}
+			writeError(w, r, errorStatus(r, callErr), callErr)
 			return
 		}
 
-		if hasResBody {
-			if err := json.NewEncoder(w).Encode(res); err != nil {
-				w.WriteHeader(http.StatusBadRequest)
-				err = json.NewEncoder(w).Encode(Error{
-					Code:    "FAILED_ENCODING_RESPONSE_BODY",
-					Message: err.Error(),
-				})
-				if err != nil {
-					slog.Default().ErrorContext(r.Context(), "failed to write request marshal error", "err", err)
+		if meta := MetaFromContext(r.Context()); meta != nil {
+			if cacheControl := meta.Spec.Cache.HeaderValue(); cacheControl != "" && w.Header().Get("Cache-Control") == "" {
+				w.Header().Set("Cache-Control", cacheControl)
+				if len(meta.Spec.Cache.VaryHeaders) > 0 {
+					w.Header().Add("Vary", strings.Join(meta.Spec.Cache.VaryHeaders, ", "))
 				}
 			}
 		}
+
+		if rawRes {
+			writeRaw(w, r, res)
+		} else if hasResBody {
+			writeResponse(w, r, res)
+		}
+	}
+}
+
+// writeError writes the error with the encoder of the router serving the request
+func writeError(w http.ResponseWriter, r *http.Request, status int, e *Error) {
+	var enc ErrorEncoder = defaultErrorEncoder{}
+	if route := routeFromContext(r.Context()); route != nil && route.shared.errorEncoder != nil {
+		enc = route.shared.errorEncoder
+	}
+	if err := enc.EncodeError(w, r, status, e); err != nil {
+		slog.Default().ErrorContext(r.Context(), "failed to write error response", "err", err, "code", e.Code)
 	}
 }
 
 type Router struct {
-	mux             *http.ServeMux
-	middlewares     []Middleware
-	prefix          string
-	optionsPatterns map[string]bool
+	mux         *http.ServeMux
+	middlewares []Middleware
+	prefix      string
+	shared      *routerShared
+
+	handlersMeta []*HandlerMeta
+	subrouters   []*Router
+}
+
+// routerShared holds the state shared by a router and all its subrouters
+type routerShared struct {
+	// allowed holds the methods registered for every path, it builds the OPTIONS Allow header
+	allowed allowedMethods
+	// optionsRouters remembers the router whose middlewares serve OPTIONS of a path
+	optionsRouters map[string]*Router
+	// routes contains every registered route in the registration order, the endpoints serving them,
+	// e.g. the batch one, read them by allRoutes since the routes may be registered while the router serves
+	routesMu sync.RWMutex
+	routes   []*HandlerMeta
+	// patterns maps mux patterns to the routes serving them
+	patterns     map[string]*HandlerMeta
+	diagnostics  diagnostics
+	errorEncoder ErrorEncoder
+	// registrations holds every pattern served by the mux along with its call site
+	registrations map[string]*registration
+	// streams tracks the open responses of the routes using the Stream middleware
+	streams *streamSet
+	// unready is set once the router starts shutting down, /healthz and /readyz answer 503 then
+	unready atomic.Bool
+	health  healthChecks
+	// jobs is set by RegisterOperationsEndpoint, the async routes run their operations by it
+	jobs *asyncJobs
+	// webhooks are the webhooks the API sends, see RegisterWebhook
+	webhooks []WebhookMeta
+	// versions maps the patterns of the versioned operations to their dispatchers, see WithVersion
+	versions map[string]*versionedRoute
+	// msgPack is set by AcceptMsgPack, the routes decode MessagePack request bodies then
+	msgPack bool
+}
 
-	handlersMeta []HandlerMeta
+// allRoutes returns a copy of the registered routes
+func (s *routerShared) allRoutes() []*HandlerMeta {
+	s.routesMu.RLock()
+	defer s.routesMu.RUnlock()
+	return slices.Clone(s.routes)
 }
 
 func (r *Router) Mux() *http.ServeMux {
@@ -114,12 +259,17 @@ func (r *Router) Mux() *http.ServeMux {
 }
 
 func (r *Router) Use(m func(http.Handler) http.Handler) {
+	if len(r.handlersMeta) > 0 {
+		r.shared.diagnostics.add(DiagnosticLateMiddleware, fmt.Sprintf("middleware registered after %d route(s) on %q, they don't use it", len(r.handlersMeta), r.prefix+"/"))
+	}
 	r.middlewares = append(r.middlewares, m)
 }
 
 func (r *Router) Meta() []HandlerMeta {
 	meta := make([]HandlerMeta, len(r.handlersMeta))
-	copy(meta, r.handlersMeta)
+	for i := range r.handlersMeta {
+		meta[i] = *r.handlersMeta[i]
+	}
 	return meta
 }
 
@@ -128,18 +278,73 @@ type HandlerMeta struct {
 	Output      any
 	OperationID string
 	Method      string
-	Spec        Spec
+	// Path is the full request path including the subrouter prefixes, set on registration
+	Path string
+	Spec Spec
+
+	// headers are the request headers documented by the middlewares of the route, e.g. Idempotency
+	headers []KeyValueSpec
+	// operations is the path of the status endpoint of an async route, see RegisterAsync
+	operations string
+	// security are the schemes documented by the middlewares of the route, e.g. Signature
+	security []SecurityScheme
+	// version is the version of the operation set by WithVersion
+	version string
 }
 
+// SetSpec replaces the spec of the route, the errors of the body limits stay documented, see Opts.MaxBodyBytes
 func (m *HandlerMeta) SetSpec(spec Spec) {
 	m.Spec = spec
+	m.documentLimits()
+}
+
+// RequestHeaders returns the request header of the spec followed by the ones documented by the middlewares of the route
+func (m HandlerMeta) RequestHeaders() []KeyValueSpec {
+	var headers []KeyValueSpec
+	if m.Spec.RequestHeaders.Key != "" {
+		headers = append(headers, m.Spec.RequestHeaders)
+	}
+	for _, h := range m.headers {
+		if !slices.ContainsFunc(headers, func(d KeyValueSpec) bool { return strings.EqualFold(d.Key, h.Key) }) {
+			headers = append(headers, h)
+		}
+	}
+	return headers
+}
+
+// OperationsPath returns the path of the status endpoint polled for the operations of an async route,
+// empty for the other routes, see RegisterAsync
+func (m HandlerMeta) OperationsPath() string {
+	return m.operations
+}
+
+// SecuritySchemes returns the schemes authenticating the requests documented by the middlewares of the route
+func (m HandlerMeta) SecuritySchemes() []SecurityScheme {
+	return slices.Clone(m.security)
+}
+
+// headerDocumenter is implemented by the handlers of the middlewares documenting the request headers they read
+type headerDocumenter interface {
+	requestHeaders() []KeyValueSpec
+}
+
+// securityDocumenter is implemented by the handlers of the middlewares authenticating the requests
+type securityDocumenter interface {
+	securityScheme() SecurityScheme
 }
 
 type Error struct {
-	Code    string            `json:"code"`
-	Message string            `json:"message,omitempty"`
-	Meta    map[string]string `json:"meta,omitempty,omitzero"`
-	Err     error             `json:"-"`
+	Code    string    `json:"code"`
+	Message string    `json:"message,omitempty"`
+	Meta    ErrorMeta `json:"meta,omitempty,omitzero"`
+	// Violations lists the failed validation rules of the request
+	Violations []Violation `json:"violations,omitempty"`
+	Err        error       `json:"-"`
+	// Status is the response status of the error, it takes precedence over the status the spec of the route
+	// declares the code under and over GlobalOpts.MapCodeToStatus
+	Status int `json:"-"`
+
+	redirect *redirect
 }
 
 func (e *Error) Error() string {
@@ -157,29 +362,44 @@ func (e *Error) JsonString() string {
 }
 
 func NewRouter() *Router {
-	mux := http.NewServeMux()
-	mux.Handle("GET /healthz", NewHandler(func(ctx context.Context, _ struct{}) (struct{}, *Error) {
-		return struct{}{}, nil
-	}))
-
-	return &Router{
-		mux:             mux,
-		prefix:          "",
-		optionsPatterns: make(map[string]bool),
+	r := &Router{
+		mux:    http.NewServeMux(),
+		prefix: "",
+		shared: &routerShared{
+			optionsRouters: make(map[string]*Router),
+			patterns:       make(map[string]*HandlerMeta),
+			registrations:  make(map[string]*registration),
+			versions:       make(map[string]*versionedRoute),
+			streams:        newStreamSet(),
+		},
 	}
+	r.handle("GET /healthz", http.HandlerFunc(r.serveHealthz), nil, "NewRouter at "+callSite())
+	return r
 }
 
 func (r *Router) Subrouter(prefix string) *Router {
 	if !strings.HasPrefix(prefix, "/") {
 		prefix = "/" + prefix
 	}
-	return &Router{
-		mux:             r.mux,
-		middlewares:     append([]Middleware{}, r.middlewares...),
-		prefix:          r.prefix + prefix,
-		optionsPatterns: r.optionsPatterns,
-		handlersMeta:    []HandlerMeta{},
+	sub := &Router{
+		mux:          r.mux,
+		middlewares:  append([]Middleware{}, r.middlewares...),
+		prefix:       r.prefix + prefix,
+		shared:       r.shared,
+		handlersMeta: []*HandlerMeta{},
 	}
+	r.subrouters = append(r.subrouters, sub)
+	return sub
+}
+
+// Subrouters returns the subrouters created by the router in the creation order
+func (r *Router) Subrouters() []*Router {
+	return slices.Clone(r.subrouters)
+}
+
+// Prefix returns the path prefix of the router including the prefixes of its parents
+func (r *Router) Prefix() string {
+	return r.prefix
 }
 
 type (
@@ -191,26 +411,20 @@ func NoopMiddleware(h http.Handler) http.Handler {
 }
 
 func RegisterPost[I, O any](r *Router, operationID string, handler Handler[I, O], middlewares ...Middleware) *HandlerMeta {
-	var i I
-	var o O
-
 	var h http.Handler = NewHandler(handler)
 	return RegisterHandler(r, h, HandlerMeta{
-		Input:       i,
-		Output:      o,
+		Input:       metaValue[I](),
+		Output:      metaValue[O](),
 		OperationID: operationID,
 		Method:      "POST",
 	}, middlewares...)
 }
 
 func RegisterGet[I, O any](r *Router, operationID string, handler Handler[I, O], middlewares ...Middleware) *HandlerMeta {
-	var i I
-	var o O
-
 	var h http.Handler = NewHandler(handler)
 	return RegisterHandler(r, h, HandlerMeta{
-		Input:       i,
-		Output:      o,
+		Input:       metaValue[I](),
+		Output:      metaValue[O](),
 		OperationID: operationID,
 		Method:      "GET",
 	}, middlewares...)
@@ -222,27 +436,51 @@ func RegisterHandlerFunc(r *Router, meta HandlerMeta, h http.HandlerFunc, middle
 }
 
 func RegisterHandler(r *Router, handler http.Handler, meta HandlerMeta, middlewares ...Middleware) *HandlerMeta {
-	for i := range middlewares {
-		handler = middlewares[i](handler)
-	}
-	for i := range r.middlewares {
-		handler = r.middlewares[i](handler)
+	for _, m := range slices.Concat(middlewares, r.middlewares) {
+		handler = m(handler)
+		if d, ok := handler.(headerDocumenter); ok {
+			meta.headers = append(meta.headers, d.requestHeaders()...)
+		}
+		if d, ok := handler.(securityDocumenter); ok {
+			meta.security = append(meta.security, d.securityScheme())
+		}
+		if d, ok := handler.(versionDocumenter); ok {
+			meta.version = d.routeVersion()
+		}
 	}
 
-	r.handlersMeta = append(r.handlersMeta, meta)
 	path := r.prefix + "/" + meta.OperationID
 	if r.prefix == "" {
 		path = "/" + meta.OperationID
 	}
+	meta.Path = path
+	meta.documentLimits()
+
+	metaRef := &meta
+	handler = withRoute(handler, metaRef, r.shared)
 	pattern := meta.Method + " " + path
-	r.mux.Handle(pattern, handler)
+	var served bool
+	if meta.version != "" {
+		served = r.handleVersion(pattern, meta.version, handler, metaRef, callSite())
+	} else {
+		served = r.handle(pattern, handler, metaRef, callSite())
+	}
+	if !served {
+		return metaRef
+	}
+	r.handlersMeta = append(r.handlersMeta, metaRef)
+	r.shared.routesMu.Lock()
+	r.shared.routes = append(r.shared.routes, metaRef)
+	r.shared.routesMu.Unlock()
+	r.shared.patterns[pattern] = metaRef
+	r.shared.allowed.add(path, meta.Method)
 	if !GlobalOpts.SkipOptionMethod {
-		optionsPattern := http.MethodOptions + " " + path
-		if !r.optionsPatterns[optionsPattern] {
-			r.mux.Handle(optionsPattern, handler)
-			r.optionsPatterns[optionsPattern] = true
+		if owner, ok := r.shared.optionsRouters[path]; !ok {
+			r.registerOptions(path, middlewares)
+		} else if owner != r || len(middlewares) > 0 {
+			r.shared.diagnostics.add(DiagnosticDuplicateOptions, fmt.Sprintf("OPTIONS %s is served with the middlewares of the route registered first, %s doesn't apply its own", path, pattern))
 		}
 	}
 
-	return &r.handlersMeta[len(r.handlersMeta)-1]
+	return metaRef
 }
@@ -0,0 +1,175 @@
+package vel
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type benchmarkQuery struct {
+	Query string    `schema:"q"`
+	Limit int       `schema:"limit"`
+	Since time.Time `schema:"since"`
+}
+
+// benchmarkTagsQuery has a slice, gorilla schema decodes it
+type benchmarkTagsQuery struct {
+	Tags  []string `schema:"tag"`
+	Limit int      `schema:"limit"`
+}
+
+type benchmarkItem struct {
+	ID    string   `json:"id"`
+	Name  string   `json:"name"`
+	Price float64  `json:"price"`
+	Tags  []string `json:"tags"`
+}
+
+func (i benchmarkItem) Validate() []Violation {
+	if i.Name == "" {
+		return []Violation{ViolationRequired("name")}
+	}
+	return nil
+}
+
+type benchmarkOrder struct {
+	Items []benchmarkItem `json:"items"`
+}
+
+// benchmarkItems lists n items
+func benchmarkItems(n int) []benchmarkItem {
+	items := make([]benchmarkItem, n)
+	for i := range items {
+		items[i] = benchmarkItem{ID: strconv.Itoa(i), Name: "item " + strconv.Itoa(i), Price: 9.99, Tags: []string{"a", "b"}}
+	}
+	return items
+}
+
+type benchmarkRequest struct {
+	name, method, target, body string
+	status                     int
+}
+
+// benchmarkRequests serves the requests concurrently, a request is served again until the benchmark ends
+func benchmarkRequests(b *testing.B, handler http.Handler, requests []benchmarkRequest) {
+	for _, req := range requests {
+		b.Run(req.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				body := strings.NewReader(req.body)
+				w := httptest.NewRecorder()
+				for pb.Next() {
+					// the recorder is reused, so the allocations are the ones of the router
+					body.Reset(req.body)
+					w.Body.Reset()
+					clear(w.HeaderMap)
+					*w = httptest.ResponseRecorder{Code: http.StatusOK, HeaderMap: w.HeaderMap, Body: w.Body}
+					handler.ServeHTTP(w, httptest.NewRequest(req.method, req.target, body))
+					if w.Code != req.status {
+						b.Errorf("unexpected response %d %s", w.Code, w.Body)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkHandler(b *testing.B) {
+	items := benchmarkItems(20)
+	r := NewRouter()
+	RegisterPost(r, "create", func(ctx context.Context, req benchmarkItem) (benchmarkItem, *Error) {
+		return req, nil
+	})
+	RegisterGet(r, "search", func(ctx context.Context, req benchmarkQuery) ([]benchmarkItem, *Error) {
+		return items[:req.Limit], nil
+	})
+
+	benchmarkRequests(b, r.Mux(), []benchmarkRequest{
+		{name: "post", method: "POST", target: "/create", body: `{"id":"1","name":"item","price":9.99,"tags":["a","b","c"]}`, status: http.StatusOK},
+		{name: "get", method: "GET", target: "/search?q=item&limit=20&since=2024-01-15T09:30:00Z", status: http.StatusOK},
+	})
+}
+
+func BenchmarkQueryDecoding(b *testing.B) {
+	r := NewRouter()
+	RegisterGet(r, "flat", func(ctx context.Context, req benchmarkQuery) (TestResponse, *Error) {
+		return TestResponse{Reply: req.Query}, nil
+	})
+	RegisterGet(r, "tags", func(ctx context.Context, req benchmarkTagsQuery) (TestResponse, *Error) {
+		return TestResponse{Reply: strings.Join(req.Tags, ",")}, nil
+	})
+
+	benchmarkRequests(b, r.Mux(), []benchmarkRequest{
+		{name: "flat", method: "GET", target: "/flat?q=item&limit=20&since=2024-01-15T09:30:00Z", status: http.StatusOK},
+		// the repeated key falls back to gorilla schema
+		{name: "repeated key", method: "GET", target: "/flat?q=item&q=other&limit=20", status: http.StatusOK},
+		{name: "slice", method: "GET", target: "/tags?tag=a&tag=b&tag=c&limit=20", status: http.StatusOK},
+		{name: "empty", method: "GET", target: "/flat", status: http.StatusOK},
+	})
+}
+
+func BenchmarkBodyDecoding(b *testing.B) {
+	r := NewRouter()
+	RegisterPost(r, "order", func(ctx context.Context, req benchmarkOrder) (TestResponse, *Error) {
+		return TestResponse{Reply: strconv.Itoa(len(req.Items))}, nil
+	})
+
+	requests := []benchmarkRequest{}
+	for _, n := range []int{1, 100} {
+		data, err := json.Marshal(benchmarkOrder{Items: benchmarkItems(n)})
+		if err != nil {
+			b.Fatal(err)
+		}
+		requests = append(requests, benchmarkRequest{name: strconv.Itoa(n) + " items", method: "POST", target: "/order", body: string(data), status: http.StatusOK})
+	}
+	benchmarkRequests(b, r.Mux(), requests)
+}
+
+func BenchmarkErrors(b *testing.B) {
+	r := NewRouter()
+	RegisterPost(r, "create", func(ctx context.Context, req benchmarkItem) (benchmarkItem, *Error) {
+		if req.ID == "missing" {
+			return benchmarkItem{}, &Error{Code: "NOT_FOUND", Message: "no item " + req.ID}
+		}
+		return req, nil
+	})
+	RegisterGet(r, "search", func(ctx context.Context, req benchmarkQuery) (TestResponse, *Error) {
+		return TestResponse{Reply: req.Query}, nil
+	})
+
+	benchmarkRequests(b, r.Mux(), []benchmarkRequest{
+		{name: "handler error", method: "POST", target: "/create", body: `{"id":"missing","name":"item"}`, status: GlobalOpts.MapCodeToStatus("NOT_FOUND")},
+		{name: "validation", method: "POST", target: "/create", body: `{"id":"1"}`, status: http.StatusUnprocessableEntity},
+		{name: "malformed body", method: "POST", target: "/create", body: `{"id":`, status: http.StatusBadRequest},
+		{name: "malformed query", method: "GET", target: "/search?limit=many", status: http.StatusBadRequest},
+	})
+}
+
+func BenchmarkMiddlewares(b *testing.B) {
+	for _, n := range []int{0, 1, 5, 10} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			r := NewRouter()
+			for range n {
+				r.Use(func(next http.Handler) http.Handler {
+					return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+						w.Header().Set("X-Middleware", "1")
+						next.ServeHTTP(w, req)
+					})
+				})
+			}
+			RegisterPost(r, "create", func(ctx context.Context, req benchmarkItem) (benchmarkItem, *Error) {
+				return req, nil
+			})
+
+			benchmarkRequests(b, r.Mux(), []benchmarkRequest{
+				{name: "post", method: "POST", target: "/create", body: `{"id":"1","name":"item","price":9.99,"tags":["a"]}`, status: http.StatusOK},
+			})
+		})
+	}
+}
@@ -1,13 +1,36 @@
 package vel
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/dennypenta/vel/msgpack"
+	"github.com/google/uuid"
+	"github.com/gorilla/schema"
 )
 
 type TestRequest struct {
@@ -194,3 +217,3206 @@ func TestSubrouterMetadata(t *testing.T) {
 		t.Errorf("expected posts operation, got %s", v2Meta[0].OperationID)
 	}
 }
+
+type Celsius float64
+
+type QueryWithTypes struct {
+	Since time.Time `schema:"since"`
+	Temp  Celsius   `schema:"temp"`
+}
+
+func TestGetQueryConverters(t *testing.T) {
+	RegisterQueryConverter(func(s string) (Celsius, error) {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(s, "C"), 64)
+		return Celsius(v), err
+	})
+
+	r := NewRouter()
+	RegisterGet(r, "query", func(ctx context.Context, req QueryWithTypes) (TestResponse, *Error) {
+		return TestResponse{Reply: fmt.Sprintf("%s %.1f", req.Since.Format(time.DateOnly), req.Temp)}, nil
+	})
+	server := httptest.NewServer(r.Mux())
+	defer server.Close()
+
+	for _, tc := range []struct {
+		query     string
+		wantCode  int
+		wantReply string
+	}{
+		{"since=2024-06-01T10:00:00Z&temp=21.5C", 200, "2024-06-01 21.5"},
+		{"since=2024-06-01T10:00:00.123%2B02:00&temp=3C", 200, "2024-06-01 3.0"},
+		{"since=yesterday&temp=3C", 400, ""},
+		{"since=2024-06-01T10:00:00Z&temp=warm", 400, ""},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			resp, err := http.Get(server.URL + "/query?" + tc.query)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tc.wantCode {
+				t.Fatalf("expected status %d, got %d", tc.wantCode, resp.StatusCode)
+			}
+			if tc.wantReply == "" {
+				return
+			}
+			var got TestResponse
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if got.Reply != tc.wantReply {
+				t.Errorf("expected reply %s, got %s", tc.wantReply, got.Reply)
+			}
+		})
+	}
+
+	// converters may be registered while other routers are built, e.g. in parallel tests
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			RegisterQueryConverter(func(s string) (Celsius, error) {
+				v, err := strconv.ParseFloat(s, 64)
+				return Celsius(v), err
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			newQueryDecoder(reflect.TypeFor[QueryWithTypes]())
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCacheControlFromSpec(t *testing.T) {
+	r := NewRouter()
+	cached := RegisterGet(r, "cached", func(ctx context.Context, req struct{}) (TestResponse, *Error) {
+		return TestResponse{Reply: "ok"}, nil
+	})
+	RegisterGet(r, "private", func(ctx context.Context, req struct{}) (TestResponse, *Error) {
+		return TestResponse{Reply: "ok"}, nil
+	}).SetSpec(Spec{Cache: CachePolicy{NoStore: true}})
+	RegisterGet(r, "failing", func(ctx context.Context, req struct{}) (TestResponse, *Error) {
+		return TestResponse{}, &Error{Code: "FAILED"}
+	}).SetSpec(Spec{Cache: CachePolicy{MaxAge: time.Minute}})
+	// the spec is set after other handlers are registered on purpose
+	cached.SetSpec(Spec{Cache: CachePolicy{MaxAge: 90 * time.Second, Public: true}})
+
+	server := httptest.NewServer(r.Mux())
+	defer server.Close()
+
+	for path, want := range map[string]string{
+		"/cached":  "public, max-age=90",
+		"/private": "no-store",
+		"/failing": "",
+	} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("Cache-Control"); got != want {
+			t.Errorf("%s: expected Cache-Control %q, got %q", path, want, got)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	r := NewRouter()
+	RegisterGet(r, "users", func(ctx context.Context, req struct{}) (TestResponse, *Error) {
+		return TestResponse{}, nil
+	}).SetSpec(Spec{Description: "list users"})
+	RegisterPost(r, "users", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		return TestResponse{}, nil
+	}).SetSpec(Spec{Description: "create user"})
+	// the route middlewares of a later route on the path don't wrap OPTIONS
+	RegisterHandlerFunc(r, HandlerMeta{OperationID: "users", Method: http.MethodDelete, Spec: Spec{Description: "delete users"}}, func(w http.ResponseWriter, r *http.Request) {}, NoopMiddleware)
+	// subrouters sharing a prefix serve OPTIONS of a path with the middlewares of the first one
+	RegisterGet(r.Subrouter("v2"), "items", func(ctx context.Context, req struct{}) (TestResponse, *Error) {
+		return TestResponse{}, nil
+	}).SetSpec(Spec{Description: "list items"})
+	RegisterPost(r.Subrouter("v2"), "items", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		return TestResponse{}, nil
+	}).SetSpec(Spec{Description: "create item"})
+	r.Use(NoopMiddleware)
+	v1 := r.Subrouter("v1")
+	RegisterGet(v1, "posts", func(ctx context.Context, req struct{}) (TestResponse, *Error) {
+		return TestResponse{}, nil
+	})
+
+	kinds := make(map[DiagnosticKind]int)
+	for _, d := range r.Diagnostics() {
+		kinds[d.Kind]++
+	}
+	want := map[DiagnosticKind]int{
+		DiagnosticDuplicateOptions: 2,
+		DiagnosticLateMiddleware:   1,
+		DiagnosticRouteWithoutSpec: 1,
+		// the default global options aren't reported
+		DiagnosticGlobalOptsUnset: 0,
+	}
+	for kind, n := range want {
+		if kinds[kind] != n {
+			t.Errorf("expected %d %s diagnostics, got %d", n, kind, kinds[kind])
+		}
+	}
+
+	buf := &strings.Builder{}
+	logger := slog.New(slog.NewTextHandler(buf, nil))
+	r.LogDiagnostics(logger)
+	r.LogDiagnostics(logger)
+	if lines := strings.Count(buf.String(), "\n"); lines != 4 {
+		t.Errorf("expected every diagnostic to be logged once, got %d lines:\n%s", lines, buf.String())
+	}
+
+	t.Run("cleared global opts", func(t *testing.T) {
+		prev := GlobalOpts.MapCodeToStatus
+		GlobalOpts.MapCodeToStatus = nil
+		defer func() { GlobalOpts.MapCodeToStatus = prev }()
+		var unset int
+		for _, d := range r.Diagnostics() {
+			if d.Kind == DiagnosticGlobalOptsUnset {
+				unset++
+			}
+		}
+		if unset != 1 {
+			t.Errorf("expected the nil MapCodeToStatus reported, got %d", unset)
+		}
+	})
+}
+
+func TestErrorEncoder(t *testing.T) {
+	r := NewRouter()
+	r.SetErrorEncoder(JSONErrorEncoder{
+		ErrorSchema: ErrorSchema{
+			Envelope:  "error",
+			CodeField: "type",
+			Extra:     []KeyValueSpec{{Key: "requestId", ValueType: String}},
+		},
+		ExtraFields: func(r *http.Request, e *Error) map[string]any {
+			return map[string]any{"requestId": r.Header.Get("X-Request-Id")}
+		},
+	})
+	v1 := r.Subrouter("v1")
+	RegisterPost(v1, "fail", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		return TestResponse{}, &Error{Code: "NOPE", Message: "not today"}
+	})
+
+	for body, want := range map[string]string{
+		`{"message":"hi"}`: `{"error":{"message":"not today","requestId":"42","type":"NOPE"}}`,
+		`{`:                `{"error":{"requestId":"42","type":"FAILED_DECODING_REQUEST_BODY"}}`,
+	} {
+		req := httptest.NewRequest("POST", "/v1/fail", strings.NewReader(body))
+		req.Header.Set("X-Request-Id", "42")
+		rec := httptest.NewRecorder()
+		r.Mux().ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", rec.Code)
+		}
+		if got := strings.TrimSpace(rec.Body.String()); got != want {
+			t.Errorf("expected body %s, got %s", want, got)
+		}
+	}
+}
+
+func TestErrorMetaOrder(t *testing.T) {
+	e := &Error{Code: "LIMITED", Meta: map[string]string{"window": "1m", "limit": "10", "retryAfter": "5"}}
+	want := `{"code":"LIMITED","meta":{"limit":"10","retryAfter":"5","window":"1m"}}`
+	if got := e.JsonString(); got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+
+	buf := &strings.Builder{}
+	slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+		if a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	}})).Info("failed", "meta", e.Meta)
+	if got := buf.String(); got != "level=INFO msg=failed meta.limit=10 meta.retryAfter=5 meta.window=1m\n" {
+		t.Errorf("unexpected log record %q", got)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	r := NewRouter()
+	RegisterGet(r, "poll", func(ctx context.Context, req struct{}) (TestResponse, *Error) {
+		time.Sleep(50 * time.Millisecond)
+		return TestResponse{Reply: "ok"}, nil
+	}, Heartbeat(10*time.Millisecond, JSONHeartbeat))
+	RegisterGet(r, "events", func(ctx context.Context, req struct{}) (struct{}, *Error) {
+		w := WriterFromContext(ctx)
+		w.Header().Set("Content-Type", "text/event-stream")
+		if err := WriteComment(ctx, "connected"); err != nil {
+			return struct{}{}, &Error{Code: "FLUSH_FAILED", Err: err}
+		}
+		fmt.Fprint(w, "data: done\n\n")
+		return struct{}{}, nil
+	})
+
+	server := httptest.NewServer(r.Mux())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/poll")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.HasPrefix(string(body), JSONHeartbeat) {
+		t.Errorf("expected heartbeats before the response, got %q", body)
+	}
+	var res TestResponse
+	if err := json.Unmarshal(body, &res); err != nil || res.Reply != "ok" {
+		t.Errorf("expected a valid JSON response after heartbeats, got %q: %v", body, err)
+	}
+
+	resp, err = http.Get(server.URL + "/events")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != ": connected\n\ndata: done\n\n" {
+		t.Errorf("unexpected event stream %q", body)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	r := NewRouter()
+	v1 := r.Subrouter("/v1")
+	RegisterGet(v1, "items", func(ctx context.Context, req struct{}) (TestResponse, *Error) {
+		return TestResponse{Reply: "ok"}, nil
+	})
+	RegisterPost(v1, "fail", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		return TestResponse{}, &Error{Code: "FAILED"}
+	})
+	RegisterGet(r.Subrouter("/v2"), "items", func(ctx context.Context, req struct{}) (TestResponse, *Error) {
+		return TestResponse{Reply: "ok"}, nil
+	})
+
+	var recorded []map[string]string
+	rec := MetricsRecorderFunc(func(labels map[string]string, _ time.Duration) {
+		recorded = append(recorded, labels)
+	})
+
+	tests := []struct {
+		name   string
+		opts   MetricsOpts
+		method string
+		path   string
+		want   map[string]string
+	}{
+		{
+			name:   "matched route",
+			method: "GET",
+			path:   "/v1/items",
+			want:   map[string]string{"operation": "v1/items", "method": "GET", "status": "200"},
+		},
+		{
+			name:   "same operation in another subrouter",
+			method: "GET",
+			path:   "/v2/items",
+			want:   map[string]string{"operation": "v2/items", "method": "GET", "status": "200"},
+		},
+		{
+			name:   "failed route",
+			method: "POST",
+			path:   "/v1/fail",
+			want:   map[string]string{"operation": "v1/fail", "method": "POST", "status": "400"},
+		},
+		{
+			name:   "route registered on mux",
+			method: "GET",
+			path:   "/healthz",
+			want:   map[string]string{"operation": "GET /healthz", "method": "GET", "status": "200"},
+		},
+		{
+			name:   "unknown path",
+			method: "GET",
+			path:   "/v1/items/123",
+			want:   map[string]string{"operation": MetricUnmatched, "method": "GET", "status": "404"},
+		},
+		{
+			name:   "unknown method",
+			method: "PURGE",
+			path:   "/random",
+			want:   map[string]string{"operation": MetricUnmatched, "method": "OTHER", "status": "404"},
+		},
+		{
+			name:   "selected labels with status class",
+			opts:   MetricsOpts{Labels: []MetricLabel{MetricLabelOperation, MetricLabelStatus}, StatusClass: true},
+			method: "GET",
+			path:   "/nope",
+			want:   map[string]string{"operation": MetricUnmatched, "status": "4xx"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorded = nil
+			body := strings.NewReader(`{"message":"hi"}`)
+			req := httptest.NewRequest(tt.method, tt.path, body)
+			r.MetricsHandler(rec, tt.opts).ServeHTTP(httptest.NewRecorder(), req)
+
+			if len(recorded) != 1 {
+				t.Fatalf("expected 1 recorded request, got %d", len(recorded))
+			}
+			if fmt.Sprint(recorded[0]) != fmt.Sprint(tt.want) {
+				t.Errorf("expected labels %v, got %v", tt.want, recorded[0])
+			}
+		})
+	}
+}
+
+func TestOptionsAllow(t *testing.T) {
+	r := NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			next.ServeHTTP(w, r)
+		})
+	})
+	called := false
+	RegisterPost(r, "users", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		called = true
+		return TestResponse{}, nil
+	})
+	v1 := r.Subrouter("v1")
+	RegisterPost(v1, "posts", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		called = true
+		return TestResponse{}, nil
+	})
+	RegisterPost(r, "comments", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		called = true
+		return TestResponse{}, nil
+	}, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Access-Control-Allow-Headers", "X-Comment")
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	server := httptest.NewServer(r.Mux())
+	defer server.Close()
+
+	options := func(path string) *http.Response {
+		req, _ := http.NewRequest(http.MethodOptions, server.URL+path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := options("/users")
+	if got := resp.Header.Get("Allow"); got != "OPTIONS, POST" {
+		t.Errorf("expected Allow %q, got %q", "OPTIONS, POST", got)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+	if resp.Header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Error("expected router middlewares to serve OPTIONS")
+	}
+	resp = options("/comments")
+	if resp.Header.Get("Access-Control-Allow-Headers") != "X-Comment" || resp.Header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Error("expected route and router middlewares to serve OPTIONS")
+	}
+	if called {
+		t.Error("expected OPTIONS not to call the business handler")
+	}
+
+	// methods registered after the first request are reflected too
+	RegisterGet(r, "users", func(ctx context.Context, req struct{}) (TestResponse, *Error) {
+		return TestResponse{}, nil
+	})
+	RegisterGet(r.Subrouter("v1"), "posts", func(ctx context.Context, req struct{}) (TestResponse, *Error) {
+		return TestResponse{}, nil
+	})
+	for _, path := range []string{"/users", "/v1/posts"} {
+		if got := options(path).Header.Get("Allow"); got != "GET, HEAD, OPTIONS, POST" {
+			t.Errorf("%s: expected Allow %q, got %q", path, "GET, HEAD, OPTIONS, POST", got)
+		}
+	}
+}
+
+type CreateUserRequest struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+func (r CreateUserRequest) Validate() []Violation {
+	var violations []Violation
+	if len(r.Name) < 3 {
+		violations = append(violations, ViolationMinLen("name", 3))
+	}
+	if r.Role != "admin" && r.Role != "user" {
+		violations = append(violations, ViolationEnum("role", []string{"admin", "user"}))
+	}
+	return violations
+}
+
+func TestValidation(t *testing.T) {
+	called := false
+	r := NewRouter()
+	RegisterPost(r, "users", func(ctx context.Context, req CreateUserRequest) (TestResponse, *Error) {
+		called = true
+		return TestResponse{Reply: req.Name}, nil
+	})
+
+	tests := []struct {
+		name         string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "valid request",
+			body:         `{"name":"john","role":"admin"}`,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"reply":"john"}`,
+		},
+		{
+			name:         "violations",
+			body:         `{"name":"jo","role":"root"}`,
+			expectedCode: http.StatusUnprocessableEntity,
+			expectedBody: `{"code":"VALIDATION_FAILED","violations":[{"field":"name","rule":"min_len","params":{"min":"3"}},{"field":"role","rule":"enum","params":{"values":"admin,user"}}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called = false
+			w := httptest.NewRecorder()
+			r.Mux().ServeHTTP(w, httptest.NewRequest("POST", "/users", strings.NewReader(tt.body)))
+
+			if w.Code != tt.expectedCode {
+				t.Errorf("expected status %d, got %d", tt.expectedCode, w.Code)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.expectedBody {
+				t.Errorf("expected body %s, got %s", tt.expectedBody, got)
+			}
+			if called != (tt.expectedCode == http.StatusOK) {
+				t.Errorf("expected the handler to be called only for a valid request")
+			}
+		})
+	}
+}
+
+func TestCachePolicyKey(t *testing.T) {
+	policy := CachePolicy{
+		MaxAge:      time.Minute,
+		IgnoreQuery: []string{"trace", "signature"},
+		VaryHeaders: []string{"x-tenant"},
+	}
+
+	tests := []struct {
+		name     string
+		url      string
+		tenant   string
+		expected string
+	}{
+		{
+			name:     "ignored params are dropped, the rest is sorted",
+			url:      "/items?trace=abc&b=2&a=1&signature=xyz",
+			tenant:   "acme",
+			expected: "/items?a=1&b=2\nX-Tenant: acme",
+		},
+		{
+			name:     "no params left",
+			url:      "/items?trace=abc",
+			expected: "/items\nX-Tenant: ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.url, nil)
+			if tt.tenant != "" {
+				r.Header.Set("X-Tenant", tt.tenant)
+			}
+			if got := policy.Key(r); got != tt.expected {
+				t.Errorf("expected key %q, got %q", tt.expected, got)
+			}
+		})
+	}
+
+	r := NewRouter()
+	RegisterGet(r, "items", func(ctx context.Context, req struct{}) (TestResponse, *Error) {
+		return TestResponse{Reply: "ok"}, nil
+	}).SetSpec(Spec{Cache: policy})
+
+	w := httptest.NewRecorder()
+	r.Mux().ServeHTTP(w, httptest.NewRequest("GET", "/items", nil))
+	if got := w.Header().Get("Vary"); got != "x-tenant" {
+		t.Errorf("expected Vary %q, got %q", "x-tenant", got)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	type ItemsRequest struct {
+		Category string `schema:"category"`
+	}
+	down := false
+	r := NewRouter()
+	RegisterGet(r, "items", func(ctx context.Context, req ItemsRequest) (TestResponse, *Error) {
+		if down {
+			return TestResponse{}, &Error{Code: UpstreamUnavailableCode}
+		}
+		if req.Category == "" {
+			return TestResponse{}, &Error{Code: "CATEGORY_REQUIRED"}
+		}
+		return TestResponse{Reply: req.Category}, nil
+	}).SetSpec(Spec{Fallback: Fallback{
+		Codes:  []string{UpstreamUnavailableCode},
+		Body:   TestResponse{Reply: "static"},
+		Cached: true,
+	}})
+
+	var recorded []map[string]string
+	handler := r.MetricsHandler(MetricsRecorderFunc(func(labels map[string]string, _ time.Duration) {
+		recorded = append(recorded, labels)
+	}), MetricsOpts{Labels: []MetricLabel{MetricLabelStatus, MetricLabelFallback}})
+
+	tests := []struct {
+		name            string
+		down            bool
+		path            string
+		authorization   string
+		expectedCode    int
+		expectedBody    string
+		expectedWarning string
+		expectedLabels  map[string]string
+	}{
+		{
+			name:           "success is cached",
+			path:           "/items?category=books",
+			expectedCode:   http.StatusOK,
+			expectedBody:   `{"reply":"books"}`,
+			expectedLabels: map[string]string{"status": "200", "fallback": "false"},
+		},
+		{
+			name:           "other codes aren't handled",
+			path:           "/items",
+			expectedCode:   http.StatusBadRequest,
+			expectedBody:   `{"code":"CATEGORY_REQUIRED"}`,
+			expectedLabels: map[string]string{"status": "400", "fallback": "false"},
+		},
+		{
+			name:            "cached fallback",
+			down:            true,
+			path:            "/items?category=books",
+			expectedCode:    http.StatusOK,
+			expectedBody:    `{"reply":"books"}`,
+			expectedWarning: FallbackWarningStale,
+			expectedLabels:  map[string]string{"status": "200", "fallback": "true"},
+		},
+		{
+			name:           "success with credentials isn't cached",
+			path:           "/items?category=games",
+			authorization:  "Bearer alice",
+			expectedCode:   http.StatusOK,
+			expectedBody:   `{"reply":"games"}`,
+			expectedLabels: map[string]string{"status": "200", "fallback": "false"},
+		},
+		{
+			name:            "cached response isn't served to credentials",
+			down:            true,
+			path:            "/items?category=books",
+			authorization:   "Bearer bob",
+			expectedCode:    http.StatusOK,
+			expectedBody:    `{"reply":"static"}`,
+			expectedWarning: FallbackWarningStatic,
+			expectedLabels:  map[string]string{"status": "200", "fallback": "true"},
+		},
+		{
+			name:            "static fallback without a cached response",
+			down:            true,
+			path:            "/items?category=games",
+			expectedCode:    http.StatusOK,
+			expectedBody:    `{"reply":"static"}`,
+			expectedWarning: FallbackWarningStatic,
+			expectedLabels:  map[string]string{"status": "200", "fallback": "true"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			down = tt.down
+			recorded = nil
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedCode {
+				t.Errorf("expected status %d, got %d", tt.expectedCode, w.Code)
+			}
+			if body := strings.TrimSpace(w.Body.String()); body != tt.expectedBody {
+				t.Errorf("expected body %s, got %s", tt.expectedBody, body)
+			}
+			if warning := w.Header().Get("Warning"); warning != tt.expectedWarning {
+				t.Errorf("expected warning %q, got %q", tt.expectedWarning, warning)
+			}
+			if len(recorded) != 1 || fmt.Sprint(recorded[0]) != fmt.Sprint(tt.expectedLabels) {
+				t.Errorf("expected labels %v, got %v", tt.expectedLabels, recorded)
+			}
+		})
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	processed := false
+	prev := GlobalOpts.ProcessErr
+	GlobalOpts.ProcessErr = func(r *http.Request, e *Error) { processed = true }
+	defer func() { GlobalOpts.ProcessErr = prev }()
+
+	r := NewRouter()
+	RegisterGet(r, "items", func(ctx context.Context, req struct{}) (TestResponse, *Error) {
+		return TestResponse{}, Redirect(http.StatusFound, "/v2/items")
+	})
+
+	w := httptest.NewRecorder()
+	r.Mux().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/items", nil))
+
+	if w.Code != http.StatusFound {
+		t.Errorf("expected status %d, got %d", http.StatusFound, w.Code)
+	}
+	if location := w.Header().Get("Location"); location != "/v2/items" {
+		t.Errorf("expected location /v2/items, got %s", location)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("expected empty body, got %s", w.Body.String())
+	}
+	if processed {
+		t.Error("expected redirect not to be processed as an error")
+	}
+
+	for _, status := range []int{http.StatusOK, http.StatusBadRequest, 309} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected Redirect to panic on %d", status)
+				}
+			}()
+			Redirect(status, "/v2/items")
+		}()
+	}
+}
+
+func TestExamplesEndpoint(t *testing.T) {
+	type ItemsRequest struct {
+		Category string `schema:"category"`
+	}
+	r := NewRouter()
+	RegisterPost(r, "echo", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		return TestResponse{Reply: req.Message}, nil
+	}).SetSpec(Spec{Examples: []Example{{Name: "hello", Request: TestRequest{Message: "hello"}}}})
+	RegisterGet(r, "items", func(ctx context.Context, req ItemsRequest) (TestResponse, *Error) {
+		return TestResponse{Reply: req.Category}, nil
+	}).SetSpec(Spec{Examples: []Example{{Name: "books", Request: ItemsRequest{Category: "books"}}}})
+	RegisterGet(r, "plain", func(ctx context.Context, req struct{}) (TestResponse, *Error) {
+		return TestResponse{}, nil
+	})
+	r.RegisterExamplesEndpoint(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("X-Admin") != "yes" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	})
+
+	serve := func(method, path, form string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(form))
+		if form != "" {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.Mux().ServeHTTP(w, req)
+		return w
+	}
+	admin := map[string]string{"X-Admin": "yes"}
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		form         string
+		headers      map[string]string
+		expectedCode int
+		expectedBody []string
+	}{
+		{
+			name:         "unauthorized",
+			method:       http.MethodGet,
+			path:         "/examples/echo",
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "index lists operations with examples",
+			method:       http.MethodGet,
+			path:         "/examples",
+			headers:      admin,
+			expectedCode: http.StatusOK,
+			expectedBody: []string{`href="/examples/echo"`, `href="/examples/items"`},
+		},
+		{
+			name:         "operation without examples",
+			method:       http.MethodGet,
+			path:         "/examples/plain",
+			headers:      admin,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "json payload",
+			method:       http.MethodGet,
+			path:         "/examples/echo",
+			headers:      admin,
+			expectedCode: http.StatusOK,
+			expectedBody: []string{"POST /echo", "&#34;message&#34;: &#34;hello&#34;"},
+		},
+		{
+			name:         "query payload",
+			method:       http.MethodGet,
+			path:         "/examples/items",
+			headers:      admin,
+			expectedCode: http.StatusOK,
+			expectedBody: []string{"GET /items", "category=books"},
+		},
+		{
+			name:         "fire json payload",
+			method:       http.MethodPost,
+			path:         "/examples/echo",
+			form:         "route=0&name=hello&payload=" + `{"message":"edited"}`,
+			headers:      admin,
+			expectedCode: http.StatusOK,
+			expectedBody: []string{"hello: 200", "&#34;reply&#34;: &#34;edited&#34;"},
+		},
+		{
+			name:         "fire query payload",
+			method:       http.MethodPost,
+			path:         "/examples/items",
+			form:         "route=0&name=books&payload=category%3Dgames",
+			headers:      admin,
+			expectedCode: http.StatusOK,
+			expectedBody: []string{"books: 200", "&#34;reply&#34;: &#34;games&#34;"},
+		},
+		{
+			name:         "cross-site submission",
+			method:       http.MethodPost,
+			path:         "/examples/echo",
+			form:         "route=0&name=hello&payload={}",
+			headers:      map[string]string{"X-Admin": "yes", "Sec-Fetch-Site": "cross-site"},
+			expectedCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.method, tt.path, tt.form, tt.headers)
+			if w.Code != tt.expectedCode {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedCode, w.Code, w.Body.String())
+			}
+			for _, expected := range tt.expectedBody {
+				if !strings.Contains(w.Body.String(), expected) {
+					t.Errorf("expected body to contain %s, got %s", expected, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestSpecHashMiddleware(t *testing.T) {
+	handler := SpecHashMiddleware("current")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name             string
+		clientHash       string
+		expectedOutdated string
+	}{
+		{name: "current client", clientHash: "current"},
+		{name: "outdated client", clientHash: "previous", expectedOutdated: "true"},
+		{name: "request without hash"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.clientHash != "" {
+				req.Header.Set(SpecHashHeader, tt.clientHash)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if outdated := w.Header().Get(SpecOutdatedHeader); outdated != tt.expectedOutdated {
+				t.Errorf("expected outdated %q, got %q", tt.expectedOutdated, outdated)
+			}
+		})
+	}
+}
+
+func TestDuplicateRoutes(t *testing.T) {
+	register := func(r *Router, reply string) *HandlerMeta {
+		return RegisterPost(r, "items", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+			return TestResponse{Reply: reply}, nil
+		})
+	}
+	reply := func(r *Router) string {
+		w := httptest.NewRecorder()
+		r.Mux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/items", strings.NewReader(`{}`)))
+		return strings.TrimSpace(w.Body.String())
+	}
+	duplicates := func(r *Router) int {
+		n := 0
+		for _, d := range r.Diagnostics() {
+			if d.Kind == DiagnosticDuplicateRoute {
+				n++
+			}
+		}
+		return n
+	}
+
+	t.Run("panic", func(t *testing.T) {
+		handler := func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+			return TestResponse{}, nil
+		}
+		r := NewRouter()
+		RegisterPost(r.Subrouter("v1"), "items", handler)
+		defer func() {
+			err, ok := recover().(*DuplicateRouteError)
+			if !ok {
+				t.Fatal("expected a duplicate route error")
+			}
+			if err.Pattern != "POST /v1/items" {
+				t.Errorf("expected pattern POST /v1/items, got %s", err.Pattern)
+			}
+			if !strings.Contains(err.First, "router_test.go:") || !strings.Contains(err.Second, "router_test.go:") || err.First == err.Second {
+				t.Errorf("expected both call sites in the test, got %s and %s", err.First, err.Second)
+			}
+		}()
+		RegisterPost(r.Subrouter("v1"), "items", handler)
+	})
+
+	t.Run("builtin", func(t *testing.T) {
+		r := NewRouter()
+		defer func() {
+			err, ok := recover().(*DuplicateRouteError)
+			if !ok || !strings.HasPrefix(err.First, "NewRouter at ") {
+				t.Errorf("expected the health check to be registered by NewRouter, got %v", err)
+			}
+		}()
+		RegisterGet(r, "healthz", func(ctx context.Context, req struct{}) (TestResponse, *Error) {
+			return TestResponse{}, nil
+		})
+	})
+
+	t.Run("keep first", func(t *testing.T) {
+		defer func(prev DuplicateRoutePolicy) { GlobalOpts.DuplicateRoutes = prev }(GlobalOpts.DuplicateRoutes)
+		GlobalOpts.DuplicateRoutes = DuplicateRouteKeepFirst
+
+		r := NewRouter()
+		register(r.Subrouter("v1"), "first")
+		second := r.Subrouter("v1")
+		register(second, "second")
+
+		if got := reply(r); got != `{"reply":"first"}` {
+			t.Errorf("expected the first handler to serve, got %s", got)
+		}
+		if len(second.Meta()) != 0 {
+			t.Errorf("expected the ignored route not to be in the meta, got %d routes", len(second.Meta()))
+		}
+		if n := duplicates(r); n != 1 {
+			t.Errorf("expected 1 duplicate route diagnostic, got %d", n)
+		}
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		defer func(prev DuplicateRoutePolicy) { GlobalOpts.DuplicateRoutes = prev }(GlobalOpts.DuplicateRoutes)
+		GlobalOpts.DuplicateRoutes = DuplicateRouteReplace
+
+		r := NewRouter()
+		first := r.Subrouter("v1")
+		register(first, "first")
+		second := r.Subrouter("v1")
+		meta := register(second, "second")
+
+		if got := reply(r); got != `{"reply":"second"}` {
+			t.Errorf("expected the second handler to serve, got %s", got)
+		}
+		if len(first.Meta()) != 0 || len(second.Meta()) != 1 {
+			t.Errorf("expected the route to move to the second router, got %d and %d routes", len(first.Meta()), len(second.Meta()))
+		}
+		if r.shared.patterns["POST /v1/items"] != meta {
+			t.Error("expected the pattern to refer to the second route")
+		}
+		if n := duplicates(r); n != 1 {
+			t.Errorf("expected 1 duplicate route diagnostic, got %d", n)
+		}
+	})
+}
+
+func TestShutdownStreams(t *testing.T) {
+	r := NewRouter()
+	// the events handler ignores the shutdown, its context is canceled after the grace period
+	RegisterHandlerFunc(r, HandlerMeta{OperationID: "events", Method: http.MethodGet}, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: hello\n\n")
+		http.NewResponseController(w).Flush()
+		<-req.Context().Done()
+	}, Stream(SSEShutdownNotice))
+	// the socket handler says goodbye itself
+	RegisterHandlerFunc(r, HandlerMeta{OperationID: "socket", Method: http.MethodGet}, func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, "open\n")
+		http.NewResponseController(w).Flush()
+		<-Draining(req.Context())
+		io.WriteString(w, "bye\n")
+	}, Stream(""))
+
+	server := httptest.NewServer(r.Mux())
+	defer server.Close()
+	open := func(path, first string) *bufio.Reader {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		body := bufio.NewReader(resp.Body)
+		if line, _ := body.ReadString('\n'); line != first {
+			t.Fatalf("expected %q, got %q", first, line)
+		}
+		return body
+	}
+	events := open("/events", "data: hello\n")
+	socket := open("/socket", "open\n")
+
+	start := time.Now()
+	if err := r.Shutdown(server.Config, ShutdownOpts{Grace: 100 * time.Millisecond, Timeout: 5 * time.Second}); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("expected the shutdown to wait the grace period, took %s", elapsed)
+	}
+
+	rest, _ := io.ReadAll(events)
+	if !strings.Contains(string(rest), SSEShutdownNotice) {
+		t.Errorf("expected the shutdown notice, got %q", rest)
+	}
+	rest, _ = io.ReadAll(socket)
+	if string(rest) != "bye\n" {
+		t.Errorf("expected the handler goodbye, got %q", rest)
+	}
+
+	// a stream opened while shutting down is refused
+	w := httptest.NewRecorder()
+	r.Mux().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events", nil))
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), ShuttingDownCode) {
+		t.Errorf("expected 503 %s, got %d %s", ShuttingDownCode, w.Code, w.Body.String())
+	}
+}
+
+func TestServer(t *testing.T) {
+	r := NewRouter()
+	release := make(chan struct{})
+	RegisterGet(r, "slow", func(ctx context.Context, req struct{}) (TestResponse, *Error) {
+		<-release
+		return TestResponse{Reply: "done"}, nil
+	})
+	var hooks []string
+	srv := NewServer(r, nil, ShutdownOpts{DrainDelay: 200 * time.Millisecond, Timeout: 5 * time.Second})
+	srv.OnShutdown(func(ctx context.Context) error {
+		hooks = append(hooks, "db")
+		return nil
+	})
+	srv.OnShutdown(func(ctx context.Context) error {
+		hooks = append(hooks, "cache")
+		return fmt.Errorf("cache flush failed")
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := "http://" + ln.Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(ctx, ln)
+	}()
+	health := func() int {
+		resp, err := http.Get(base + "/healthz")
+		if err != nil {
+			t.Fatalf("healthz failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := health(); status != http.StatusOK {
+		t.Fatalf("expected ready, got %d", status)
+	}
+
+	// the in-flight request is drained
+	slow := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(base + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		slow <- string(body)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	if status := health(); status != http.StatusServiceUnavailable {
+		t.Errorf("expected unready while draining, got %d", status)
+	}
+	close(release)
+	if body := <-slow; body != `{"reply":"done"}`+"\n" {
+		t.Errorf("expected the in-flight request to finish, got %q", body)
+	}
+
+	err = <-served
+	if err == nil || err.Error() != "cache flush failed" {
+		t.Errorf("expected the hook error, got %v", err)
+	}
+	if strings.Join(hooks, ",") != "cache,db" {
+		t.Errorf("expected the hooks in the reverse order, got %v", hooks)
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	r := NewRouter()
+	w := httptest.NewRecorder()
+	r.Mux().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected no probes until they're registered, got %d", w.Code)
+	}
+	r.RegisterHealthEndpoints(HealthOpts{Verbose: true})
+	var dbErr error
+	r.AddHealthCheck("db", func(ctx context.Context) error {
+		return dbErr
+	})
+	r.Subrouter("v1").AddLivenessCheck("worker", func(ctx context.Context) error {
+		return nil
+	})
+	probe := func(path string) (int, HealthReport) {
+		w := httptest.NewRecorder()
+		r.Mux().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var report HealthReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatalf("%s: unexpected body %q", path, w.Body.String())
+		}
+		return w.Code, report
+	}
+	names := func(report HealthReport) string {
+		var names []string
+		for _, check := range report.Checks {
+			names = append(names, check.Name+" "+check.Status+" "+check.Error)
+		}
+		return strings.Join(names, ",")
+	}
+
+	if status, report := probe("/readyz"); status != http.StatusOK || report.Status != HealthStatusOK || names(report) != "db ok ,worker ok " {
+		t.Errorf("expected ready, got %d %+v", status, report)
+	}
+	dbErr = fmt.Errorf("connection refused")
+	if status, report := probe("/readyz"); status != http.StatusServiceUnavailable || report.Status != HealthStatusFailed || names(report) != "db failed connection refused,worker ok " {
+		t.Errorf("expected the failed db, got %d %+v", status, report)
+	}
+	// the liveness doesn't depend on the db
+	if status, report := probe("/livez"); status != http.StatusOK || names(report) != "worker ok " {
+		t.Errorf("expected alive, got %d %+v", status, report)
+	}
+
+	quiet := NewRouter()
+	quiet.AddHealthCheck("db", func(ctx context.Context) error {
+		return dbErr
+	})
+	quiet.RegisterHealthEndpoints(HealthOpts{})
+	w = httptest.NewRecorder()
+	quiet.Mux().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if got := strings.TrimSpace(w.Body.String()); w.Code != http.StatusServiceUnavailable || got != `{"status":"failed","checks":[{"name":"db","status":"failed"}]}` {
+		t.Errorf("expected only the name and the status of the check, got %d %s", w.Code, got)
+	}
+
+	r.shared.unready.Store(true)
+	w = httptest.NewRecorder()
+	r.Mux().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), ShuttingDownCode) {
+		t.Errorf("expected unready while shutting down, got %d %s", w.Code, w.Body.String())
+	}
+	if status, _ := probe("/livez"); status != http.StatusOK {
+		t.Errorf("expected alive while shutting down, got %d", status)
+	}
+}
+
+func TestStatic(t *testing.T) {
+	files := fstest.MapFS{
+		"index.html":   {Data: []byte("<html>app</html>")},
+		"app.js":       {Data: []byte("console.log(1)")},
+		"img/logo.svg": {Data: []byte("<svg/>")},
+	}
+	r := NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("X-Router", "root")
+			next.ServeHTTP(w, req)
+		})
+	})
+	api := r.Subrouter("api")
+	RegisterGet(api, "users", func(ctx context.Context, req struct{}) (TestResponse, *Error) {
+		return TestResponse{Reply: "users"}, nil
+	})
+	api.Static("/assets", files)
+	r.SPA("/", files, "index.html")
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{path: "/api/assets/app.js", status: http.StatusOK, body: "console.log(1)"},
+		{path: "/api/assets/img/logo.svg", status: http.StatusOK, body: "<svg/>"},
+		{path: "/api/assets/missing.js", status: http.StatusNotFound},
+		{path: "/api/users", status: http.StatusOK, body: `{"reply":"users"}` + "\n"},
+		{path: "/", status: http.StatusOK, body: "<html>app</html>"},
+		{path: "/app.js", status: http.StatusOK, body: "console.log(1)"},
+		{path: "/settings/profile", status: http.StatusOK, body: "<html>app</html>"},
+		{path: "/missing.css", status: http.StatusNotFound},
+		{path: "/healthz", status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.Mux().ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.status || (tt.body != "" && w.Body.String() != tt.body) {
+				t.Errorf("unexpected response %d %q", w.Code, w.Body.String())
+			}
+			if w.Header().Get("X-Router") != "root" && tt.path != "/healthz" {
+				t.Error("expected the router middlewares to serve the files")
+			}
+		})
+	}
+
+	if len(r.Meta()) != 0 || len(api.Meta()) != 1 {
+		t.Errorf("expected the files not to be in the meta, got %d and %d routes", len(r.Meta()), len(api.Meta()))
+	}
+}
+
+func TestProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		fmt.Fprintf(w, `{"reply":"%s %s %s"}`, req.URL.Path, req.Header.Get("X-Forwarded-Host"), body)
+	}))
+	defer backend.Close()
+	target, _ := url.Parse(backend.URL)
+
+	r := NewRouter()
+	v1 := r.Subrouter("v1")
+	meta := v1.Proxy("users", target, HandlerMeta{Input: TestRequest{}, Output: TestResponse{}, Spec: Spec{Description: "served by the legacy backend"}})
+	if meta.Method != http.MethodPost || meta.Path != "/v1/users" || meta.Spec.Description == "" {
+		t.Errorf("unexpected meta %+v", meta)
+	}
+	if routes := r.Subrouters()[0].Meta(); len(routes) != 1 || routes[0].OperationID != "users" {
+		t.Errorf("expected the proxied route in the meta, got %+v", routes)
+	}
+
+	w := httptest.NewRecorder()
+	r.Mux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://api.example.com/v1/users", strings.NewReader("hi")))
+	if w.Code != http.StatusOK || w.Body.String() != `{"reply":"/v1/users api.example.com hi"}` {
+		t.Errorf("unexpected response %d %q", w.Code, w.Body.String())
+	}
+
+	unreachable, _ := url.Parse("http://127.0.0.1:1")
+	r.Proxy("down", unreachable, HandlerMeta{})
+	w = httptest.NewRecorder()
+	r.Mux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/down", nil))
+	if w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), ProxyFailedCode) {
+		t.Errorf("expected 502 %s, got %d %s", ProxyFailedCode, w.Code, w.Body.String())
+	}
+}
+
+func TestBatch(t *testing.T) {
+	r := NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("Authorization") == "" {
+				writeError(w, req, http.StatusUnauthorized, &Error{Code: "UNAUTHORIZED"})
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	})
+	RegisterPost(r, "echo", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		if req.Message == "" {
+			return TestResponse{}, &Error{Code: "EMPTY_MESSAGE"}
+		}
+		return TestResponse{Reply: req.Message}, nil
+	})
+	RegisterGet(r, "find", func(ctx context.Context, req benchmarkQuery) (TestResponse, *Error) {
+		return TestResponse{Reply: req.Query + " " + strconv.Itoa(req.Limit)}, nil
+	})
+	RegisterPost(r.Subrouter("v1"), "lookup", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		return TestResponse{}, nil
+	})
+	RegisterPost(r.Subrouter("v2"), "lookup", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		return TestResponse{}, nil
+	})
+	RegisterPost(r, "ping", func(ctx context.Context, req struct{}) (struct{}, *Error) {
+		return struct{}{}, nil
+	})
+	RegisterPost(r, "crash", func(ctx context.Context, req struct{}) (struct{}, *Error) {
+		panic("boom")
+	})
+	RegisterPost(r, "upload", func(ctx context.Context, req []byte) (TestResponse, *Error) {
+		return TestResponse{}, nil
+	})
+	r.RegisterBatchEndpoint(BatchOpts{Concurrency: 2, MaxItems: 5})
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, BatchPath, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer token")
+		w := httptest.NewRecorder()
+		r.Mux().ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`[
+		{"operationId": "find", "body": {"Query": "shoes", "Limit": 3}},
+		{"operationId": "echo", "body": {"message": ""}},
+		{"operationId": "missing"},
+		{"operationId": "ping"}
+	]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+	var results []BatchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`200 {"reply":"shoes 3"}`,
+		`400 {"code":"EMPTY_MESSAGE"}`,
+		`404 {"code":"UNKNOWN_OPERATION","message":"no operation missing"}`,
+		`200 `,
+	}
+	for i, result := range results {
+		if got := strconv.Itoa(result.Status) + " " + string(result.Body); i >= len(want) || got != want[i] {
+			t.Errorf("item %d: unexpected result %s", i, got)
+		}
+	}
+	if len(results) != len(want) {
+		t.Errorf("expected %d results, got %d", len(want), len(results))
+	}
+
+	// the batch keeps the headers, the middlewares see them
+	req := httptest.NewRequest(http.MethodPost, BatchPath, strings.NewReader(`[{"operationId": "ping"}]`))
+	w = httptest.NewRecorder()
+	r.Mux().ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `"status":401`) {
+		t.Errorf("expected the item to be unauthorized, got %s", w.Body.String())
+	}
+	if w = post(`[{"operationId": "ping"}, {"operationId": "ping"}, {"operationId": "ping"}, {"operationId": "ping"}, {"operationId": "ping"}, {"operationId": "ping"}]`); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), BatchTooLargeCode) {
+		t.Errorf("expected the batch to be too large, got %d %s", w.Code, w.Body.String())
+	}
+	if w = post(`[{"operationId": "lookup"}]`); !strings.Contains(w.Body.String(), AmbiguousOperationCode) {
+		t.Errorf("expected the operation to be ambiguous, got %s", w.Body.String())
+	}
+	if w = post(`[{"operationId": "upload"}]`); !strings.Contains(w.Body.String(), `"status":400`) || !strings.Contains(w.Body.String(), UnbatchableOperationCode) {
+		t.Errorf("expected a raw operation to be rejected, got %s", w.Body.String())
+	}
+	if w = post(`[{"operationId": "crash"}, {"operationId": "ping"}]`); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":500`) || !strings.Contains(w.Body.String(), `"status":200`) {
+		t.Errorf("expected a panicking item to fail alone, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSampling(t *testing.T) {
+	var samples []Sample
+	sink := SampleSinkFunc(func(ctx context.Context, sample Sample) {
+		samples = append(samples, sample)
+	})
+
+	type Session struct {
+		Token string `json:"token" schema:"session_token" redact:"true"`
+	}
+	type Login struct {
+		User     string    `json:"user"`
+		Password string    `json:"password"`
+		Sessions []Session `json:"sessions"`
+	}
+	r := NewRouter()
+	RegisterPost(r, "login", func(ctx context.Context, req Login) (TestResponse, *Error) {
+		if req.User == "" {
+			return TestResponse{}, &Error{Code: "NO_USER"}
+		}
+		return TestResponse{Reply: "token"}, nil
+	}, Sampling(sink, SamplingOpts{Rate: 1, RedactFields: []string{"PASSWORD", "reply"}}))
+	RegisterPost(r, "flaky", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		if req.Message == "fail" {
+			return TestResponse{}, &Error{Code: "FAILED"}
+		}
+		return TestResponse{Reply: req.Message}, nil
+	}, Sampling(sink, SamplingOpts{Rate: 1, ErrorThreshold: 0.4, ErrorWindow: 50 * time.Millisecond}))
+	RegisterGet(r, "off", func(ctx context.Context, req struct{}) (TestResponse, *Error) {
+		return TestResponse{}, nil
+	}, Sampling(sink, SamplingOpts{Rate: 0}))
+
+	serve := func(method, path, body string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		r.Mux().ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve("POST", "/login?password=123&user=bob&session_token=abc", `{"user":"bob","password":"hunter2","sessions":[{"token":"tok_1"}]}`)
+	serve("GET", "/off", "")
+	if len(samples) != 1 {
+		t.Fatalf("expected 1 sample, got %d", len(samples))
+	}
+	sample := samples[0]
+	if sample.OperationID != "login" || sample.Status != http.StatusOK {
+		t.Errorf("unexpected sample %s %d", sample.OperationID, sample.Status)
+	}
+	// the tagged fields are redacted at any depth
+	if got := string(sample.RequestBody); got != `{"password":"[REDACTED]","sessions":[{"token":"[REDACTED]"}],"user":"bob"}` {
+		t.Errorf("unexpected request body %s", got)
+	}
+	if got := string(sample.ResponseBody); got != `{"reply":"[REDACTED]"}` {
+		t.Errorf("unexpected response body %s", got)
+	}
+	if got := sample.RequestHeaders.Get("Authorization"); got != Redacted {
+		t.Errorf("expected the authorization to be redacted, got %s", got)
+	}
+	if sample.URL != "/login?password=%5BREDACTED%5D&session_token=%5BREDACTED%5D&user=bob" {
+		t.Errorf("unexpected url %s", sample.URL)
+	}
+
+	// the flaky route is captured while its error rate is above the threshold
+	samples = nil
+	serve("POST", "/flaky", `{"message":"ok"}`)
+	serve("POST", "/flaky", `{"message":"fail"}`)
+	if len(samples) != 0 {
+		t.Fatalf("expected no samples below the threshold, got %d", len(samples))
+	}
+	serve("POST", "/flaky", `{"message":"fail"}`)
+	serve("POST", "/flaky", `{"message":"ok"}`)
+	if len(samples) != 2 || samples[0].Status != http.StatusBadRequest {
+		t.Fatalf("expected 2 samples above the threshold, got %d", len(samples))
+	}
+	// the errors are forgotten after two windows
+	time.Sleep(110 * time.Millisecond)
+	samples = nil
+	serve("POST", "/flaky", `{"message":"ok"}`)
+	if len(samples) != 0 {
+		t.Errorf("expected no samples once the error rate normalizes, got %d", len(samples))
+	}
+}
+
+func TestStreamItems(t *testing.T) {
+	r := NewRouter()
+	handler := func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		if req.Message == "early" {
+			return TestResponse{}, &Error{Code: "EARLY"}
+		}
+		for _, reply := range []string{"a", "b"} {
+			if err := WriteItem(ctx, TestResponse{Reply: reply}); err != nil {
+				return TestResponse{}, &Error{Code: "WRITE", Err: err}
+			}
+		}
+		if req.Message == "late" {
+			return TestResponse{}, &Error{Code: "LATE"}
+		}
+		return TestResponse{}, nil
+	}
+	RegisterPost(r, "events", handler).SetSpec(Spec{Stream: StreamSSE})
+	RegisterPost(r, "lines", handler).SetSpec(Spec{Stream: StreamNDJSON})
+	RegisterPost(r, "plain", handler)
+
+	for _, tc := range []struct {
+		path, message string
+		status        int
+		contentType   string
+		body          string
+	}{
+		{"/events", "", http.StatusOK, "text/event-stream", "data: {\"reply\":\"a\"}\n\ndata: {\"reply\":\"b\"}\n\n"},
+		{"/events", "late", http.StatusOK, "text/event-stream", "data: {\"reply\":\"a\"}\n\ndata: {\"reply\":\"b\"}\n\nevent: error\ndata: {\"code\":\"LATE\"}\n\n"},
+		{"/lines", "late", http.StatusOK, "application/x-ndjson", "{\"reply\":\"a\"}\n{\"reply\":\"b\"}\n{\"$error\":{\"code\":\"LATE\"}}\n"},
+		{"/lines", "early", http.StatusBadRequest, "", "{\"code\":\"EARLY\"}\n"},
+		// a route without the stream spec can't write items
+		{"/plain", "", http.StatusBadRequest, "", "{\"code\":\"WRITE\"}\n"},
+	} {
+		t.Run(tc.path+" "+tc.message, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.Mux().ServeHTTP(w, httptest.NewRequest("POST", tc.path, strings.NewReader(`{"message":"`+tc.message+`"}`)))
+			if w.Code != tc.status {
+				t.Errorf("expected status %d, got %d", tc.status, w.Code)
+			}
+			if got := w.Header().Get("Content-Type"); got != tc.contentType {
+				t.Errorf("expected content type %q, got %q", tc.contentType, got)
+			}
+			if w.Body.String() != tc.body {
+				t.Errorf("unexpected body %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	r := NewRouter()
+	handler := func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		next := 1
+		if id := LastEventID(ctx); id != "" {
+			next, _ = strconv.Atoi(id)
+			next++
+		}
+		for i := next; i <= 3; i++ {
+			if err := WriteEvent(ctx, strconv.Itoa(i), TestResponse{Reply: strconv.Itoa(i)}); err != nil {
+				return TestResponse{}, &Error{Code: "WRITE", Err: err}
+			}
+		}
+		return TestResponse{}, nil
+	}
+	RegisterPost(r, "events", handler).SetSpec(Spec{Stream: StreamSSE})
+	RegisterPost(r, "lines", handler).SetSpec(Spec{Stream: StreamNDJSON})
+
+	for _, tc := range []struct {
+		path, lastEventID string
+		body              string
+	}{
+		{"/events", "", "id: 1\ndata: {\"reply\":\"1\"}\n\nid: 2\ndata: {\"reply\":\"2\"}\n\nid: 3\ndata: {\"reply\":\"3\"}\n\n"},
+		{"/events", "2", "id: 3\ndata: {\"reply\":\"3\"}\n\n"},
+		{"/lines", "", "{\"reply\":\"1\"}\n{\"reply\":\"2\"}\n{\"reply\":\"3\"}\n"},
+	} {
+		t.Run(tc.path+" "+tc.lastEventID, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tc.path, strings.NewReader(`{}`))
+			if tc.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", tc.lastEventID)
+			}
+			w := httptest.NewRecorder()
+			r.Mux().ServeHTTP(w, req)
+			if w.Body.String() != tc.body {
+				t.Errorf("unexpected body %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestItemsInput(t *testing.T) {
+	r := NewRouter()
+	RegisterPost(r, "users", func(ctx context.Context, items Items[CreateUserRequest]) (TestResponse, *Error) {
+		var names []string
+		for item, err := range items {
+			if err != nil {
+				return TestResponse{}, err
+			}
+			names = append(names, item.Name)
+		}
+		return TestResponse{Reply: strings.Join(names, ",")}, nil
+	})
+	RegisterPost(r, "echo", func(ctx context.Context, items Items[TestRequest]) (TestResponse, *Error) {
+		for item, err := range items {
+			if err != nil {
+				return TestResponse{}, err
+			}
+			if err := WriteItem(ctx, TestResponse{Reply: item.Message}); err != nil {
+				return TestResponse{}, &Error{Code: "WRITE", Err: err}
+			}
+		}
+		return TestResponse{}, nil
+	}).SetSpec(Spec{Stream: StreamNDJSON})
+
+	for _, tc := range []struct {
+		name, path, body string
+		status           int
+		response         string
+	}{
+		{"items", "/users", "{\"name\":\"alice\",\"role\":\"admin\"}\n{\"name\":\"bob\",\"role\":\"user\"}\n", http.StatusOK, `"reply":"alice,bob"`},
+		{"no items", "/users", "", http.StatusOK, `"reply":""`},
+		{"invalid item", "/users", "{\"name\":\"alice\",\"role\":\"admin\"}\n{\"name\":\"b\",\"role\":\"user\"}\n", http.StatusUnprocessableEntity, ValidationFailedCode},
+		{"malformed line", "/users", "{\"name\":\"alice\",\"role\":\"admin\"}\n{\"name\n", http.StatusBadRequest, "FAILED_DECODING_REQUEST_BODY"},
+		{"streamed", "/echo", "{\"message\":\"a\"}\n{\"message\":\"b\"}\n", http.StatusOK, "{\"reply\":\"a\"}\n{\"reply\":\"b\"}\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", NDJSON)
+			w := httptest.NewRecorder()
+			r.Mux().ServeHTTP(w, req)
+			if w.Code != tc.status {
+				t.Errorf("expected status %d, got %d: %s", tc.status, w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tc.response) {
+				t.Errorf("expected %q in the body, got %q", tc.response, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestMsgPack(t *testing.T) {
+	r := NewRouter()
+	RegisterPost(r, "echo", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		if req.Message == "" {
+			return TestResponse{}, &Error{Code: "EMPTY_MESSAGE", Status: http.StatusBadRequest}
+		}
+		return TestResponse{Reply: req.Message}, nil
+	})
+
+	// the router decodes JSON only until it opts in
+	body, _ := msgpack.Marshal(TestRequest{Message: "hi"})
+	req := httptest.NewRequest("POST", "/echo", bytes.NewReader(body))
+	req.Header.Set("Content-Type", MsgPack)
+	w := httptest.NewRecorder()
+	r.Mux().ServeHTTP(w, req)
+	if w.Code != http.StatusUnsupportedMediaType || !strings.Contains(w.Body.String(), UnsupportedMediaTypeCode) {
+		t.Fatalf("expected the MessagePack body rejected, got %d %s", w.Code, w.Body)
+	}
+	r.AcceptMsgPack()
+
+	for _, tc := range []struct {
+		name, message, accept string
+		msgpack               bool
+		msgpackResponse       bool
+	}{
+		{"msgpack", "hi", MsgPack, true, true},
+		{"msgpack preferred", "hi", "application/json;q=0.5, application/msgpack", true, true},
+		{"json preferred", "hi", "application/json, application/msgpack;q=0.5", false, false},
+		{"json request", "hi", MsgPack, false, true},
+		{"error", "", MsgPack, true, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var body []byte
+			if tc.msgpack {
+				body, _ = msgpack.Marshal(TestRequest{Message: tc.message})
+			} else {
+				body, _ = json.Marshal(TestRequest{Message: tc.message})
+			}
+			req := httptest.NewRequest("POST", "/echo", bytes.NewReader(body))
+			if tc.msgpack {
+				req.Header.Set("Content-Type", MsgPack)
+			}
+			req.Header.Set("Accept", tc.accept)
+			w := httptest.NewRecorder()
+			r.Mux().ServeHTTP(w, req)
+
+			if got := w.Header().Get("Content-Type"); (got == MsgPack) != tc.msgpackResponse {
+				t.Errorf("unexpected content type %q", got)
+			}
+			if tc.message != "" && !slices.Contains(w.Header().Values("Vary"), "Accept") {
+				t.Errorf("expected Vary: Accept, got %q", w.Header().Values("Vary"))
+			}
+			var res TestResponse
+			if tc.msgpackResponse {
+				if err := msgpack.Unmarshal(w.Body.Bytes(), &res); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				json.Unmarshal(w.Body.Bytes(), &res)
+			}
+			if tc.message != "" && res.Reply != tc.message {
+				t.Errorf("expected the reply %q, got %q", tc.message, w.Body.String())
+			}
+			// the errors are JSON
+			if tc.message == "" && !strings.Contains(w.Body.String(), `"code":"EMPTY_MESSAGE"`) {
+				t.Errorf("expected the JSON error, got %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestBigInt(t *testing.T) {
+	type account struct {
+		Balance BigInt `json:"balance"`
+	}
+	r := NewRouter()
+	RegisterPost(r, "deposit", func(ctx context.Context, req account) (account, *Error) {
+		req.Balance.Add(&req.Balance.Int, big.NewInt(1))
+		return req, nil
+	})
+
+	for _, tc := range []struct {
+		name, body, expected string
+		status               int
+	}{
+		{"string", `{"balance":"18446744073709551616"}`, `{"balance":"18446744073709551617"}`, http.StatusOK},
+		{"number", `{"balance":18446744073709551616}`, `{"balance":"18446744073709551617"}`, http.StatusOK},
+		{"null", `{"balance":null}`, `{"balance":"1"}`, http.StatusOK},
+		{"malformed", `{"balance":"1.5"}`, "", http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.Mux().ServeHTTP(w, httptest.NewRequest("POST", "/deposit", strings.NewReader(tc.body)))
+			if w.Code != tc.status {
+				t.Fatalf("expected %d, got %d: %s", tc.status, w.Code, w.Body.String())
+			}
+			if tc.status == http.StatusOK && strings.TrimSpace(w.Body.String()) != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestBodyLimits(t *testing.T) {
+	r := NewRouter()
+	echo := func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		return TestResponse{Reply: req.Message}, nil
+	}
+	RegisterPost(r, "small", echo).SetSpec(Spec{MaxBodyBytes: 32, DecodeTimeout: 50 * time.Millisecond})
+	RegisterPost(r, "unbounded", echo).SetSpec(Spec{MaxBodyBytes: -1})
+	RegisterPost(r, "sized", echo).SetSpec(Spec{MaxBodyBytes: 1 << 10})
+	server := httptest.NewServer(r.Mux())
+	defer server.Close()
+
+	// the errors are documented only for the limits set, none are by default
+	documented := map[string][]int{
+		"small":     {http.StatusRequestEntityTooLarge, http.StatusRequestTimeout},
+		"unbounded": nil,
+		"sized":     {http.StatusRequestEntityTooLarge},
+	}
+	for _, meta := range r.Meta() {
+		for status, code := range map[int]string{http.StatusRequestEntityTooLarge: RequestTooLargeCode, http.StatusRequestTimeout: RequestTimeoutCode} {
+			want := slices.Contains(documented[meta.OperationID], status)
+			if got := slices.ContainsFunc(meta.Spec.Errors[status], func(spec ErrorSpec) bool { return spec.Code == code }); got != want {
+				t.Errorf("%s: expected %s documented under %d %v, got %v", meta.OperationID, code, status, want, meta.Spec.Errors)
+			}
+		}
+	}
+
+	large := `{"message":"` + strings.Repeat("x", 64) + `"}`
+	for _, tc := range []struct {
+		path   string
+		status int
+		code   string
+	}{
+		{"/small", http.StatusRequestEntityTooLarge, RequestTooLargeCode},
+		{"/unbounded", http.StatusOK, ""},
+	} {
+		resp, err := http.Post(server.URL+tc.path, "application/json", strings.NewReader(large))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tc.status || !strings.Contains(string(body), tc.code) {
+			t.Errorf("%s: expected %d %s, got %d %s", tc.path, tc.status, tc.code, resp.StatusCode, body)
+		}
+	}
+
+	t.Run("slow body", func(t *testing.T) {
+		body, writer := io.Pipe()
+		defer writer.Close()
+		go writer.Write([]byte(`{"message":`))
+		resp, err := http.Post(server.URL+"/small", "application/json", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusRequestTimeout || !strings.Contains(string(data), RequestTimeoutCode) {
+			t.Errorf("expected 408 %s, got %d %s", RequestTimeoutCode, resp.StatusCode, data)
+		}
+	})
+}
+
+// deadlineRecorder records the read deadlines set by the handler
+type deadlineRecorder struct {
+	*httptest.ResponseRecorder
+	deadlines []time.Time
+}
+
+func (d *deadlineRecorder) SetReadDeadline(deadline time.Time) error {
+	d.deadlines = append(d.deadlines, deadline)
+	return nil
+}
+
+func TestBodyReadDeadline(t *testing.T) {
+	meta := &HandlerMeta{Spec: Spec{DecodeTimeout: time.Second}}
+	for _, tc := range []struct {
+		name        string
+		readTimeout time.Duration
+		// decode and restored are the deadlines set relative to the request, zero is no deadline
+		decode, restored time.Duration
+	}{
+		{"no server deadline", 0, time.Second, 0},
+		{"server deadline", time.Minute, time.Second, time.Minute},
+		{"shorter server deadline", 100 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/echo", strings.NewReader(`{}`))
+			ctx := context.WithValue(req.Context(), http.ServerContextKey, &http.Server{ReadTimeout: tc.readTimeout})
+			req = req.WithContext(routeKey.Set(ctx, &route{meta: meta}))
+			w := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+
+			start := time.Now()
+			limitBody(w, req)()
+			if len(w.deadlines) != 2 {
+				t.Fatalf("expected the deadline set and restored, got %v", w.deadlines)
+			}
+			for i, want := range []time.Duration{tc.decode, tc.restored} {
+				got := w.deadlines[i]
+				if want == 0 && !got.IsZero() || want != 0 && got.Sub(start.Add(want)).Abs() > 50*time.Millisecond {
+					t.Errorf("expected the deadline %d in %v, got %v", i, want, got.Sub(start))
+				}
+			}
+		})
+	}
+}
+
+func TestTypedMiddlewares(t *testing.T) {
+	var calls []string
+	trace := func(name string) TypedMiddleware[TestRequest, TestResponse] {
+		return func(next Handler[TestRequest, TestResponse]) Handler[TestRequest, TestResponse] {
+			return func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+				calls = append(calls, name+" "+req.Message)
+				return next(ctx, req)
+			}
+		}
+	}
+	trim := MapInput[TestRequest, TestResponse](func(ctx context.Context, req TestRequest) (TestRequest, *Error) {
+		req.Message = strings.TrimSpace(req.Message)
+		if req.Message == "" {
+			return req, &Error{Code: "EMPTY_MESSAGE"}
+		}
+		return req, nil
+	})
+	sign := MapOutput[TestRequest](func(ctx context.Context, res TestResponse) (TestResponse, *Error) {
+		res.Reply += "!"
+		return res, nil
+	})
+	httpTrace := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls = append(calls, "http")
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	r := NewRouter()
+	RegisterPost(r, "echo", WithMiddlewares(func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		return TestResponse{Reply: req.Message}, nil
+	}, trace("first"), trim, trace("second"), sign), httpTrace)
+
+	w := httptest.NewRecorder()
+	r.Mux().ServeHTTP(w, httptest.NewRequest("POST", "/echo", strings.NewReader(`{"message":" hi "}`)))
+	if w.Body.String() != `{"reply":"hi!"}`+"\n" {
+		t.Errorf("unexpected body %q", w.Body.String())
+	}
+	if got := strings.Join(calls, ","); got != "http,first  hi ,second hi" {
+		t.Errorf("unexpected calls %q", got)
+	}
+
+	w = httptest.NewRecorder()
+	r.Mux().ServeHTTP(w, httptest.NewRequest("POST", "/echo", strings.NewReader(`{"message":" "}`)))
+	if w.Code != http.StatusBadRequest || w.Body.String() != `{"code":"EMPTY_MESSAGE"}`+"\n" {
+		t.Errorf("unexpected response %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	dir := t.TempDir()
+	opts := SamplingOpts{RedactFields: []string{"password"}}
+
+	type Login struct {
+		User     string `json:"user"`
+		Password string `json:"password"`
+		Token    string `json:"token" redact:"true"`
+	}
+	r := NewRouter()
+	r.Use(Recorder(dir, opts))
+	RegisterPost(r, "login", func(ctx context.Context, req Login) (TestResponse, *Error) {
+		if req.User == "" {
+			return TestResponse{}, &Error{Code: "NO_USER"}
+		}
+		return TestResponse{Reply: "hello " + req.User}, nil
+	})
+
+	for _, body := range []string{`{"user":"bob","password":"hunter2","token":"tok_1"}`, `{"password":"hunter2"}`, `{"password":"hunter2","user":"bob","token":"tok_2"}`} {
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		r.Mux().ServeHTTP(httptest.NewRecorder(), req)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "login", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same request is saved once
+	if len(files) != 2 {
+		t.Fatalf("expected 2 recordings, got %v", files)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "hunter2") || strings.Contains(string(data), "secret") || strings.Contains(string(data), "tok_") {
+			t.Errorf("expected the recording to be redacted:\n%s", data)
+		}
+	}
+
+	transport, err := NewReplayTransport(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: transport}
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		reply  string
+		err    bool
+	}{
+		{name: "recorded", body: `{"user":"bob","password":"another","token":"tok_3"}`, status: http.StatusOK, reply: `{"reply":"hello bob"}`},
+		{name: "recorded error", body: `{"password":"another"}`, status: http.StatusBadRequest, reply: `{"code":"NO_USER"}`},
+		{name: "not recorded", body: `{"user":"alice"}`, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := client.Post("http://replay/login", "application/json", strings.NewReader(tt.body))
+			if tt.err {
+				if err == nil || !strings.Contains(err.Error(), "no recording of POST /login") {
+					t.Errorf("expected no recording to match, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			body, _ := io.ReadAll(res.Body)
+			if res.StatusCode != tt.status || string(body) != tt.reply {
+				t.Errorf("unexpected response %d %s", res.StatusCode, body)
+			}
+		})
+	}
+}
+
+// unencodable fails its encoding with an infinite ratio, after its reply is encoded
+type unencodable struct {
+	Reply string  `json:"reply"`
+	Ratio float64 `json:"ratio"`
+}
+
+func TestResponseEncoding(t *testing.T) {
+	r := NewRouter()
+	RegisterPost(r, "divide", func(ctx context.Context, req TestRequest) (unencodable, *Error) {
+		if req.Message == "zero" {
+			return unencodable{Reply: "ok", Ratio: math.Inf(1)}, nil
+		}
+		return unencodable{Reply: "ok", Ratio: 0.5}, nil
+	})
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   string
+	}{
+		{name: "encoded", body: `{"message":"half"}`, status: http.StatusOK, want: `{"reply":"ok","ratio":0.5}` + "\n"},
+		{name: "failed encoding", body: `{"message":"zero"}`, status: http.StatusInternalServerError, want: `{"code":"FAILED_ENCODING_RESPONSE_BODY","message":"json: unsupported value: +Inf"}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.Mux().ServeHTTP(w, httptest.NewRequest("POST", "/divide", strings.NewReader(tt.body)))
+			if w.Code != tt.status || w.Body.String() != tt.want {
+				t.Errorf("unexpected response %d %q", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+// appendedRequest keeps the raw body its UnmarshalJSON gets, it accepts an empty body to show encoding/json isn't involved
+type appendedRequest struct {
+	raw string
+}
+
+func (v appendedRequest) AppendJSON(b []byte) ([]byte, error) {
+	return append(b, v.raw...), nil
+}
+
+func (v *appendedRequest) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && !json.Valid(data) {
+		return fmt.Errorf("invalid JSON %s", data)
+	}
+	v.raw = string(data)
+	return nil
+}
+
+type appendedResponse struct {
+	Reply string
+}
+
+func (v appendedResponse) AppendJSON(b []byte) ([]byte, error) {
+	return fmt.Appendf(b, `{"appended":%q}`, v.Reply), nil
+}
+
+func TestJSONAppender(t *testing.T) {
+	r := NewRouter()
+	RegisterPost(r, "echo", func(ctx context.Context, req appendedRequest) (appendedResponse, *Error) {
+		return appendedResponse{Reply: req.raw}, nil
+	})
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   string
+	}{
+		{name: "appended", body: `{"a": 1}`, status: http.StatusOK, want: `{"appended":"{\"a\": 1}"}` + "\n"},
+		{name: "empty", body: "", status: http.StatusOK, want: `{"appended":""}` + "\n"},
+		// like a json.Decoder the data after the JSON value isn't read
+		{name: "trailing", body: `{"a": 1} {"b": 2}`, status: http.StatusOK, want: `{"appended":"{\"a\": 1}"}` + "\n"},
+		{name: "malformed", body: `{"a"`, status: http.StatusBadRequest, want: `{"code":"FAILED_DECODING_REQUEST_BODY"}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.Mux().ServeHTTP(w, httptest.NewRequest("POST", "/echo", strings.NewReader(tt.body)))
+			if w.Code != tt.status || w.Body.String() != tt.want {
+				t.Errorf("unexpected response %d %q", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+type flatQuery struct {
+	Name     string    `schema:"name"`
+	Limit    int8      `schema:"limit"`
+	Ratio    float32   `schema:"ratio"`
+	Active   bool      `schema:"active"`
+	Since    time.Time `schema:"since"`
+	Skipped  string    `schema:"-"`
+	Untagged uint
+}
+
+func TestQueryDecoder(t *testing.T) {
+	decoder := newQueryDecoder(reflect.TypeFor[flatQuery]())
+	if decoder.fields == nil {
+		t.Fatal("expected the fields of a flat query to be cached")
+	}
+	gorilla := schema.NewDecoder()
+	gorilla.RegisterConverter(time.Time{}, queryConverter(reflect.TypeFor[time.Time]()))
+
+	for _, query := range []string{
+		"name=a&limit=10&ratio=0.5&active=true&since=2024-01-15T09:30:00Z&Untagged=3",
+		"NAME=a",
+		"name=a&name=b",
+		"limit=",
+		"limit=300",
+		"active=maybe",
+		"since=yesterday",
+		"unknown=1",
+		"Skipped=a",
+	} {
+		t.Run(query, func(t *testing.T) {
+			values, err := url.ParseQuery(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, want flatQuery
+			gotErr, wantErr := decoder.Decode(&got, values), gorilla.Decode(&want, values)
+			if (gotErr != nil) != (wantErr != nil) || got != want {
+				t.Errorf("expected %+v, %v, got %+v, %v", want, wantErr, got, gotErr)
+			}
+		})
+	}
+
+	if newQueryDecoder(reflect.TypeFor[struct {
+		IDs []string `schema:"ids"`
+	}]()).fields != nil {
+		t.Error("expected a slice field to be decoded by gorilla schema")
+	}
+}
+
+// headerRequest is filled from the headers by a middleware, the body isn't decoded
+type headerRequest struct {
+	NoBody
+	Tenant string
+}
+
+var tenantKey ContextValue[string]
+
+func TestNoBody(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		t    reflect.Type
+		want bool
+	}{
+		{name: "empty", t: reflect.TypeFor[struct{}](), want: false},
+		{name: "blank", t: reflect.TypeFor[struct{ _ [0]int }](), want: false},
+		{name: "blank sized", t: reflect.TypeFor[struct{ _ int }](), want: false},
+		{name: "unexported", t: reflect.TypeFor[struct{ n int }](), want: true},
+		{name: "fields", t: reflect.TypeFor[TestRequest](), want: true},
+		{name: "embedded", t: reflect.TypeFor[headerRequest](), want: false},
+		{name: "slice", t: reflect.TypeFor[[]TestRequest](), want: true},
+		{name: "interface", t: reflect.TypeFor[any](), want: true},
+	} {
+		if got := HasBody(tt.t); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	r := NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			next.ServeHTTP(w, req.WithContext(tenantKey.Set(req.Context(), req.Header.Get("X-Tenant"))))
+		})
+	})
+	RegisterPost(r, "tenant", func(ctx context.Context, req headerRequest) (TestResponse, *Error) {
+		tenant, _ := tenantKey.From(ctx)
+		return TestResponse{Reply: tenant + req.Tenant}, nil
+	})
+	req := httptest.NewRequest("POST", "/tenant", strings.NewReader(`not json`))
+	req.Header.Set("X-Tenant", "acme")
+	w := httptest.NewRecorder()
+	r.Mux().ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != `{"reply":"acme"}`+"\n" {
+		t.Errorf("expected the body to be skipped, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestIdempotency(t *testing.T) {
+	calls := 0
+	started, release := make(chan struct{}), make(chan struct{})
+	r := NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("X-Request-Id", req.Header.Get("X-Request-Id"))
+			if user := req.Header.Get("X-User"); user != "" {
+				req = req.WithContext(WithPrincipal(req.Context(), &Principal{ID: user}))
+			}
+			next.ServeHTTP(w, req)
+		})
+	})
+	meta := RegisterPost(r, "pay", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		calls++
+		switch req.Message {
+		case "slow":
+			close(started)
+			<-release
+		case "fail":
+			return TestResponse{}, &Error{Message: "the payment provider is down"}
+		}
+		WriterFromContext(ctx).Header().Set("X-Payment", strconv.Itoa(calls))
+		return TestResponse{Reply: req.Message + " " + strconv.Itoa(calls)}, nil
+	}, Idempotency(NewMemoryIdempotencyStore(0), IdempotencyOpts{}))
+	if headers := meta.RequestHeaders(); len(headers) != 1 || headers[0].Key != IdempotencyKeyHeader {
+		t.Errorf("expected the documented %s header, got %+v", IdempotencyKeyHeader, headers)
+	}
+
+	payAs := func(user, key, id, message string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/pay", strings.NewReader(`{"message":"`+message+`"}`))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		req.Header.Set("X-Request-Id", id)
+		req.Header.Set("X-User", user)
+		w := httptest.NewRecorder()
+		r.Mux().ServeHTTP(w, req)
+		return w
+	}
+	pay := func(key, id, message string) *httptest.ResponseRecorder {
+		return payAs("", key, id, message)
+	}
+
+	first := pay("k1", "1", "hi")
+	retry := pay("k1", "2", "hi")
+	if retry.Code != http.StatusOK || retry.Body.String() != first.Body.String() || calls != 1 {
+		t.Errorf("expected the replay of %q, got %d %q after %d calls", first.Body.String(), retry.Code, retry.Body.String(), calls)
+	}
+	if retry.Header().Get(IdempotentReplayedHeader) != "true" || retry.Header().Get("X-Payment") != "1" {
+		t.Errorf("expected the replayed headers, got %v", retry.Header())
+	}
+	if got := retry.Header().Get("X-Request-Id"); got != "2" {
+		t.Errorf("expected the request id of the retry, got %q", got)
+	}
+	if w := pay("k1", "2", "bye"); w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), IdempotencyMismatchCode) || calls != 1 {
+		t.Errorf("expected 422 %s for another body, got %d %s after %d calls", IdempotencyMismatchCode, w.Code, w.Body.String(), calls)
+	}
+	if w := payAs("alice", "k1", "2", "hi"); w.Header().Get(IdempotentReplayedHeader) != "" || calls != 2 {
+		t.Errorf("expected the key of another caller to be served, got %v after %d calls", w.Header(), calls)
+	}
+	if w := pay("k2", "3", "hi"); w.Header().Get(IdempotentReplayedHeader) != "" || calls != 3 {
+		t.Errorf("expected another key to be served, got %v after %d calls", w.Header(), calls)
+	}
+	if pay("", "4", "hi"); calls != 4 {
+		t.Errorf("expected a request without the key to be served, got %d calls", calls)
+	}
+
+	pay("k3", "5", "fail")
+	if w := pay("k3", "6", "hi"); w.Code != http.StatusOK || calls != 6 {
+		t.Errorf("expected the retry of a server error to be served, got %d after %d calls", w.Code, calls)
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- pay("k4", "7", "slow")
+	}()
+	// the first request holds the key while it waits
+	<-started
+	if w := pay("k4", "8", "slow"); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), IdempotencyConflictCode) {
+		t.Errorf("expected 409 %s, got %d %s", IdempotencyConflictCode, w.Code, w.Body.String())
+	}
+	close(release)
+	if w := <-done; w.Code != http.StatusOK {
+		t.Errorf("expected the first request to succeed, got %d", w.Code)
+	}
+
+	required := NewRouter()
+	RegisterPost(required, "pay", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		return TestResponse{}, nil
+	}, Idempotency(NewMemoryIdempotencyStore(time.Minute), IdempotencyOpts{Required: true}))
+	w := httptest.NewRecorder()
+	required.Mux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/pay", strings.NewReader(`{}`)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), IdempotencyKeyRequiredCode) {
+		t.Errorf("expected 400 %s, got %d %s", IdempotencyKeyRequiredCode, w.Code, w.Body.String())
+	}
+
+	// a handler without a route meta is keyed by its path
+	plain := NewRouter()
+	served := 0
+	idempotent := Idempotency(NewMemoryIdempotencyStore(0), IdempotencyOpts{})(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// the body isn't read, the middleware hashes it on its own
+		served++
+		w.Write([]byte("ok"))
+	}))
+	plain.Mux().Handle("POST /plain/{name}", withRoute(idempotent, nil, plain.shared))
+	for _, path := range []string{"/plain/a", "/plain/a", "/plain/b"} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{}`))
+		req.Header.Set(IdempotencyKeyHeader, "k1")
+		w := httptest.NewRecorder()
+		plain.Mux().ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d %s", path, w.Code, w.Body.String())
+		}
+	}
+	if served != 2 {
+		t.Errorf("expected the key to be replayed on the same path only, got %d calls", served)
+	}
+}
+
+func TestETag(t *testing.T) {
+	r := NewRouter()
+	RegisterGet(r, "item", func(ctx context.Context, req benchmarkQuery) (TestResponse, *Error) {
+		if req.Limit > 0 {
+			SetETag(ctx, "v"+strconv.Itoa(req.Limit))
+		}
+		return TestResponse{Reply: req.Query}, nil
+	}, ETag(ETagOpts{}))
+	RegisterGet(r, "weak", func(ctx context.Context, req benchmarkQuery) (TestResponse, *Error) {
+		return TestResponse{Reply: req.Query}, nil
+	}, ETag(ETagOpts{Weak: true}))
+	if headers := r.Meta()[0].RequestHeaders(); len(headers) != 1 || headers[0].Key != "If-None-Match" {
+		t.Errorf("expected the documented If-None-Match header, got %+v", headers)
+	}
+
+	get := func(target, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		r.Mux().ServeHTTP(w, req)
+		return w
+	}
+
+	first := get("/item?q=a", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || !strings.HasPrefix(etag, `"`) || first.Body.String() != `{"reply":"a"}`+"\n" {
+		t.Fatalf("unexpected response %d %q %q", first.Code, etag, first.Body.String())
+	}
+	if w := get("/item?q=a", `"other", `+etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+		t.Errorf("expected 304 with the ETag, got %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+	if w := get("/item?q=b", etag); w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("expected another response to have another ETag, got %d %v", w.Code, w.Header())
+	}
+	if w := get("/item?q=a&limit=2", `W/"v2"`); w.Code != http.StatusNotModified || w.Header().Get("ETag") != `"v2"` {
+		t.Errorf("expected the explicit ETag to match weakly, got %d %v", w.Code, w.Header())
+	}
+	if w := get("/weak?q=a", "*"); w.Code != http.StatusNotModified || !strings.HasPrefix(w.Header().Get("ETag"), `W/"`) {
+		t.Errorf("expected a weak ETag to match *, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	cache := NewResponseCache(NewMemoryCacheStore(0))
+	calls := 0
+	r := NewRouter()
+	RegisterGet(r, "find", func(ctx context.Context, req benchmarkQuery) (TestResponse, *Error) {
+		calls++
+		if req.Query == "" {
+			return TestResponse{}, &Error{Code: "EMPTY_QUERY"}
+		}
+		return TestResponse{Reply: req.Query + " " + strconv.Itoa(calls)}, nil
+	}, cache.Middleware(CacheOpts{TTL: time.Minute, EmitCacheControl: true})).SetSpec(Spec{
+		Cache: CachePolicy{IgnoreQuery: []string{"trace"}},
+	})
+	RegisterPost(r, "update", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		if err := cache.Invalidate(ctx, "find"); err != nil {
+			return TestResponse{}, &Error{Err: err}
+		}
+		return TestResponse{}, nil
+	})
+
+	get := func(target string, header ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		r.Mux().ServeHTTP(w, req)
+		return w
+	}
+
+	first := get("/find?q=a&limit=1")
+	if first.Header().Get(CacheStatusHeader) != "MISS" || first.Header().Get("Cache-Control") != "private, max-age=60" {
+		t.Errorf("expected a stored response, got %v", first.Header())
+	}
+	hit := get("/find?limit=1&q=a&trace=x")
+	if hit.Header().Get(CacheStatusHeader) != "HIT" || hit.Body.String() != first.Body.String() || calls != 1 {
+		t.Errorf("expected the cached %q, got %v %q after %d calls", first.Body.String(), hit.Header(), hit.Body.String(), calls)
+	}
+	if cacheControl := hit.Header().Get("Cache-Control"); !strings.HasPrefix(cacheControl, "private, max-age=") {
+		t.Errorf("expected the rest of the TTL in Cache-Control, got %q", cacheControl)
+	}
+	if w := get("/find?q=b"); w.Header().Get(CacheStatusHeader) != "MISS" || calls != 2 {
+		t.Errorf("expected another query to be served, got %v after %d calls", w.Header(), calls)
+	}
+	if w := get("/find?q=a&limit=1", "Authorization", "Bearer bob"); w.Header().Get(CacheStatusHeader) != "" || w.Body.String() == first.Body.String() {
+		t.Errorf("expected a request with credentials to bypass the cache, got %v %q", w.Header(), w.Body.String())
+	}
+	if w := get("/find?q=a&limit=1", "Cookie", "session=bob"); w.Header().Get(CacheStatusHeader) != "" {
+		t.Errorf("expected a request with a cookie to bypass the cache, got %v", w.Header())
+	}
+	if w := get("/find"); w.Code != http.StatusBadRequest || w.Header().Get(CacheStatusHeader) != "" {
+		t.Errorf("expected an error not to be cached, got %d %v", w.Code, w.Header())
+	}
+
+	w := httptest.NewRecorder()
+	r.Mux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/update", strings.NewReader(`{}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected invalidation response %d %s", w.Code, w.Body.String())
+	}
+	if w := get("/find?q=a&limit=1"); w.Header().Get(CacheStatusHeader) != "MISS" || w.Body.String() == first.Body.String() {
+		t.Errorf("expected the invalidated response to be served again, got %v %q", w.Header(), w.Body.String())
+	}
+}
+
+type listNumbersRequest struct {
+	Cursor
+	Total int `json:"total" schema:"total"`
+}
+
+func TestPage(t *testing.T) {
+	r := NewRouter()
+	RegisterGet(r, "listNumbers", func(ctx context.Context, req listNumbersRequest) (Page[int], *Error) {
+		start, _ := strconv.Atoi(req.After)
+		var page Page[int]
+		for i := start; i < min(start+req.PageSize(2, 3), req.Total); i++ {
+			page.Items = append(page.Items, i)
+		}
+		if next := start + len(page.Items); next < req.Total {
+			page.NextCursor = strconv.Itoa(next)
+		}
+		return page, nil
+	})
+	if !Paginated(reflect.TypeFor[listNumbersRequest](), reflect.TypeFor[Page[int]]()) {
+		t.Error("expected the route to be paginated")
+	}
+	if Paginated(reflect.TypeFor[TestRequest](), reflect.TypeFor[Page[int]]()) {
+		t.Error("expected an input without a cursor not to be paginated")
+	}
+
+	for target, expected := range map[string]string{
+		"/listNumbers?total=5":                   `{"items":[0,1],"nextCursor":"2"}`,
+		"/listNumbers?total=5&cursor=2&limit=10": `{"items":[2,3,4]}`,
+		"/listNumbers?total=5&cursor=&limit=1":   `{"items":[0],"nextCursor":"1"}`,
+		"/listNumbers?total=5&cursor=1&cursor=3": `{"items":[1,2],"nextCursor":"3"}`,
+	} {
+		w := httptest.NewRecorder()
+		r.Mux().ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if got := strings.TrimSpace(w.Body.String()); w.Code != http.StatusOK || got != expected {
+			t.Errorf("%s: expected %s, got %d %s", target, expected, w.Code, got)
+		}
+	}
+}
+
+// the endpoints registered by the router itself serve the route-aware middlewares like the routes do
+func TestBuiltinEndpointMiddlewares(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		middleware func() Middleware
+	}{
+		{"idempotency", func() Middleware { return Idempotency(NewMemoryIdempotencyStore(0), IdempotencyOpts{}) }},
+		{"cache", func() Middleware { return NewResponseCache(NewMemoryCacheStore(0)).Middleware(CacheOpts{}) }},
+		{"sampling", func() Middleware {
+			return Sampling(SampleSinkFunc(func(ctx context.Context, sample Sample) {}), SamplingOpts{Rate: 1})
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewRouter()
+			r.RegisterBatchEndpoint(BatchOpts{}, tc.middleware())
+			r.RegisterOperationsEndpoint(NewMemoryJobStore(0), tc.middleware())
+			RegisterPost(r, "echo", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+				return TestResponse{Reply: req.Message}, nil
+			}, WithVersion("2024-01-01"), tc.middleware())
+
+			for _, req := range []struct {
+				method, path, body string
+				status             int
+			}{
+				{http.MethodPost, BatchPath, "[]", http.StatusOK},
+				{http.MethodGet, OperationsPath + "missing", "", http.StatusNotFound},
+				{http.MethodPost, "/echo", `{"message":"hi"}`, http.StatusOK},
+			} {
+				httpReq := httptest.NewRequest(req.method, req.path, strings.NewReader(req.body))
+				httpReq.Header.Set(IdempotencyKeyHeader, "k1")
+				w := httptest.NewRecorder()
+				r.Mux().ServeHTTP(w, httpReq)
+				if w.Code != req.status {
+					t.Errorf("%s %s: expected status %d, got %d: %s", req.method, req.path, req.status, w.Code, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+// a handler served under a route without a meta passes the route-aware middlewares as is
+func TestMiddlewaresWithoutMeta(t *testing.T) {
+	r := NewRouter()
+	var samples []Sample
+	var handler http.Handler = NewHandler(func(ctx context.Context, req benchmarkQuery) (TestResponse, *Error) {
+		if req.Query == "fail" {
+			return TestResponse{}, &Error{Code: "DOWN"}
+		}
+		return TestResponse{Reply: req.Query}, nil
+	})
+	for _, m := range []Middleware{
+		NewResponseCache(NewMemoryCacheStore(0)).Middleware(CacheOpts{}),
+		Sampling(SampleSinkFunc(func(ctx context.Context, sample Sample) { samples = append(samples, sample) }), SamplingOpts{Rate: 1}),
+	} {
+		handler = m(handler)
+	}
+	r.Mux().Handle("GET /plain", withRoute(handler, nil, r.shared))
+
+	for _, tc := range []struct {
+		query  string
+		status int
+	}{
+		{"q=ok", http.StatusOK},
+		{"q=ok", http.StatusOK},
+		{"q=fail", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		r.Mux().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/plain?"+tc.query, nil))
+		if w.Code != tc.status || w.Header().Get(CacheStatusHeader) == "HIT" {
+			t.Errorf("%s: expected an uncached %d, got %d %v", tc.query, tc.status, w.Code, w.Header())
+		}
+	}
+	if len(samples) != 3 || samples[0].OperationID != "" {
+		t.Errorf("expected the samples without an operation, got %+v", samples)
+	}
+}
+
+func TestAsync(t *testing.T) {
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a panic registering an async route without the operations endpoint")
+			}
+		}()
+		RegisterAsync(NewRouter(), "report", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+			return TestResponse{}, nil
+		})
+	}()
+
+	release := make(chan struct{})
+	r := NewRouter()
+	api := r.Subrouter("/api")
+	api.RegisterOperationsEndpoint(NewMemoryJobStore(0))
+	meta := RegisterAsync(api, "report", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		if WriterFromContext(ctx) != nil {
+			t.Error("expected no writer in the context of the operation")
+		}
+		<-release
+		if req.Message == "fail" {
+			return TestResponse{}, &Error{Code: "REPORT_FAILED", Message: "no data"}
+		}
+		return TestResponse{Reply: "report of " + req.Message}, nil
+	})
+	if meta.OperationsPath() != "/api/operations/" {
+		t.Errorf("expected the operations path of the async route, got %q", meta.OperationsPath())
+	}
+
+	start := func(message string) Operation {
+		w := httptest.NewRecorder()
+		r.Mux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/report", strings.NewReader(`{"message":"`+message+`"}`)))
+		var op Operation
+		if err := json.Unmarshal(w.Body.Bytes(), &op); err != nil || w.Code != http.StatusAccepted {
+			t.Fatalf("expected 202 with the operation, got %d %s", w.Code, w.Body.String())
+		}
+		if op.Status != OperationPending || op.Name != "report" || w.Header().Get("Location") != "/api/operations/"+op.ID {
+			t.Errorf("expected the pending operation and its location, got %+v %v", op, w.Header())
+		}
+		return op
+	}
+	get := func(id string) (*httptest.ResponseRecorder, Operation) {
+		w := httptest.NewRecorder()
+		r.Mux().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/operations/"+id, nil))
+		var op Operation
+		_ = json.Unmarshal(w.Body.Bytes(), &op)
+		return w, op
+	}
+	wait := func(id string) Operation {
+		for {
+			if _, op := get(id); op.Status.Done() {
+				return op
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	succeeded, failed := start("q1"), start("fail")
+	if w, op := get(succeeded.ID); w.Code != http.StatusOK || op.Status.Done() || w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected the operation in progress with Retry-After, got %d %+v %v", w.Code, op, w.Header())
+	}
+	close(release)
+
+	if op := wait(succeeded.ID); op.Status != OperationSucceeded || string(op.Result) != `{"reply":"report of q1"}` {
+		t.Errorf("expected the result of the operation, got %+v", op)
+	}
+	if op := wait(failed.ID); op.Status != OperationFailed || !strings.Contains(string(op.Error), `"code":"REPORT_FAILED"`) {
+		t.Errorf("expected the error of the operation, got %+v", op)
+	}
+	if w, _ := get(succeeded.ID); w.Header().Get("Retry-After") != "" {
+		t.Errorf("expected no Retry-After of a done operation, got %v", w.Header())
+	}
+	if w, _ := get("unknown"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 of an unknown operation, got %d", w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	r.Mux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/report", strings.NewReader(`{`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected a malformed request to be rejected before the operation starts, got %d", w.Code)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	keys := map[string][]byte{"partner": []byte("s3cret")}
+	r := NewRouter()
+	meta := RegisterPost(r, "webhook", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		return TestResponse{Reply: SignatureKeyID(ctx) + " " + req.Message}, nil
+	}, Signature(SignatureOpts{
+		Secret: func(ctx context.Context, keyID string) ([]byte, error) {
+			if secret, ok := keys[keyID]; ok {
+				return secret, nil
+			}
+			return nil, ErrUnknownSignatureKey
+		},
+		ReplayCache: NewMemoryReplayCache(),
+	}))
+	if schemes := meta.SecuritySchemes(); len(schemes) != 1 || schemes[0].Header != SignatureHeader {
+		t.Errorf("expected the documented signature scheme, got %+v", schemes)
+	}
+
+	send := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.Mux().ServeHTTP(w, req)
+		return w
+	}
+	newRequest := func(keyID string, secret []byte) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/webhook?source=test", strings.NewReader(`{"message":"hi"}`))
+		if err := SignRequest(req, keyID, secret, HMACSHA256); err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+
+	signed := newRequest("partner", keys["partner"])
+	replay := signed.Clone(context.Background())
+	replay.Body = io.NopCloser(strings.NewReader(`{"message":"hi"}`))
+	if w := send(signed); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"partner hi"`) {
+		t.Errorf("expected the signed request to be served with its key id, got %d %s", w.Code, w.Body.String())
+	}
+	if w := send(replay); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "replayed") {
+		t.Errorf("expected the replay to be rejected, got %d %s", w.Code, w.Body.String())
+	}
+
+	tampered := newRequest("partner", keys["partner"])
+	tampered.Body = io.NopCloser(strings.NewReader(`{"message":"bye"}`))
+	stale := newRequest("partner", keys["partner"])
+	stale.Header.Set(SignatureTimestampHeader, strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+	unsigned := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{}`))
+	for name, req := range map[string]*http.Request{
+		"tampered":    tampered,
+		"stale":       stale,
+		"unknown key": newRequest("stranger", keys["partner"]),
+		"wrong key":   newRequest("partner", []byte("guess")),
+		"unsigned":    unsigned,
+	} {
+		if w := send(req); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), InvalidSignatureCode) {
+			t.Errorf("%s: expected 401 %s, got %d %s", name, InvalidSignatureCode, w.Code, w.Body.String())
+		}
+	}
+
+	// a base64 signature is accepted too
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{"message":"b64"}`))
+	if err := SignRequest(req, "partner", keys["partner"], HMACSHA256); err != nil {
+		t.Fatal(err)
+	}
+	signature, _ := hex.DecodeString(req.Header.Get(SignatureHeader))
+	req.Header.Set(SignatureHeader, base64.StdEncoding.EncodeToString(signature))
+	if w := send(req); w.Code != http.StatusOK {
+		t.Errorf("expected the base64 signature to be accepted, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	principals := map[string]*Principal{
+		"admin":  {ID: "admin", Permissions: []string{"users:read", "users:write"}},
+		"reader": {ID: "reader", Permissions: []string{"users:read"}},
+	}
+	r := NewRouter()
+	// the authentication goes first, the principal is picked by the token
+	r.Use(Authorize(PermissionPolicy))
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if p, ok := principals[req.Header.Get("Authorization")]; ok {
+				req = req.WithContext(WithPrincipal(req.Context(), p))
+			}
+			next.ServeHTTP(w, req)
+		})
+	})
+	handler := func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		return TestResponse{Reply: PrincipalFromContext(ctx).ID}, nil
+	}
+	RegisterPost(r, "deleteUser", handler).SetSpec(Spec{Permissions: []string{"users:read", "users:write"}})
+	RegisterPost(r, "ping", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		return TestResponse{Reply: "pong"}, nil
+	})
+
+	for _, tc := range []struct {
+		operation string
+		token     string
+		status    int
+		code      string
+	}{
+		{"deleteUser", "admin", http.StatusOK, ""},
+		{"deleteUser", "reader", http.StatusForbidden, ForbiddenCode},
+		{"deleteUser", "", http.StatusUnauthorized, UnauthenticatedCode},
+		{"ping", "", http.StatusOK, ""},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/"+tc.operation, strings.NewReader(`{"message":"hi"}`))
+		req.Header.Set("Authorization", tc.token)
+		w := httptest.NewRecorder()
+		r.Mux().ServeHTTP(w, req)
+		if w.Code != tc.status || !strings.Contains(w.Body.String(), tc.code) {
+			t.Errorf("%s by %q: expected %d %s, got %d %s", tc.operation, tc.token, tc.status, tc.code, w.Code, w.Body.String())
+		}
+	}
+
+	failing := NewRouter()
+	RegisterPost(failing, "deleteUser", handler, Authorize(PolicyFunc(func(ctx context.Context, p *Principal, meta *HandlerMeta) (bool, error) {
+		return false, errors.New("policy engine is down")
+	})), func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			next.ServeHTTP(w, req.WithContext(WithPrincipal(req.Context(), principals["admin"])))
+		})
+	}).SetSpec(Spec{Permissions: []string{"users:write"}})
+	w := httptest.NewRecorder()
+	failing.Mux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/deleteUser", strings.NewReader(`{}`)))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected the policy error to fail the request, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	newRouter := func(opts ConcurrencyOpts) (*Router, chan struct{}, chan struct{}) {
+		entered, unblock := make(chan struct{}, 10), make(chan struct{})
+		r := NewRouter()
+		r.Use(ConcurrencyLimit(opts))
+		for _, op := range []string{"slow", "other"} {
+			RegisterPost(r, op, func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+				entered <- struct{}{}
+				<-unblock
+				return TestResponse{Reply: "done"}, nil
+			})
+		}
+		return r, entered, unblock
+	}
+	serve := func(r *Router, op string) chan *httptest.ResponseRecorder {
+		done := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			w := httptest.NewRecorder()
+			r.Mux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/"+op, strings.NewReader(`{}`)))
+			done <- w
+		}()
+		return done
+	}
+
+	r, entered, unblock := newRouter(ConcurrencyOpts{MaxInFlight: 1, MaxQueue: 1, MaxWait: time.Minute, RetryAfter: 3 * time.Second})
+	first := serve(r, "slow")
+	<-entered
+	queued := serve(r, "other")
+	// the queued request takes the only place in the queue
+	time.Sleep(20 * time.Millisecond)
+	w := <-serve(r, "slow")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "3" || !strings.Contains(w.Body.String(), OverloadedCode) {
+		t.Errorf("expected the request to be shed, got %d %s %v", w.Code, w.Body.String(), w.Header())
+	}
+	close(unblock)
+	if w := <-first; w.Code != http.StatusOK {
+		t.Errorf("expected the first request to be served, got %d", w.Code)
+	}
+	if w := <-queued; w.Code != http.StatusOK {
+		t.Errorf("expected the queued request to be served, got %d %s", w.Code, w.Body.String())
+	}
+
+	// the queued request gives up after MaxWait
+	r, entered, unblock = newRouter(ConcurrencyOpts{MaxInFlight: 1, MaxQueue: 1, MaxWait: 10 * time.Millisecond})
+	first = serve(r, "slow")
+	<-entered
+	if w := <-serve(r, "slow"); w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected the queued request to time out, got %d", w.Code)
+	}
+	close(unblock)
+	<-first
+
+	// every route has its own limit
+	r, entered, unblock = newRouter(ConcurrencyOpts{MaxInFlight: 1, PerRoute: true})
+	first = serve(r, "slow")
+	<-entered
+	other := serve(r, "other")
+	<-entered
+	close(unblock)
+	if w1, w2 := <-first, <-other; w1.Code != http.StatusOK || w2.Code != http.StatusOK {
+		t.Errorf("expected both routes to be served, got %d %d", w1.Code, w2.Code)
+	}
+}
+
+func TestVersioning(t *testing.T) {
+	r := NewRouter()
+	reply := func(version string) Handler[TestRequest, TestResponse] {
+		return func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+			return TestResponse{Reply: version + " " + MetaFromContext(ctx).Version()}, nil
+		}
+	}
+	v1 := RegisterPost(r, "echo", reply("v1"), WithVersion("2024-01-01"))
+	RegisterPost(r, "echo", reply("v3"), WithVersion("2024-09-01"))
+	RegisterPost(r, "echo", reply("v2"), WithVersion("2024-06-01"))
+	if v1.Version() != "2024-01-01" || len(r.Meta()) != 3 {
+		t.Errorf("expected every version in the meta, got %q %d", v1.Version(), len(r.Meta()))
+	}
+
+	for _, tc := range []struct {
+		header, version string
+		status          int
+		reply           string
+	}{
+		{"", "", http.StatusOK, "v3 2024-09-01"},
+		{AcceptVersionHeader, "2024-06-01", http.StatusOK, "v2 2024-06-01"},
+		{APIVersionHeader, "2024-01-01", http.StatusOK, "v1 2024-01-01"},
+		{AcceptVersionHeader, "2024-07-15", http.StatusOK, "v2 2024-06-01"},
+		{AcceptVersionHeader, "2025-01-01", http.StatusOK, "v3 2024-09-01"},
+		{AcceptVersionHeader, "2023-12-31", http.StatusBadRequest, UnsupportedVersionCode},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(`{"message":"hi"}`))
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.version)
+		}
+		w := httptest.NewRecorder()
+		r.Mux().ServeHTTP(w, req)
+		if w.Code != tc.status || !strings.Contains(w.Body.String(), tc.reply) {
+			t.Errorf("%s %q: expected %d %s, got %d %s", tc.header, tc.version, tc.status, tc.reply, w.Code, w.Body.String())
+		}
+		if tc.status == http.StatusOK && !strings.HasSuffix(tc.reply, w.Header().Get(APIVersionHeader)) {
+			t.Errorf("%s %q: expected the served version, got %q", tc.header, tc.version, w.Header().Get(APIVersionHeader))
+		}
+	}
+
+	func() {
+		defer func() {
+			if _, ok := recover().(*DuplicateRouteError); !ok {
+				t.Error("expected a version registered twice to panic")
+			}
+		}()
+		RegisterPost(r, "echo", reply("v2"), WithVersion("2024-06-01"))
+	}()
+	func() {
+		defer func() {
+			if _, ok := recover().(*DuplicateRouteError); !ok {
+				t.Error("expected an unversioned route of a versioned operation to panic")
+			}
+		}()
+		RegisterPost(r, "echo", reply("v0"))
+	}()
+}
+
+func TestDeadline(t *testing.T) {
+	r := NewRouter()
+	meta := RegisterPost(r, "echo", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			return TestResponse{Reply: "none"}, nil
+		}
+		return TestResponse{Reply: time.Until(deadline).Round(time.Second).String()}, nil
+	}, Deadline(DeadlineOpts{Max: 10 * time.Second}))
+	RegisterPost(r, "grpc", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		_, ok := ctx.Deadline()
+		return TestResponse{Reply: strconv.FormatBool(ok)}, nil
+	}, Deadline(DeadlineOpts{Header: GRPCTimeoutHeader}))
+	if headers := meta.RequestHeaders(); len(headers) != 1 || headers[0].Key != RequestTimeoutHeader {
+		t.Errorf("expected the documented header, got %+v", headers)
+	}
+
+	for _, tc := range []struct {
+		operation, header, value string
+		status                   int
+		reply                    string
+	}{
+		{"echo", RequestTimeoutHeader, "2s", http.StatusOK, `"2s"`},
+		{"echo", RequestTimeoutHeader, "3000", http.StatusOK, `"3s"`},
+		{"echo", RequestTimeoutHeader, "1m", http.StatusOK, `"10s"`},
+		{"echo", "", "", http.StatusOK, `"10s"`},
+		{"echo", RequestTimeoutHeader, "0ms", http.StatusGatewayTimeout, DeadlineExceededCode},
+		{"echo", RequestTimeoutHeader, "-5ms", http.StatusGatewayTimeout, DeadlineExceededCode},
+		{"echo", RequestTimeoutHeader, "soon", http.StatusBadRequest, InvalidTimeoutCode},
+		{"grpc", GRPCTimeoutHeader, "100m", http.StatusOK, `"true"`},
+		{"grpc", GRPCTimeoutHeader, "5x", http.StatusBadRequest, InvalidTimeoutCode},
+		{"grpc", "", "", http.StatusOK, `"false"`},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/"+tc.operation, strings.NewReader(`{}`))
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+		w := httptest.NewRecorder()
+		r.Mux().ServeHTTP(w, req)
+		if w.Code != tc.status || !strings.Contains(w.Body.String(), tc.reply) {
+			t.Errorf("%s %q: expected %d %s, got %d %s", tc.operation, tc.value, tc.status, tc.reply, w.Code, w.Body.String())
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/echo", nil)
+	SetRequestTimeout(req)
+	if timeout, err := time.ParseDuration(req.Header.Get(RequestTimeoutHeader)); err != nil || timeout <= 4*time.Second || timeout > 5*time.Second {
+		t.Errorf("expected the time left in the header, got %q", req.Header.Get(RequestTimeoutHeader))
+	}
+}
+
+func TestRawBody(t *testing.T) {
+	r := NewRouter()
+	csv := RegisterPost(r, "importCsv", func(ctx context.Context, body io.Reader) (TestResponse, *Error) {
+		lines, err := io.ReadAll(body)
+		if err != nil {
+			return TestResponse{}, &Error{Err: err}
+		}
+		return TestResponse{Reply: strconv.Itoa(strings.Count(string(lines), "\n"))}, nil
+	})
+	csv.SetSpec(Spec{RequestContentType: "text/csv"})
+	RegisterPost(r, "upper", func(ctx context.Context, body []byte) ([]byte, *Error) {
+		return []byte(strings.ToUpper(string(body))), nil
+	})
+	export := RegisterGet(r, "export", func(ctx context.Context, req TestRequest) (io.Reader, *Error) {
+		return io.NopCloser(strings.NewReader("id,name\n1,vel\n")), nil
+	})
+	export.SetSpec(Spec{ContentType: "text/csv"})
+	RegisterGet(r, "image", func(ctx context.Context, req TestRequest) ([]byte, *Error) {
+		WriterFromContext(ctx).Header().Set("Content-Type", "image/png")
+		return []byte{0x89, 'P', 'N', 'G'}, nil
+	})
+	if _, ok := csv.Input.([]byte); !ok {
+		t.Errorf("expected the raw input described by []byte, got %T", csv.Input)
+	}
+
+	for _, tc := range []struct {
+		method, target, body string
+		contentType, reply   string
+	}{
+		{http.MethodPost, "/importCsv", "a,b\n1,2\n3,4\n", "", `{"reply":"3"}`},
+		{http.MethodPost, "/upper", `{"not":"json"`, OctetStream, `{"NOT":"JSON"`},
+		{http.MethodGet, "/export", "", "text/csv", "id,name\n1,vel\n"},
+		{http.MethodGet, "/image", "", "image/png", "\x89PNG"},
+	} {
+		w := httptest.NewRecorder()
+		r.Mux().ServeHTTP(w, httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body)))
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != tc.contentType || strings.TrimSpace(w.Body.String()) != strings.TrimSpace(tc.reply) {
+			t.Errorf("%s: expected %s %q, got %d %s %q", tc.target, tc.contentType, tc.reply, w.Code, w.Header().Get("Content-Type"), w.Body.String())
+		}
+	}
+}
+
+func TestFormBody(t *testing.T) {
+	type signup struct {
+		Email string `schema:"email" json:"email"`
+		Age   int    `schema:"age" json:"age"`
+	}
+	r := NewRouter()
+	handler := func(ctx context.Context, req signup) (TestResponse, *Error) {
+		return TestResponse{Reply: req.Email + " " + strconv.Itoa(req.Age)}, nil
+	}
+	RegisterPost(r, "signup", handler).SetSpec(Spec{RequestContentType: FormURLEncoded})
+	RegisterPost(r, "json", handler)
+
+	for _, tc := range []struct {
+		path, contentType, body string
+		status                  int
+		reply                   string
+	}{
+		{"/signup?age=1", FormURLEncoded, "email=a%40b.c&age=30", http.StatusOK, `"a@b.c 30"`},
+		{"/signup", FormURLEncoded + "; charset=utf-8", "email=x", http.StatusOK, `"x 0"`},
+		{"/signup", FormURLEncoded, "age=old", http.StatusBadRequest, "FAILED_DECODING_REQUEST_BODY"},
+		{"/signup", "application/json", `{"email":"j","age":2}`, http.StatusOK, `"j 2"`},
+		// a route accepts the forms only if it declares them
+		{"/json", FormURLEncoded, "email=a", http.StatusBadRequest, "FAILED_DECODING_REQUEST_BODY"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", tc.contentType)
+		w := httptest.NewRecorder()
+		r.Mux().ServeHTTP(w, req)
+		if w.Code != tc.status || !strings.Contains(w.Body.String(), tc.reply) {
+			t.Errorf("%s %q: expected %d %s, got %d %s", tc.path, tc.body, tc.status, tc.reply, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestNestedQuery(t *testing.T) {
+	type filter struct {
+		Status []string   `schema:"status"`
+		Before *time.Time `schema:"before"`
+	}
+	type search struct {
+		Filter filter      `schema:"filter"`
+		Days   []time.Time `schema:"day"`
+		Limit  *int        `schema:"limit"`
+	}
+	r := NewRouter()
+	RegisterGet(r, "search", func(ctx context.Context, req search) (TestResponse, *Error) {
+		reply := fmt.Sprint(req.Filter.Status, req.Filter.Before != nil, req.Limit != nil)
+		for _, day := range req.Days {
+			reply += " " + day.Format(time.DateOnly)
+		}
+		return TestResponse{Reply: reply}, nil
+	})
+
+	for _, tc := range []struct {
+		query  string
+		status int
+		reply  string
+	}{
+		{"", http.StatusOK, `"[] false false"`},
+		{"filter.status=a&filter.status=b&limit=2", http.StatusOK, `"[a b] false true"`},
+		{"filter.before=2024-06-01T10:00:00Z", http.StatusOK, `"[] true false"`},
+		{"day=2024-06-01T10:00:00Z&day=2024-06-02T10:00:00Z", http.StatusOK, `"[] false false 2024-06-01 2024-06-02"`},
+		{"day=yesterday", http.StatusBadRequest, "FAILED_DECODING_QUERY"},
+		{"limit=many", http.StatusBadRequest, "FAILED_DECODING_QUERY"},
+	} {
+		w := httptest.NewRecorder()
+		r.Mux().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/search?"+tc.query, nil))
+		if w.Code != tc.status || !strings.Contains(w.Body.String(), tc.reply) {
+			t.Errorf("%q: expected %d %s, got %d %s", tc.query, tc.status, tc.reply, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestOptionalQuery(t *testing.T) {
+	type page struct {
+		Limit *int   `schema:"limit"`
+		Sort  string `schema:"sort,omitempty"`
+		Token string `schema:"token"`
+	}
+	decoder := newQueryDecoder(reflect.TypeFor[page]())
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{"", "<nil>  "},
+		{"limit=&sort=", "<nil>  "},
+		{"limit=5&sort=name&token=t", "5 name t"},
+	} {
+		values, err := url.ParseQuery(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got page
+		if err := decoder.Decode(&got, values); err != nil {
+			t.Errorf("%q: unexpected error %v", tc.query, err)
+			continue
+		}
+		limit := "<nil>"
+		if got.Limit != nil {
+			limit = strconv.Itoa(*got.Limit)
+		}
+		if s := limit + " " + got.Sort + " " + got.Token; s != tc.want {
+			t.Errorf("%q: expected %q, got %q", tc.query, tc.want, s)
+		}
+	}
+
+	if newQueryDecoder(reflect.TypeFor[struct {
+		Sort string `schema:"sort,omitempty"`
+	}]()).fields == nil {
+		t.Error("expected an omitempty field to be cached")
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	r := NewRouter()
+	RegisterPost(r, "user", func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		switch req.Message {
+		case "teapot":
+			return TestResponse{}, &Error{Code: "NOT_FOUND", Status: http.StatusTeapot}
+		case "":
+			return TestResponse{}, &Error{Code: "UNKNOWN"}
+		}
+		return TestResponse{}, &Error{Code: req.Message}
+	}).SetSpec(Spec{Errors: map[int][]ErrorSpec{
+		http.StatusNotFound: {{Code: "NOT_FOUND"}},
+		http.StatusConflict: {{Code: "CONFLICT"}, {Code: "DUPLICATE"}},
+	}})
+
+	for _, tc := range []struct {
+		name   string
+		status int
+	}{
+		{"NOT_FOUND", http.StatusNotFound},
+		{"DUPLICATE", http.StatusConflict},
+		{"teapot", http.StatusTeapot},
+		{"", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		r.Mux().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/user", strings.NewReader(`{"message":"`+tc.name+`"}`)))
+		if w.Code != tc.status {
+			t.Errorf("%q: expected status %d, got %d", tc.name, tc.status, w.Code)
+		}
+	}
+}
+
+func TestContextValue(t *testing.T) {
+	var first, second ContextValue[string]
+	var principal ContextValue[*Principal]
+	ctx := first.Set(context.Background(), "a")
+
+	if v, ok := first.From(ctx); !ok || v != "a" {
+		t.Errorf("expected the value a, got %q, %v", v, ok)
+	}
+	if _, ok := second.From(ctx); ok {
+		t.Error("expected another key of the same type not to see the value")
+	}
+	if p, ok := principal.From(ctx); ok || p != nil {
+		t.Errorf("expected no principal, got %v", p)
+	}
+	ctx = WithPrincipal(ctx, &Principal{ID: "u1"})
+	if p := PrincipalFromContext(ctx); p == nil || p.ID != "u1" {
+		t.Errorf("expected the principal u1, got %v", p)
+	}
+}
+
+func TestEnforceRequestHeaders(t *testing.T) {
+	r := NewRouter()
+	handler := func(ctx context.Context, req TestRequest) (TestResponse, *Error) {
+		return TestResponse{Reply: "ok"}, nil
+	}
+	header := KeyValueSpec{Key: "X-Region", ValueType: String, Validation: Validation{Required: true, MinLen: 2, MaxLen: 4, Enum: []string{"eu", "us", "apac", "a"}}}
+	RegisterPost(r, "enforced", handler).SetSpec(Spec{RequestHeaders: header, EnforceRequestHeaders: true})
+	RegisterPost(r, "documented", handler).SetSpec(Spec{RequestHeaders: header})
+
+	for _, tc := range []struct {
+		path, region string
+		status       int
+		// rules are the violated rules of the header in order
+		rules []string
+	}{
+		{"/enforced", "eu", http.StatusOK, nil},
+		{"/enforced", "", http.StatusBadRequest, []string{RuleRequired}},
+		{"/enforced", "a", http.StatusBadRequest, []string{RuleMinLen}},
+		{"/enforced", "x", http.StatusBadRequest, []string{RuleMinLen, RuleEnum}},
+		{"/enforced", "europe", http.StatusBadRequest, []string{RuleMaxLen, RuleEnum}},
+		{"/enforced", "mars", http.StatusBadRequest, []string{RuleEnum}},
+		{"/documented", "", http.StatusOK, nil},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(`{}`))
+		if tc.region != "" {
+			req.Header.Set("X-Region", tc.region)
+		}
+		w := httptest.NewRecorder()
+		r.Mux().ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s %q: expected status %d, got %d", tc.path, tc.region, tc.status, w.Code)
+			continue
+		}
+		if tc.rules == nil {
+			continue
+		}
+		var got Error
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatalf("failed to decode the error: %v", err)
+		}
+		var rules []string
+		for _, v := range got.Violations {
+			if v.Field != "X-Region" {
+				t.Errorf("%q: expected the violation of X-Region, got %+v", tc.region, v)
+			}
+			rules = append(rules, v.Rule)
+		}
+		if got.Code != InvalidRequestHeaderCode || !slices.Equal(rules, tc.rules) {
+			t.Errorf("%q: expected the %v violations, got %+v", tc.region, tc.rules, got)
+		}
+	}
+}
+
+func TestUUIDValidation(t *testing.T) {
+	// veluuid registers it, the package can't be imported by the tests of vel
+	RegisterUUIDType(reflect.TypeFor[uuid.UUID]())
+	type Member struct {
+		ID uuid.UUID `json:"id"`
+	}
+	type Team struct {
+		OwnerID *uuid.UUID `json:"ownerId"`
+		Members []Member   `json:"members"`
+	}
+	type TeamQuery struct {
+		ID uuid.UUID `schema:"id"`
+	}
+	r := NewRouter()
+	RegisterPost(r, "createTeam", func(ctx context.Context, req Team) (Team, *Error) {
+		return req, nil
+	})
+	RegisterPost(r, "joinTeam", func(ctx context.Context, req TeamQuery) (TeamQuery, *Error) {
+		return req, nil
+	}).SetSpec(Spec{RequestContentType: FormURLEncoded})
+	RegisterGet(r, "getTeam", func(ctx context.Context, req TeamQuery) (TeamQuery, *Error) {
+		return req, nil
+	})
+
+	const id = "3f2b8c4e-1a7d-4e9b-9c2a-5d6e7f8a9b0c"
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		status      int
+		violations  []Violation
+	}{
+		{name: "valid body", method: "POST", path: "/createTeam", body: `{"ownerId":"` + id + `","members":[{"id":"` + id + `"}]}`, status: http.StatusOK},
+		{
+			name: "malformed body", method: "POST", path: "/createTeam", body: `{"ownerId":"nope","members":[{"id":"` + id + `"},{"id":42}]}`,
+			status:     http.StatusUnprocessableEntity,
+			violations: []Violation{ViolationFormat("ownerId", FormatUUID), ViolationFormat("members.1.id", FormatUUID)},
+		},
+		{name: "syntax error", method: "POST", path: "/createTeam", body: `{"ownerId":`, status: http.StatusBadRequest},
+		{name: "valid query", method: "GET", path: "/getTeam?id=" + id, status: http.StatusOK},
+		{
+			name: "malformed query", method: "GET", path: "/getTeam?id=nope",
+			status: http.StatusUnprocessableEntity, violations: []Violation{ViolationFormat("id", FormatUUID)},
+		},
+		{
+			name: "malformed form", method: "POST", path: "/joinTeam", contentType: "application/x-www-form-urlencoded", body: "id=nope",
+			status: http.StatusUnprocessableEntity, violations: []Violation{ViolationFormat("id", FormatUUID)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			r.Mux().ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("expected %d, got %d %s", tt.status, w.Code, w.Body)
+			}
+			if tt.violations == nil {
+				return
+			}
+			var res Error
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Code != ValidationFailedCode || !reflect.DeepEqual(res.Violations, tt.violations) {
+				t.Errorf("unexpected error %s %v", res.Code, res.Violations)
+			}
+		})
+	}
+}
+
+type Card struct {
+	Kind  string `json:"kind" discriminator:"card"`
+	Last4 string `json:"last4"`
+}
+
+type BankAccount struct {
+	Kind string `json:"kind" discriminator:"bank"`
+	IBAN string `json:"iban"`
+}
+
+// PaymentMethod names the union by embedding it
+type PaymentMethod struct {
+	OneOf[Card, BankAccount]
+}
+
+func TestOneOf(t *testing.T) {
+	r := NewRouter()
+	RegisterGet(r, "paymentMethod", func(ctx context.Context, req struct {
+		Bank bool `schema:"bank"`
+	}) (OneOf[Card, BankAccount], *Error) {
+		if req.Bank {
+			return OneOfB[Card](BankAccount{IBAN: "DE89370400440532013000"}), nil
+		}
+		return OneOfA[Card, BankAccount](Card{Last4: "4242"}), nil
+	})
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{path: "/paymentMethod", expected: `{"kind":"card","last4":"4242"}`},
+		{path: "/paymentMethod?bank=true", expected: `{"kind":"bank","iban":"DE89370400440532013000"}`},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.Mux().ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != tt.expected {
+			t.Errorf("%s: expected %s, got %d %s", tt.path, tt.expected, w.Code, w.Body)
+		}
+	}
+
+	var method PaymentMethod
+	if err := json.Unmarshal([]byte(`{"kind":"bank","iban":"DE89"}`), &method); err != nil {
+		t.Fatal(err)
+	}
+	if account, ok := method.B(); !ok || account.IBAN != "DE89" || account.Kind != "bank" {
+		t.Errorf("expected the bank account decoded, got %#v", method.Value())
+	}
+	if err := json.Unmarshal([]byte(`{"kind":"cash"}`), &method); err == nil {
+		t.Error("expected an unknown kind to fail")
+	}
+	var empty OneOf[Card, BankAccount]
+	if data, err := json.Marshal(empty); err != nil || string(data) != "null" {
+		t.Errorf("expected an empty OneOf encoded as null, got %s %v", data, err)
+	}
+
+	type Cash struct {
+		Kind string `json:"type" discriminator:"cash"`
+	}
+	if _, err := OneOfUnion(reflect.TypeFor[OneOf[Card, Cash]]()); err == nil {
+		t.Error("expected the discriminators named differently to fail")
+	}
+	if _, err := OneOfUnion(reflect.TypeFor[OneOf[Card, Card]]()); err == nil {
+		t.Error("expected a repeated discriminator value to fail")
+	}
+	if _, err := OneOfUnion(reflect.TypeFor[OneOf[Card, string]]()); err == nil {
+		t.Error("expected a variant other than a struct to fail")
+	}
+}
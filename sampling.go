@@ -0,0 +1,325 @@
+package vel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redacted replaces the values of the redacted headers, query parameters and JSON fields of a sample
+const Redacted = "[REDACTED]"
+
+const defaultSampleBodySize = 64 << 10
+
+// sensitiveHeaders are redacted in every sample
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// Sample is a captured request of a route and its response, redacted by SamplingOpts
+type Sample struct {
+	OperationID string
+	Method      string
+	// URL includes the query of the request
+	URL             string
+	Status          int
+	Duration        time.Duration
+	RequestHeaders  http.Header
+	ResponseHeaders http.Header
+	// RequestBody and ResponseBody are the JSON payloads, nil if empty or not JSON
+	RequestBody  []byte
+	ResponseBody []byte
+	// RedactedFields are the fields redacted by the redact tag of the route types in addition to SamplingOpts.RedactFields, see IsRedacted
+	RedactedFields []string
+}
+
+// SampleSink receives the captured samples, e.g. writes them to a log or a bucket
+type SampleSink interface {
+	Capture(ctx context.Context, sample Sample)
+}
+
+type SampleSinkFunc func(ctx context.Context, sample Sample)
+
+func (f SampleSinkFunc) Capture(ctx context.Context, sample Sample) {
+	f(ctx, sample)
+}
+
+// SamplingOpts configures the Sampling middleware
+type SamplingOpts struct {
+	// Rate is the fraction of the captured requests, e.g. 0.05 captures 5% of them
+	Rate float64
+	// RedactHeaders lists the headers replaced by Redacted in addition to Authorization, Proxy-Authorization, Cookie and Set-Cookie
+	RedactHeaders []string
+	// RedactFields lists the JSON keys replaced by Redacted at any depth of the bodies, as well as the query parameters,
+	// the names are case-insensitive, e.g. password or cardNumber
+	RedactFields []string
+	// MaxBodySize bounds a captured body, 64KiB if zero, a longer body is dropped since it can't be redacted
+	MaxBodySize int
+	// ErrorThreshold captures the requests only while the error rate of the route is above it,
+	// e.g. 0.05 starts capturing once more than 5% of the responses fail and stops once the rate normalizes,
+	// zero captures regardless of the errors. A response with the status 400 or above is an error.
+	ErrorThreshold float64
+	// ErrorWindow is the period the error rate is computed over, a minute if zero
+	ErrorWindow time.Duration
+}
+
+// Sampling is a per-route middleware capturing a fraction of the requests with their responses to the sink
+// to debug production issues. The payloads are redacted before the sink receives them: the sensitive headers,
+// the fields of RedactFields and the fields of the route input and output tagged by redact:"true" are replaced by Redacted,
+// a body that isn't JSON is dropped.
+// The sink is called by the serving goroutine once the handler returns, a slow sink should buffer the samples.
+func Sampling(sink SampleSink, opts SamplingOpts) Middleware {
+	if opts.MaxBodySize == 0 {
+		opts.MaxBodySize = defaultSampleBodySize
+	}
+	if opts.ErrorWindow == 0 {
+		opts.ErrorWindow = time.Minute
+	}
+	redactor := newRedactor(opts)
+
+	return func(next http.Handler) http.Handler {
+		// every route wrapped by the middleware has its own error rate
+		errs := &errorRate{window: opts.ErrorWindow}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			capture := rand.Float64() < opts.Rate && (opts.ErrorThreshold == 0 || errs.rate(start) > opts.ErrorThreshold)
+			if !capture && opts.ErrorThreshold == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var requestBody *cappedBuffer
+			if capture && r.Body != nil {
+				requestBody = &cappedBuffer{limit: opts.MaxBodySize}
+				r.Body = readCloser{Reader: io.TeeReader(r.Body, requestBody), Closer: r.Body}
+			}
+			cw := &captureWriter{ResponseWriter: w, capture: capture, body: cappedBuffer{limit: opts.MaxBodySize}}
+			// the handler replaces the request in place, the sample reads the original one
+			method, requestURL, headers, ctx := r.Method, *r.URL, r.Header.Clone(), r.Context()
+			next.ServeHTTP(cw, r)
+
+			status := cw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			errs.record(start, status >= http.StatusBadRequest)
+			if !capture {
+				return
+			}
+
+			var operationID string
+			var fields []string
+			if meta := MetaFromContext(ctx); meta != nil {
+				operationID = meta.OperationID
+				fields = redactedFields(reflect.TypeOf(meta.Input), reflect.TypeOf(meta.Output))
+			}
+			redactor := redactor.with(fields)
+			sample := Sample{
+				OperationID:     operationID,
+				Method:          method,
+				URL:             redactor.url(requestURL),
+				Status:          status,
+				Duration:        time.Since(start),
+				RequestHeaders:  redactor.headers(headers),
+				ResponseHeaders: redactor.headers(w.Header().Clone()),
+				ResponseBody:    redactor.body(&cw.body),
+				RedactedFields:  fields,
+			}
+			if requestBody != nil {
+				sample.RequestBody = redactor.body(requestBody)
+			}
+			sink.Capture(ctx, sample)
+		})
+	}
+}
+
+// redactor applies the redaction rules of SamplingOpts
+type redactor struct {
+	headerKeys []string
+	fields     map[string]bool
+}
+
+func newRedactor(opts SamplingOpts) *redactor {
+	r := &redactor{headerKeys: append(append([]string{}, sensitiveHeaders...), opts.RedactHeaders...), fields: make(map[string]bool)}
+	for _, field := range opts.RedactFields {
+		r.fields[strings.ToLower(field)] = true
+	}
+	return r
+}
+
+// with extends the redacted fields, e.g. by the tagged fields of a route
+func (r *redactor) with(fields []string) *redactor {
+	if len(fields) == 0 {
+		return r
+	}
+	extended := &redactor{headerKeys: r.headerKeys, fields: maps.Clone(r.fields)}
+	for _, field := range fields {
+		extended.fields[strings.ToLower(field)] = true
+	}
+	return extended
+}
+
+func (r *redactor) headers(h http.Header) http.Header {
+	for _, key := range r.headerKeys {
+		if _, ok := h[http.CanonicalHeaderKey(key)]; ok {
+			h.Set(key, Redacted)
+		}
+	}
+	return h
+}
+
+func (r *redactor) url(u url.URL) string {
+	query := u.Query()
+	redacted := false
+	for key := range query {
+		if r.fields[strings.ToLower(key)] {
+			query.Set(key, Redacted)
+			redacted = true
+		}
+	}
+	if redacted {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// body redacts the JSON body, a body that is truncated or isn't JSON is dropped
+func (r *redactor) body(b *cappedBuffer) []byte {
+	if b.truncated || b.Len() == 0 {
+		return nil
+	}
+	decoder := json.NewDecoder(&b.Buffer)
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil
+	}
+	redacted, err := json.Marshal(r.value(value))
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+func (r *redactor) value(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if r.fields[strings.ToLower(key)] {
+				v[key] = Redacted
+				continue
+			}
+			v[key] = r.value(field)
+		}
+	case []any:
+		for i := range v {
+			v[i] = r.value(v[i])
+		}
+	}
+	return v
+}
+
+// errorRate counts the responses and the errors of the current and the previous windows
+type errorRate struct {
+	mu          sync.Mutex
+	window      time.Duration
+	start       time.Time
+	total, errs int
+	prevTotal   int
+	prevErrs    int
+}
+
+// roll starts a new window once the current one is over, the previous window is dropped after two of them
+func (e *errorRate) roll(now time.Time) {
+	switch elapsed := now.Sub(e.start); {
+	case elapsed < e.window:
+	case elapsed < 2*e.window:
+		e.prevTotal, e.prevErrs = e.total, e.errs
+		e.total, e.errs = 0, 0
+		e.start = e.start.Add(e.window)
+	default:
+		e.prevTotal, e.prevErrs, e.total, e.errs = 0, 0, 0, 0
+		e.start = now
+	}
+}
+
+func (e *errorRate) record(now time.Time, failed bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.roll(now)
+	e.total++
+	if failed {
+		e.errs++
+	}
+}
+
+// rate is the share of errors in the current and the previous windows
+func (e *errorRate) rate(now time.Time) float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.roll(now)
+	total := e.total + e.prevTotal
+	if total == 0 {
+		return 0
+	}
+	return float64(e.errs+e.prevErrs) / float64(total)
+}
+
+// cappedBuffer keeps up to limit bytes of a body
+type cappedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+// Write never fails, the rest of the body is discarded once the limit is reached
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := b.limit - b.Len(); room < n {
+		b.truncated = true
+		p = p[:max(room, 0)]
+	}
+	b.Buffer.Write(p)
+	return n, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// captureWriter remembers the status and a copy of the body if the request is captured
+type captureWriter struct {
+	http.ResponseWriter
+	capture bool
+	status  int
+	body    cappedBuffer
+}
+
+func (w *captureWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.capture {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *captureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
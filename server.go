@@ -0,0 +1,73 @@
+package vel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+)
+
+// Server serves a router until SIGINT or SIGTERM arrives and then shuts it down gracefully, see Router.Serve
+type Server struct {
+	// HTTP is the served server, its handler defaults to the router mux
+	HTTP   *http.Server
+	Router *Router
+	Opts   ShutdownOpts
+
+	hooks []func(ctx context.Context) error
+}
+
+// NewServer makes a server of the router, a nil http server is the zero one listening on :http
+func NewServer(r *Router, server *http.Server, opts ShutdownOpts) *Server {
+	if server == nil {
+		server = &http.Server{}
+	}
+	return &Server{HTTP: server, Router: r, Opts: opts}
+}
+
+// OnShutdown registers a hook run once the server stopped serving, e.g. closing a database pool.
+// The hooks run in the reverse order of the registration, their context is done once the Timeout of the options is over.
+func (s *Server) OnShutdown(hook func(ctx context.Context) error) {
+	s.hooks = append(s.hooks, hook)
+}
+
+// ListenAndServe listens on the address of the http server and serves, see Serve
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	return s.run(ctx, func(ctx context.Context) error {
+		return s.Router.Serve(ctx, s.HTTP, s.Opts)
+	})
+}
+
+// Serve serves the listener by Router.Serve until the context is done or the process gets SIGINT or SIGTERM,
+// then it runs the shutdown hooks. The hooks run also if serving fails. A second signal terminates the process.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	return s.run(ctx, func(ctx context.Context) error {
+		return s.Router.serve(ctx, s.HTTP, s.Opts, func() error { return s.HTTP.Serve(ln) })
+	})
+}
+
+// run serves until a signal arrives and runs the hooks
+func (s *Server) run(ctx context.Context, serve func(ctx context.Context) error) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// the signals are handled by default again once the shutdown starts
+	context.AfterFunc(ctx, stop)
+	err := serve(ctx)
+
+	hooksCtx, cancel := context.WithTimeout(context.Background(), s.Opts.withDefaults().Timeout)
+	defer cancel()
+	return errors.Join(err, s.runHooks(hooksCtx))
+}
+
+// runHooks runs every hook, a failed one doesn't stop the others
+func (s *Server) runHooks(ctx context.Context) error {
+	var errs []error
+	for _, hook := range slices.Backward(s.hooks) {
+		errs = append(errs, hook(ctx))
+	}
+	return errors.Join(errs...)
+}
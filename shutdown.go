@@ -0,0 +1,216 @@
+package vel
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ShuttingDownCode is the code of the error answering /healthz, /readyz and a stream opened while the router is shutting down
+const ShuttingDownCode = "SHUTTING_DOWN"
+
+// SSEShutdownNotice is an event telling SSE clients the server is going away, they reconnect to another instance
+const SSEShutdownNotice = "event: shutdown\ndata: {}\n\n"
+
+// ShutdownOpts configures the graceful shutdown of Serve and Shutdown
+type ShutdownOpts struct {
+	// Grace is how long the streams may finish after they're notified of the shutdown,
+	// the contexts of the remaining ones are canceled then, 5 seconds if zero
+	Grace time.Duration
+	// Timeout bounds the whole shutdown including the regular requests, 30 seconds if zero
+	Timeout time.Duration
+	// DrainDelay is how long /healthz and /readyz answer 503 before the server stops accepting connections,
+	// so the load balancers stop routing to the instance, zero doesn't wait
+	DrainDelay time.Duration
+}
+
+func (o ShutdownOpts) withDefaults() ShutdownOpts {
+	if o.Grace == 0 {
+		o.Grace = 5 * time.Second
+	}
+	if o.Timeout == 0 {
+		o.Timeout = 30 * time.Second
+	}
+	return o
+}
+
+// Serve listens on the address of the server and serves the router until the context is done, then shuts down, see Shutdown.
+// The server handler defaults to the router mux.
+func (r *Router) Serve(ctx context.Context, server *http.Server, opts ShutdownOpts) error {
+	return r.serve(ctx, server, opts, server.ListenAndServe)
+}
+
+// serve runs listen, e.g. the ListenAndServe of the server, until the context is done, then shuts down, see Serve
+func (r *Router) serve(ctx context.Context, server *http.Server, opts ShutdownOpts, listen func() error) error {
+	if server.Handler == nil {
+		server.Handler = r.mux
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- listen()
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	return r.Shutdown(server, opts)
+}
+
+// Shutdown stops the server gracefully: /healthz and /readyz turn unready for the drain delay, then the server stops accepting
+// connections and waits for the regular requests, while the streams registered by the Stream middleware are notified,
+// get the grace period to finish and then their contexts are canceled. http.Server doesn't wait for hijacked
+// connections, e.g. WebSockets, the handlers of the streams are waited for instead. The async operations are waited for too.
+func (r *Router) Shutdown(server *http.Server, opts ShutdownOpts) error {
+	opts = opts.withDefaults()
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+	return r.shutdown(ctx, server, opts)
+}
+
+func (r *Router) shutdown(ctx context.Context, server *http.Server, opts ShutdownOpts) error {
+	r.shared.unready.Store(true)
+	if opts.DrainDelay > 0 {
+		timer := time.NewTimer(opts.DrainDelay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+	}
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.Shutdown(ctx)
+	}()
+	drained := r.shared.streams.drain(ctx, opts.Grace)
+	return errors.Join(<-stopped, drained, r.shared.jobs.wait(ctx))
+}
+
+// Stream is a per-route middleware of long-lived responses, e.g. SSE or WebSockets, making the route shutdown-aware:
+// on shutdown the notice is written and flushed to the open streams, e.g. SSEShutdownNotice, empty writes nothing.
+// Handlers may watch Draining to say goodbye themselves, e.g. by a WebSocket close frame,
+// the context of the request is canceled once the grace period is over.
+// A stream opened while shutting down is answered by 503 Service Unavailable.
+func Stream(notice string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rt := routeFromContext(r.Context())
+			if rt == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+			s := &stream{writer: &syncWriter{ResponseWriter: w}, notice: notice, cancel: cancel}
+			if !rt.shared.streams.add(s) {
+				writeError(w, r, http.StatusServiceUnavailable, &Error{Code: ShuttingDownCode, Message: "the server is shutting down"})
+				return
+			}
+			defer rt.shared.streams.remove(s)
+			next.ServeHTTP(s.writer, r.WithContext(ctx))
+		})
+	}
+}
+
+// Draining returns a channel closed once the router serving the request starts shutting down,
+// nil, i.e. never closed, if the handler is not registered on a Router.
+func Draining(ctx context.Context) <-chan struct{} {
+	if rt := routeFromContext(ctx); rt != nil {
+		return rt.shared.streams.draining
+	}
+	return nil
+}
+
+// stream is an open response of a route using the Stream middleware
+type stream struct {
+	writer *syncWriter
+	notice string
+	cancel context.CancelFunc
+}
+
+// streamSet tracks the open streams of a router and its subrouters
+type streamSet struct {
+	mu       sync.Mutex
+	active   map[*stream]struct{}
+	wg       sync.WaitGroup
+	draining chan struct{}
+	closed   bool
+}
+
+func newStreamSet() *streamSet {
+	return &streamSet{active: make(map[*stream]struct{}), draining: make(chan struct{})}
+}
+
+// add tracks the stream, it reports false once the set is draining
+func (s *streamSet) add(st *stream) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.active[st] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *streamSet) remove(st *stream) {
+	s.mu.Lock()
+	delete(s.active, st)
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+// drain notifies the open streams, waits the grace period for them to finish, cancels the remaining ones
+// and waits for their handlers to return until the context is done
+func (s *streamSet) drain(ctx context.Context, grace time.Duration) error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.draining)
+	}
+	active := make([]*stream, 0, len(s.active))
+	for st := range s.active {
+		active = append(active, st)
+	}
+	s.mu.Unlock()
+
+	for _, st := range active {
+		if st.notice != "" {
+			// a hijacked connection can't be written, its handler watches Draining
+			st.writer.heartbeat([]byte(st.notice))
+		}
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(finished)
+	}()
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-finished:
+		return nil
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	for st := range s.active {
+		st.cancel()
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return errors.New("streams didn't finish before the shutdown timeout")
+	}
+}
@@ -0,0 +1,290 @@
+package vel
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// SignatureHeader carries the HMAC of the request, hex or base64 encoded, see Signature
+	SignatureHeader = "X-Signature"
+	// SignatureTimestampHeader carries the unix time in seconds the request is signed at
+	SignatureTimestampHeader = "X-Signature-Timestamp"
+	// SignatureKeyIDHeader identifies the secret the request is signed with
+	SignatureKeyIDHeader = "X-Signature-Key-Id"
+
+	InvalidSignatureCode = "INVALID_SIGNATURE"
+
+	defaultClockSkew      = 5 * time.Minute
+	defaultSignedBodySize = 1 << 20
+	signatureSchemeName   = "hmacSignature"
+)
+
+type SignatureAlgorithm string
+
+const (
+	HMACSHA256 SignatureAlgorithm = "hmac-sha256"
+	HMACSHA512 SignatureAlgorithm = "hmac-sha512"
+	// HMACSHA1 suits the legacy partners only
+	HMACSHA1 SignatureAlgorithm = "hmac-sha1"
+)
+
+func (a SignatureAlgorithm) hash() (func() hash.Hash, error) {
+	switch a {
+	case HMACSHA256, "":
+		return sha256.New, nil
+	case HMACSHA512:
+		return sha512.New, nil
+	case HMACSHA1:
+		return sha1.New, nil
+	}
+	return nil, fmt.Errorf("unknown signature algorithm %q", a)
+}
+
+// ErrUnknownSignatureKey is returned by SignatureOpts.Secret for a key id it doesn't know
+var ErrUnknownSignatureKey = errors.New("unknown signature key")
+
+type SignatureOpts struct {
+	// Secret returns the secret of the key id, ErrUnknownSignatureKey if it doesn't know the key,
+	// the key id is empty if the request doesn't send SignatureKeyIDHeader
+	Secret func(ctx context.Context, keyID string) ([]byte, error)
+	// Algorithm is HMACSHA256 if empty
+	Algorithm SignatureAlgorithm
+	// ClockSkew bounds how far the timestamp of a request may be from the server time, 5 minutes if zero
+	ClockSkew time.Duration
+	// ReplayCache rejects a signature seen already within the clock skew, nil doesn't check the replays
+	ReplayCache ReplayCache
+	// MaxBodySize limits the signed body, a larger request is rejected, 1MB if zero
+	MaxBodySize int64
+}
+
+// ReplayCache remembers the verified signatures, e.g. in Redis to share them between instances
+type ReplayCache interface {
+	// Seen records the signature until it expires, it reports whether the signature is recorded already
+	Seen(ctx context.Context, signature string, expires time.Time) (bool, error)
+}
+
+// Signature is a middleware verifying the HMAC signature of the requests, e.g. of the webhooks or the partner APIs.
+// A request sends the signature of its timestamp, method, path with the query and body, see SignRequest,
+// in SignatureHeader along with SignatureTimestampHeader and SignatureKeyIDHeader.
+// A request signed with an unknown key, with a wrong signature, outside the clock skew or replayed is answered
+// by 401 INVALID_SIGNATURE. The handlers get the verified key id by SignatureKeyID.
+// The headers are documented in the OpenAPI spec of the routes using the middleware.
+func Signature(opts SignatureOpts) Middleware {
+	newHash, err := opts.Algorithm.hash()
+	if err != nil {
+		panic("vel: " + err.Error())
+	}
+	opts.ClockSkew = cmp.Or(opts.ClockSkew, defaultClockSkew)
+	opts.MaxBodySize = cmp.Or(opts.MaxBodySize, defaultSignedBodySize)
+	return func(next http.Handler) http.Handler {
+		return &signatureHandler{next: next, opts: opts, newHash: newHash}
+	}
+}
+
+type signatureHandler struct {
+	next    http.Handler
+	opts    SignatureOpts
+	newHash func() hash.Hash
+}
+
+// requestHeaders documents the headers in the meta of the route, see RegisterHandler
+func (h *signatureHandler) requestHeaders() []KeyValueSpec {
+	return SignatureHeaders()
+}
+
+// securityScheme documents the signature in the meta of the route, see RegisterHandler
+func (h *signatureHandler) securityScheme() SecurityScheme {
+	return SignatureSecurityScheme(h.opts)
+}
+
+// SignatureSecurityScheme documents the signature verified by Signature with the options,
+// e.g. the signature of the webhooks a receiver verifies
+func SignatureSecurityScheme(opts SignatureOpts) SecurityScheme {
+	return SecurityScheme{
+		Name:   signatureSchemeName,
+		Header: SignatureHeader,
+		Description: fmt.Sprintf("%s of the timestamp, the method, the path with the query and the body joined by newlines, "+
+			"hex or base64 encoded, the timestamp may differ from the server time by %s", cmp.Or(opts.Algorithm, HMACSHA256), cmp.Or(opts.ClockSkew, defaultClockSkew)),
+	}
+}
+
+// SignatureHeaders documents the headers sent along with the signature, see Signature
+func SignatureHeaders() []KeyValueSpec {
+	return []KeyValueSpec{{
+		Key:         SignatureTimestampHeader,
+		ValueType:   Int,
+		Description: "unix time in seconds the request is signed at",
+		Validation:  Validation{Required: true},
+	}, {
+		Key:         SignatureKeyIDHeader,
+		ValueType:   String,
+		Description: "id of the key the request is signed with",
+	}}
+}
+
+func (h *signatureHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	keyID, status, err := h.verify(r)
+	switch {
+	case status >= http.StatusInternalServerError:
+		writeError(w, r, status, &Error{Err: err})
+		return
+	case err != nil:
+		writeError(w, r, status, &Error{Code: InvalidSignatureCode, Message: err.Error()})
+		return
+	}
+	h.next.ServeHTTP(w, r.WithContext(signatureKey.Set(r.Context(), keyID)))
+}
+
+// verify checks the signature of the request and returns its key id, the body is read and restored.
+// The status is 401 for an invalid signature and 500 if the signature can't be checked.
+func (h *signatureHandler) verify(r *http.Request) (string, int, error) {
+	ctx := r.Context()
+	signature, err := decodeSignature(r.Header.Get(SignatureHeader))
+	if err != nil {
+		return "", http.StatusUnauthorized, err
+	}
+	timestamp, err := strconv.ParseInt(r.Header.Get(SignatureTimestampHeader), 10, 64)
+	if err != nil {
+		return "", http.StatusUnauthorized, errors.New("the signature timestamp is missing or malformed")
+	}
+	signedAt := time.Unix(timestamp, 0)
+	if skew := time.Since(signedAt); skew > h.opts.ClockSkew || skew < -h.opts.ClockSkew {
+		return "", http.StatusUnauthorized, errors.New("the signature timestamp is outside the allowed clock skew")
+	}
+
+	keyID := r.Header.Get(SignatureKeyIDHeader)
+	secret, err := h.opts.Secret(ctx, keyID)
+	if errors.Is(err, ErrUnknownSignatureKey) {
+		return "", http.StatusUnauthorized, errors.New("the signature key is unknown")
+	}
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("failed to get the signature key: %w", err)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, h.opts.MaxBodySize+1))
+	if err != nil {
+		return "", http.StatusBadRequest, fmt.Errorf("failed to read the signed body: %w", err)
+	}
+	if int64(len(body)) > h.opts.MaxBodySize {
+		return "", http.StatusRequestEntityTooLarge, errors.New("the signed body is too large")
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	expected := signRequest(h.newHash, secret, timestamp, r.Method, r.URL.RequestURI(), body)
+	if !hmac.Equal(signature, expected) {
+		return "", http.StatusUnauthorized, errors.New("the signature doesn't match the request")
+	}
+
+	if h.opts.ReplayCache != nil {
+		seen, err := h.opts.ReplayCache.Seen(ctx, keyID+":"+hex.EncodeToString(signature), signedAt.Add(h.opts.ClockSkew))
+		if err != nil {
+			return "", http.StatusInternalServerError, fmt.Errorf("failed to check the signature replay: %w", err)
+		}
+		if seen {
+			return "", http.StatusUnauthorized, errors.New("the signed request is replayed")
+		}
+	}
+	return keyID, 0, nil
+}
+
+func decodeSignature(value string) ([]byte, error) {
+	if value == "" {
+		return nil, errors.New("the " + SignatureHeader + " header is missing")
+	}
+	if signature, err := hex.DecodeString(value); err == nil {
+		return signature, nil
+	}
+	if signature, err := base64.StdEncoding.DecodeString(value); err == nil {
+		return signature, nil
+	}
+	return nil, errors.New("the signature is neither hex nor base64 encoded")
+}
+
+func signRequest(newHash func() hash.Hash, secret []byte, timestamp int64, method, uri string, body []byte) []byte {
+	mac := hmac.New(newHash, secret)
+	fmt.Fprintf(mac, "%d\n%s\n%s\n", timestamp, method, uri)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// SignRequest signs the request the way Signature verifies it, e.g. in a partner client or a test,
+// the body is read and restored
+func SignRequest(r *http.Request, keyID string, secret []byte, algorithm SignatureAlgorithm) error {
+	newHash, err := algorithm.hash()
+	if err != nil {
+		return err
+	}
+	var body []byte
+	if r.Body != nil {
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	timestamp := time.Now().Unix()
+	r.Header.Set(SignatureTimestampHeader, strconv.FormatInt(timestamp, 10))
+	if keyID != "" {
+		r.Header.Set(SignatureKeyIDHeader, keyID)
+	}
+	r.Header.Set(SignatureHeader, hex.EncodeToString(signRequest(newHash, secret, timestamp, r.Method, r.URL.RequestURI(), body)))
+	return nil
+}
+
+// SignatureKeyID returns the key id of the request verified by Signature, empty if the request isn't verified
+// or it's signed without a key id
+func SignatureKeyID(ctx context.Context) string {
+	keyID, _ := signatureKey.From(ctx)
+	return keyID
+}
+
+// MemoryReplayCache remembers the signatures in memory, it suits a single instance
+type MemoryReplayCache struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+	swept   time.Time
+}
+
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{entries: make(map[string]time.Time), swept: time.Now()}
+}
+
+func (c *MemoryReplayCache) Seen(_ context.Context, signature string, expires time.Time) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.sweep(now)
+	if expiry, ok := c.entries[signature]; ok && now.Before(expiry) {
+		return true, nil
+	}
+	c.entries[signature] = expires
+	return false, nil
+}
+
+// sweep drops the expired signatures once a minute
+func (c *MemoryReplayCache) sweep(now time.Time) {
+	if now.Sub(c.swept) < time.Minute {
+		return
+	}
+	c.swept = now
+	for signature, expires := range c.entries {
+		if now.After(expires) {
+			delete(c.entries, signature)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package vel
+
+import "net/http"
+
+const (
+	// SpecHashHeader carries the spec hash of the generated client making the request
+	SpecHashHeader = "X-Spec-Hash"
+	// SpecOutdatedHeader is "true" in the responses to clients generated from another spec
+	SpecOutdatedHeader = "X-Spec-Outdated"
+)
+
+// SpecHashMiddleware hints the clients generated from another version of the API, e.g. a SPA after a deployment,
+// with the SpecOutdatedHeader, so they may ask users to reload. The hash is made by gen.SpecHash of the router.
+// Requests without the SpecHashHeader are served as is.
+func SpecHashMiddleware(hash string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if clientHash := r.Header.Get(SpecHashHeader); clientHash != "" && clientHash != hash {
+				w.Header().Set(SpecOutdatedHeader, "true")
+				// cross-origin clients can read the header only if it's exposed
+				w.Header().Add("Access-Control-Expose-Headers", SpecOutdatedHeader)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
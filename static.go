@@ -0,0 +1,68 @@
+package vel
+
+import (
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Static serves the files of fsys under the route path relative to the router prefix, e.g. /assets/app.js serves app.js.
+// The router middlewares and the given ones wrap the file server. The files are not a part of the router meta,
+// therefore they're not generated in clients nor in the OpenAPI spec.
+func (r *Router) Static(route string, fsys fs.FS, middlewares ...Middleware) {
+	prefix := r.staticPrefix(route)
+	r.handleStatic(prefix, http.StripPrefix(prefix, http.FileServerFS(fsys)), middlewares, callSite())
+}
+
+// SPA serves a single page application under the route path relative to the router prefix: the files of fsys,
+// and the index file for the other paths, so the client-side router resolves them, e.g. /settings serves index.html.
+// A missing path with an extension is answered by 404, it's a missing asset rather than a page.
+// The more specific routes take precedence, e.g. the API ones, see Static for the middlewares and the meta.
+func (r *Router) SPA(route string, fsys fs.FS, index string, middlewares ...Middleware) {
+	prefix := r.staticPrefix(route)
+	files := http.StripPrefix(prefix, http.FileServerFS(fsys))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name := strings.Trim(strings.TrimPrefix(req.URL.Path, prefix), "/")
+		if name == "" {
+			serveIndex(w, req, fsys, index)
+			return
+		}
+		if _, err := fs.Stat(fsys, name); err == nil || !fs.ValidPath(name) {
+			files.ServeHTTP(w, req)
+			return
+		}
+		if path.Ext(name) != "" {
+			http.NotFound(w, req)
+			return
+		}
+		serveIndex(w, req, fsys, index)
+	})
+	r.handleStatic(prefix, handler, middlewares, callSite())
+}
+
+// serveIndex serves the index file without caching it, so a new deployment replaces it at once
+func serveIndex(w http.ResponseWriter, r *http.Request, fsys fs.FS, index string) {
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFileFS(w, r, fsys, index)
+}
+
+// staticPrefix joins the path to the router prefix, the root path is empty
+func (r *Router) staticPrefix(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return r.prefix
+	}
+	return r.prefix + "/" + p
+}
+
+// handleStatic serves the subtree of the prefix with the middlewares
+func (r *Router) handleStatic(prefix string, handler http.Handler, middlewares []Middleware, site string) {
+	for i := range middlewares {
+		handler = middlewares[i](handler)
+	}
+	for i := range r.middlewares {
+		handler = r.middlewares[i](handler)
+	}
+	r.handle(http.MethodGet+" "+prefix+"/", handler, nil, site)
+}
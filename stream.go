@@ -0,0 +1,220 @@
+package vel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// JSONHeartbeat keeps a JSON response alive, JSON decoders skip leading whitespaces
+	JSONHeartbeat = "\n"
+	// SSEHeartbeat is an event stream comment ignored by SSE clients
+	SSEHeartbeat = ": keep-alive\n\n"
+)
+
+var ErrFlushNotSupported = errors.New("response writer doesn't support flushing")
+
+// ErrNotStreaming is returned by WriteItem called outside of a route declaring Spec.Stream
+var ErrNotStreaming = errors.New("route doesn't declare a stream")
+
+// StreamFormat is the encoding of the items of a streaming route
+type StreamFormat string
+
+const (
+	// StreamSSE sends every item as a server-sent event, an error after the first item is sent as an error event
+	StreamSSE StreamFormat = "sse"
+	// StreamNDJSON sends every item as a line of JSON, an error after the first item is sent as a line {"$error": ...}
+	StreamNDJSON StreamFormat = "ndjson"
+)
+
+// ContentType returns the content type of the stream
+func (f StreamFormat) ContentType() string {
+	if f == StreamSSE {
+		return "text/event-stream"
+	}
+	return NDJSON
+}
+
+// streamState is the stream of a request served by a route declaring Spec.Stream
+type streamState struct {
+	format  StreamFormat
+	started bool
+}
+
+// WriteItem sends an item of the stream declared by Spec.Stream and flushes it, the generated clients iterate over the items.
+// The handler returns the zero output once the stream is over, the output isn't written.
+// An error returned before the first item is a regular error response, after it the error ends the stream.
+func WriteItem(ctx context.Context, item any) error {
+	return WriteEvent(ctx, "", item)
+}
+
+// WriteEvent sends an item like WriteItem along with its id, an SSE client resuming a dropped stream
+// sends the id of the last event it got, see LastEventID. The id is a single line, it's left out of an NDJSON stream.
+func WriteEvent(ctx context.Context, id string, item any) error {
+	state, ok := streamKey.From(ctx)
+	w := WriterFromContext(ctx)
+	if !ok || w == nil {
+		return ErrNotStreaming
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	state.started = true
+	switch {
+	case state.format == StreamSSE && id != "":
+		_, err = fmt.Fprintf(w, "id: %s\ndata: %s\n\n", id, data)
+	case state.format == StreamSSE:
+		_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+	default:
+		_, err = w.Write(append(data, '\n'))
+	}
+	if err != nil {
+		return err
+	}
+	// a writer without flushing still delivers the items, just not one by one
+	_ = Flush(ctx)
+	return nil
+}
+
+// LastEventID returns the id of the last event a client resuming an SSE stream got, empty for a new stream.
+// The handler continues the stream after the event, see WriteEvent.
+func LastEventID(ctx context.Context) string {
+	if r := RequestFromContext(ctx); r != nil {
+		return r.Header.Get("Last-Event-ID")
+	}
+	return ""
+}
+
+// writeStreamError ends the started stream by the error encoded by the error encoder of the router
+func writeStreamError(w http.ResponseWriter, r *http.Request, state *streamState, e *Error) {
+	buf := &bufferWriter{header: make(http.Header)}
+	writeError(buf, r, errorStatus(r, e), e)
+	data := bytes.TrimSpace(buf.body.Bytes())
+	if state.format == StreamSSE {
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+	} else {
+		fmt.Fprintf(w, "{\"$error\":%s}\n", data)
+	}
+}
+
+// bufferWriter keeps the body written by an error encoder
+type bufferWriter struct {
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (w *bufferWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *bufferWriter) WriteHeader(int) {}
+
+// Flush sends the buffered part of the response to the client,
+// it's meant for long-polling or slow-streaming handlers.
+func Flush(ctx context.Context) error {
+	w := WriterFromContext(ctx)
+	if w == nil {
+		return ErrFlushNotSupported
+	}
+	if err := http.NewResponseController(w).Flush(); err != nil {
+		return ErrFlushNotSupported
+	}
+	return nil
+}
+
+// WriteComment writes an event stream comment and flushes it,
+// a comment keeps the connection alive without producing an event on the client side.
+func WriteComment(ctx context.Context, comment string) error {
+	w := WriterFromContext(ctx)
+	if w == nil {
+		return ErrFlushNotSupported
+	}
+	if _, err := w.Write([]byte(": " + comment + "\n\n")); err != nil {
+		return err
+	}
+	return Flush(ctx)
+}
+
+// Heartbeat is a per-route middleware writing the payload every interval while the handler is running,
+// so proxies and load balancers don't time out long-polling or slow-streaming responses.
+// The first heartbeat commits the status code 200, errors returned after it can't change the status.
+// Use JSONHeartbeat for JSON responses and SSEHeartbeat for event streams.
+func Heartbeat(interval time.Duration, payload string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sw := &syncWriter{ResponseWriter: w}
+			// the handler replaces the request in place, the context is read before it starts
+			ctx := r.Context()
+			done := make(chan struct{})
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-done:
+						return
+					case <-ctx.Done():
+						return
+					case <-ticker.C:
+						sw.heartbeat([]byte(payload))
+					}
+				}
+			}()
+
+			next.ServeHTTP(sw, r)
+			close(done)
+			<-stopped
+		})
+	}
+}
+
+// syncWriter serializes writes of a handler and a background heartbeat
+type syncWriter struct {
+	http.ResponseWriter
+	mu sync.Mutex
+}
+
+func (w *syncWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *syncWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *syncWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *syncWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *syncWriter) heartbeat(payload []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.ResponseWriter.Write(payload); err != nil {
+		return
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
@@ -0,0 +1,46 @@
+package vel
+
+import "context"
+
+// TypedMiddleware wraps a handler with access to the decoded input and the output before it's encoded,
+// e.g. normalizes the input or enriches the output. It runs after the input is validated
+// and inside the http middlewares of the route, see WithMiddlewares.
+type TypedMiddleware[I, O any] func(next Handler[I, O]) Handler[I, O]
+
+// WithMiddlewares wraps the handler by the typed middlewares, the first one runs first:
+//
+//	vel.RegisterPost(router, "createUser", vel.WithMiddlewares(CreateUser, trimName), authMiddleware)
+func WithMiddlewares[I, O any](handler Handler[I, O], middlewares ...TypedMiddleware[I, O]) Handler[I, O] {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// MapInput is a typed middleware replacing the input before the handler is called,
+// an error is returned to the client without calling the handler
+func MapInput[I, O any](f func(ctx context.Context, i I) (I, *Error)) TypedMiddleware[I, O] {
+	return func(next Handler[I, O]) Handler[I, O] {
+		return func(ctx context.Context, i I) (O, *Error) {
+			i, err := f(ctx, i)
+			if err != nil {
+				var o O
+				return o, err
+			}
+			return next(ctx, i)
+		}
+	}
+}
+
+// MapOutput is a typed middleware replacing the output of a successful call
+func MapOutput[I, O any](f func(ctx context.Context, o O) (O, *Error)) TypedMiddleware[I, O] {
+	return func(next Handler[I, O]) Handler[I, O] {
+		return func(ctx context.Context, i I) (O, *Error) {
+			o, err := next(ctx, i)
+			if err != nil {
+				return o, err
+			}
+			return f(ctx, o)
+		}
+	}
+}
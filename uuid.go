@@ -0,0 +1,210 @@
+package vel
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/schema"
+)
+
+// FormatUUID is the format of a UUID string, e.g. 3f2b8c4e-1a7d-4e9b-9c2a-5d6e7f8a9b0c, see ViolationFormat
+const FormatUUID = "uuid"
+
+var (
+	uuidTypesMu sync.RWMutex
+	uuidTypes   []reflect.Type
+	// hasUUIDCache caches whether a type holds a UUID at any depth
+	hasUUIDCache sync.Map // reflect.Type -> bool
+)
+
+// RegisterUUIDType teaches the decoding a UUID type, the type implements encoding.TextUnmarshaler, e.g. gofrs/uuid,
+// the veluuid package registers github.com/google/uuid.UUID. A malformed UUID of a request is reported
+// as the violation of the format rule of its field instead of a failed decoding, see IsUUID.
+// It must be called before the handlers using the type are registered.
+func RegisterUUIDType(t reflect.Type) {
+	if !reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		panic(t.String() + " must implement encoding.TextUnmarshaler to be a UUID type")
+	}
+	uuidTypesMu.Lock()
+	defer uuidTypesMu.Unlock()
+	if !slices.Contains(uuidTypes, t) {
+		uuidTypes = append(uuidTypes, t)
+		hasUUIDCache.Clear()
+	}
+}
+
+// IsUUID reports whether the type is registered by RegisterUUIDType,
+// the spec describes it as a string of the uuid format and the clients as a string
+func IsUUID(t reflect.Type) bool {
+	uuidTypesMu.RLock()
+	defer uuidTypesMu.RUnlock()
+	return slices.Contains(uuidTypes, t)
+}
+
+// violationsError is a failed decoding of the request caused by the malformed values of the fields,
+// it's answered by VALIDATION_FAILED like a failed validation, see bodyError
+type violationsError struct {
+	err        error
+	violations []Violation
+}
+
+func (e *violationsError) Error() string { return e.err.Error() }
+
+func (e *violationsError) Unwrap() error { return e.err }
+
+// uuidBodyError explains the failed decoding of the JSON body to v by the malformed UUIDs of the body,
+// it returns the error as is if the type holds no UUID or every UUID is well-formed
+func uuidBodyError(v any, data []byte, err error) error {
+	t := reflect.TypeOf(v)
+	if err == nil || !HasUUID(t) {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if decoder.Decode(&value) != nil {
+		return err
+	}
+	var violations []Violation
+	collectUUIDViolations(t, value, "", &violations)
+	if len(violations) == 0 {
+		return err
+	}
+	return &violationsError{err: err, violations: violations}
+}
+
+// uuidQueryError explains the failed decoding of a query or a form by its malformed UUIDs, see uuidBodyError
+func uuidQueryError(err error) error {
+	var errs schema.MultiError
+	if !errors.As(err, &errs) {
+		return err
+	}
+	var violations []Violation
+	for key, keyErr := range errs {
+		var conversion schema.ConversionError
+		if errors.As(keyErr, &conversion) && conversion.Type != nil && IsUUID(derefType(conversion.Type)) {
+			violations = append(violations, ViolationFormat(key, FormatUUID))
+		}
+	}
+	if len(violations) == 0 {
+		return err
+	}
+	slices.SortFunc(violations, func(a, b Violation) int { return strings.Compare(a.Field, b.Field) })
+	return &violationsError{err: err, violations: violations}
+}
+
+// collectUUIDViolations walks the decoded JSON value along the type, a UUID value failing to parse is a violation,
+// the path joins the JSON names and the indexes by dots, e.g. items.0.userId
+func collectUUIDViolations(t reflect.Type, value any, path string, violations *[]Violation) {
+	t = derefType(t)
+	if value == nil || !HasUUID(t) {
+		return
+	}
+	if IsUUID(t) {
+		s, ok := value.(string)
+		if !ok || reflect.New(t).Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)) != nil {
+			*violations = append(*violations, ViolationFormat(path, FormatUUID))
+		}
+		return
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		items, _ := value.([]any)
+		for i, item := range items {
+			collectUUIDViolations(t.Elem(), item, joinPath(path, strconv.Itoa(i)), violations)
+		}
+	case reflect.Map:
+		entries, _ := value.(map[string]any)
+		for _, key := range slices.Sorted(maps.Keys(entries)) {
+			collectUUIDViolations(t.Elem(), entries[key], joinPath(path, key), violations)
+		}
+	case reflect.Struct:
+		object, ok := value.(map[string]any)
+		if !ok {
+			return
+		}
+		for _, field := range reflect.VisibleFields(t) {
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "-" || field.Anonymous && name == "" && derefType(field.Type).Kind() == reflect.Struct {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			key, ok := jsonKey(object, name)
+			if !ok {
+				continue
+			}
+			collectUUIDViolations(field.Type, object[key], joinPath(path, key), violations)
+		}
+	}
+}
+
+// hasUUID reports whether the type holds a UUID at any depth
+func HasUUID(t reflect.Type) bool {
+	if t == nil {
+		return false
+	}
+	if cached, ok := hasUUIDCache.Load(t); ok {
+		return cached.(bool)
+	}
+	found := findUUID(t, map[reflect.Type]bool{})
+	hasUUIDCache.Store(t, found)
+	return found
+}
+
+func findUUID(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if IsUUID(t) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		return findUUID(t.Elem(), seen)
+	case reflect.Struct:
+		if seen[t] {
+			return false
+		}
+		seen[t] = true
+		for i := range t.NumField() {
+			if field := t.Field(i); field.IsExported() && findUUID(field.Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsonKey finds the key of the field in the object, encoding/json matches the names case-insensitively
+func jsonKey(object map[string]any, name string) (string, bool) {
+	if _, ok := object[name]; ok {
+		return name, true
+	}
+	for key := range object {
+		if strings.EqualFold(key, name) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,130 @@
+package vel
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ValidationFailedCode is the error code of a request failing its validation, it's served with 422
+const ValidationFailedCode = "VALIDATION_FAILED"
+
+// InvalidRequestHeaderCode is the error code of a request whose header fails the validation declared by the spec,
+// it's served with 400, see Spec.EnforceRequestHeaders
+const InvalidRequestHeaderCode = "INVALID_REQUEST_HEADER"
+
+// Rules of the violations built by the package, the params of each rule are listed next to it.
+// Handlers may use their own rules, clients should fall back to a generic message for unknown ones.
+const (
+	RuleRequired = "required"
+	// RuleMinLen has the param "min"
+	RuleMinLen = "min_len"
+	// RuleMaxLen has the param "max"
+	RuleMaxLen = "max_len"
+	// RuleMin has the param "min"
+	RuleMin = "min"
+	// RuleMax has the param "max"
+	RuleMax = "max"
+	// RuleEnum has the param "values" joined by a comma
+	RuleEnum = "enum"
+	// RuleFormat has the param "format", e.g. uuid, the value isn't parsed as the format
+	RuleFormat = "format"
+)
+
+// Rules lists the rules known by the package, generated clients declare them as types
+var Rules = []string{RuleRequired, RuleMinLen, RuleMaxLen, RuleMin, RuleMax, RuleEnum, RuleFormat}
+
+// Violation is a machine readable validation failure,
+// clients render a localized message by its rule and params instead of an english text.
+type Violation struct {
+	// Field is the json name of the invalid field
+	Field  string            `json:"field"`
+	Rule   string            `json:"rule"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// Validator is implemented by request types validating themselves after decoding,
+// violations are returned to the client with the VALIDATION_FAILED code and 422 status
+type Validator interface {
+	Validate() []Violation
+}
+
+func ViolationRequired(field string) Violation {
+	return Violation{Field: field, Rule: RuleRequired}
+}
+
+func ViolationMinLen(field string, min int) Violation {
+	return Violation{Field: field, Rule: RuleMinLen, Params: map[string]string{"min": strconv.Itoa(min)}}
+}
+
+func ViolationMaxLen(field string, max int) Violation {
+	return Violation{Field: field, Rule: RuleMaxLen, Params: map[string]string{"max": strconv.Itoa(max)}}
+}
+
+func ViolationMin(field string, min int) Violation {
+	return Violation{Field: field, Rule: RuleMin, Params: map[string]string{"min": strconv.Itoa(min)}}
+}
+
+func ViolationMax(field string, max int) Violation {
+	return Violation{Field: field, Rule: RuleMax, Params: map[string]string{"max": strconv.Itoa(max)}}
+}
+
+func ViolationEnum(field string, values []string) Violation {
+	return Violation{Field: field, Rule: RuleEnum, Params: map[string]string{"values": strings.Join(values, ",")}}
+}
+
+func ViolationFormat(field, format string) Violation {
+	return Violation{Field: field, Rule: RuleFormat, Params: map[string]string{"format": format}}
+}
+
+// validate runs the request validation if the request type implements Validator
+func validate(i any) *Error {
+	v, ok := i.(Validator)
+	if !ok {
+		return nil
+	}
+	violations := v.Validate()
+	if len(violations) == 0 {
+		return nil
+	}
+	return &Error{
+		Code:       ValidationFailedCode,
+		Violations: violations,
+	}
+}
+
+// validateHeader checks the request header declared by the spec against its validation: the presence,
+// the length and the allowed values, the violations name the header
+func validateHeader(r *http.Request, spec KeyValueSpec) *Error {
+	if spec.Key == "" {
+		return nil
+	}
+	rules := spec.Validation
+	value := r.Header.Get(spec.Key)
+	var violations []Violation
+	// an absent header breaks only the presence rule, a present one is checked against every other rule
+	if value == "" {
+		if rules.Required {
+			violations = append(violations, ViolationRequired(spec.Key))
+		}
+	} else {
+		if rules.MinLen > 0 && len(value) < rules.MinLen {
+			violations = append(violations, ViolationMinLen(spec.Key, rules.MinLen))
+		}
+		if rules.MaxLen > 0 && len(value) > rules.MaxLen {
+			violations = append(violations, ViolationMaxLen(spec.Key, rules.MaxLen))
+		}
+		if len(rules.Enum) > 0 && !slices.Contains(rules.Enum, value) {
+			violations = append(violations, ViolationEnum(spec.Key, rules.Enum))
+		}
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	return &Error{
+		Code:       InvalidRequestHeaderCode,
+		Message:    "invalid " + spec.Key + " header",
+		Violations: violations,
+	}
+}
@@ -0,0 +1,267 @@
+// Package velhook sends webhooks: the event types are registered by their payload types and documented
+// in the OpenAPI spec of the router, every delivery is signed the way vel.Signature verifies it,
+// retried with a backoff and logged:
+//
+//	hooks := velhook.New(router, velhook.Opts{})
+//	userCreated := velhook.Register[User](hooks, "userCreated", "a user signed up")
+//	delivery, err := userCreated.Send(ctx, endpoint, user)
+package velhook
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dennypenta/vel"
+)
+
+const (
+	// EventIDHeader carries the id of the event, it's the same for the retries, so the receivers skip the duplicates
+	EventIDHeader = "X-Webhook-Id"
+	// EventTypeHeader carries the name of the event type
+	EventTypeHeader = "X-Webhook-Event"
+)
+
+// Envelope is the body of a delivery
+type Envelope[T any] struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"createdAt"`
+	Data      T         `json:"data"`
+}
+
+// Endpoint is a receiver of the webhooks
+type Endpoint struct {
+	URL string
+	// KeyID is sent in vel.SignatureKeyIDHeader, the receiver picks the secret by it
+	KeyID  string
+	Secret []byte
+}
+
+type Opts struct {
+	// Client sends the deliveries, a client with a 10 seconds timeout if nil
+	Client *http.Client
+	// Algorithm signs the deliveries, vel.HMACSHA256 if empty
+	Algorithm vel.SignatureAlgorithm
+	// MaxAttempts bounds the attempts of a delivery, 5 if zero
+	MaxAttempts int
+	// Backoff is the delay before the second attempt, a second if zero, it doubles for every next attempt up to MaxBackoff,
+	// a minute if zero. The delays are jittered, a longer Retry-After of the receiver is respected up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Log gets every attempt, LogDelivery if nil
+	Log func(ctx context.Context, d Delivery)
+}
+
+func (o Opts) withDefaults() Opts {
+	if o.Client == nil {
+		o.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	o.MaxAttempts = cmp.Or(o.MaxAttempts, 5)
+	o.Backoff = cmp.Or(o.Backoff, time.Second)
+	o.MaxBackoff = cmp.Or(o.MaxBackoff, time.Minute)
+	if o.Log == nil {
+		o.Log = LogDelivery
+	}
+	return o
+}
+
+// Delivery is an attempt to deliver an event
+type Delivery struct {
+	EventID string
+	Event   string
+	URL     string
+	Attempt int
+	// Status is the status of the response, zero if the request failed
+	Status   int
+	Err      error
+	Duration time.Duration
+}
+
+// Succeeded reports whether the receiver acknowledged the event by a 2xx status
+func (d Delivery) Succeeded() bool {
+	return d.Status >= 200 && d.Status < 300
+}
+
+// LogDelivery logs the attempt by the default slog logger, a failed one as a warning
+func LogDelivery(ctx context.Context, d Delivery) {
+	args := []any{"event", d.Event, "id", d.EventID, "url", d.URL, "attempt", d.Attempt, "status", d.Status, "duration", d.Duration}
+	if d.Succeeded() {
+		slog.Default().InfoContext(ctx, "webhook delivered", args...)
+		return
+	}
+	if d.Err != nil {
+		args = append(args, "err", d.Err)
+	}
+	slog.Default().WarnContext(ctx, "webhook delivery failed", args...)
+}
+
+// Sender delivers the events registered on it
+type Sender struct {
+	router *vel.Router
+	opts   Opts
+
+	mu     sync.Mutex
+	events map[string]struct{}
+}
+
+// New makes a sender documenting its events in the meta of the router, see vel.Router.RegisterWebhook
+func New(router *vel.Router, opts Opts) *Sender {
+	return &Sender{router: router, opts: opts.withDefaults(), events: make(map[string]struct{})}
+}
+
+// Event is a registered event type, it sends the payloads of the type
+type Event[T any] struct {
+	sender *Sender
+	name   string
+}
+
+// Register registers the event type of the payload type, it panics if the name is registered already
+func Register[T any](s *Sender, name, description string) Event[T] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.events[name]; ok {
+		panic("velhook: event " + name + " is registered already")
+	}
+	s.events[name] = struct{}{}
+
+	headers := append([]vel.KeyValueSpec{{
+		Key:         EventIDHeader,
+		ValueType:   vel.String,
+		Description: "id of the event, it's the same for the retries",
+		Validation:  vel.Validation{Required: true},
+	}, {
+		Key:         EventTypeHeader,
+		ValueType:   vel.String,
+		Description: "name of the event type",
+		Validation:  vel.Validation{Required: true},
+	}}, vel.SignatureHeaders()...)
+	s.router.RegisterWebhook(vel.WebhookMeta{
+		Name:        name,
+		Description: description,
+		Body:        Envelope[T]{},
+		Headers:     headers,
+		Security:    []vel.SecurityScheme{vel.SignatureSecurityScheme(vel.SignatureOpts{Algorithm: s.opts.Algorithm})},
+	})
+	return Event[T]{sender: s, name: name}
+}
+
+// Name returns the name of the event type
+func (e Event[T]) Name() string {
+	return e.name
+}
+
+// Send delivers the payload to the endpoint, it blocks until the receiver acknowledges the event or the attempts run out,
+// e.g. call it from a goroutine or a job. The server errors, 408, 429 and the failed requests are retried,
+// the other statuses end the delivery. It returns the last attempt and an error unless the event is delivered.
+func (e Event[T]) Send(ctx context.Context, endpoint Endpoint, payload T) (Delivery, error) {
+	envelope := Envelope[T]{ID: newEventID(), Type: e.name, CreatedAt: time.Now().UTC(), Data: payload}
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return Delivery{EventID: envelope.ID, Event: e.name, URL: endpoint.URL}, fmt.Errorf("failed to marshal %s event: %w", e.name, err)
+	}
+	return e.sender.deliver(ctx, endpoint, envelope.ID, e.name, body)
+}
+
+func (s *Sender) deliver(ctx context.Context, endpoint Endpoint, id, event string, body []byte) (Delivery, error) {
+	var d Delivery
+	for attempt := 1; ; attempt++ {
+		var (
+			retryAfter time.Duration
+			sent       bool
+		)
+		d, retryAfter, sent = s.attempt(ctx, endpoint, id, event, body)
+		d.Attempt = attempt
+		s.opts.Log(ctx, d)
+		switch {
+		case d.Succeeded():
+			return d, nil
+		case !sent:
+			return d, fmt.Errorf("failed to send webhook %s: %w", event, d.Err)
+		case !retryable(d):
+			return d, fmt.Errorf("webhook %s rejected by %s with %d", event, endpoint.URL, d.Status)
+		case attempt >= s.opts.MaxAttempts:
+			return d, fmt.Errorf("webhook %s not delivered to %s after %d attempts: %w", event, endpoint.URL, attempt, deliveryErr(d))
+		}
+
+		// a receiver can't stall the delivery for longer than MaxBackoff by its Retry-After
+		timer := time.NewTimer(max(s.backoff(attempt), min(retryAfter, s.opts.MaxBackoff)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return d, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt sends the signed request once, it returns the Retry-After of the receiver if any
+// and reports false if the request can't be made at all, e.g. the url is malformed
+func (s *Sender) attempt(ctx context.Context, endpoint Endpoint, id, event string, body []byte) (Delivery, time.Duration, bool) {
+	d := Delivery{EventID: id, Event: event, URL: endpoint.URL}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		d.Err = err
+		return d, 0, false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventIDHeader, id)
+	req.Header.Set(EventTypeHeader, event)
+	if err := vel.SignRequest(req, endpoint.KeyID, endpoint.Secret, s.opts.Algorithm); err != nil {
+		d.Err = err
+		return d, 0, false
+	}
+
+	start := time.Now()
+	resp, err := s.opts.Client.Do(req)
+	d.Duration = time.Since(start)
+	if err != nil {
+		d.Err = err
+		return d, 0, true
+	}
+	resp.Body.Close()
+	d.Status = resp.StatusCode
+	var retryAfter time.Duration
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	return d, retryAfter, true
+}
+
+// backoff returns the jittered delay after the attempt
+func (s *Sender) backoff(attempt int) time.Duration {
+	delay := min(s.opts.Backoff<<(attempt-1), s.opts.MaxBackoff)
+	if delay <= 0 {
+		// the shift overflowed
+		delay = s.opts.MaxBackoff
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func retryable(d Delivery) bool {
+	return d.Status == 0 || d.Status >= http.StatusInternalServerError ||
+		d.Status == http.StatusRequestTimeout || d.Status == http.StatusTooManyRequests
+}
+
+func deliveryErr(d Delivery) error {
+	if d.Err != nil {
+		return d.Err
+	}
+	return fmt.Errorf("the receiver answered %d", d.Status)
+}
+
+func newEventID() string {
+	var id [16]byte
+	_, _ = crand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
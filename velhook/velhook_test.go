@@ -0,0 +1,147 @@
+package velhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dennypenta/vel"
+)
+
+type userCreated struct {
+	Name string `json:"name"`
+}
+
+// receiver verifies the signatures the way a webhook receiver does, it fails the first attempts by the status
+func receiver(t *testing.T, failures int32, status int) (*httptest.Server, *atomic.Int32, chan Envelope[userCreated]) {
+	attempts := &atomic.Int32{}
+	received := make(chan Envelope[userCreated], 1)
+	r := vel.NewRouter()
+	vel.RegisterPost(r, "hooks", func(ctx context.Context, req Envelope[userCreated]) (struct{}, *vel.Error) {
+		if vel.SignatureKeyID(ctx) != "k1" {
+			t.Errorf("expected the verified key, got %q", vel.SignatureKeyID(ctx))
+		}
+		return struct{}{}, nil
+	}, vel.Signature(vel.SignatureOpts{
+		Secret: func(ctx context.Context, keyID string) ([]byte, error) {
+			if keyID != "k1" {
+				return nil, vel.ErrUnknownSignatureKey
+			}
+			return []byte("secret"), nil
+		},
+	}), func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if attempts.Add(1) <= failures {
+				w.WriteHeader(status)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, req)
+			if rec.status == 0 || rec.status == http.StatusOK {
+				received <- Envelope[userCreated]{ID: req.Header.Get(EventIDHeader), Type: req.Header.Get(EventTypeHeader)}
+			}
+		})
+	})
+	server := httptest.NewServer(r.Mux())
+	t.Cleanup(server.Close)
+	return server, attempts, received
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func TestSend(t *testing.T) {
+	var logged []Delivery
+	hooks := New(vel.NewRouter(), Opts{
+		Backoff: time.Millisecond,
+		Log:     func(ctx context.Context, d Delivery) { logged = append(logged, d) },
+	})
+	event := Register[userCreated](hooks, "userCreated", "a user signed up")
+
+	server, attempts, received := receiver(t, 2, http.StatusServiceUnavailable)
+	d, err := event.Send(context.Background(), Endpoint{URL: server.URL + "/hooks", KeyID: "k1", Secret: []byte("secret")}, userCreated{Name: "ann"})
+	if err != nil || !d.Succeeded() || d.Attempt != 3 || attempts.Load() != 3 {
+		t.Fatalf("expected the delivery on the third attempt, got %+v %v", d, err)
+	}
+	got := <-received
+	if got.ID != d.EventID || got.Type != "userCreated" {
+		t.Errorf("expected the event headers, got %+v", got)
+	}
+	if len(logged) != 3 || logged[0].Status != http.StatusServiceUnavailable || logged[0].EventID != d.EventID {
+		t.Errorf("expected every attempt to be logged with the same event id, got %+v", logged)
+	}
+
+	// a client error isn't retried
+	server, attempts, _ = receiver(t, 1, http.StatusBadRequest)
+	if d, err := event.Send(context.Background(), Endpoint{URL: server.URL + "/hooks", KeyID: "k1", Secret: []byte("secret")}, userCreated{}); err == nil || d.Status != http.StatusBadRequest || attempts.Load() != 1 {
+		t.Errorf("expected the rejected delivery, got %+v %v after %d attempts", d, err, attempts.Load())
+	}
+	// a wrong secret fails the signature
+	if d, err := event.Send(context.Background(), Endpoint{URL: server.URL + "/hooks", KeyID: "k1", Secret: []byte("guess")}, userCreated{}); err == nil || d.Status != http.StatusUnauthorized {
+		t.Errorf("expected the signature to be rejected, got %+v %v", d, err)
+	}
+
+	// the attempts run out
+	server, attempts, _ = receiver(t, 10, http.StatusInternalServerError)
+	d, err = event.Send(context.Background(), Endpoint{URL: server.URL + "/hooks", KeyID: "k1", Secret: []byte("secret")}, userCreated{})
+	if err == nil || d.Attempt != 5 || attempts.Load() != 5 {
+		t.Errorf("expected 5 attempts, got %+v %v", d, err)
+	}
+
+	// a long Retry-After waits MaxBackoff at most
+	retried := &atomic.Int32{}
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if retried.Add(1) == 1 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer slow.Close()
+	capped := Register[userCreated](New(vel.NewRouter(), Opts{Backoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond, Log: func(context.Context, Delivery) {}}), "userCreated", "")
+	start := time.Now()
+	if d, err := capped.Send(context.Background(), Endpoint{URL: slow.URL}, userCreated{}); err != nil || d.Attempt != 2 || time.Since(start) > time.Second {
+		t.Errorf("expected the Retry-After capped by MaxBackoff, got %+v %v after %s", d, err, time.Since(start))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := event.Send(ctx, Endpoint{URL: server.URL + "/hooks"}, userCreated{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the canceled delivery, got %v", err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	router := vel.NewRouter()
+	hooks := New(router, Opts{})
+	Register[userCreated](hooks, "userCreated", "a user signed up")
+
+	webhooks := router.Webhooks()
+	if len(webhooks) != 1 || webhooks[0].Name != "userCreated" || len(webhooks[0].Security) != 1 {
+		t.Fatalf("expected the documented webhook, got %+v", webhooks)
+	}
+	if _, ok := webhooks[0].Body.(Envelope[userCreated]); !ok {
+		t.Errorf("expected the envelope of the payload, got %T", webhooks[0].Body)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic registering the event twice")
+		}
+	}()
+	Register[userCreated](hooks, "userCreated", "")
+}
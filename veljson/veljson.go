@@ -0,0 +1,588 @@
+// Package veljson holds the encoding and decoding primitives of the JSON methods generated by gen.GenerateJSON,
+// the generated code encodes exactly as encoding/json does and appends to a buffer instead of reflecting on the values:
+//
+//	func (v User) AppendJSON(b []byte) ([]byte, error) {
+//		start := len(b)
+//		b = append(b, '{')
+//		b = append(veljson.Comma(b, start), "\"name\":"...)
+//		b = veljson.AppendString(b, v.Name)
+//		return append(b, '}'), nil
+//	}
+//
+// The values of the types it doesn't cover, e.g. the ones with their own MarshalJSON, are encoded by encoding/json.
+package veljson
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+	"unicode/utf8"
+	"unsafe"
+)
+
+const hex = "0123456789abcdef"
+
+// Comma separates a value of an object or an array started at start from the previous one
+func Comma(b []byte, start int) []byte {
+	if len(b) > start+1 {
+		b = append(b, ',')
+	}
+	return b
+}
+
+// AppendString appends the JSON string of s the way encoding/json does: the HTML characters are escaped
+// and the invalid UTF-8 is replaced with U+FFFD
+func AppendString(b []byte, s string) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\b':
+				b = append(b, '\\', 'b')
+			case '\f':
+				b = append(b, '\\', 'f')
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, s[start:i]...)
+			b = append(b, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		// U+2028 and U+2029 break JavaScript, encoding/json escapes them
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hex[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
+
+// AppendFloat appends f the way encoding/json does, bits is the size of the float type: 32 or 64.
+// NaN and the infinities fail.
+func AppendFloat(b []byte, f float64, bits int) ([]byte, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return b, &json.UnsupportedValueError{Value: reflect.ValueOf(f), Str: strconv.FormatFloat(f, 'g', -1, bits)}
+	}
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	b = strconv.AppendFloat(b, f, format, -1, bits)
+	if format == 'e' {
+		// e-09 is written as e-9
+		if n := len(b); n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b, nil
+}
+
+// AppendTime appends t in RFC 3339 format the way its MarshalJSON does
+func AppendTime(b []byte, t time.Time) ([]byte, error) {
+	_, offset := t.Zone()
+	if y := t.Year(); y < 0 || y > 9999 || offset <= -24*60*60 || offset >= 24*60*60 {
+		// MarshalJSON reports the error
+		return AppendValue(b, t)
+	}
+	b = append(b, '"')
+	b = t.AppendFormat(b, time.RFC3339Nano)
+	return append(b, '"'), nil
+}
+
+// AppendValue appends the value encoded by encoding/json, it's the encoding of the types the methods aren't generated for
+func AppendValue(b []byte, v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return b, err
+	}
+	return append(b, data...), nil
+}
+
+// maxDepth bounds the nesting of a decoded value the way encoding/json does
+const maxDepth = 10000
+
+// Reader decodes a JSON value for the generated UnmarshalJSON methods. It validates the input as it goes,
+// the first error stops the reading and is returned by End:
+//
+//	r := veljson.NewReader(data)
+//	if r.Object() {
+//		for r.NextKey() {
+//			switch veljson.Field(r.Key(), userFields) {
+//			case 0:
+//				if s, ok := r.String(); ok {
+//					v.Name = s
+//				}
+//			default:
+//				r.Skip()
+//			}
+//		}
+//	}
+//	return r.End()
+//
+// A null leaves a value as it is, the way encoding/json does.
+type Reader struct {
+	data  []byte
+	pos   int
+	depth int
+	err   error
+	// key is the last object key, an escaped one is unescaped to scratch
+	key     []byte
+	scratch []byte
+}
+
+func NewReader(data []byte) Reader {
+	return Reader{data: data}
+}
+
+// End checks nothing follows the value and returns the first error
+func (r *Reader) End() error {
+	if r.err == nil {
+		r.skipSpace()
+		if r.pos < len(r.data) {
+			r.expected("the end of the input")
+		}
+	}
+	return r.err
+}
+
+func (r *Reader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.pos = len(r.data)
+}
+
+func (r *Reader) expected(what string) {
+	if r.pos >= len(r.data) {
+		r.fail(errors.New("veljson: unexpected end of JSON input"))
+		return
+	}
+	r.fail(fmt.Errorf("veljson: expected %s at offset %d, got %q", what, r.pos, r.data[r.pos]))
+}
+
+func (r *Reader) skipSpace() {
+	for r.pos < len(r.data) {
+		switch r.data[r.pos] {
+		case ' ', '\t', '\n', '\r':
+			r.pos++
+		default:
+			return
+		}
+	}
+}
+
+// peek skips the spaces and returns the next byte, 0 at the end
+func (r *Reader) peek() byte {
+	r.skipSpace()
+	if r.pos < len(r.data) {
+		return r.data[r.pos]
+	}
+	return 0
+}
+
+// opened reports whether the last read byte opens an object or an array, so no comma precedes the next value
+func (r *Reader) opened(delim byte) bool {
+	for i := r.pos - 1; i >= 0; i-- {
+		switch r.data[i] {
+		case ' ', '\t', '\n', '\r':
+		default:
+			return r.data[i] == delim
+		}
+	}
+	return false
+}
+
+func (r *Reader) literal(name string) bool {
+	if r.peek() == name[0] && len(r.data)-r.pos >= len(name) && string(r.data[r.pos:r.pos+len(name)]) == name {
+		r.pos += len(name)
+		return true
+	}
+	return false
+}
+
+// Null reads a null, it's false if the value isn't one
+func (r *Reader) Null() bool {
+	return r.literal("null")
+}
+
+func (r *Reader) open(delim byte, what string) bool {
+	if r.Null() {
+		return false
+	}
+	if r.peek() != delim {
+		r.expected(what)
+		return false
+	}
+	if r.depth++; r.depth > maxDepth {
+		r.fail(errors.New("veljson: exceeded max depth"))
+		return false
+	}
+	r.pos++
+	return true
+}
+
+// Object starts reading an object, it's false for a null, NextKey iterates over its keys
+func (r *Reader) Object() bool {
+	return r.open('{', "an object")
+}
+
+// Array starts reading an array, it's false for a null, NextItem iterates over its items
+func (r *Reader) Array() bool {
+	return r.open('[', "an array")
+}
+
+// NextKey reads the next key of an object, it's false at the end of the object.
+// The value of the key must be read or skipped before the next call.
+func (r *Reader) NextKey() bool {
+	if r.err != nil {
+		return false
+	}
+	c := r.peek()
+	if !r.opened('{') {
+		switch c {
+		case '}':
+			r.pos++
+			r.depth--
+			return false
+		case ',':
+			r.pos++
+		default:
+			r.expected("',' or '}'")
+			return false
+		}
+	} else if c == '}' {
+		r.pos++
+		r.depth--
+		return false
+	}
+
+	key, ok := r.readString()
+	if !ok {
+		return false
+	}
+	r.key = key
+	if r.peek() != ':' {
+		r.expected("':'")
+		return false
+	}
+	r.pos++
+	return true
+}
+
+// Key is the key NextKey read, it's valid until the next key is read
+func (r *Reader) Key() []byte {
+	return r.key
+}
+
+// NextItem starts reading the next item of an array, it's false at the end of the array
+func (r *Reader) NextItem() bool {
+	if r.err != nil {
+		return false
+	}
+	c := r.peek()
+	if r.opened('[') {
+		if c == ']' {
+			r.pos++
+			r.depth--
+			return false
+		}
+		return true
+	}
+	switch c {
+	case ']':
+		r.pos++
+		r.depth--
+		return false
+	case ',':
+		r.pos++
+		return true
+	default:
+		r.expected("',' or ']'")
+		return false
+	}
+}
+
+// Field finds the key among the field names, an exact match goes first and a case-insensitive one follows
+// the way encoding/json matches them. It's -1 if no field matches.
+func Field(key []byte, names []string) int {
+	for i, name := range names {
+		if string(key) == name {
+			return i
+		}
+	}
+	for i, name := range names {
+		if bytes.EqualFold(key, unsafe.Slice(unsafe.StringData(name), len(name))) {
+			return i
+		}
+	}
+	return -1
+}
+
+// readString reads a string, the result is valid until the next string is read
+func (r *Reader) readString() ([]byte, bool) {
+	if r.peek() != '"' {
+		r.expected("a string")
+		return nil, false
+	}
+	start := r.pos + 1
+	ascii := true
+	for i := start; i < len(r.data); i++ {
+		switch c := r.data[i]; {
+		case c == '"':
+			s := r.data[start:i]
+			if !ascii && !utf8.Valid(s) {
+				return r.readEscaped(start)
+			}
+			r.pos = i + 1
+			return s, true
+		case c == '\\' || c < 0x20:
+			return r.readEscaped(start)
+		case c >= utf8.RuneSelf:
+			ascii = false
+		}
+	}
+	r.pos = len(r.data)
+	r.expected("a string")
+	return nil, false
+}
+
+// readEscaped reads a string with escapes or invalid UTF-8 by encoding/json
+func (r *Reader) readEscaped(start int) ([]byte, bool) {
+	end := start
+	for end < len(r.data) && r.data[end] != '"' {
+		if r.data[end] == '\\' {
+			end++
+		}
+		end++
+	}
+	if end >= len(r.data) {
+		r.pos = len(r.data)
+		r.expected("a string")
+		return nil, false
+	}
+	var s string
+	if err := json.Unmarshal(r.data[start-1:end+1], &s); err != nil {
+		r.fail(fmt.Errorf("veljson: invalid string at offset %d: %w", start-1, err))
+		return nil, false
+	}
+	r.pos = end + 1
+	r.scratch = append(r.scratch[:0], s...)
+	return r.scratch, true
+}
+
+// String reads a string, it's false for a null and on a failure
+func (r *Reader) String() (string, bool) {
+	if r.Null() {
+		return "", false
+	}
+	s, ok := r.readString()
+	return string(s), ok
+}
+
+// Bool reads a boolean, it's false for a null and on a failure
+func (r *Reader) Bool() (value bool, ok bool) {
+	switch {
+	case r.literal("true"):
+		return true, true
+	case r.literal("false"):
+		return false, true
+	case r.Null():
+		return false, false
+	}
+	r.expected("a boolean")
+	return false, false
+}
+
+// readNumber reads a number, the result is valid until the input is released
+func (r *Reader) readNumber() (string, bool) {
+	c := r.peek()
+	start := r.pos
+	i := start
+	digits := func() bool {
+		from := i
+		for i < len(r.data) && r.data[i] >= '0' && r.data[i] <= '9' {
+			i++
+		}
+		return i > from
+	}
+	if c == '-' {
+		i++
+	}
+	switch {
+	case i < len(r.data) && r.data[i] == '0':
+		i++
+	case !digits():
+		r.pos = i
+		r.expected("a number")
+		return "", false
+	}
+	if i < len(r.data) && r.data[i] == '.' {
+		i++
+		if !digits() {
+			r.pos = i
+			r.expected("a digit")
+			return "", false
+		}
+	}
+	if i < len(r.data) && (r.data[i] == 'e' || r.data[i] == 'E') {
+		i++
+		if i < len(r.data) && (r.data[i] == '+' || r.data[i] == '-') {
+			i++
+		}
+		if !digits() {
+			r.pos = i
+			r.expected("a digit")
+			return "", false
+		}
+	}
+	r.pos = i
+	// the strconv functions copy the number to their errors
+	return unsafe.String(&r.data[start], i-start), true
+}
+
+func (r *Reader) numberError(n string, err error) {
+	r.fail(fmt.Errorf("veljson: cannot read number %s at offset %d: %w", n, r.pos-len(n), errors.Unwrap(err)))
+}
+
+// Int reads an integer of the bit size, it's false for a null and on a failure
+func (r *Reader) Int(bits int) (int64, bool) {
+	if r.Null() {
+		return 0, false
+	}
+	n, ok := r.readNumber()
+	if !ok {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(n, 10, bits)
+	if err != nil {
+		r.numberError(n, err)
+		return 0, false
+	}
+	return v, true
+}
+
+// Uint reads an unsigned integer of the bit size, it's false for a null and on a failure
+func (r *Reader) Uint(bits int) (uint64, bool) {
+	if r.Null() {
+		return 0, false
+	}
+	n, ok := r.readNumber()
+	if !ok {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(n, 10, bits)
+	if err != nil {
+		r.numberError(n, err)
+		return 0, false
+	}
+	return v, true
+}
+
+// Float reads a float of the bit size, it's false for a null and on a failure
+func (r *Reader) Float(bits int) (float64, bool) {
+	if r.Null() {
+		return 0, false
+	}
+	n, ok := r.readNumber()
+	if !ok {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(n, bits)
+	if err != nil {
+		r.numberError(n, err)
+		return 0, false
+	}
+	return v, true
+}
+
+// Time reads a time in RFC 3339 format by its UnmarshalJSON
+func (r *Reader) Time(t *time.Time) {
+	if r.Null() {
+		return
+	}
+	start := r.pos
+	if _, ok := r.readString(); !ok {
+		return
+	}
+	if err := t.UnmarshalJSON(r.data[start:r.pos]); err != nil {
+		r.fail(fmt.Errorf("veljson: invalid time at offset %d: %w", start, err))
+	}
+}
+
+// Value reads a value by encoding/json, it's the decoding of the types the methods aren't generated for
+func (r *Reader) Value(v any) {
+	r.skipSpace()
+	start := r.pos
+	r.Skip()
+	if r.err != nil {
+		return
+	}
+	if err := json.Unmarshal(r.data[start:r.pos], v); err != nil {
+		r.fail(err)
+	}
+}
+
+// Skip reads a value and drops it, e.g. the value of an unknown key
+func (r *Reader) Skip() {
+	switch c := r.peek(); {
+	case c == '{':
+		if r.Object() {
+			for r.NextKey() {
+				r.Skip()
+			}
+		}
+	case c == '[':
+		if r.Array() {
+			for r.NextItem() {
+				r.Skip()
+			}
+		}
+	case c == '"':
+		r.readString()
+	case c == 't' || c == 'f':
+		r.Bool()
+	case c == 'n':
+		if !r.Null() {
+			r.expected("a value")
+		}
+	case c == '-' || c >= '0' && c <= '9':
+		r.readNumber()
+	default:
+		r.expected("a value")
+	}
+}
@@ -0,0 +1,129 @@
+package veljson
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAppendString(t *testing.T) {
+	for _, s := range []string{"", "plain", `quote " and \ backslash`, "<b>&</b>", "\n\t\r\b\f\x00\x1f", "é 日本 😀", "  ", "bad \xff utf8"} {
+		want, _ := json.Marshal(s)
+		if got := AppendString(nil, s); string(got) != string(want) {
+			t.Errorf("expected %s, got %s", want, got)
+		}
+	}
+}
+
+func TestAppendFloat(t *testing.T) {
+	for _, f := range []float64{0, -0.5, 1, 1.5, 1e20, 1e21, 1e-6, 1e-7, 123456789.123, math.MaxFloat64, math.SmallestNonzeroFloat64} {
+		want, _ := json.Marshal(f)
+		if got, err := AppendFloat(nil, f, 64); err != nil || string(got) != string(want) {
+			t.Errorf("expected %s, got %s, %v", want, got, err)
+		}
+		if math.Abs(f) > math.MaxFloat32 {
+			continue
+		}
+		want, _ = json.Marshal(float32(f))
+		if got, err := AppendFloat(nil, float64(float32(f)), 32); err != nil || string(got) != string(want) {
+			t.Errorf("expected float32 %s, got %s, %v", want, got, err)
+		}
+	}
+	if _, err := AppendFloat(nil, math.Inf(1), 64); err == nil || err.Error() != "json: unsupported value: +Inf" {
+		t.Errorf("expected the infinity to fail, got %v", err)
+	}
+}
+
+func TestAppendTime(t *testing.T) {
+	for _, tm := range []time.Time{
+		time.Date(2024, 1, 15, 9, 30, 0, 0, time.UTC),
+		time.Date(2024, 1, 15, 9, 30, 0, 120, time.FixedZone("", -5*60*60)),
+	} {
+		want, _ := json.Marshal(tm)
+		if got, err := AppendTime(nil, tm); err != nil || string(got) != string(want) {
+			t.Errorf("expected %s, got %s, %v", want, got, err)
+		}
+	}
+	if _, err := AppendTime(nil, time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC)); err == nil {
+		t.Error("expected a year out of range to fail")
+	}
+}
+
+func TestSkip(t *testing.T) {
+	for _, input := range []string{
+		`null`, ` true `, `false`, `"s"`, `"é\n"`, "\"\xff\"", `0`, `-1.5e+10`, `{}`, `[]`, `{"a":[1,{"b":null}],"c":"d"}`,
+		``, ` `, `nul`, `01`, `1.`, `-`, `1e`, `.5`, `"a`, `"\q"`, "\"\x01\"", `{,}`, `{"a"}`, `{"a":1,}`, `{"a":1 "b":2}`,
+		`[1,]`, `[,1]`, `[1 2]`, `{"a":1}}`, `[1]]`, `{1:2}`, `1 2`,
+	} {
+		r := NewReader([]byte(input))
+		r.Skip()
+		if err := r.End(); (err == nil) != json.Valid([]byte(input)) {
+			t.Errorf("%q: expected the validity %v, got %v", input, json.Valid([]byte(input)), err)
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	type value struct {
+		name  string
+		count int64
+		ratio float64
+		flag  bool
+		tags  []string
+	}
+	fields := []string{"name", "count", "ratio", "flag", "tags"}
+	read := func(input string) (value, error) {
+		var v value
+		r := NewReader([]byte(input))
+		if r.Object() {
+			for r.NextKey() {
+				switch Field(r.Key(), fields) {
+				case 0:
+					if s, ok := r.String(); ok {
+						v.name = s
+					}
+				case 1:
+					if n, ok := r.Int(8); ok {
+						v.count = n
+					}
+				case 2:
+					if n, ok := r.Float(64); ok {
+						v.ratio = n
+					}
+				case 3:
+					if x, ok := r.Bool(); ok {
+						v.flag = x
+					}
+				case 4:
+					if r.Array() {
+						for r.NextItem() {
+							if s, ok := r.String(); ok {
+								v.tags = append(v.tags, s)
+							}
+						}
+					}
+				default:
+					r.Skip()
+				}
+			}
+		}
+		return v, r.End()
+	}
+
+	v, err := read(`{"NAME":"a\"b","count":-7,"ratio":0.25,"flag":true,"tags":["x","y"],"other":{"z":[1]},"name":"c"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.name != "c" || v.count != -7 || v.ratio != 0.25 || !v.flag || len(v.tags) != 2 || v.tags[1] != "y" {
+		t.Errorf("unexpected value %+v", v)
+	}
+	if v, err := read(`{"name":null,"count":null}`); err != nil || v.name != "" {
+		t.Errorf("expected the nulls to be skipped, got %+v, %v", v, err)
+	}
+	for _, input := range []string{`{"count":128}`, `{"count":1.5}`, `{"name":1}`, `{"flag":"true"}`, `{"tags":"x"}`, `[]`, `{"name":"a"} {}`} {
+		if _, err := read(input); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// Package velproto registers the protobuf messages of google.golang.org/protobuf as the inputs and outputs of vel handlers,
+// so the core package doesn't depend on protobuf. A service imports it for the side effect:
+//
+//	import _ "github.com/dennypenta/vel/velproto"
+//
+// A message is decoded from binary protobuf or protojson and encoded to the one the request accepts, see vel.Protobuf.
+// The unknown fields of protojson are dropped like encoding/json drops them.
+package velproto
+
+import (
+	"reflect"
+
+	"github.com/dennypenta/vel"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+var messageType = reflect.TypeFor[proto.Message]()
+
+func init() {
+	vel.RegisterProtoCodec(codec{})
+}
+
+// codec is the vel.ProtoCodec of the messages generated by protoc-gen-go
+type codec struct{}
+
+func (codec) IsMessage(t reflect.Type) bool {
+	return t.Implements(messageType)
+}
+
+func (codec) Unmarshal(data []byte, binary bool, message any) error {
+	if binary {
+		return proto.Unmarshal(data, message.(proto.Message))
+	}
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, message.(proto.Message))
+}
+
+func (codec) Append(b []byte, binary bool, message any) ([]byte, error) {
+	if binary {
+		return proto.MarshalOptions{}.MarshalAppend(b, message.(proto.Message))
+	}
+	return protojson.MarshalOptions{}.MarshalAppend(b, message.(proto.Message))
+}
@@ -0,0 +1,76 @@
+package velproto
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dennypenta/vel"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/apipb"
+	"google.golang.org/protobuf/types/known/typepb"
+)
+
+func TestProtobuf(t *testing.T) {
+	r := vel.NewRouter()
+	vel.RegisterPost(r, "describe", func(ctx context.Context, req *apipb.Method) (*apipb.Method, *vel.Error) {
+		if req.GetName() == "" {
+			return nil, &vel.Error{Code: "EMPTY_NAME", Status: http.StatusBadRequest}
+		}
+		return &apipb.Method{Name: req.GetName(), ResponseTypeUrl: "type.googleapis.com/" + req.GetRequestTypeUrl(), Syntax: req.GetSyntax()}, nil
+	})
+	method := &apipb.Method{Name: "get", RequestTypeUrl: "GetRequest", Syntax: typepb.Syntax_SYNTAX_PROTO3}
+	binary, _ := proto.Marshal(method)
+
+	for _, tc := range []struct {
+		name, body, contentType, accept string
+		protobufResponse                bool
+		status                          int
+	}{
+		{"protojson", `{"name":"get","requestTypeUrl":"GetRequest","syntax":"SYNTAX_PROTO3","unknown":1}`, "application/json", "", false, http.StatusOK},
+		{"protobuf", string(binary), vel.Protobuf, vel.Protobuf, true, http.StatusOK},
+		{"protobuf request", string(binary), vel.Protobuf, "", false, http.StatusOK},
+		{"json preferred", `{"name":"get","request_type_url":"GetRequest","syntax":1}`, "", "application/json, application/x-protobuf;q=0.5", false, http.StatusOK},
+		{"malformed", `{"name":`, "application/json", "", false, http.StatusBadRequest},
+		{"error", `{}`, "application/json", vel.Protobuf, false, http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/describe", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			req.Header.Set("Accept", tc.accept)
+			w := httptest.NewRecorder()
+			r.Mux().ServeHTTP(w, req)
+
+			if w.Code != tc.status {
+				t.Fatalf("expected %d, got %d: %s", tc.status, w.Code, w.Body.String())
+			}
+			if got := w.Header().Get("Content-Type"); (got == vel.Protobuf) != tc.protobufResponse {
+				t.Errorf("unexpected content type %q", got)
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+			res := &apipb.Method{}
+			if tc.protobufResponse {
+				if err := proto.Unmarshal(w.Body.Bytes(), res); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				// the fields are named as protojson names them
+				if !strings.Contains(w.Body.String(), `"responseTypeUrl"`) || !strings.Contains(w.Body.String(), `"SYNTAX_PROTO3"`) {
+					t.Errorf("expected protojson, got %s", w.Body.String())
+				}
+				if err := protojson.Unmarshal(w.Body.Bytes(), res); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want := &apipb.Method{Name: "get", ResponseTypeUrl: "type.googleapis.com/GetRequest", Syntax: typepb.Syntax_SYNTAX_PROTO3}
+			if !proto.Equal(res, want) {
+				t.Errorf("expected %v, got %v", want, res)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+// Package veltest calls the handlers of a vel router in tests, the requests are served with httptest
+// and the responses are decoded to the output types and vel errors:
+//
+//	resp, velErr := veltest.Call[HelloRequest, HelloResponse](t, router, "hello", HelloRequest{Name: "vel"})
+package veltest
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dennypenta/vel"
+	"github.com/gorilla/schema"
+)
+
+// Option configures a call
+type Option func(*call)
+
+type call struct {
+	header  http.Header
+	status  int
+	version string
+}
+
+// WithHeader sets a header of the request, e.g. Authorization
+func WithHeader(key, value string) Option {
+	return func(c *call) {
+		c.header.Set(key, value)
+	}
+}
+
+// WithVersion calls the version of a versioned operation, see vel.WithVersion, the latest version is called by default
+func WithVersion(version string) Option {
+	return func(c *call) {
+		c.version = version
+		c.header.Set(vel.AcceptVersionHeader, version)
+	}
+}
+
+// ExpectStatus fails the test unless the response has the status
+func ExpectStatus(status int) Option {
+	return func(c *call) {
+		c.status = status
+	}
+}
+
+// Call serves the request by the router and decodes the response: the output of a successful one
+// or the error of a failed one, decoded with the error schema of the router. The operation is its id or its path,
+// e.g. v1/hello, the path tells apart the operations of subrouters sharing an id.
+// The request is sent as the JSON body or as the query of a GET operation,
+// a []byte request and output are the raw bodies of the operation, see vel.IsRawBody.
+// It fails the test if the operation isn't registered with the types, the response can't be decoded
+// or its status isn't the expected one.
+func Call[I, O any](t testing.TB, router *vel.Router, operation string, req I, opts ...Option) (O, *vel.Error) {
+	t.Helper()
+	c := call{header: make(http.Header)}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	var out O
+
+	meta := findRoute(t, router, operation, c.version)
+	if meta.Spec.Stream != "" {
+		t.Fatalf("%s streams its items, Call decodes a single output", operation)
+	}
+	for _, types := range []struct {
+		registered any
+		given      reflect.Type
+	}{
+		{meta.Input, reflect.TypeFor[I]()},
+		{meta.Output, reflect.TypeFor[O]()},
+	} {
+		if types.registered != nil && reflect.TypeOf(types.registered) != types.given {
+			t.Fatalf("%s is registered with %s, got %s", operation, reflect.TypeOf(types.registered), types.given)
+		}
+	}
+
+	target, body := meta.Path, io.Reader(http.NoBody)
+	if meta.Method == http.MethodGet {
+		query := url.Values{}
+		if err := queryEncoder().Encode(req, query); err != nil {
+			t.Fatalf("%s: failed to encode the query: %v", operation, err)
+		}
+		if len(query) > 0 {
+			target += "?" + query.Encode()
+		}
+	} else if raw, ok := any(req).([]byte); ok {
+		body = bytes.NewReader(raw)
+	} else {
+		data, err := json.Marshal(req)
+		if err != nil {
+			t.Fatalf("%s: failed to encode the request: %v", operation, err)
+		}
+		body = bytes.NewReader(data)
+	}
+	r := httptest.NewRequest(meta.Method, target, body)
+	if _, raw := any(req).([]byte); raw {
+		r.Header.Set("Content-Type", cmp.Or(meta.Spec.RequestContentType, vel.OctetStream))
+	} else if meta.Method != http.MethodGet {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for key, values := range c.header {
+		r.Header[key] = values
+	}
+
+	w := httptest.NewRecorder()
+	router.Mux().ServeHTTP(w, r)
+	if c.status != 0 && w.Code != c.status {
+		t.Fatalf("%s: got status %d, want %d: %s", operation, w.Code, c.status, w.Body)
+	}
+
+	if w.Code >= http.StatusBadRequest {
+		velErr, err := decodeError(router.ErrorEncoder().Schema().WithDefaults(), w.Body.Bytes())
+		if err != nil {
+			t.Fatalf("%s: failed to decode the error of status %d: %v: %s", operation, w.Code, err, w.Body)
+		}
+		return out, velErr
+	}
+	if raw, ok := any(&out).(*[]byte); ok {
+		*raw = w.Body.Bytes()
+	} else if w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+			t.Fatalf("%s: failed to decode the response: %v: %s", operation, err, w.Body)
+		}
+	}
+	return out, nil
+}
+
+// findRoute looks the operation up by its path first, then by its id, and picks the version of a versioned one
+func findRoute(t testing.TB, router *vel.Router, operation, version string) vel.HandlerMeta {
+	t.Helper()
+	var byPath, byID []vel.HandlerMeta
+	routers := []*vel.Router{router}
+	for len(routers) > 0 {
+		r := routers[0]
+		routers = append(routers[1:], r.Subrouters()...)
+		for _, meta := range r.Meta() {
+			if strings.TrimPrefix(strings.TrimPrefix(meta.Path, router.Prefix()), "/") == strings.TrimPrefix(operation, "/") {
+				byPath = append(byPath, meta)
+			}
+			if meta.OperationID == operation {
+				byID = append(byID, meta)
+			}
+		}
+	}
+
+	routes := byPath
+	if len(routes) == 0 {
+		routes = byID
+	}
+	// the versions of an operation share the path
+	var paths []string
+	for _, meta := range routes {
+		if !slices.Contains(paths, meta.Path) {
+			paths = append(paths, meta.Path)
+		}
+	}
+	switch len(paths) {
+	case 0:
+		t.Fatalf("operation %s isn't registered", operation)
+	case 1:
+	default:
+		t.Fatalf("operation %s is registered at %s, call it by the path", operation, strings.Join(paths, ", "))
+	}
+
+	latest := routes[0]
+	for _, meta := range routes {
+		if version != "" && meta.Version() == version {
+			return meta
+		}
+		if meta.Version() > latest.Version() {
+			latest = meta
+		}
+	}
+	if version != "" {
+		t.Fatalf("operation %s has no version %s", operation, version)
+	}
+	return latest
+}
+
+// decodeError reads an error encoded with the schema, the extra fields are dropped
+func decodeError(schema vel.ErrorSchema, body []byte) (*vel.Error, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	if schema.Envelope != "" {
+		if err := json.Unmarshal(fields[schema.Envelope], &fields); err != nil {
+			return nil, err
+		}
+	}
+
+	velErr := &vel.Error{}
+	for key, value := range map[string]any{
+		schema.CodeField:       &velErr.Code,
+		schema.MessageField:    &velErr.Message,
+		schema.MetaField:       &velErr.Meta,
+		schema.ViolationsField: &velErr.Violations,
+	} {
+		if raw, ok := fields[key]; ok {
+			if err := json.Unmarshal(raw, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return velErr, nil
+}
+
+// queryEncoder encodes a GET request the way the router decodes it, time.Time is formatted as RFC3339
+func queryEncoder() *schema.Encoder {
+	encoder := schema.NewEncoder()
+	encoder.RegisterEncoder(time.Time{}, func(v reflect.Value) string {
+		return v.Interface().(time.Time).Format(time.RFC3339)
+	})
+	return encoder
+}
@@ -0,0 +1,182 @@
+package veltest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/dennypenta/vel"
+)
+
+type helloRequest struct {
+	Name string `json:"name"`
+}
+
+type helloResponse struct {
+	Message string `json:"message"`
+}
+
+type searchQuery struct {
+	Query string `schema:"q"`
+	Limit int    `schema:"limit"`
+}
+
+func testRouter() *vel.Router {
+	router := vel.NewRouter()
+	vel.RegisterPost(router, "hello", func(ctx context.Context, req helloRequest) (helloResponse, *vel.Error) {
+		if req.Name == "" {
+			return helloResponse{}, &vel.Error{Code: "EMPTY_NAME", Message: "name is required", Meta: map[string]string{"field": "name"}}
+		}
+		if vel.RequestFromContext(ctx).Header.Get("X-Greeting") != "" {
+			return helloResponse{Message: vel.RequestFromContext(ctx).Header.Get("X-Greeting") + " " + req.Name}, nil
+		}
+		return helloResponse{Message: "hello " + req.Name}, nil
+	})
+	vel.RegisterGet(router, "search", func(ctx context.Context, req searchQuery) (helloResponse, *vel.Error) {
+		return helloResponse{Message: req.Query}, nil
+	})
+	vel.RegisterPost(router, "greet", func(ctx context.Context, req helloRequest) (helloResponse, *vel.Error) {
+		return helloResponse{Message: "old " + req.Name}, nil
+	}, vel.WithVersion("2024-01-01"))
+	vel.RegisterPost(router, "greet", func(ctx context.Context, req searchQuery) (helloResponse, *vel.Error) {
+		return helloResponse{Message: "new " + req.Query}, nil
+	}, vel.WithVersion("2024-06-01"))
+	vel.RegisterPost(router, "upper", func(ctx context.Context, body io.Reader) ([]byte, *vel.Error) {
+		data, _ := io.ReadAll(body)
+		return bytes.ToUpper(data), nil
+	})
+	vel.RegisterPost(router.Subrouter("v1"), "hello", func(ctx context.Context, req helloRequest) (helloResponse, *vel.Error) {
+		return helloResponse{Message: "v1 " + req.Name}, nil
+	})
+	return router
+}
+
+func TestCall(t *testing.T) {
+	router := testRouter()
+
+	tests := []struct {
+		name      string
+		operation string
+		req       helloRequest
+		opts      []Option
+		expected  helloResponse
+		code      string
+	}{
+		{name: "by path", operation: "hello", req: helloRequest{Name: "vel"}, expected: helloResponse{Message: "hello vel"}},
+		{name: "subrouter path", operation: "v1/hello", req: helloRequest{Name: "vel"}, expected: helloResponse{Message: "v1 vel"}},
+		{name: "header", operation: "hello", req: helloRequest{Name: "vel"}, opts: []Option{WithHeader("X-Greeting", "hi")}, expected: helloResponse{Message: "hi vel"}},
+		{name: "error", operation: "hello", opts: []Option{ExpectStatus(http.StatusBadRequest)}, code: "EMPTY_NAME"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, velErr := Call[helloRequest, helloResponse](t, router, tt.operation, tt.req, tt.opts...)
+			if resp != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, resp)
+			}
+			if tt.code == "" && velErr != nil {
+				t.Fatalf("unexpected error %v", velErr)
+			}
+			if tt.code != "" && (velErr == nil || velErr.Code != tt.code) {
+				t.Fatalf("expected the %s error, got %v", tt.code, velErr)
+			}
+		})
+	}
+
+	t.Run("query", func(t *testing.T) {
+		resp, velErr := Call[searchQuery, helloResponse](t, router, "search", searchQuery{Query: "vel go", Limit: 3})
+		if velErr != nil || resp.Message != "vel go" {
+			t.Errorf("unexpected response %+v, %v", resp, velErr)
+		}
+	})
+}
+
+func TestCallVersion(t *testing.T) {
+	router := testRouter()
+	resp, velErr := Call[searchQuery, helloResponse](t, router, "greet", searchQuery{Query: "vel"})
+	if velErr != nil || resp.Message != "new vel" {
+		t.Errorf("expected the latest version, got %+v, %v", resp, velErr)
+	}
+	resp, velErr = Call[helloRequest, helloResponse](t, router, "greet", helloRequest{Name: "vel"}, WithVersion("2024-01-01"))
+	if velErr != nil || resp.Message != "old vel" {
+		t.Errorf("expected the old version, got %+v, %v", resp, velErr)
+	}
+}
+
+func TestCallRaw(t *testing.T) {
+	resp, velErr := Call[[]byte, []byte](t, testRouter(), "upper", []byte("a,b\n"))
+	if velErr != nil || string(resp) != "A,B\n" {
+		t.Errorf("expected the raw body, got %q, %v", resp, velErr)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	schema := vel.ErrorSchema{Envelope: "error", CodeField: "type"}.WithDefaults()
+	velErr, err := decodeError(schema, []byte(`{"error":{"type":"INVALID","message":"bad","meta":{"b":"1","a":"2"},"violations":[{"field":"name","rule":"required"}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if velErr.Code != "INVALID" || velErr.Message != "bad" {
+		t.Errorf("unexpected error %v", velErr)
+	}
+	if velErr.Meta["a"] != "2" || velErr.Meta["b"] != "1" {
+		t.Errorf("unexpected meta %v", velErr.Meta)
+	}
+	if len(velErr.Violations) != 1 || velErr.Violations[0].Field != "name" || velErr.Violations[0].Rule != vel.RuleRequired {
+		t.Errorf("unexpected violations %v", velErr.Violations)
+	}
+}
+
+// recorder fails the test instead of stopping the goroutine, so the failures of Call are checked
+type recorder struct {
+	testing.TB
+	failed string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Fatalf(format string, args ...any) {
+	r.failed = format
+	panic(r)
+}
+
+func TestCallFailures(t *testing.T) {
+	router := testRouter()
+	ambiguous := vel.NewRouter()
+	for _, prefix := range []string{"a", "b"} {
+		vel.RegisterPost(ambiguous.Subrouter(prefix), "hello", func(ctx context.Context, req helloRequest) (helloResponse, *vel.Error) {
+			return helloResponse{}, nil
+		})
+	}
+	fails := func(call func(tb testing.TB)) (failed bool) {
+		r := &recorder{TB: t}
+		defer func() {
+			if recovered := recover(); recovered != nil && recovered != r {
+				panic(recovered)
+			}
+			failed = r.failed != ""
+		}()
+		call(r)
+		return false
+	}
+
+	tests := []struct {
+		name string
+		call func(tb testing.TB)
+	}{
+		{name: "unknown operation", call: func(tb testing.TB) { Call[helloRequest, helloResponse](tb, router, "missing", helloRequest{}) }},
+		{name: "ambiguous id", call: func(tb testing.TB) { Call[helloRequest, helloResponse](tb, ambiguous, "hello", helloRequest{}) }},
+		{name: "wrong input", call: func(tb testing.TB) { Call[searchQuery, helloResponse](tb, router, "hello", searchQuery{}) }},
+		{name: "unexpected status", call: func(tb testing.TB) {
+			Call[helloRequest, helloResponse](tb, router, "hello", helloRequest{Name: "vel"}, ExpectStatus(http.StatusCreated))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !fails(tt.call) {
+				t.Error("expected the call to fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+// Package veluuid registers uuid.UUID of github.com/google/uuid as a UUID type of vel, so the core package doesn't depend on it.
+// A service imports it for the side effect:
+//
+//	import _ "github.com/dennypenta/vel/veluuid"
+//
+// The decoding reports a malformed UUID of a request as the violation of the format rule of its field,
+// the spec describes it as a string of the uuid format, the TS client as a string and the Go client keeps uuid.UUID.
+package veluuid
+
+import (
+	"reflect"
+
+	"github.com/dennypenta/vel"
+	"github.com/google/uuid"
+)
+
+func init() {
+	vel.RegisterUUIDType(reflect.TypeFor[uuid.UUID]())
+}
@@ -0,0 +1,117 @@
+package vel
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	// AcceptVersionHeader asks for the version of the operation, APIVersionHeader is accepted as well
+	AcceptVersionHeader = "Accept-Version"
+	// APIVersionHeader is the version the response is served by, the requests may send it instead of AcceptVersionHeader
+	APIVersionHeader = "X-API-Version"
+
+	UnsupportedVersionCode = "UNSUPPORTED_VERSION"
+)
+
+// WithVersion is a per-route middleware registering the route as a version of its operation,
+// so the same operation id may be registered for several versions:
+//
+//	vel.RegisterPost(router, "createUser", CreateUserV1, vel.WithVersion("2024-01-01"))
+//	vel.RegisterPost(router, "createUser", CreateUser, vel.WithVersion("2024-06-01"))
+//
+// A request is served by the version it asks for in AcceptVersionHeader or APIVersionHeader,
+// a version in between is served by the latest version before it, no version by the latest one.
+// The versions compare as strings, e.g. the dates in the YYYY-MM-DD format.
+// A request asking for a version before all of them is answered by 400 UNSUPPORTED_VERSION.
+// The response tells the version in APIVersionHeader.
+func WithVersion(version string) Middleware {
+	if version == "" {
+		panic("vel: WithVersion requires a version")
+	}
+	return func(next http.Handler) http.Handler {
+		return &versionHandler{Handler: next, version: version}
+	}
+}
+
+type versionHandler struct {
+	http.Handler
+	version string
+}
+
+// routeVersion documents the version in the meta of the route, see RegisterHandler
+func (h *versionHandler) routeVersion() string {
+	return h.version
+}
+
+// versionDocumenter is implemented by the handler of WithVersion
+type versionDocumenter interface {
+	routeVersion() string
+}
+
+// Version returns the version of the operation the route serves, empty if the route isn't versioned, see WithVersion
+func (m HandlerMeta) Version() string {
+	return m.version
+}
+
+// versionedRoute serves the pattern of a versioned operation by the version the request asks for
+type versionedRoute struct {
+	// versions are sorted
+	versions []string
+	handlers map[string]http.Handler
+}
+
+func (v *versionedRoute) add(version string, handler http.Handler) {
+	v.handlers[version] = handler
+	v.versions = append(v.versions, version)
+	slices.Sort(v.versions)
+}
+
+// resolve returns the latest version up to the requested one, the latest one if none is requested
+func (v *versionedRoute) resolve(requested string) (string, bool) {
+	if requested == "" {
+		return v.versions[len(v.versions)-1], true
+	}
+	i, found := slices.BinarySearch(v.versions, requested)
+	if found {
+		return requested, true
+	}
+	if i == 0 {
+		return "", false
+	}
+	return v.versions[i-1], true
+}
+
+func (v *versionedRoute) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	requested := cmp.Or(r.Header.Get(AcceptVersionHeader), r.Header.Get(APIVersionHeader))
+	version, ok := v.resolve(requested)
+	w.Header().Add("Vary", AcceptVersionHeader+", "+APIVersionHeader)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, &Error{
+			Code:    UnsupportedVersionCode,
+			Message: "version " + requested + " isn't supported, the versions are " + strings.Join(v.versions, ", "),
+		})
+		return
+	}
+	w.Header().Set(APIVersionHeader, version)
+	v.handlers[version].ServeHTTP(w, r)
+}
+
+// handleVersion serves the version of the pattern: the mux serves the pattern by a versionedRoute
+// dispatching the requests to the versions, a version registered again is a duplicate route
+func (r *Router) handleVersion(pattern, version string, handler http.Handler, meta *HandlerMeta, site string) bool {
+	versions, ok := r.shared.versions[pattern]
+	if !ok {
+		versions = &versionedRoute{handlers: make(map[string]http.Handler)}
+		// the dispatcher answers an unsupported version as the first version registered, every version serves its own meta
+		if !r.handle(pattern, withRoute(versions, meta, r.shared), nil, site) {
+			return false
+		}
+		r.shared.versions[pattern] = versions
+	}
+	return r.register(pattern+" "+AcceptVersionHeader+": "+version, handler, meta, site, func(reg *registration) {
+		versions.add(version, reg)
+	})
+}
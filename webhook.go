@@ -0,0 +1,27 @@
+package vel
+
+import "slices"
+
+// WebhookMeta documents a webhook the API sends to its receivers, it's published in the webhooks section
+// of the OpenAPI spec, see the velhook package
+type WebhookMeta struct {
+	// Name is the event type of the webhook
+	Name        string
+	Description string
+	// Body is a zero value of the request body the receivers get
+	Body any
+	// Headers are the request headers the receivers get
+	Headers []KeyValueSpec
+	// Security lists the schemes the receivers authenticate the requests by
+	Security []SecurityScheme
+}
+
+// RegisterWebhook documents the webhook in the meta of the router shared with its subrouters
+func (r *Router) RegisterWebhook(meta WebhookMeta) {
+	r.shared.webhooks = append(r.shared.webhooks, meta)
+}
+
+// Webhooks returns the documented webhooks in the registration order
+func (r *Router) Webhooks() []WebhookMeta {
+	return slices.Clone(r.shared.webhooks)
+}